@@ -0,0 +1,31 @@
+#!/usr/bin/env bash
+
+# Checks that the patches in patches/<package> are all still applied to the
+# vendored package, so bumping it to an upstream version without them fails
+# rather than silently dropping them. Patches are taken off a copy of the
+# vendored package, newest first; each must come off cleanly.
+
+cd "$(dirname "$0")"
+
+declare -A vendored=(
+    [lpms]=vendor/github.com/livepeer/lpms
+)
+
+failed=0
+for pkg in "${!vendored[@]}"; do
+    patches=$(ls -r patches/$pkg/*.patch 2>/dev/null)
+    if [ -z "$patches" ]; then
+        continue
+    fi
+    tmp=$(mktemp -d)
+    cp -R "${vendored[$pkg]}/." "$tmp"
+    for patch in $patches; do
+        if ! (cd "$tmp" && git apply -R --check "$OLDPWD/$patch" && git apply -R "$OLDPWD/$patch"); then
+            printf "\n%s isn't applied to %s; reapply it after bumping %s, or delete it once it's upstream\n\n" "$patch" "${vendored[$pkg]}" "$pkg"
+            failed=1
+            break
+        fi
+    done
+    rm -rf "$tmp"
+done
+exit $failed
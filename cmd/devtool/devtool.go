@@ -19,7 +19,7 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/livepeer/go-livepeer/eth"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 const (
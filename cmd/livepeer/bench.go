@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/server"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/nareix/joy4/av/avutil"
+	"github.com/nareix/joy4/format"
+	"github.com/nareix/joy4/format/rtmp"
+)
+
+// benchPollInterval is how often the playlists of the streams published to a
+// broadcaster are polled for new segments
+const benchPollInterval = 250 * time.Millisecond
+
+// benchConfig is the settings of `livepeer bench`
+type benchConfig struct {
+	sample   string
+	streams  int
+	duration time.Duration
+
+	// Against an orchestrator, the sample is a segment submitted every
+	// segmentDuration on each stream
+	orchAddr        string
+	segmentDuration time.Duration
+	profiles        string
+
+	// Against a broadcaster, the sample is published over RTMP on each stream
+	rtmpAddr       string
+	httpAddr       string
+	cliAddr        string
+	cliToken       string
+	segmentTimeout time.Duration
+
+	json bool
+}
+
+// runBench runs `livepeer bench` with args, writing its report to out, and
+// returns the exit status
+func runBench(args []string, out io.Writer) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	var cfg benchConfig
+	fs.StringVar(&cfg.sample, "sample", "", "Sample to stream: a segment submitted to the orchestrator, or a video file (FLV, MP4 or TS) published to the broadcaster")
+	fs.IntVar(&cfg.streams, "streams", 1, "Number of concurrent streams")
+	fs.DurationVar(&cfg.duration, "duration", time.Minute, "How long to stream for")
+	fs.StringVar(&cfg.orchAddr, "orchAddr", "", "Orchestrator to submit segments to directly, as a broadcaster without payments would; payments aren't measured")
+	fs.DurationVar(&cfg.segmentDuration, "segmentDuration", 2*time.Second, "With -orchAddr, how often each stream submits the sample; its duration")
+	fs.StringVar(&cfg.profiles, "transcodingOptions", "P240p30fps16x9,P360p30fps16x9", "With -orchAddr, the profiles segments are transcoded into")
+	fs.StringVar(&cfg.rtmpAddr, "rtmpAddr", "", "RTMP address of the broadcaster to publish streams to")
+	fs.StringVar(&cfg.httpAddr, "httpAddr", "127.0.0.1:"+RpcPort, "With -rtmpAddr, HTTP address the broadcaster serves playlists at")
+	fs.StringVar(&cfg.cliAddr, "cliAddr", "", "With -rtmpAddr, CLI address of the broadcaster to report the tickets sent for the streams from")
+	fs.StringVar(&cfg.cliToken, "cliToken", "", "Token for the CLI server at -cliAddr")
+	fs.DurationVar(&cfg.segmentTimeout, "segmentTimeout", 30*time.Second, "With -rtmpAddr, how long after the source a segment's renditions may appear before it's counted as failed")
+	fs.BoolVar(&cfg.json, "json", false, "Write the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := cfg.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid bench settings:", err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	// Report what was measured so far when interrupted
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stats := newBenchStats()
+	run := string(core.RandomManifestID())
+	start := time.Now()
+	var payments *benchPayments
+	var err error
+	if cfg.orchAddr != "" {
+		payments, err = benchOrchestrator(ctx, cfg, run, stats)
+	} else {
+		payments, err = benchBroadcaster(ctx, cfg, run, stats)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error running bench:", err)
+		return 1
+	}
+
+	report := stats.report(cfg, time.Since(start), payments)
+	if cfg.json {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return 1
+		}
+	} else {
+		report.print(out)
+	}
+	return 0
+}
+
+func (cfg benchConfig) validate() error {
+	switch {
+	case cfg.sample == "":
+		return errors.New("-sample is required")
+	case (cfg.orchAddr == "") == (cfg.rtmpAddr == ""):
+		return errors.New("exactly one of -orchAddr or -rtmpAddr is required")
+	case cfg.streams <= 0:
+		return fmt.Errorf("-streams must be at least 1, got %d", cfg.streams)
+	case cfg.duration <= 0:
+		return fmt.Errorf("-duration must be positive, got %v", cfg.duration)
+	case cfg.orchAddr != "" && cfg.segmentDuration <= 0:
+		return fmt.Errorf("-segmentDuration must be positive, got %v", cfg.segmentDuration)
+	}
+	if _, err := os.Stat(cfg.sample); err != nil {
+		return fmt.Errorf("-sample: %v", err)
+	}
+	return nil
+}
+
+// benchStats is what's measured of the segments of a benchmark
+type benchStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	failures  map[string]int
+}
+
+func newBenchStats() *benchStats {
+	return &benchStats{failures: make(map[string]int)}
+}
+
+// record adds a segment that took latency to transcode, or failed with err
+func (s *benchStats) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failures[err.Error()]++
+		return
+	}
+	s.latencies = append(s.latencies, latency)
+}
+
+// benchLatency is the distribution of the latency of the segments
+// transcoded, in seconds
+type benchLatency struct {
+	Min float64 `json:"min"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+	Max float64 `json:"max"`
+}
+
+// benchPayments is the flow of tickets of a benchmark. Amounts are expected
+// values in wei.
+type benchPayments struct {
+	// Required is whether the orchestrator asked for tickets
+	Required bool `json:"required"`
+	// TicketEV is the expected value of each ticket the orchestrator asked for
+	TicketEV *big.Int `json:"ticketEV,omitempty"`
+	// Tickets sent, and their amount, by the broadcaster for the streams
+	Tickets int      `json:"tickets"`
+	Amount  *big.Int `json:"amount,omitempty"`
+	Deposit *big.Int `json:"deposit,omitempty"`
+	Reserve *big.Int `json:"reserve,omitempty"`
+	// Note on how the payments were measured
+	Note string `json:"note,omitempty"`
+}
+
+// benchReport is the result of a benchmark
+type benchReport struct {
+	Target         string         `json:"target"`
+	Streams        int            `json:"streams"`
+	Duration       float64        `json:"duration"`
+	Segments       int            `json:"segments"`
+	Transcoded     int            `json:"transcoded"`
+	SuccessRate    float64        `json:"successRate"`
+	SegmentsPerSec float64        `json:"segmentsPerSec"`
+	Latency        *benchLatency  `json:"latency,omitempty"`
+	Failures       map[string]int `json:"failures,omitempty"`
+	Payments       *benchPayments `json:"payments,omitempty"`
+}
+
+func (s *benchStats) report(cfg benchConfig, elapsed time.Duration, payments *benchPayments) *benchReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &benchReport{
+		Target:     cfg.orchAddr,
+		Streams:    cfg.streams,
+		Duration:   elapsed.Seconds(),
+		Transcoded: len(s.latencies),
+		Failures:   s.failures,
+		Payments:   payments,
+	}
+	if r.Target == "" {
+		r.Target = cfg.rtmpAddr
+	}
+	r.Segments = r.Transcoded
+	for _, n := range s.failures {
+		r.Segments += n
+	}
+	if r.Segments > 0 {
+		r.SuccessRate = float64(r.Transcoded) / float64(r.Segments)
+	}
+	if elapsed > 0 {
+		r.SegmentsPerSec = float64(r.Transcoded) / elapsed.Seconds()
+	}
+	if len(s.latencies) > 0 {
+		sorted := append([]time.Duration(nil), s.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		r.Latency = &benchLatency{
+			Min: sorted[0].Seconds(),
+			P50: percentile(sorted, 0.5).Seconds(),
+			P90: percentile(sorted, 0.9).Seconds(),
+			P99: percentile(sorted, 0.99).Seconds(),
+			Max: sorted[len(sorted)-1].Seconds(),
+		}
+	}
+	return r
+}
+
+// percentile returns the p-th percentile, nearest rank, of sorted
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (r *benchReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Target:       %s\n", r.Target)
+	fmt.Fprintf(w, "Streams:      %d\n", r.Streams)
+	fmt.Fprintf(w, "Duration:     %.1fs\n", r.Duration)
+	fmt.Fprintf(w, "Segments:     %d, %d transcoded (%.2f%%), %.2f/s\n", r.Segments, r.Transcoded, r.SuccessRate*100, r.SegmentsPerSec)
+	if l := r.Latency; l != nil {
+		fmt.Fprintf(w, "Latency:      min %.3fs  p50 %.3fs  p90 %.3fs  p99 %.3fs  max %.3fs\n", l.Min, l.P50, l.P90, l.P99, l.Max)
+	}
+	if len(r.Failures) > 0 {
+		errs := make([]string, 0, len(r.Failures))
+		for err := range r.Failures {
+			errs = append(errs, err)
+		}
+		sort.Strings(errs)
+		fmt.Fprintln(w, "Failures:")
+		for _, err := range errs {
+			fmt.Fprintf(w, "  %6d  %s\n", r.Failures[err], err)
+		}
+	}
+	if p := r.Payments; p != nil {
+		switch {
+		case p.Amount != nil:
+			fmt.Fprintf(w, "Payments:     %d tickets, %v wei expected value", p.Tickets, p.Amount)
+			if p.Deposit != nil {
+				fmt.Fprintf(w, "; deposit %v wei, reserve %v wei", p.Deposit, p.Reserve)
+			}
+			fmt.Fprintln(w)
+		case p.Required:
+			fmt.Fprintf(w, "Payments:     required, %v wei expected value a ticket\n", p.TicketEV)
+		default:
+			fmt.Fprintln(w, "Payments:     none")
+		}
+		if p.Note != "" {
+			fmt.Fprintf(w, "              %s\n", p.Note)
+		}
+	}
+}
+
+// benchOrchestrator submits the sample segment to the orchestrator on each
+// stream, every segment duration, as a broadcaster without payments would
+func benchOrchestrator(ctx context.Context, cfg benchConfig, run string, stats *benchStats) (*benchPayments, error) {
+	data, err := ioutil.ReadFile(cfg.sample)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := url.Parse("https://" + defaultAddr(cfg.orchAddr, "127.0.0.1", RpcPort))
+	if err != nil {
+		return nil, err
+	}
+	var profiles []ffmpeg.VideoProfile
+	for _, name := range strings.Split(cfg.profiles, ",") {
+		if p, ok := ffmpeg.VideoProfileLookup[strings.TrimSpace(name)]; ok {
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no valid profiles in -transcodingOptions %s", cfg.profiles)
+	}
+	workDir, err := ioutil.TempDir("", "livepeer-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+	n, err := core.NewLivepeerNode(nil, workDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	bcast := core.NewBroadcaster(n)
+
+	payments := &benchPayments{
+		Note: "segments are submitted without tickets; measure payments with -rtmpAddr against an on-chain broadcaster",
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var setupErr error
+	for i := 0; i < cfg.streams; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			info, err := server.GetOrchestratorInfo(ctx, bcast, uri)
+			if err != nil {
+				mu.Lock()
+				setupErr = err
+				mu.Unlock()
+				return
+			}
+			if tp := info.TicketParams; tp != nil {
+				mu.Lock()
+				payments.Required = true
+				payments.TicketEV = ticketEV(tp.FaceValue, tp.WinProb)
+				mu.Unlock()
+			}
+			mid := core.ManifestID(fmt.Sprintf("bench%s%d", run, i))
+			sess := &server.BroadcastSession{
+				Broadcaster:      bcast,
+				ManifestID:       mid,
+				Profiles:         profiles,
+				OrchestratorInfo: info,
+				BroadcasterOS:    drivers.NewMemoryDriver(nil).NewSession(string(mid)),
+			}
+			if len(info.Storage) > 0 {
+				sess.OrchestratorOS = drivers.NewSession(info.Storage[0])
+			}
+			benchStream(ctx, cfg, sess, data, stats)
+		}(i)
+	}
+	wg.Wait()
+	if stats.empty() && setupErr != nil {
+		return nil, setupErr
+	}
+	return payments, nil
+}
+
+// benchStream submits a segment on sess every segment duration until ctx is
+// done, and waits for those in flight
+func benchStream(ctx context.Context, cfg benchConfig, sess *server.BroadcastSession, data []byte, stats *benchStats) {
+	ticker := time.NewTicker(cfg.segmentDuration)
+	defer ticker.Stop()
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	nonce := uint64(time.Now().UnixNano())
+	for seq := uint64(0); ; seq++ {
+		seg := &stream.HLSSegment{SeqNo: seq, Data: data, Duration: cfg.segmentDuration.Seconds(), Name: fmt.Sprintf("%d.ts", seq)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			// Segments in flight are given the time a broadcaster would give them
+			sctx := common.WithRequestID(context.Background(), common.NewRequestID())
+			_, err := server.SubmitSegment(sctx, sess, seg, nonce)
+			stats.record(time.Since(start), err)
+		}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *benchStats) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.latencies) == 0 && len(s.failures) == 0
+}
+
+// ticketEV is the expected value in wei of a ticket of faceValue and winProb
+func ticketEV(faceValue, winProb []byte) *big.Int {
+	ev := new(big.Int).Mul(new(big.Int).SetBytes(faceValue), new(big.Int).SetBytes(winProb))
+	return ev.Rsh(ev, 256)
+}
+
+// benchBroadcaster publishes the sample to the broadcaster over RTMP on each
+// stream, and measures how long after the source of each segment is in the
+// playlist its renditions are
+func benchBroadcaster(ctx context.Context, cfg benchConfig, run string, stats *benchStats) (*benchPayments, error) {
+	format.RegisterAll()
+	rtmpAddr := defaultAddr(cfg.rtmpAddr, "127.0.0.1", RtmpPort)
+	httpAddr := defaultAddr(cfg.httpAddr, "127.0.0.1", RpcPort)
+
+	start := time.Now()
+	mids := make([]string, cfg.streams)
+	errs := make(chan error, cfg.streams)
+	var wg sync.WaitGroup
+	for i := range mids {
+		mids[i] = fmt.Sprintf("bench%s%d", run, i)
+		wg.Add(2)
+		go func(mid string) {
+			defer wg.Done()
+			if err := publishSample(ctx, cfg.sample, "rtmp://"+rtmpAddr+"/stream/"+mid); err != nil {
+				errs <- err
+			}
+		}(mids[i])
+		go func(mid string) {
+			defer wg.Done()
+			pollPlaylists(ctx, cfg, "http://"+httpAddr+"/stream/"+mid+".m3u8", stats)
+		}(mids[i])
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil && stats.empty() {
+		return nil, err
+	}
+
+	if cfg.cliAddr == "" {
+		return nil, nil
+	}
+	return broadcasterSpend(defaultAddr(cfg.cliAddr, "127.0.0.1", CliPort), cfg.cliToken, time.Since(start), mids)
+}
+
+// publishSample publishes the sample to rtmpURL in a loop, in real time,
+// until ctx is done
+func publishSample(ctx context.Context, sample, rtmpURL string) error {
+	conn, err := rtmp.Dial(rtmpURL)
+	if err != nil {
+		return fmt.Errorf("could not publish to %s: %v", rtmpURL, err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	var offset, last time.Duration
+	for header := true; ctx.Err() == nil; header = false {
+		file, err := avutil.Open(sample)
+		if err != nil {
+			return err
+		}
+		if header {
+			streams, err := file.Streams()
+			if err != nil {
+				file.Close()
+				return err
+			}
+			if err := conn.WriteHeader(streams); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		for ctx.Err() == nil {
+			pkt, err := file.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				return err
+			}
+			// Each pass over the sample follows on from the last
+			pkt.Time += offset
+			if wait := pkt.Time - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+			if err := conn.WritePacket(pkt); err != nil {
+				file.Close()
+				return err
+			}
+			last = pkt.Time
+		}
+		file.Close()
+		offset = last + time.Millisecond
+	}
+	return conn.WriteTrailer()
+}
+
+// pollPlaylists polls the master playlist of a stream and the media
+// playlists it lists, recording the latency of each segment from when it's
+// in the source playlist until it's in those of every rendition
+func pollPlaylists(ctx context.Context, cfg benchConfig, masterURL string, stats *benchStats) {
+	base, _ := url.Parse(masterURL)
+	// When each segment of each rendition was first seen
+	seen := make(map[string]map[string]time.Time)
+	done := make(map[string]bool)
+	ticker := time.NewTicker(benchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Segments still being transcoded aren't counted
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		variants, err := playlistURIs(masterURL)
+		if err != nil {
+			continue
+		}
+		var renditions []string
+		for _, v := range variants {
+			rendition := strings.TrimSuffix(path.Base(v), ".m3u8")
+			ref, err := url.Parse(v)
+			if err != nil {
+				continue
+			}
+			segs, err := playlistURIs(base.ResolveReference(ref).String())
+			if err != nil {
+				continue
+			}
+			if rendition != "source" {
+				renditions = append(renditions, rendition)
+			}
+			for _, s := range segs {
+				name := path.Base(s)
+				if seen[name] == nil {
+					seen[name] = make(map[string]time.Time)
+				}
+				if _, ok := seen[name][rendition]; !ok {
+					seen[name][rendition] = now
+				}
+			}
+		}
+
+		for name, at := range seen {
+			source, ok := at["source"]
+			if done[name] || !ok {
+				continue
+			}
+			var latest time.Time
+			complete := len(renditions) > 0
+			for _, r := range renditions {
+				t, ok := at[r]
+				if !ok {
+					complete = false
+					break
+				}
+				if t.After(latest) {
+					latest = t
+				}
+			}
+			if complete {
+				stats.record(latest.Sub(source), nil)
+				done[name] = true
+			} else if now.Sub(source) > cfg.segmentTimeout {
+				stats.record(0, errors.New("renditions not in the playlist in time"))
+				done[name] = true
+			}
+		}
+	}
+}
+
+// playlistURIs returns the URIs listed in the playlist at u: the media
+// playlists of a master playlist, or the segments of a media playlist
+func playlistURIs(u string) ([]string, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	var uris []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	return uris, scanner.Err()
+}
+
+// broadcasterSpend returns the tickets the broadcaster at cliAddr sent for
+// the streams mids within the last window
+func broadcasterSpend(cliAddr, token string, window time.Duration, mids []string) (*benchPayments, error) {
+	seconds := int(math.Ceil(window.Seconds()))
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s/spend?window=%ds", cliAddr, seconds), nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not query spend: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not query spend: %s", resp.Status)
+	}
+	type total struct {
+		Tickets int
+		Amount  *big.Int
+	}
+	var spend struct {
+		Deposit  *big.Int
+		Reserve  *big.Int
+		ByStream map[string]*total
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spend); err != nil {
+		return nil, fmt.Errorf("could not parse spend: %v", err)
+	}
+
+	payments := &benchPayments{Amount: big.NewInt(0), Deposit: spend.Deposit, Reserve: spend.Reserve}
+	for _, mid := range mids {
+		if t := spend.ByStream[mid]; t != nil && t.Amount != nil {
+			payments.Tickets += t.Tickets
+			payments.Amount.Add(payments.Amount, t.Amount)
+		}
+	}
+	payments.Required = payments.Tickets > 0
+	if spend.Deposit == nil {
+		payments.Note = "the broadcaster is off-chain"
+	}
+	return payments, nil
+}
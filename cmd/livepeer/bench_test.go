@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	assert := assert.New(t)
+	var sorted []time.Duration
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Second)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Second},
+		{0.1, 1 * time.Second},
+		{0.5, 5 * time.Second},
+		{0.51, 6 * time.Second},
+		{0.9, 9 * time.Second},
+		{0.99, 10 * time.Second},
+		{1, 10 * time.Second},
+	} {
+		assert.Equal(tt.want, percentile(sorted, tt.p), "p=%v", tt.p)
+	}
+	assert.Equal(time.Second, percentile([]time.Duration{time.Second}, 0.99))
+}
+
+func TestBenchStats_Report(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	stats := newBenchStats()
+	assert.True(stats.empty())
+	r := stats.report(benchConfig{rtmpAddr: "127.0.0.1:1935", streams: 2}, 10*time.Second, nil)
+	assert.Equal("127.0.0.1:1935", r.Target)
+	assert.Equal(0, r.Segments)
+	assert.Equal(0.0, r.SuccessRate)
+	assert.Nil(r.Latency)
+
+	for _, latency := range []time.Duration{4 * time.Second, time.Second, 3 * time.Second, 2 * time.Second} {
+		stats.record(latency, nil)
+	}
+	stats.record(0, errors.New("timeout"))
+	stats.record(0, errors.New("timeout"))
+	stats.record(0, errors.New("no orchestrators"))
+	assert.False(stats.empty())
+
+	payments := &benchPayments{Required: true}
+	r = stats.report(benchConfig{orchAddr: "127.0.0.1:8935", rtmpAddr: "127.0.0.1:1935", streams: 2}, 2*time.Second, payments)
+	assert.Equal("127.0.0.1:8935", r.Target)
+	assert.Equal(2, r.Streams)
+	assert.Equal(2.0, r.Duration)
+	assert.Equal(7, r.Segments)
+	assert.Equal(4, r.Transcoded)
+	assert.InDelta(4.0/7, r.SuccessRate, 1e-9)
+	assert.Equal(2.0, r.SegmentsPerSec)
+	assert.Equal(map[string]int{"timeout": 2, "no orchestrators": 1}, r.Failures)
+	assert.Equal(payments, r.Payments)
+	require.NotNil(r.Latency)
+	assert.Equal(&benchLatency{Min: 1, P50: 2, P90: 4, P99: 4, Max: 4}, r.Latency)
+}
+
+func TestTicketEV(t *testing.T) {
+	assert := assert.New(t)
+	faceValue := big.NewInt(1000000000000000000)
+	half := new(big.Int).Lsh(big.NewInt(1), 255)
+	assert.Equal("500000000000000000", ticketEV(faceValue.Bytes(), half.Bytes()).String())
+
+	// Tickets that always win are worth about their face value
+	always := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	assert.Equal("999999999999999999", ticketEV(faceValue.Bytes(), always.Bytes()).String())
+
+	assert.Equal(0, ticketEV(nil, half.Bytes()).Sign())
+	assert.Equal(0, ticketEV(faceValue.Bytes(), nil).Sign())
+}
+
+func TestPlaylistURIs(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream/mid.m3u8" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-VERSION:3\n\n#EXT-X-STREAM-INF:BANDWIDTH=4000000\nmid/source.m3u8\n  \n#EXT-X-STREAM-INF:BANDWIDTH=400000\nmid/P144p30fps16x9.m3u8\n")
+	}))
+	defer ts.Close()
+
+	uris, err := playlistURIs(ts.URL + "/stream/mid.m3u8")
+	assert.Nil(err)
+	assert.Equal([]string{"mid/source.m3u8", "mid/P144p30fps16x9.m3u8"}, uris)
+
+	uris, err = playlistURIs(ts.URL + "/stream/missing.m3u8")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "404")
+	assert.Nil(uris)
+}
+
+func TestPollPlaylists(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The rendition of segment 0 shows up a poll after its source, and that
+	// of segment 1 never does
+	var mu sync.Mutex
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream/mid.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		polls++
+		mu.Unlock()
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=4000000\nmid/source.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=400000\nmid/P144p30fps16x9.m3u8\n")
+	})
+	mux.HandleFunc("/stream/mid/source.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXTINF:2.000,\n0.ts\n#EXTINF:2.000,\n1.ts\n")
+	})
+	mux.HandleFunc("/stream/mid/P144p30fps16x9.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if polls < 2 {
+			fmt.Fprint(w, "#EXTM3U\n")
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXTINF:2.000,\n0.ts\n")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	stats := newBenchStats()
+	ctx, cancel := context.WithTimeout(context.Background(), 6*benchPollInterval)
+	defer cancel()
+	pollPlaylists(ctx, benchConfig{segmentTimeout: 2 * benchPollInterval}, ts.URL+"/stream/mid.m3u8", stats)
+
+	r := stats.report(benchConfig{}, time.Second, nil)
+	assert.Equal(2, r.Segments)
+	assert.Equal(1, r.Transcoded)
+	assert.Equal(map[string]int{"renditions not in the playlist in time": 1}, r.Failures)
+	require.NotNil(r.Latency)
+	// Seen on the next poll
+	assert.InDelta(benchPollInterval.Seconds(), r.Latency.Max, benchPollInterval.Seconds()/2)
+}
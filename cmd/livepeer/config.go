@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is prepended to the environment variables flags can be set from
+const envPrefix = "LP_"
+
+// loadEnv sets the flags of fs that weren't set on the command line from
+// environment variables, named after the flag by envName. Called before
+// loadConfig, so they take precedence over the config file.
+func loadEnv(fs *flag.FlagSet) error {
+	set := setFlags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || err != nil {
+			return
+		}
+		name := envName(f.Name)
+		if val, ok := os.LookupEnv(name); ok {
+			if serr := fs.Set(f.Name, val); serr != nil {
+				err = fmt.Errorf("invalid %s: %v", name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// fileEnvSuffix is appended to the environment variable of a flag to set it
+// from the contents of a file instead, e.g. LP_ETH_PASSWORD_FILE
+const fileEnvSuffix = "_FILE"
+
+// loadEnvFiles sets the flags of fs that weren't set on the command line or
+// by loadEnv from the files named by their environment variables with
+// fileEnvSuffix, as mounted by Docker or Kubernetes secrets, so secrets needn't
+// be on the command line or in the environment. Trailing newlines are
+// trimmed.
+func loadEnvFiles(fs *flag.FlagSet) error {
+	set := setFlags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		name := envName(f.Name) + fileEnvSuffix
+		path, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if set[f.Name] {
+			err = fmt.Errorf("%s is set along with -%s or %s", name, f.Name, envName(f.Name))
+			return
+		}
+		data, rerr := ioutil.ReadFile(path)
+		if rerr != nil {
+			err = fmt.Errorf("invalid %s: %v", name, rerr)
+			return
+		}
+		if serr := fs.Set(f.Name, strings.TrimRight(string(data), "\r\n")); serr != nil {
+			err = fmt.Errorf("invalid %s: %v", name, serr)
+		}
+	})
+	return err
+}
+
+// envName is the environment variable for a flag: the flag name in upper
+// case, with its words separated by underscores, e.g. LP_ETH_URL for -ethUrl
+func envName(flagName string) string {
+	runes := []rune(flagName)
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// loadConfig sets the flags of fs that weren't already set, on the command line
+// or by loadEnv, from the config file at path; see doc/config.md. The file is
+// TOML if its extension is .toml, YAML otherwise.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	settings := make(map[string]interface{})
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		_, err = toml.Decode(string(data), &settings)
+	case ".yaml", ".yml", "":
+		err = yaml.Unmarshal(data, &settings)
+	default:
+		return fmt.Errorf("unsupported config file type %s; must be .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	// Flags on the command line take precedence
+	set := setFlags(fs)
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown setting %s", name)
+		}
+		if set[name] {
+			continue
+		}
+		val, err := configValue(settings[name])
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+		if err := fs.Set(name, val); err != nil {
+			return fmt.Errorf("invalid %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// configValue formats a setting as it would be passed on the command line.
+// Lists are joined with commas, as taken by flags like -orchAddr.
+func configValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		vals := make([]string, len(v))
+		for i, item := range v {
+			val, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			vals[i] = val
+		}
+		return strings.Join(vals, ","), nil
+	default:
+		return "", fmt.Errorf("must be a string, number, boolean or list, got %T", v)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEnvFiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "envfiles")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		require.Nil(ioutil.WriteFile(path, []byte(data), 0600))
+		return path
+	}
+	newFlags := func() (*flag.FlagSet, *string, *string) {
+		fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+		return fs, fs.String("ethPassword", "", ""), fs.String("orchSecret", "", "")
+	}
+
+	// Set from the files, without their trailing newlines
+	require.Nil(os.Setenv("LP_ETH_PASSWORD_FILE", write("password", "hunter2\r\n")))
+	defer os.Unsetenv("LP_ETH_PASSWORD_FILE")
+	fs, password, secret := newFlags()
+	require.Nil(fs.Parse(nil))
+	require.Nil(loadEnvFiles(fs))
+	assert.Equal("hunter2", *password)
+	assert.Equal("", *secret)
+
+	// Flags set otherwise conflict with their files
+	fs, _, _ = newFlags()
+	require.Nil(fs.Parse([]string{"-ethPassword", "other"}))
+	err = loadEnvFiles(fs)
+	require.NotNil(err)
+	assert.Equal("LP_ETH_PASSWORD_FILE is set along with -ethPassword or LP_ETH_PASSWORD", err.Error())
+
+	require.Nil(os.Setenv("LP_ETH_PASSWORD", "other"))
+	fs, _, _ = newFlags()
+	require.Nil(fs.Parse(nil))
+	require.Nil(loadEnv(fs))
+	assert.NotNil(loadEnvFiles(fs))
+	require.Nil(os.Unsetenv("LP_ETH_PASSWORD"))
+
+	// Missing files are errors
+	require.Nil(os.Setenv("LP_ORCH_SECRET_FILE", filepath.Join(dir, "missing")))
+	defer os.Unsetenv("LP_ORCH_SECRET_FILE")
+	fs, _, _ = newFlags()
+	require.Nil(fs.Parse(nil))
+	err = loadEnvFiles(fs)
+	require.NotNil(err)
+	assert.Contains(err.Error(), "invalid LP_ORCH_SECRET_FILE")
+}
+
+func TestRedact(t *testing.T) {
+	for _, tt := range []struct{ name, value, redacted string }{
+		{"orchSecret", "secret", "REDACTED"},
+		{"ethPassword", "", ""},
+		{"dbKeyCommand", "vault read -field=key secret/livepeer", "REDACTED"},
+		{"ethUrl", "https://mainnet.infura.io/v3/0123456789abcdef0123456789abcdef", "https://mainnet.infura.io/v3/REDACTED"},
+		{"orchAddr", "127.0.0.1:8935", "127.0.0.1:8935"},
+	} {
+		assert.Equal(t, tt.redacted, redact(tt.name, tt.value), tt.name)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for _, tt := range []struct{ flag, env string }{
+		{"orchestrator", "LP_ORCHESTRATOR"},
+		{"ethUrl", "LP_ETH_URL"},
+		{"s3Bucket", "LP_S3_BUCKET"},
+		{"s3bucket", "LP_S3BUCKET"},
+		{"alertMaxGPUUtilization", "LP_ALERT_MAX_GPU_UTILIZATION"},
+		{"httpIngest", "LP_HTTP_INGEST"},
+	} {
+		assert.Equal(t, tt.env, envName(tt.flag))
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, tt := range []struct {
+		name, file, config string
+		args               []string
+		err                string
+		orchAddr           string
+		winProb            string
+		transcoder         string
+	}{
+		{
+			name:       "yaml",
+			file:       "livepeer.yaml",
+			config:     "orchAddr:\n  - 127.0.0.1:8935\n  - 127.0.0.1:8936\nwinProb: 0.5\ntranscoder: true\n",
+			orchAddr:   "127.0.0.1:8935,127.0.0.1:8936",
+			winProb:    "0.5",
+			transcoder: "true",
+		},
+		{
+			name:       "toml",
+			file:       "livepeer.toml",
+			config:     "orchAddr = [\"127.0.0.1:8935\"]\nwinProb = 2\n",
+			orchAddr:   "127.0.0.1:8935",
+			winProb:    "2",
+			transcoder: "false",
+		},
+		{
+			name:       "flags take precedence",
+			file:       "flags.yaml",
+			config:     "winProb: 0.5\ntranscoder: true\n",
+			args:       []string{"-winProb", "1"},
+			winProb:    "1",
+			transcoder: "true",
+		},
+		{
+			name:       "old names",
+			file:       "old.yaml",
+			config:     "winprob: 0.5\n",
+			winProb:    "0.5",
+			transcoder: "false",
+		},
+		{name: "unknown setting", file: "unknown.yaml", config: "nope: 1\n", err: "unknown setting nope"},
+		{name: "config in config", file: "config.yaml", config: "config: other.yaml\n", err: "unknown setting config"},
+		{name: "invalid value", file: "invalid.yaml", config: "winProb: abc\n", err: "invalid winProb"},
+		{name: "nested value", file: "nested.yaml", config: "winProb:\n  a: 1\n", err: "invalid winProb"},
+		{name: "unsupported file", file: "livepeer.json", config: "{}", err: "unsupported config file type .json"},
+		{name: "invalid file", file: "broken.toml", config: "winProb = \n", err: "error parsing"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+			orchAddr, winProb, transcoder := fs.String("orchAddr", "", ""), fs.Float64("winProb", 0, ""), fs.Bool("transcoder", false, "")
+			fs.String("config", "", "")
+			registerDeprecatedFlags(fs, []*deprecatedFlag{{name: "winprob", replacement: "winProb", removedIn: "0.6.0"}})
+			require.Nil(t, fs.Parse(tt.args))
+			path := filepath.Join(dir, tt.file)
+			require.Nil(t, ioutil.WriteFile(path, []byte(tt.config), 0600))
+
+			err := loadConfig(fs, path)
+			if tt.err != "" {
+				require.NotNil(t, err)
+				assert.Contains(err.Error(), tt.err)
+				return
+			}
+			require.Nil(t, err)
+			assert.Equal(tt.orchAddr, *orchAddr)
+			assert.Equal(tt.winProb, strconv.FormatFloat(*winProb, 'f', -1, 64))
+			assert.Equal(tt.transcoder, strconv.FormatBool(*transcoder))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/eth"
+)
+
+// setupDBEncryption encrypts the ticket secrets in the DB with the key
+// printed by keyCommand, or else the one derived from the account of client
+// if fromAccount is set. Nodes can't start on encrypted DBs without a key.
+func setupDBEncryption(db *common.DB, client eth.LivepeerEthClient, fromAccount bool, keyCommand string) error {
+	if !fromAccount && keyCommand == "" {
+		encrypted, err := db.Encrypted()
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			return errors.New("the DB is encrypted; start the node with -dbEncrypt or -dbKeyCommand")
+		}
+		return nil
+	}
+
+	var key []byte
+	if keyCommand != "" {
+		var err error
+		if key, err = common.DBKeyFromCommand(keyCommand); err != nil {
+			return fmt.Errorf("-dbKeyCommand: %v", err)
+		}
+	} else {
+		if client == nil {
+			return errors.New("-dbEncrypt needs the node's Ethereum account")
+		}
+		sig, err := client.Sign(common.DBKeyMessage)
+		if err != nil {
+			return fmt.Errorf("error deriving DB key: %v", err)
+		}
+		key = common.DBKeyFromSignature(sig)
+	}
+	return db.SetEncryptionKey(key)
+}
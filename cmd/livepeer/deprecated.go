@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// deprecatedFlag is the old name of a renamed flag. Until the release it's
+// removed in, setting it on the command line, in the environment or in a
+// config file sets the flag it was renamed to, with a warning; from then on
+// the node refuses to start with it.
+type deprecatedFlag struct {
+	name        string
+	replacement string
+	// removedIn is the release the old name stops working in, e.g. 0.6.0
+	removedIn string
+}
+
+// deprecatedFlags are the old names of renamed flags. Rename a flag by
+// adding its old name here rather than removing it.
+var deprecatedFlags = []*deprecatedFlag{
+	// Storage flags, renamed to the camel case of the others
+	{name: "s3bucket", replacement: "s3Bucket", removedIn: "0.6.0"},
+	{name: "s3creds", replacement: "s3Credentials", removedIn: "0.6.0"},
+	{name: "gsbucket", replacement: "gsBucket", removedIn: "0.6.0"},
+	{name: "gskey", replacement: "gsKey", removedIn: "0.6.0"},
+}
+
+// aliasValue is the value of a deprecated flag, which is that of its
+// replacement
+type aliasValue struct {
+	fs  *flag.FlagSet
+	old *deprecatedFlag
+}
+
+func (v *aliasValue) String() string {
+	// The flag package calls String on zero values for the usage
+	if v.fs == nil {
+		return ""
+	}
+	return v.fs.Lookup(v.old.replacement).Value.String()
+}
+
+func (v *aliasValue) Set(s string) error {
+	return v.fs.Set(v.old.replacement, s)
+}
+
+// IsBoolFlag lets deprecated bool flags be set without a value too
+func (v *aliasValue) IsBoolFlag() bool {
+	if v.fs == nil {
+		return false
+	}
+	b, ok := v.fs.Lookup(v.old.replacement).Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// registerDeprecatedFlags adds the old names of renamed flags to fs, once
+// all other flags have been defined
+func registerDeprecatedFlags(fs *flag.FlagSet, deprecated []*deprecatedFlag) {
+	for _, d := range deprecated {
+		if fs.Lookup(d.replacement) == nil {
+			panic(fmt.Sprintf("replacement -%s of deprecated flag -%s not defined", d.replacement, d.name))
+		}
+		fs.Var(&aliasValue{fs: fs, old: d}, d.name, fmt.Sprintf("Deprecated: use -%s; removed in %s", d.replacement, d.removedIn))
+	}
+}
+
+// deprecatedFlagOf returns the deprecation of a flag; nil if it isn't
+func deprecatedFlagOf(f *flag.Flag) *deprecatedFlag {
+	if v, ok := f.Value.(*aliasValue); ok {
+		return v.old
+	}
+	return nil
+}
+
+// setFlags are the flags of fs that have been set, along with the old names
+// of those, so a setting under either name doesn't override the other
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	fs.VisitAll(func(f *flag.Flag) {
+		if d := deprecatedFlagOf(f); d != nil && set[d.replacement] {
+			set[f.Name] = true
+		}
+	})
+	return set
+}
+
+// checkDeprecatedFlags returns warnings for the deprecated flags of fs that
+// were set, and errors for those already removed as of version
+func checkDeprecatedFlags(fs *flag.FlagSet, version string) ([]string, []configError) {
+	var warnings []string
+	var errs []configError
+	fs.Visit(func(f *flag.Flag) {
+		d := deprecatedFlagOf(f)
+		if d == nil {
+			return
+		}
+		if !releaseBefore(version, d.removedIn) {
+			errs = append(errs, configError{Setting: f.Name, Error: fmt.Sprintf("was removed in %s; use -%s instead", d.removedIn, d.replacement)})
+			return
+		}
+		warnings = append(warnings, fmt.Sprintf("-%s is deprecated and will be removed in %s; use -%s instead", f.Name, d.removedIn, d.replacement))
+	})
+	return warnings, errs
+}
+
+// releaseBefore is whether version is older than release. Versions that
+// aren't releases, such as those of development builds, are older than all.
+func releaseBefore(version, release string) bool {
+	v, ok := parseRelease(version)
+	if !ok {
+		return true
+	}
+	r, ok := parseRelease(release)
+	if !ok {
+		return true
+	}
+	for i := range v {
+		if v[i] != r[i] {
+			return v[i] < r[i]
+		}
+	}
+	return false
+}
+
+// parseRelease parses major.minor.patch, ignoring a v prefix and any
+// -suffix, e.g. of 0.5.1-abcdef
+func parseRelease(version string) ([3]int, bool) {
+	var parts [3]int
+	version = strings.SplitN(strings.TrimPrefix(version, "v"), "-", 2)[0]
+	fields := strings.Split(version, ".")
+	if len(fields) != len(parts) {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
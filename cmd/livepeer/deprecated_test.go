@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeprecatedFlags(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string, *bool) {
+		fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+		bucket, presign := fs.String("s3Bucket", "", ""), fs.Bool("s3Presign", false, "")
+		registerDeprecatedFlags(fs, []*deprecatedFlag{
+			{name: "s3bucket", replacement: "s3Bucket", removedIn: "0.6.0"},
+			{name: "s3presign", replacement: "s3Presign", removedIn: "0.6.0"},
+		})
+		return fs, bucket, presign
+	}
+
+	for _, tt := range []struct {
+		name     string
+		args     []string
+		version  string
+		bucket   string
+		presign  bool
+		set      []string
+		warnings []string
+		errs     []configError
+	}{
+		{
+			name:    "new names",
+			args:    []string{"-s3Bucket", "eu/b"},
+			version: "0.5.0",
+			bucket:  "eu/b",
+			set:     []string{"s3Bucket", "s3bucket"},
+		},
+		{
+			name:     "old names set the new ones",
+			args:     []string{"-s3bucket", "eu/b", "-s3presign"},
+			version:  "0.5.0",
+			bucket:   "eu/b",
+			presign:  true,
+			set:      []string{"s3bucket", "s3presign"},
+			warnings: []string{"-s3bucket is deprecated and will be removed in 0.6.0; use -s3Bucket instead", "-s3presign is deprecated and will be removed in 0.6.0; use -s3Presign instead"},
+		},
+		{
+			name:     "development builds",
+			args:     []string{"-s3bucket", "eu/b"},
+			version:  "undefined",
+			bucket:   "eu/b",
+			set:      []string{"s3bucket"},
+			warnings: []string{"-s3bucket is deprecated and will be removed in 0.6.0; use -s3Bucket instead"},
+		},
+		{
+			name:    "removed",
+			args:    []string{"-s3bucket", "eu/b"},
+			version: "0.6.0-abcdef",
+			bucket:  "eu/b",
+			set:     []string{"s3bucket"},
+			errs:    []configError{{Setting: "s3bucket", Error: "was removed in 0.6.0; use -s3Bucket instead"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+			fs, bucket, presign := newFlags()
+			require.Nil(t, fs.Parse(tt.args))
+			assert.Equal(tt.bucket, *bucket)
+			assert.Equal(tt.presign, *presign)
+			set := setFlags(fs)
+			for _, name := range tt.set {
+				assert.True(set[name], name)
+			}
+			warnings, errs := checkDeprecatedFlags(fs, tt.version)
+			assert.Equal(tt.warnings, warnings)
+			assert.Equal(tt.errs, errs)
+		})
+	}
+
+	// Old names take the values of the new ones
+	fs, _, _ := newFlags()
+	require.Nil(t, fs.Parse([]string{"-s3Bucket", "eu/b"}))
+	assert.Equal(t, "eu/b", fs.Lookup("s3bucket").Value.String())
+	assert.NotNil(t, deprecatedFlagOf(fs.Lookup("s3bucket")))
+	assert.Nil(t, deprecatedFlagOf(fs.Lookup("s3Bucket")))
+}
+
+func TestDeprecatedFlags_Replacements(t *testing.T) {
+	fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+	assert.Panics(t, func() { registerDeprecatedFlags(fs, deprecatedFlags) })
+
+	for _, d := range deprecatedFlags {
+		fs.String(d.replacement, "", "")
+	}
+	assert.NotPanics(t, func() { registerDeprecatedFlags(fs, deprecatedFlags) })
+	for _, d := range deprecatedFlags {
+		_, ok := parseRelease(d.removedIn)
+		assert.True(t, ok, d.name)
+	}
+}
+
+func TestReleaseBefore(t *testing.T) {
+	for _, tt := range []struct {
+		version, release string
+		before           bool
+	}{
+		{"0.5.0", "0.6.0", true},
+		{"0.5.9", "0.6.0", true},
+		{"v0.5.1-abcdef", "0.6.0", true},
+		{"0.6.0", "0.6.0", false},
+		{"0.6.0-abcdef", "0.6.0", false},
+		{"1.0.0", "0.6.0", false},
+		{"0.10.0", "0.6.0", false},
+		{"undefined", "0.6.0", true},
+		{"0.6", "0.6.0", true},
+		{"0.5.0", "next", true},
+	} {
+		assert.Equal(t, tt.before, releaseBefore(tt.version, tt.release), "%s before %s", tt.version, tt.release)
+	}
+}
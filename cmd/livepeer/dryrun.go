@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// flagSources is where each flag was set from: flag, env or config.
+// Flags that weren't set have their defaults.
+type flagSources map[string]string
+
+// record marks the flags of fs set since the last call as coming from source
+func (s flagSources) record(fs *flag.FlagSet, source string) {
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := s[f.Name]; !ok {
+			s[f.Name] = source
+		}
+	})
+}
+
+type effectiveSetting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// plannedTopology is what the node would run with the settings
+type plannedTopology struct {
+	NodeType      string            `json:"nodeType"`
+	Network       string            `json:"network"`
+	EthURL        string            `json:"ethUrl,omitempty"`
+	EthController string            `json:"ethController,omitempty"`
+	Datadir       string            `json:"datadir"`
+	Listeners     map[string]string `json:"listeners"`
+	Orchestrators []string          `json:"orchestrators,omitempty"`
+	Transcoding   string            `json:"transcoding,omitempty"`
+	Storage       string            `json:"storage"`
+	Monitor       bool              `json:"monitor"`
+}
+
+// isSecretFlag is whether the value of a flag must not be printed
+func isSecretFlag(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"secret", "password", "token", "creds", "credentials", "webhook", "keycommand"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact hides the value of secret flags, and the credentials in URLs
+func redact(name, value string) string {
+	if value == "" {
+		return value
+	}
+	if isSecretFlag(name) {
+		return common.Redacted
+	}
+	return common.RedactURL(value)
+}
+
+// dryRun prints the settings of fs along with where they were set from, and
+// the topology of the node they make for, without starting anything
+func dryRun(w io.Writer, fs *flag.FlagSet, sources flagSources, homeDir string) error {
+	settings := make(map[string]effectiveSetting)
+	fs.VisitAll(func(f *flag.Flag) {
+		// Their values are those of their replacements
+		if deprecatedFlagOf(f) != nil {
+			return
+		}
+		source, ok := sources[f.Name]
+		if !ok {
+			source = "default"
+		}
+		settings[f.Name] = effectiveSetting{Value: redact(f.Name, f.Value.String()), Source: source}
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Settings map[string]effectiveSetting `json:"settings"`
+		Topology *plannedTopology            `json:"topology"`
+	}{settings, planTopology(fs, homeDir)})
+}
+
+// planTopology works out the node the settings of fs would start, the way
+// main does
+func planTopology(fs *flag.FlagSet, homeDir string) *plannedTopology {
+	str := func(name string) string { return fs.Lookup(name).Value.String() }
+	on := func(name string) bool { b, _ := strconv.ParseBool(str(name)); return b }
+
+	t := &plannedTopology{
+		Network:   str("network"),
+		Datadir:   str("datadir"),
+		Listeners: make(map[string]string),
+		Monitor:   on("monitor"),
+	}
+	if t.Network != "offchain" {
+		t.EthURL, t.EthController = str("ethUrl"), str("ethController")
+		if netw, _ := lookupNetwork(t.Network, str("networkConfig")); netw != nil {
+			if t.EthURL == "" {
+				t.EthURL = netw.ethUrl
+			}
+			if t.EthController == "" {
+				t.EthController = netw.ethController
+			}
+		}
+		t.EthURL = redact("ethUrl", t.EthURL)
+	}
+	if t.Datadir == "" {
+		t.Datadir = filepath.Join(homeDir, ".lpData", t.Network)
+	}
+
+	var orchs []string
+	if orchAddr := str("orchAddr"); orchAddr != "" {
+		for _, addr := range strings.Split(orchAddr, ",") {
+			parts := strings.SplitN(strings.TrimSpace(addr), "?", 2)
+			orchs = append(orchs, defaultAddr(parts[0], "127.0.0.1", RpcPort))
+		}
+	}
+
+	switch {
+	case on("orchestrator"):
+		t.NodeType = "orchestrator"
+		port := RpcPort
+		if serviceAddr := str("serviceAddr"); serviceAddr != "" {
+			if u, err := url.ParseRequestURI("https://" + serviceAddr); err == nil && u.Port() != "" {
+				port = u.Port()
+			}
+		}
+		t.Listeners["http"] = defaultAddr(str("httpAddr"), "", port)
+		if on("transcoder") {
+			t.Transcoding = localTranscoding(str("nvidia"), str("vaapi"), on("videotoolbox"))
+		} else {
+			t.Transcoding = "standalone transcoders connecting with -orchSecret"
+		}
+	case on("transcoder"):
+		t.NodeType = "transcoder"
+		t.Transcoding = localTranscoding(str("nvidia"), str("vaapi"), on("videotoolbox"))
+		if len(orchs) > 0 {
+			t.Orchestrators = orchs[:1]
+		}
+		// Standalone transcoders only connect out to their orchestrator
+		return t
+	case on("broadcaster"):
+		t.NodeType = "broadcaster"
+		t.Listeners["rtmp"] = defaultAddr(str("rtmpAddr"), "127.0.0.1", RtmpPort)
+		t.Listeners["http"] = defaultAddr(str("httpAddr"), "127.0.0.1", RpcPort)
+		switch {
+		case len(orchs) > 0:
+			t.Orchestrators = orchs
+		case t.Network != "offchain":
+			t.Orchestrators = []string{"discovered on chain"}
+		}
+	default:
+		t.NodeType = "none"
+	}
+	t.Listeners["cli"] = defaultAddr(str("cliAddr"), "127.0.0.1", CliPort)
+
+	switch {
+	case str("s3Bucket") != "":
+		t.Storage = "s3 " + str("s3Bucket")
+	case str("gsBucket") != "":
+		t.Storage = "gs " + str("gsBucket")
+	case str("ipfsApi") != "":
+		t.Storage = "ipfs via " + str("ipfsApi")
+	case str("ipfsPinningUrl") != "":
+		t.Storage = "ipfs via " + str("ipfsPinningUrl")
+	default:
+		t.Storage = "memory"
+	}
+	if str("storageEncryptionKey") != "" {
+		t.Storage += ", encrypted"
+	}
+	return t
+}
+
+func localTranscoding(nvidia, vaapi string, videotoolbox bool) string {
+	switch {
+	case nvidia != "":
+		return "local on GPUs " + nvidia
+	case vaapi != "":
+		return "local on VAAPI render nodes " + vaapi
+	case videotoolbox:
+		return "local on VideoToolbox"
+	}
+	return "local on the CPU"
+}
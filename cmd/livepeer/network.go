@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// customNetwork is the -network whose settings are read from the bundle
+// given by -networkConfig
+const customNetwork = "custom"
+
+// networkBundle is the JSON file of a custom protocol deployment, e.g.
+//
+//	{"ethController": "0x...", "chainId": 1337, "blockTime": 5, "confirmations": 2}
+type networkBundle struct {
+	EthURL        string   `json:"ethUrl"`
+	EthController string   `json:"ethController"`
+	ChainID       *big.Int `json:"chainId"`
+	// BlockTime in seconds
+	BlockTime     float64 `json:"blockTime"`
+	Confirmations uint64  `json:"confirmations"`
+}
+
+// lookupNetwork returns the settings of a preset network, or those of the
+// bundle at bundlePath for the custom network. Returns nil for networks that
+// are neither, whose settings all come from flags.
+func lookupNetwork(network, bundlePath string) (*NetworkConfig, error) {
+	if network != customNetwork {
+		return configOptions[network], nil
+	}
+	if bundlePath == "" {
+		return nil, errors.New("-network custom requires -networkConfig")
+	}
+	data, err := ioutil.ReadFile(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	var b networkBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid network bundle %s: %v", bundlePath, err)
+	}
+	if !ethcommon.IsHexAddress(b.EthController) {
+		return nil, fmt.Errorf("invalid ethController %q in network bundle %s", b.EthController, bundlePath)
+	}
+	if b.BlockTime < 0 {
+		return nil, fmt.Errorf("invalid blockTime %v in network bundle %s", b.BlockTime, bundlePath)
+	}
+	return &NetworkConfig{
+		ethUrl:        b.EthURL,
+		ethController: b.EthController,
+		chainID:       b.ChainID,
+		blockTime:     time.Duration(b.BlockTime * float64(time.Second)),
+		confirmations: b.Confirmations,
+	}, nil
+}
+
+// rpcCaller is the RPC client of the eth node
+type rpcCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// checkChainID returns an error unless the eth node is on chainID, which is
+// what transactions are signed for. The vendored ethclient predates
+// ChainID, so eth_chainId is called directly; the network ID can differ from
+// the chain ID on private networks.
+func checkChainID(ctx context.Context, rpcClient rpcCaller, chainID *big.Int) error {
+	var res hexutil.Big
+	if err := rpcClient.CallContext(ctx, &res, "eth_chainId"); err != nil {
+		return err
+	}
+	if id := (*big.Int)(&res); id.Cmp(chainID) != 0 {
+		return fmt.Errorf("eth node is on chain %v, expected %v", id, chainID)
+	}
+	return nil
+}
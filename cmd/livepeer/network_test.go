@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupNetwork(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "network")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	write := func(name, bundle string) string {
+		path := filepath.Join(dir, name)
+		require.Nil(ioutil.WriteFile(path, []byte(bundle), 0644))
+		return path
+	}
+
+	netw, err := lookupNetwork("rinkeby", "")
+	assert.Nil(err)
+	assert.Equal(configOptions["rinkeby"], netw)
+	netw, err = lookupNetwork("offchain", "")
+	assert.Nil(err)
+	assert.Nil(netw)
+
+	netw, err = lookupNetwork(customNetwork, write("ok.json", `{"ethUrl": "ws://geth:8546", "ethController": "0x1234567890123456789012345678901234567890", "chainId": 1337, "blockTime": 2.5, "confirmations": 3}`))
+	require.Nil(err)
+	assert.Equal(&NetworkConfig{
+		ethUrl:        "ws://geth:8546",
+		ethController: "0x1234567890123456789012345678901234567890",
+		chainID:       big.NewInt(1337),
+		blockTime:     2500 * time.Millisecond,
+		confirmations: 3,
+	}, netw)
+
+	for _, tt := range []struct {
+		name, path, err string
+	}{
+		{"no bundle", "", "requires -networkConfig"},
+		{"missing bundle", filepath.Join(dir, "missing.json"), "no such file"},
+		{"invalid JSON", write("json.json", `{`), "invalid network bundle"},
+		{"no controller", write("controller.json", `{"chainId": 1337}`), "invalid ethController"},
+		{"negative block time", write("blocktime.json", `{"ethController": "0x1234567890123456789012345678901234567890", "blockTime": -1}`), "invalid blockTime"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := lookupNetwork(customNetwork, tt.path)
+			require.NotNil(t, err)
+			assert.Contains(err.Error(), tt.err)
+		})
+	}
+}
+
+// stubRPC answers eth_chainId with chainID
+type stubRPC struct {
+	chainID int64
+	err     error
+	method  string
+}
+
+func (r *stubRPC) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	r.method = method
+	if r.err != nil {
+		return r.err
+	}
+	*result.(*hexutil.Big) = hexutil.Big(*big.NewInt(r.chainID))
+	return nil
+}
+
+func TestCheckChainID(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+
+	rpc := &stubRPC{chainID: 1337}
+	assert.Nil(checkChainID(ctx, rpc, big.NewInt(1337)))
+	assert.Equal("eth_chainId", rpc.method)
+	assert.EqualError(checkChainID(ctx, rpc, big.NewInt(1)), "eth node is on chain 1337, expected 1")
+	assert.EqualError(checkChainID(ctx, &stubRPC{err: errors.New("unreachable")}, big.NewInt(1)), "unreachable")
+}
+
+func TestSetupNetwork_Custom(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "setup")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "custom", "network.json")
+
+	input := strings.Join([]string{
+		"4",                  // custom
+		"http://127.0.0.1:1", // eth URL
+		"controller",         // not an address
+		"0x1234567890123456789012345678901234567890",
+		"abc", // not a chain ID
+		"1337",
+		"",  // default block time
+		"2", // confirmations
+		"n", // the eth URL can't be reached; don't try another
+		path,
+	}, "\n") + "\n"
+	s := &setup{
+		in:       bufio.NewReader(strings.NewReader(input)),
+		out:      ioutil.Discard,
+		homeDir:  dir,
+		settings: make(map[string]interface{}),
+	}
+	network, err := s.setupNetwork()
+	require.Nil(err)
+	assert.Equal(customNetwork, network)
+	assert.Equal(map[string]interface{}{"network": customNetwork, "networkConfig": path}, s.settings)
+
+	// The node starts with all that was given
+	netw, err := lookupNetwork(customNetwork, path)
+	require.Nil(err)
+	assert.Equal(&NetworkConfig{
+		ethUrl:        "http://127.0.0.1:1",
+		ethController: "0x1234567890123456789012345678901234567890",
+		chainID:       big.NewInt(1337),
+		blockTime:     15 * time.Second,
+		confirmations: 2,
+	}, netw)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/eth/eventservices"
+	"github.com/livepeer/go-livepeer/pm"
+)
+
+// onchainFlags only take effect on networks other than offchain, where none
+// of the chain components they configure are set up
+var onchainFlags = []string{
+	"ethAcctAddr", "ethPassword", "ethKeystorePath", "ethUrl", "ethController",
+	"gasLimit", "gasPrice", "initializeRound", "faceValue", "winProb",
+	"minSenderDeposit", "minSenderReserve", "senderCheckTTL", "alertMinDeposit",
+}
+
+// ignoredOnchainFlags returns the on-chain flags of fs that were set, sorted
+func ignoredOnchainFlags(fs *flag.FlagSet) []string {
+	set := setFlags(fs)
+	var ignored []string
+	for _, name := range onchainFlags {
+		if set[name] {
+			ignored = append(ignored, "-"+name)
+		}
+	}
+	sort.Strings(ignored)
+	return ignored
+}
+
+// ethConfig is the settings of the chain components of the node
+type ethConfig struct {
+	acctAddr     string
+	password     string
+	keystorePath string
+	datadir      string
+	url          string
+	controller   string
+	gasLimit     int
+	gasPrice     int
+	dbEncrypt    bool
+	dbKeyCommand string
+
+	// Orchestrators only
+	ipfsPath         string
+	initializeRound  bool
+	faceValue        float64
+	winProb          float64
+	minSenderDeposit string
+	minSenderReserve string
+	senderCheckTTL   time.Duration
+}
+
+// setupEth connects n to the chain and starts its eth services. It's only
+// called on networks other than offchain, so off-chain nodes don't construct
+// any of the client, event monitor or PM components. The returned func stops
+// the services.
+func setupEth(n *core.LivepeerNode, netw *NetworkConfig, cfg ethConfig) (func(), error) {
+	var keystoreDir string
+	if _, err := os.Stat(cfg.keystorePath); !os.IsNotExist(err) {
+		keystoreDir, _ = filepath.Split(cfg.keystorePath)
+	} else {
+		keystoreDir = filepath.Join(cfg.datadir, "keystore")
+	}
+
+	if keystoreDir == "" {
+		return nil, errors.New("cannot find keystore directory")
+	}
+
+	//Get the Eth client connection information
+	if cfg.url == "" {
+		return nil, errors.New("need to specify ethUrl")
+	}
+
+	//Set up eth client
+	rpcClient, err := rpc.Dial(cfg.url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Ethereum client: %v", err)
+	}
+	backend := ethclient.NewClient(rpcClient)
+	if netw != nil && netw.chainID != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), ethRPCCheckTimeout)
+		err := checkChainID(ctx, rpcClient, netw.chainID)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("wrong Ethereum client for the network: %v", err)
+		}
+	}
+	if netw != nil {
+		if netw.blockTime > 0 {
+			eth.BlockTime = netw.blockTime
+		}
+		eth.TxConfirmations = netw.confirmations
+	}
+
+	client, err := eth.NewClient(ethcommon.HexToAddress(cfg.acctAddr), keystoreDir, backend, ethcommon.HexToAddress(cfg.controller), EthTxTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	var bigGasPrice *big.Int
+	if cfg.gasPrice > 0 {
+		bigGasPrice = big.NewInt(int64(cfg.gasPrice))
+	}
+
+	err = client.Setup(cfg.password, uint64(cfg.gasLimit), bigGasPrice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup client: %v", err)
+	}
+
+	n.Eth = client
+	// Nodes sharing a DB keep their blocks, unbonding locks and tickets apart
+	n.Database.SetNodeAddress(client.Account().Address)
+
+	// Before any winning tickets are loaded
+	if err := setupDBEncryption(n.Database, client, cfg.dbEncrypt, cfg.dbKeyCommand); err != nil {
+		return nil, fmt.Errorf("error setting up DB encryption: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := func() {
+		cancel()
+		n.StopEthServices()
+	}
+
+	addrMap := n.Eth.ContractAddresses()
+	em := eth.NewEventMonitor(backend, addrMap)
+
+	// Setup block service to receive headers from the head of the chain
+	n.EthServices["BlockService"] = eventservices.NewBlockService(em, n.Database)
+	// Setup unbonding service to manage unbonding locks
+	n.EthServices["UnbondingService"] = eventservices.NewUnbondingService(n.Eth, n.Database)
+
+	if n.NodeType == core.OrchestratorNode {
+		if err := setupRecipient(ctx, n, em, cfg); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+
+	if n.NodeType == core.BroadcasterNode {
+		n.Sender = pm.NewSender(n.Eth)
+	}
+
+	// Start services
+	if err := n.StartEthServices(); err != nil {
+		stop()
+		return nil, fmt.Errorf("failed to start ETH services: %v", err)
+	}
+	return stop, nil
+}
+
+// setupRecipient sets up the orchestrator's eth services and the PM
+// recipient of the tickets it's paid with
+func setupRecipient(ctx context.Context, n *core.LivepeerNode, em eth.EventMonitor, cfg ethConfig) error {
+	if err := setupOrchestrator(ctx, n, em, cfg.ipfsPath, cfg.initializeRound); err != nil {
+		return fmt.Errorf("error setting up orchestrator: %v", err)
+	}
+
+	if cfg.faceValue < float64(0) {
+		return fmt.Errorf("-faceValue must be greater than 0, but %v provided. Restart the node with a different valid value for -faceValue", cfg.faceValue)
+	}
+
+	if cfg.winProb < float64(0) || cfg.winProb > float64(100) {
+		return fmt.Errorf("-winProb must be between 0 and 100, but %v provided. Restart the node with a different valid value for -winProb", cfg.winProb)
+	}
+
+	sigVerifier := &pm.DefaultSigVerifier{}
+	validator := pm.NewValidator(sigVerifier)
+	faceValueInWei := eth.ToBaseUnit(big.NewFloat(cfg.faceValue))
+	winProbBigInt := eth.FromPercOfUint256(cfg.winProb)
+	var err error
+	n.Recipient, err = pm.NewRecipient(n.Eth.Account().Address, n.Eth, validator, n.Database, faceValueInWei, winProbBigInt)
+	if err != nil {
+		return fmt.Errorf("error setting up PM recipient: %v", err)
+	}
+
+	minDeposit, minReserve := big.NewInt(0), big.NewInt(0)
+	for _, min := range []struct {
+		name   string
+		value  string
+		amount *big.Int
+	}{
+		{"minSenderDeposit", cfg.minSenderDeposit, minDeposit},
+		{"minSenderReserve", cfg.minSenderReserve, minReserve},
+	} {
+		if min.value == "" {
+			continue
+		}
+		if _, ok := min.amount.SetString(min.value, 10); !ok {
+			return fmt.Errorf("invalid -%s %s; must be an amount of wei", min.name, min.value)
+		}
+	}
+	n.SenderChecker = pm.NewSenderChecker(n.Eth, minDeposit, minReserve, cfg.senderCheckTTL)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoredOnchainFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		ignored []string
+	}{
+		{"none", nil, nil},
+		{"off-chain flags", []string{"-orchSecret", "secret", "-transcoder"}, nil},
+		{"on-chain flags", []string{"-winProb", "10", "-ethUrl", "http://geth:8545", "-orchSecret", "secret"}, []string{"-ethUrl", "-winProb"}},
+		{"by their old names", []string{"-ethpassword", "pass"}, []string{"-ethPassword"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+			for _, name := range onchainFlags {
+				fs.String(name, "", "")
+			}
+			fs.String("orchSecret", "", "")
+			fs.Bool("transcoder", false, "")
+			registerDeprecatedFlags(fs, []*deprecatedFlag{{name: "ethpassword", replacement: "ethPassword", removedIn: "0.6.0"}})
+			require.Nil(t, fs.Parse(tt.args))
+			assert.Equal(t, tt.ignored, ignoredOnchainFlags(fs))
+		})
+	}
+}
+
+// stubEthRPC answers eth_chainId with chainID, and eth_getBlockByNumber with
+// a header, over JSON-RPC
+func stubEthRPC(chainID int64) *httptest.Server {
+	header, _ := json.Marshal(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)})
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var result string
+		switch req.Method {
+		case "eth_chainId":
+			result = fmt.Sprintf(`"0x%x"`, chainID)
+		case "eth_getBlockByNumber":
+			result = string(header)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, result)
+	}))
+}
+
+func TestSetupEth_Errors(t *testing.T) {
+	ts := stubEthRPC(1)
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name string
+		netw *NetworkConfig
+		cfg  ethConfig
+		err  string
+	}{
+		{"no eth URL", nil, ethConfig{datadir: "/tmp/lp"}, "need to specify ethUrl"},
+		{"unsupported eth URL", nil, ethConfig{datadir: "/tmp/lp", url: "ftp://geth"}, "failed to connect to Ethereum client"},
+		{"eth node on another chain", &NetworkConfig{chainID: big.NewInt(1337)}, ethConfig{datadir: "/tmp/lp", url: ts.URL},
+			"wrong Ethereum client for the network: eth node is on chain 1, expected 1337"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &core.LivepeerNode{}
+			stop, err := setupEth(n, tt.netw, tt.cfg)
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.Nil(t, stop)
+			// Nothing's set up on the node
+			assert.Nil(t, n.Eth)
+			assert.Nil(t, n.Recipient)
+			assert.Nil(t, n.Sender)
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/livepeer/go-livepeer/eth"
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	"gopkg.in/yaml.v2"
+)
+
+// setup walks a new operator through configuring a node and writes the
+// result to a config file that -config can load
+type setup struct {
+	in       *bufio.Reader
+	out      io.Writer
+	homeDir  string
+	settings map[string]interface{}
+}
+
+// findGPUs lists the GPUs setup offers to transcode on
+var findGPUs = lpmon.FindGPUs
+
+func runSetup(in io.Reader, out io.Writer, homeDir string) error {
+	s := &setup{
+		in:       bufio.NewReader(in),
+		out:      out,
+		homeDir:  homeDir,
+		settings: make(map[string]interface{}),
+	}
+	fmt.Fprintln(out, "This will set up a Livepeer node and write its settings to a config file.")
+	fmt.Fprintln(out, "Press enter to accept the default shown in brackets.")
+
+	nodeType := s.choose("What kind of node is this?", []string{
+		"broadcaster - sends streams to orchestrators to be transcoded",
+		"orchestrator - transcodes streams for broadcasters, on this machine or with standalone transcoders",
+		"transcoder - standalone transcoder working for an orchestrator",
+	}, 0)
+	network := "offchain"
+	switch nodeType {
+	case 0:
+		s.settings["broadcaster"] = true
+	case 1:
+		s.settings["orchestrator"] = true
+	case 2:
+		s.settings["transcoder"] = true
+	}
+
+	// Standalone transcoders only talk to their orchestrator
+	if nodeType != 2 {
+		var err error
+		if network, err = s.setupNetwork(); err != nil {
+			return err
+		}
+	}
+	datadir := s.ask("Data directory", filepath.Join(s.homeDir, ".lpData", network))
+	s.settings["datadir"] = datadir
+	if network != "offchain" {
+		if err := s.setupKey(filepath.Join(datadir, "keystore")); err != nil {
+			return err
+		}
+	}
+
+	switch nodeType {
+	case 1:
+		s.setupOrchestrator()
+	case 2:
+		s.settings["orchAddr"] = s.askRequired("Address of the orchestrator (host:port)")
+		s.settings["orchSecret"] = s.askRequired("Secret shared with the orchestrator (its -orchSecret)")
+		s.setupGPUs()
+	}
+
+	path := s.ask("Write the config file to", filepath.Join(datadir, "livepeer.yaml"))
+	if err := s.writeConfig(path); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "\nWrote %s. Check it with\n\n  livepeer -config %s -validateConfig\n\nand start the node with\n\n  livepeer -config %s\n", path, path, path)
+	return nil
+}
+
+func (s *setup) setupNetwork() (string, error) {
+	networks := []string{"offchain", "rinkeby", "mainnet", "custom"}
+	network := networks[s.choose("Which network?", []string{
+		"offchain - no payments, for testing",
+		"rinkeby - the test network",
+		"mainnet",
+		"custom - another eth network running the protocol",
+	}, 0)]
+	s.settings["network"] = network
+	if network == "offchain" {
+		return network, nil
+	}
+
+	var ethURL string
+	var chainID *big.Int
+	// The settings of custom networks go in a bundle of their own
+	var bundle *networkBundle
+	if netw, ok := configOptions[network]; ok {
+		chainID = netw.chainID
+		ethURL = s.ask("Ethereum RPC URL", netw.ethUrl)
+		if ethURL != netw.ethUrl {
+			s.settings["ethUrl"] = ethURL
+		}
+	} else {
+		bundle = s.askNetworkBundle()
+		chainID = bundle.ChainID
+		ethURL = bundle.EthURL
+	}
+
+	for {
+		fmt.Fprintf(s.out, "Testing %s... ", ethURL)
+		err := checkEthURL(ethURL, chainID)
+		if err == nil {
+			fmt.Fprintln(s.out, "OK")
+			break
+		}
+		fmt.Fprintf(s.out, "failed: %v\n", err)
+		if !s.confirm("Try another URL?", true) {
+			break
+		}
+		ethURL = s.askRequired("Ethereum RPC URL")
+		if bundle != nil {
+			bundle.EthURL = ethURL
+		} else {
+			s.settings["ethUrl"] = ethURL
+		}
+	}
+
+	if bundle != nil {
+		path := s.ask("Write the network config to", filepath.Join(s.homeDir, ".lpData", network, "network.json"))
+		data, err := json.MarshalIndent(bundle, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := s.writeFile(path, data, 0644); err != nil {
+			return "", err
+		}
+		s.settings["networkConfig"] = path
+	}
+	return network, nil
+}
+
+// askNetworkBundle asks for the settings of a custom network
+func (s *setup) askNetworkBundle() *networkBundle {
+	b := &networkBundle{EthURL: s.askRequired("Ethereum RPC URL")}
+	for {
+		b.EthController = s.askRequired("Address of the protocol controller contract")
+		if ethcommon.IsHexAddress(b.EthController) {
+			break
+		}
+		fmt.Fprintln(s.out, "That's not an address")
+	}
+	for {
+		id := s.ask("Chain ID the eth node must be on (none to not check)", "none")
+		if id == "none" {
+			break
+		}
+		if chainID, ok := new(big.Int).SetString(id, 10); ok && chainID.Sign() > 0 {
+			b.ChainID = chainID
+			break
+		}
+		fmt.Fprintln(s.out, "Enter a positive number")
+	}
+	for {
+		blockTime, err := strconv.ParseFloat(s.ask("Seconds between blocks", "15"), 64)
+		if err == nil && blockTime > 0 {
+			b.BlockTime = blockTime
+			break
+		}
+		fmt.Fprintln(s.out, "Enter a positive number")
+	}
+	for {
+		confirmations, err := strconv.ParseUint(s.ask("Blocks a transaction must be under to be confirmed", "0"), 10, 64)
+		if err == nil {
+			b.Confirmations = confirmations
+			break
+		}
+		fmt.Fprintln(s.out, "Enter a number")
+	}
+	return b
+}
+
+func (s *setup) setupKey(keystoreDir string) error {
+	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+	options := []string{"create a new key", "import a JSON keystore file"}
+	accts := ks.Accounts()
+	for _, acct := range accts {
+		options = append(options, "use "+acct.Address.Hex())
+	}
+
+	def := 0
+	if len(accts) > 0 {
+		def = 2
+	}
+	switch choice := s.choose("Which Ethereum account should the node use?", options, def); choice {
+	case 0:
+		passphrase, err := s.passphrase(true)
+		if err != nil {
+			return err
+		}
+		acct, err := ks.NewAccount(passphrase)
+		if err != nil {
+			return fmt.Errorf("error creating key: %v", err)
+		}
+		fmt.Fprintf(s.out, "Created %s in %s. Back up this file and its passphrase; funds are lost without them.\n", acct.Address.Hex(), acct.URL.Path)
+		s.settings["ethAcctAddr"] = acct.Address.Hex()
+	case 1:
+		for {
+			path := s.askRequired("Path of the keystore file")
+			keyJSON, err := ioutil.ReadFile(path)
+			if err != nil {
+				fmt.Fprintln(s.out, err)
+				continue
+			}
+			passphrase, err := s.passphrase(false)
+			if err != nil {
+				return err
+			}
+			acct, err := ks.Import(keyJSON, passphrase, passphrase)
+			if err != nil {
+				fmt.Fprintf(s.out, "Error importing key: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(s.out, "Imported %s\n", acct.Address.Hex())
+			s.settings["ethAcctAddr"] = acct.Address.Hex()
+			break
+		}
+	default:
+		s.settings["ethAcctAddr"] = accts[choice-2].Address.Hex()
+	}
+	fmt.Fprintln(s.out, "The node asks for the passphrase when it starts, unless -ethPassword is given.")
+	return nil
+}
+
+func (s *setup) setupOrchestrator() {
+	s.settings["serviceAddr"] = s.askRequired("Public host:port broadcasters reach this node on")
+	if s.confirm("Transcode on this machine?", true) {
+		s.settings["transcoder"] = true
+		s.setupGPUs()
+		return
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	s.settings["orchSecret"] = s.ask("Secret standalone transcoders connect with", hex.EncodeToString(secret))
+}
+
+func (s *setup) setupGPUs() {
+	gpus, err := findGPUs()
+	if err != nil || len(gpus) == 0 {
+		fmt.Fprintln(s.out, "No Nvidia GPUs found; transcoding on the CPU.")
+		return
+	}
+	var ids []string
+	for _, gpu := range gpus {
+		fmt.Fprintf(s.out, "Found GPU %s: %s\n", gpu.ID, gpu.Name)
+		ids = append(ids, gpu.ID)
+	}
+	if s.confirm("Transcode on these GPUs?", true) {
+		s.settings["nvidia"] = strings.Join(ids, ",")
+	}
+}
+
+func (s *setup) writeConfig(path string) error {
+	data, err := yaml.Marshal(s.settings)
+	if err != nil {
+		return err
+	}
+	// May include the orchestrator secret
+	return s.writeFile(path, data, 0600)
+}
+
+// writeFile writes data to path, unless it exists and the operator would
+// rather keep it
+func (s *setup) writeFile(path string, data []byte, perm os.FileMode) error {
+	if _, err := os.Stat(path); err == nil && !s.confirm(path+" exists. Overwrite it?", false) {
+		return fmt.Errorf("not overwriting %s", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, perm)
+}
+
+func (s *setup) read() string {
+	fmt.Fprint(s.out, "> ")
+	text, err := s.in.ReadString('\n')
+	if err != nil && text == "" {
+		// Input closed; nothing more to read
+		fmt.Fprintln(s.out)
+		os.Exit(1)
+	}
+	return strings.TrimSpace(text)
+}
+
+func (s *setup) ask(question, def string) string {
+	fmt.Fprintf(s.out, "\n%s [%s]\n", question, def)
+	if text := s.read(); text != "" {
+		return text
+	}
+	return def
+}
+
+func (s *setup) askRequired(question string) string {
+	fmt.Fprintf(s.out, "\n%s\n", question)
+	for {
+		if text := s.read(); text != "" {
+			return text
+		}
+	}
+}
+
+func (s *setup) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	fmt.Fprintf(s.out, "\n%s [%s]\n", question, hint)
+	for {
+		switch strings.ToLower(s.read()) {
+		case "":
+			return def
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+		fmt.Fprintln(s.out, "Enter y or n")
+	}
+}
+
+// choose returns the index of the option picked
+func (s *setup) choose(question string, options []string, def int) int {
+	fmt.Fprintf(s.out, "\n%s [%d]\n", question, def+1)
+	for i, opt := range options {
+		fmt.Fprintf(s.out, "  %d. %s\n", i+1, opt)
+	}
+	for {
+		text := s.read()
+		if text == "" {
+			return def
+		}
+		if i, err := strconv.Atoi(text); err == nil && i >= 1 && i <= len(options) {
+			return i - 1
+		}
+		fmt.Fprintf(s.out, "Enter a number from 1 to %d\n", len(options))
+	}
+}
+
+func (s *setup) passphrase(confirm bool) (string, error) {
+	if confirm {
+		fmt.Fprintln(s.out, "\nChoose a passphrase to encrypt the key with (no characters will appear as you type)")
+	} else {
+		fmt.Fprintln(s.out, "\nEnter the passphrase of the key (no characters will appear as you type)")
+	}
+	passphrase, err := console.Stdin.PromptPassword("Passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		repeat, err := console.Stdin.PromptPassword("Repeat passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if repeat != passphrase {
+			return "", eth.ErrPassphraseMismatch
+		}
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+// readSetupConfig returns the settings of the config file setup wrote at path
+func readSetupConfig(t *testing.T, path string) map[string]interface{} {
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	settings := make(map[string]interface{})
+	require.Nil(t, yaml.Unmarshal(data, &settings))
+	return settings
+}
+
+func TestRunSetup_Offchain(t *testing.T) {
+	home, err := ioutil.TempDir("", "setup")
+	require.Nil(t, err)
+	defer os.RemoveAll(home)
+	datadir := filepath.Join(home, ".lpData", "offchain")
+
+	defer func(f func() ([]lpmon.GPUInfo, error)) { findGPUs = f }(findGPUs)
+	for _, tt := range []struct {
+		name string
+		gpus []lpmon.GPUInfo
+		// one line per question
+		input    []string
+		settings map[string]interface{}
+	}{
+		{
+			name:     "broadcaster",
+			input:    []string{"", "", "", ""},
+			settings: map[string]interface{}{"broadcaster": true, "network": "offchain", "datadir": datadir},
+		},
+		{
+			name: "orchestrator with standalone transcoders",
+			// Retries invalid answers
+			input: []string{"4", "2", "", "", "", "0.0.0.0:8935", "maybe", "n", "secret", ""},
+			settings: map[string]interface{}{"orchestrator": true, "network": "offchain", "datadir": datadir,
+				"serviceAddr": "0.0.0.0:8935", "orchSecret": "secret"},
+		},
+		{
+			name:  "orchestrator transcoding on GPUs",
+			gpus:  []lpmon.GPUInfo{{ID: "0", Name: "Tesla T4"}, {ID: "1", Name: "Tesla T4"}},
+			input: []string{"2", "", "", "0.0.0.0:8935", "", "", ""},
+			settings: map[string]interface{}{"orchestrator": true, "network": "offchain", "datadir": datadir,
+				"serviceAddr": "0.0.0.0:8935", "transcoder": true, "nvidia": "0,1"},
+		},
+		{
+			name:  "orchestrator transcoding on the CPU",
+			gpus:  []lpmon.GPUInfo{{ID: "0", Name: "Tesla T4"}},
+			input: []string{"2", "", "", "0.0.0.0:8935", "y", "n", ""},
+			settings: map[string]interface{}{"orchestrator": true, "network": "offchain", "datadir": datadir,
+				"serviceAddr": "0.0.0.0:8935", "transcoder": true},
+		},
+		{
+			name: "standalone transcoder",
+			// Isn't asked for a network
+			input: []string{"3", filepath.Join(home, "transcoder"), "127.0.0.1:8935", "secret", ""},
+			settings: map[string]interface{}{"transcoder": true, "datadir": filepath.Join(home, "transcoder"),
+				"orchAddr": "127.0.0.1:8935", "orchSecret": "secret"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			findGPUs = func() ([]lpmon.GPUInfo, error) {
+				if tt.gpus == nil {
+					return nil, errors.New("NVML not found")
+				}
+				return tt.gpus, nil
+			}
+			datadir := tt.settings["datadir"].(string)
+			defer os.RemoveAll(datadir)
+			var out bytes.Buffer
+			require.Nil(t, runSetup(strings.NewReader(strings.Join(tt.input, "\n")+"\n"), &out, home))
+			path := filepath.Join(datadir, "livepeer.yaml")
+			assert.Equal(t, tt.settings, readSetupConfig(t, path))
+			assert.Contains(t, out.String(), "livepeer -config "+path)
+		})
+	}
+}
+
+func TestRunSetup_GeneratedSecret(t *testing.T) {
+	assert := assert.New(t)
+	home, err := ioutil.TempDir("", "setup")
+	require.Nil(t, err)
+	defer os.RemoveAll(home)
+
+	input := "2\n\n\n0.0.0.0:8935\nn\n\n\n"
+	require.Nil(t, runSetup(strings.NewReader(input), ioutil.Discard, home))
+	settings := readSetupConfig(t, filepath.Join(home, ".lpData", "offchain", "livepeer.yaml"))
+	secret, _ := settings["orchSecret"].(string)
+	assert.Len(secret, 32)
+
+	// Another secret each time
+	require.Nil(t, runSetup(strings.NewReader(input+"y\n"), ioutil.Discard, home))
+	settings = readSetupConfig(t, filepath.Join(home, ".lpData", "offchain", "livepeer.yaml"))
+	assert.Len(settings["orchSecret"], 32)
+	assert.NotEqual(secret, settings["orchSecret"])
+}
+
+func TestRunSetup_KeepsExistingConfig(t *testing.T) {
+	assert := assert.New(t)
+	home, err := ioutil.TempDir("", "setup")
+	require.Nil(t, err)
+	defer os.RemoveAll(home)
+	path := filepath.Join(home, "livepeer.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte("broadcaster: true\n"), 0600))
+
+	err = runSetup(strings.NewReader("2\n\n\n0.0.0.0:8935\nn\nsecret\n"+path+"\n\n"), ioutil.Discard, home)
+	assert.EqualError(err, "not overwriting "+path)
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("broadcaster: true\n", string(data))
+}
+
+func TestRunSetup_Onchain(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	home, err := ioutil.TempDir("", "setup")
+	require.Nil(err)
+	defer os.RemoveAll(home)
+
+	// Uses a key already in the keystore, which doesn't ask for its passphrase
+	newAccount := func(network string) string {
+		ks := keystore.NewKeyStore(filepath.Join(home, ".lpData", network, "keystore"), keystore.LightScryptN, keystore.LightScryptP)
+		acct, err := ks.NewAccount("")
+		require.Nil(err)
+		return acct.Address.Hex()
+	}
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	// A preset network, retrying after the eth node can't be reached
+	rinkeby := stubEthRPC(4)
+	defer rinkeby.Close()
+	addr := newAccount("rinkeby")
+	var out bytes.Buffer
+	input := []string{"", "2", down.URL, "", rinkeby.URL, "", "", ""}
+	require.Nil(runSetup(strings.NewReader(strings.Join(input, "\n")+"\n"), &out, home))
+	datadir := filepath.Join(home, ".lpData", "rinkeby")
+	assert.Equal(map[string]interface{}{"broadcaster": true, "network": "rinkeby", "datadir": datadir,
+		"ethUrl": rinkeby.URL, "ethAcctAddr": addr}, readSetupConfig(t, filepath.Join(datadir, "livepeer.yaml")))
+	assert.Contains(out.String(), "Testing "+down.URL+"... failed")
+	assert.Contains(out.String(), "Testing "+rinkeby.URL+"... OK")
+
+	// A custom network, whose settings go in a bundle
+	custom := stubEthRPC(1337)
+	defer custom.Close()
+	addr = newAccount(customNetwork)
+	out.Reset()
+	input = []string{"", "4", custom.URL, "controller", "0x1234567890123456789012345678901234567890", "-1", "1337", "", "2", "", "", "", ""}
+	require.Nil(runSetup(strings.NewReader(strings.Join(input, "\n")+"\n"), &out, home))
+	datadir = filepath.Join(home, ".lpData", customNetwork)
+	bundlePath := filepath.Join(datadir, "network.json")
+	assert.Equal(map[string]interface{}{"broadcaster": true, "network": customNetwork, "datadir": datadir,
+		"networkConfig": bundlePath, "ethAcctAddr": addr}, readSetupConfig(t, filepath.Join(datadir, "livepeer.yaml")))
+	assert.Contains(out.String(), "That's not an address")
+	assert.Contains(out.String(), "Testing "+custom.URL+"... OK")
+
+	data, err := ioutil.ReadFile(bundlePath)
+	require.Nil(err)
+	var bundle networkBundle
+	require.Nil(json.Unmarshal(data, &bundle))
+	assert.Equal(custom.URL, bundle.EthURL)
+	assert.Equal("0x1234567890123456789012345678901234567890", bundle.EthController)
+	assert.Equal(int64(1337), bundle.ChainID.Int64())
+	assert.Equal(15.0, bundle.BlockTime)
+	assert.Equal(uint64(2), bundle.Confirmations)
+	// The bundle is one the node loads
+	netw, err := lookupNetwork(customNetwork, bundlePath)
+	require.Nil(err)
+	assert.Equal(custom.URL, netw.ethUrl)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/server"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+)
+
+const ethRPCCheckTimeout = 10 * time.Second
+
+// configError is a problem with the setting of a flag, as reported by
+// -validateConfig
+type configError struct {
+	Setting string `json:"setting"`
+	Error   string `json:"error"`
+}
+
+// validateConfig cross-checks the flags of fs the way the node would on
+// startup, without starting anything. The eth RPC endpoint is dialed to check
+// it's reachable.
+func validateConfig(fs *flag.FlagSet) []configError {
+	var errs []configError
+	fail := func(setting, format string, args ...interface{}) {
+		errs = append(errs, configError{Setting: setting, Error: fmt.Sprintf(format, args...)})
+	}
+	str := func(name string) string { return fs.Lookup(name).Value.String() }
+	on := func(name string) bool { b, _ := strconv.ParseBool(str(name)); return b }
+	num := func(name string) float64 { f, _ := strconv.ParseFloat(str(name), 64); return f }
+
+	orchestrator, transcoder, broadcaster := on("orchestrator"), on("transcoder"), on("broadcaster")
+	switch {
+	case !orchestrator && !transcoder && !broadcaster:
+		fail("broadcaster", "node type not set; must be one of -broadcaster, -transcoder or -orchestrator")
+	case broadcaster && (orchestrator || transcoder):
+		fail("broadcaster", "can't be combined with -orchestrator or -transcoder")
+	case transcoder && !orchestrator:
+		if str("orchSecret") == "" {
+			fail("orchSecret", "required by a standalone transcoder")
+		}
+		if str("orchAddr") == "" {
+			fail("orchAddr", "required by a standalone transcoder")
+		}
+	case orchestrator && !transcoder:
+		if str("orchSecret") == "" {
+			fail("orchSecret", "required by an orchestrator without -transcoder, for standalone transcoders to connect")
+		}
+	}
+
+	if network := str("network"); network != "offchain" {
+		ethURL, ethController := str("ethUrl"), str("ethController")
+		var chainID *big.Int
+		netw, err := lookupNetwork(network, str("networkConfig"))
+		if err != nil {
+			fail("networkConfig", "%v", err)
+		}
+		if netw != nil {
+			chainID = netw.chainID
+			if ethURL == "" {
+				ethURL = netw.ethUrl
+			}
+			if ethController == "" {
+				ethController = netw.ethController
+			}
+		}
+		if ethURL == "" {
+			fail("ethUrl", "required on network %s", network)
+		} else if err := checkEthURL(ethURL, chainID); err != nil {
+			fail("ethUrl", "eth RPC endpoint unreachable: %v", err)
+		}
+		if !ethcommon.IsHexAddress(ethController) {
+			fail("ethController", "must be the address of the protocol controller on network %s", network)
+		}
+		if addr := str("ethAcctAddr"); addr != "" && !ethcommon.IsHexAddress(addr) {
+			fail("ethAcctAddr", "invalid address %s", addr)
+		}
+		if faceValue := num("faceValue"); faceValue < 0 {
+			fail("faceValue", "must be at least 0, got %v", faceValue)
+		}
+		if winProb := num("winProb"); winProb < 0 || winProb > 100 {
+			fail("winProb", "must be between 0 and 100, got %v", winProb)
+		}
+	}
+
+	var stores []string
+	if s3bucket, s3creds, s3credsFile := str("s3Bucket"), str("s3Credentials"), str("s3CredentialsFile"); s3bucket != "" || s3creds != "" || s3credsFile != "" {
+		stores = append(stores, "s3Bucket")
+		if s3bucket == "" || s3creds == "" && s3credsFile == "" {
+			fail("s3Bucket", "-s3Bucket and -s3Credentials or -s3CredentialsFile must both be set")
+		}
+		if s3creds != "" && s3credsFile != "" {
+			fail("s3CredentialsFile", "can't be combined with -s3Credentials")
+		}
+		bucket := strings.Split(s3bucket, "/")
+		if s3bucket != "" && len(bucket) != 2 {
+			fail("s3Bucket", "must be in the form region/bucket, got %s", s3bucket)
+		}
+		if s3creds != "" && len(strings.SplitN(s3creds, "/", 2)) != 2 {
+			fail("s3Credentials", "must be in the form ACCESSKEYID/ACCESSKEY")
+		}
+		if s3credsFile != "" && len(bucket) == 2 {
+			if _, _, err := drivers.S3BucketCredentials(s3credsFile, bucket[1]); err != nil {
+				fail("s3CredentialsFile", "%v", err)
+			}
+		}
+		if endpoint := str("s3Endpoint"); endpoint != "" {
+			if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+				fail("s3Endpoint", "invalid URL %s", endpoint)
+			}
+		}
+	}
+	if gsBucket, gsKey := str("gsBucket"), str("gsKey"); gsBucket != "" || gsKey != "" {
+		stores = append(stores, "gsBucket")
+		if gsBucket == "" || gsKey == "" {
+			fail("gsBucket", "-gsBucket and -gsKey must both be set")
+		}
+		if gsKey != "" {
+			if _, err := os.Stat(gsKey); err != nil {
+				fail("gsKey", "%v", err)
+			}
+		}
+	}
+	if ipfsAPI, ipfsPinURL := str("ipfsApi"), str("ipfsPinningUrl"); ipfsAPI != "" || ipfsPinURL != "" {
+		stores = append(stores, "ipfsApi")
+		if ipfsAPI != "" && ipfsPinURL != "" {
+			fail("ipfsPinningUrl", "can't be combined with -ipfsApi")
+		}
+		if ipfsPinURL != "" {
+			if _, err := url.ParseRequestURI(ipfsPinURL); err != nil {
+				fail("ipfsPinningUrl", "invalid URL %s", ipfsPinURL)
+			}
+		}
+	}
+	if len(stores) > 1 {
+		fail(stores[1], "only one object store may be set, got %s", strings.Join(stores, ", "))
+	}
+	if key := str("storageEncryptionKey"); key != "" {
+		if !broadcaster || len(stores) == 0 {
+			fail("storageEncryptionKey", "requires a broadcaster with an object store")
+		}
+		if _, err := os.Stat(key); err != nil {
+			fail("storageEncryptionKey", "%v", err)
+		}
+	}
+
+	if broadcaster {
+		for _, p := range strings.Split(str("transcodingOptions"), ",") {
+			if _, ok := ffmpeg.VideoProfileLookup[strings.TrimSpace(p)]; !ok {
+				fail("transcodingOptions", "unknown profile %s", strings.TrimSpace(p))
+			}
+		}
+		if _, err := getAuthWebhookURL(str("authWebhookUrl")); err != nil {
+			fail("authWebhookUrl", "%v", err)
+		}
+	}
+	if transcoder {
+		if sessions := num("maxSessions"); sessions <= 0 {
+			fail("maxSessions", "must be greater than 0, got %v", sessions)
+		}
+		if nvidia := str("nvidia"); nvidia != "" {
+			for _, id := range strings.Split(nvidia, ",") {
+				if _, err := strconv.Atoi(strings.TrimSpace(id)); err != nil {
+					fail("nvidia", "invalid device ID %s", id)
+				}
+			}
+		}
+		if vaapi := str("vaapi"); vaapi != "" {
+			if len(core.VAAPIDevices(vaapi)) == 0 {
+				fail("vaapi", "no render node in %s", vaapi)
+			}
+			if runtime.GOOS != "linux" {
+				fail("vaapi", "VAAPI is only supported on Linux, not %s", runtime.GOOS)
+			}
+		}
+		if on("videotoolbox") && runtime.GOOS != "darwin" {
+			fail("videotoolbox", "VideoToolbox is only supported on macOS, not %s", runtime.GOOS)
+		}
+		accels := 0
+		for _, set := range []bool{str("nvidia") != "", str("vaapi") != "", on("videotoolbox")} {
+			if set {
+				accels++
+			}
+		}
+		if accels > 1 {
+			fail("nvidia", "only one of -nvidia, -vaapi or -videotoolbox can be set")
+		}
+	}
+
+	if logFormat := str("logFormat"); logFormat != common.LogFormatText && logFormat != common.LogFormatJSON {
+		fail("logFormat", "must be one of text or json, got %s", logFormat)
+	}
+	switch mode := lpmon.LabelMode(str("metricsPerOrchestrator")); mode {
+	case lpmon.LabelFull, lpmon.LabelAggregate, lpmon.LabelOff:
+	default:
+		fail("metricsPerOrchestrator", "must be one of full, aggregate or off, got %s", mode)
+	}
+	if rate := num("alertMinSuccessRate"); rate > 0 && !on("monitor") {
+		fail("alertMinSuccessRate", "requires -monitor")
+	}
+	for _, name := range []string{"alertMinDeposit", "minSenderDeposit", "minSenderReserve"} {
+		if min := str(name); min != "" {
+			if _, ok := new(big.Int).SetString(min, 10); !ok {
+				fail(name, "invalid amount %s", min)
+			}
+		}
+	}
+	if on("dbEncrypt") && str("dbKeyCommand") != "" {
+		fail("dbEncrypt", "can't be combined with -dbKeyCommand")
+	}
+	if (on("dbEncrypt") || str("dbKeyCommand") != "") && str("network") == "offchain" {
+		fail("dbEncrypt", "winning tickets are only stored on chain")
+	}
+	for _, name := range []string{"ingestAllow", "ingestDeny"} {
+		if _, err := server.ParseCIDRs(str(name)); err != nil {
+			fail(name, "%v", err)
+		}
+	}
+	if str("failoverID") != "" {
+		if !broadcaster {
+			fail("failoverID", "requires a broadcaster")
+		}
+		if d, _ := time.ParseDuration(str("failoverLease")); d <= 0 {
+			fail("failoverLease", "must be greater than 0, got %v", d)
+		}
+	}
+	for _, name := range []string{"capacityBackoff", "maxCapacityBackoff"} {
+		if d, _ := time.ParseDuration(str(name)); d <= 0 {
+			fail(name, "must be greater than 0, got %v", d)
+		}
+	}
+	for _, name := range []string{"orchRateLimitPerIP", "orchRateLimit", "hlsRateLimitPerIP", "hlsRateLimit"} {
+		if _, err := server.ParseRateLimit(str(name)); err != nil {
+			fail(name, "%v", err)
+		}
+	}
+	if res := str("maxSegmentResolution"); res != "" {
+		if err := server.ParseResolution(res); err != nil {
+			fail("maxSegmentResolution", "%v", err)
+		}
+	}
+	for _, name := range []string{"maxSegmentUploads", "segmentBanViolations", "maxRenditionUploads", "segmentMaxConns", "segmentMaxIdleConns"} {
+		if n := num(name); n < 0 {
+			fail(name, "must be at least 0, got %v", n)
+		}
+	}
+	for _, name := range []string{"segmentIdleConnTimeout", "segmentTLSHandshakeTimeout", "segmentResponseHeaderTimeout", "dbFlushInterval"} {
+		if d, _ := time.ParseDuration(str(name)); d < 0 {
+			fail(name, "must be at least 0, got %v", d)
+		}
+	}
+	if _, err := server.ParseCliTokens(str("cliTokens")); err != nil {
+		fail("cliTokens", "%v", err)
+	}
+	if str("adminAddr") != "" && str("adminToken") == "" {
+		fail("adminAddr", "requires -adminToken")
+	}
+	if cert, key := str("adminTLSCert"), str("adminTLSKey"); cert != "" || key != "" {
+		if cert == "" || key == "" {
+			fail("adminTLSCert", "-adminTLSCert and -adminTLSKey must both be set")
+		}
+		for _, name := range []string{"adminTLSCert", "adminTLSKey"} {
+			if file := str(name); file != "" {
+				if _, err := os.Stat(file); err != nil {
+					fail(name, "%v", err)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// checkEthURL dials the eth RPC endpoint and fetches the latest header. If
+// chainID is set, the endpoint must be on that chain.
+func checkEthURL(ethURL string, chainID *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ethRPCCheckTimeout)
+	defer cancel()
+	rpcClient, err := rpc.DialContext(ctx, ethURL)
+	if err != nil {
+		return err
+	}
+	defer rpcClient.Close()
+	if _, err = ethclient.NewClient(rpcClient).HeaderByNumber(ctx, nil); err != nil || chainID == nil {
+		return err
+	}
+	return checkChainID(ctx, rpcClient, chainID)
+}
+
+// printConfigErrors writes the outcome of validating the config to w as JSON
+// and returns the exit status: 0 if the config is valid, 1 otherwise
+func printConfigErrors(w io.Writer, errs []configError) int {
+	if errs == nil {
+		errs = []configError{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{
+		"valid":  len(errs) == 0,
+		"errors": errs,
+	})
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/common"
+	lpmon "github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validateFlags returns the flags validateConfig checks, with the defaults of
+// the node, parsed from args
+func validateFlags(t *testing.T, args ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet("livepeer", flag.ContinueOnError)
+	for _, name := range []string{"orchestrator", "transcoder", "broadcaster", "monitor", "dbEncrypt", "videotoolbox"} {
+		fs.Bool(name, false, "")
+	}
+	for _, name := range []string{"orchSecret", "orchAddr", "ethUrl", "ethController", "ethAcctAddr", "networkConfig",
+		"s3Bucket", "s3Credentials", "s3CredentialsFile", "s3Endpoint", "gsBucket", "gsKey", "ipfsApi", "ipfsPinningUrl",
+		"storageEncryptionKey", "authWebhookUrl", "nvidia", "vaapi", "alertMinDeposit", "minSenderDeposit", "minSenderReserve",
+		"dbKeyCommand", "ingestAllow", "ingestDeny", "failoverID", "orchRateLimitPerIP", "orchRateLimit", "hlsRateLimitPerIP",
+		"hlsRateLimit", "cliTokens", "adminAddr", "adminToken", "adminTLSCert", "adminTLSKey"} {
+		fs.String(name, "", "")
+	}
+	for _, name := range []string{"faceValue", "winProb", "alertMinSuccessRate"} {
+		fs.Float64(name, 0, "")
+	}
+	fs.String("network", "offchain", "")
+	fs.String("transcodingOptions", "P240p30fps16x9,P360p30fps16x9", "")
+	fs.String("logFormat", common.LogFormatText, "")
+	fs.String("metricsPerOrchestrator", string(lpmon.LabelFull), "")
+	fs.String("maxSegmentResolution", server.MaxSegmentResolution, "")
+	fs.Int("maxSessions", 10, "")
+	fs.Int("maxSegmentUploads", server.MaxSegmentUploads, "")
+	fs.Int("segmentBanViolations", server.SegmentBanViolations, "")
+	fs.Int("maxRenditionUploads", server.MaxRenditionUploads, "")
+	fs.Int("segmentMaxConns", server.SegmentMaxConnsPerHost, "")
+	fs.Int("segmentMaxIdleConns", server.SegmentMaxIdleConnsPerHost, "")
+	fs.Duration("failoverLease", server.FailoverLease, "")
+	fs.Duration("capacityBackoff", server.CapacityBackoff, "")
+	fs.Duration("maxCapacityBackoff", server.MaxCapacityBackoff, "")
+	fs.Duration("segmentIdleConnTimeout", server.SegmentIdleConnTimeout, "")
+	fs.Duration("segmentTLSHandshakeTimeout", server.SegmentTLSHandshakeTimeout, "")
+	fs.Duration("segmentResponseHeaderTimeout", server.SegmentResponseHeaderTimeout, "")
+	fs.Duration("dbFlushInterval", common.DBFlushInterval, "")
+	require.Nil(t, fs.Parse(args))
+	return fs
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	require.Nil(t, ioutil.WriteFile(keyFile, []byte("key"), 0600))
+	missing := filepath.Join(dir, "missing")
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		// settings reported, in order; none if the config is valid
+		errs []string
+	}{
+		// Node types
+		{name: "no node type", errs: []string{"broadcaster"}},
+		{name: "broadcaster", args: []string{"-broadcaster"}},
+		{name: "orchestrator and transcoder", args: []string{"-orchestrator", "-transcoder"}},
+		{name: "broadcaster and orchestrator", args: []string{"-broadcaster", "-orchestrator", "-transcoder"}, errs: []string{"broadcaster"}},
+		{name: "standalone transcoder", args: []string{"-transcoder", "-orchSecret", "s", "-orchAddr", "127.0.0.1:8935"}},
+		{name: "standalone transcoder without orchestrator", args: []string{"-transcoder"}, errs: []string{"orchSecret", "orchAddr"}},
+		{name: "standalone orchestrator", args: []string{"-orchestrator", "-orchSecret", "s"}},
+		{name: "standalone orchestrator without secret", args: []string{"-orchestrator"}, errs: []string{"orchSecret"}},
+
+		// On chain, without dialing the eth node
+		{name: "custom network without config", args: []string{"-broadcaster", "-network", "custom"}, errs: []string{"networkConfig", "ethUrl", "ethController"}},
+		{
+			name: "invalid ticket params",
+			args: []string{"-broadcaster", "-network", "custom", "-ethAcctAddr", "foo", "-faceValue", "-1", "-winProb", "101"},
+			errs: []string{"networkConfig", "ethUrl", "ethController", "ethAcctAddr", "faceValue", "winProb"},
+		},
+
+		// Object stores
+		{name: "s3", args: []string{"-broadcaster", "-s3Bucket", "eu-central-1/bucket", "-s3Credentials", "id/key"}},
+		{name: "s3 without credentials", args: []string{"-broadcaster", "-s3Bucket", "eu-central-1/bucket"}, errs: []string{"s3Bucket"}},
+		{name: "s3 with both credentials", args: []string{"-broadcaster", "-s3Bucket", "eu-central-1/bucket", "-s3Credentials", "id/key", "-s3CredentialsFile", missing}, errs: []string{"s3CredentialsFile", "s3CredentialsFile"}},
+		{name: "s3 malformed", args: []string{"-broadcaster", "-s3Bucket", "bucket", "-s3Credentials", "key", "-s3Endpoint", "minio"}, errs: []string{"s3Bucket", "s3Credentials", "s3Endpoint"}},
+		{name: "gs without key", args: []string{"-broadcaster", "-gsBucket", "bucket"}, errs: []string{"gsBucket"}},
+		{name: "gs missing key", args: []string{"-broadcaster", "-gsBucket", "bucket", "-gsKey", missing}, errs: []string{"gsKey"}},
+		{name: "ipfs", args: []string{"-broadcaster", "-ipfsApi", "127.0.0.1:5001"}},
+		{name: "ipfs twice", args: []string{"-broadcaster", "-ipfsApi", "127.0.0.1:5001", "-ipfsPinningUrl", "https://api.pinata.cloud/pinning/pinFileToIPFS"}, errs: []string{"ipfsPinningUrl"}},
+		{name: "two object stores", args: []string{"-broadcaster", "-ipfsApi", "127.0.0.1:5001", "-gsBucket", "bucket", "-gsKey", keyFile}, errs: []string{"ipfsApi"}},
+		{name: "storage encryption", args: []string{"-broadcaster", "-ipfsApi", "127.0.0.1:5001", "-storageEncryptionKey", keyFile}},
+		{name: "storage encryption without object store", args: []string{"-broadcaster", "-storageEncryptionKey", keyFile}, errs: []string{"storageEncryptionKey"}},
+		{name: "storage encryption on orchestrator", args: []string{"-orchestrator", "-transcoder", "-ipfsApi", "127.0.0.1:5001", "-storageEncryptionKey", missing}, errs: []string{"storageEncryptionKey", "storageEncryptionKey"}},
+
+		// Broadcasters and transcoders
+		{name: "unknown profile", args: []string{"-broadcaster", "-transcodingOptions", "P240p30fps16x9,P9000p"}, errs: []string{"transcodingOptions"}},
+		{name: "invalid auth webhook", args: []string{"-broadcaster", "-authWebhookUrl", "ftp://example.com"}, errs: []string{"authWebhookUrl"}},
+		{name: "nvidia", args: []string{"-orchestrator", "-transcoder", "-nvidia", "0,1"}},
+		{name: "invalid nvidia device", args: []string{"-orchestrator", "-transcoder", "-nvidia", "0,a"}, errs: []string{"nvidia"}},
+		{name: "no sessions", args: []string{"-orchestrator", "-transcoder", "-maxSessions", "0"}, errs: []string{"maxSessions"}},
+		{name: "nvidia on broadcaster", args: []string{"-broadcaster", "-nvidia", "a"}},
+
+		// Everything else
+		{name: "log format", args: []string{"-broadcaster", "-logFormat", "xml"}, errs: []string{"logFormat"}},
+		{name: "metrics labels", args: []string{"-broadcaster", "-metricsPerOrchestrator", "some"}, errs: []string{"metricsPerOrchestrator"}},
+		{name: "alert without monitor", args: []string{"-broadcaster", "-alertMinSuccessRate", "0.9"}, errs: []string{"alertMinSuccessRate"}},
+		{name: "alert with monitor", args: []string{"-broadcaster", "-monitor", "-alertMinSuccessRate", "0.9"}},
+		{name: "invalid amounts", args: []string{"-orchestrator", "-transcoder", "-minSenderDeposit", "1e18", "-minSenderReserve", "100"}, errs: []string{"minSenderDeposit"}},
+		{name: "db keys offchain", args: []string{"-orchestrator", "-transcoder", "-dbEncrypt", "-dbKeyCommand", "cat key"}, errs: []string{"dbEncrypt", "dbEncrypt"}},
+		{name: "invalid ingest ACL", args: []string{"-broadcaster", "-ingestAllow", "10.0.0.0/8", "-ingestDeny", "10.0.0.300"}, errs: []string{"ingestDeny"}},
+		{name: "failover", args: []string{"-broadcaster", "-failoverID", "b1"}},
+		{name: "failover on orchestrator", args: []string{"-orchestrator", "-transcoder", "-failoverID", "b1", "-failoverLease", "0s"}, errs: []string{"failoverID", "failoverLease"}},
+		{name: "no capacity backoff", args: []string{"-broadcaster", "-capacityBackoff", "0s"}, errs: []string{"capacityBackoff"}},
+		{name: "invalid rate limits", args: []string{"-orchestrator", "-transcoder", "-orchRateLimit", "10:0", "-hlsRateLimitPerIP", "fast"}, errs: []string{"orchRateLimit", "hlsRateLimitPerIP"}},
+		{name: "invalid segment limits", args: []string{"-orchestrator", "-transcoder", "-maxSegmentResolution", "4k", "-maxSegmentUploads", "-1", "-dbFlushInterval", "-1s"}, errs: []string{"maxSegmentResolution", "maxSegmentUploads", "dbFlushInterval"}},
+		{name: "invalid CLI tokens", args: []string{"-broadcaster", "-cliTokens", "root:secret"}, errs: []string{"cliTokens"}},
+		{name: "admin server", args: []string{"-broadcaster", "-adminAddr", "0.0.0.0:7936", "-adminToken", "t", "-adminTLSCert", keyFile, "-adminTLSKey", keyFile}},
+		{name: "admin server without token", args: []string{"-broadcaster", "-adminAddr", "0.0.0.0:7936"}, errs: []string{"adminAddr"}},
+		{name: "admin TLS without key", args: []string{"-broadcaster", "-adminTLSCert", missing}, errs: []string{"adminTLSCert", "adminTLSCert"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var settings []string
+			for _, e := range validateConfig(validateFlags(t, tt.args...)) {
+				settings = append(settings, e.Setting)
+			}
+			assert.Equal(t, tt.errs, settings)
+		})
+	}
+}
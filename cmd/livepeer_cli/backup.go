@@ -0,0 +1,500 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/console"
+	"github.com/ethereum/go-ethereum/crypto"
+	lpcommon "github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"golang.org/x/crypto/scrypt"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const backupVersion = 1
+
+// scrypt parameters of the key backups are encrypted with; the same as the
+// standard ones of the keystore. N is variable so that tests run quickly.
+var backupScryptN = 1 << 18
+
+const (
+	backupScryptR = 8
+	backupScryptP = 1
+)
+
+var ErrBackupPassphrase = errors.New("wrong passphrase or corrupted backup")
+
+// backupFile is what's written to disk: the bundle, encrypted with AES-GCM
+// under a key derived from the passphrase
+type backupFile struct {
+	Version    int    `json:"version"`
+	ScryptN    int    `json:"scryptN"`
+	ScryptR    int    `json:"scryptR"`
+	ScryptP    int    `json:"scryptP"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// backupBundle is the state of a node that can't be recovered from the chain
+type backupBundle struct {
+	CreatedAt time.Time `json:"createdAt"`
+	// Keys are the keystore files by name; they stay encrypted with their
+	// own passphrases
+	Keys           map[string]json.RawMessage  `json:"keys"`
+	WinningTickets []*lpcommon.DBWinningTicket `json:"winningTickets"`
+	Orchestrators  []*lpcommon.DBOrch          `json:"orchestrators"`
+	// Encrypted is whether the DB backed up was, so its tickets aren't
+	// restored in the clear
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+type backupResult struct {
+	File           string   `json:"file"`
+	Keys           []string `json:"keys"`
+	WinningTickets int      `json:"winningTickets"`
+	Orchestrators  int      `json:"orchestrators"`
+}
+
+func backupFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "datadir",
+			Usage: "data directory of the node, e.g. ~/.lpData/mainnet",
+		},
+		cli.StringFlag{
+			Name:  "keystore",
+			Usage: "keystore directory, if not <datadir>/keystore",
+		},
+		cli.StringFlag{
+			Name:  "file",
+			Usage: "backup file",
+		},
+		cli.StringFlag{
+			Name:  "passphraseFile",
+			Usage: "file with the passphrase of the backup, instead of prompting for it",
+		},
+		cli.BoolFlag{
+			Name:  "dbEncrypt",
+			Usage: "the DB is encrypted with the key derived from the node's account, as with the node's -dbEncrypt",
+		},
+		cli.StringFlag{
+			Name:  "dbKeyCommand",
+			Usage: "command printing the hex encoded key the DB is encrypted with, as with the node's -dbKeyCommand",
+		},
+		cli.StringFlag{
+			Name:  "ethAcctAddr",
+			Usage: "account of the node, with --dbEncrypt; the first in the keystore if not given",
+		},
+		cli.StringFlag{
+			Name:  "ethPassword",
+			Usage: "password of the node's account, with --dbEncrypt, instead of prompting for it",
+		},
+	}
+}
+
+func backupCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	datadir, keystoreDir, path, err := backupPaths(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+
+	dbPath := filepath.Join(datadir, "lp.sqlite3")
+	_, err = os.Stat(dbPath)
+	hasDB := err == nil
+	db, audit, err := startAudit(dbPath, "backup", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	res, err := backup(c, db, hasDB, keystoreDir, path)
+	audit.done(err)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// backup writes the keys in keystoreDir, and the tickets and orchestrators
+// in db if it had any, to the backup file at path
+func backup(c *cli.Context, db *lpcommon.DB, hasDB bool, keystoreDir, path string) (*backupResult, error) {
+	bundle := &backupBundle{CreatedAt: time.Now().UTC(), Keys: make(map[string]json.RawMessage)}
+	files, err := ioutil.ReadDir(keystoreDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	res := &backupResult{File: path, Keys: []string{}}
+	for _, f := range files {
+		// Skip editor backups and hidden files, as the keystore does
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || strings.HasSuffix(f.Name(), "~") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(keystoreDir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !json.Valid(data) {
+			continue
+		}
+		bundle.Keys[f.Name()] = json.RawMessage(data)
+		res.Keys = append(res.Keys, f.Name())
+	}
+
+	if hasDB {
+		key, err := backupDBKey(c, keystoreDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := unlockBackupDB(db, key); err != nil {
+			return nil, err
+		}
+		bundle.Encrypted = key != nil
+		if bundle.WinningTickets, err = db.AllWinningTickets(); err != nil {
+			return nil, err
+		}
+		if bundle.Orchestrators, err = db.AllOrchs(); err != nil {
+			return nil, err
+		}
+	}
+	if len(bundle.Keys) == 0 && len(bundle.WinningTickets) == 0 {
+		return nil, errors.New("no keys or tickets to back up")
+	}
+	res.WinningTickets, res.Orchestrators = len(bundle.WinningTickets), len(bundle.Orchestrators)
+
+	passphrase, err := backupPassphrase(c, true)
+	if err != nil {
+		return nil, err
+	}
+	data, err := sealBackup(bundle, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// restoreCommand writes the keys of a backup into the keystore and adds its
+// tickets and orchestrators to the DB. Existing keys are never overwritten
+// and tickets already in the DB are skipped, so restoring twice is harmless.
+// Tickets are encrypted again with the DB's key; those of encrypted DBs
+// aren't restored without one.
+func restoreCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	datadir, keystoreDir, path, err := backupPaths(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{datadir, keystoreDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	db, audit, err := startAudit(filepath.Join(datadir, "lp.sqlite3"), "restore", path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	res, err := restore(c, db, keystoreDir, path)
+	audit.done(err)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// restore restores the backup file at path into keystoreDir and db
+func restore(c *cli.Context, db *lpcommon.DB, keystoreDir, path string) (*backupResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := backupPassphrase(c, false)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := openBackup(data, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &backupResult{File: path, Keys: []string{}}
+	for name, key := range bundle.Keys {
+		// Names come from the backup; don't let them escape the keystore
+		keyPath := filepath.Join(keystoreDir, filepath.Base(name))
+		if existing, err := ioutil.ReadFile(keyPath); err == nil {
+			if string(existing) != string(key) {
+				return nil, fmt.Errorf("%s exists with a different key; not overwriting it", keyPath)
+			}
+			continue
+		}
+		if err := ioutil.WriteFile(keyPath, key, 0600); err != nil {
+			return nil, err
+		}
+		res.Keys = append(res.Keys, filepath.Base(name))
+	}
+
+	// The keys are restored first, so that the DB key can be derived from them
+	key, err := backupDBKey(c, keystoreDir)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.Encrypted && key == nil && len(bundle.WinningTickets) > 0 {
+		return nil, errors.New("the backed up DB was encrypted; restore it with --dbEncrypt or --dbKeyCommand")
+	}
+	if err := unlockBackupDB(db, key); err != nil {
+		return nil, err
+	}
+	for _, t := range bundle.WinningTickets {
+		exists, err := db.HasWinningTicket(t.Sig)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			continue
+		}
+		if err := db.StoreWinningTicket(t.SessionID, t.Ticket, t.Sig, t.RecipientRand); err != nil {
+			return nil, err
+		}
+		res.WinningTickets++
+	}
+	for _, o := range bundle.Orchestrators {
+		if err := db.UpdateOrch(o); err != nil {
+			return nil, err
+		}
+		res.Orchestrators++
+	}
+	return res, nil
+}
+
+func backupPaths(c *cli.Context) (datadir, keystoreDir, path string, err error) {
+	datadir = c.String("datadir")
+	if datadir == "" {
+		return "", "", "", errors.New("missing --datadir")
+	}
+	path = c.String("file")
+	if path == "" {
+		return "", "", "", errors.New("missing --file")
+	}
+	keystoreDir = c.String("keystore")
+	if keystoreDir == "" {
+		keystoreDir = filepath.Join(datadir, "keystore")
+	}
+	return datadir, keystoreDir, path, nil
+}
+
+// unlockBackupDB sets the key of db if given. Encrypted DBs aren't read from
+// or written to without their key.
+func unlockBackupDB(db *lpcommon.DB, key []byte) error {
+	if key != nil {
+		return db.SetEncryptionKey(key)
+	}
+	encrypted, err := db.Encrypted()
+	if err == nil && encrypted {
+		err = errors.New("the DB is encrypted; give its key with --dbEncrypt or --dbKeyCommand")
+	}
+	return err
+}
+
+// backupAudit is a backup or restore of the keys and tickets of a node,
+// recorded in the node's audit log as actions requested of it are
+type backupAudit struct {
+	db    *lpcommon.DB
+	entry lpcommon.DBAuditEntry
+}
+
+// startAudit opens the DB at dbPath and records in its audit log that action
+// is about to be taken with the backup file at path, by the user running
+// the CLI. Nothing is backed up or restored unless that's recorded.
+func startAudit(dbPath, action, path string) (*lpcommon.DB, *backupAudit, error) {
+	db, err := lpcommon.InitDB(dbPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening DB: %v", err)
+	}
+	caller := "local"
+	if u, err := user.Current(); err == nil {
+		caller += ":" + u.Username
+	}
+	params, _ := json.Marshal(map[string][]string{"file": {path}})
+	a := &backupAudit{db: db, entry: lpcommon.DBAuditEntry{
+		RequestID: lpcommon.NewRequestID(),
+		Caller:    caller,
+		Action:    action,
+		Params:    string(params),
+	}}
+	if err := db.InsertAuditEntry(&a.entry); err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("error recording the %s in the audit log: %v", action, err)
+	}
+	return db, a, nil
+}
+
+// done records that the action succeeded, or failed with err
+func (a *backupAudit) done(err error) {
+	a.entry.Status = http.StatusOK
+	if err != nil {
+		a.entry.Status = http.StatusInternalServerError
+	}
+	if err := a.db.InsertAuditEntry(&a.entry); err != nil {
+		glog.Errorf("Unable to record the %s in the audit log: %v", a.entry.Action, err)
+	}
+}
+
+// backupDBKey is the key of the DB given by --dbKeyCommand, or derived from
+// the node's account in keystoreDir with --dbEncrypt; nil if neither is given
+func backupDBKey(c *cli.Context, keystoreDir string) ([]byte, error) {
+	if cmd := c.String("dbKeyCommand"); cmd != "" {
+		return lpcommon.DBKeyFromCommand(cmd)
+	}
+	if !c.Bool("dbEncrypt") {
+		return nil, nil
+	}
+
+	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
+	accts := ks.Accounts()
+	if len(accts) == 0 {
+		return nil, fmt.Errorf("no accounts in %s to derive the DB key from", keystoreDir)
+	}
+	acct := accts[0]
+	if addr := c.String("ethAcctAddr"); addr != "" {
+		acct = accounts.Account{Address: ethcommon.HexToAddress(addr)}
+		if !ks.HasAddress(acct.Address) {
+			return nil, fmt.Errorf("account %s not in %s", addr, keystoreDir)
+		}
+	}
+	password := c.String("ethPassword")
+	if password == "" {
+		var err error
+		if password, err = console.Stdin.PromptPassword(fmt.Sprintf("Passphrase of account %s: ", acct.Address.Hex())); err != nil {
+			return nil, err
+		}
+	}
+	// Signed as the node signs it
+	hash := crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", 32, lpcommon.DBKeyMessage)))
+	sig, err := ks.SignHashWithPassphrase(acct, password, hash)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving DB key: %v", err)
+	}
+	return lpcommon.DBKeyFromSignature(sig), nil
+}
+
+// ErrEmptyBackupPassphrase is returned for backups without a passphrase, as
+// their key would be known to anyone
+var ErrEmptyBackupPassphrase = errors.New("empty passphrase")
+
+// backupPassphrase reads the passphrase of the backup from --passphraseFile,
+// or else prompts for it, twice if confirm is set
+func backupPassphrase(c *cli.Context, confirm bool) (string, error) {
+	var passphrase string
+	if file := c.String("passphraseFile"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	} else {
+		var err error
+		if passphrase, err = console.Stdin.PromptPassword("Backup passphrase: "); err != nil {
+			return "", err
+		}
+		if confirm {
+			repeat, err := console.Stdin.PromptPassword("Repeat passphrase: ")
+			if err != nil {
+				return "", err
+			}
+			if repeat != passphrase {
+				return "", errors.New("passphrases do not match")
+			}
+		}
+	}
+	if passphrase == "" {
+		return "", ErrEmptyBackupPassphrase
+	}
+	return passphrase, nil
+}
+
+func sealBackup(bundle *backupBundle, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyBackupPassphrase
+	}
+	plaintext, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+	f := &backupFile{
+		Version: backupVersion,
+		ScryptN: backupScryptN,
+		ScryptR: backupScryptR,
+		ScryptP: backupScryptP,
+		Salt:    make([]byte, 32),
+	}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupAEAD(f, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return nil, err
+	}
+	f.Ciphertext = aead.Seal(nil, f.Nonce, plaintext, nil)
+	return json.MarshalIndent(f, "", "  ")
+}
+
+func openBackup(data []byte, passphrase string) (*backupBundle, error) {
+	f := &backupFile{}
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, fmt.Errorf("not a backup file: %v", err)
+	}
+	if f.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported backup version %d", f.Version)
+	}
+	aead, err := backupAEAD(f, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return nil, ErrBackupPassphrase
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrBackupPassphrase
+	}
+	bundle := &backupBundle{}
+	if err := json.Unmarshal(plaintext, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func backupAEAD(f *backupFile, passphrase string) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), f.Salt, f.ScryptN, f.ScryptR, f.ScryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
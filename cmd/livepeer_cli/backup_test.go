@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	lpcommon "github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/pm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func TestSealOpenBackup(t *testing.T) {
+	defer func(n int) { backupScryptN = n }(backupScryptN)
+	backupScryptN = 1 << 10
+
+	bundle := &backupBundle{
+		Keys: map[string]json.RawMessage{"UTC--key": json.RawMessage(`{"address":"0102"}`)},
+		WinningTickets: []*lpcommon.DBWinningTicket{{
+			SessionID: "session",
+			Ticket: &pm.Ticket{
+				Sender:            ethcommon.HexToAddress("0x1"),
+				Recipient:         ethcommon.HexToAddress("0x2"),
+				FaceValue:         big.NewInt(1234),
+				WinProb:           big.NewInt(5678),
+				SenderNonce:       3,
+				RecipientRandHash: ethcommon.HexToHash("0x4"),
+			},
+			Sig:           []byte("sig"),
+			RecipientRand: big.NewInt(42),
+		}},
+		Orchestrators: []*lpcommon.DBOrch{lpcommon.NewDBOrch("https://127.0.0.1:8935", "0x5")},
+	}
+	data, err := sealBackup(bundle, "passphrase")
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), "session")
+
+	opened, err := openBackup(data, "passphrase")
+	require.Nil(t, err)
+	assert.Equal(t, bundle.Keys, opened.Keys)
+	assert.Equal(t, bundle.WinningTickets, opened.WinningTickets)
+	assert.Equal(t, bundle.Orchestrators, opened.Orchestrators)
+
+	_, err = openBackup(data, "wrong")
+	assert.Equal(t, ErrBackupPassphrase, err)
+
+	_, err = openBackup([]byte("not json"), "passphrase")
+	assert.NotNil(t, err)
+
+	_, err = sealBackup(bundle, "")
+	assert.Equal(t, ErrEmptyBackupPassphrase, err)
+}
+
+func TestBackupPassphrase_Empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backup")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	passphraseFile := filepath.Join(dir, "passphrase")
+	require.Nil(t, ioutil.WriteFile(passphraseFile, []byte("\n"), 0600))
+
+	_, err = backupPassphrase(backupContext(t, "--passphraseFile", passphraseFile), true)
+	assert.Equal(t, ErrEmptyBackupPassphrase, err)
+	// so no backup is written
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "keystore"), 0700))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "keystore", "UTC--key"), []byte(`{"address":"0102"}`), 0600))
+	file := filepath.Join(dir, "backup.json")
+	_, err = backupCommand(backupContext(t, "--datadir", dir, "--file", file, "--passphraseFile", passphraseFile), nil)
+	assert.Equal(t, ErrEmptyBackupPassphrase, err)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func backupContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("backup", flag.ContinueOnError)
+	for _, f := range backupFlags() {
+		f.Apply(set)
+	}
+	require.Nil(t, set.Parse(args))
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestBackupRestore_EncryptedDB(t *testing.T) {
+	defer func(n int) { backupScryptN = n }(backupScryptN)
+	backupScryptN = 1 << 10
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "backup")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	require.Nil(os.MkdirAll(src, 0700))
+	passphraseFile, file := filepath.Join(dir, "passphrase"), filepath.Join(dir, "backup.json")
+	require.Nil(ioutil.WriteFile(passphraseFile, []byte("passphrase"), 0600))
+	key := pm.RandBytes(32)
+	keyCommand := "echo " + hex.EncodeToString(key)
+
+	db, err := lpcommon.InitDB(filepath.Join(src, "lp.sqlite3"))
+	require.Nil(err)
+	require.Nil(db.SetEncryptionKey(key))
+	ticket := &pm.Ticket{
+		Sender:            ethcommon.HexToAddress("0x1"),
+		Recipient:         ethcommon.HexToAddress("0x2"),
+		FaceValue:         big.NewInt(1234),
+		WinProb:           big.NewInt(5678),
+		SenderNonce:       3,
+		RecipientRandHash: ethcommon.HexToHash("0x4"),
+	}
+	require.Nil(db.StoreWinningTicket("session", ticket, []byte("sig"), big.NewInt(42)))
+	db.Close()
+
+	// Encrypted DBs aren't backed up without their key
+	_, err = backupCommand(backupContext(t, "--datadir", src, "--file", file, "--passphraseFile", passphraseFile), nil)
+	assert.NotNil(err)
+	res, err := backupCommand(backupContext(t, "--datadir", src, "--file", file, "--passphraseFile", passphraseFile, "--dbKeyCommand", keyCommand), nil)
+	require.Nil(err)
+	assert.Equal(1, res.(*backupResult).WinningTickets)
+
+	// nor restored without it
+	_, err = restoreCommand(backupContext(t, "--datadir", dst, "--file", file, "--passphraseFile", passphraseFile), nil)
+	assert.NotNil(err)
+	res, err = restoreCommand(backupContext(t, "--datadir", dst, "--file", file, "--passphraseFile", passphraseFile, "--dbKeyCommand", keyCommand), nil)
+	require.Nil(err)
+	assert.Equal(1, res.(*backupResult).WinningTickets)
+
+	// The restored tickets are encrypted with the key
+	db, err = lpcommon.InitDB(filepath.Join(dst, "lp.sqlite3"))
+	require.Nil(err)
+	defer db.Close()
+	encrypted, err := db.Encrypted()
+	require.Nil(err)
+	assert.True(encrypted)
+	_, err = db.AllWinningTickets()
+	assert.NotNil(err)
+	require.Nil(db.SetEncryptionKey(key))
+	tickets, err := db.AllWinningTickets()
+	require.Nil(err)
+	require.Len(tickets, 1)
+	assert.Equal(ticket, tickets[0].Ticket)
+	assert.Equal(big.NewInt(42), tickets[0].RecipientRand)
+
+	// Each backup and restore is recorded in the audit log of the node, as
+	// it's requested and once it's done
+	auditStatuses := func(db *lpcommon.DB, action string) []int {
+		entries, err := db.AuditLog(time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+		require.Nil(err)
+		var statuses []int
+		for _, e := range entries {
+			assert.Equal(action, e.Action)
+			assert.Contains(e.Params, file)
+			statuses = append(statuses, e.Status)
+		}
+		return statuses
+	}
+	assert.ElementsMatch([]int{0, http.StatusInternalServerError, 0, http.StatusOK}, auditStatuses(db, "restore"))
+	srcDB, err := lpcommon.InitDB(filepath.Join(src, "lp.sqlite3"))
+	require.Nil(err)
+	defer srcDB.Close()
+	assert.ElementsMatch([]int{0, http.StatusInternalServerError, 0, http.StatusOK}, auditStatuses(srcDB, "backup"))
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	lpcommon "github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/eth"
+	lpTypes "github.com/livepeer/go-livepeer/eth/types"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// commands are the non-interactive alternatives to the wizard. Each prints
+// its result to stdout as JSON, or {"error": ...} and exits with status 1 if
+// it fails.
+func commands() []cli.Command {
+	return []cli.Command{
+		{
+			Name:  "status",
+			Usage: "show the status of the node",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the status as JSON instead of tables",
+				},
+			},
+			Action: command(statusCommand),
+		},
+		{
+			Name:  "payments",
+			Usage: "show the deposit, pending winning tickets, redemptions and spend of the node",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "window",
+					Usage: "how far back to report redemptions and spend, e.g. 24h or 7d",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the payments as JSON instead of tables",
+				},
+			},
+			Action: command(paymentsCommand),
+		},
+		{
+			Name:  "bond",
+			Usage: "bond LPT to an orchestrator",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "amount",
+					Usage: "amount to bond in LPT base units",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "address of the orchestrator to bond to",
+				},
+			},
+			Action: command(bondCommand),
+		},
+		{
+			Name:  "unbond",
+			Usage: "unbond LPT",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "amount",
+					Usage: "amount to unbond in LPT base units",
+				},
+			},
+			Action: command(unbondCommand),
+		},
+		{
+			Name:  "set-price",
+			Usage: "set the price per segment of an orchestrator, or the max price per segment of a broadcaster",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "price",
+					Usage: "price per segment in wei",
+				},
+				cli.StringFlag{
+					Name:  "rewardCut",
+					Usage: "orchestrator only; block reward cut percentage, unchanged if not set",
+				},
+				cli.StringFlag{
+					Name:  "feeShare",
+					Usage: "orchestrator only; fee share percentage, unchanged if not set",
+				},
+				cli.StringFlag{
+					Name:  "serviceURI",
+					Usage: "orchestrator only; public URI of the node, unchanged if not set",
+				},
+				cli.StringFlag{
+					Name:  "transcodingOptions",
+					Usage: "broadcaster only; comma separated video profiles, unchanged if not set",
+				},
+			},
+			Action: command(setPriceCommand),
+		},
+		{
+			Name:   "backup",
+			Usage:  "write the keys and unredeemed tickets of a node on this machine to an encrypted backup file",
+			Flags:  backupFlags(),
+			Action: command(backupCommand),
+		},
+		{
+			Name:   "restore",
+			Usage:  "restore a backup file into the data directory of a node on this machine, while the node is stopped",
+			Flags:  backupFlags(),
+			Action: command(restoreCommand),
+		},
+	}
+}
+
+// command runs fn against the node given by the global flags and prints its
+// result
+func command(fn func(c *cli.Context, w *wizard) (interface{}, error)) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		w := newWizard(c.GlobalString("host"), c.GlobalString("http"))
+		res, err := fn(c, w)
+		if err != nil {
+			printJSON(map[string]string{"error": err.Error()})
+			return cli.NewExitError("", 1)
+		}
+		if res != nil {
+			printJSON(res)
+		}
+		return nil
+	}
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+type statusOutput struct {
+	Orchestrator bool            `json:"orchestrator"`
+	EthAddr      string          `json:"ethAddr"`
+	TokenBalance string          `json:"tokenBalance"`
+	EthBalance   string          `json:"ethBalance"`
+	CurrentRound string          `json:"currentRound"`
+	Node         json.RawMessage `json:"node"`
+}
+
+func statusCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	node, err := w.getJSON("/status")
+	if err != nil {
+		return nil, err
+	}
+	w.orchestrator = w.isOrchestrator()
+	if !c.Bool("json") {
+		w.stats(w.orchestrator)
+		return nil, nil
+	}
+	return &statusOutput{
+		Orchestrator: w.orchestrator,
+		EthAddr:      httpGet(w.nodeURL("/ethAddr")),
+		TokenBalance: httpGet(w.nodeURL("/tokenBalance")),
+		EthBalance:   httpGet(w.nodeURL("/ethBalance")),
+		CurrentRound: httpGet(w.nodeURL("/currentRound")),
+		Node:         node,
+	}, nil
+}
+
+func paymentsCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	if !c.Bool("json") {
+		return nil, w.paymentStats(c.String("window"))
+	}
+	return w.getPayments(c.String("window"))
+}
+
+func bondCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	amount, err := requiredBigInt(c, "amount")
+	if err != nil {
+		return nil, err
+	}
+	to := c.String("to")
+	if !common.IsHexAddress(to) {
+		return nil, fmt.Errorf("invalid --to address %q", to)
+	}
+	val := url.Values{
+		"amount": {amount.String()},
+		"toAddr": {common.HexToAddress(to).Hex()},
+	}
+	if err := w.postForm("/bond", val); err != nil {
+		return nil, err
+	}
+	return params(val), nil
+}
+
+func unbondCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	amount, err := requiredBigInt(c, "amount")
+	if err != nil {
+		return nil, err
+	}
+	val := url.Values{"amount": {amount.String()}}
+	if err := w.postForm("/unbond", val); err != nil {
+		return nil, err
+	}
+	return params(val), nil
+}
+
+func setPriceCommand(c *cli.Context, w *wizard) (interface{}, error) {
+	price, err := requiredBigInt(c, "price")
+	if err != nil {
+		return nil, err
+	}
+
+	if w.isOrchestrator() {
+		t, err := w.getOrchestratorInfo()
+		if err != nil {
+			return nil, fmt.Errorf("error getting orchestrator info: %v", err)
+		}
+		val := orchestratorConfigParams(t, price, c.String("rewardCut"), c.String("feeShare"), c.String("serviceURI"))
+		if err := w.postForm("/setOrchestratorConfig", val); err != nil {
+			return nil, err
+		}
+		return params(val), nil
+	}
+
+	transOpts := c.String("transcodingOptions")
+	if transOpts == "" {
+		if _, transOpts = w.getBroadcastConfig(); transOpts == "" {
+			return nil, errors.New("error getting the current transcoding options")
+		}
+	}
+	val := url.Values{
+		"maxPricePerSegment": {price.String()},
+		"transcodingOptions": {transOpts},
+	}
+	if err := w.postForm("/setBroadcastConfig", val); err != nil {
+		return nil, err
+	}
+	return params(val), nil
+}
+
+// orchestratorConfigParams are the params for /setOrchestratorConfig that
+// change the price per segment, keeping the pending config of t for the
+// settings that are empty
+func orchestratorConfigParams(t lpTypes.Transcoder, price *big.Int, rewardCut, feeShare, serviceURI string) url.Values {
+	if rewardCut == "" {
+		rewardCut = fmt.Sprintf("%v", eth.ToPerc(t.PendingRewardCut))
+	}
+	if feeShare == "" {
+		feeShare = fmt.Sprintf("%v", eth.ToPerc(t.PendingFeeShare))
+	}
+	if serviceURI == "" {
+		serviceURI = t.ServiceURI
+	}
+	return url.Values{
+		"blockRewardCut":  {rewardCut},
+		"feeShare":        {feeShare},
+		"pricePerSegment": {price.String()},
+		"serviceURI":      {serviceURI},
+	}
+}
+
+// params flattens the form sent to the node, to print it as the result
+func params(val url.Values) map[string]string {
+	m := make(map[string]string, len(val))
+	for k := range val {
+		m[k] = val.Get(k)
+	}
+	return m
+}
+
+func requiredBigInt(c *cli.Context, name string) (*big.Int, error) {
+	v := c.String(name)
+	if v == "" {
+		return nil, fmt.Errorf("missing --%s", name)
+	}
+	i, err := lpcommon.ParseBigInt(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %v", name, err)
+	}
+	return i, nil
+}
+
+func (w *wizard) nodeURL(path string) string {
+	return fmt.Sprintf("http://%v:%v%v", w.host, w.httpPort, path)
+}
+
+func (w *wizard) getJSON(path string) (json.RawMessage, error) {
+	resp, err := http.Get(w.nodeURL(path))
+	if err != nil {
+		return nil, fmt.Errorf("cannot reach the node on %v:%v: %v", w.host, w.httpPort, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.RawMessage(body), nil
+}
+
+// postForm is like httpPostWithParams, but fails unless the node responds
+// with 200
+func (w *wizard) postForm(path string, val url.Values) error {
+	resp, err := http.PostForm(w.nodeURL(path), val)
+	if err != nil {
+		return fmt.Errorf("cannot reach the node on %v:%v: %v", w.host, w.httpPort, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
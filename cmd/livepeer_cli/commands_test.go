@@ -0,0 +1,32 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	lpTypes "github.com/livepeer/go-livepeer/eth/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrchestratorConfigParams(t *testing.T) {
+	assert := assert.New(t)
+
+	orch := lpTypes.Transcoder{
+		ServiceURI:       "https://127.0.0.1:8935",
+		PendingRewardCut: big.NewInt(100000),
+		PendingFeeShare:  big.NewInt(50000),
+	}
+
+	// Keeps the pending config
+	val := orchestratorConfigParams(orch, big.NewInt(7), "", "", "")
+	assert.Equal("10", val.Get("blockRewardCut"))
+	assert.Equal("5", val.Get("feeShare"))
+	assert.Equal("7", val.Get("pricePerSegment"))
+	assert.Equal("https://127.0.0.1:8935", val.Get("serviceURI"))
+
+	// Overrides
+	val = orchestratorConfigParams(orch, big.NewInt(7), "20", "2.5", "https://orch.example.com:8935")
+	assert.Equal("20", val.Get("blockRewardCut"))
+	assert.Equal("2.5", val.Get("feeShare"))
+	assert.Equal("https://orch.example.com:8935", val.Get("serviceURI"))
+}
@@ -29,12 +29,25 @@ func main() {
 			Usage: "host for the Livepeer node",
 			Value: "localhost",
 		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "CLI token of the Livepeer node, if it requires one",
+			EnvVar: "LIVEPEER_CLI_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "socket",
+			Usage: "CLI socket of the Livepeer node, to connect over instead of the port",
+		},
 		cli.IntFlag{
 			Name:  "loglevel",
 			Value: 4,
 			Usage: "log level to emit to the screen",
 		},
 	}
+	app.Before = func(c *cli.Context) error {
+		http.DefaultClient.Transport = newNodeTransport(c.String("token"), c.String("socket"))
+		return nil
+	}
 	app.Action = func(c *cli.Context) error {
 		if c.Bool("version") {
 			fmt.Println("Version: " + core.LivepeerVersion)
@@ -45,18 +58,14 @@ func main() {
 		rand.Seed(time.Now().UnixNano())
 
 		// Start the wizard and relinquish control
-		w := &wizard{
-			endpoint: fmt.Sprintf("http://%v:%v/status", c.String("host"), c.String("http")),
-			httpPort: c.String("http"),
-			host:     c.String("host"),
-			in:       bufio.NewReader(os.Stdin),
-		}
+		w := newWizard(c.String("host"), c.String("http"))
 		w.orchestrator = w.isOrchestrator()
 		w.testnet = w.onTestnet()
 		w.run()
 
 		return nil
 	}
+	app.Commands = commands()
 	app.Version = core.LivepeerVersion
 	app.Run(os.Args)
 }
@@ -70,6 +79,15 @@ type wizard struct {
 	in           *bufio.Reader // Wrapper around stdin to allow reading user input
 }
 
+func newWizard(host, httpPort string) *wizard {
+	return &wizard{
+		endpoint: fmt.Sprintf("http://%v:%v/status", host, httpPort),
+		httpPort: httpPort,
+		host:     host,
+		in:       bufio.NewReader(os.Stdin),
+	}
+}
+
 type wizardOpt struct {
 	desc            string
 	invoke          func()
@@ -82,6 +100,7 @@ func (w *wizard) initializeOptions() []wizardOpt {
 	options := []wizardOpt{
 		{desc: "Get node status", invoke: func() { w.stats(w.orchestrator) }},
 		{desc: "View protocol parameters", invoke: w.protocolStats},
+		{desc: "View payments", invoke: w.payments},
 		{desc: "List registered orchestrators", invoke: func() { w.registeredOrchestratorStats() }},
 		{desc: "Invoke \"initialize round\"", invoke: w.initializeRound},
 		{desc: "Invoke \"bond\"", invoke: w.bond},
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+// nodeTransport authenticates the requests to the node with its CLI token,
+// and sends them over its CLI socket if it's given one
+type nodeTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func newNodeTransport(token, socket string) http.RoundTripper {
+	base := http.DefaultTransport
+	if socket != "" {
+		base = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	return &nodeTransport{token: token, base: base}
+}
+
+func (t *nodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.base.RoundTrip(req)
+}
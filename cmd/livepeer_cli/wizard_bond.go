@@ -10,7 +10,7 @@ import (
 	"os"
 	"strconv"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 
 	"github.com/ethereum/go-ethereum/common"
 	lpcommon "github.com/livepeer/go-livepeer/common"
@@ -12,7 +12,7 @@ import (
 	"strings"
 	"text/tabwriter"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 func (w *wizard) allTranscodingOptions() map[int]string {
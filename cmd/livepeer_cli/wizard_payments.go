@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/eth"
+	"github.com/olekukonko/tablewriter"
+)
+
+type paymentsTotal struct {
+	Tickets int
+	Amount  *big.Int
+}
+
+// paymentsStatus is the response of /payments
+type paymentsStatus struct {
+	From, To       time.Time
+	Deposit        *big.Int
+	Reserve        *big.Int
+	PendingTickets paymentsTotal
+	Pending        []struct {
+		CreatedAt time.Time
+		Sender    string
+		FaceValue *big.Int
+		SessionID string
+	}
+	RedeemedTickets paymentsTotal
+	Redemptions     []struct {
+		CreatedAt time.Time
+		Sender    string
+		FaceValue *big.Int
+		TxHash    string
+	}
+	SpendByOrchestrator map[string]paymentsTotal
+}
+
+func (w *wizard) getPayments(window string) (json.RawMessage, error) {
+	path := "/payments"
+	if window != "" {
+		path += "?" + url.Values{"window": {window}}.Encode()
+	}
+	return w.getJSON(path)
+}
+
+func (w *wizard) payments() {
+	fmt.Printf("Window of the redemptions and spend, e.g. 24h or 7d (default 24h) - ")
+	window := w.readDefaultString("24h")
+	if err := w.paymentStats(window); err != nil {
+		glog.Errorf("Error getting payments: %v", err)
+	}
+}
+
+// paymentStats prints the payment state of the node, with the redemptions
+// and spend over window
+func (w *wizard) paymentStats(window string) error {
+	data, err := w.getPayments(window)
+	if err != nil {
+		return err
+	}
+	var p paymentsStatus
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+
+	fmt.Println("+--------+")
+	fmt.Println("|PAYMENTS|")
+	fmt.Println("+--------+")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	if p.Deposit != nil {
+		table.Append([]string{"Deposit", eth.FormatUnits(p.Deposit, "ETH")})
+		table.Append([]string{"Reserve", eth.FormatUnits(p.Reserve, "ETH")})
+	}
+	table.Append([]string{"Pending Winning Tickets", strconv.Itoa(p.PendingTickets.Tickets)})
+	table.Append([]string{"Pending Face Value", eth.FormatUnits(p.PendingTickets.Amount, "ETH")})
+	table.Append([]string{"Redeemed Tickets Since " + p.From.Format(time.RFC3339), strconv.Itoa(p.RedeemedTickets.Tickets)})
+	table.Append([]string{"Redeemed Face Value", eth.FormatUnits(p.RedeemedTickets.Amount, "ETH")})
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetCenterSeparator("*")
+	table.SetRowLine(true)
+	table.SetColumnSeparator("|")
+	table.Render()
+
+	if len(p.Pending) > 0 {
+		fmt.Println("Pending winning tickets")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Received", "Sender", "Face Value"})
+		for _, t := range p.Pending {
+			table.Append([]string{t.CreatedAt.Format(time.RFC3339), t.Sender, eth.FormatUnits(t.FaceValue, "ETH")})
+		}
+		table.Render()
+	}
+
+	if len(p.Redemptions) > 0 {
+		fmt.Println("Redemptions")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Submitted", "Sender", "Face Value", "Tx"})
+		for _, r := range p.Redemptions {
+			table.Append([]string{r.CreatedAt.Format(time.RFC3339), r.Sender, eth.FormatUnits(r.FaceValue, "ETH"), r.TxHash})
+		}
+		table.Render()
+	}
+
+	if len(p.SpendByOrchestrator) > 0 {
+		fmt.Println("Spend by orchestrator")
+		orchs := make([]string, 0, len(p.SpendByOrchestrator))
+		for orch := range p.SpendByOrchestrator {
+			orchs = append(orchs, orch)
+		}
+		sort.Strings(orchs)
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Orchestrator", "Tickets", "Expected Value"})
+		for _, orch := range orchs {
+			spend := p.SpendByOrchestrator[orch]
+			table.Append([]string{orch, strconv.Itoa(spend.Tickets), eth.FormatUnits(spend.Amount, "ETH")})
+		}
+		table.Render()
+	}
+	return nil
+}
@@ -7,7 +7,7 @@ import (
 	"os/exec"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 func (w *wizard) stream() {
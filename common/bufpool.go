@@ -0,0 +1,65 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Buffers for segment data are pooled across the ingest, transcode and upload
+// of segments, rather than allocated, grown and collected for every one
+
+// CopyBufferSize is the size of the buffers segments are copied through
+const CopyBufferSize = 32 * 1024
+
+// maxPooledBufferSize is the capacity above which buffers aren't returned to
+// the pool, so that one unusually large segment isn't held onto for good
+const maxPooledBufferSize = 16 * 1024 * 1024
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+var copyBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, CopyBufferSize)
+		return &buf
+	},
+}
+
+// GetBuffer returns an empty buffer from the pool. It should be returned with
+// PutBuffer once its contents are no longer used.
+func GetBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns buf to the pool. Neither buf nor anything read from its
+// Bytes may be used afterwards.
+func PutBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// ReadAll reads r until EOF like ioutil.ReadAll, through a pooled buffer, so
+// that the data returned is the only allocation rather than every buffer it
+// was grown through
+func ReadAll(r io.Reader) ([]byte, error) {
+	buf := GetBuffer()
+	defer PutBuffer(buf)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+	return data, nil
+}
+
+// CopyBuffer copies src to dst through a pooled buffer of CopyBufferSize
+func CopyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBufferPool.Get().(*[]byte)
+	defer copyBufferPool.Put(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadAll(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data, err := ReadAll(strings.NewReader("foo"))
+	require.Nil(err)
+	assert.Equal([]byte("foo"), data)
+	assert.Equal(3, cap(data))
+
+	// The data is copied out of the buffer, which is reused
+	again, err := ReadAll(strings.NewReader("bar"))
+	require.Nil(err)
+	assert.Equal([]byte("foo"), data)
+	assert.Equal([]byte("bar"), again)
+
+	_, err = ReadAll(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("foo"))))
+	assert.Equal(iotest.ErrTimeout, err)
+}
+
+func TestBufferPool(t *testing.T) {
+	assert := assert.New(t)
+
+	buf := GetBuffer()
+	buf.WriteString("foo")
+	PutBuffer(buf)
+	// Buffers come back empty
+	assert.Zero(GetBuffer().Len())
+
+	assert.NotPanics(func() { PutBuffer(nil) })
+}
+
+func TestCopyBuffer(t *testing.T) {
+	assert := assert.New(t)
+	var dst bytes.Buffer
+	n, err := CopyBuffer(&dst, iotest.OneByteReader(strings.NewReader("foobar")))
+	assert.Nil(err)
+	assert.Equal(int64(6), n)
+	assert.Equal("foobar", dst.String())
+
+	_, err = CopyBuffer(&dst, errReader{})
+	assert.NotNil(err)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) { return 0, errors.New("read error") }
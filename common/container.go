@@ -0,0 +1,467 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Segments are untrusted input to the native decoder. Their container is
+// checked here first, and anything that isn't plainly a well formed MPEG-TS
+// or MP4 segment is rejected before it's transcoded.
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+
+	// MaxContainerStreams is the number of elementary streams, or tracks, a
+	// segment may have
+	MaxContainerStreams = 8
+	// maxTSPrograms is the number of programs a TS segment's PAT may list
+	maxTSPrograms = 4
+	// maxMP4Boxes is the number of boxes an MP4 segment may have in all
+	maxMP4Boxes = 4096
+	// maxMP4BoxDepth is how deeply MP4 boxes may be nested
+	maxMP4BoxDepth = 16
+	// maxMP4MetadataSize is the size of the moov and moof boxes, which are
+	// read into memory to check the boxes within them
+	maxMP4MetadataSize = 8 * 1024 * 1024
+)
+
+// ContainerError is why the container of a segment was rejected
+type ContainerError struct {
+	// Format is ts or mp4, or empty if it wasn't recognized
+	Format string
+	// Offset is where in the segment the problem is
+	Offset int64
+	Reason string
+}
+
+func (e *ContainerError) Error() string {
+	if e.Format == "" {
+		return fmt.Sprintf("invalid segment: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid %s segment at byte %d: %s", e.Format, e.Offset, e.Reason)
+}
+
+// ValidateContainer checks that the size bytes of r are an MPEG-TS or MP4
+// segment: the structure of the container is sound and it has no more than
+// MaxContainerStreams streams. The segment is read as a stream, in bounded
+// memory. Returns a *ContainerError for segments that aren't, or the error
+// reading them.
+func ValidateContainer(r io.Reader, size int64) error {
+	br := bufio.NewReaderSize(r, 32*1024)
+	head, err := br.Peek(8)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	switch {
+	case len(head) > 0 && head[0] == tsSyncByte:
+		return validateTS(br, size)
+	case len(head) == 8 && isMP4Brand(string(head[4:8])):
+		return validateMP4(br, size)
+	}
+	return &ContainerError{Reason: "neither MPEG-TS nor MP4"}
+}
+
+func isMP4Brand(box string) bool {
+	return box == "ftyp" || box == "styp"
+}
+
+// tsValidator is the state of the check of a TS segment, packet by packet
+type tsValidator struct {
+	pmtPIDs map[uint16]bool
+	esPIDs  map[uint16]bool
+	pes     int
+	offset  int64
+}
+
+func validateTS(r io.Reader, size int64) error {
+	if size <= 0 || size%tsPacketSize != 0 {
+		return &ContainerError{Format: "ts", Reason: fmt.Sprintf("size %d isn't a whole number of packets", size)}
+	}
+	v := &tsValidator{pmtPIDs: make(map[uint16]bool), esPIDs: make(map[uint16]bool)}
+	pkt := make([]byte, tsPacketSize)
+	for v.offset = 0; v.offset < size; v.offset += tsPacketSize {
+		if _, err := io.ReadFull(r, pkt); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return v.fail("segment is shorter than its size")
+			}
+			return err
+		}
+		if err := v.packet(pkt); err != nil {
+			return err
+		}
+	}
+	switch {
+	case len(v.pmtPIDs) == 0:
+		return v.fail("no PAT")
+	case len(v.esPIDs) == 0:
+		return v.fail("no PMT")
+	case v.pes == 0:
+		return v.fail("no PES packets")
+	}
+	return nil
+}
+
+func (v *tsValidator) fail(format string, args ...interface{}) error {
+	return &ContainerError{Format: "ts", Offset: v.offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (v *tsValidator) packet(pkt []byte) error {
+	if pkt[0] != tsSyncByte {
+		return v.fail("lost packet sync")
+	}
+	if pkt[1]&0x80 != 0 {
+		return v.fail("transport error indicator set")
+	}
+	start := pkt[1]&0x40 != 0
+	pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+	control := (pkt[3] >> 4) & 0x3
+	if control == 0 {
+		return v.fail("reserved adaptation field control")
+	}
+
+	payload := pkt[4:]
+	if control&0x2 != 0 {
+		length := int(pkt[4])
+		if control == 0x2 && length != 183 || control == 0x3 && length > 182 {
+			return v.fail("adaptation field length %d", length)
+		}
+		payload = pkt[5+length:]
+	}
+	if control&0x1 == 0 || pid == tsNullPID {
+		return nil
+	}
+
+	switch {
+	case pid == 0:
+		if start {
+			return v.pat(payload)
+		}
+	case v.pmtPIDs[pid]:
+		if start {
+			return v.pmt(payload)
+		}
+	case v.esPIDs[pid]:
+		if start {
+			return v.pesHeader(payload)
+		}
+	}
+	// Other PIDs, such as the SDT, aren't decoded
+	return nil
+}
+
+// section returns the PSI section starting in payload, which must fit in
+// the packet and whose CRC must match
+func (v *tsValidator) section(payload []byte, table byte) ([]byte, error) {
+	if len(payload) < 1 {
+		return nil, v.fail("empty PSI payload")
+	}
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil, v.fail("PSI pointer field %d out of bounds", pointer)
+	}
+	s := payload[1+pointer:]
+	if s[0] != table {
+		return nil, v.fail("table ID %#x, expected %#x", s[0], table)
+	}
+	if s[1]&0x80 == 0 {
+		return nil, v.fail("section syntax indicator not set")
+	}
+	length := int(s[1]&0x0f)<<8 | int(s[2])
+	if length < 9 || length > 1021 {
+		return nil, v.fail("section length %d", length)
+	}
+	if 3+length > len(s) {
+		return nil, v.fail("section of %d bytes spans packets", length)
+	}
+	s = s[:3+length]
+	if crc32MPEG2(s) != 0 {
+		return nil, v.fail("section CRC mismatch")
+	}
+	// Without the header and CRC
+	return s[8 : len(s)-4], nil
+}
+
+func (v *tsValidator) pat(payload []byte) error {
+	programs, err := v.section(payload, 0x00)
+	if err != nil {
+		return err
+	}
+	if len(programs)%4 != 0 {
+		return v.fail("PAT of %d bytes", len(programs))
+	}
+	pids := make(map[uint16]bool)
+	for i := 0; i < len(programs); i += 4 {
+		number := binary.BigEndian.Uint16(programs[i:])
+		pid := binary.BigEndian.Uint16(programs[i+2:]) & 0x1fff
+		if number == 0 {
+			// The network PID
+			continue
+		}
+		if pid == 0 || pid == tsNullPID {
+			return v.fail("PMT on reserved PID %#x", pid)
+		}
+		pids[pid] = true
+	}
+	if len(pids) == 0 || len(pids) > maxTSPrograms {
+		return v.fail("PAT lists %d programs", len(pids))
+	}
+	v.pmtPIDs = pids
+	return nil
+}
+
+func (v *tsValidator) pmt(payload []byte) error {
+	body, err := v.section(payload, 0x02)
+	if err != nil {
+		return err
+	}
+	if len(body) < 4 {
+		return v.fail("PMT of %d bytes", len(body))
+	}
+	infoLength := int(binary.BigEndian.Uint16(body[2:]) & 0x0fff)
+	if 4+infoLength > len(body) {
+		return v.fail("program info length %d out of bounds", infoLength)
+	}
+	streams := body[4+infoLength:]
+	pids := make(map[uint16]bool)
+	for len(streams) > 0 {
+		if len(streams) < 5 {
+			return v.fail("truncated PMT stream entry")
+		}
+		pid := binary.BigEndian.Uint16(streams[1:]) & 0x1fff
+		esInfoLength := int(binary.BigEndian.Uint16(streams[3:]) & 0x0fff)
+		if 5+esInfoLength > len(streams) {
+			return v.fail("ES info length %d out of bounds", esInfoLength)
+		}
+		if pid == 0 || pid == tsNullPID || v.pmtPIDs[pid] {
+			return v.fail("elementary stream on reserved PID %#x", pid)
+		}
+		pids[pid] = true
+		streams = streams[5+esInfoLength:]
+	}
+	if len(pids) == 0 {
+		return v.fail("PMT lists no streams")
+	}
+	for pid := range pids {
+		v.esPIDs[pid] = true
+	}
+	if len(v.esPIDs) > MaxContainerStreams {
+		return v.fail("%d streams, more than %d", len(v.esPIDs), MaxContainerStreams)
+	}
+	return nil
+}
+
+func (v *tsValidator) pesHeader(payload []byte) error {
+	if len(payload) < 6 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return v.fail("PES packet without a start code")
+	}
+	streamID := payload[3]
+	if streamID < 0xbc {
+		return v.fail("PES stream ID %#x", streamID)
+	}
+	v.pes++
+	switch streamID {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		// Streams without the optional PES header
+		return nil
+	}
+	if len(payload) < 9 {
+		return v.fail("truncated PES header")
+	}
+	if payload[6]&0xc0 != 0x80 {
+		return v.fail("PES header marker bits")
+	}
+	headerLength := int(payload[8])
+	if 9+headerLength > len(payload) {
+		return v.fail("PES header length %d out of bounds", headerLength)
+	}
+	switch payload[7] >> 6 {
+	case 0x1:
+		return v.fail("PES has a DTS without a PTS")
+	case 0x2:
+		if headerLength < 5 {
+			return v.fail("PES header too short for its PTS")
+		}
+	case 0x3:
+		if headerLength < 10 {
+			return v.fail("PES header too short for its PTS and DTS")
+		}
+	}
+	return nil
+}
+
+// crc32MPEG2 is the CRC of PSI sections; 0 over a section including its CRC
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// mp4Containers are the boxes whose payload is only more boxes
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"dinf": true, "edts": true, "mvex": true, "moof": true, "traf": true,
+}
+
+// mp4Validator is the state of the check of an MP4 segment, box by box
+type mp4Validator struct {
+	boxes  int
+	tracks int
+	seen   map[string]bool
+}
+
+func validateMP4(r io.Reader, size int64) error {
+	v := &mp4Validator{seen: make(map[string]bool)}
+	var offset int64
+	var header [16]byte
+	for offset < size {
+		if size-offset < 8 {
+			return mp4Error(offset, "truncated box header")
+		}
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return mp4ReadError(offset, err)
+		}
+		boxSize, boxType := int64(binary.BigEndian.Uint32(header[:4])), string(header[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			// The box extends to the end of the segment
+			boxSize = size - offset
+		case 1:
+			if size-offset < 16 {
+				return mp4Error(offset, "truncated box header")
+			}
+			if _, err := io.ReadFull(r, header[8:16]); err != nil {
+				return mp4ReadError(offset, err)
+			}
+			headerSize = 16
+			large := binary.BigEndian.Uint64(header[8:16])
+			if large > uint64(size-offset) {
+				return mp4Error(offset, fmt.Sprintf("%s box of %d bytes overruns the segment", boxType, large))
+			}
+			boxSize = int64(large)
+		}
+		if err := v.box(offset, boxType, boxSize, headerSize, size-offset, 0); err != nil {
+			return err
+		}
+		if offset == 0 && !isMP4Brand(boxType) {
+			return mp4Error(offset, "doesn't start with ftyp or styp")
+		}
+
+		body := boxSize - headerSize
+		if boxType == "moov" || boxType == "moof" {
+			if body > maxMP4MetadataSize {
+				return mp4Error(offset, fmt.Sprintf("%s box of %d bytes", boxType, boxSize))
+			}
+			data := make([]byte, body)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return mp4ReadError(offset, err)
+			}
+			if err := v.children(offset+headerSize, data, 1); err != nil {
+				return err
+			}
+		} else if _, err := io.CopyN(ioutil.Discard, r, body); err != nil {
+			return mp4ReadError(offset, err)
+		}
+		offset += boxSize
+	}
+
+	switch {
+	case !v.seen["moov"] && !v.seen["moof"]:
+		return mp4Error(offset, "no moov or moof box")
+	case !v.seen["mdat"]:
+		return mp4Error(offset, "no mdat box")
+	case v.tracks == 0:
+		return mp4Error(offset, "no tracks")
+	}
+	return nil
+}
+
+// box checks the header of a box at offset within a parent with remaining
+// bytes left
+func (v *mp4Validator) box(offset int64, boxType string, boxSize, headerSize, remaining int64, depth int) error {
+	for _, c := range []byte(boxType) {
+		if c < 0x20 || c > 0x7e {
+			return mp4Error(offset, fmt.Sprintf("box type %q", boxType))
+		}
+	}
+	if boxSize < headerSize || boxSize > remaining {
+		return mp4Error(offset, fmt.Sprintf("%s box of %d bytes in %d", boxType, boxSize, remaining))
+	}
+	if v.boxes++; v.boxes > maxMP4Boxes {
+		return mp4Error(offset, fmt.Sprintf("more than %d boxes", maxMP4Boxes))
+	}
+	if depth > maxMP4BoxDepth {
+		return mp4Error(offset, "boxes nested too deeply")
+	}
+	if boxType == "trak" || boxType == "traf" {
+		if v.tracks++; v.tracks > MaxContainerStreams {
+			return mp4Error(offset, fmt.Sprintf("more than %d tracks", MaxContainerStreams))
+		}
+	}
+	if depth == 0 {
+		v.seen[boxType] = true
+	}
+	return nil
+}
+
+// children checks the boxes in data, the payload of a container box at
+// offset
+func (v *mp4Validator) children(offset int64, data []byte, depth int) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return mp4Error(offset, "truncated box header")
+		}
+		boxSize, boxType := int64(binary.BigEndian.Uint32(data[:4])), string(data[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return mp4Error(offset, "truncated box header")
+			}
+			large := binary.BigEndian.Uint64(data[8:16])
+			if large > uint64(len(data)) {
+				return mp4Error(offset, fmt.Sprintf("%s box of %d bytes overruns its parent", boxType, large))
+			}
+			boxSize, headerSize = int64(large), 16
+		}
+		if err := v.box(offset, boxType, boxSize, headerSize, int64(len(data)), depth); err != nil {
+			return err
+		}
+		if mp4Containers[boxType] {
+			if err := v.children(offset+headerSize, data[headerSize:boxSize], depth+1); err != nil {
+				return err
+			}
+		}
+		offset += boxSize
+		data = data[boxSize:]
+	}
+	return nil
+}
+
+func mp4Error(offset int64, reason string) error {
+	return &ContainerError{Format: "mp4", Offset: offset, Reason: reason}
+}
+
+func mp4ReadError(offset int64, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return mp4Error(offset, "segment is shorter than its size")
+	}
+	return err
+}
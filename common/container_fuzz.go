@@ -0,0 +1,21 @@
+//go:build gofuzz
+// +build gofuzz
+
+package common
+
+import "bytes"
+
+// Fuzz is the go-fuzz entry point for ValidateContainer:
+//
+//	go-fuzz-build github.com/livepeer/go-livepeer/common && go-fuzz -func Fuzz
+//
+// Inputs that validate are more interesting to the fuzzer than those rejected.
+func Fuzz(data []byte) int {
+	if err := ValidateContainer(bytes.NewReader(data), int64(len(data))); err != nil {
+		if _, ok := err.(*ContainerError); !ok {
+			panic(err)
+		}
+		return 0
+	}
+	return 1
+}
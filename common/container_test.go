@@ -0,0 +1,327 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPMTPID = 0x1000
+	testESPID  = 0x100
+)
+
+func tsPacket(pid uint16, start bool, payload []byte) []byte {
+	pkt := bytes.Repeat([]byte{0xff}, tsPacketSize)
+	pkt[0] = tsSyncByte
+	pkt[1] = byte(pid>>8) & 0x1f
+	if start {
+		pkt[1] |= 0x40
+	}
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10
+	copy(pkt[4:], payload)
+	return pkt
+}
+
+// psi is a PSI payload of a section of table with body, and its CRC
+func psi(table byte, id uint16, body []byte) []byte {
+	length := 5 + len(body) + 4
+	s := []byte{table, 0xb0 | byte(length>>8), byte(length), byte(id >> 8), byte(id), 0xc1, 0, 0}
+	s = append(s, body...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32MPEG2(s))
+	return append([]byte{0}, append(s, crc...)...)
+}
+
+func testPAT() []byte {
+	return psi(0x00, 1, []byte{0, 1, 0xe0 | testPMTPID>>8, testPMTPID & 0xff})
+}
+
+func testPMT(pids ...uint16) []byte {
+	body := []byte{0xe0 | testESPID>>8, testESPID & 0xff, 0xf0, 0}
+	for _, pid := range pids {
+		body = append(body, 0x1b, 0xe0|byte(pid>>8), byte(pid), 0xf0, 0)
+	}
+	return psi(0x02, 1, body)
+}
+
+func testPES() []byte {
+	return []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 5, 0x21, 0, 1, 0, 1}
+}
+
+func testTS() []byte {
+	var ts []byte
+	ts = append(ts, tsPacket(0, true, testPAT())...)
+	ts = append(ts, tsPacket(testPMTPID, true, testPMT(testESPID))...)
+	ts = append(ts, tsPacket(testESPID, true, testPES())...)
+	ts = append(ts, tsPacket(testESPID, false, nil)...)
+	return ts
+}
+
+func mp4Box(boxType string, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	box := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(box, uint32(8+len(payload)))
+	copy(box[4:], boxType)
+	return append(box, payload...)
+}
+
+func testMP4(tracks int) []byte {
+	var traks [][]byte
+	for i := 0; i < tracks; i++ {
+		traks = append(traks, mp4Box("trak", mp4Box("tkhd", make([]byte, 84))))
+	}
+	return bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom\x00\x00\x02\x00")),
+		mp4Box("moov", append([][]byte{mp4Box("mvhd", make([]byte, 100))}, traks...)...),
+		mp4Box("mdat", make([]byte, 1000)),
+	}, nil)
+}
+
+func validate(data []byte) error {
+	return ValidateContainer(bytes.NewReader(data), int64(len(data)))
+}
+
+func assertContainerError(t *testing.T, format string, reason string, err error) {
+	cerr, ok := err.(*ContainerError)
+	if !assert.True(t, ok, "expected a container error, got %v", err) {
+		return
+	}
+	assert.Equal(t, format, cerr.Format)
+	assert.Contains(t, cerr.Reason, reason)
+}
+
+func TestValidateContainer_TS(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validate(testTS()))
+	// The reader isn't assumed to fill reads
+	ts := testTS()
+	assert.Nil(ValidateContainer(iotest.OneByteReader(bytes.NewReader(ts)), int64(len(ts))))
+
+	// Other PIDs and null packets are skipped
+	assert.Nil(validate(append(testTS(), append(tsPacket(0x11, true, []byte{0xde, 0xad}), tsPacket(tsNullPID, false, nil)...)...)))
+
+	// An adaptation field without a payload
+	pkt := tsPacket(testESPID, false, nil)
+	pkt[3] = 0x20
+	pkt[4] = 183
+	assert.Nil(validate(append(testTS(), pkt...)))
+
+	assert.Equal("invalid ts segment at byte 376: section CRC mismatch", (&ContainerError{Format: "ts", Offset: 376, Reason: "section CRC mismatch"}).Error())
+}
+
+func TestValidateContainer_InvalidTS(t *testing.T) {
+	corrupt := func(offset int, b byte) []byte {
+		ts := testTS()
+		ts[offset] = b
+		return ts
+	}
+	var manyStreams []uint16
+	for i := 0; i <= MaxContainerStreams; i++ {
+		manyStreams = append(manyStreams, uint16(testESPID+1+i))
+	}
+	truncatedPES := testTS()
+	copy(truncatedPES[2*tsPacketSize+4:], []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x80, 0xb4})
+	dtsOnly := testTS()
+	dtsOnly[2*tsPacketSize+4+7] = 0x40
+	shortPTS := testTS()
+	shortPTS[2*tsPacketSize+4+8] = 2
+
+	tests := []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"partial packet", testTS()[:tsPacketSize*3+100], "whole number of packets"},
+		{"lost sync", corrupt(tsPacketSize*3, 0x48), "lost packet sync"},
+		{"transport error", corrupt(tsPacketSize*3+1, 0x81), "transport error"},
+		{"reserved adaptation field control", corrupt(tsPacketSize*3+3, 0x00), "reserved adaptation"},
+		{"adaptation field overruns packet", append(corrupt(tsPacketSize*3+3, 0x30), tsPacket(0x11, false, nil)...)[:4*tsPacketSize], "adaptation field length"},
+		{"pointer field out of bounds", corrupt(4, 200), "pointer field"},
+		{"wrong table", corrupt(5, 0x02), "table ID"},
+		{"section syntax indicator", corrupt(6, 0x30), "section syntax"},
+		{"section spans packets", corrupt(7, 0xf0), "spans packets"},
+		{"PAT CRC", corrupt(14, 0x02), "CRC mismatch"},
+		{"PMT CRC", corrupt(tsPacketSize+15, 0x1c), "CRC mismatch"},
+		{"no PAT", testTS()[tsPacketSize:], "no PAT"},
+		{"no PMT", append(tsPacket(0, true, testPAT()), tsPacket(testESPID, true, testPES())...), "no PMT"},
+		{"no PES", testTS()[:2*tsPacketSize], "no PES"},
+		{"too many streams", tsPacket(testPMTPID, true, testPMT(manyStreams...)), "streams, more than"},
+		{"no start code", corrupt(2*tsPacketSize+6, 2), "start code"},
+		{"stream ID", corrupt(2*tsPacketSize+7, 0x01), "stream ID"},
+		{"PES marker bits", corrupt(2*tsPacketSize+10, 0x00), "marker bits"},
+		{"PES header out of bounds", truncatedPES, "PES header length"},
+		{"DTS without PTS", dtsOnly, "DTS without a PTS"},
+		{"PTS header too short", shortPTS, "too short"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data
+			if tt.name == "too many streams" {
+				data = append(tsPacket(0, true, testPAT()), data...)
+			}
+			assertContainerError(t, "ts", tt.reason, validate(data))
+		})
+	}
+
+	// Shorter than it's said to be
+	ts := testTS()
+	assertContainerError(t, "ts", "shorter than its size", ValidateContainer(bytes.NewReader(ts), int64(len(ts)+tsPacketSize)))
+}
+
+func TestValidateContainer_MP4(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validate(testMP4(2)))
+
+	// Fragments
+	frag := bytes.Join([][]byte{
+		mp4Box("styp", []byte("msdh\x00\x00\x00\x00")),
+		mp4Box("moof", mp4Box("mfhd", make([]byte, 8)), mp4Box("traf", mp4Box("tfhd", make([]byte, 8)))),
+		mp4Box("mdat", make([]byte, 100)),
+	}, nil)
+	assert.Nil(validate(frag))
+
+	// A last box that extends to the end of the segment
+	mp4 := testMP4(1)
+	binary.BigEndian.PutUint32(mp4[len(mp4)-1008:], 0)
+	assert.Nil(validate(mp4))
+
+	// A large size
+	mp4 = testMP4(1)
+	mdat := append([]byte{0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0, 0, 0, 0, 0, 0, 24}, make([]byte, 8)...)
+	assert.Nil(validate(append(mp4[:len(mp4)-1008], mdat...)))
+}
+
+func TestValidateContainer_InvalidMP4(t *testing.T) {
+	nested := mp4Box("tkhd")
+	for i := 0; i <= maxMP4BoxDepth; i++ {
+		nested = mp4Box("dinf", nested)
+	}
+	deep := bytes.Join([][]byte{mp4Box("ftyp", []byte("isom")), mp4Box("moov", nested), mp4Box("mdat")}, nil)
+	withSize := func(offset int, size uint32) []byte {
+		mp4 := testMP4(1)
+		binary.BigEndian.PutUint32(mp4[offset:], size)
+		return mp4
+	}
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	moovOffset := len(ftyp)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		reason string
+	}{
+		{"truncated header", testMP4(1)[:len(testMP4(1))-1005], "truncated box header"},
+		{"box overruns segment", withSize(0, 1<<30), "ftyp box of"},
+		{"box smaller than its header", withSize(0, 4), "ftyp box of 4 bytes"},
+		{"child overruns parent", withSize(moovOffset+8, 1<<20), "mvhd box of"},
+		{"large size overruns segment", append(ftyp, 0, 0, 0, 1, 'm', 'd', 'a', 't', 0xff, 0, 0, 0, 0, 0, 0, 0), "overruns the segment"},
+		{"box type", append(ftyp, 0, 0, 0, 8, 'm', 0, 'a', 't'), "box type"},
+		{"not ftyp first", append(mp4Box("free", []byte("ftyp")), testMP4(1)...), "neither MPEG-TS nor MP4"},
+		{"too many tracks", testMP4(MaxContainerStreams + 1), "more than 8 tracks"},
+		{"nested too deeply", deep, "nested too deeply"},
+		{"no moov", append(ftyp, mp4Box("mdat")...), "no moov"},
+		{"no mdat", append(ftyp, mp4Box("moov", mp4Box("trak"))...), "no mdat"},
+		{"no tracks", bytes.Join([][]byte{ftyp, mp4Box("moov"), mp4Box("mdat")}, nil), "no tracks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := "mp4"
+			if tt.name == "not ftyp first" {
+				format = ""
+			}
+			assertContainerError(t, format, tt.reason, validate(tt.data))
+		})
+	}
+
+	var boxes [][]byte
+	boxes = append(boxes, ftyp)
+	for i := 0; i < maxMP4Boxes; i++ {
+		boxes = append(boxes, mp4Box("free"))
+	}
+	assertContainerError(t, "mp4", "more than 4096 boxes", validate(bytes.Join(boxes, nil)))
+}
+
+func TestValidateContainer_Errors(t *testing.T) {
+	require := require.New(t)
+
+	err := validate([]byte("not a segment at all"))
+	assertContainerError(t, "", "neither MPEG-TS nor MP4", err)
+	require.Equal("invalid segment: neither MPEG-TS nor MP4", err.Error())
+	assertContainerError(t, "", "neither", validate(nil))
+
+	// Read errors are returned as they are
+	readErr := errors.New("read error")
+	ts := testTS()
+	err = ValidateContainer(&failingReader{data: ts[:200], err: readErr}, int64(len(ts)))
+	require.Equal(readErr, err)
+	_, ok := err.(*ContainerError)
+	require.False(ok)
+	mp4 := testMP4(1)
+	err = ValidateContainer(&failingReader{data: mp4[:20], err: readErr}, int64(len(mp4)))
+	require.Equal(readErr, err)
+}
+
+// failingReader reads data and then returns err
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestValidateContainer_Mutations(t *testing.T) {
+	// Corrupt segments are rejected, never panic
+	rng := rand.New(rand.NewSource(1))
+	for _, valid := range [][]byte{testTS(), testMP4(2)} {
+		for i := 0; i < 5000; i++ {
+			data := append([]byte(nil), valid...)
+			for n := rng.Intn(4) + 1; n > 0; n-- {
+				data[rng.Intn(len(data))] = byte(rng.Intn(256))
+			}
+			if rng.Intn(4) == 0 {
+				data = data[:rng.Intn(len(data))]
+			}
+			if err := validate(data); err != nil {
+				_, ok := err.(*ContainerError)
+				require.True(t, ok, "unexpected error %v", err)
+			}
+		}
+	}
+}
+
+func TestValidateContainer_Samples(t *testing.T) {
+	// Real encoder output, which the check must never reject
+	for _, fname := range []string{
+		"../core/test.ts",
+		"../vendor/github.com/livepeer/lpms/transcoder/test.ts",
+		"../vendor/github.com/livepeer/lpms/data/bunny.mp4",
+		"../vendor/github.com/livepeer/lpms/data/bunny2.mp4",
+	} {
+		t.Run(fname, func(t *testing.T) {
+			f, err := os.Open(fname)
+			require.Nil(t, err)
+			defer f.Close()
+			info, err := f.Stat()
+			require.Nil(t, err)
+			assert.Nil(t, ValidateContainer(f, info.Size()))
+		})
+	}
+}
@@ -4,29 +4,41 @@ import (
 	"bytes"
 	"database/sql"
 	"math/big"
-	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/pm"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
 type DB struct {
-	dbh *sql.DB
+	dbh     *sql.DB
+	dialect dbDialect
+	// writes go through writer; reads use dbh directly
+	writer *dbWriter
+	// cipher encrypts ticket secrets and stream keys, once SetEncryptionKey
+	// is called
+	cipher *dbCipher
+	// node is the ETH address of the node, that its last seen block,
+	// unbonding locks and winning tickets are kept under, once
+	// SetNodeAddress is called. Nodes sharing a DB each keep their own.
+	node string
 
 	// prepared statements
 	selectOrchs                *sql.Stmt
-	updateOrch                 *sql.Stmt
-	updateKV                   *sql.Stmt
 	insertUnbondingLock        *sql.Stmt
 	useUnbondingLock           *sql.Stmt
 	unbondingLocks             *sql.Stmt
 	withdrawableUnbondingLocks *sql.Stmt
 	insertWinningTicket        *sql.Stmt
+	updateRecordingDeals       *sql.Stmt
+	selectRecordings           *sql.Stmt
+	insertPayment              *sql.Stmt
+	selectPayments             *sql.Stmt
 }
 
 type DBOrch struct {
@@ -41,76 +53,144 @@ type DBUnbondingLock struct {
 	WithdrawRound int64
 }
 
-var LivepeerDBVersion = 1
+// DBWinningTicket is a winning ticket an orchestrator stored for redemption
+type DBWinningTicket struct {
+	CreatedAt     time.Time
+	SessionID     string
+	Ticket        *pm.Ticket
+	Sig           []byte
+	RecipientRand *big.Int
+}
+
+// DBRedemption is a winning ticket the orchestrator submitted for redemption
+type DBRedemption struct {
+	CreatedAt time.Time
+	Sender    string
+	FaceValue *big.Int
+	TxHash    string
+}
+
+// DBRecording is an archive of a stream on Filecoin
+type DBRecording struct {
+	ManifestID string
+	CreatedAt  string
+	UpdatedAt  string
+	CID        string
+	Size       int64
+	// Deals JSON encoded Filecoin deals of the archive, as reported by the archival service
+	Deals string
+}
+
+// Kinds of payments recorded in the DB
+const (
+	// PaymentTicketSent expected value of a ticket a broadcaster sent
+	PaymentTicketSent = "ticketSent"
+	// PaymentTicketReceived expected value of a ticket an orchestrator received
+	PaymentTicketReceived = "ticketReceived"
+	// PaymentTicketWon face value of a winning ticket an orchestrator received
+	PaymentTicketWon = "ticketWon"
+	// PaymentReward LPT an orchestrator minted by calling reward
+	PaymentReward = "reward"
+)
+
+// DBPayment is a payment sent or received by the node
+type DBPayment struct {
+	CreatedAt  time.Time
+	Kind       string
+	ManifestID string
+	// Counterparty ETH address of the other side of the payment, if any
+	Counterparty string
+	Amount       *big.Int
+}
+
+// LivepeerDBVersion is the schema version of the node, that of the last of
+// dbMigrations
+var LivepeerDBVersion = 10
 
 var ErrDBTooNew = errors.New("DB Too New")
 
 var schema = `
 	CREATE TABLE IF NOT EXISTS kv (
-		key STRING PRIMARY KEY,
-		value STRING,
-		updatedAt STRING DEFAULT CURRENT_TIMESTAMP
+		key {{ .String }} PRIMARY KEY,
+		value {{ .String }},
+		updatedAt {{ .String }} DEFAULT {{ .Now }}
 	);
-	INSERT OR IGNORE INTO kv(key, value) VALUES('dbVersion', '{{ . }}');
-	INSERT OR IGNORE INTO kv(key, value) VALUES('lastBlock', '0');
+	INSERT INTO kv(key, value) SELECT 'dbVersion', '{{ .Version }}' WHERE NOT EXISTS (SELECT 1 FROM kv WHERE key = 'dbVersion');
+	INSERT INTO kv(key, value) SELECT 'lastBlock', '0' WHERE NOT EXISTS (SELECT 1 FROM kv WHERE key = 'lastBlock');
 
 	CREATE TABLE IF NOT EXISTS orchestrators (
-		ethereumAddr STRING PRIMARY KEY,
-		createdAt STRING DEFAULT CURRENT_TIMESTAMP NOT NULL,
-		updatedAt STRING DEFAULT CURRENT_TIMESTAMP NOT NULL,
-		serviceURI STRING
+		ethereumAddr {{ .String }} PRIMARY KEY,
+		createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+		updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+		serviceURI {{ .String }}
 	);
 
 	CREATE TABLE IF NOT EXISTS unbondingLocks (
-		createdAt STRING DEFAULT CURRENT_TIMESTAMP,
-		id INTEGER NOT NULL,
-		delegator STRING,
+		createdAt {{ .String }} DEFAULT {{ .Now }},
+		id {{ .Integer }} NOT NULL,
+		delegator {{ .String }},
 		amount TEXT,
-		withdrawRound int64,
-		usedBlock int64,
+		withdrawRound {{ .Integer }},
+		usedBlock {{ .Integer }},
 		PRIMARY KEY(id, delegator)
 	);
 	-- Index to only retrieve unbonding locks that have not been used
 	CREATE INDEX IF NOT EXISTS idx_unbondinglocks_usedblock ON unbondingLocks(usedBlock);
 
 	CREATE TABLE IF NOT EXISTS winningTickets (
-		createdAt STRING DEFAULT CURRENT_TIMESTAMP,
-		sender STRING,
-		recipient STRING,
-		faceValue BLOB,
-		winProb BLOB,
-		senderNonce INTEGER,
-		recipientRand BLOB,
-		recipientRandHash STRING,
-		sig BLOB,
-		sessionID STRING
+		createdAt {{ .String }} DEFAULT {{ .Now }},
+		sender {{ .String }},
+		recipient {{ .String }},
+		faceValue {{ .Blob }},
+		winProb {{ .Blob }},
+		senderNonce {{ .Integer }},
+		recipientRand {{ .Blob }},
+		recipientRandHash {{ .String }},
+		sig {{ .Blob }},
+		sessionID {{ .String }}
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_winningtickets_sessionid ON winningTickets(sessionID);
+
+	CREATE TABLE IF NOT EXISTS payments (
+		createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+		kind {{ .String }} NOT NULL,
+		manifestID {{ .String }},
+		counterparty {{ .String }},
+		amount TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_payments_createdat ON payments(createdAt);
 `
 
 func NewDBOrch(serviceURI string, orchAddr string) *DBOrch {
 	return &DBOrch{ServiceURI: serviceURI, EthereumAddr: orchAddr}
 }
 
-func InitDB(dbPath string) (*DB, error) {
+// InitDB opens the DB at dsn: the path of a SQLite file, or the URL of a
+// Postgres DB, e.g. postgres://livepeer@db.example.com/livepeer
+func InitDB(dsn string) (*DB, error) {
 	// XXX need a way to ensure (via unit tests?) that all DB{} fields are
 	// properly closed / cleaned up in the case of an error
-	d := DB{}
-	db, err := sql.Open("sqlite3", dbPath)
+	d := DB{dialect: dialectOf(dsn)}
+	db, err := sql.Open(d.dialect.driverName(), dsn)
 	if err != nil {
-		glog.Error("Unable to open DB ", dbPath, err)
+		glog.Error("Unable to open DB ", dsn, err)
 		return nil, err
 	}
-	// The DB connection might be used in multiple goroutines (i.e. when recovering claims during node restart)
-	// resulting in concurrent access. SQLite can only handle one writer at a time, so if concurrent writes occur
-	// we can encounter a `database is locked` error. To avoid concurrent writes, we limit SQLite to a single connection
-	db.SetMaxOpenConns(1)
+	db.SetMaxOpenConns(d.dialect.maxOpenConns())
 	d.dbh = db
-	schemaBuf := new(bytes.Buffer)
-	tmpl := template.Must(template.New("schema").Parse(schema))
-	tmpl.Execute(schemaBuf, LivepeerDBVersion)
-	_, err = db.Exec(schemaBuf.String())
+	for _, stmt := range d.dialect.setup() {
+		if _, err := db.Exec(stmt); err != nil {
+			glog.Error("Error setting up DB ", err)
+			d.Close()
+			return nil, err
+		}
+	}
+	stmts := renderSQL("schema", schema, struct {
+		dbTypes
+		Version int
+	}{d.dialect.types(), dbBaseVersion})
+	_, err = db.Exec(stmts)
 	if err != nil {
 		glog.Error("Error initializing schema ", err)
 		d.Close()
@@ -118,27 +198,30 @@ func InitDB(dbPath string) (*DB, error) {
 	}
 
 	// Check for correct DB version and upgrade if needed
-	var dbVersion int
-	row := db.QueryRow("SELECT value FROM kv WHERE key = 'dbVersion'")
-	err = row.Scan(&dbVersion)
+	version, err := dbVersion(db)
 	if err != nil {
 		glog.Error("Unable to fetch DB version ", err)
 		d.Close()
 		return nil, err
 	}
-	if dbVersion > LivepeerDBVersion {
+	if version > LivepeerDBVersion {
 		glog.Error("Database too new")
 		d.Close()
 		return nil, ErrDBTooNew
-	} else if dbVersion < LivepeerDBVersion {
+	} else if version < LivepeerDBVersion {
 		// Upgrade stepwise up to the correct version using the migration
 		// procedure for each version
-	} else if dbVersion == LivepeerDBVersion {
-		// all good; nothing to do
+		if err := migrateDB(db, d.dialect, dbMigrations, version, LivepeerDBVersion); err != nil {
+			glog.Error(err)
+			d.Close()
+			return nil, err
+		}
 	}
 
+	d.writer = newDBWriter(db, d.dialect)
+
 	// select all orchestrators updated in the last 24 hours
-	stmt, err := db.Prepare("SELECT serviceURI, ethereumAddr FROM orchestrators WHERE updatedAt >= datetime('now','-1 day')")
+	stmt, err := d.prepare("SELECT serviceURI, ethereumAddr FROM orchestrators WHERE updatedAt >= ?")
 	if err != nil {
 		glog.Error("Unable to select orchestrators updated in the past 24 hours", err)
 		d.Close()
@@ -146,47 +229,29 @@ func InitDB(dbPath string) (*DB, error) {
 	}
 	d.selectOrchs = stmt
 
-	// updateOrchestrators statement
-	stmt, err = db.Prepare("INSERT OR REPLACE INTO orchestrators(updatedAt, serviceURI, ethereumAddr, createdAt) VALUES(datetime(), ?1, ?2, (SELECT createdAt FROM orchestrators WHERE ethereumAddr = ?2))")
-	if err != nil {
-		glog.Error("Unable to prepare updateOrchestrators stmt ", err)
-		d.Close()
-		return nil, err
-	}
-	d.updateOrch = stmt
-
-	// updateKV prepared statement
-	stmt, err = db.Prepare("UPDATE kv SET value=?, updatedAt = datetime() WHERE key=?")
-	if err != nil {
-		glog.Error("Unable to prepare updatekv stmt ", err)
-		d.Close()
-		return nil, err
-	}
-	d.updateKV = stmt
-
 	// Unbonding locks prepared statements
-	stmt, err = db.Prepare("INSERT INTO unbondingLocks(id, delegator, amount, withdrawRound) VALUES(?, ?, ?, ?)")
+	stmt, err = d.prepare("INSERT INTO unbondingLocks(id, delegator, amount, withdrawRound) VALUES(?, ?, ?, ?)")
 	if err != nil {
 		glog.Error("Unable to prepare insertUnbondingLock ", err)
 		d.Close()
 		return nil, err
 	}
 	d.insertUnbondingLock = stmt
-	stmt, err = db.Prepare("UPDATE unbondingLocks SET usedBlock=? WHERE id=? AND delegator=?")
+	stmt, err = d.prepare("UPDATE unbondingLocks SET usedBlock=? WHERE id=? AND delegator=?")
 	if err != nil {
 		glog.Error("Unable to prepare useUnbondingLock ", err)
 		d.Close()
 		return nil, err
 	}
 	d.useUnbondingLock = stmt
-	stmt, err = db.Prepare("SELECT id, delegator, amount, withdrawRound FROM unbondingLocks WHERE usedBlock IS NULL")
+	stmt, err = d.prepare("SELECT id, delegator, amount, withdrawRound FROM unbondingLocks WHERE usedBlock IS NULL AND (?1 = '' OR delegator = ?1)")
 	if err != nil {
 		glog.Error("Unable to prepare unbondingLocks ", err)
 		d.Close()
 		return nil, err
 	}
 	d.unbondingLocks = stmt
-	stmt, err = db.Prepare("SELECT id, delegator, amount, withdrawRound FROM unbondingLocks WHERE usedBlock IS NULL AND withdrawRound <= ?")
+	stmt, err = d.prepare("SELECT id, delegator, amount, withdrawRound FROM unbondingLocks WHERE usedBlock IS NULL AND withdrawRound <= ?1 AND (?2 = '' OR delegator = ?2)")
 	if err != nil {
 		glog.Error("Unable to prepare withdrawableUnbondingLocks ", err)
 		d.Close()
@@ -195,7 +260,7 @@ func InitDB(dbPath string) (*DB, error) {
 	d.withdrawableUnbondingLocks = stmt
 
 	// Winning tickets prepared statements
-	stmt, err = db.Prepare("INSERT INTO winningTickets(sender, recipient, faceValue, winProb, senderNonce, recipientRand, recipientRandHash, sig, sessionID) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err = d.prepare("INSERT INTO winningTickets(sender, recipient, faceValue, winProb, senderNonce, recipientRand, recipientRandHash, sig, sessionID) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		glog.Error("Unable to prepare insertWinningTicket ", err)
 		d.Close()
@@ -203,21 +268,50 @@ func InitDB(dbPath string) (*DB, error) {
 	}
 	d.insertWinningTicket = stmt
 
+	// Recordings prepared statements
+	stmt, err = d.prepare("UPDATE recordings SET deals=?, updatedAt=? WHERE cid=?")
+	if err != nil {
+		glog.Error("Unable to prepare updateRecordingDeals ", err)
+		d.Close()
+		return nil, err
+	}
+	d.updateRecordingDeals = stmt
+	stmt, err = d.prepare("SELECT manifestID, createdAt, updatedAt, cid, size, deals FROM recordings ORDER BY createdAt, manifestID")
+	if err != nil {
+		glog.Error("Unable to prepare selectRecordings ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectRecordings = stmt
+
+	// Payments prepared statements
+	stmt, err = d.prepare("INSERT INTO payments(kind, manifestID, counterparty, amount) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		glog.Error("Unable to prepare insertPayment ", err)
+		d.Close()
+		return nil, err
+	}
+	d.insertPayment = stmt
+	stmt, err = d.prepare("SELECT createdAt, kind, manifestID, counterparty, amount FROM payments WHERE createdAt >= ? AND createdAt < ? ORDER BY createdAt")
+	if err != nil {
+		glog.Error("Unable to prepare selectPayments ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectPayments = stmt
+
 	glog.V(DEBUG).Info("Initialized DB node")
 	return &d, nil
 }
 
 func (db *DB) Close() {
 	glog.V(DEBUG).Info("Closing DB")
-	if db.updateOrch != nil {
-		db.updateOrch.Close()
+	if db.writer != nil {
+		db.writer.close()
 	}
 	if db.selectOrchs != nil {
 		db.selectOrchs.Close()
 	}
-	if db.updateKV != nil {
-		db.updateKV.Close()
-	}
 	if db.insertUnbondingLock != nil {
 		db.insertUnbondingLock.Close()
 	}
@@ -233,17 +327,102 @@ func (db *DB) Close() {
 	if db.insertWinningTicket != nil {
 		db.insertWinningTicket.Close()
 	}
+	if db.updateRecordingDeals != nil {
+		db.updateRecordingDeals.Close()
+	}
+	if db.selectRecordings != nil {
+		db.selectRecordings.Close()
+	}
+	if db.insertPayment != nil {
+		db.insertPayment.Close()
+	}
+	if db.selectPayments != nil {
+		db.selectPayments.Close()
+	}
 	if db.dbh != nil {
 		db.dbh.Close()
 	}
 }
 
+// renderSQL executes the template of SQL statements text with data, e.g.
+// the column types of a dialect
+func renderSQL(name, text string, data interface{}) string {
+	buf := new(bytes.Buffer)
+	template.Must(template.New(name).Parse(text)).Execute(buf, data)
+	return buf.String()
+}
+
+// dbNow is the current time in sqliteTimeFormat, for the updatedAt columns
+func dbNow() string {
+	return time.Now().UTC().Format(sqliteTimeFormat)
+}
+
+func (db *DB) prepare(query string) (*sql.Stmt, error) {
+	return db.dbh.Prepare(db.dialect.rebind(query))
+}
+
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.dbh.Query(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	return db.dbh.QueryRow(db.dialect.rebind(query), args...)
+}
+
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	query = db.dialect.rebind(query)
+	return db.writer.exec(func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(query, args...)
+	})
+}
+
+// upsert inserts a row of cols with the values args into table, or updates
+// the row with the same key to it
+func (db *DB) upsert(table string, key []string, cols []string, args ...interface{}) (sql.Result, error) {
+	stmts := db.dialect.upsert(table, key, cols...)
+	for i := range stmts {
+		stmts[i] = db.dialect.rebind(stmts[i])
+	}
+	return db.writer.exec(func(tx *sql.Tx) (res sql.Result, err error) {
+		for _, stmt := range stmts {
+			if res, err = tx.Exec(stmt, args...); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	})
+}
+
+// SetNodeAddress keeps the last seen block, unbonding locks and winning
+// tickets of the node, from now on, under its ETH address addr
+func (db *DB) SetNodeAddress(addr ethcommon.Address) {
+	if db == nil {
+		return
+	}
+	db.node = addr.Hex()
+}
+
+// lastBlockKey is the key in kv of the last seen block of the node
+func (db *DB) lastBlockKey() string {
+	if db.node == "" {
+		return "lastBlock"
+	}
+	return "lastBlock:" + db.node
+}
+
+// execStmt runs a prepared statement that writes to the DB
+func (db *DB) execStmt(stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
+	return db.writer.exec(func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Stmt(stmt).Exec(args...)
+	})
+}
+
 func (db *DB) SetLastSeenBlock(block *big.Int) error {
 	if db == nil {
 		return nil
 	}
 	glog.V(VERBOSE).Info("db: Setting LastSeenBlock to ", block)
-	_, err := db.updateKV.Exec(block.String(), "lastBlock")
+	_, err := db.upsert("kv", []string{"key"}, []string{"key", "value", "updatedAt"}, db.lastBlockKey(), block.String(), dbNow())
 	if err != nil {
 		glog.Error("db: Got err in updating block ", err)
 		return err
@@ -256,9 +435,13 @@ func (db *DB) LastSeenBlock() (*big.Int, error) {
 		return nil, nil
 	}
 
+	// Nodes that didn't share the DB before their blocks were kept by
+	// address carry on from the one they last saw
 	var lastSeenBlock int64
-	row := db.dbh.QueryRow("SELECT value FROM kv WHERE key = 'lastBlock'")
-	err := row.Scan(&lastSeenBlock)
+	err := db.queryRow("SELECT value FROM kv WHERE key = ?", db.lastBlockKey()).Scan(&lastSeenBlock)
+	if err == sql.ErrNoRows {
+		err = db.queryRow("SELECT value FROM kv WHERE key = 'lastBlock'").Scan(&lastSeenBlock)
+	}
 	if err != nil {
 		glog.Error("db: Got err in retrieving block ", err)
 		return nil, err
@@ -272,7 +455,8 @@ func (db *DB) UpdateOrch(orch *DBOrch) error {
 		return nil
 	}
 
-	_, err := db.updateOrch.Exec(orch.ServiceURI, orch.EthereumAddr)
+	// createdAt is kept from when the orchestrator was first seen
+	_, err := db.upsert("orchestrators", []string{"ethereumAddr"}, []string{"ethereumAddr", "serviceURI", "updatedAt"}, orch.EthereumAddr, orch.ServiceURI, dbNow())
 	if err != nil {
 		glog.Error("db: Unable to update orchestrator ", err)
 	}
@@ -285,7 +469,7 @@ func (db *DB) SelectOrchs() ([]*DBOrch, error) {
 		return nil, nil
 	}
 
-	rows, err := db.selectOrchs.Query()
+	rows, err := db.selectOrchs.Query(time.Now().Add(-24 * time.Hour).UTC().Format(sqliteTimeFormat))
 	defer rows.Close()
 	if err != nil {
 		glog.Error("db: Unable to get orchestrators updated in the last 24 hours", err)
@@ -309,7 +493,7 @@ func (db *DB) SelectOrchs() ([]*DBOrch, error) {
 
 func (db *DB) InsertUnbondingLock(id *big.Int, delegator ethcommon.Address, amount, withdrawRound *big.Int) error {
 	glog.V(DEBUG).Infof("db: Inserting unbonding lock %v for delegator %v", id, delegator.Hex())
-	_, err := db.insertUnbondingLock.Exec(id.Int64(), delegator.Hex(), amount.String(), withdrawRound.Int64())
+	_, err := db.execStmt(db.insertUnbondingLock, id.Int64(), delegator.Hex(), amount.String(), withdrawRound.Int64())
 	if err != nil {
 		glog.Errorf("db: Error inserting unbonding lock %v for delegator %v: %v", id, delegator.Hex(), err)
 		return err
@@ -319,7 +503,7 @@ func (db *DB) InsertUnbondingLock(id *big.Int, delegator ethcommon.Address, amou
 
 func (db *DB) UseUnbondingLock(id *big.Int, delegator ethcommon.Address, usedBlock *big.Int) error {
 	glog.V(DEBUG).Infof("db: Using unbonding lock %v for delegator %v", id, delegator.Hex())
-	_, err := db.useUnbondingLock.Exec(usedBlock.Int64(), id.Int64(), delegator.Hex())
+	_, err := db.execStmt(db.useUnbondingLock, usedBlock.Int64(), id.Int64(), delegator.Hex())
 	if err != nil {
 		glog.Errorf("db: Error using unbonding lock %v for delegator %v: %v", id, delegator.Hex(), err)
 		return err
@@ -330,7 +514,7 @@ func (db *DB) UseUnbondingLock(id *big.Int, delegator ethcommon.Address, usedBlo
 func (db *DB) UnbondingLockIDs() ([]*big.Int, error) {
 	glog.V(DEBUG).Infof("db: Querying unbonding lock IDs")
 
-	rows, err := db.dbh.Query("SELECT id FROM unbondingLocks")
+	rows, err := db.query("SELECT id FROM unbondingLocks WHERE ?1 = '' OR delegator = ?1", db.node)
 	if err != nil {
 		glog.Error("db: Unable to select unbonding lock IDs ", err)
 		return nil, err
@@ -360,9 +544,9 @@ func (db *DB) UnbondingLocks(currentRound *big.Int) ([]*DBUnbondingLock, error)
 	)
 
 	if currentRound == nil {
-		rows, err = db.unbondingLocks.Query()
+		rows, err = db.unbondingLocks.Query(db.node)
 	} else {
-		rows, err = db.withdrawableUnbondingLocks.Query(currentRound.Int64())
+		rows, err = db.withdrawableUnbondingLocks.Query(currentRound.Int64(), db.node)
 	}
 	if err != nil {
 		glog.Error("db: Unable to select unbonding locks ", err)
@@ -405,7 +589,15 @@ func (db *DB) StoreWinningTicket(sessionID string, ticket *pm.Ticket, sig []byte
 	}
 	glog.V(DEBUG).Infof("db: Inserting winning ticket from %v, recipientRand %d, senderNonce %d", ticket.Sender.Hex(), recipientRand, ticket.SenderNonce)
 
-	_, err := db.insertWinningTicket.Exec(ticket.Sender.Hex(), ticket.Recipient.Hex(), ticket.FaceValue.Bytes(), ticket.WinProb.Bytes(), ticket.SenderNonce, recipientRand.Bytes(), ticket.RecipientRandHash.Hex(), sig, sessionID)
+	recipientRandBytes, senderNonce := recipientRand.Bytes(), ticket.SenderNonce
+	if db.cipher != nil {
+		sealed, err := db.cipher.sealTicketSecrets(recipientRand, senderNonce, sig)
+		if err != nil {
+			return errors.Wrap(err, "failed encrypting winning ticket")
+		}
+		recipientRandBytes, senderNonce = sealed, 0
+	}
+	_, err := db.execStmt(db.insertWinningTicket, ticket.Sender.Hex(), ticket.Recipient.Hex(), ticket.FaceValue.Bytes(), ticket.WinProb.Bytes(), senderNonce, recipientRandBytes, ticket.RecipientRandHash.Hex(), sig, sessionID)
 
 	if err != nil {
 		return errors.Wrapf(err, "failed inserting winning ticket for sessionID: %v, ticket: %v", sessionID, ticket)
@@ -414,13 +606,16 @@ func (db *DB) StoreWinningTicket(sessionID string, ticket *pm.Ticket, sig []byte
 }
 
 func (db *DB) LoadWinningTickets(sessionIDs []string) (tickets []*pm.Ticket, sigs [][]byte, recipientRands []*big.Int, err error) {
-	rows, err := db.dbh.Query(buildWinningTicketsQuery(sessionIDs))
-	defer rows.Close()
-
+	if len(sessionIDs) == 0 {
+		return
+	}
+	query, args := buildWinningTicketsQuery(db.node, sessionIDs)
+	rows, err := db.query(query, args...)
 	if err != nil {
 		err = errors.Wrapf(err, "failed loading winning tickets for sessionIDs %v", sessionIDs)
 		return
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var sender, recipient, recipientRandHash, sessionID string
@@ -432,6 +627,11 @@ func (db *DB) LoadWinningTickets(sessionIDs []string) (tickets []*pm.Ticket, sig
 			err = errors.Wrapf(err, "failed scanning a winning ticket row for sessionID %v", sessionID)
 			return
 		}
+		var recipientRand *big.Int
+		recipientRand, senderNonce, err = db.openTicketSecrets(recipientRandBytes, senderNonce, sig)
+		if err != nil {
+			return
+		}
 
 		ticket := &pm.Ticket{
 			Sender:            ethcommon.HexToAddress(sender),
@@ -441,8 +641,6 @@ func (db *DB) LoadWinningTickets(sessionIDs []string) (tickets []*pm.Ticket, sig
 			SenderNonce:       senderNonce,
 			RecipientRandHash: ethcommon.HexToHash(recipientRandHash),
 		}
-		recipientRand := new(big.Int).SetBytes(recipientRandBytes)
-
 		tickets = append(tickets, ticket)
 		sigs = append(sigs, sig)
 		recipientRands = append(recipientRands, recipientRand)
@@ -454,11 +652,243 @@ func (db *DB) LoadWinningTickets(sessionIDs []string) (tickets []*pm.Ticket, sig
 // We are building a query string instead of using a prepared statement because prepared statements don't
 // support IN queries. We want to use IN for the performance benefit, rather than running len(sessionIDs)
 // queries.
-// The known risk with string queries is SQL injection, however in this case all sessionID values are generated
-// by O, so such injections are not likely.
-func buildWinningTicketsQuery(sessionIDs []string) string {
-	for i := 0; i < len(sessionIDs); i++ {
-		sessionIDs[i] = strconv.Quote(sessionIDs[i])
+func buildWinningTicketsQuery(recipient string, sessionIDs []string) (string, []interface{}) {
+	args := make([]interface{}, 0, len(sessionIDs)+2)
+	for _, id := range sessionIDs {
+		args = append(args, id)
+	}
+	args = append(args, recipient, recipient)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(sessionIDs)), ", ")
+	return "SELECT sender, recipient, faceValue, winProb, senderNonce, recipientRand, recipientRandHash, sig, sessionID FROM winningTickets WHERE sessionID IN (" + placeholders + ") AND (? = '' OR recipient = ?)", args
+}
+
+// InsertRecording records an archive of the stream manifestID. Each archive
+// of a stream is kept; archiving the same data again keeps its deals.
+func (db *DB) InsertRecording(manifestID, cid string, size int64) error {
+	glog.V(DEBUG).Infof("db: Inserting recording manifestID=%v cid=%v", manifestID, cid)
+	_, err := db.upsert("recordings", []string{"manifestID", "cid"}, []string{"manifestID", "cid", "size", "updatedAt"}, manifestID, cid, size, dbNow())
+	if err != nil {
+		glog.Errorf("db: Error inserting recording manifestID=%v: %v", manifestID, err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) UpdateRecordingDeals(cid, deals string) error {
+	_, err := db.execStmt(db.updateRecordingDeals, deals, dbNow(), cid)
+	if err != nil {
+		glog.Errorf("db: Error updating deals of recording cid=%v: %v", cid, err)
+		return err
+	}
+	return nil
+}
+
+func (db *DB) Recordings() ([]*DBRecording, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	rows, err := db.selectRecordings.Query()
+	if err != nil {
+		glog.Error("db: Unable to select recordings ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	recordings := []*DBRecording{}
+	for rows.Next() {
+		var r DBRecording
+		var size sql.NullInt64
+		if err := rows.Scan(&r.ManifestID, &r.CreatedAt, &r.UpdatedAt, &r.CID, &size, &r.Deals); err != nil {
+			glog.Error("db: Unable to fetch recording ", err)
+			continue
+		}
+		r.Size = size.Int64
+		recordings = append(recordings, &r)
+	}
+	return recordings, nil
+}
+
+// AllOrchs returns all orchestrators in the DB, unlike SelectOrchs which
+// only returns the recently updated ones
+func (db *DB) AllOrchs() ([]*DBOrch, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	rows, err := db.query("SELECT serviceURI, ethereumAddr FROM orchestrators")
+	if err != nil {
+		glog.Error("db: Unable to get orchestrators ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	orchs := []*DBOrch{}
+	for rows.Next() {
+		var serviceURI sql.NullString
+		var ethereumAddr string
+		if err := rows.Scan(&serviceURI, &ethereumAddr); err != nil {
+			glog.Error("db: Unable to fetch orchestrator ", err)
+			continue
+		}
+		orchs = append(orchs, NewDBOrch(serviceURI.String, ethereumAddr))
+	}
+	return orchs, nil
+}
+
+// AllWinningTickets returns every winning ticket in the DB, across sessions
+func (db *DB) AllWinningTickets() ([]*DBWinningTicket, error) {
+	return db.winningTickets("")
+}
+
+// PendingWinningTickets returns the winning tickets that haven't been
+// submitted for redemption yet
+func (db *DB) PendingWinningTickets() ([]*DBWinningTicket, error) {
+	return db.winningTickets("WHERE sig NOT IN (SELECT sig FROM ticketRedemptions) AND (?1 = '' OR recipient = ?1)", db.node)
+}
+
+func (db *DB) winningTickets(where string, args ...interface{}) ([]*DBWinningTicket, error) {
+	rows, err := db.query("SELECT createdAt, sender, recipient, faceValue, winProb, senderNonce, recipientRand, recipientRandHash, sig, sessionID FROM winningTickets "+where, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed loading winning tickets")
+	}
+	defer rows.Close()
+
+	tickets := []*DBWinningTicket{}
+	for rows.Next() {
+		var createdAt, sender, recipient, recipientRandHash, sessionID string
+		var faceValue, winProb, recipientRand, sig []byte
+		var senderNonce uint32
+		if err := rows.Scan(&createdAt, &sender, &recipient, &faceValue, &winProb, &senderNonce, &recipientRand, &recipientRandHash, &sig, &sessionID); err != nil {
+			return nil, errors.Wrap(err, "failed scanning a winning ticket row")
+		}
+		rand, nonce, err := db.openTicketSecrets(recipientRand, senderNonce, sig)
+		if err != nil {
+			return nil, err
+		}
+		t := &DBWinningTicket{
+			SessionID: sessionID,
+			Ticket: &pm.Ticket{
+				Sender:            ethcommon.HexToAddress(sender),
+				Recipient:         ethcommon.HexToAddress(recipient),
+				FaceValue:         new(big.Int).SetBytes(faceValue),
+				WinProb:           new(big.Int).SetBytes(winProb),
+				SenderNonce:       nonce,
+				RecipientRandHash: ethcommon.HexToHash(recipientRandHash),
+			},
+			Sig:           sig,
+			RecipientRand: rand,
+		}
+		t.CreatedAt, _ = time.Parse(sqliteTimeFormat, createdAt)
+		tickets = append(tickets, t)
+	}
+	return tickets, nil
+}
+
+// HasWinningTicket is whether the winning ticket with sig is in the DB
+func (db *DB) HasWinningTicket(sig []byte) (bool, error) {
+	var count int
+	if err := db.queryRow("SELECT count(*) FROM winningTickets WHERE sig = ?", sig).Scan(&count); err != nil {
+		return false, errors.Wrap(err, "failed looking up winning ticket")
+	}
+	return count > 0, nil
+}
+
+// MarkWinningTicketRedeemed records that the winning ticket with sig was
+// submitted for redemption in the tx with txHash
+func (db *DB) MarkWinningTicketRedeemed(sig []byte, txHash ethcommon.Hash) error {
+	glog.V(DEBUG).Infof("db: Marking winning ticket redeemed txHash=%v", txHash.Hex())
+	_, err := db.upsert("ticketRedemptions", []string{"sig"}, []string{"sig", "txHash", "createdAt"}, sig, txHash.Hex(), dbNow())
+	if err != nil {
+		return errors.Wrapf(err, "failed marking winning ticket redeemed in tx %v", txHash.Hex())
+	}
+	return nil
+}
+
+// Redemptions returns the winning tickets submitted for redemption from
+// `from` up to `to`
+func (db *DB) Redemptions(from, to time.Time) ([]*DBRedemption, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	rows, err := db.query(`SELECT r.createdAt, t.sender, t.faceValue, r.txHash FROM ticketRedemptions r
+		JOIN winningTickets t ON t.sig = r.sig
+		WHERE r.createdAt >= ? AND r.createdAt <= ? ORDER BY r.createdAt`,
+		from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		glog.Error("db: Unable to select redemptions ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	redemptions := []*DBRedemption{}
+	for rows.Next() {
+		var r DBRedemption
+		var createdAt string
+		var faceValue []byte
+		if err := rows.Scan(&createdAt, &r.Sender, &faceValue, &r.TxHash); err != nil {
+			glog.Error("db: Unable to fetch redemption ", err)
+			continue
+		}
+		r.CreatedAt, _ = time.Parse(sqliteTimeFormat, createdAt)
+		r.FaceValue = new(big.Int).SetBytes(faceValue)
+		redemptions = append(redemptions, &r)
+	}
+	return redemptions, nil
+}
+
+// Format of the timestamps SQLite sets by default
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// InsertPayment records a payment of amount wei, or LPTU for rewards. The
+// payment is buffered and written with the next flush of the DB's writes.
+func (db *DB) InsertPayment(kind, manifestID, counterparty string, amount *big.Int) error {
+	if db == nil || amount == nil {
+		return nil
+	}
+	stmt, value := db.insertPayment, amount.String()
+	err := db.writer.buffer("inserting payment kind="+kind+" manifestID="+manifestID, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Stmt(stmt).Exec(kind, manifestID, counterparty, value)
+	})
+	if err != nil {
+		glog.Errorf("db: Error inserting payment kind=%v manifestID=%v: %v", kind, manifestID, err)
+		return err
+	}
+	return nil
+}
+
+// Payments returns the payments recorded from `from` up to `to`
+func (db *DB) Payments(from, to time.Time) ([]*DBPayment, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.selectPayments.Query(from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		glog.Error("db: Unable to select payments ", err)
+		return nil, err
+	}
+	defer rows.Close()
+	payments := []*DBPayment{}
+	for rows.Next() {
+		var (
+			p                        DBPayment
+			createdAt, amount        string
+			manifestID, counterparty sql.NullString
+		)
+		if err := rows.Scan(&createdAt, &p.Kind, &manifestID, &counterparty, &amount); err != nil {
+			glog.Error("db: Unable to fetch payment ", err)
+			continue
+		}
+		p.CreatedAt, _ = time.Parse(sqliteTimeFormat, createdAt)
+		p.ManifestID, p.Counterparty = manifestID.String, counterparty.String
+		var ok bool
+		if p.Amount, ok = new(big.Int).SetString(amount, 10); !ok {
+			glog.Errorf("db: Unable to convert amount string %v to big int", amount)
+			continue
+		}
+		payments = append(payments, &p)
 	}
-	return "SELECT sender, recipient, faceValue, winProb, senderNonce, recipientRand, recipientRandHash, sig, sessionID FROM winningTickets WHERE sessionID IN (" + strings.Join(sessionIDs, ", ") + ")"
+	return payments, nil
 }
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DBAuditEntry is an administrative action taken on the node. The audit log
+// is only appended to; entries are never updated, nor pruned, which the DB
+// enforces. Each action has an entry as it's requested, with a Status of 0,
+// and one once it's done.
+type DBAuditEntry struct {
+	CreatedAt time.Time `json:"createdAt"`
+	RequestID string    `json:"requestID"`
+	// Caller is how the request was authenticated, e.g. `token:funds:1a2b3c4d`
+	Caller     string `json:"caller"`
+	RemoteAddr string `json:"remoteAddr"`
+	// Action is the endpoint called
+	Action string `json:"action"`
+	// Params is the JSON object of the parameters of the request
+	Params string `json:"params"`
+	// Status is the HTTP status the node responded with; 0 while requested
+	Status int `json:"status"`
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (db *DB) InsertAuditEntry(e *DBAuditEntry) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.exec("INSERT INTO auditLog(requestID, caller, remoteAddr, action, params, status) VALUES(?, ?, ?, ?, ?, ?)",
+		e.RequestID, e.Caller, e.RemoteAddr, e.Action, e.Params, e.Status)
+	if err != nil {
+		return errors.Wrap(err, "failed inserting audit entry")
+	}
+	return nil
+}
+
+// AuditLog returns the entries of the audit log from `from` up to `to`
+func (db *DB) AuditLog(from, to time.Time) ([]*DBAuditEntry, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.query("SELECT createdAt, requestID, caller, remoteAddr, action, params, status FROM auditLog WHERE createdAt >= ? AND createdAt < ? ORDER BY createdAt",
+		from.UTC().Format(sqliteTimeFormat), to.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting audit log")
+	}
+	defer rows.Close()
+
+	entries := []*DBAuditEntry{}
+	for rows.Next() {
+		var (
+			e         DBAuditEntry
+			createdAt string
+		)
+		if err := rows.Scan(&createdAt, &e.RequestID, &e.Caller, &e.RemoteAddr, &e.Action, &e.Params, &e.Status); err != nil {
+			return nil, errors.Wrap(err, "failed scanning audit entry")
+		}
+		e.CreatedAt, _ = time.Parse(sqliteTimeFormat, createdAt)
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
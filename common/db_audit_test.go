@@ -0,0 +1,55 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBAuditLog(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	from := time.Now().Add(-time.Minute)
+	entries, err := dbh.AuditLog(from, time.Now().Add(time.Minute))
+	require.Nil(err)
+	assert.Empty(entries)
+
+	e1 := &DBAuditEntry{RequestID: "a", Caller: "token:funds:1a2b3c4d", RemoteAddr: "10.0.0.1:1234", Action: "/bond", Params: `{"amount":["1"]}`, Status: 200}
+	e2 := &DBAuditEntry{RequestID: "b", Caller: "socket", Action: "/setGasPrice", Params: "{}", Status: 400}
+	require.Nil(dbh.InsertAuditEntry(e1))
+	require.Nil(dbh.InsertAuditEntry(e2))
+
+	entries, err = dbh.AuditLog(from, time.Now().Add(time.Minute))
+	require.Nil(err)
+	require.Len(entries, 2)
+	byID := map[string]*DBAuditEntry{entries[0].RequestID: entries[0], entries[1].RequestID: entries[1]}
+	require.Contains(byID, "a")
+	assert.Equal("token:funds:1a2b3c4d", byID["a"].Caller)
+	assert.Equal("10.0.0.1:1234", byID["a"].RemoteAddr)
+	assert.Equal("/bond", byID["a"].Action)
+	assert.Equal(`{"amount":["1"]}`, byID["a"].Params)
+	assert.Equal(200, byID["a"].Status)
+	assert.WithinDuration(time.Now(), byID["a"].CreatedAt, time.Minute)
+	require.Contains(byID, "b")
+	assert.Equal(400, byID["b"].Status)
+
+	// Entries outside the window aren't returned
+	entries, err = dbh.AuditLog(from.Add(-time.Hour), from)
+	require.Nil(err)
+	assert.Empty(entries)
+	// Entries can't be changed or deleted
+	_, err = dbraw.Exec("UPDATE auditLog SET status = 200 WHERE requestID = 'b'")
+	assert.Contains(err.Error(), "append-only")
+	_, err = dbraw.Exec("DELETE FROM auditLog")
+	assert.Contains(err.Error(), "append-only")
+	entries, err = dbh.AuditLog(from, time.Now().Add(time.Minute))
+	require.Nil(err)
+	assert.Len(entries, 2)
+}
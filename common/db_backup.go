@@ -0,0 +1,132 @@
+package common
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// The online backup API works on the driver's connections, which the hook
+// of the driver registered for backups hands over
+const backupDriver = "sqlite3_backup"
+
+var (
+	backupMu   sync.Mutex
+	backupConn *sqlite3.SQLiteConn
+)
+
+// How long a backup is retried for while the DB is locked, and how often
+var (
+	backupTimeout       = 30 * time.Second
+	backupRetryInterval = 100 * time.Millisecond
+)
+
+func init() {
+	sql.Register(backupDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			backupConn = conn
+			return nil
+		},
+	})
+}
+
+// BackupDB writes a consistent snapshot of the SQLite DB at dbPath to
+// backupPath, which mustn't exist yet. The node may keep running, and
+// writing to the DB, while the backup is made.
+func BackupDB(dbPath, backupPath string) error {
+	if _, ok := dialectOf(dbPath).(sqliteDialect); !ok {
+		return errors.New("only SQLite DBs can be backed up; use the tools of the DB instead")
+	}
+	if _, err := os.Stat(backupPath); err == nil {
+		return errors.Errorf("backup %v already exists", backupPath)
+	}
+	return copyDB(dbPath, backupPath)
+}
+
+// RestoreDB replaces the SQLite DB at dbPath with the backup at backupPath,
+// e.g. one made by BackupDB. The node must not be running on the DB. Backups
+// made by older nodes are migrated as the node starts.
+func RestoreDB(backupPath, dbPath string) error {
+	if _, ok := dialectOf(dbPath).(sqliteDialect); !ok {
+		return errors.New("only SQLite DBs can be restored; use the tools of the DB instead")
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		return err
+	}
+	backup, err := sql.Open("sqlite3", backupPath)
+	if err != nil {
+		return err
+	}
+	version, err := dbVersion(backup)
+	backup.Close()
+	if err != nil {
+		return errors.Wrapf(err, "%v is not a node DB", backupPath)
+	}
+	if version > LivepeerDBVersion {
+		return ErrDBTooNew
+	}
+	return copyDB(backupPath, dbPath)
+}
+
+// copyDB copies the DB at src over the one at dst with the online backup API.
+// All pages are copied in one step, while src is locked for reading, so the
+// copy is consistent.
+func copyDB(src, dst string) error {
+	backupMu.Lock()
+	defer backupMu.Unlock()
+
+	open := func(path string) (*sql.DB, *sqlite3.SQLiteConn, error) {
+		db, err := sql.Open(backupDriver, path)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Keep to the connection the hook saw
+		db.SetMaxOpenConns(1)
+		backupConn = nil
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, nil, err
+		}
+		return db, backupConn, nil
+	}
+
+	srcDB, srcConn, err := open(src)
+	if err != nil {
+		return errors.Wrapf(err, "failed opening %v", src)
+	}
+	defer srcDB.Close()
+	dstDB, dstConn, err := open(dst)
+	if err != nil {
+		return errors.Wrapf(err, "failed opening %v", dst)
+	}
+	defer dstDB.Close()
+
+	backup, err := dstConn.Backup("main", srcConn, "main")
+	if err != nil {
+		return errors.Wrap(err, "failed starting backup")
+	}
+	deadline := time.Now().Add(backupTimeout)
+	for {
+		done, err := backup.Step(-1)
+		if err != nil {
+			backup.Finish()
+			return errors.Wrap(err, "failed copying DB")
+		}
+		if done {
+			break
+		}
+		// Locked by a writer, e.g. the node
+		if time.Now().After(deadline) {
+			backup.Finish()
+			return errors.Errorf("%v stayed locked for %v", src, backupTimeout)
+		}
+		time.Sleep(backupRetryInterval)
+	}
+	glog.V(DEBUG).Infof("db: Copied %d pages from %v to %v", backup.PageCount(), src, dst)
+	return backup.Finish()
+}
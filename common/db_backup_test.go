@@ -0,0 +1,60 @@
+package common
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestoreDB(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", t.Name())
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	dbPath, backupPath := filepath.Join(dir, "lp.sqlite3"), filepath.Join(dir, "backup.sqlite3")
+
+	dbh, err := InitDB(dbPath)
+	require.Nil(err)
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+
+	// Backed up while the node has the DB open
+	require.Nil(BackupDB(dbPath, backupPath))
+	assert.NotNil(BackupDB(dbPath, backupPath))
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	dbh.Close()
+
+	require.Nil(RestoreDB(backupPath, dbPath))
+	dbh, err = InitDB(dbPath)
+	require.Nil(err)
+	defer dbh.Close()
+	ok, err := dbh.HasWinningTicket(sig)
+	require.Nil(err)
+	assert.True(ok)
+	payments, err := dbh.Payments(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.Nil(err)
+	assert.Empty(payments)
+}
+
+func TestRestoreDB_Errors(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", t.Name())
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "lp.sqlite3")
+
+	assert.NotNil(RestoreDB(filepath.Join(dir, "nonexistent"), dbPath))
+	notDB := filepath.Join(dir, "notdb")
+	require.Nil(t, ioutil.WriteFile(notDB, []byte("foo"), 0644))
+	assert.NotNil(RestoreDB(notDB, dbPath))
+
+	assert.NotNil(RestoreDB(notDB, "postgres://localhost/livepeer"))
+	assert.NotNil(BackupDB("postgres://localhost/livepeer", filepath.Join(dir, "backup")))
+}
@@ -0,0 +1,320 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"os/exec"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/pkg/errors"
+)
+
+// ErrDBKey is returned when the DB was encrypted with a different key
+var ErrDBKey = errors.New("DB encrypted with a different key")
+
+var errDBEncrypted = errors.New("winning ticket is encrypted; the DB's key is needed to load it")
+
+var errStreamKeyEncrypted = errors.New("stream key is encrypted; the DB's key is needed to load it")
+
+// Sealed data starts with the version of its encoding. Data sealed since
+// version 2 is bound to the row it's stored in, so it can't be moved to
+// another; data of version 1 is only opened to be sealed again.
+const (
+	dbSealedVersionUnbound = 1
+	dbSealedVersion        = 2
+)
+
+// DBKeyMessage is signed by a node's account to derive the key of its DB.
+// Signatures are deterministic, so the key is the same whenever the account
+// is unlocked.
+var DBKeyMessage = crypto.Keccak256([]byte("livepeer node DB encryption key"))
+
+// DBKeyFromSignature is the DB key derived from the signature of
+// DBKeyMessage by the node's account
+func DBKeyFromSignature(sig []byte) []byte {
+	return crypto.Keccak256(sig)
+}
+
+// DBKeyFromCommand runs the shell command keyCommand for the hex encoded DB
+// key it prints
+func DBKeyFromCommand(keyCommand string) ([]byte, error) {
+	out, err := exec.Command("sh", "-c", keyCommand).Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "error running DB key command")
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, errors.New("DB key command must print a hex encoded key")
+	}
+	return key, nil
+}
+
+// dbKeyCheckAAD binds the key check to its row of kv
+var dbKeyCheckAAD = []byte("kv:dbKeyCheck")
+
+// dbKeyCheck is sealed into kv as the DB is first encrypted, to tell whether
+// the key it's opened with is the same
+var dbKeyCheck = []byte("livepeer")
+
+// streamKeyAAD binds a sealed stream key to the stream of its row of
+// streamState
+func streamKeyAAD(manifestID string) []byte {
+	return []byte("streamState:streamKey:" + manifestID)
+}
+
+// Encrypted ticket secrets are stored in place of the recipientRand, which
+// is no longer than this
+const recipientRandSize = 32
+
+// dbCipher encrypts fields of the DB with AES-256-GCM
+type dbCipher struct {
+	aead cipher.AEAD
+}
+
+func newDBCipher(key []byte) (*dbCipher, error) {
+	if len(key) != 32 {
+		return nil, errors.Errorf("DB key must be 32 bytes, not %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &dbCipher{aead: aead}, nil
+}
+
+// seal encrypts plaintext, bound to aad, which must be given to open it
+func (c *dbCipher) seal(plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append([]byte{dbSealedVersion}, nonce...)
+	return c.aead.Seal(sealed, nonce, plaintext, aad), nil
+}
+
+func (c *dbCipher) open(sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < 1+c.aead.NonceSize() || sealed[0] != dbSealedVersion {
+		return nil, errors.New("invalid sealed data")
+	}
+	nonce := sealed[1 : 1+c.aead.NonceSize()]
+	return c.aead.Open(nil, nonce, sealed[1+c.aead.NonceSize():], aad)
+}
+
+// openUnbound decrypts data sealed before it was bound to its row
+func (c *dbCipher) openUnbound(sealed []byte) ([]byte, error) {
+	if len(sealed) < 1+c.aead.NonceSize() || sealed[0] != dbSealedVersionUnbound {
+		return nil, errors.New("invalid sealed data")
+	}
+	nonce := sealed[1 : 1+c.aead.NonceSize()]
+	return c.aead.Open(nil, nonce, sealed[1+c.aead.NonceSize():], nil)
+}
+
+// sealTicketSecrets encrypts the recipientRand and sender nonce of the ticket
+// with sig together, to be stored as its recipientRand
+func (c *dbCipher) sealTicketSecrets(recipientRand *big.Int, senderNonce uint32, sig []byte) ([]byte, error) {
+	secrets := ethcommon.LeftPadBytes(recipientRand.Bytes(), recipientRandSize)
+	secrets = append(secrets, make([]byte, 4)...)
+	binary.BigEndian.PutUint32(secrets[recipientRandSize:], senderNonce)
+	return c.seal(secrets, sig)
+}
+
+// openTicketSecrets returns the recipientRand and sender nonce of the ticket
+// with sig as stored, decrypting them if they're encrypted
+func (db *DB) openTicketSecrets(recipientRand []byte, senderNonce uint32, sig []byte) (*big.Int, uint32, error) {
+	if len(recipientRand) <= recipientRandSize {
+		return new(big.Int).SetBytes(recipientRand), senderNonce, nil
+	}
+	if db.cipher == nil {
+		return nil, 0, errDBEncrypted
+	}
+	secrets, err := db.cipher.open(recipientRand, sig)
+	if err != nil || len(secrets) != recipientRandSize+4 {
+		return nil, 0, errors.Wrap(ErrDBKey, "failed decrypting winning ticket")
+	}
+	return new(big.Int).SetBytes(secrets[:recipientRandSize]), binary.BigEndian.Uint32(secrets[recipientRandSize:]), nil
+}
+
+// sealStreamKey returns the stream key of the stream with manifestID as it's
+// stored: in the clear, or sealed in place of it if the DB is encrypted
+func (db *DB) sealStreamKey(manifestID, key string) (string, []byte, error) {
+	if db.cipher == nil || key == "" {
+		return key, nil, nil
+	}
+	sealed, err := db.cipher.seal([]byte(key), streamKeyAAD(manifestID))
+	if err != nil {
+		return "", nil, err
+	}
+	return "", sealed, nil
+}
+
+// openStreamKey decrypts the sealed stream key of the stream with manifestID
+func (db *DB) openStreamKey(manifestID string, sealed []byte) (string, error) {
+	if db.cipher == nil {
+		return "", errStreamKeyEncrypted
+	}
+	key, err := db.cipher.open(sealed, streamKeyAAD(manifestID))
+	if err != nil {
+		return "", errors.Wrap(ErrDBKey, "failed decrypting stream key")
+	}
+	return string(key), nil
+}
+
+// Encrypted is whether the DB was encrypted with SetEncryptionKey
+func (db *DB) Encrypted() (bool, error) {
+	var n int
+	err := db.queryRow("SELECT count(*) FROM kv WHERE key = 'dbKeyCheck'").Scan(&n)
+	return n > 0, err
+}
+
+// SetEncryptionKey encrypts the secrets of the winning tickets and the stream
+// keys stored from now on, and those already stored, with key. A DB encrypted once must be
+// opened with the same key from then on; ErrDBKey is returned otherwise.
+func (db *DB) SetEncryptionKey(key []byte) error {
+	c, err := newDBCipher(key)
+	if err != nil {
+		return err
+	}
+
+	var check string
+	err = db.queryRow("SELECT value FROM kv WHERE key = 'dbKeyCheck'").Scan(&check)
+	switch {
+	case err == sql.ErrNoRows:
+		sealed, err := c.seal(dbKeyCheck, dbKeyCheckAAD)
+		if err != nil {
+			return err
+		}
+		if _, err := db.exec("INSERT INTO kv(key, value) VALUES('dbKeyCheck', ?)", hex.EncodeToString(sealed)); err != nil {
+			return errors.Wrap(err, "failed storing DB key check")
+		}
+	case err != nil:
+		return errors.Wrap(err, "failed loading DB key check")
+	default:
+		sealed, err := hex.DecodeString(check)
+		if err != nil {
+			return errors.Wrap(err, "invalid DB key check")
+		}
+		if len(sealed) > 0 && sealed[0] == dbSealedVersionUnbound {
+			if plaintext, err := c.openUnbound(sealed); err != nil || !bytes.Equal(plaintext, dbKeyCheck) {
+				return ErrDBKey
+			}
+			if sealed, err = c.seal(dbKeyCheck, dbKeyCheckAAD); err != nil {
+				return err
+			}
+			if _, err := db.exec("UPDATE kv SET value = ? WHERE key = 'dbKeyCheck'", hex.EncodeToString(sealed)); err != nil {
+				return errors.Wrap(err, "failed storing DB key check")
+			}
+		} else if plaintext, err := c.open(sealed, dbKeyCheckAAD); err != nil || !bytes.Equal(plaintext, dbKeyCheck) {
+			return ErrDBKey
+		}
+	}
+	db.cipher = c
+
+	n, err := db.encryptWinningTickets()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		glog.Infof("db: Encrypted the secrets of %d winning tickets", n)
+	}
+	if n, err = db.encryptStreamKeys(); err != nil {
+		return err
+	}
+	if n > 0 {
+		glog.Infof("db: Encrypted the stream keys of %d streams", n)
+	}
+	return nil
+}
+
+// encryptWinningTickets encrypts the secrets of the winning tickets stored
+// before the DB was encrypted, and seals those sealed before secrets were
+// bound to their tickets again
+func (db *DB) encryptWinningTickets() (int, error) {
+	rows, err := db.query("SELECT sig, recipientRand, senderNonce FROM winningTickets")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed loading winning tickets to encrypt")
+	}
+	type secrets struct {
+		sig, sealed []byte
+	}
+	var plain []secrets
+	for rows.Next() {
+		var sig, recipientRand []byte
+		var senderNonce uint32
+		if err := rows.Scan(&sig, &recipientRand, &senderNonce); err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "failed scanning winning ticket to encrypt")
+		}
+		if len(recipientRand) > recipientRandSize {
+			if recipientRand[0] != dbSealedVersionUnbound {
+				continue
+			}
+			opened, err := db.cipher.openUnbound(recipientRand)
+			if err != nil || len(opened) != recipientRandSize+4 {
+				rows.Close()
+				return 0, errors.Wrap(ErrDBKey, "failed decrypting winning ticket")
+			}
+			recipientRand, senderNonce = opened[:recipientRandSize], binary.BigEndian.Uint32(opened[recipientRandSize:])
+		}
+		sealed, err := db.cipher.sealTicketSecrets(new(big.Int).SetBytes(recipientRand), senderNonce, sig)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain = append(plain, secrets{sig, sealed})
+	}
+	rows.Close()
+
+	for _, s := range plain {
+		if _, err := db.exec("UPDATE winningTickets SET recipientRand = ?, senderNonce = 0 WHERE sig = ?", s.sealed, s.sig); err != nil {
+			return 0, errors.Wrap(err, "failed encrypting winning ticket")
+		}
+	}
+	return len(plain), nil
+}
+
+// encryptStreamKeys seals the stream keys stored before the DB was encrypted
+func (db *DB) encryptStreamKeys() (int, error) {
+	rows, err := db.query("SELECT manifestID, streamKey FROM streamState WHERE streamKey <> ''")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed loading stream keys to encrypt")
+	}
+	type streamKey struct {
+		manifestID, key string
+		sealed          []byte
+	}
+	var plain []streamKey
+	for rows.Next() {
+		var k streamKey
+		if err := rows.Scan(&k.manifestID, &k.key); err != nil {
+			rows.Close()
+			return 0, errors.Wrap(err, "failed scanning stream key to encrypt")
+		}
+		if _, k.sealed, err = db.sealStreamKey(k.manifestID, k.key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain = append(plain, k)
+	}
+	rows.Close()
+
+	// Unless its owner stored another key since
+	for _, k := range plain {
+		if _, err := db.exec("UPDATE streamState SET streamKey = '', sealedStreamKey = ? WHERE manifestID = ? AND streamKey = ?", k.sealed, k.manifestID, k.key); err != nil {
+			return 0, errors.Wrap(err, "failed encrypting stream key")
+		}
+	}
+	return len(plain), nil
+}
@@ -0,0 +1,225 @@
+package common
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/pm"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBEncryption(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbraw.Close()
+
+	key := pm.RandBytes(32)
+	encrypted, err := dbh.Encrypted()
+	require.Nil(err)
+	assert.False(encrypted)
+
+	// Stored before the DB is encrypted
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	assert.NotNil(dbh.SetEncryptionKey(key[:16]))
+	require.Nil(dbh.SetEncryptionKey(key))
+	_, ticket2, sig2, recipientRand2 := defaultWinningTicket(t)
+	ticket2.SenderNonce = 456
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket2, sig2, recipientRand2))
+
+	// No secrets in the clear
+	rows, err := dbraw.Query("SELECT recipientRand, senderNonce FROM winningTickets")
+	require.Nil(err)
+	for rows.Next() {
+		var rand []byte
+		var nonce uint32
+		require.Nil(rows.Scan(&rand, &nonce))
+		assert.True(len(rand) > recipientRandSize)
+		assert.Equal(uint32(0), nonce)
+	}
+	rows.Close()
+
+	// Secrets are bound to their ticket
+	swapped := func() error {
+		_, err := dbh.exec("UPDATE winningTickets SET recipientRand = (SELECT recipientRand FROM winningTickets WHERE sig = ?) WHERE sig = ?", sig2, sig)
+		return err
+	}
+
+	check := func(dbh *DB) {
+		tickets, sigs, rands, err := dbh.LoadWinningTickets([]string{sessionID})
+		require.Nil(err)
+		require.Len(tickets, 2)
+		assert.Equal(ticket, tickets[0])
+		assert.Equal(sig, sigs[0])
+		assert.Equal(recipientRand, rands[0])
+		assert.Equal(ticket2, tickets[1])
+		assert.Equal(recipientRand2, rands[1])
+
+		all, err := dbh.AllWinningTickets()
+		require.Nil(err)
+		require.Len(all, 2)
+		assert.Equal(uint32(456), all[1].Ticket.SenderNonce)
+		assert.Equal(recipientRand2, all[1].RecipientRand)
+	}
+	check(dbh)
+	dbh.Close()
+
+	// Reopened, the tickets can't be loaded without the key
+	dbh, err = InitDB(dbPath(t))
+	require.Nil(err)
+	defer dbh.Close()
+	encrypted, err = dbh.Encrypted()
+	require.Nil(err)
+	assert.True(encrypted)
+	_, _, _, err = dbh.LoadWinningTickets([]string{sessionID})
+	assert.Equal(errDBEncrypted, err)
+	assert.Equal(ErrDBKey, dbh.SetEncryptionKey(pm.RandBytes(32)))
+	require.Nil(dbh.SetEncryptionKey(key))
+	check(dbh)
+
+	require.Nil(swapped())
+	_, _, _, err = dbh.LoadWinningTickets([]string{sessionID})
+	assert.Equal(ErrDBKey, errors.Cause(err))
+}
+
+func TestDBEncryption_StreamKeys(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbraw.Close()
+
+	store := func(manifestID, key string) {
+		s, err := dbh.AcquireStream(manifestID, "a", time.Minute)
+		require.Nil(err)
+		s.StreamKey = key
+		require.Nil(dbh.RenewStream(s, time.Minute))
+	}
+	streamKey := func(manifestID string) (string, error) {
+		s, err := dbh.StreamState(manifestID)
+		if err != nil {
+			return "", err
+		}
+		return s.StreamKey, nil
+	}
+
+	// Stored before the DB is encrypted
+	store("stream1", "key1")
+	key := pm.RandBytes(32)
+	require.Nil(dbh.SetEncryptionKey(key))
+	store("stream2", "key2")
+
+	// No keys in the clear
+	var plain int
+	require.Nil(dbraw.QueryRow("SELECT count(*) FROM streamState WHERE streamKey <> '' OR sealedStreamKey IS NULL").Scan(&plain))
+	assert.Equal(0, plain)
+	for manifestID, want := range map[string]string{"stream1": "key1", "stream2": "key2"} {
+		k, err := streamKey(manifestID)
+		require.Nil(err)
+		assert.Equal(want, k)
+	}
+	dbh.Close()
+
+	// Reopened, the keys can't be loaded without the key of the DB
+	dbh, err = InitDB(dbPath(t))
+	require.Nil(err)
+	defer dbh.Close()
+	_, err = streamKey("stream1")
+	assert.Equal(errStreamKeyEncrypted, err)
+	require.Nil(dbh.SetEncryptionKey(key))
+	k, err := streamKey("stream1")
+	require.Nil(err)
+	assert.Equal("key1", k)
+
+	// Keys are bound to their stream
+	_, err = dbh.exec("UPDATE streamState SET sealedStreamKey = (SELECT sealedStreamKey FROM streamState WHERE manifestID = 'stream2') WHERE manifestID = 'stream1'")
+	require.Nil(err)
+	_, err = streamKey("stream1")
+	assert.Equal(ErrDBKey, errors.Cause(err))
+}
+
+func TestDBEncryption_Unbound(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbraw.Close()
+	defer dbh.Close()
+
+	// Encrypted before secrets were bound to their ticket
+	key := pm.RandBytes(32)
+	c, err := newDBCipher(key)
+	require.Nil(err)
+	unbound := func(plaintext []byte) []byte {
+		nonce := pm.RandBytes(c.aead.NonceSize())
+		return c.aead.Seal(append([]byte{dbSealedVersionUnbound}, nonce...), nonce, plaintext, nil)
+	}
+	_, err = dbh.exec("INSERT INTO kv(key, value) VALUES('dbKeyCheck', ?)", hex.EncodeToString(unbound(dbKeyCheck)))
+	require.Nil(err)
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	secrets := append(ethcommon.LeftPadBytes(recipientRand.Bytes(), recipientRandSize), make([]byte, 4)...)
+	binary.BigEndian.PutUint32(secrets[recipientRandSize:], ticket.SenderNonce)
+	_, err = dbh.exec("UPDATE winningTickets SET recipientRand = ?, senderNonce = 0", unbound(secrets))
+	require.Nil(err)
+
+	assert.Equal(ErrDBKey, dbh.SetEncryptionKey(pm.RandBytes(32)))
+	require.Nil(dbh.SetEncryptionKey(key))
+
+	// Sealed again, bound
+	var rand []byte
+	require.Nil(dbraw.QueryRow("SELECT recipientRand FROM winningTickets").Scan(&rand))
+	assert.Equal(byte(dbSealedVersion), rand[0])
+	var check string
+	require.Nil(dbraw.QueryRow("SELECT value FROM kv WHERE key = 'dbKeyCheck'").Scan(&check))
+	assert.True(strings.HasPrefix(check, "02"))
+	tickets, _, rands, err := dbh.LoadWinningTickets([]string{sessionID})
+	require.Nil(err)
+	require.Len(tickets, 1)
+	assert.Equal(ticket, tickets[0])
+	assert.Equal(recipientRand, rands[0])
+	require.Nil(dbh.SetEncryptionKey(key))
+}
+
+func TestDBCipher(t *testing.T) {
+	assert := assert.New(t)
+	c, err := newDBCipher(pm.RandBytes(32))
+	require.Nil(t, err)
+
+	sealed, err := c.sealTicketSecrets(big.NewInt(1234), 5, []byte("sig"))
+	require.Nil(t, err)
+	// Nonces are random
+	sealed2, err := c.sealTicketSecrets(big.NewInt(1234), 5, []byte("sig"))
+	require.Nil(t, err)
+	assert.NotEqual(sealed, sealed2)
+
+	db := &DB{cipher: c}
+	rand, nonce, err := db.openTicketSecrets(sealed, 0, []byte("sig"))
+	require.Nil(t, err)
+	assert.Equal(big.NewInt(1234), rand)
+	assert.Equal(uint32(5), nonce)
+
+	// Moved to another ticket
+	_, _, err = db.openTicketSecrets(sealed, 0, []byte("other sig"))
+	assert.NotNil(err)
+
+	// Tampered with
+	sealed[len(sealed)-1] ^= 1
+	_, _, err = db.openTicketSecrets(sealed, 0, []byte("sig"))
+	assert.NotNil(err)
+
+	// Stored in the clear
+	rand, nonce, err = db.openTicketSecrets(big.NewInt(42).Bytes(), 7, []byte("sig"))
+	require.Nil(t, err)
+	assert.Equal(big.NewInt(42), rand)
+	assert.Equal(uint32(7), nonce)
+}
@@ -0,0 +1,240 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// dbDialect is an engine the node's data can be stored in. Queries are
+// written in the SQL both engines share, with ? placeholders; the dialect
+// builds the statements that differ between them.
+type dbDialect interface {
+	// driverName is the database/sql driver of the engine
+	driverName() string
+	// types are the column types the schema is created with
+	types() dbTypes
+	// rebind rewrites the ? and ?N placeholders of a query for the engine
+	rebind(query string) string
+	// insertIgnore is the statement inserting a row of cols into table,
+	// unless it conflicts with a row already there
+	insertIgnore(table string, cols ...string) string
+	// upsert are the statements inserting a row of cols into table, or
+	// updating the row with the same key to it. The statements each take
+	// the values of cols, in order, as their arguments.
+	upsert(table string, key []string, cols ...string) []string
+	// maxOpenConns limits the connections to the DB; 0 for no limit
+	maxOpenConns() int
+	// setup are the statements run as the DB is opened
+	setup() []string
+	// optimize are the statements that reclaim space and refresh the
+	// statistics of the query planner
+	optimize() []string
+	// sizeQuery selects the size of the DB in bytes
+	sizeQuery() string
+	// isLocked is whether err is from the DB being locked
+	isLocked(err error) bool
+}
+
+// dbTypes are the column types of an engine, and the default of timestamp
+// columns. Timestamps are stored as text in sqliteTimeFormat by each engine,
+// so they're read and compared the same way. Postgres is set for the
+// statements that differ in more than types, e.g. triggers.
+type dbTypes struct {
+	String   string
+	Blob     string
+	Integer  string
+	Real     string
+	Now      string
+	Postgres bool
+}
+
+// dialectOf returns the engine of a DSN: Postgres for postgres:// URLs,
+// otherwise SQLite, with the DSN the path of the DB file
+func dialectOf(dsn string) dbDialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return postgresDialect{}
+	}
+	return sqliteDialect{memory: strings.Contains(dsn, ":memory:") || strings.Contains(dsn, "mode=memory")}
+}
+
+// values are the placeholders of n values, numbered so that statements
+// can refer to the same argument more than once
+func values(n int) string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = "?" + strconv.Itoa(i+1)
+	}
+	return strings.Join(ps, ", ")
+}
+
+// nonKey are the cols not in key, along with their placeholders
+func nonKey(key []string, cols []string) (names []string, placeholders []string) {
+	for i, col := range cols {
+		isKey := false
+		for _, k := range key {
+			isKey = isKey || k == col
+		}
+		if !isKey {
+			names, placeholders = append(names, col), append(placeholders, "?"+strconv.Itoa(i+1))
+		}
+	}
+	return names, placeholders
+}
+
+type sqliteDialect struct {
+	// memory is whether the DB is in memory rather than in a file
+	memory bool
+}
+
+func (sqliteDialect) driverName() string { return "sqlite3" }
+
+func (sqliteDialect) types() dbTypes {
+	return dbTypes{String: "STRING", Blob: "BLOB", Integer: "INTEGER", Real: "REAL", Now: "CURRENT_TIMESTAMP"}
+}
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) insertIgnore(table string, cols ...string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s(%s) VALUES(%s)", table, strings.Join(cols, ", "), values(len(cols)))
+}
+
+// The SQLite the node is built with predates UPSERT, and INSERT OR REPLACE
+// deletes the row it replaces, along with the columns not inserted, so the
+// row is updated, and only inserted if there wasn't one.
+func (d sqliteDialect) upsert(table string, key []string, cols ...string) []string {
+	insert := d.insertIgnore(table, cols...)
+	names, placeholders := nonKey(key, cols)
+	if len(names) == 0 {
+		return []string{insert}
+	}
+	set := make([]string, len(names))
+	for i := range names {
+		set[i] = names[i] + " = " + placeholders[i]
+	}
+	var where []string
+	for _, k := range key {
+		for i, col := range cols {
+			if col == k {
+				where = append(where, k+" = ?"+strconv.Itoa(i+1))
+			}
+		}
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table, strings.Join(set, ", "), strings.Join(where, " AND "))
+	return []string{update, insert}
+}
+
+// SQLite can only handle one writer at a time, which the DB's writer takes
+// care of. In WAL mode readers don't block the writer, or each other, so they
+// get connections of their own. In memory DBs can't be in WAL mode, and their
+// tables are locked by a connection writing to them, so they're limited to a
+// single connection.
+func (d sqliteDialect) maxOpenConns() int {
+	if d.memory {
+		return 1
+	}
+	return 0
+}
+
+// Writers, e.g. those migrating the DB or running the CLI, outside of the
+// node also wait their turn: the driver sets a busy timeout of 5s on
+// connections by default. WAL mode persists in the DB file once set.
+func (sqliteDialect) setup() []string { return []string{"PRAGMA journal_mode=WAL"} }
+
+func (sqliteDialect) optimize() []string { return []string{"VACUUM", "ANALYZE"} }
+
+func (sqliteDialect) sizeQuery() string {
+	return "SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()"
+}
+
+func (sqliteDialect) isLocked(err error) bool {
+	e, ok := err.(sqlite3.Error)
+	return ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+// postgresDialect stores the data in a Postgres DB that any number of nodes
+// can share
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string { return "postgres" }
+
+func (postgresDialect) types() dbTypes {
+	return dbTypes{
+		String: "TEXT",
+		Blob:   "BYTEA",
+		// SQLite integers and floats are 64 bit
+		Integer:  "BIGINT",
+		Real:     "DOUBLE PRECISION",
+		Now:      `(to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD HH24:MI:SS'))`,
+		Postgres: true,
+	}
+}
+
+// rebind numbers the placeholders $1, $2, ... in order, and ?N as $N.
+// Placeholders in string literals and quoted identifiers are left alone.
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	var quote byte
+	n := 0
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				b.WriteString("$" + query[i+1:j])
+			} else {
+				n++
+				b.WriteString("$" + strconv.Itoa(n))
+			}
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func (postgresDialect) insertIgnore(table string, cols ...string) string {
+	return fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) ON CONFLICT DO NOTHING", table, strings.Join(cols, ", "), values(len(cols)))
+}
+
+func (d postgresDialect) upsert(table string, key []string, cols ...string) []string {
+	names, _ := nonKey(key, cols)
+	if len(names) == 0 {
+		return []string{d.insertIgnore(table, cols...)}
+	}
+	set := make([]string, len(names))
+	for i, col := range names {
+		set[i] = fmt.Sprintf("%s = EXCLUDED.%s", col, col)
+	}
+	return []string{fmt.Sprintf("INSERT INTO %s(%s) VALUES(%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(cols, ", "), values(len(cols)), strings.Join(key, ", "), strings.Join(set, ", "))}
+}
+
+func (postgresDialect) maxOpenConns() int { return 0 }
+
+func (postgresDialect) setup() []string { return nil }
+
+// Postgres autovacuums; ANALYZE refreshes the statistics after a prune
+func (postgresDialect) optimize() []string { return []string{"ANALYZE"} }
+
+func (postgresDialect) sizeQuery() string { return "SELECT pg_database_size(current_database())" }
+
+func (postgresDialect) isLocked(err error) bool {
+	e, ok := err.(*pq.Error)
+	// lock_not_available and deadlock_detected
+	return ok && (e.Code == "55P03" || e.Code == "40P01")
+}
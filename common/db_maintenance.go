@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/pkg/errors"
+)
+
+// DBRetention is how long the rows of the DB are kept for; 0 keeps them
+// forever
+type DBRetention struct {
+	// Orchestrators cached since they were last updated. Those not updated
+	// in the last 24 hours are already ignored by SelectOrchs.
+	Orchestrators time.Duration
+	// WinningTickets redeemed, and their redemptions. Tickets not redeemed
+	// yet are kept however old they are, as they may still be claimed.
+	WinningTickets time.Duration
+	// Payments recorded for each stream
+	Payments time.Duration
+	// OrchestratorPerformance aggregated for each day
+	OrchestratorPerformance time.Duration
+	// StreamStates since the streams were last ingested. The state of the
+	// streams being ingested is updated as their leases are renewed.
+	StreamStates time.Duration
+}
+
+// DBPruned is the number of rows Prune deleted from each table
+type DBPruned struct {
+	Orchestrators           int64
+	WinningTickets          int64
+	TicketRedemptions       int64
+	Payments                int64
+	OrchestratorPerformance int64
+	StreamStates            int64
+}
+
+// Prune deletes the rows older than the retention windows
+func (db *DB) Prune(retention DBRetention) (DBPruned, error) {
+	var pruned DBPruned
+	if db == nil {
+		return pruned, nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return pruned, err
+	}
+
+	now := time.Now()
+	del := func(n *int64, window time.Duration, query string) error {
+		if window <= 0 {
+			return nil
+		}
+		res, err := db.exec(query, now.Add(-window).UTC().Format(sqliteTimeFormat))
+		if err != nil {
+			return err
+		}
+		*n, err = res.RowsAffected()
+		return err
+	}
+
+	if err := del(&pruned.Orchestrators, retention.Orchestrators, "DELETE FROM orchestrators WHERE updatedAt < ?"); err != nil {
+		return pruned, errors.Wrap(err, "failed pruning orchestrators")
+	}
+	// Only redeemed tickets are pruned; the others are still worth their
+	// face value however old they are
+	if err := del(&pruned.WinningTickets, retention.WinningTickets, "DELETE FROM winningTickets WHERE sig IN (SELECT sig FROM ticketRedemptions) AND createdAt < ?"); err != nil {
+		return pruned, errors.Wrap(err, "failed pruning winning tickets")
+	}
+	if retention.WinningTickets > 0 {
+		res, err := db.exec("DELETE FROM ticketRedemptions WHERE sig NOT IN (SELECT sig FROM winningTickets)")
+		if err == nil {
+			pruned.TicketRedemptions, err = res.RowsAffected()
+		}
+		if err != nil {
+			return pruned, errors.Wrap(err, "failed pruning ticket redemptions")
+		}
+	}
+	if err := del(&pruned.Payments, retention.Payments, "DELETE FROM payments WHERE createdAt < ?"); err != nil {
+		return pruned, errors.Wrap(err, "failed pruning payments")
+	}
+	// Days sort the same as timestamps, which they're the prefix of
+	if err := del(&pruned.OrchestratorPerformance, retention.OrchestratorPerformance, "DELETE FROM orchestratorPerformance WHERE day < ?"); err != nil {
+		return pruned, errors.Wrap(err, "failed pruning orchestrator performance")
+	}
+	if err := del(&pruned.StreamStates, retention.StreamStates, "DELETE FROM streamState WHERE updatedAt < ?"); err != nil {
+		return pruned, errors.Wrap(err, "failed pruning stream states")
+	}
+	return pruned, nil
+}
+
+// Optimize reclaims the space of deleted rows and updates the statistics
+// the query planner uses
+func (db *DB) Optimize() error {
+	if db == nil {
+		return nil
+	}
+	// VACUUM can't run in a transaction, or with writes in progress
+	return db.writer.exclusive(func() error {
+		for _, stmt := range db.dialect.optimize() {
+			if _, err := db.dbh.Exec(stmt); err != nil {
+				return errors.Wrapf(err, "failed running %v", stmt)
+			}
+		}
+		return nil
+	})
+}
+
+// Maintain prunes and optimizes the DB every interval until ctx is done
+func (db *DB) Maintain(ctx context.Context, interval time.Duration, retention DBRetention) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			pruned, err := db.Prune(retention)
+			if err != nil {
+				glog.Errorf("db: Error pruning DB: %v", err)
+				continue
+			}
+			if err := db.Optimize(); err != nil {
+				glog.Errorf("db: Error optimizing DB: %v", err)
+				continue
+			}
+			glog.Infof("db: Maintenance done in %v; pruned orchestrators=%d winningTickets=%d ticketRedemptions=%d payments=%d orchestratorPerformance=%d streamStates=%d",
+				time.Since(start), pruned.Orchestrators, pruned.WinningTickets, pruned.TicketRedemptions, pruned.Payments, pruned.OrchestratorPerformance, pruned.StreamStates)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBPrune(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	require.Nil(dbh.UpdateOrch(NewDBOrch("https://127.0.0.1:8936", "0x1")))
+	_, err = dbh.exec("INSERT INTO orchestrators(updatedAt, serviceURI, ethereumAddr) VALUES(?, 'https://127.0.0.1:8937', '0x2')", dbTimeAgo(10*24*time.Hour))
+	require.Nil(err)
+
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	_, ticket, oldSig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, oldSig, recipientRand))
+	_, err = dbh.exec("UPDATE winningTickets SET createdAt = ? WHERE sig = ?", dbTimeAgo(40*24*time.Hour), oldSig)
+	require.Nil(err)
+	require.Nil(dbh.MarkWinningTicketRedeemed(oldSig, ethcommon.HexToHash("0xabc")))
+	_, ticket, unredeemedSig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, unredeemedSig, recipientRand))
+	_, err = dbh.exec("UPDATE winningTickets SET createdAt = ? WHERE sig = ?", dbTimeAgo(400*24*time.Hour), unredeemedSig)
+	require.Nil(err)
+
+	require.Nil(dbh.InsertPayment(PaymentTicketSent, "stream1", "0x1", big.NewInt(100)))
+	_, err = dbh.exec("INSERT INTO payments(createdAt, kind, amount) VALUES(?, 'ticketWon', '7')", dbTimeAgo(100*24*time.Hour))
+	require.Nil(err)
+
+	// Nothing is pruned without retention windows
+	pruned, err := dbh.Prune(DBRetention{})
+	require.Nil(err)
+	assert.Equal(DBPruned{}, pruned)
+
+	pruned, err = dbh.Prune(DBRetention{
+		Orchestrators:  7 * 24 * time.Hour,
+		WinningTickets: 30 * 24 * time.Hour,
+		Payments:       90 * 24 * time.Hour,
+	})
+	require.Nil(err)
+	assert.Equal(DBPruned{Orchestrators: 1, WinningTickets: 1, TicketRedemptions: 1, Payments: 1}, pruned)
+
+	orchs, err := dbh.AllOrchs()
+	require.Nil(err)
+	require.Len(orchs, 1)
+	assert.Equal("0x1", orchs[0].EthereumAddr)
+	ok, err := dbh.HasWinningTicket(sig)
+	require.Nil(err)
+	assert.True(ok)
+	ok, err = dbh.HasWinningTicket(oldSig)
+	require.Nil(err)
+	assert.False(ok)
+	// Tickets not redeemed yet may still be claimed, however old
+	ok, err = dbh.HasWinningTicket(unredeemedSig)
+	require.Nil(err)
+	assert.True(ok)
+	assert.Equal(0, getRowCountOrFatal("SELECT count(*) FROM ticketRedemptions", dbraw, t))
+	assert.Equal(1, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+
+	assert.Nil(dbh.Optimize())
+}
+
+func TestDBPrune_NilDB(t *testing.T) {
+	var dbh *DB
+	pruned, err := dbh.Prune(DBRetention{Payments: time.Hour})
+	assert.Nil(t, err)
+	assert.Equal(t, DBPruned{}, pruned)
+	assert.Nil(t, dbh.Optimize())
+}
@@ -0,0 +1,352 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// dbBaseVersion is the version of the schema DBs are created with, before
+// the migrations are run on them
+const dbBaseVersion = 1
+
+// dbMigration changes the schema from version-1 to version with up, and
+// back with down. Both are templates of the statements, executed with the
+// column types of the dialect. Each runs in a transaction along with the update of the
+// version, so a migration is applied in full or not at all.
+type dbMigration struct {
+	version     int
+	description string
+	up          string
+	down        string
+}
+
+// dbMigrations change the schema in order. To change the schema, append a
+// migration and bump LivepeerDBVersion to its version; never edit the ones
+// already released.
+var dbMigrations = []dbMigration{
+	{
+		version:     2,
+		description: "track the redemptions of winning tickets",
+		// Nodes built before there were migrations created the table
+		// along with the rest of the schema
+		up: `
+			CREATE TABLE IF NOT EXISTS ticketRedemptions (
+				createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				sig {{ .Blob }} PRIMARY KEY,
+				txHash {{ .String }}
+			);
+			CREATE INDEX IF NOT EXISTS idx_ticketredemptions_createdat ON ticketRedemptions(createdAt);
+		`,
+		down: `
+			DROP INDEX IF EXISTS idx_ticketredemptions_createdat;
+			DROP TABLE IF EXISTS ticketRedemptions;
+		`,
+	},
+	{
+		version:     3,
+		description: "keep daily aggregates of the performance of orchestrators",
+		up: `
+			CREATE TABLE orchestratorPerformance (
+				day {{ .String }} NOT NULL,
+				orchestrator {{ .String }} NOT NULL,
+				segments {{ .Integer }} DEFAULT 0 NOT NULL,
+				succeeded {{ .Integer }} DEFAULT 0 NOT NULL,
+				-- total over the segments that succeeded
+				latencyMs {{ .Integer }} DEFAULT 0 NOT NULL,
+				-- wei, the expected value of the tickets sent
+				paid {{ .Real }} DEFAULT 0 NOT NULL,
+				PRIMARY KEY(day, orchestrator)
+			);
+		`,
+		down: `
+			DROP TABLE orchestratorPerformance;
+		`,
+	},
+	{
+		version:     4,
+		description: "keep the tokens remote transcoders authenticate with",
+		up: `
+			CREATE TABLE transcoderTokens (
+				id {{ .String }} PRIMARY KEY,
+				label {{ .String }} DEFAULT '' NOT NULL,
+				-- sha256 of the token, which isn't kept
+				hash {{ .Blob }} NOT NULL,
+				-- unix seconds; expiresAt is 0 for tokens that don't expire
+				createdAt {{ .Integer }} NOT NULL,
+				expiresAt {{ .Integer }} DEFAULT 0 NOT NULL,
+				revoked {{ .Integer }} DEFAULT 0 NOT NULL
+			);
+		`,
+		down: `
+			DROP TABLE transcoderTokens;
+		`,
+	},
+	{
+		version:     5,
+		description: "keep an audit log of administrative actions",
+		up: `
+			CREATE TABLE auditLog (
+				createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				requestID {{ .String }} NOT NULL,
+				-- who made the request: how it was authenticated, and from where
+				caller {{ .String }} NOT NULL,
+				remoteAddr {{ .String }} DEFAULT '' NOT NULL,
+				action {{ .String }} NOT NULL,
+				-- JSON object of the form and query parameters, secrets masked
+				params {{ .String }} DEFAULT '{}' NOT NULL,
+				-- HTTP status of the response
+				status {{ .Integer }} NOT NULL
+			);
+			CREATE INDEX idx_auditlog_createdat ON auditLog(createdAt);
+		`,
+		down: `
+			DROP INDEX idx_auditlog_createdat;
+			DROP TABLE auditLog;
+		`,
+	},
+	{
+		version:     6,
+		description: "share the state of streams between broadcasters failing over",
+		up: `
+			CREATE TABLE streamState (
+				manifestID {{ .String }} PRIMARY KEY,
+				-- failover ID of the broadcaster ingesting the stream, and
+				-- until when, in unix seconds, it holds the stream
+				owner {{ .String }} DEFAULT '' NOT NULL,
+				leaseExpiresAt {{ .Integer }} DEFAULT 0 NOT NULL,
+				streamKey {{ .String }} DEFAULT '' NOT NULL,
+				-- comma separated names of the renditions
+				profiles {{ .String }} DEFAULT '' NOT NULL,
+				-- of the last source segment ingested; -1 before the first
+				lastSeqNo {{ .Integer }} DEFAULT -1 NOT NULL,
+				-- JSON array of the orchestrators the stream has sessions with
+				orchestrators {{ .String }} DEFAULT '[]' NOT NULL,
+				updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL
+			);
+			CREATE INDEX idx_streamstate_updatedat ON streamState(updatedAt);
+		`,
+		down: `
+			DROP INDEX idx_streamstate_updatedat;
+			DROP TABLE streamState;
+		`,
+	},
+	{
+		version:     7,
+		description: "keep the audit log from being updated or deleted from",
+		up: `
+			{{ if .Postgres }}
+			CREATE FUNCTION auditlog_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'the audit log is append-only';
+			END;
+			$$ LANGUAGE plpgsql;
+			CREATE TRIGGER auditlog_append_only BEFORE UPDATE OR DELETE ON auditLog
+				FOR EACH ROW EXECUTE PROCEDURE auditlog_append_only();
+			CREATE TRIGGER auditlog_no_truncate BEFORE TRUNCATE ON auditLog
+				FOR EACH STATEMENT EXECUTE PROCEDURE auditlog_append_only();
+			{{ else }}
+			CREATE TRIGGER auditlog_no_update BEFORE UPDATE ON auditLog
+			BEGIN
+				SELECT RAISE(ABORT, 'the audit log is append-only');
+			END;
+			CREATE TRIGGER auditlog_no_delete BEFORE DELETE ON auditLog
+			BEGIN
+				SELECT RAISE(ABORT, 'the audit log is append-only');
+			END;
+			{{ end }}
+		`,
+		down: `
+			{{ if .Postgres }}
+			DROP TRIGGER auditlog_no_truncate ON auditLog;
+			DROP TRIGGER auditlog_append_only ON auditLog;
+			DROP FUNCTION auditlog_append_only();
+			{{ else }}
+			DROP TRIGGER auditlog_no_delete;
+			DROP TRIGGER auditlog_no_update;
+			{{ end }}
+		`,
+	},
+	{
+		version:     8,
+		description: "keep the pools remote transcoders are routed segments by",
+		up: `
+			CREATE TABLE transcoderRules (
+				name {{ .String }} PRIMARY KEY,
+				-- rules are matched in order of position
+				position {{ .Integer }} NOT NULL,
+				manifestID {{ .String }} DEFAULT '' NOT NULL,
+				minHeight {{ .Integer }} DEFAULT 0 NOT NULL,
+				-- JSON array of the tags of the transcoders routed to
+				tags {{ .String }} NOT NULL
+			);
+			CREATE TABLE transcoderTags (
+				-- the ID remote transcoders register with
+				id {{ .String }} PRIMARY KEY,
+				-- JSON array of the tags the operator assigned it
+				tags {{ .String }} NOT NULL
+			);
+		`,
+		down: `
+			DROP TABLE transcoderTags;
+			DROP TABLE transcoderRules;
+		`,
+	},
+	{
+		version:     9,
+		description: "keep every archive of a stream rather than only the last",
+		// Nodes built before there were migrations created the table, with
+		// an archive per stream, along with the rest of the schema
+		up: `
+			CREATE TABLE IF NOT EXISTS recordings (
+				manifestID {{ .String }} PRIMARY KEY,
+				createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				cid {{ .String }} NOT NULL,
+				size {{ .Integer }},
+				deals {{ .String }} DEFAULT '[]' NOT NULL
+			);
+			CREATE TABLE recordings_v9 (
+				manifestID {{ .String }} NOT NULL,
+				-- streams archived again, e.g. as they reconnect, have an
+				-- archive of the segments since
+				cid {{ .String }} NOT NULL,
+				createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				size {{ .Integer }},
+				-- JSON array of the Filecoin deals of the archive
+				deals {{ .String }} DEFAULT '[]' NOT NULL,
+				PRIMARY KEY(manifestID, cid)
+			);
+			INSERT INTO recordings_v9(manifestID, cid, createdAt, updatedAt, size, deals)
+				SELECT manifestID, cid, createdAt, updatedAt, size, deals FROM recordings;
+			DROP TABLE recordings;
+			ALTER TABLE recordings_v9 RENAME TO recordings;
+			CREATE INDEX idx_recordings_cid ON recordings(cid);
+		`,
+		// Only the last archive of each stream is kept
+		down: `
+			DROP INDEX idx_recordings_cid;
+			CREATE TABLE recordings_v8 (
+				manifestID {{ .String }} PRIMARY KEY,
+				createdAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL,
+				cid {{ .String }} NOT NULL,
+				size {{ .Integer }},
+				deals {{ .String }} DEFAULT '[]' NOT NULL
+			);
+			INSERT {{ if not .Postgres }}OR IGNORE {{ end }}INTO recordings_v8(manifestID, createdAt, updatedAt, cid, size, deals)
+				SELECT manifestID, createdAt, updatedAt, cid, size, deals FROM recordings ORDER BY createdAt DESC
+				{{ if .Postgres }}ON CONFLICT DO NOTHING{{ end }};
+			DROP TABLE recordings;
+			ALTER TABLE recordings_v8 RENAME TO recordings;
+		`,
+	},
+	{
+		version:     10,
+		description: "keep the stream keys given by the auth webhook sealed once the DB is encrypted",
+		up: `
+			-- stream key sealed with the DB's key, in place of streamKey
+			ALTER TABLE streamState ADD COLUMN sealedStreamKey {{ .Blob }};
+		`,
+		// Sealed stream keys are dropped; their streams get new ones
+		down: `
+			{{ if .Postgres }}
+			ALTER TABLE streamState DROP COLUMN sealedStreamKey;
+			{{ else }}
+			CREATE TABLE streamState_v9 (
+				manifestID {{ .String }} PRIMARY KEY,
+				owner {{ .String }} DEFAULT '' NOT NULL,
+				leaseExpiresAt {{ .Integer }} DEFAULT 0 NOT NULL,
+				streamKey {{ .String }} DEFAULT '' NOT NULL,
+				profiles {{ .String }} DEFAULT '' NOT NULL,
+				lastSeqNo {{ .Integer }} DEFAULT -1 NOT NULL,
+				orchestrators {{ .String }} DEFAULT '[]' NOT NULL,
+				updatedAt {{ .String }} DEFAULT {{ .Now }} NOT NULL
+			);
+			INSERT INTO streamState_v9(manifestID, owner, leaseExpiresAt, streamKey, profiles, lastSeqNo, orchestrators, updatedAt)
+				SELECT manifestID, owner, leaseExpiresAt, streamKey, profiles, lastSeqNo, orchestrators, updatedAt FROM streamState;
+			DROP TABLE streamState;
+			ALTER TABLE streamState_v9 RENAME TO streamState;
+			CREATE INDEX idx_streamstate_updatedat ON streamState(updatedAt);
+			{{ end }}
+		`,
+	},
+}
+
+// dbVersion returns the schema version of the DB
+func dbVersion(db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRow("SELECT value FROM kv WHERE key = 'dbVersion'").Scan(&version)
+	return version, err
+}
+
+// migrateDB runs the migrations up or down from the from version to the to
+// version, one at a time
+func migrateDB(db *sql.DB, dialect dbDialect, migrations []dbMigration, from, to int) error {
+	if from < to {
+		for _, m := range migrations {
+			if m.version <= from || m.version > to {
+				continue
+			}
+			glog.Infof("Migrating DB to version %d: %s", m.version, m.description)
+			if err := applyMigration(db, dialect, m.up, m.version); err != nil {
+				return fmt.Errorf("error migrating DB to version %d: %v", m.version, err)
+			}
+		}
+		return nil
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version > from || m.version <= to {
+			continue
+		}
+		glog.Infof("Reverting DB migration %d: %s", m.version, m.description)
+		if err := applyMigration(db, dialect, m.down, m.version-1); err != nil {
+			return fmt.Errorf("error reverting DB migration %d: %v", m.version, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, dialect dbDialect, stmts string, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(renderSQL("migration", stmts, dialect.types())); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec(dialect.rebind("UPDATE kv SET value = ?, updatedAt = ? WHERE key = 'dbVersion'"), strconv.Itoa(version), dbNow()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateDB migrates the schema of the DB at dsn, as taken by InitDB, to
+// version, up or down, and returns the version it was at. Nodes migrate their
+// DB up as they start; migrate down before running an older node on the DB.
+func MigrateDB(dsn string, version int) (int, error) {
+	if version < dbBaseVersion || version > LivepeerDBVersion {
+		return 0, fmt.Errorf("invalid DB version %d; must be between %d and %d", version, dbBaseVersion, LivepeerDBVersion)
+	}
+	dialect := dialectOf(dsn)
+	db, err := sql.Open(dialect.driverName(), dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(dialect.maxOpenConns())
+	from, err := dbVersion(db)
+	if err != nil {
+		return 0, fmt.Errorf("unable to fetch DB version: %v", err)
+	}
+	if from > LivepeerDBVersion {
+		return from, ErrDBTooNew
+	}
+	return from, migrateDB(db, dialect, dbMigrations, from, version)
+}
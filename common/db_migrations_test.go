@@ -0,0 +1,149 @@
+package common
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/pm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	rows, err := db.Query("SELECT * FROM " + table + " WHERE 1 = 0")
+	if err != nil {
+		return false
+	}
+	rows.Close()
+	return true
+}
+
+func TestDBMigrations_Ordered(t *testing.T) {
+	assert := assert.New(t)
+	prev := dbBaseVersion
+	for _, m := range dbMigrations {
+		assert.Equal(prev+1, m.version, m.description)
+		assert.NotEmpty(m.up, m.description)
+		assert.NotEmpty(m.down, m.description)
+		prev = m.version
+	}
+	assert.Equal(LivepeerDBVersion, prev)
+}
+
+func TestMigrateDB(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(err)
+	defer dbraw.Close()
+
+	// New DBs are migrated up from the base schema
+	version, err := dbVersion(dbraw)
+	require.Nil(err)
+	assert.Equal(LivepeerDBVersion, version)
+	assert.True(tableExists(t, dbraw, "ticketRedemptions"))
+	dbh.Close()
+
+	from, err := MigrateDB(dbPath(t), dbBaseVersion)
+	require.Nil(err)
+	assert.Equal(LivepeerDBVersion, from)
+	version, err = dbVersion(dbraw)
+	require.Nil(err)
+	assert.Equal(dbBaseVersion, version)
+	assert.False(tableExists(t, dbraw, "ticketRedemptions"))
+
+	// Up again as the node starts
+	dbh, err = InitDB(dbPath(t))
+	require.Nil(err)
+	dbh.Close()
+	version, err = dbVersion(dbraw)
+	require.Nil(err)
+	assert.Equal(LivepeerDBVersion, version)
+	assert.True(tableExists(t, dbraw, "ticketRedemptions"))
+
+	_, err = MigrateDB(dbPath(t), LivepeerDBVersion+1)
+	assert.NotNil(err)
+	_, err = MigrateDB(dbPath(t), 0)
+	assert.NotNil(err)
+}
+
+func TestMigrateDB_FailedMigrationRollsBack(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	migrations := append(append([]dbMigration{}, dbMigrations...), dbMigration{
+		version: LivepeerDBVersion + 1,
+		up:      "CREATE TABLE widgets (id INTEGER); INSERT INTO nonexistent VALUES (1);",
+		down:    "DROP TABLE widgets;",
+	})
+	err = migrateDB(dbraw, dialectOf(dbPath(t)), migrations, LivepeerDBVersion, LivepeerDBVersion+1)
+	assert.NotNil(err)
+	version, err := dbVersion(dbraw)
+	require.Nil(err)
+	assert.Equal(LivepeerDBVersion, version)
+	assert.False(tableExists(t, dbraw, "widgets"))
+}
+
+func TestMigrateDB_Recordings(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(err)
+	defer dbraw.Close()
+	require.Nil(dbh.InsertRecording("stream1", "cid1", 1))
+	require.Nil(dbh.InsertRecording("stream1", "cid2", 2))
+	require.Nil(dbh.InsertRecording("stream2", "cid3", 3))
+	_, err = dbraw.Exec("UPDATE recordings SET createdAt = '2000-01-01 00:00:00' WHERE cid = 'cid1'")
+	require.Nil(err)
+	dbh.Close()
+
+	// Only the last archive of each stream is kept down from version 9
+	_, err = MigrateDB(dbPath(t), 8)
+	require.Nil(err)
+	assert.Equal(2, getRowCountOrFatal("SELECT count(*) FROM recordings", dbraw, t))
+	var cid string
+	require.Nil(dbraw.QueryRow("SELECT cid FROM recordings WHERE manifestID = 'stream1'").Scan(&cid))
+	assert.Equal("cid2", cid)
+
+	// The archives recorded with one per stream are kept up again
+	dbh, err = InitDB(dbPath(t))
+	require.Nil(err)
+	defer dbh.Close()
+	recordings, err := dbh.Recordings()
+	require.Nil(err)
+	assert.Len(recordings, 2)
+	require.Nil(dbh.InsertRecording("stream2", "cid4", 4))
+	assert.Equal(3, getRowCountOrFatal("SELECT count(*) FROM recordings", dbraw, t))
+}
+
+func TestMigrateDB_SealedStreamKeys(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(err)
+	defer dbraw.Close()
+	require.Nil(dbh.SetEncryptionKey(pm.RandBytes(32)))
+	s, err := dbh.AcquireStream("stream1", "a", time.Minute)
+	require.Nil(err)
+	s.StreamKey, s.LastSeqNo = "key", 41
+	require.Nil(dbh.ReleaseStream(s))
+	dbh.Close()
+
+	// The sealed keys are dropped down from version 10, and the rest of
+	// the state kept
+	_, err = MigrateDB(dbPath(t), 9)
+	require.Nil(err)
+	dbh, err = InitDB(dbPath(t))
+	require.Nil(err)
+	defer dbh.Close()
+	s, err = dbh.StreamState("stream1")
+	require.Nil(err)
+	require.NotNil(s)
+	assert.Equal("", s.StreamKey)
+	assert.Equal(int64(41), s.LastSeqNo)
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"database/sql"
+	"math/big"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/pkg/errors"
+)
+
+// Format of the days orchestrator performance is aggregated over, in UTC
+const dbDayFormat = "2006-01-02"
+
+// DBOrchPerformance is how an orchestrator did on the segments a broadcaster
+// sent it over a day
+type DBOrchPerformance struct {
+	Day time.Time
+	// Orchestrator the transcoder URI of the orchestrator
+	Orchestrator string
+	Segments     int64
+	Succeeded    int64
+	SuccessRate  float64
+	// MeanLatencyMs from sending a segment to its renditions being verified,
+	// over the segments that succeeded
+	MeanLatencyMs float64
+	// PricePerSegment mean expected value of the tickets sent, in wei
+	PricePerSegment float64
+}
+
+// RecordOrchestratorSegment adds a segment sent to orch to its performance of
+// the day. paid, the expected value of the ticket sent with the segment, may
+// be nil. The segment is buffered and written with the next flush of the DB's
+// writes.
+func (db *DB) RecordOrchestratorSegment(orch string, latency time.Duration, success bool, paid *big.Int) error {
+	if db == nil || orch == "" {
+		return nil
+	}
+
+	day := time.Now().UTC().Format(dbDayFormat)
+	var succeeded, latencyMs int64
+	if success {
+		succeeded, latencyMs = 1, int64(latency/time.Millisecond)
+	}
+	var paidWei float64
+	if paid != nil {
+		paidWei, _ = new(big.Float).SetInt(paid).Float64()
+	}
+	insert := db.dialect.rebind(db.dialect.insertIgnore("orchestratorPerformance", "day", "orchestrator"))
+	update := db.dialect.rebind(`UPDATE orchestratorPerformance
+		SET segments = segments + 1, succeeded = succeeded + ?, latencyMs = latencyMs + ?, paid = paid + ?
+		WHERE day = ? AND orchestrator = ?`)
+	err := db.writer.buffer("recording segment of orchestrator "+orch, func(tx *sql.Tx) (sql.Result, error) {
+		if _, err := tx.Exec(insert, day, orch); err != nil {
+			return nil, err
+		}
+		return tx.Exec(update, succeeded, latencyMs, paidWei, day, orch)
+	})
+	if err != nil {
+		glog.Errorf("db: Error recording segment of orchestrator %v: %v", orch, err)
+		return err
+	}
+	return nil
+}
+
+// OrchestratorPerformance returns the performance of the orchestrators on
+// the days from `from` up to `to`
+func (db *DB) OrchestratorPerformance(from, to time.Time) ([]*DBOrchPerformance, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.query(`SELECT day, orchestrator, segments, succeeded, latencyMs, paid FROM orchestratorPerformance
+		WHERE day >= ? AND day <= ? ORDER BY day, orchestrator`,
+		from.UTC().Format(dbDayFormat), to.UTC().Format(dbDayFormat))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting orchestrator performance")
+	}
+	defer rows.Close()
+
+	perfs := []*DBOrchPerformance{}
+	for rows.Next() {
+		var (
+			p         DBOrchPerformance
+			day       string
+			latencyMs int64
+			paid      float64
+		)
+		if err := rows.Scan(&day, &p.Orchestrator, &p.Segments, &p.Succeeded, &latencyMs, &paid); err != nil {
+			return nil, errors.Wrap(err, "failed scanning orchestrator performance")
+		}
+		p.Day, _ = time.Parse(dbDayFormat, day)
+		if p.Segments > 0 {
+			p.SuccessRate = float64(p.Succeeded) / float64(p.Segments)
+			p.PricePerSegment = paid / float64(p.Segments)
+		}
+		if p.Succeeded > 0 {
+			p.MeanLatencyMs = float64(latencyMs) / float64(p.Succeeded)
+		}
+		perfs = append(perfs, &p)
+	}
+	return perfs, nil
+}
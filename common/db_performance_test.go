@@ -0,0 +1,57 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBOrchestratorPerformance(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	orch1, orch2 := "https://127.0.0.1:8936", "https://127.0.0.1:8937"
+	require.Nil(dbh.RecordOrchestratorSegment(orch1, 100*time.Millisecond, true, big.NewInt(1000)))
+	require.Nil(dbh.RecordOrchestratorSegment(orch1, 300*time.Millisecond, true, big.NewInt(1000)))
+	// Latency of failed segments isn't counted
+	require.Nil(dbh.RecordOrchestratorSegment(orch1, 5*time.Second, false, big.NewInt(1000)))
+	require.Nil(dbh.RecordOrchestratorSegment(orch2, 200*time.Millisecond, true, nil))
+	_, err = dbh.exec("INSERT INTO orchestratorPerformance(day, orchestrator, segments, succeeded) VALUES('2020-01-01', ?, 10, 10)", orch1)
+	require.Nil(err)
+	// Not recorded without an orchestrator, or a DB
+	require.Nil(dbh.RecordOrchestratorSegment("", time.Second, true, nil))
+	var nilDB *DB
+	require.Nil(nilDB.RecordOrchestratorSegment(orch1, time.Second, true, nil))
+
+	now := time.Now()
+	perfs, err := dbh.OrchestratorPerformance(now.Add(-24*time.Hour), now)
+	require.Nil(err)
+	require.Len(perfs, 2)
+	assert.Equal(now.UTC().Format(dbDayFormat), perfs[0].Day.Format(dbDayFormat))
+	assert.Equal(orch1, perfs[0].Orchestrator)
+	assert.Equal(int64(3), perfs[0].Segments)
+	assert.Equal(int64(2), perfs[0].Succeeded)
+	assert.InDelta(2.0/3, perfs[0].SuccessRate, 1e-9)
+	assert.Equal(200.0, perfs[0].MeanLatencyMs)
+	assert.Equal(1000.0, perfs[0].PricePerSegment)
+	assert.Equal(orch2, perfs[1].Orchestrator)
+	assert.Equal(1.0, perfs[1].SuccessRate)
+	assert.Equal(0.0, perfs[1].PricePerSegment)
+
+	perfs, err = dbh.OrchestratorPerformance(time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC))
+	require.Nil(err)
+	require.Len(perfs, 1)
+	assert.Equal(int64(10), perfs[0].Segments)
+	assert.Equal(0.0, perfs[0].MeanLatencyMs)
+
+	pruned, err := dbh.Prune(DBRetention{OrchestratorPerformance: 90 * 24 * time.Hour})
+	require.Nil(err)
+	assert.Equal(int64(1), pruned.OrchestratorPerformance)
+}
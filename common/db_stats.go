@@ -0,0 +1,124 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// dbTables are the tables of the schema, as of LivepeerDBVersion
+var dbTables = []string{"kv", "orchestrators", "unbondingLocks", "winningTickets", "ticketRedemptions", "recordings", "payments", "orchestratorPerformance", "transcoderTokens", "auditLog", "streamState"}
+
+// dbStatsWindow is the number of the most recent writes the latencies are
+// reported over
+var dbStatsWindow = 256
+
+// DBStats is the state of the DB, to tell whether it's bloated or degraded
+type DBStats struct {
+	// SizeBytes of the DB on disk
+	SizeBytes int64 `json:"sizeBytes"`
+	// Rows in each table
+	Rows   map[string]int64 `json:"rows"`
+	Writes DBWriteStats     `json:"writes"`
+}
+
+// DBWriteStats are the writes the node has made to the DB
+type DBWriteStats struct {
+	Count   int64 `json:"count"`
+	Failed  int64 `json:"failed"`
+	Batches int64 `json:"batches"`
+	// Locked writes that failed on the DB being locked by another process
+	Locked int64 `json:"locked"`
+	// Latency from a write being made until it's committed, over the most
+	// recent writes
+	AvgLatencyMs float64 `json:"avgLatencyMs"`
+	MaxLatencyMs float64 `json:"maxLatencyMs"`
+	// Wait for other writes to be done before a write starts, over the most
+	// recent writes
+	AvgWaitMs float64 `json:"avgWaitMs"`
+	MaxWaitMs float64 `json:"maxWaitMs"`
+}
+
+// dbWriterStats tracks the writes of a dbWriter
+type dbWriterStats struct {
+	mu                     sync.Mutex
+	count, failed, batches int64
+	locked                 int64
+	// latencies and waits of the most recent writes, oldest overwritten first
+	latencies, waits []time.Duration
+	next             int
+}
+
+func (s *dbWriterStats) batch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+}
+
+func (s *dbWriterStats) write(latency, wait time.Duration, err error, locked bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	if err != nil {
+		s.failed++
+	}
+	if locked {
+		s.locked++
+	}
+	if len(s.latencies) < dbStatsWindow {
+		s.latencies = append(s.latencies, latency)
+		s.waits = append(s.waits, wait)
+		return
+	}
+	s.latencies[s.next], s.waits[s.next] = latency, wait
+	s.next = (s.next + 1) % len(s.latencies)
+}
+
+func (s *dbWriterStats) report() DBWriteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := DBWriteStats{Count: s.count, Failed: s.failed, Batches: s.batches, Locked: s.locked}
+	r.AvgLatencyMs, r.MaxLatencyMs = avgMaxMs(s.latencies)
+	r.AvgWaitMs, r.MaxWaitMs = avgMaxMs(s.waits)
+	return r
+}
+
+func avgMaxMs(ds []time.Duration) (avg, max float64) {
+	if len(ds) == 0 {
+		return 0, 0
+	}
+	var sum, m time.Duration
+	for _, d := range ds {
+		sum += d
+		if d > m {
+			m = d
+		}
+	}
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return ms(sum) / float64(len(ds)), ms(m)
+}
+
+// Stats returns the size of the DB, the rows in its tables, and how the
+// node's writes to it have fared
+func (db *DB) Stats() (*DBStats, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return nil, err
+	}
+
+	stats := &DBStats{Rows: make(map[string]int64), Writes: db.writer.stats.report()}
+	if err := db.dbh.QueryRow(db.dialect.sizeQuery()).Scan(&stats.SizeBytes); err != nil {
+		return nil, errors.Wrap(err, "failed querying DB size")
+	}
+	for _, table := range dbTables {
+		var n int64
+		if err := db.dbh.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+			return nil, errors.Wrapf(err, "failed counting rows of %v", table)
+		}
+		stats.Rows[table] = n
+	}
+	return stats, nil
+}
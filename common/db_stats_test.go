@@ -0,0 +1,66 @@
+package common
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStats(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	require.Nil(dbh.UpdateOrch(NewDBOrch("https://127.0.0.1:8936", "0x1")))
+	require.Nil(dbh.InsertPayment(PaymentTicketSent, "stream1", "0x1", big.NewInt(100)))
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	_, err = dbh.exec("INSERT INTO nonexistent VALUES(1)")
+	require.NotNil(err)
+
+	stats, err := dbh.Stats()
+	require.Nil(err)
+	assert.True(stats.SizeBytes > 0)
+	assert.Len(stats.Rows, len(dbTables))
+	assert.Equal(int64(1), stats.Rows["orchestrators"])
+	assert.Equal(int64(2), stats.Rows["payments"])
+	assert.Equal(int64(0), stats.Rows["winningTickets"])
+	// "kv" starts with the DB version and last block
+	assert.Equal(int64(2), stats.Rows["kv"])
+	assert.Equal(int64(4), stats.Writes.Count)
+	assert.Equal(int64(1), stats.Writes.Failed)
+	assert.Equal(int64(0), stats.Writes.Locked)
+	assert.True(stats.Writes.MaxLatencyMs >= stats.Writes.AvgLatencyMs)
+
+	var nilDB *DB
+	stats, err = nilDB.Stats()
+	assert.Nil(err)
+	assert.Nil(stats)
+}
+
+func TestDBWriterStats_Window(t *testing.T) {
+	defer func(w int) { dbStatsWindow = w }(dbStatsWindow)
+	dbStatsWindow = 2
+	assert := assert.New(t)
+
+	var s dbWriterStats
+	s.write(10*time.Millisecond, time.Millisecond, nil, false)
+	s.write(20*time.Millisecond, 3*time.Millisecond, nil, false)
+	r := s.report()
+	assert.Equal(15.0, r.AvgLatencyMs)
+	assert.Equal(20.0, r.MaxLatencyMs)
+	assert.Equal(2.0, r.AvgWaitMs)
+
+	// The oldest write drops out of the window
+	s.write(40*time.Millisecond, time.Millisecond, nil, true)
+	r = s.report()
+	assert.Equal(int64(3), r.Count)
+	assert.Equal(int64(1), r.Locked)
+	assert.Equal(30.0, r.AvgLatencyMs)
+	assert.Equal(40.0, r.MaxLatencyMs)
+}
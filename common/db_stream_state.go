@@ -0,0 +1,162 @@
+package common
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrStreamHeld is returned for a stream another broadcaster holds the lease
+// of
+var ErrStreamHeld = errors.New("stream held by another broadcaster")
+
+// DBStreamState is the state of a stream that broadcasters sharing the DB
+// hand over to each other as its ingest fails over between them. Only the
+// broadcaster holding the lease of the stream updates it.
+type DBStreamState struct {
+	ManifestID string
+	// Owner is the failover ID of the broadcaster holding the stream, until
+	// LeaseExpiresAt in unix seconds
+	Owner          string
+	LeaseExpiresAt int64
+	// StreamKey the auth webhook gave the stream, or it was given at random;
+	// sealed while stored in an encrypted DB
+	StreamKey string
+	// Profiles comma separated names of the renditions
+	Profiles string
+	// LastSeqNo of the source segments ingested; -1 before the first
+	LastSeqNo int64
+	// Orchestrators the stream has sessions with, the one used last first
+	Orchestrators []string
+	UpdatedAt     time.Time
+	// Lapsed if the stream was acquired once the lease of another broadcaster
+	// holding it lapsed, rather than after it was released; its last writes
+	// may be missing
+	Lapsed bool
+}
+
+// AcquireStream has owner hold the stream with manifestID for lease, if no
+// other broadcaster holds it, and returns its state. The state left by the
+// broadcaster that held the stream before is kept.
+func (db *DB) AcquireStream(manifestID, owner string, lease time.Duration) (*DBStreamState, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if _, err := db.exec(db.dialect.insertIgnore("streamState", "manifestID"), manifestID); err != nil {
+		return nil, errors.Wrap(err, "failed inserting stream state")
+	}
+	// Ours to flush, if we held the stream before
+	if err := db.writer.flush(); err != nil {
+		return nil, err
+	}
+	var prevOwner string
+	var prevExpiresAt int64
+	if err := db.queryRow("SELECT owner, leaseExpiresAt FROM streamState WHERE manifestID = ?", manifestID).Scan(&prevOwner, &prevExpiresAt); err != nil {
+		return nil, errors.Wrap(err, "failed selecting stream state")
+	}
+	now := time.Now()
+	res, err := db.exec("UPDATE streamState SET owner = ?, leaseExpiresAt = ?, updatedAt = ? WHERE manifestID = ? AND (owner = ? OR leaseExpiresAt <= ?)",
+		owner, now.Add(lease).Unix(), dbNow(), manifestID, owner, now.Unix())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed acquiring stream")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrStreamHeld
+	}
+	s, err := db.StreamState(manifestID)
+	if err != nil {
+		return nil, err
+	}
+	// Taken from another broadcaster without it releasing the stream, which
+	// it can only be once its lease expired
+	s.Lapsed = prevOwner != owner && prevExpiresAt > 0
+	return s, nil
+}
+
+// StreamSegmentIngested writes the last seqNo of a stream its owner holds,
+// with the next flush of the DB's writes
+func (db *DB) StreamSegmentIngested(s *DBStreamState) error {
+	if db == nil {
+		return nil
+	}
+	update := db.dialect.rebind("UPDATE streamState SET lastSeqNo = ?, updatedAt = ? WHERE manifestID = ? AND owner = ? AND lastSeqNo < ?")
+	seqNo, manifestID, owner, now := s.LastSeqNo, s.ManifestID, s.Owner, dbNow()
+	return db.writer.buffer("writing seqNo of stream manifestID="+manifestID, func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec(update, seqNo, now, manifestID, owner, seqNo)
+	})
+}
+
+// RenewStream writes the state of a stream its owner holds, and extends the
+// lease of the owner by lease from now
+func (db *DB) RenewStream(s *DBStreamState, lease time.Duration) error {
+	if db == nil {
+		return nil
+	}
+	return db.updateStream(s, time.Now().Add(lease).Unix())
+}
+
+// ReleaseStream writes the state of a stream its owner holds, and lets any
+// broadcaster take it over straight away
+func (db *DB) ReleaseStream(s *DBStreamState) error {
+	if db == nil {
+		return nil
+	}
+	return db.updateStream(s, 0)
+}
+
+func (db *DB) updateStream(s *DBStreamState, leaseExpiresAt int64) error {
+	orchs, err := json.Marshal(s.Orchestrators)
+	if err != nil {
+		return err
+	}
+	streamKey, sealedKey, err := db.sealStreamKey(s.ManifestID, s.StreamKey)
+	if err != nil {
+		return err
+	}
+	res, err := db.exec("UPDATE streamState SET leaseExpiresAt = ?, streamKey = ?, sealedStreamKey = ?, profiles = ?, lastSeqNo = ?, orchestrators = ?, updatedAt = ? WHERE manifestID = ? AND owner = ?",
+		leaseExpiresAt, streamKey, sealedKey, s.Profiles, s.LastSeqNo, string(orchs), dbNow(), s.ManifestID, s.Owner)
+	if err != nil {
+		return errors.Wrap(err, "failed updating stream state")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrStreamHeld
+	}
+	return nil
+}
+
+// StreamState returns the state of the stream with manifestID, or nil if
+// no broadcaster has held it
+func (db *DB) StreamState(manifestID string) (*DBStreamState, error) {
+	if db == nil {
+		return nil, nil
+	}
+	if err := db.writer.flush(); err != nil {
+		return nil, err
+	}
+	var (
+		s         = DBStreamState{ManifestID: manifestID}
+		sealedKey []byte
+		orchs     string
+		updatedAt string
+	)
+	err := db.queryRow("SELECT owner, leaseExpiresAt, streamKey, sealedStreamKey, profiles, lastSeqNo, orchestrators, updatedAt FROM streamState WHERE manifestID = ?", manifestID).
+		Scan(&s.Owner, &s.LeaseExpiresAt, &s.StreamKey, &sealedKey, &s.Profiles, &s.LastSeqNo, &orchs, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting stream state")
+	}
+	if err := json.Unmarshal([]byte(orchs), &s.Orchestrators); err != nil {
+		return nil, errors.Wrap(err, "failed decoding stream orchestrators")
+	}
+	if len(sealedKey) > 0 {
+		if s.StreamKey, err = db.openStreamKey(manifestID, sealedKey); err != nil {
+			return nil, err
+		}
+	}
+	s.UpdatedAt, _ = time.Parse(sqliteTimeFormat, updatedAt)
+	return &s, nil
+}
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBStreamState(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	s, err := dbh.StreamState("stream1")
+	require.Nil(err)
+	assert.Nil(s)
+
+	// A new stream starts without any state
+	s, err = dbh.AcquireStream("stream1", "a", time.Minute)
+	require.Nil(err)
+	require.NotNil(s)
+	assert.Equal("a", s.Owner)
+	assert.Equal(int64(-1), s.LastSeqNo)
+	assert.Empty(s.Orchestrators)
+	assert.False(s.Lapsed)
+	assert.InDelta(time.Now().Add(time.Minute).Unix(), s.LeaseExpiresAt, 2)
+
+	// Its owner may acquire it again, no one else while the lease lasts
+	s, err = dbh.AcquireStream("stream1", "a", time.Minute)
+	require.Nil(err)
+	assert.False(s.Lapsed)
+	_, err = dbh.AcquireStream("stream1", "b", time.Minute)
+	assert.Equal(ErrStreamHeld, err)
+
+	s.StreamKey, s.Profiles, s.LastSeqNo = "key", "P240p30fps16x9,P360p30fps16x9", 41
+	s.Orchestrators = []string{"https://127.0.0.1:8936", "https://127.0.0.1:8937"}
+	require.Nil(dbh.RenewStream(s, time.Minute))
+	other := *s
+	other.Owner = "b"
+	assert.Equal(ErrStreamHeld, dbh.RenewStream(&other, time.Minute))
+	assert.Equal(ErrStreamHeld, dbh.ReleaseStream(&other))
+
+	// Once released, another broadcaster takes over where it was left
+	require.Nil(dbh.ReleaseStream(s))
+	s, err = dbh.AcquireStream("stream1", "b", time.Minute)
+	require.Nil(err)
+	assert.Equal("b", s.Owner)
+	assert.Equal("key", s.StreamKey)
+	assert.Equal("P240p30fps16x9,P360p30fps16x9", s.Profiles)
+	assert.Equal(int64(41), s.LastSeqNo)
+	assert.Equal([]string{"https://127.0.0.1:8936", "https://127.0.0.1:8937"}, s.Orchestrators)
+	assert.WithinDuration(time.Now(), s.UpdatedAt, time.Minute)
+	assert.False(s.Lapsed)
+
+	// Segments are written by the owner only, and never backwards
+	s.LastSeqNo = 42
+	require.Nil(dbh.StreamSegmentIngested(s))
+	other = *s
+	other.Owner, other.LastSeqNo = "a", 50
+	require.Nil(dbh.StreamSegmentIngested(&other))
+	s.LastSeqNo = 40
+	require.Nil(dbh.StreamSegmentIngested(s))
+	s, err = dbh.StreamState("stream1")
+	require.Nil(err)
+	assert.Equal(int64(42), s.LastSeqNo)
+
+	// as it does once the lease lapses
+	_, err = dbh.exec("UPDATE streamState SET leaseExpiresAt = ?", time.Now().Add(-time.Second).Unix())
+	require.Nil(err)
+	s, err = dbh.AcquireStream("stream1", "a", time.Minute)
+	require.Nil(err)
+	assert.Equal("a", s.Owner)
+	assert.Equal(int64(42), s.LastSeqNo)
+	assert.True(s.Lapsed)
+
+	// Nothing of its own is missing once it takes it back after its lease lapsed
+	_, err = dbh.exec("UPDATE streamState SET leaseExpiresAt = ?", time.Now().Add(-time.Second).Unix())
+	require.Nil(err)
+	s, err = dbh.AcquireStream("stream1", "a", time.Minute)
+	require.Nil(err)
+	assert.False(s.Lapsed)
+
+	// Streams not ingested within the retention window are pruned
+	_, err = dbh.exec("INSERT INTO streamState(manifestID, updatedAt) VALUES('stream2', ?)", dbTimeAgo(10*24*time.Hour))
+	require.Nil(err)
+	pruned, err := dbh.Prune(DBRetention{StreamStates: 7 * 24 * time.Hour})
+	require.Nil(err)
+	assert.Equal(int64(1), pruned.StreamStates)
+	s, err = dbh.StreamState("stream2")
+	require.Nil(err)
+	assert.Nil(s)
+}
+
+func TestDBStreamState_NilDB(t *testing.T) {
+	var dbh *DB
+	s, err := dbh.AcquireStream("stream1", "a", time.Minute)
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+	assert.Nil(t, dbh.RenewStream(&DBStreamState{}, time.Minute))
+	assert.Nil(t, dbh.ReleaseStream(&DBStreamState{}))
+	assert.Nil(t, dbh.StreamSegmentIngested(&DBStreamState{}))
+}
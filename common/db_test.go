@@ -6,6 +6,7 @@ import (
 	"math"
 	"math/big"
 	"testing"
+	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/livepeer/go-livepeer/pm"
@@ -35,8 +36,7 @@ func TestDBLastSeenBlock(t *testing.T) {
 		return
 	}
 	// set last updated at timestamp to sometime in the past
-	update_stmt := "UPDATE kv SET updatedAt = datetime('now', '-2 months') WHERE key = 'lastBlock'"
-	_, err = dbraw.Exec(update_stmt) // really should sanity check this result
+	_, err = dbh.exec("UPDATE kv SET updatedAt = ? WHERE key = 'lastBlock'", dbTimeAgo(60*24*time.Hour)) // really should sanity check this result
 	if err != nil {
 		t.Error("Could not update db ", err)
 	}
@@ -145,7 +145,7 @@ func TestSelectUpdateOrchs_AddingUpdatingRow_NoError(t *testing.T) {
 	require.Nil(err)
 
 	// adding row
-	orchAddress := string(pm.RandBytes(32))
+	orchAddress := pm.RandAddress().Hex()
 	orch := NewDBOrch("127.0.0.1:8936", orchAddress)
 
 	err = dbh.UpdateOrch(orch)
@@ -175,7 +175,7 @@ func TestSelectUpdateOrchs_AddingMultipleRows_NoError(t *testing.T) {
 	require.Nil(err)
 
 	// adding one row
-	orchAddress := string(pm.RandBytes(32))
+	orchAddress := pm.RandAddress().Hex()
 	orch := NewDBOrch("127.0.0.1:8936", orchAddress)
 	err = dbh.UpdateOrch(orch)
 	require.Nil(err)
@@ -186,7 +186,7 @@ func TestSelectUpdateOrchs_AddingMultipleRows_NoError(t *testing.T) {
 	assert.Equal(orchs[0].ServiceURI, orch.ServiceURI)
 
 	// adding second row
-	orchAddress = string(pm.RandBytes(32))
+	orchAddress = pm.RandAddress().Hex()
 	orchAdd := NewDBOrch("127.0.0.1:8938", orchAddress)
 	err = dbh.UpdateOrch(orchAdd)
 	require.Nil(err)
@@ -294,7 +294,7 @@ func TestDBUnbondingLocks(t *testing.T) {
 		return
 	}
 	var usedBlock int64
-	row = dbraw.QueryRow("SELECT usedBlock FROM unbondingLocks WHERE id = 0 AND delegator = ?", delegator.Hex())
+	row = dbh.queryRow("SELECT usedBlock FROM unbondingLocks WHERE id = 0 AND delegator = ?", delegator.Hex())
 	err = row.Scan(&usedBlock)
 	if err != nil {
 		t.Error(err)
@@ -309,7 +309,7 @@ func TestDBUnbondingLocks(t *testing.T) {
 		t.Error(err)
 		return
 	}
-	row = dbraw.QueryRow("SELECT usedBlock FROM unbondingLocks WHERE id = 1 AND delegator = ?", delegator.Hex())
+	row = dbh.queryRow("SELECT usedBlock FROM unbondingLocks WHERE id = 1 AND delegator = ?", delegator.Hex())
 	err = row.Scan(&usedBlock)
 	if err != nil {
 		t.Error(err)
@@ -609,6 +609,172 @@ func TestLoadWinningTickets_GivenTwoSessionsWithTickets_ReturnsAllTickets(t *tes
 	assert.Equal(recipientRand1, recipientRands[1])
 }
 
+func TestDBRecordings(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	recordings, err := dbh.Recordings()
+	require.Nil(err)
+	require.Empty(recordings)
+
+	require.Nil(dbh.InsertRecording("stream1", "cid1", 1234))
+	require.Nil(dbh.InsertRecording("stream2", "cid2", 5678))
+
+	recordings, err = dbh.Recordings()
+	require.Nil(err)
+	require.Len(recordings, 2)
+	assert := assert.New(t)
+	assert.Equal("stream1", recordings[0].ManifestID)
+	assert.Equal("cid1", recordings[0].CID)
+	assert.Equal(int64(1234), recordings[0].Size)
+	assert.Equal("[]", recordings[0].Deals)
+	assert.Equal("stream2", recordings[1].ManifestID)
+
+	// update deals
+	deals := `[{"dealId":1,"status":"Active"}]`
+	require.Nil(dbh.UpdateRecordingDeals("cid2", deals))
+	recordings, err = dbh.Recordings()
+	require.Nil(err)
+	assert.Equal("[]", recordings[0].Deals)
+	assert.Equal(deals, recordings[1].Deals)
+
+	// archiving the stream again keeps the earlier archive
+	require.Nil(dbh.UpdateRecordingDeals("cid1", deals))
+	require.Nil(dbh.InsertRecording("stream1", "cid3", 42))
+	count := getRowCountOrFatal("SELECT count(*) FROM recordings", dbraw, t)
+	assert.Equal(3, count)
+	recordings, err = dbh.Recordings()
+	require.Nil(err)
+	var cids []string
+	for _, r := range recordings {
+		if r.ManifestID == "stream1" {
+			cids = append(cids, r.CID)
+		}
+	}
+	assert.ElementsMatch([]string{"cid1", "cid3"}, cids)
+
+	// and archiving the same data again keeps its deals
+	require.Nil(dbh.InsertRecording("stream1", "cid1", 1234))
+	assert.Equal(3, getRowCountOrFatal("SELECT count(*) FROM recordings", dbraw, t))
+	recordings, err = dbh.Recordings()
+	require.Nil(err)
+	for _, r := range recordings {
+		if r.CID == "cid1" {
+			assert.Equal(deals, r.Deals)
+		}
+	}
+}
+
+func TestDBNodeAddress(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	require.Nil(dbh.SetLastSeenBlock(big.NewInt(100)))
+
+	// Another node sharing the DB
+	other, err := InitDB(dbPath(t))
+	require.Nil(err)
+	defer other.Close()
+	addr, otherAddr := pm.RandAddress(), pm.RandAddress()
+	dbh.SetNodeAddress(addr)
+	other.SetNodeAddress(otherAddr)
+
+	// Nodes carry on from the block seen before they were kept apart
+	block, err := other.LastSeenBlock()
+	require.Nil(err)
+	assert.Equal(big.NewInt(100), block)
+	require.Nil(dbh.SetLastSeenBlock(big.NewInt(200)))
+	require.Nil(other.SetLastSeenBlock(big.NewInt(300)))
+	block, err = dbh.LastSeenBlock()
+	require.Nil(err)
+	assert.Equal(big.NewInt(200), block)
+	block, err = other.LastSeenBlock()
+	require.Nil(err)
+	assert.Equal(big.NewInt(300), block)
+
+	require.Nil(dbh.InsertUnbondingLock(big.NewInt(0), addr, big.NewInt(10), big.NewInt(5)))
+	require.Nil(other.InsertUnbondingLock(big.NewInt(0), otherAddr, big.NewInt(20), big.NewInt(5)))
+	locks, err := dbh.UnbondingLocks(nil)
+	require.Nil(err)
+	require.Len(locks, 1)
+	assert.Equal(addr, locks[0].Delegator)
+	locks, err = other.UnbondingLocks(big.NewInt(5))
+	require.Nil(err)
+	require.Len(locks, 1)
+	assert.Equal(otherAddr, locks[0].Delegator)
+	ids, err := dbh.UnbondingLockIDs()
+	require.Nil(err)
+	assert.Len(ids, 1)
+
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	ticket.Recipient = addr
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	_, otherTicket, otherSig, recipientRand := defaultWinningTicket(t)
+	otherTicket.Recipient = otherAddr
+	require.Nil(other.StoreWinningTicket(sessionID, otherTicket, otherSig, recipientRand))
+	tickets, _, _, err := dbh.LoadWinningTickets([]string{sessionID})
+	require.Nil(err)
+	require.Len(tickets, 1)
+	assert.Equal(ticket, tickets[0])
+	pending, err := other.PendingWinningTickets()
+	require.Nil(err)
+	require.Len(pending, 1)
+	assert.Equal(otherSig, pending[0].Sig)
+
+	// Nodes without an address see everything
+	all, err := InitDB(dbPath(t))
+	require.Nil(err)
+	defer all.Close()
+	locks, err = all.UnbondingLocks(nil)
+	require.Nil(err)
+	assert.Len(locks, 2)
+	pending, err = all.PendingWinningTickets()
+	require.Nil(err)
+	assert.Len(pending, 2)
+}
+
+func TestDBPayments(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	now := time.Now()
+	payments, err := dbh.Payments(now.Add(-time.Hour), now.Add(time.Hour))
+	require.Nil(err)
+	require.Empty(payments)
+
+	require.Nil(dbh.InsertPayment(PaymentTicketSent, "stream1", "0x1", big.NewInt(100)))
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	// older than the window
+	_, err = dbh.exec("INSERT INTO payments(createdAt, kind, amount) VALUES(?, 'ticketWon', '7')", dbTimeAgo(2*time.Hour))
+	require.Nil(err)
+
+	payments, err = dbh.Payments(now.Add(-time.Hour), now.Add(time.Hour))
+	require.Nil(err)
+	require.Len(payments, 2)
+	assert := assert.New(t)
+	assert.Equal(PaymentTicketSent, payments[0].Kind)
+	assert.Equal("stream1", payments[0].ManifestID)
+	assert.Equal("0x1", payments[0].Counterparty)
+	assert.Equal(big.NewInt(100), payments[0].Amount)
+	assert.WithinDuration(now, payments[0].CreatedAt, 5*time.Second)
+	assert.Equal(PaymentReward, payments[1].Kind)
+	assert.Equal("", payments[1].ManifestID)
+	assert.Equal(big.NewInt(5), payments[1].Amount)
+
+	payments, err = dbh.Payments(now.Add(-3*time.Hour), now.Add(time.Hour))
+	require.Nil(err)
+	assert.Len(payments, 3)
+}
+
 func defaultWinningTicket(t *testing.T) (sessionID string, ticket *pm.Ticket, sig []byte, recipientRand *big.Int) {
 	sessionID = "foo bar"
 	ticket = &pm.Ticket{
@@ -632,3 +798,101 @@ func getRowCountOrFatal(query string, dbraw *sql.DB, t *testing.T) int {
 
 	return count
 }
+
+func TestDBAllWinningTickets(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	tickets, err := dbh.AllWinningTickets()
+	require.Nil(err)
+	require.Empty(tickets)
+
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	_, ticket2, sig2, recipientRand2 := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket("other session", ticket2, sig2, recipientRand2))
+
+	tickets, err = dbh.AllWinningTickets()
+	require.Nil(err)
+	require.Len(tickets, 2)
+	assert := assert.New(t)
+	assert.Equal(sessionID, tickets[0].SessionID)
+	assert.Equal(ticket, tickets[0].Ticket)
+	assert.Equal(sig, tickets[0].Sig)
+	assert.Equal(recipientRand, tickets[0].RecipientRand)
+	assert.Equal("other session", tickets[1].SessionID)
+	assert.Equal(ticket2, tickets[1].Ticket)
+
+	ok, err := dbh.HasWinningTicket(sig2)
+	require.Nil(err)
+	assert.True(ok)
+	ok, err = dbh.HasWinningTicket(pm.RandBytes(42))
+	require.Nil(err)
+	assert.False(ok)
+}
+
+func TestDBAllOrchs(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	require.Nil(dbh.UpdateOrch(NewDBOrch("https://127.0.0.1:8936", "0x1")))
+	// not returned by SelectOrchs anymore
+	_, err = dbh.exec("INSERT INTO orchestrators(updatedAt, serviceURI, ethereumAddr) VALUES(?, 'https://127.0.0.1:8937', '0x2')", dbTimeAgo(2*24*time.Hour))
+	require.Nil(err)
+
+	orchs, err := dbh.SelectOrchs()
+	require.Nil(err)
+	assert.Len(t, orchs, 1)
+
+	orchs, err = dbh.AllOrchs()
+	require.Nil(err)
+	require.Len(orchs, 2)
+	assert.Equal(t, "https://127.0.0.1:8936", orchs[0].ServiceURI)
+	assert.Equal(t, "0x1", orchs[0].EthereumAddr)
+	assert.Equal(t, "https://127.0.0.1:8937", orchs[1].ServiceURI)
+	assert.Equal(t, "0x2", orchs[1].EthereumAddr)
+}
+
+func TestDBRedemptions(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	sessionID, ticket, sig, recipientRand := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket, sig, recipientRand))
+	_, ticket2, sig2, recipientRand2 := defaultWinningTicket(t)
+	require.Nil(dbh.StoreWinningTicket(sessionID, ticket2, sig2, recipientRand2))
+
+	pending, err := dbh.PendingWinningTickets()
+	require.Nil(err)
+	assert := assert.New(t)
+	assert.Len(pending, 2)
+
+	txHash := pm.RandHash()
+	require.Nil(dbh.MarkWinningTicketRedeemed(sig, txHash))
+	pending, err = dbh.PendingWinningTickets()
+	require.Nil(err)
+	require.Len(pending, 1)
+	assert.Equal(sig2, pending[0].Sig)
+	assert.WithinDuration(time.Now(), pending[0].CreatedAt, 5*time.Second)
+
+	now := time.Now()
+	redemptions, err := dbh.Redemptions(now.Add(-time.Hour), now.Add(time.Hour))
+	require.Nil(err)
+	require.Len(redemptions, 1)
+	assert.Equal(ticket.Sender.Hex(), redemptions[0].Sender)
+	assert.Equal(ticket.FaceValue, redemptions[0].FaceValue)
+	assert.Equal(txHash.Hex(), redemptions[0].TxHash)
+
+	redemptions, err = dbh.Redemptions(now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+	require.Nil(err)
+	assert.Empty(redemptions)
+}
@@ -0,0 +1,120 @@
+package common
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// DBTranscoderRule is a rule routing streams to the pool of remote
+// transcoders tagged with all of its tags
+type DBTranscoderRule struct {
+	Name       string
+	ManifestID string
+	MinHeight  int
+	Tags       []string
+}
+
+// SetTranscoderRules replaces the rules stored with rules, kept in the order
+// they're matched
+func (db *DB) SetTranscoderRules(rules []*DBTranscoderRule) error {
+	if db == nil {
+		return nil
+	}
+	del := db.dialect.rebind("DELETE FROM transcoderRules")
+	insert := db.dialect.rebind("INSERT INTO transcoderRules(name, position, manifestID, minHeight, tags) VALUES(?, ?, ?, ?, ?)")
+	_, err := db.writer.exec(func(tx *sql.Tx) (res sql.Result, err error) {
+		if res, err = tx.Exec(del); err != nil {
+			return nil, err
+		}
+		for i, r := range rules {
+			tags, err := json.Marshal(r.Tags)
+			if err != nil {
+				return nil, err
+			}
+			if res, err = tx.Exec(insert, r.Name, i, r.ManifestID, r.MinHeight, string(tags)); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed storing transcoder rules")
+	}
+	return nil
+}
+
+// TranscoderRules returns the rules stored, in the order they're matched
+func (db *DB) TranscoderRules() ([]*DBTranscoderRule, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.query("SELECT name, manifestID, minHeight, tags FROM transcoderRules ORDER BY position")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting transcoder rules")
+	}
+	defer rows.Close()
+
+	rules := []*DBTranscoderRule{}
+	for rows.Next() {
+		var r DBTranscoderRule
+		var tags string
+		if err := rows.Scan(&r.Name, &r.ManifestID, &r.MinHeight, &tags); err != nil {
+			return nil, errors.Wrap(err, "failed scanning transcoder rule")
+		}
+		if err := json.Unmarshal([]byte(tags), &r.Tags); err != nil {
+			return nil, errors.Wrap(err, "failed decoding transcoder rule tags")
+		}
+		rules = append(rules, &r)
+	}
+	return rules, nil
+}
+
+// SetTranscoderTags stores the tags the remote transcoder with id is
+// assigned; none removes them
+func (db *DB) SetTranscoderTags(id string, tags []string) error {
+	if db == nil {
+		return nil
+	}
+	if len(tags) == 0 {
+		if _, err := db.exec("DELETE FROM transcoderTags WHERE id = ?", id); err != nil {
+			return errors.Wrap(err, "failed removing transcoder tags")
+		}
+		return nil
+	}
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return errors.Wrap(err, "failed encoding transcoder tags")
+	}
+	if _, err := db.upsert("transcoderTags", []string{"id"}, []string{"id", "tags"}, id, string(encoded)); err != nil {
+		return errors.Wrap(err, "failed storing transcoder tags")
+	}
+	return nil
+}
+
+// TranscoderTags returns the tags stored of the remote transcoders, by ID
+func (db *DB) TranscoderTags() (map[string][]string, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.query("SELECT id, tags FROM transcoderTags")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting transcoder tags")
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var id, encoded string
+		if err := rows.Scan(&id, &encoded); err != nil {
+			return nil, errors.Wrap(err, "failed scanning transcoder tags")
+		}
+		var t []string
+		if err := json.Unmarshal([]byte(encoded), &t); err != nil {
+			return nil, errors.Wrap(err, "failed decoding transcoder tags")
+		}
+		tags[id] = t
+	}
+	return tags, nil
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBTranscoderRules(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	rules, err := dbh.TranscoderRules()
+	require.Nil(err)
+	assert.Empty(rules)
+
+	// Kept in order
+	require.Nil(dbh.SetTranscoderRules([]*DBTranscoderRule{
+		{Name: "4k", MinHeight: 2160, Tags: []string{"a100"}},
+		{Name: "eu", ManifestID: "eu-", Tags: []string{"eu", "nvenc"}},
+	}))
+	rules, err = dbh.TranscoderRules()
+	require.Nil(err)
+	assert.Equal([]*DBTranscoderRule{
+		{Name: "4k", MinHeight: 2160, Tags: []string{"a100"}},
+		{Name: "eu", ManifestID: "eu-", Tags: []string{"eu", "nvenc"}},
+	}, rules)
+
+	// Replaced as a whole
+	require.Nil(dbh.SetTranscoderRules([]*DBTranscoderRule{{Name: "eu", ManifestID: "eu-", Tags: []string{"eu"}}}))
+	rules, err = dbh.TranscoderRules()
+	require.Nil(err)
+	assert.Equal([]*DBTranscoderRule{{Name: "eu", ManifestID: "eu-", Tags: []string{"eu"}}}, rules)
+
+	require.Nil(dbh.SetTranscoderRules(nil))
+	rules, err = dbh.TranscoderRules()
+	require.Nil(err)
+	assert.Empty(rules)
+}
+
+func TestDBTranscoderTags(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	tags, err := dbh.TranscoderTags()
+	require.Nil(err)
+	assert.Empty(tags)
+
+	require.Nil(dbh.SetTranscoderTags("t1", []string{"a100", "eu"}))
+	require.Nil(dbh.SetTranscoderTags("t2", []string{"us"}))
+	require.Nil(dbh.SetTranscoderTags("t2", []string{"eu"}))
+	tags, err = dbh.TranscoderTags()
+	require.Nil(err)
+	assert.Equal(map[string][]string{"t1": {"a100", "eu"}, "t2": {"eu"}}, tags)
+
+	// No tags removes them
+	require.Nil(dbh.SetTranscoderTags("t1", nil))
+	tags, err = dbh.TranscoderTags()
+	require.Nil(err)
+	assert.Equal(map[string][]string{"t2": {"eu"}}, tags)
+
+	// Nil DBs keep nothing
+	var nodb *DB
+	assert.Nil(nodb.SetTranscoderTags("t1", []string{"eu"}))
+	tags, err = nodb.TranscoderTags()
+	assert.Nil(err)
+	assert.Nil(tags)
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DBTranscoderToken is a token a remote transcoder authenticates to the
+// orchestrator with. Only the hash of the token is kept.
+type DBTranscoderToken struct {
+	ID    string
+	Label string
+	Hash  []byte
+	// CreatedAt and ExpiresAt are in unix seconds; ExpiresAt is 0 if the
+	// token doesn't expire
+	CreatedAt int64
+	ExpiresAt int64
+	Revoked   bool
+}
+
+// InsertTranscoderToken stores a newly issued token
+func (db *DB) InsertTranscoderToken(t *DBTranscoderToken) error {
+	if db == nil {
+		return nil
+	}
+	_, err := db.exec("INSERT INTO transcoderTokens(id, label, hash, createdAt, expiresAt) VALUES(?, ?, ?, ?, ?)",
+		t.ID, t.Label, t.Hash, t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return errors.Wrap(err, "failed inserting transcoder token")
+	}
+	return nil
+}
+
+// TranscoderTokens returns the tokens issued, revoked or not
+func (db *DB) TranscoderTokens() ([]*DBTranscoderToken, error) {
+	if db == nil {
+		return nil, nil
+	}
+	rows, err := db.query("SELECT id, label, hash, createdAt, expiresAt, revoked FROM transcoderTokens ORDER BY createdAt, id")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed selecting transcoder tokens")
+	}
+	defer rows.Close()
+
+	tokens := []*DBTranscoderToken{}
+	for rows.Next() {
+		var t DBTranscoderToken
+		var revoked int
+		if err := rows.Scan(&t.ID, &t.Label, &t.Hash, &t.CreatedAt, &t.ExpiresAt, &revoked); err != nil {
+			return nil, errors.Wrap(err, "failed scanning transcoder token")
+		}
+		t.Revoked = revoked != 0
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+// RevokeTranscoderToken stops the token with id being accepted
+func (db *DB) RevokeTranscoderToken(id string) error {
+	if db == nil {
+		return nil
+	}
+	if _, err := db.exec("UPDATE transcoderTokens SET revoked = 1 WHERE id = ?", id); err != nil {
+		return errors.Wrap(err, "failed revoking transcoder token")
+	}
+	return nil
+}
+
+// ExpireTranscoderToken has the token with id expire at expiresAt instead,
+// e.g. once it's been rotated
+func (db *DB) ExpireTranscoderToken(id string, expiresAt time.Time) error {
+	if db == nil {
+		return nil
+	}
+	if _, err := db.exec("UPDATE transcoderTokens SET expiresAt = ? WHERE id = ?", expiresAt.Unix(), id); err != nil {
+		return errors.Wrap(err, "failed expiring transcoder token")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBTranscoderTokens(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	tokens, err := dbh.TranscoderTokens()
+	require.Nil(err)
+	assert.Empty(tokens)
+
+	t1 := &DBTranscoderToken{ID: "a", Label: "gpu1", Hash: []byte{1, 2, 3}, CreatedAt: 100}
+	t2 := &DBTranscoderToken{ID: "b", Hash: []byte{4, 5, 6}, CreatedAt: 200, ExpiresAt: 300}
+	require.Nil(dbh.InsertTranscoderToken(t1))
+	require.Nil(dbh.InsertTranscoderToken(t2))
+	// IDs are unique
+	assert.NotNil(dbh.InsertTranscoderToken(t1))
+
+	require.Nil(dbh.RevokeTranscoderToken("a"))
+	require.Nil(dbh.ExpireTranscoderToken("b", time.Unix(250, 0)))
+
+	tokens, err = dbh.TranscoderTokens()
+	require.Nil(err)
+	require.Len(tokens, 2)
+	assert.Equal("a", tokens[0].ID)
+	assert.Equal("gpu1", tokens[0].Label)
+	assert.Equal([]byte{1, 2, 3}, tokens[0].Hash)
+	assert.True(tokens[0].Revoked)
+	assert.Equal(int64(0), tokens[0].ExpiresAt)
+	assert.Equal("b", tokens[1].ID)
+	assert.False(tokens[1].Revoked)
+	assert.Equal(int64(200), tokens[1].CreatedAt)
+	assert.Equal(int64(250), tokens[1].ExpiresAt)
+}
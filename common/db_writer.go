@@ -0,0 +1,250 @@
+package common
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/pkg/errors"
+)
+
+var errDBClosed = errors.New("DB closed")
+
+// dbWriteBatch is the most writes committed in a single transaction
+var dbWriteBatch = 64
+
+// DBFlushInterval is how often the buffered writes, per-segment records
+// that nothing waits on, are committed together in a transaction rather than
+// one by one. They're written straight away if 0.
+var DBFlushInterval = time.Second
+
+// dbMaxBuffered is the number of buffered writes that are committed without
+// waiting for the interval
+var dbMaxBuffered = 1024
+
+// dbWriter serializes the writes to the DB, so concurrent writers wait their
+// turn instead of failing on the DB being locked. The writes waiting when a
+// transaction commits are batched into the next one. Buffered writes are
+// held until the next flush, and committed together.
+type dbWriter struct {
+	db       *sql.DB
+	dialect  dbDialect
+	stats    dbWriterStats
+	interval time.Duration
+	writes   chan *dbWrite
+	flushes  chan chan struct{}
+	full     chan struct{}
+	quit     chan struct{}
+	done     chan struct{}
+	// held while a batch is written, and by exclusive
+	mu sync.Mutex
+
+	bufMu    sync.Mutex
+	buffered []*dbWrite
+	closed   bool
+}
+
+type dbWrite struct {
+	exec func(tx *sql.Tx) (sql.Result, error)
+	// result is nil for buffered writes, whose errors are logged
+	result chan dbWriteResult
+	queued time.Time
+	desc   string
+}
+
+type dbWriteResult struct {
+	res sql.Result
+	err error
+}
+
+func newDBWriter(db *sql.DB, dialect dbDialect) *dbWriter {
+	w := &dbWriter{
+		db:       db,
+		dialect:  dialect,
+		interval: DBFlushInterval,
+		writes:   make(chan *dbWrite),
+		flushes:  make(chan chan struct{}),
+		full:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// exec runs a write in the next transaction to be committed, and returns once
+// it is. A write that fails is rolled back without the rest of its batch.
+func (w *dbWriter) exec(exec func(tx *sql.Tx) (sql.Result, error)) (sql.Result, error) {
+	wr := &dbWrite{exec: exec, result: make(chan dbWriteResult, 1), queued: time.Now()}
+	select {
+	case w.writes <- wr:
+	case <-w.quit:
+		return nil, errDBClosed
+	}
+	r := <-wr.result
+	return r.res, r.err
+}
+
+// buffer runs a write, described by desc in the log if it fails, in the next
+// flush, returning without waiting for it. Buffered writes are only seen by
+// reads that flush them first.
+func (w *dbWriter) buffer(desc string, exec func(tx *sql.Tx) (sql.Result, error)) error {
+	if w.interval <= 0 {
+		_, err := w.exec(exec)
+		return err
+	}
+	w.bufMu.Lock()
+	defer w.bufMu.Unlock()
+	if w.closed {
+		return errDBClosed
+	}
+	w.buffered = append(w.buffered, &dbWrite{exec: exec, desc: desc})
+	if len(w.buffered) >= dbMaxBuffered {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flush commits the buffered writes, returning once they are
+func (w *dbWriter) flush() error {
+	w.bufMu.Lock()
+	pending := len(w.buffered)
+	w.bufMu.Unlock()
+	if pending == 0 {
+		return nil
+	}
+	done := make(chan struct{})
+	select {
+	case w.flushes <- done:
+	case <-w.quit:
+		return errDBClosed
+	}
+	<-done
+	return nil
+}
+
+// exclusive runs fn with no writes in progress, for statements such as
+// VACUUM that can't run alongside them
+func (w *dbWriter) exclusive(fn func() error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return fn()
+}
+
+// close stops the writer once the buffered writes are committed
+func (w *dbWriter) close() {
+	w.bufMu.Lock()
+	w.closed = true
+	w.bufMu.Unlock()
+	close(w.quit)
+	<-w.done
+}
+
+func (w *dbWriter) run() {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		var batch []*dbWrite
+		select {
+		case wr := <-w.writes:
+			batch = append(batch, wr)
+		case <-tick:
+			w.commitBuffered()
+			continue
+		case <-w.full:
+			w.commitBuffered()
+			continue
+		case done := <-w.flushes:
+			w.commitBuffered()
+			close(done)
+			continue
+		case <-w.quit:
+			w.commitBuffered()
+			return
+		}
+	more:
+		for len(batch) < dbWriteBatch {
+			select {
+			case wr := <-w.writes:
+				batch = append(batch, wr)
+			default:
+				break more
+			}
+		}
+		w.commit(batch)
+	}
+}
+
+// commitBuffered commits the buffered writes in one transaction
+func (w *dbWriter) commitBuffered() {
+	w.bufMu.Lock()
+	batch := w.buffered
+	w.buffered = nil
+	w.bufMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	// Their time in the buffer isn't time waiting on the DB
+	now := time.Now()
+	for _, wr := range batch {
+		wr.queued = now
+	}
+	w.commit(batch)
+}
+
+func (w *dbWriter) commit(batch []*dbWrite) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	start := time.Now()
+	results := make([]dbWriteResult, len(batch))
+	err := func() error {
+		tx, err := w.db.Begin()
+		if err != nil {
+			return err
+		}
+		for i, wr := range batch {
+			if _, err := tx.Exec("SAVEPOINT dbwrite"); err != nil {
+				tx.Rollback()
+				return err
+			}
+			results[i].res, results[i].err = wr.exec(tx)
+			if results[i].err != nil {
+				if _, err := tx.Exec("ROLLBACK TO SAVEPOINT dbwrite"); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			if _, err := tx.Exec("RELEASE SAVEPOINT dbwrite"); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+
+	done := time.Now()
+	w.stats.batch()
+	for i, wr := range batch {
+		if err != nil {
+			results[i] = dbWriteResult{err: err}
+		}
+		w.stats.write(done.Sub(wr.queued), start.Sub(wr.queued), results[i].err, w.dialect.isLocked(results[i].err))
+		if wr.result == nil {
+			if results[i].err != nil {
+				glog.Errorf("db: Error %s: %v", wr.desc, results[i].err)
+			}
+			continue
+		}
+		wr.result <- results[i]
+	}
+}
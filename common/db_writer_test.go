@@ -0,0 +1,179 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBWriter_ConcurrentWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	dbh, err := InitDB(filepath.Join(dir, "lp.sqlite3"))
+	require.Nil(t, err)
+	defer dbh.Close()
+
+	var mode string
+	require.Nil(t, dbh.dbh.QueryRow("PRAGMA journal_mode").Scan(&mode))
+	assert.Equal(t, "wal", mode)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			errs <- dbh.InsertPayment(PaymentTicketSent, fmt.Sprintf("stream%d", i), "", big.NewInt(int64(i)))
+		}(i)
+		go func() {
+			defer wg.Done()
+			_, err := dbh.LastSeenBlock()
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	var count int
+	require.Nil(t, dbh.writer.flush())
+	require.Nil(t, dbh.dbh.QueryRow("SELECT count(*) FROM payments").Scan(&count))
+	assert.Equal(t, 100, count)
+}
+
+func TestDBWriter_FailedWriteRollsBackAlone(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require := require.New(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	// Hold up the writer so the writes below are likely batched together
+	started, release := make(chan struct{}), make(chan struct{})
+	go dbh.writer.exec(func(tx *sql.Tx) (sql.Result, error) {
+		close(started)
+		<-release
+		return nil, nil
+	})
+	<-started
+
+	var wg sync.WaitGroup
+	var goodErr, badErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, goodErr = dbh.exec("INSERT INTO payments(kind, amount) VALUES('reward', '1')")
+	}()
+	go func() {
+		defer wg.Done()
+		_, badErr = dbh.exec("INSERT INTO nonexistent VALUES(1)")
+	}()
+	close(release)
+	wg.Wait()
+
+	assert.Nil(t, goodErr)
+	assert.NotNil(t, badErr)
+	assert.Equal(t, 1, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+}
+
+func TestDBWriter_Closed(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(t, err)
+	defer dbraw.Close()
+	dbh.Close()
+
+	_, err = dbh.exec("INSERT INTO payments(kind, amount) VALUES('reward', '1')")
+	assert.Equal(t, errDBClosed, err)
+}
+
+func TestDBWriter_BufferedWrites(t *testing.T) {
+	defer func(interval time.Duration, max int) { DBFlushInterval, dbMaxBuffered = interval, max }(DBFlushInterval, dbMaxBuffered)
+	DBFlushInterval = time.Hour
+	dir, err := ioutil.TempDir("", t.Name())
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "lp.sqlite3")
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dbh, err := InitDB(dbPath)
+	require.Nil(err)
+	dbraw, err := sql.Open("sqlite3", dbPath)
+	require.Nil(err)
+	defer dbraw.Close()
+
+	// Buffered until flushed by a read
+	require.Nil(dbh.InsertPayment(PaymentTicketSent, "stream1", "0x1", big.NewInt(100)))
+	require.Nil(dbh.RecordOrchestratorSegment("https://127.0.0.1:8936", time.Second, true, nil))
+	assert.Equal(0, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+	payments, err := dbh.Payments(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	require.Nil(err)
+	assert.Len(payments, 1)
+	assert.Equal(1, getRowCountOrFatal("SELECT count(*) FROM orchestratorPerformance", dbraw, t))
+
+	// Together in one transaction, where a failed write is rolled back alone
+	require.Nil(dbh.writer.buffer("inserting nothing", func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("INSERT INTO nonexistent VALUES(1)")
+	}))
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	stats, err := dbh.Stats()
+	require.Nil(err)
+	assert.Equal(int64(2), stats.Rows["payments"])
+	assert.Equal(int64(1), stats.Writes.Failed)
+
+	// Without waiting for the interval once there are enough of them
+	dbMaxBuffered = 2
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(4, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+
+	// And when the DB is closed
+	require.Nil(dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	dbh.Close()
+	assert.Equal(5, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+	assert.Equal(errDBClosed, dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+}
+
+func TestDBWriter_FlushInterval(t *testing.T) {
+	defer func(interval time.Duration) { DBFlushInterval = interval }(DBFlushInterval)
+	DBFlushInterval = 50 * time.Millisecond
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(t, err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	require.Nil(t, dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+}
+
+func TestDBWriter_Unbuffered(t *testing.T) {
+	defer func(interval time.Duration) { DBFlushInterval = interval }(DBFlushInterval)
+	DBFlushInterval = 0
+	dbh, dbraw, err := TempDB(t)
+	require.Nil(t, err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	// Written straight away, failing with the write
+	require.Nil(t, dbh.InsertPayment(PaymentReward, "", "", big.NewInt(5)))
+	assert.Equal(t, 1, getRowCountOrFatal("SELECT count(*) FROM payments", dbraw, t))
+	err = dbh.writer.buffer("inserting nothing", func(tx *sql.Tx) (sql.Result, error) {
+		return tx.Exec("INSERT INTO nonexistent VALUES(1)")
+	})
+	assert.NotNil(t, err)
+}
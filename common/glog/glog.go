@@ -0,0 +1,141 @@
+// Package glog logs through github.com/golang/glog, with the parts of its API
+// the node uses, unless a hook is set to handle each entry as it's logged
+// instead, e.g. to write it as JSON.
+package glog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	golog "github.com/golang/glog"
+)
+
+// Severities of log entries
+const (
+	InfoSeverity    = "info"
+	WarningSeverity = "warning"
+	ErrorSeverity   = "error"
+	FatalSeverity   = "fatal"
+)
+
+// Frames between the caller of the exported functions and output
+const callerDepth = 2
+
+// Entry is a log entry as it was logged
+type Entry struct {
+	Time     time.Time
+	Severity string
+	// File and Line of the call that logged the entry
+	File    string
+	Line    int
+	Message string
+}
+
+var hookLock sync.RWMutex
+var hook func(e *Entry)
+
+// SetHook has entries passed to h rather than written by glog. A nil h has
+// glog write them again.
+func SetHook(h func(e *Entry)) {
+	hookLock.Lock()
+	defer hookLock.Unlock()
+	hook = h
+}
+
+func getHook() func(e *Entry) {
+	hookLock.RLock()
+	defer hookLock.RUnlock()
+	return hook
+}
+
+func output(severity, msg string) {
+	h := getHook()
+	if h == nil {
+		switch severity {
+		case InfoSeverity:
+			golog.InfoDepth(callerDepth, msg)
+		case WarningSeverity:
+			golog.WarningDepth(callerDepth, msg)
+		case ErrorSeverity:
+			golog.ErrorDepth(callerDepth, msg)
+		case FatalSeverity:
+			golog.FatalDepth(callerDepth, msg)
+		}
+		return
+	}
+	e := &Entry{Time: time.Now(), Severity: severity, Message: msg}
+	if _, file, line, ok := runtime.Caller(callerDepth); ok {
+		e.File, e.Line = filepath.Base(file), line
+	}
+	h(e)
+	if severity == FatalSeverity {
+		// As glog does
+		os.Exit(255)
+	}
+}
+
+// Verbose logs at info severity if its verbosity level is enabled
+type Verbose bool
+
+// V returns whether verbosity level l is enabled with -v
+func V(l golog.Level) Verbose {
+	return Verbose(golog.V(l))
+}
+
+// Info logs args, formatted as fmt.Sprint does, if v is enabled
+func (v Verbose) Info(args ...interface{}) {
+	if v {
+		output(InfoSeverity, fmt.Sprint(args...))
+	}
+}
+
+// Infof logs args, formatted as fmt.Sprintf does, if v is enabled
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v {
+		output(InfoSeverity, fmt.Sprintf(format, args...))
+	}
+}
+
+// Info logs args at info severity, formatted as fmt.Sprint does
+func Info(args ...interface{}) {
+	output(InfoSeverity, fmt.Sprint(args...))
+}
+
+// Infof logs args at info severity, formatted as fmt.Sprintf does
+func Infof(format string, args ...interface{}) {
+	output(InfoSeverity, fmt.Sprintf(format, args...))
+}
+
+// Warning logs args at warning severity, formatted as fmt.Sprint does
+func Warning(args ...interface{}) {
+	output(WarningSeverity, fmt.Sprint(args...))
+}
+
+// Warningf logs args at warning severity, formatted as fmt.Sprintf does
+func Warningf(format string, args ...interface{}) {
+	output(WarningSeverity, fmt.Sprintf(format, args...))
+}
+
+// Error logs args at error severity, formatted as fmt.Sprint does
+func Error(args ...interface{}) {
+	output(ErrorSeverity, fmt.Sprint(args...))
+}
+
+// Errorf logs args at error severity, formatted as fmt.Sprintf does
+func Errorf(format string, args ...interface{}) {
+	output(ErrorSeverity, fmt.Sprintf(format, args...))
+}
+
+// Fatal logs args at fatal severity, formatted as fmt.Sprint does, and exits
+func Fatal(args ...interface{}) {
+	output(FatalSeverity, fmt.Sprint(args...))
+}
+
+// Fatalf logs args at fatal severity, formatted as fmt.Sprintf does, and exits
+func Fatalf(format string, args ...interface{}) {
+	output(FatalSeverity, fmt.Sprintf(format, args...))
+}
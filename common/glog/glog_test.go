@@ -0,0 +1,41 @@
+package glog
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetHook(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	var entries []*Entry
+	SetHook(func(e *Entry) { entries = append(entries, e) })
+	defer SetHook(nil)
+
+	start := time.Now()
+	_, _, line, _ := runtime.Caller(0)
+	Errorf("Error saving segment nonce=%d", 1)
+	Info("Segment ", 2)
+	V(100).Infof("Verbose %d", 3)
+	Warning("Warning\nwith two lines")
+	require.Len(entries, 3)
+
+	assert.Equal(ErrorSeverity, entries[0].Severity)
+	assert.Equal("Error saving segment nonce=1", entries[0].Message)
+	assert.Equal("glog_test.go", entries[0].File)
+	assert.Equal(line+1, entries[0].Line)
+	assert.False(entries[0].Time.Before(start))
+
+	assert.Equal(InfoSeverity, entries[1].Severity)
+	assert.Equal("Segment 2", entries[1].Message)
+	assert.Equal(line+2, entries[1].Line)
+
+	// Disabled verbosity levels aren't logged; entries are logged whole
+	assert.Equal(WarningSeverity, entries[2].Severity)
+	assert.Equal("Warning\nwith two lines", entries[2].Message)
+	assert.Equal(line+4, entries[2].Line)
+}
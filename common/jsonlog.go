@@ -0,0 +1,146 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// Log formats selectable with -logFormat
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// key=value pairs within messages, e.g. "nonce=123 seqNo=4"
+var logField = regexp.MustCompile(`(^|\s)([A-Za-z][A-Za-z0-9_]*)=([^\s,;]+)`)
+
+// Some messages use shorter names for the fields every line is indexed by
+var logFieldAliases = map[string]string{
+	"manifest": "manifestID",
+	"orch":     "orchestrator",
+}
+
+var logStreamsLock sync.RWMutex
+var logStreams = make(map[string]string)
+
+// SetLogStream attaches manifestID to JSON log lines of the stream with nonce,
+// as most lines only mention the nonce
+func SetLogStream(nonce uint64, manifestID string) {
+	logStreamsLock.Lock()
+	defer logStreamsLock.Unlock()
+	logStreams[strconv.FormatUint(nonce, 10)] = manifestID
+}
+
+// ClearLogStream forgets the stream with nonce once it has ended
+func ClearLogStream(nonce uint64) {
+	logStreamsLock.Lock()
+	defer logStreamsLock.Unlock()
+	delete(logStreams, strconv.FormatUint(nonce, 10))
+}
+
+// RedirectLogs writes everything glog writes to stderr to out instead, e.g. to
+// a LogFile. glog must be logging to stderr.
+func RedirectLogs(out io.Writer) error {
+	return redirectStderr(func(text string) {
+		out.Write([]byte(text + "\n"))
+	})
+}
+
+// RedirectLogsToJSON writes log entries as JSON objects, one per line, to out
+// rather than stderr. Dependencies that log through glog itself, such as
+// lpms, have each line they write to stderr in the msg of an object as is.
+// glog must be logging to stderr.
+func RedirectLogsToJSON(out io.Writer) error {
+	var mu sync.Mutex
+	write := func(line []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		out.Write(line)
+	}
+	if err := redirectStderr(func(text string) {
+		if line, err := json.Marshal(map[string]string{"ts": time.Now().Format(time.RFC3339Nano), "msg": text}); err == nil {
+			write(append(line, '\n'))
+		}
+	}); err != nil {
+		return err
+	}
+	glog.SetHook(func(e *glog.Entry) {
+		if line := formatJSONLog(e); line != nil {
+			write(line)
+		}
+	})
+	return nil
+}
+
+// redirectStderr passes each line written to stderr to handle
+func redirectStderr(handle func(text string)) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	os.Stderr = w
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			handle(scanner.Text())
+		}
+	}()
+	return nil
+}
+
+// formatJSONLog converts a log entry into JSON, adding the fields logged as
+// key=value in its message
+func formatJSONLog(e *glog.Entry) []byte {
+	msg := strings.TrimSpace(e.Message)
+	entry := map[string]interface{}{
+		"ts":     e.Time.Format(time.RFC3339Nano),
+		"level":  e.Severity,
+		"caller": fmt.Sprintf("%s:%d", e.File, e.Line),
+		"msg":    msg,
+	}
+
+	for _, m := range logField.FindAllStringSubmatch(msg, -1) {
+		key, val := m[2], strings.TrimRight(m[3], ":.)")
+		if alias, ok := logFieldAliases[key]; ok {
+			key = alias
+		}
+		switch key {
+		case "ts", "caller", "level", "msg":
+			continue
+		}
+		if _, ok := entry[key]; ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(val, 10, 64); err == nil && key != "nonce" {
+			entry[key] = n
+		} else {
+			entry[key] = val
+		}
+	}
+	if nonce, ok := entry["nonce"].(string); ok {
+		if _, ok := entry["manifestID"]; !ok {
+			logStreamsLock.RLock()
+			if mid, ok := logStreams[nonce]; ok {
+				entry["manifestID"] = mid
+			}
+			logStreamsLock.RUnlock()
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil
+	}
+	return append(line, '\n')
+}
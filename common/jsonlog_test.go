@@ -0,0 +1,50 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatJSONLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	now := time.Date(2019, 3, 2, 9, 59, 58, 123456000, time.UTC)
+
+	SetLogStream(1234, "stream1")
+	defer ClearLogStream(1234)
+
+	line := formatJSONLog(&glog.Entry{Time: now, Severity: glog.ErrorSeverity, File: "broadcast.go", Line: 236,
+		Message: "Error saving segment nonce=1234 seqNo=7: Session ended\n"})
+	require.NotNil(line)
+	var entry map[string]interface{}
+	require.Nil(json.Unmarshal(line, &entry))
+	assert.Equal("2019-03-02T09:59:58.123456Z", entry["ts"])
+	assert.Equal("error", entry["level"])
+	assert.Equal("broadcast.go:236", entry["caller"])
+	assert.Equal("Error saving segment nonce=1234 seqNo=7: Session ended", entry["msg"])
+	assert.Equal("1234", entry["nonce"])
+	assert.Equal(float64(7), entry["seqNo"])
+	assert.Equal("stream1", entry["manifestID"])
+
+	// aliases
+	line = formatJSONLog(&glog.Entry{Time: now, Severity: glog.InfoSeverity, File: "broadcast.go", Line: 300,
+		Message: "Submitting segment nonce=5 seqNo=1 orch=https://127.0.0.1:8935"})
+	entry = nil
+	require.Nil(json.Unmarshal(line, &entry))
+	assert.Equal("https://127.0.0.1:8935", entry["orchestrator"])
+	assert.Nil(entry["manifestID"])
+
+	// multi-line messages are kept in one object, and fields can't override
+	// those of the entry
+	line = formatJSONLog(&glog.Entry{Time: now, Severity: glog.WarningSeverity, File: "playlist.go", Line: 12,
+		Message: "LatestPlaylist:\nlevel=debug"})
+	entry = nil
+	require.Nil(json.Unmarshal(line, &entry))
+	assert.Equal("warning", entry["level"])
+	assert.Equal("LatestPlaylist:\nlevel=debug", entry["msg"])
+}
@@ -0,0 +1,161 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Suffix of rotated log files, appended to the name of the log file
+const logFileTimeFormat = "20060102T150405.000"
+
+var logFileNow = time.Now
+
+// LogFile is a log file that's rotated once it gets too large or too old.
+// Rotated files are kept in the same directory, named after the log file with
+// the time of rotation appended to it.
+type LogFile struct {
+	path           string
+	maxSize        int64
+	rotateInterval time.Duration
+	maxBackups     int
+	maxAge         time.Duration
+
+	lock   sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewLogFile appends to the log file at path; it's rotated once it's larger
+// than maxSize bytes or older than rotateInterval, if non-zero. Up to
+// maxBackups rotated files no older than maxAge are kept; zero for no limit.
+func NewLogFile(path string, maxSize int64, rotateInterval time.Duration, maxBackups int, maxAge time.Duration) (*LogFile, error) {
+	f := &LogFile{
+		path:           path,
+		maxSize:        maxSize,
+		rotateInterval: rotateInterval,
+		maxBackups:     maxBackups,
+		maxAge:         maxAge,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	f.removeOldLogs()
+	return f, nil
+}
+
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = logFileNow()
+	return nil
+}
+
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.shouldRotate(len(p)) {
+		// Keep writing to the current file if it can't be rotated
+		f.rotate()
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *LogFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.rotateInterval > 0 && logFileNow().Sub(f.opened) >= f.rotateInterval
+}
+
+func (f *LogFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	backup := f.path + "." + logFileNow().Format(logFileTimeFormat)
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	f.removeOldLogs()
+	return nil
+}
+
+// removeOldLogs removes the rotated files beyond maxBackups or maxAge
+func (f *LogFile) removeOldLogs() {
+	if f.maxBackups <= 0 && f.maxAge <= 0 {
+		return
+	}
+	dir, base := filepath.Split(f.path)
+	if dir == "" {
+		dir = "."
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type backup struct {
+		name string
+		t    time.Time
+	}
+	var backups []backup
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		t, err := time.ParseInLocation(logFileTimeFormat, name[len(base)+1:], time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{name: name, t: t})
+	}
+	// Newest first
+	sort.Slice(backups, func(i, j int) bool { return backups[i].t.After(backups[j].t) })
+	now := logFileNow()
+	for i, b := range backups {
+		if (f.maxBackups > 0 && i >= f.maxBackups) || (f.maxAge > 0 && now.Sub(b.t) > f.maxAge) {
+			os.Remove(filepath.Join(dir, b.name))
+		}
+	}
+}
+
+// Close closes the log file; nothing can be written to it afterwards
+func (f *LogFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "logfile")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2019, 3, 2, 10, 0, 0, 0, time.Local)
+	logFileNow = func() time.Time { return now }
+	defer func() { logFileNow = time.Now }()
+
+	logs := func() []string {
+		infos, err := ioutil.ReadDir(dir)
+		require.Nil(err)
+		var names []string
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	path := filepath.Join(dir, "livepeer.log")
+	f, err := NewLogFile(path, 10, time.Hour, 2, 0)
+	require.Nil(err)
+
+	// size based rotation
+	f.Write([]byte("12345678\n"))
+	assert.Equal([]string{"livepeer.log"}, logs())
+	now = now.Add(time.Second)
+	f.Write([]byte("abc\n"))
+	assert.Equal([]string{"livepeer.log", "livepeer.log.20190302T100001.000"}, logs())
+	data, _ := ioutil.ReadFile(path)
+	assert.Equal("abc\n", string(data))
+	data, _ = ioutil.ReadFile(path + ".20190302T100001.000")
+	assert.Equal("12345678\n", string(data))
+
+	// lines larger than maxSize are written to an empty file
+	now = now.Add(time.Second)
+	f.Write([]byte("0123456789abc\n"))
+	now = now.Add(time.Second)
+	f.Write([]byte("d\n"))
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal("d\n", string(data))
+
+	// only maxBackups are kept
+	assert.Equal([]string{"livepeer.log", "livepeer.log.20190302T100002.000", "livepeer.log.20190302T100003.000"}, logs())
+
+	// time based rotation
+	now = now.Add(time.Hour)
+	f.Write([]byte("e\n"))
+	assert.Equal([]string{"livepeer.log", "livepeer.log.20190302T100003.000", "livepeer.log.20190302T110003.000"}, logs())
+	require.Nil(f.Close())
+	_, err = f.Write([]byte("f\n"))
+	assert.NotNil(err)
+
+	// backups older than maxAge are removed when opening
+	f, err = NewLogFile(path, 0, 0, 0, 30*time.Minute)
+	require.Nil(err)
+	defer f.Close()
+	assert.Equal([]string{"livepeer.log", "livepeer.log.20190302T110003.000"}, logs())
+	data, _ = ioutil.ReadFile(path)
+	assert.Equal("e\n", string(data))
+}
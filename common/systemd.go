@@ -0,0 +1,65 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// States sent to systemd with SdNotify; see sd_notify(3)
+const (
+	SdNotifyReady    = "READY=1"
+	SdNotifyStopping = "STOPPING=1"
+	SdNotifyWatchdog = "WATCHDOG=1"
+)
+
+var ErrWatchdogUsec = errors.New("invalid WATCHDOG_USEC")
+
+// SdNotifyEnabled reports whether the node was started by systemd with
+// Type=notify
+func SdNotifyEnabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// SdNotify sends state to the systemd notification socket. Returns false
+// without an error if the node wasn't started by systemd with
+// Type=notify, i.e. NOTIFY_SOCKET isn't set.
+func SdNotify(state string) (bool, error) {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return false, nil
+	}
+	// Abstract socket
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SdWatchdogInterval is the interval systemd expects SdNotifyWatchdog to be
+// sent within, or 0 if the watchdog isn't enabled for this process
+func SdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// Meant for another process
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, ErrWatchdogUsec
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
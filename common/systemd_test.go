@@ -0,0 +1,84 @@
+package common
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSdNotify(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	// Not run by systemd
+	os.Unsetenv("NOTIFY_SOCKET")
+	sent, err := SdNotify(SdNotifyReady)
+	assert.False(sent)
+	assert.Nil(err)
+
+	dir, err := ioutil.TempDir("", "sdnotify")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	require.Nil(err)
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", name)
+	sent, err = SdNotify(SdNotifyReady)
+	assert.True(sent)
+	assert.Nil(err)
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	require.Nil(err)
+	assert.Equal(SdNotifyReady, string(buf[:n]))
+
+	// Nobody listening
+	os.Setenv("NOTIFY_SOCKET", filepath.Join(dir, "missing.sock"))
+	sent, err = SdNotify(SdNotifyReady)
+	assert.False(sent)
+	assert.NotNil(err)
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	assert := assert.New(t)
+
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	os.Unsetenv("WATCHDOG_USEC")
+	interval, err := SdWatchdogInterval()
+	assert.Equal(time.Duration(0), interval)
+	assert.Nil(err)
+
+	os.Setenv("WATCHDOG_USEC", "30000000")
+	interval, err = SdWatchdogInterval()
+	assert.Equal(30*time.Second, interval)
+	assert.Nil(err)
+
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	interval, err = SdWatchdogInterval()
+	assert.Equal(30*time.Second, interval)
+	assert.Nil(err)
+
+	// Another process is watched
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	interval, err = SdWatchdogInterval()
+	assert.Equal(time.Duration(0), interval)
+	assert.Nil(err)
+
+	os.Unsetenv("WATCHDOG_PID")
+	os.Setenv("WATCHDOG_USEC", "abc")
+	_, err = SdWatchdogInterval()
+	assert.Equal(ErrWatchdogUsec, err)
+}
@@ -4,33 +4,88 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io"
 	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/livepeer/go-livepeer/net"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 )
 
+// TestPostgresEnv is the environment variable with the URL of a Postgres DB
+// for the tests to run against instead of SQLite, e.g.
+// postgres://postgres@localhost/livepeer_test?sslmode=disable. Each test
+// gets a schema of its own in the DB.
+const TestPostgresEnv = "LP_TEST_POSTGRES"
+
+var testSchemaName = regexp.MustCompile(`[^a-z0-9_]`)
+
 func dbPath(t *testing.T) string {
-	return fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=1", t.Name())
+	dsn := os.Getenv(TestPostgresEnv)
+	if dsn == "" {
+		return fmt.Sprintf("file:%s?mode=memory&cache=shared&_foreign_keys=1", t.Name())
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("Invalid %s: %v", TestPostgresEnv, err)
+	}
+	q := u.Query()
+	q.Set("search_path", testSchema(t))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// testSchema is the Postgres schema of the DB of test t
+func testSchema(t *testing.T) string {
+	name := "test_" + testSchemaName.ReplaceAllString(strings.ToLower(t.Name()), "_")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	return name
 }
 
 func TempDB(t *testing.T) (*DB, *sql.DB, error) {
 	dbpath := dbPath(t)
+	dialect := dialectOf(dbpath)
+	if _, ok := dialect.(postgresDialect); ok {
+		// Start over from an empty schema
+		db, err := sql.Open(dialect.driverName(), os.Getenv(TestPostgresEnv))
+		if err != nil {
+			t.Error("Unable to open Postgres DB ", err)
+			return nil, nil, err
+		}
+		schema := testSchema(t)
+		_, err = db.Exec(fmt.Sprintf(`DROP SCHEMA IF EXISTS "%s" CASCADE; CREATE SCHEMA "%s"`, schema, schema))
+		db.Close()
+		if err != nil {
+			t.Error("Unable to create Postgres schema ", err)
+			return nil, nil, err
+		}
+	}
 	dbh, err := InitDB(dbpath)
 	if err != nil {
 		t.Error("Unable to initialize DB ", err)
 		return nil, nil, err
 	}
-	raw, err := sql.Open("sqlite3", dbpath)
+	raw, err := sql.Open(dialect.driverName(), dbpath)
 	if err != nil {
-		t.Error("Unable to open raw sqlite db ", err)
+		t.Error("Unable to open raw db ", err)
 		return nil, nil, err
 	}
 	return dbh, raw, nil
 }
 
+// dbTimeAgo is the time d ago as it's stored in the DB
+func dbTimeAgo(d time.Duration) string {
+	return time.Now().Add(-d).UTC().Format(sqliteTimeFormat)
+}
+
 func MaxUint256OrFatal(t *testing.T) *big.Int {
 	n, ok := new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 16)
 	if !ok {
@@ -76,3 +131,6 @@ func (s *StubServerStream) RecvMsg(m interface{}) error {
 func (s *StubServerStream) Send(n *net.NotifySegment) error {
 	return nil
 }
+func (s *StubServerStream) Recv() (*net.RegisterRequest, error) {
+	return nil, io.EOF
+}
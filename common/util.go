@@ -2,16 +2,18 @@ package common
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"net/url"
 	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 	"google.golang.org/grpc/peer"
 )
@@ -149,3 +151,121 @@ func GetConnectionAddr(ctx context.Context) string {
 	}
 	return from
 }
+
+// RequestIDHeader identifies a request, and the work it leads to, across nodes
+const RequestIDHeader = "X-Request-ID"
+
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// NewRequestID returns a random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID whether a request ID received from elsewhere can be used,
+// i.e. it's short and won't garble log lines
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID returns ctx carrying the request ID id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Redacted replaces the secrets hidden by RedactURL
+const Redacted = "REDACTED"
+
+// RedactURL hides the password of u, the segments of its path that look like
+// API keys, such as infura project IDs, and the values of its query
+// parameters that look like credentials, such as `token` or `api_key`, for u
+// to be logged or shown
+func RedactURL(u string) string {
+	p, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	redacted := false
+	if segs := strings.Split(p.Path, "/"); len(segs) > 1 {
+		for i, seg := range segs {
+			if isAPIKey(seg) {
+				segs[i] = Redacted
+				redacted = true
+			}
+		}
+		if redacted {
+			p.Path, p.RawPath = strings.Join(segs, "/"), ""
+		}
+	}
+	if p.User != nil {
+		if _, ok := p.User.Password(); ok {
+			p.User = url.UserPassword(p.User.Username(), Redacted)
+			redacted = true
+		}
+	}
+	if p.RawQuery != "" {
+		q := p.Query()
+		for k := range q {
+			if isCredentialParam(k) {
+				q.Set(k, Redacted)
+				redacted = true
+			}
+		}
+		if redacted {
+			p.RawQuery = q.Encode()
+		}
+	}
+	if !redacted {
+		return u
+	}
+	return p.String()
+}
+
+// isAPIKey is whether a segment of a URL path looks like an API key: at
+// least 20 letters and digits, with some of each
+func isAPIKey(seg string) bool {
+	if len(seg) < 20 {
+		return false
+	}
+	var letters, digits bool
+	for _, r := range seg {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+			letters = true
+		case r == '-' || r == '_':
+		default:
+			return false
+		}
+	}
+	return letters && digits
+}
+
+func isCredentialParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"token", "key", "secret", "password", "auth", "sig"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -59,9 +59,11 @@ type LivepeerNode struct {
 	// Transcoder public fields
 	SegmentChans      map[ManifestID]SegmentChan
 	Recipient         pm.Recipient
+	SenderChecker     pm.SenderChecker
 	OrchestratorPool  net.OrchestratorPool
 	Ipfs              ipfs.IpfsApi
 	OrchSecret        string
+	TranscoderTokens  *TranscoderTokens
 	Transcoder        Transcoder
 	TranscoderManager *RemoteTranscoderManager
 
@@ -73,6 +75,12 @@ type LivepeerNode struct {
 	pmSessions      map[ManifestID]map[string]bool
 	pmSessionsMutex *sync.Mutex
 	segmentMutex    *sync.RWMutex
+	// segmentSeen is when each of SegmentChans last got a segment, to
+	// estimate when the next session times out. Protected by segmentMutex.
+	segmentSeen map[ManifestID]time.Time
+	// spooled are the segments served to remote transcoders from the file
+	// they were spooled to
+	spooled spooledSegments
 }
 
 //NewLivepeerNode creates a new Livepeer Node. Eth can be nil.
@@ -87,6 +95,7 @@ func NewLivepeerNode(e eth.LivepeerEthClient, wd string, dbh *common.DB) (*Livep
 		pmSessions:      make(map[ManifestID]map[string]bool),
 		pmSessionsMutex: &sync.Mutex{},
 		segmentMutex:    &sync.RWMutex{},
+		segmentSeen:     make(map[ManifestID]time.Time),
 	}, nil
 
 }
@@ -5,11 +5,13 @@ import (
 	"io/ioutil"
 	"net/url"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/drivers"
 	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -129,3 +131,54 @@ func TestServiceURIChange(t *testing.T) {
 	require.Nil(err)
 	assert.Equal("test://secondurl.com/stream/testpath/testdata3", surl)
 }
+
+// spooledTranscoder records where it's given segments from, and the file
+// served from there while transcoding
+type spooledTranscoder struct {
+	StubTranscoder
+	orch   *orchestrator
+	url    string
+	served string
+}
+
+func (t *spooledTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	t.url = fname
+	t.served, _ = t.orch.SpooledSegment(path.Base(fname))
+	return t.StubTranscoder.Transcode(fname, profiles)
+}
+
+func TestTranscodeSeg_Spooled(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	p := []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+	storage := drivers.NewMemoryDriver(nil).NewSession("")
+	config := transcodeConfig{LocalOS: storage, OS: storage}
+
+	tmpdir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(tmpdir)
+	n, err := NewLivepeerNode(nil, tmpdir, nil)
+	require.Nil(err)
+	uri, _ := url.Parse("https://127.0.0.1:8935")
+	n.SetServiceURI(uri)
+	orch := NewOrchestrator(n)
+	tr := &spooledTranscoder{StubTranscoder: StubTranscoder{Profiles: p}, orch: orch}
+	n.Transcoder = tr
+
+	fname := path.Join(tmpdir, "spooled.ts")
+	require.Nil(ioutil.WriteFile(fname, []byte("segment"), 0644))
+	md := &SegTranscodingMetadata{Profiles: p, Fname: fname}
+	res := n.transcodeSeg(config, &stream.HLSSegment{SeqNo: 1}, md)
+	require.Nil(res.Err)
+
+	// The segment is served from its file while it's transcoded, rather
+	// than copied into the local OS
+	assert.Regexp("^https://127.0.0.1:8935"+SpooledSegmentPath+"[0-9a-f]{32}$", tr.url)
+	assert.Equal(fname, tr.served)
+	assert.Nil(storage.(*drivers.MemorySession).GetData("1.ts"))
+	_, ok := orch.SpooledSegment(path.Base(tr.url))
+	assert.False(ok)
+
+	// The file is left for whoever spooled it to remove
+	_, err = os.Stat(fname)
+	assert.Nil(err)
+}
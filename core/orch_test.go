@@ -13,6 +13,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"github.com/livepeer/go-livepeer/pm"
 
@@ -68,7 +69,7 @@ func TestServeTranscoder(t *testing.T) {
 	strm := &StubTranscoderServer{}
 
 	// test that a transcoder was created
-	go n.serveTranscoder(strm, 5)
+	go n.serveTranscoder(strm, "", RemoteTranscoderCapacity{Sessions: 5}, nil)
 	time.Sleep(1 * time.Second)
 
 	tc, ok := n.TranscoderManager.liveTranscoders[strm]
@@ -91,7 +92,7 @@ func TestRemoteTranscoder(t *testing.T) {
 	m := NewRemoteTranscoderManager()
 	initTranscoder := func() (*RemoteTranscoder, *StubTranscoderServer) {
 		strm := &StubTranscoderServer{manager: m}
-		tc := NewRemoteTranscoder(m, strm, 5)
+		tc := NewRemoteTranscoder(m, strm, RemoteTranscoderCapacity{Sessions: 5})
 		return tc, strm
 	}
 
@@ -154,45 +155,47 @@ func TestManageTranscoders(t *testing.T) {
 	strm := &StubTranscoderServer{}
 	strm2 := &StubTranscoderServer{manager: m}
 
-	// sanity check that liveTranscoders and remoteTranscoders is empty
+	// sanity check that liveTranscoders is empty
 	assert := assert.New(t)
 	assert.Nil(m.liveTranscoders[strm])
 	assert.Nil(m.liveTranscoders[strm2])
-	assert.Empty(m.remoteTranscoders)
 	assert.Equal(0, m.RegisteredTranscodersCount())
 
-	// test that transcoder is added to liveTranscoders and remoteTranscoders
+	// test that transcoder is added to liveTranscoders
 	wg1 := newWg(1)
-	go func() { m.Manage(strm, 5); wg1.Done() }()
+	go func() {
+		m.Manage(strm, RemoteTranscoderCapacity{Sessions: 5, GPUs: 2, Codecs: []string{"h264"}})
+		wg1.Done()
+	}()
 	time.Sleep(1 * time.Millisecond) // allow the manager to activate
 
 	assert.NotNil(m.liveTranscoders[strm])
 	assert.Len(m.liveTranscoders, 1)
-	assert.Len(m.remoteTranscoders, 5)
 	assert.Equal(1, m.RegisteredTranscodersCount())
 	ti := m.RegisteredTranscodersInfo()
 	assert.Len(ti, 1)
 	assert.Equal(5, ti[0].Capacity)
+	assert.Equal(2, ti[0].GPUs)
+	assert.Equal([]string{"h264"}, ti[0].Codecs)
+	assert.Equal(0, ti[0].Load)
 	assert.Equal("TestAddress", ti[0].Address)
 
-	// test that additional transcoder is added to liveTranscoders and remoteTranscoders
+	// test that additional transcoder is added to liveTranscoders
 	wg2 := newWg(1)
-	go func() { m.Manage(strm2, 4); wg2.Done() }()
+	go func() { m.Manage(strm2, RemoteTranscoderCapacity{Sessions: 4}); wg2.Done() }()
 	time.Sleep(1 * time.Millisecond) // allow the manager to activate
 
 	assert.NotNil(m.liveTranscoders[strm])
 	assert.NotNil(m.liveTranscoders[strm2])
 	assert.Len(m.liveTranscoders, 2)
-	assert.Len(m.remoteTranscoders, 9)
 	assert.Equal(2, m.RegisteredTranscodersCount())
 
-	// test that transcoders are removed from liveTranscoders and remoteTranscoders
+	// test that transcoders are removed from liveTranscoders
 	m.liveTranscoders[strm].eof <- struct{}{}
 	assert.True(wgWait(wg1)) // time limit
 	assert.Nil(m.liveTranscoders[strm])
 	assert.NotNil(m.liveTranscoders[strm2])
 	assert.Len(m.liveTranscoders, 1)
-	assert.Len(m.remoteTranscoders, 9)
 	assert.Equal(1, m.RegisteredTranscodersCount())
 
 	m.liveTranscoders[strm2].eof <- struct{}{}
@@ -200,7 +203,6 @@ func TestManageTranscoders(t *testing.T) {
 	assert.Nil(m.liveTranscoders[strm])
 	assert.Nil(m.liveTranscoders[strm2])
 	assert.Len(m.liveTranscoders, 0)
-	assert.Len(m.remoteTranscoders, 9)
 	assert.Equal(0, m.RegisteredTranscodersCount())
 }
 
@@ -209,29 +211,43 @@ func TestSelectTranscoder(t *testing.T) {
 	strm := &StubTranscoderServer{manager: m, WithholdResults: false}
 	strm2 := &StubTranscoderServer{manager: m}
 
-	// sanity check that transcoder is not in liveTranscoders or remoteTranscoders
+	// sanity check that transcoder is not in liveTranscoders
 	assert := assert.New(t)
 	assert.Nil(m.liveTranscoders[strm])
-	assert.Empty(m.remoteTranscoders)
 
-	// register transcoders, which adds transcoder to liveTranscoders and remoteTranscoders
+	// register transcoders, which adds transcoder to liveTranscoders
 	wg := newWg(1)
-	go func() { m.Manage(strm, 5) }()
+	go func() { m.Manage(strm, RemoteTranscoderCapacity{Sessions: 2}) }()
 	time.Sleep(1 * time.Millisecond) // allow time for first stream to register
-	go func() { m.Manage(strm2, 4); wg.Done() }()
+	go func() { m.Manage(strm2, RemoteTranscoderCapacity{Sessions: 3}); wg.Done() }()
 	time.Sleep(1 * time.Millisecond) // allow time for second stream to register
 
 	assert.NotNil(m.liveTranscoders[strm])
 	assert.NotNil(m.liveTranscoders[strm2])
-	assert.Len(m.remoteTranscoders, 9)
 
-	// assert transcoder is returned from selectTranscoder and removed from list
+	// assert the transcoder with the most headroom is selected first
 	t1 := m.liveTranscoders[strm]
 	t2 := m.liveTranscoders[strm2]
 	currentTranscoder := m.selectTranscoder()
-	assert.Equal(currentTranscoder, t2)
-	assert.NotNil(m.liveTranscoders[strm])
-	assert.Len(m.remoteTranscoders, 8)
+	assert.Equal(t2, currentTranscoder)
+	assert.Equal(1, t2.load)
+
+	// assert the load is spread by headroom as transcoders fill up
+	assert.NotNil(m.selectTranscoder())
+	assert.NotNil(m.selectTranscoder())
+	assert.NotNil(m.selectTranscoder())
+	assert.Equal(2, t1.load)
+	assert.Equal(3, t2.load)
+	assert.Nil(m.selectTranscoder())
+
+	// assert headroom is freed once segments are done
+	m.completeTranscoders(t1)
+	assert.Equal(t1, m.selectTranscoder())
+	m.completeTranscoders(t1)
+	m.completeTranscoders(t1)
+	m.completeTranscoders(t2)
+	m.completeTranscoders(t2)
+	m.completeTranscoders(t2)
 
 	// unregister transcoder
 	t2.eof <- struct{}{}
@@ -239,16 +255,189 @@ func TestSelectTranscoder(t *testing.T) {
 	assert.Nil(m.liveTranscoders[strm2])
 	assert.NotNil(m.liveTranscoders[strm])
 
-	// assert t1 is selected and t2 drained
+	// assert t1 is selected once t2 is gone
 	currentTranscoder = m.selectTranscoder()
-	assert.Equal(currentTranscoder, t1)
-	assert.NotNil(m.liveTranscoders[strm])
-	assert.Len(m.remoteTranscoders, 4)
+	assert.Equal(t1, currentTranscoder)
+	assert.Equal(1, t1.load)
+	m.completeTranscoders(t1)
 
-	// assert transcoder gets added back to remoteTranscoders if no transcoding error
+	// assert load is freed if no transcoding error
 	_, err := m.Transcode("", nil)
 	assert.Nil(err)
-	assert.Len(m.remoteTranscoders, 4)
+	assert.Equal(0, t1.load)
+}
+
+func TestSelectTranscoder_GPUsAndCodecs(t *testing.T) {
+	m := NewRemoteTranscoderManager()
+	assert := assert.New(t)
+	managed := func(capacity RemoteTranscoderCapacity) *RemoteTranscoder {
+		tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, capacity)
+		m.liveTranscoders[tc.stream] = tc
+		return tc
+	}
+	cpu := managed(RemoteTranscoderCapacity{Sessions: 4})
+	gpus := managed(RemoteTranscoderCapacity{Sessions: 4, GPUs: 2})
+	vp9 := managed(RemoteTranscoderCapacity{Sessions: 10, Codecs: []string{"vp9"}})
+
+	// with equal headroom, the transcoder spreading the load over more GPUs wins
+	cpu.load, gpus.load = 1, 1
+	assert.Equal(gpus, m.selectTranscoder())
+	assert.Equal(cpu, m.selectTranscoder())
+
+	// transcoders that can't encode the segments aren't selected
+	cpu.load, gpus.load = 4, 4
+	assert.Nil(m.selectTranscoder())
+	assert.Equal(0, vp9.load)
+}
+
+func TestSelectTranscoder_Latency(t *testing.T) {
+	m := NewRemoteTranscoderManager()
+	assert := assert.New(t)
+	managed := func(latency time.Duration) *RemoteTranscoder {
+		tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, RemoteTranscoderCapacity{Sessions: 2})
+		tc.latency = latency
+		m.liveTranscoders[tc.stream] = tc
+		return tc
+	}
+	fast := managed(100 * time.Millisecond)
+	near := managed(110 * time.Millisecond)
+	slow := managed(time.Second)
+
+	// live segments are balanced over the transcoders about as fast as the
+	// fastest, and go to slower ones once those are full
+	near.load = 1
+	assert.Equal(fast, m.selectTranscoder())
+	fast.load = 2
+	assert.Equal(near, m.selectTranscoder())
+	assert.Equal(slow, m.selectTranscoder())
+
+	// low priority segments go to the slowest transcoders
+	fast.load, near.load, slow.load = 1, 0, 0
+	low := &SegTranscodingMetadata{Priority: SegmentPriorityLow}
+	assert.Equal(slow, m.selectTranscoderFor(low))
+	assert.Equal(slow, m.selectTranscoderFor(low))
+	assert.Equal(near, m.selectTranscoderFor(low))
+
+	// transcoders yet to transcode a segment are tried with live segments
+	fresh := managed(0)
+	fresh.capacity.Sessions = 10
+	assert.Equal(fresh, m.selectTranscoder())
+
+	// the latency follows the round trip times of the segments
+	fast.recordLatency(600 * time.Millisecond)
+	assert.InDelta(float64(200*time.Millisecond), float64(fast.latency), float64(time.Microsecond))
+	fresh.recordLatency(50 * time.Millisecond)
+	assert.Equal(50*time.Millisecond, fresh.latency)
+
+	// recorded by ID, not by address
+	assert.Equal("unidentified", fresh.metricsLabel())
+	fresh.id = "transcoder-1"
+	assert.Equal("transcoder-1", fresh.metricsLabel())
+}
+
+func TestSelectTranscoder_Affinity(t *testing.T) {
+	m := NewRemoteTranscoderManager()
+	assert := assert.New(t)
+	managed := func(id string, sessions int) *RemoteTranscoder {
+		tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, RemoteTranscoderCapacity{Sessions: sessions})
+		tc.id = id
+		m.liveTranscoders[tc.stream] = tc
+		return tc
+	}
+	t1 := managed("t1", 3)
+	t2 := managed("t2", 2)
+	md := &SegTranscodingMetadata{ManifestID: "stream"}
+
+	// the segments of a stream stay with the transcoder they went to first,
+	// even as others have more headroom
+	assert.Equal(t1, m.selectTranscoderFor(md))
+	assert.Equal(t1, m.selectTranscoderFor(md))
+	assert.Equal(t1, m.affinity[md.ManifestID])
+
+	// until it's overloaded
+	assert.Equal(t1, m.selectTranscoderFor(md))
+	assert.Equal(t2, m.selectTranscoderFor(md))
+	m.completeTranscoders(t1)
+	assert.Equal(t2, m.selectTranscoderFor(md))
+
+	// or disconnects, unless it reconnects as the same transcoder
+	delete(m.liveTranscoders, t2.stream)
+	t2b := managed("t2", 2)
+	assert.Equal(t2b, m.selectTranscoderFor(md))
+	delete(m.liveTranscoders, t2b.stream)
+	assert.Equal(t1, m.selectTranscoderFor(md))
+
+	m.unpin(md.ManifestID)
+	assert.Empty(m.affinity)
+}
+
+func TestUpdateTranscoderCapacity(t *testing.T) {
+	assert := assert.New(t)
+	m := NewRemoteTranscoderManager()
+	tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, RemoteTranscoderCapacity{Sessions: 4, GPUs: 2})
+	tc.id = "t1"
+	m.liveTranscoders[tc.stream] = tc
+
+	// only the transcoder registered with the stream is updated
+	assert.Equal(ErrTranscoderNotFound, m.UpdateCapacity(&StubTranscoderServer{manager: m}, RemoteTranscoderCapacity{}))
+
+	// a GPU taken offline halves the sessions the transcoder is sent
+	assert.Nil(m.UpdateCapacity(tc.stream, RemoteTranscoderCapacity{Sessions: 2, GPUs: 1}))
+	assert.Equal(tc, m.selectTranscoder())
+	assert.Equal(tc, m.selectTranscoder())
+	assert.Nil(m.selectTranscoder())
+	info := m.RegisteredTranscodersInfo()
+	assert.Len(info, 1)
+	assert.Equal(2, info[0].Capacity)
+	assert.Equal(1, info[0].GPUs)
+
+	// segments it can no longer encode aren't sent to it
+	m.completeTranscoders(tc)
+	assert.Nil(m.UpdateCapacity(tc.stream, RemoteTranscoderCapacity{Sessions: 4, Codecs: []string{"vp9"}}))
+	assert.Nil(m.selectTranscoder())
+	assert.Equal(1, tc.load)
+}
+
+func TestRemoteTranscoderManagerCapacity(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := NewLivepeerNode(nil, "", nil)
+	m := NewRemoteTranscoderManager()
+	n.Transcoder = m
+	defer func(max int) { MaxSessions = max }(MaxSessions)
+	MaxSessions = 10
+
+	// Without transcoders, streams are taken up to MaxSessions
+	assert.Equal(RemoteTranscoderCapacity{}, m.Capacity())
+	assert.Equal(10, n.sessionLimit())
+
+	managed := func(capacity RemoteTranscoderCapacity) *RemoteTranscoder {
+		tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, capacity)
+		m.liveTranscoders[tc.stream] = tc
+		return tc
+	}
+	t1 := managed(RemoteTranscoderCapacity{Sessions: 4, GPUs: 2, Codecs: []string{"h264"}})
+	managed(RemoteTranscoderCapacity{Sessions: 2, GPUs: 1, Codecs: []string{"H264", "vp9"}})
+	assert.Equal(RemoteTranscoderCapacity{Sessions: 6, GPUs: 3, Codecs: []string{"h264", "vp9"}}, m.Capacity())
+	assert.Equal(6, n.sessionLimit())
+
+	// The node takes on fewer streams as soon as a transcoder loses a GPU
+	assert.Nil(m.UpdateCapacity(t1.stream, RemoteTranscoderCapacity{Sessions: 2, GPUs: 1, Codecs: []string{"h264"}}))
+	assert.Equal(4, m.Capacity().Sessions)
+	assert.Equal(4, n.sessionLimit())
+	n.SegmentChans[ManifestID("a")] = make(SegmentChan)
+	n.SegmentChans[ManifestID("b")] = make(SegmentChan)
+	n.SegmentChans[ManifestID("c")] = make(SegmentChan)
+	n.SegmentChans[ManifestID("d")] = make(SegmentChan)
+	orch := NewOrchestrator(n)
+	assert.IsType(&CapacityError{}, orch.CheckCapacity(ManifestID("e")))
+	assert.Nil(orch.CheckCapacity(ManifestID("a")))
+
+	// Draining transcoders take on no more, and transcoders taking any
+	// codec make for any codec
+	t1.draining = true
+	managed(RemoteTranscoderCapacity{Sessions: 20})
+	assert.Equal(RemoteTranscoderCapacity{Sessions: 22, GPUs: 1}, m.Capacity())
+	assert.Equal(10, n.sessionLimit())
 }
 
 func TestTranscoderManagerTranscoding(t *testing.T) {
@@ -258,27 +447,27 @@ func TestTranscoderManagerTranscoding(t *testing.T) {
 	// sanity checks
 	assert := assert.New(t)
 	assert.Empty(m.liveTranscoders)
-	assert.Empty(m.remoteTranscoders)
 
 	wg := newWg(1)
-	go func() { m.Manage(s, 5); wg.Done() }()
+	go func() { m.Manage(s, RemoteTranscoderCapacity{Sessions: 5}); wg.Done() }()
 	time.Sleep(1 * time.Millisecond)
 
-	assert.Len(m.remoteTranscoders, 5) // sanity
 	assert.Len(m.liveTranscoders, 1)
 	assert.NotNil(m.liveTranscoders[s])
+	tc := m.liveTranscoders[s]
 
 	// happy path
 	res, err := m.Transcode("", nil)
 	assert.Nil(err)
 	assert.Len(res, 1)
 	assert.Equal(string(res[0]), "asdf")
+	assert.Equal(0, tc.load)
 
 	// non-fatal error should not remove from list
 	s.TranscodeError = fmt.Errorf("TranscodeError")
 	_, err = m.Transcode("", nil)
 	assert.Equal(s.TranscodeError, err)
-	assert.Len(m.remoteTranscoders, 5) // sanity
+	assert.Equal(0, tc.load)
 	assert.Len(m.liveTranscoders, 1)
 	assert.NotNil(m.liveTranscoders[s])
 	s.TranscodeError = nil
@@ -290,15 +479,13 @@ func TestTranscoderManagerTranscoding(t *testing.T) {
 	assert.NotNil(err)
 	assert.Equal(err.Error(), "No transcoders available")
 	assert.Len(m.liveTranscoders, 0)
-	assert.Len(m.remoteTranscoders, 0) // retries drain the list
 	s.SendError = nil
 
 	// fatal error should not retry
 	wg.Add(1)
-	go func() { m.Manage(s, 5); wg.Done() }()
+	go func() { m.Manage(s, RemoteTranscoderCapacity{Sessions: 5}); wg.Done() }()
 	time.Sleep(1 * time.Millisecond)
 
-	assert.Len(m.remoteTranscoders, 5) // sanity check
 	assert.Len(m.liveTranscoders, 1)
 	s.WithholdResults = true
 	RemoteTranscoderTimeout = 1 * time.Millisecond
@@ -307,11 +494,130 @@ func TestTranscoderManagerTranscoding(t *testing.T) {
 	wg.Wait()
 	assert.True(fatal)
 	assert.Len(m.liveTranscoders, 0)
-	assert.Len(m.remoteTranscoders, 4) // no retries, so don't drain
 	s.WithholdResults = false
 	RemoteTranscoderTimeout = 8 * time.Second
 }
 
+func TestTranscoderManagerMaxRetries(t *testing.T) {
+	assert := assert.New(t)
+	defer func(retries int) { RemoteTranscoderMaxRetries = retries }(RemoteTranscoderMaxRetries)
+	RemoteTranscoderMaxRetries = 1
+
+	m := NewRemoteTranscoderManager()
+	for i := 0; i < 3; i++ {
+		s := &StubTranscoderServer{manager: m, SendError: fmt.Errorf("SendError")}
+		go m.Manage(s, RemoteTranscoderCapacity{Sessions: 5})
+	}
+	time.Sleep(1 * time.Millisecond)
+	assert.Len(m.liveTranscoders, 3)
+
+	// A segment failing every transcoder is retried once, then its error
+	// returned rather than it going to the last transcoder
+	_, err := m.Transcode("", nil)
+	_, fatal := err.(RemoteTranscoderFatalError)
+	assert.True(fatal)
+	assert.Contains(err.Error(), "SendError")
+	assert.Len(m.liveTranscoders, 1)
+}
+
+func TestTranscoderManagerReconnect(t *testing.T) {
+	assert := assert.New(t)
+	defer func(grace time.Duration) { RemoteTranscoderReconnectGrace = grace }(RemoteTranscoderReconnectGrace)
+	RemoteTranscoderReconnectGrace = time.Second
+
+	m := NewRemoteTranscoderManager()
+	s := &StubTranscoderServer{manager: m, SendError: fmt.Errorf("SendError")}
+	wg := newWg(1)
+	go func() { m.ManageWithID(s, "t1", RemoteTranscoderCapacity{Sessions: 5}); wg.Done() }()
+	time.Sleep(1 * time.Millisecond)
+
+	// The segment is held for the transcoder while its stream is down, and
+	// sent to it once it's back
+	type result struct {
+		res [][]byte
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		res, err := m.Transcode("", nil)
+		done <- result{res, err}
+	}()
+	assert.True(wgWait(wg))
+	s2 := &StubTranscoderServer{manager: m}
+	go m.ManageWithID(s2, "t1", RemoteTranscoderCapacity{Sessions: 5})
+	select {
+	case r := <-done:
+		assert.Nil(r.err)
+		assert.Equal("asdf", string(r.res[0]))
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("segment wasn't sent to the reconnected transcoder")
+	}
+	assert.Empty(m.reconnecting)
+
+	// New segments are held while transcoders are reconnecting
+	m.liveTranscoders[s2].eof <- struct{}{}
+	time.Sleep(1 * time.Millisecond)
+	go func() {
+		res, err := m.Transcode("", nil)
+		done <- result{res, err}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	s3 := &StubTranscoderServer{manager: m}
+	go m.ManageWithID(s3, "t1", RemoteTranscoderCapacity{Sessions: 5})
+	r := <-done
+	assert.Nil(r.err)
+
+	// and fail once the grace is over
+	RemoteTranscoderReconnectGrace = 10 * time.Millisecond
+	m.liveTranscoders[s3].eof <- struct{}{}
+	time.Sleep(1 * time.Millisecond)
+	start := time.Now()
+	_, err := m.Transcode("", nil)
+	assert.Equal("No transcoders available", err.Error())
+	assert.True(time.Since(start) < 500*time.Millisecond)
+	assert.Empty(m.reconnecting)
+}
+
+func TestDrainTranscoder(t *testing.T) {
+	assert := assert.New(t)
+	m := NewRemoteTranscoderManager()
+	s := &StubTranscoderServer{manager: m}
+	s2 := &StubTranscoderServer{manager: m}
+	wg, wg2 := newWg(1), newWg(1)
+	go func() { m.ManageWithID(s, "t1", RemoteTranscoderCapacity{Sessions: 5}); wg.Done() }()
+	go func() { m.ManageWithID(s2, "t2", RemoteTranscoderCapacity{Sessions: 1}); wg2.Done() }()
+	time.Sleep(1 * time.Millisecond)
+	t1 := m.liveTranscoders[s]
+
+	assert.Equal(ErrTranscoderNotFound, m.Drain("nonexistent"))
+	assert.Equal(ErrTranscoderNotFound, m.Drain(""))
+
+	// A draining transcoder gets no new segments, and is disconnected once
+	// it's done with those it has
+	assert.Equal(t1, m.selectTranscoder())
+	assert.Nil(m.Drain("t1"))
+	assert.True(s.drained)
+	info := m.RegisteredTranscodersInfo()
+	assert.Len(info, 2)
+	for _, i := range info {
+		assert.Equal(i.ID == "t1", i.Draining)
+	}
+	t2 := m.selectTranscoder()
+	assert.Equal(m.liveTranscoders[s2], t2)
+	assert.Nil(m.selectTranscoder())
+	m.completeTranscoders(t2)
+
+	m.completeTranscoders(t1)
+	assert.True(wgWait(wg))
+	assert.Nil(m.liveTranscoders[s])
+	assert.Empty(m.reconnecting)
+
+	// Idle transcoders are disconnected right away, by address too
+	assert.Nil(m.Drain("TestAddress"))
+	assert.True(wgWait(wg2))
+	assert.Empty(m.liveTranscoders)
+}
+
 func TestTaskChan(t *testing.T) {
 	n := NewRemoteTranscoderManager()
 	// Sanity check task ID
@@ -387,10 +693,25 @@ type StubTranscoderServer struct {
 	TranscodeError  error
 	WithholdResults bool
 
+	// the token last sent to the transcoder
+	tokenMu   sync.Mutex
+	lastToken string
+	drained   bool
+
 	common.StubServerStream
 }
 
 func (s *StubTranscoderServer) Send(n *net.NotifySegment) error {
+	if n.Drain {
+		s.drained = true
+		return s.SendError
+	}
+	if n.Token != "" {
+		s.tokenMu.Lock()
+		s.lastToken = n.Token
+		s.tokenMu.Unlock()
+		return s.SendError
+	}
 	res := RemoteTranscoderResult{Segments: [][]byte{[]byte("asdf")}, Err: s.TranscodeError}
 	if !s.WithholdResults {
 		s.manager.transcoderResults(n.TaskId, &res)
@@ -398,6 +719,12 @@ func (s *StubTranscoderServer) Send(n *net.NotifySegment) error {
 	return s.SendError
 }
 
+func (s *StubTranscoderServer) LastToken() string {
+	s.tokenMu.Lock()
+	defer s.tokenMu.Unlock()
+	return s.lastToken
+}
+
 func StubSegTranscodingMetadata() *SegTranscodingMetadata {
 	return &SegTranscodingMetadata{
 		ManifestID: ManifestID("abcdef"),
@@ -429,7 +756,7 @@ func TestGetSegmentChan(t *testing.T) {
 		t.Error("Existing mid should continue processing even when O is at capacity: ", err)
 	}
 	segData.ManifestID = ManifestID(t.Name())
-	if _, err := n.getSegmentChan(segData); err != ErrOrchCap {
+	if _, err := n.getSegmentChan(segData); !errors.Is(err, ErrOrchCap) {
 		t.Error("Didn't fail when orch cap hit: ", err)
 	}
 	MaxSessions = oldTranscodeSessions
@@ -469,20 +796,39 @@ func TestOrchCheckCapacity(t *testing.T) {
 	md := StubSegTranscodingMetadata()
 	cap := MaxSessions
 	assert := assert.New(t)
+	defer func(d time.Duration) { transcodeLoopTimeout = d }(transcodeLoopTimeout)
+	transcodeLoopTimeout = time.Minute
 
 	// happy case
 	assert.Nil(o.CheckCapacity(md.ManifestID))
 
 	// capped case
 	MaxSessions = 0
-	assert.Equal(ErrOrchCap, o.CheckCapacity(md.ManifestID))
+	err := o.CheckCapacity(md.ManifestID)
+	assert.True(errors.Is(err, ErrOrchCap))
+	assert.Equal(ErrOrchCap.Error(), err.Error())
+	// without sessions, retry once one could have timed out
+	assert.Equal(transcodeLoopTimeout, err.(*CapacityError).RetryAfter)
 
 	// ensure existing segment chans pass while cap is active
 	MaxSessions = cap
-	_, err := n.getSegmentChan(md) // store md into segment chans
+	_, err = n.getSegmentChan(md) // store md into segment chans
 	assert.Nil(err)
 	MaxSessions = 0
 	assert.Nil(o.CheckCapacity(md.ManifestID))
+
+	// other streams retry once the session idle for the longest times out
+	n.segmentMutex.Lock()
+	n.segmentSeen[md.ManifestID] = time.Now().Add(-transcodeLoopTimeout + 10*time.Second)
+	n.segmentMutex.Unlock()
+	err = o.CheckCapacity(ManifestID("other"))
+	require.IsType(t, &CapacityError{}, err)
+	assert.InDelta(10*time.Second, err.(*CapacityError).RetryAfter, float64(time.Second))
+	n.segmentMutex.Lock()
+	n.segmentSeen[md.ManifestID] = time.Now().Add(-2 * transcodeLoopTimeout)
+	n.segmentMutex.Unlock()
+	assert.Equal(time.Second, o.CheckCapacity(ManifestID("other")).(*CapacityError).RetryAfter)
+	MaxSessions = cap
 }
 
 func TestProcessPayment_GivenRecipientError_ReturnsError(t *testing.T) {
@@ -497,6 +843,23 @@ func TestProcessPayment_GivenRecipientError_ReturnsError(t *testing.T) {
 	assert.Contains(t, err.Error(), "mock error")
 }
 
+func TestProcessPayment_GivenUnderfundedSender_ReturnsError(t *testing.T) {
+	n, _ := NewLivepeerNode(nil, "", nil)
+	recipient := new(pm.MockRecipient)
+	checker := new(pm.MockSenderChecker)
+	n.Recipient = recipient
+	n.SenderChecker = checker
+	orch := NewOrchestrator(n)
+	checker.On("CheckSender", mock.Anything).Return(errors.New("zero deposit and reserve"))
+
+	err := orch.ProcessPayment(defaultPayment(t), ManifestID("some manifest"))
+
+	assert := assert.New(t)
+	assert.Contains(err.Error(), "zero deposit and reserve")
+	// The ticket isn't even looked at
+	recipient.AssertNotCalled(t, "ReceiveTicket", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func TestProcessPayment_GivenWinningTicketAndRecipientError_DoesNotCacheSessionID(t *testing.T) {
 	n, _ := NewLivepeerNode(nil, "", nil)
 	recipient := new(pm.MockRecipient)
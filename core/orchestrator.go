@@ -2,6 +2,7 @@ package core
 
 import (
 	"context"
+	"crypto/subtle"
 	ogErrors "errors"
 	"fmt"
 	"io/ioutil"
@@ -10,12 +11,13 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/pkg/errors"
 
 	"github.com/livepeer/go-livepeer/common"
@@ -67,8 +69,19 @@ func (orch *orchestrator) Address() ethcommon.Address {
 	return orch.address
 }
 
-func (orch *orchestrator) TranscoderSecret() string {
-	return orch.node.OrchSecret
+// AuthenticateTranscoder checks the secret a remote transcoder authenticates
+// with: the shared -orchSecret, or a token issued for it, which is returned
+func (orch *orchestrator) AuthenticateTranscoder(secret string) (*TranscoderToken, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	if orch.node.OrchSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(orch.node.OrchSecret)) == 1 {
+		return nil, true
+	}
+	if orch.node.TranscoderTokens == nil {
+		return nil, false
+	}
+	return orch.node.TranscoderTokens.Authenticate(secret)
 }
 
 func (orch *orchestrator) CheckCapacity(mid ManifestID) error {
@@ -77,8 +90,8 @@ func (orch *orchestrator) CheckCapacity(mid ManifestID) error {
 	if _, ok := orch.node.SegmentChans[mid]; ok {
 		return nil
 	}
-	if len(orch.node.SegmentChans) >= MaxSessions {
-		return ErrOrchCap
+	if len(orch.node.SegmentChans) >= orch.node.sessionLimit() {
+		return orch.node.capacityError()
 	}
 	return nil
 }
@@ -87,8 +100,15 @@ func (orch *orchestrator) TranscodeSeg(md *SegTranscodingMetadata, seg *stream.H
 	return orch.node.sendToTranscodeLoop(md, seg)
 }
 
-func (orch *orchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, capacity int) {
-	orch.node.serveTranscoder(stream, capacity)
+func (orch *orchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, id string, capacity RemoteTranscoderCapacity, token *TranscoderToken) error {
+	return orch.node.serveTranscoder(stream, id, capacity, token)
+}
+
+func (orch *orchestrator) UpdateTranscoderCapacity(stream net.Transcoder_RegisterTranscoderServer, capacity RemoteTranscoderCapacity) error {
+	if orch.node.TranscoderManager == nil {
+		return ErrTranscoderNotFound
+	}
+	return orch.node.TranscoderManager.UpdateCapacity(stream, capacity)
 }
 
 func (orch *orchestrator) TranscoderResults(tcId int64, res *RemoteTranscoderResult) {
@@ -110,19 +130,39 @@ func (orch *orchestrator) ProcessPayment(payment net.Payment, manifestID Manifes
 	}
 	seed := new(big.Int).SetBytes(payment.Seed)
 
+	if err := orch.CheckSender(ticket.Sender); err != nil {
+		return errors.Wrapf(err, "error checking sender for payment for manifest %v", manifestID)
+	}
+
 	sessionID, won, err := orch.node.Recipient.ReceiveTicket(ticket, payment.Sig, seed)
 	if err != nil {
 		return errors.Wrapf(err, "error receiving ticket for payment %v for manifest %v", payment, manifestID)
 	}
 
+	ev := ticket.EV()
+	orch.node.Database.InsertPayment(common.PaymentTicketReceived, string(manifestID), ticket.Sender.Hex(), new(big.Int).Quo(ev.Num(), ev.Denom()))
+
 	if won {
 		glog.V(common.DEBUG).Info("Received winning ticket")
 		cachePMSessionID(orch.node, manifestID, sessionID)
+		orch.node.Database.InsertPayment(common.PaymentTicketWon, string(manifestID), ticket.Sender.Hex(), ticket.FaceValue)
+		monitor.EmitEvent(monitor.EventTicketWon, map[string]interface{}{
+			"manifestID": string(manifestID), "sender": ticket.Sender.Hex(), "faceValue": ticket.FaceValue.String(),
+		})
 	}
 
 	return nil
 }
 
+// CheckSender returns an error if the sender doesn't have the funds to pay
+// for segments
+func (orch *orchestrator) CheckSender(sender ethcommon.Address) error {
+	if orch.node == nil || orch.node.SenderChecker == nil {
+		return nil
+	}
+	return orch.node.SenderChecker.CheckSender(sender)
+}
+
 func (orch *orchestrator) TicketParams(sender ethcommon.Address) *net.TicketParams {
 	if orch.node == nil || orch.node.Recipient == nil {
 		return nil
@@ -154,6 +194,50 @@ func NewOrchestrator(n *LivepeerNode) *orchestrator {
 var ErrOrchBusy = ogErrors.New("OrchestratorBusy")
 var ErrOrchCap = ogErrors.New("OrchestratorCapped")
 
+// CapacityError refuses new streams while the orchestrator is at capacity.
+// RetryAfter estimates when a session is freed for another stream.
+type CapacityError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CapacityError) Error() string {
+	return ErrOrchCap.Error()
+}
+
+// Is has CapacityErrors match ErrOrchCap
+func (e *CapacityError) Is(target error) bool {
+	return target == ErrOrchCap
+}
+
+// sessionLimit is how many streams the node transcodes at once: MaxSessions,
+// or fewer while its remote transcoders can only take on fewer together
+func (n *LivepeerNode) sessionLimit() int {
+	rtm, ok := n.Transcoder.(*RemoteTranscoderManager)
+	if !ok || rtm.RegisteredTranscodersCount() == 0 {
+		return MaxSessions
+	}
+	if sessions := rtm.Capacity().Sessions; sessions < MaxSessions {
+		return sessions
+	}
+	return MaxSessions
+}
+
+// capacityError estimates when the session idle for the longest times out,
+// at least a second from now. Called with segmentMutex held.
+func (n *LivepeerNode) capacityError() *CapacityError {
+	now := time.Now()
+	retryAfter := transcodeLoopTimeout
+	for _, seen := range n.segmentSeen {
+		if wait := seen.Add(transcodeLoopTimeout).Sub(now); wait < retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+	return &CapacityError{RetryAfter: retryAfter}
+}
+
 type TranscodeResult struct {
 	Err  error
 	Sig  []byte
@@ -218,8 +302,8 @@ func (n *LivepeerNode) getSegmentChan(md *SegTranscodingMetadata) (SegmentChan,
 	if sc, ok := n.SegmentChans[md.ManifestID]; ok {
 		return sc, nil
 	}
-	if len(n.SegmentChans) >= MaxSessions {
-		return nil, ErrOrchCap
+	if len(n.SegmentChans) >= n.sessionLimit() {
+		return nil, n.capacityError()
 	}
 	sc := make(SegmentChan, 1)
 	glog.V(common.DEBUG).Info("Creating new segment chan for manifest ", md.ManifestID)
@@ -227,6 +311,7 @@ func (n *LivepeerNode) getSegmentChan(md *SegTranscodingMetadata) (SegmentChan,
 		return nil, err
 	}
 	n.SegmentChans[md.ManifestID] = sc
+	n.segmentSeen[md.ManifestID] = time.Now()
 	if lpmon.Enabled {
 		lpmon.CurrentSessions(len(n.SegmentChans))
 	}
@@ -275,12 +360,17 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 			return terr(err)
 		}
 	}
-	// Create input file from segment. Removed after claiming complete or error
-	fname := path.Join(n.WorkDir, inName)
-	fnamep = &fname
-	if err := ioutil.WriteFile(fname, seg.Data, 0644); err != nil {
-		glog.Errorf("Transcoder cannot write file: %v", err)
-		return terr(err)
+	// Segments spooled to disk as they were received are read from there;
+	// their file is for whoever spooled them to remove
+	fname := md.Fname
+	if fname == "" {
+		// Create input file from segment. Removed after claiming complete or error
+		fname = path.Join(n.WorkDir, inName)
+		fnamep = &fname
+		if err := ioutil.WriteFile(fname, seg.Data, 0644); err != nil {
+			glog.Errorf("Transcoder cannot write file: %v", err)
+			return terr(err)
+		}
 	}
 
 	// Check if there's a transcoder available
@@ -298,6 +388,15 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 		// We're using a remote TC and segment is already in our own OS
 		// Incurs an additional download for topologies with T on local network!
 		url = seg.Name
+	} else if fnamep == nil {
+		// Segments spooled to disk are served from their file rather than
+		// copied into our local OS
+		id, err := n.spooled.add(fname)
+		if err != nil {
+			return terr(err)
+		}
+		defer n.spooled.remove(id)
+		url = n.spooledSegmentURI(id)
 	} else {
 		// Need to store segment in our local OS
 		var err error
@@ -311,7 +410,15 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 
 	//Do the transcoding
 	start := time.Now()
-	tData, err := transcoder.Transcode(url, md.Profiles)
+	var tData [][]byte
+	var err error
+	if rtm, ok := transcoder.(*RemoteTranscoderManager); ok {
+		tData, err = rtm.transcode(url, md)
+	} else if st, ok := transcoder.(StreamTranscoder); ok && md.ManifestID != "" {
+		tData, err = st.TranscodeStream(md.ManifestID, url, md.Profiles)
+	} else {
+		tData, err = transcoder.Transcode(url, md.Profiles)
+	}
 	if err != nil {
 		glog.Errorf("Error transcoding manifest=%s segNo=%d segName=%s - %v", string(md.ManifestID), seg.SeqNo, seg.Name, err)
 		return terr(err)
@@ -347,7 +454,9 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 		hash := crypto.Keccak256(tData[i])
 		segHashes[i] = hash
 	}
-	os.Remove(fname)
+	if fnamep != nil {
+		os.Remove(fname)
+	}
 	tr.OS = config.OS
 
 	if n == nil || n.Eth == nil {
@@ -397,11 +506,17 @@ func (n *LivepeerNode) transcodeSegmentLoop(md *SegTranscodingMetadata, segChan
 				if _, ok := n.SegmentChans[md.ManifestID]; ok {
 					close(n.SegmentChans[md.ManifestID])
 					delete(n.SegmentChans, md.ManifestID)
+					delete(n.segmentSeen, md.ManifestID)
 					if lpmon.Enabled {
 						lpmon.CurrentSessions(len(n.SegmentChans))
 					}
 				}
 				n.segmentMutex.Unlock()
+				if rtm, ok := n.Transcoder.(*RemoteTranscoderManager); ok {
+					rtm.unpin(md.ManifestID)
+				} else if st, ok := n.Transcoder.(StreamTranscoder); ok {
+					st.EndStream(md.ManifestID)
+				}
 				if n.Recipient != nil {
 					sessionIDs := getAndClearPMSessionIDsByManifestID(n, md.ManifestID)
 					if len(sessionIDs) > 0 {
@@ -413,6 +528,9 @@ func (n *LivepeerNode) transcodeSegmentLoop(md *SegTranscodingMetadata, segChan
 				}
 				return
 			case chanData := <-segChan:
+				n.segmentMutex.Lock()
+				n.segmentSeen[md.ManifestID] = time.Now()
+				n.segmentMutex.Unlock()
 				chanData.res <- n.transcodeSeg(config, chanData.seg, chanData.md)
 			}
 			cancel()
@@ -421,10 +539,58 @@ func (n *LivepeerNode) transcodeSegmentLoop(md *SegTranscodingMetadata, segChan
 	return nil
 }
 
-func (n *LivepeerNode) serveTranscoder(stream net.Transcoder_RegisterTranscoderServer, capacity int) {
+func (n *LivepeerNode) serveTranscoder(stream net.Transcoder_RegisterTranscoderServer, id string, capacity RemoteTranscoderCapacity, token *TranscoderToken) error {
 	from := common.GetConnectionAddr(stream.Context())
-	n.TranscoderManager.Manage(stream, capacity)
+	if err := n.TranscoderManager.checkEvicted(id, from); err != nil {
+		return err
+	}
+	if token != nil && n.TranscoderTokens != nil {
+		ctx, cancel := context.WithCancel(stream.Context())
+		defer cancel()
+		go n.watchTranscoderToken(ctx, stream, token)
+	}
+	n.TranscoderManager.ManageWithID(stream, id, capacity)
 	glog.V(common.DEBUG).Infof("Closing transcoder=%s channel", from)
+	return nil
+}
+
+// How often the tokens of connected transcoders are checked
+var transcoderTokenCheckInterval = 10 * time.Second
+
+// watchTranscoderToken disconnects the transcoder on stream once its token
+// is revoked or expires, and rotates the token once half of its TTL is past
+func (n *LivepeerNode) watchTranscoderToken(ctx context.Context, stream net.Transcoder_RegisterTranscoderServer, token *TranscoderToken) {
+	from := common.GetConnectionAddr(stream.Context())
+	ticker := time.NewTicker(transcoderTokenCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, ok := n.TranscoderTokens.Get(token.ID)
+		if !ok || !current.valid(time.Now()) {
+			glog.Infof("Disconnecting transcoder=%s: its token id=%s was revoked or expired", from, token.ID)
+			n.TranscoderManager.disconnect(stream)
+			return
+		}
+		ttl := n.TranscoderTokens.TTL
+		if ttl <= 0 || current.ExpiresAt.IsZero() || time.Until(current.ExpiresAt) > ttl/2 {
+			continue
+		}
+		rotated, secret, err := n.TranscoderTokens.Rotate(token.ID)
+		if err != nil {
+			glog.Errorf("Error rotating token id=%s of transcoder=%s: %v", token.ID, from, err)
+			continue
+		}
+		if err := n.TranscoderManager.notify(stream, &net.NotifySegment{Token: secret}); err != nil {
+			glog.Errorf("Error sending rotated token to transcoder=%s: %v", from, err)
+			return
+		}
+		token = rotated
+	}
 }
 
 func (n *RemoteTranscoderManager) transcoderResults(tcId int64, res *RemoteTranscoderResult) {
@@ -435,12 +601,104 @@ func (n *RemoteTranscoderManager) transcoderResults(tcId int64, res *RemoteTrans
 	remoteChan <- res
 }
 
+// Codec segments are sent to transcoders in and renditions encoded in
+const segmentCodec = "h264"
+
+// TranscoderCodecs are the codecs the transcoders of this node can decode
+// and encode, reported by standalone transcoders as they register
+var TranscoderCodecs = []string{segmentCodec}
+
+// RemoteTranscoderCapacity is what a remote transcoder reports it can take
+// on as it registers
+type RemoteTranscoderCapacity struct {
+	// Sessions the transcoder can run at once
+	Sessions int
+	// GPUs the transcoder transcodes on; 0 if on the CPU
+	GPUs int
+	// Codecs the transcoder can decode and encode; all if empty
+	Codecs []string
+	// Accel is the hardware acceleration the GPUs are used through, e.g.
+	// nvidia or vaapi; empty if on the CPU
+	Accel string
+}
+
+func (c RemoteTranscoderCapacity) supports(codec string) bool {
+	if len(c.Codecs) == 0 {
+		return true
+	}
+	for _, supported := range c.Codecs {
+		if strings.EqualFold(supported, codec) {
+			return true
+		}
+	}
+	return false
+}
+
 type RemoteTranscoder struct {
-	manager  *RemoteTranscoderManager
-	stream   net.Transcoder_RegisterTranscoderServer
-	eof      chan struct{}
-	addr     string
-	capacity int
+	manager *RemoteTranscoderManager
+	stream  net.Transcoder_RegisterTranscoderServer
+	eof     chan struct{}
+	addr    string
+	// id the transcoder registers with each time it connects; empty if
+	// it doesn't reconnect as the same transcoder
+	id       string
+	capacity RemoteTranscoderCapacity
+	// load is the number of segments the transcoder is transcoding,
+	// guarded by the manager's RTmutex
+	load int
+	// draining transcoders get no new segments, and are disconnected once
+	// those they have are done
+	draining bool
+	// latency is the moving average of the round trip time of the segments
+	// the transcoder transcoded, guarded by the manager's RTmutex; 0 until
+	// it transcoded any
+	latency time.Duration
+	// failedChecks is the number of health checks failed in a row, guarded
+	// by the manager's RTmutex
+	failedChecks int
+	// evicted transcoders failed their health checks, and aren't waited on
+	// to reconnect
+	evicted bool
+	// sendMu serializes the messages sent on stream
+	sendMu sync.Mutex
+}
+
+// metricsLabel is the label the transcoder is recorded with: its ID, rather
+// than its address, which changes each time it connects
+func (rt *RemoteTranscoder) metricsLabel() string {
+	if rt.id != "" {
+		return rt.id
+	}
+	return "unidentified"
+}
+
+// headroom is the number of segments the transcoder can take on
+func (rt *RemoteTranscoder) headroom() int {
+	return rt.capacity.Sessions - rt.load
+}
+
+// available is whether the transcoder can take on a segment routed to the
+// pool of transcoders with tags. RTmutex must be held.
+func (rt *RemoteTranscoder) available(tags []string) bool {
+	return !rt.draining && rt.headroom() > 0 && rt.capacity.supports(segmentCodec) && rt.hasTags(tags)
+}
+
+// loadPerGPU is the share of each GPU of the transcoder in use
+func (rt *RemoteTranscoder) loadPerGPU() float64 {
+	if rt.capacity.GPUs <= 1 {
+		return float64(rt.load)
+	}
+	return float64(rt.load) / float64(rt.capacity.GPUs)
+}
+
+// recordLatency adds the round trip time of a segment to the moving average.
+// RTmutex must be held.
+func (rt *RemoteTranscoder) recordLatency(roundTrip time.Duration) {
+	if rt.latency == 0 {
+		rt.latency = roundTrip
+		return
+	}
+	rt.latency = time.Duration(float64(rt.latency)*(1-remoteTranscoderLatencyWeight) + float64(roundTrip)*remoteTranscoderLatencyWeight)
 }
 
 // RemoteTranscoderFatalError wraps error to indicate that error is fatal
@@ -455,8 +713,33 @@ func NewRemoteTranscoderFatalError(err error) error {
 }
 
 var RemoteTranscoderTimeout = 8 * time.Second
+
+// RemoteTranscoderReconnectGrace is how long a transcoder whose stream dropped
+// is waited on to reconnect, while the segments sent to it are held for it
+// instead of going to other transcoders
+var RemoteTranscoderReconnectGrace = 5 * time.Second
 var ErrRemoteTranscoderTimeout = errors.New("Remote transcoder took too long")
 
+// RemoteTranscoderMaxRetries is how many more times a segment is sent to a
+// transcoder once transcoders failed fatally on it, before its error is
+// returned
+var RemoteTranscoderMaxRetries = 3
+
+// RemoteTranscoderLatencyTolerance is how much slower than the fastest
+// transcoder, as a fraction of its latency, transcoders can be for live
+// segments to be balanced over them by headroom
+var RemoteTranscoderLatencyTolerance = 0.2
+
+// remoteTranscoderLatencyWeight is the weight of a segment's round trip
+// time in the moving average of a transcoder's latency
+const remoteTranscoderLatencyWeight = 0.2
+
+func (rt *RemoteTranscoder) send(msg *net.NotifySegment) error {
+	rt.sendMu.Lock()
+	defer rt.sendMu.Unlock()
+	return rt.stream.Send(msg)
+}
+
 func (rt *RemoteTranscoder) done() {
 	// select so we don't block indefinitely if there's no listener
 	select {
@@ -467,6 +750,12 @@ func (rt *RemoteTranscoder) done() {
 
 // Transcode do actual transcoding by sending work to remote transcoder and waiting for the result
 func (rt *RemoteTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	return rt.transcode(fname, &SegTranscodingMetadata{Profiles: profiles})
+}
+
+// transcode passes the trace context of the segment md on so the transcoder
+// can continue its trace, and its stream so it can keep a session for it
+func (rt *RemoteTranscoder) transcode(fname string, md *SegTranscodingMetadata) ([][]byte, error) {
 	taskId, taskChan := rt.manager.addTaskChan()
 	defer rt.manager.removeTaskChan(taskId)
 	signalEOF := func(err error) ([][]byte, error) {
@@ -475,11 +764,13 @@ func (rt *RemoteTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfi
 		return [][]byte{}, RemoteTranscoderFatalError{err}
 	}
 	msg := &net.NotifySegment{
-		Url:      fname,
-		TaskId:   taskId,
-		Profiles: common.ProfilesToTranscodeOpts(profiles),
+		Url:          fname,
+		TaskId:       taskId,
+		Profiles:     common.ProfilesToTranscodeOpts(md.Profiles),
+		TraceContext: md.TraceContext,
+		ManifestId:   string(md.ManifestID),
 	}
-	err := rt.stream.Send(msg)
+	err := rt.send(msg)
 	if err != nil {
 		return signalEOF(err)
 	}
@@ -494,7 +785,7 @@ func (rt *RemoteTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfi
 		return chanData.Segments, chanData.Err
 	}
 }
-func NewRemoteTranscoder(m *RemoteTranscoderManager, stream net.Transcoder_RegisterTranscoderServer, capacity int) *RemoteTranscoder {
+func NewRemoteTranscoder(m *RemoteTranscoderManager, stream net.Transcoder_RegisterTranscoderServer, capacity RemoteTranscoderCapacity) *RemoteTranscoder {
 	return &RemoteTranscoder{
 		manager:  m,
 		stream:   stream,
@@ -506,9 +797,12 @@ func NewRemoteTranscoder(m *RemoteTranscoderManager, stream net.Transcoder_Regis
 
 func NewRemoteTranscoderManager() *RemoteTranscoderManager {
 	return &RemoteTranscoderManager{
-		remoteTranscoders: []*RemoteTranscoder{},
-		liveTranscoders:   map[net.Transcoder_RegisterTranscoderServer]*RemoteTranscoder{},
-		RTmutex:           &sync.Mutex{},
+		liveTranscoders: map[net.Transcoder_RegisterTranscoderServer]*RemoteTranscoder{},
+		reconnecting:    make(map[string]chan struct{}),
+		evictedUntil:    make(map[string]time.Time),
+		affinity:        make(map[ManifestID]*RemoteTranscoder),
+		tags:            make(map[string][]string),
+		RTmutex:         &sync.Mutex{},
 
 		taskMutex: &sync.RWMutex{},
 		taskChans: make(map[int64]TranscoderChan),
@@ -516,9 +810,28 @@ func NewRemoteTranscoderManager() *RemoteTranscoderManager {
 }
 
 type RemoteTranscoderManager struct {
-	remoteTranscoders []*RemoteTranscoder
-	liveTranscoders   map[net.Transcoder_RegisterTranscoderServer]*RemoteTranscoder
-	RTmutex           *sync.Mutex
+	liveTranscoders map[net.Transcoder_RegisterTranscoderServer]*RemoteTranscoder
+	// reconnecting are the IDs of the transcoders whose stream dropped, until
+	// they reconnect or RemoteTranscoderReconnectGrace is over, as which
+	// their channel is closed
+	reconnecting map[string]chan struct{}
+	// evictedUntil are the IDs and IP addresses of the evicted transcoders,
+	// refused until when
+	evictedUntil map[string]time.Time
+	// rules route streams to pools of transcoders by the tags assigned to
+	// them, by ID
+	rules []TranscoderRule
+	tags  map[string][]string
+	// db keeps the rules and tags; nil if they're kept in memory only
+	db *common.DB
+	// affinity pins each stream to the transcoder its segments go to, for
+	// the encoder state to be kept, until it disconnects or is overloaded
+	affinity map[ManifestID]*RemoteTranscoder
+	RTmutex  *sync.Mutex
+
+	// healthOS serves the health check segment to the transcoders
+	healthMu sync.Mutex
+	healthOS drivers.OSSession
 
 	// For tracking tasks assigned to remote transcoders
 	taskMutex *sync.RWMutex
@@ -536,15 +849,35 @@ func (rtm *RemoteTranscoderManager) RegisteredTranscodersInfo() []net.RemoteTran
 	rtm.RTmutex.Lock()
 	res := make([]net.RemoteTranscoderInfo, 0, len(rtm.liveTranscoders))
 	for _, transcoder := range rtm.liveTranscoders {
-		res = append(res, net.RemoteTranscoderInfo{Address: transcoder.addr, Capacity: transcoder.capacity})
+		res = append(res, net.RemoteTranscoderInfo{
+			ID:           transcoder.id,
+			Address:      transcoder.addr,
+			Capacity:     transcoder.capacity.Sessions,
+			GPUs:         transcoder.capacity.GPUs,
+			Codecs:       transcoder.capacity.Codecs,
+			Accel:        transcoder.capacity.Accel,
+			Load:         transcoder.load,
+			Draining:     transcoder.draining,
+			LatencyMs:    float64(transcoder.latency) / float64(time.Millisecond),
+			Tags:         transcoder.tags(),
+			FailedChecks: transcoder.failedChecks,
+		})
 	}
 	rtm.RTmutex.Unlock()
 	return res
 }
 
-func (rtm *RemoteTranscoderManager) Manage(stream net.Transcoder_RegisterTranscoderServer, capacity int) {
+func (rtm *RemoteTranscoderManager) Manage(stream net.Transcoder_RegisterTranscoderServer, capacity RemoteTranscoderCapacity) {
+	rtm.ManageWithID(stream, "", capacity)
+}
+
+// ManageWithID manages a transcoder that registers with id each time it
+// connects, so that the segments sent to it are held for it for a while
+// should its stream drop
+func (rtm *RemoteTranscoderManager) ManageWithID(stream net.Transcoder_RegisterTranscoderServer, id string, capacity RemoteTranscoderCapacity) {
 	from := common.GetConnectionAddr(stream.Context())
 	transcoder := NewRemoteTranscoder(rtm, stream, capacity)
+	transcoder.id = id
 	go func() {
 		ctx := stream.Context()
 		<-ctx.Done()
@@ -555,63 +888,361 @@ func (rtm *RemoteTranscoderManager) Manage(stream net.Transcoder_RegisterTransco
 
 	rtm.RTmutex.Lock()
 	rtm.liveTranscoders[transcoder.stream] = transcoder
-	for i := 0; i < capacity; i++ {
-		rtm.remoteTranscoders = append(rtm.remoteTranscoders, transcoder)
+	if reconnected, ok := rtm.reconnecting[id]; ok {
+		glog.Infof("Transcoder=%s id=%s reconnected", from, id)
+		close(reconnected)
+		delete(rtm.reconnecting, id)
 	}
+	tags := transcoder.tags()
 	rtm.RTmutex.Unlock()
+	glog.Infof("Registered transcoder=%s id=%s sessions=%d gpus=%d codecs=%v tags=%v", from, id, capacity.Sessions, capacity.GPUs, capacity.Codecs, tags)
+	go rtm.healthChecks(transcoder)
 
 	<-transcoder.eof
 	glog.Infof("Got transcoder=%s eof, removing from live transcoders map", from)
 
 	rtm.RTmutex.Lock()
-	delete(rtm.liveTranscoders, transcoder.stream)
+	if rtm.liveTranscoders[transcoder.stream] == transcoder {
+		delete(rtm.liveTranscoders, transcoder.stream)
+	}
+	rtm.awaitReconnect(transcoder)
 	rtm.RTmutex.Unlock()
 }
 
+// awaitReconnect holds transcoder's place for it to reconnect, unless it
+// already did. RTmutex must be held.
+func (rtm *RemoteTranscoderManager) awaitReconnect(transcoder *RemoteTranscoder) {
+	id := transcoder.id
+	if id == "" || transcoder.draining || transcoder.evicted || RemoteTranscoderReconnectGrace <= 0 {
+		return
+	}
+	if _, ok := rtm.reconnecting[id]; ok {
+		return
+	}
+	for _, t := range rtm.liveTranscoders {
+		if t.id == id {
+			return
+		}
+	}
+	reconnected := make(chan struct{})
+	rtm.reconnecting[id] = reconnected
+	time.AfterFunc(RemoteTranscoderReconnectGrace, func() {
+		rtm.RTmutex.Lock()
+		defer rtm.RTmutex.Unlock()
+		if rtm.reconnecting[id] == reconnected {
+			glog.Infof("Transcoder=%s id=%s didn't reconnect within %v", transcoder.addr, id, RemoteTranscoderReconnectGrace)
+			close(reconnected)
+			delete(rtm.reconnecting, id)
+		}
+	})
+}
+
+// waitForReconnect waits for the transcoder with id to reconnect, or any
+// transcoder if id is empty, until their grace is over. Returns whether any
+// were reconnecting.
+func (rtm *RemoteTranscoderManager) waitForReconnect(id string) bool {
+	rtm.RTmutex.Lock()
+	reconnected, ok := rtm.reconnecting[id]
+	if id == "" {
+		for _, reconnected = range rtm.reconnecting {
+			ok = true
+			break
+		}
+	}
+	rtm.RTmutex.Unlock()
+	if ok {
+		<-reconnected
+	}
+	return ok
+}
+
+// selectTranscoder picks a transcoder for a live segment of any stream
 func (rtm *RemoteTranscoderManager) selectTranscoder() *RemoteTranscoder {
+	return rtm.selectTranscoderFor(&SegTranscodingMetadata{})
+}
+
+// selectTranscoderFor picks a transcoder for the segment md: that its stream
+// is pinned to, if it can take the segment on. Otherwise, from the pool the
+// stream is routed to, among the fastest transcoders for live segments and
+// the slowest for others, the one with the most headroom, breaking ties by
+// the least loaded GPUs, to which the stream is pinned from then on.
+func (rtm *RemoteTranscoderManager) selectTranscoderFor(md *SegTranscodingMetadata) *RemoteTranscoder {
 	rtm.RTmutex.Lock()
 	defer rtm.RTmutex.Unlock()
 
-	checkTranscoders := func(rtm *RemoteTranscoderManager) bool {
-		return len(rtm.remoteTranscoders) > 0
+	return rtm.selectTranscoderLocked("", md)
+}
+
+// selectTranscoderLocked picks the transcoder with id, or any if id is
+// empty. RTmutex must be held.
+func (rtm *RemoteTranscoderManager) selectTranscoderLocked(id string, md *SegTranscodingMetadata) *RemoteTranscoder {
+	priority := md.Priority
+	pool := rtm.poolLocked(md)
+	if pinned := rtm.pinnedLocked(md.ManifestID); pinned != nil && pinned.available(pool) && (id == "" || pinned.id == id) {
+		pinned.load++
+		return pinned
+	}
+	var eligible []*RemoteTranscoder
+	var fastest, slowest time.Duration
+	for _, t := range rtm.liveTranscoders {
+		if !t.available(pool) || (id != "" && t.id != id) {
+			continue
+		}
+		eligible = append(eligible, t)
+		if t.latency > 0 && (fastest == 0 || t.latency < fastest) {
+			fastest = t.latency
+		}
+		if t.latency > slowest {
+			slowest = t.latency
+		}
 	}
 
-	for checkTranscoders(rtm) {
-		last := len(rtm.remoteTranscoders) - 1
-		currentTranscoder, remoteTranscoders := rtm.remoteTranscoders[last], rtm.remoteTranscoders[:last]
-		rtm.remoteTranscoders = remoteTranscoders
-		if _, ok := rtm.liveTranscoders[currentTranscoder.stream]; ok {
-			return currentTranscoder
+	var selected *RemoteTranscoder
+	tolerance := 1 + RemoteTranscoderLatencyTolerance
+	for _, t := range eligible {
+		// Transcoders yet to transcode a segment are tried with live ones
+		if priority == SegmentPriorityLive && t.latency > 0 && float64(t.latency) > float64(fastest)*tolerance {
+			continue
+		}
+		if priority != SegmentPriorityLive && slowest > 0 && float64(t.latency)*tolerance < float64(slowest) {
+			continue
+		}
+		if selected == nil || t.headroom() > selected.headroom() ||
+			(t.headroom() == selected.headroom() && t.loadPerGPU() < selected.loadPerGPU()) {
+			selected = t
+		}
+	}
+	if selected != nil {
+		selected.load++
+		if md.ManifestID != "" && rtm.affinity[md.ManifestID] != selected {
+			glog.V(common.DEBUG).Infof("Pinned manifestID=%s to transcoder=%s", md.ManifestID, selected.addr)
+			rtm.affinity[md.ManifestID] = selected
 		}
-		// try again if transcoder does not exist in table
 	}
+	return selected
+}
 
+// pinnedLocked returns the live transcoder the stream mid is pinned to, or
+// the one that reconnected in its place. RTmutex must be held.
+func (rtm *RemoteTranscoderManager) pinnedLocked(mid ManifestID) *RemoteTranscoder {
+	pinned, ok := rtm.affinity[mid]
+	if !ok {
+		return nil
+	}
+	if rtm.liveTranscoders[pinned.stream] == pinned {
+		return pinned
+	}
+	if pinned.id != "" {
+		for _, t := range rtm.liveTranscoders {
+			if t.id == pinned.id {
+				rtm.affinity[mid] = t
+				return t
+			}
+		}
+	}
 	return nil
 }
 
+// unpin forgets the transcoder the stream mid is pinned to, as it ended
+func (rtm *RemoteTranscoderManager) unpin(mid ManifestID) {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	delete(rtm.affinity, mid)
+}
+
+// recordLatency adds the round trip time of a segment to the latency trans
+// is routed by
+func (rtm *RemoteTranscoderManager) recordLatency(trans *RemoteTranscoder, roundTrip time.Duration, priority SegmentPriority) {
+	rtm.RTmutex.Lock()
+	trans.recordLatency(roundTrip)
+	rtm.RTmutex.Unlock()
+	if monitor.Enabled {
+		monitor.RemoteTranscoderSegment(trans.metricsLabel(), priority.String(), roundTrip)
+	}
+}
+
 func (rtm *RemoteTranscoderManager) completeTranscoders(trans *RemoteTranscoder) {
 	rtm.RTmutex.Lock()
 	defer rtm.RTmutex.Unlock()
 
-	if _, ok := rtm.liveTranscoders[trans.stream]; ok {
-		rtm.remoteTranscoders = append(rtm.remoteTranscoders, trans)
+	trans.load--
+	if trans.draining && trans.load <= 0 {
+		glog.Infof("Drained transcoder=%s, disconnecting", trans.addr)
+		trans.done()
+	}
+}
+
+var ErrTranscoderNotFound = errors.New("transcoder not found")
+
+// UpdateCapacity replaces the capacity of the transcoder registered with
+// stream, as it sends it again over the stream while connected. Segments are
+// sent to the transcoder by its new capacity from then on; those it has are
+// left to it.
+func (rtm *RemoteTranscoderManager) UpdateCapacity(stream net.Transcoder_RegisterTranscoderServer, capacity RemoteTranscoderCapacity) error {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	t, ok := rtm.liveTranscoders[stream]
+	if !ok {
+		return ErrTranscoderNotFound
+	}
+	glog.Infof("Updated capacity of transcoder=%s id=%s sessions=%d gpus=%d codecs=%v",
+		t.addr, t.id, capacity.Sessions, capacity.GPUs, capacity.Codecs)
+	t.capacity = capacity
+	return nil
+}
+
+// Capacity is the capacity of the transcoders that aren't draining together:
+// their sessions and GPUs summed up, and the codecs any of them supports
+func (rtm *RemoteTranscoderManager) Capacity() RemoteTranscoderCapacity {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	var total RemoteTranscoderCapacity
+	anyCodec := false
+	for _, t := range rtm.liveTranscoders {
+		if t.draining {
+			continue
+		}
+		total.Sessions += t.capacity.Sessions
+		total.GPUs += t.capacity.GPUs
+		if len(t.capacity.Codecs) == 0 {
+			anyCodec = true
+		}
+		for _, codec := range t.capacity.Codecs {
+			if len(total.Codecs) == 0 || !total.supports(codec) {
+				total.Codecs = append(total.Codecs, codec)
+			}
+		}
+	}
+	if anyCodec {
+		total.Codecs = nil
+	}
+	return total
+}
+
+// Drain stops new segments being sent to the transcoder with the ID or
+// address given, which is told to disconnect and exit once the segments it
+// has are done
+func (rtm *RemoteTranscoderManager) Drain(transcoder string) error {
+	rtm.RTmutex.Lock()
+	var drained *RemoteTranscoder
+	for _, t := range rtm.liveTranscoders {
+		if transcoder != "" && (t.id == transcoder || t.addr == transcoder) {
+			drained = t
+			break
+		}
+	}
+	if drained == nil {
+		rtm.RTmutex.Unlock()
+		return ErrTranscoderNotFound
+	}
+	drained.draining = true
+	idle := drained.load <= 0
+	rtm.RTmutex.Unlock()
+
+	glog.Infof("Draining transcoder=%s id=%s", drained.addr, drained.id)
+	if err := drained.send(&net.NotifySegment{Drain: true}); err != nil {
+		glog.Errorf("Error telling transcoder=%s it's being drained: %v", drained.addr, err)
+	}
+	if idle {
+		drained.done()
+	}
+	return nil
+}
+
+// notify sends msg to the transcoder registered with stream, in between the
+// segments sent to it
+func (rtm *RemoteTranscoderManager) notify(stream net.Transcoder_RegisterTranscoderServer, msg *net.NotifySegment) error {
+	rtm.RTmutex.Lock()
+	transcoder, ok := rtm.liveTranscoders[stream]
+	rtm.RTmutex.Unlock()
+	if !ok {
+		return errors.New("transcoder is not registered")
+	}
+	return transcoder.send(msg)
+}
+
+// disconnect stops segments being sent to the transcoder registered with
+// stream, and ends its stream
+func (rtm *RemoteTranscoderManager) disconnect(stream net.Transcoder_RegisterTranscoderServer) {
+	rtm.RTmutex.Lock()
+	transcoder, ok := rtm.liveTranscoders[stream]
+	delete(rtm.liveTranscoders, stream)
+	rtm.RTmutex.Unlock()
+	if ok {
+		transcoder.done()
+	}
+}
+
+// evictTranscoder stops segments being sent to trans once it failed fatally,
+// before its stream is done
+func (rtm *RemoteTranscoderManager) evictTranscoder(trans *RemoteTranscoder) {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	trans.load--
+	if rtm.liveTranscoders[trans.stream] == trans {
+		delete(rtm.liveTranscoders, trans.stream)
 	}
+	rtm.awaitReconnect(trans)
 }
 
 func (rtm *RemoteTranscoderManager) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
-	currentTranscoder := rtm.selectTranscoder()
+	return rtm.transcode(fname, &SegTranscodingMetadata{Profiles: profiles})
+}
+
+// transcode transcodes the segment md, stored at fname, to md.Profiles
+func (rtm *RemoteTranscoderManager) transcode(fname string, md *SegTranscodingMetadata) ([][]byte, error) {
+	return rtm.transcodeRetrying(fname, md, 0)
+}
+
+// transcodeRetrying transcodes the segment md after it was retried retries
+// times
+func (rtm *RemoteTranscoderManager) transcodeRetrying(fname string, md *SegTranscodingMetadata, retries int) ([][]byte, error) {
+	currentTranscoder := rtm.selectTranscoderFor(md)
+	// Hold the segment while transcoders are reconnecting
+	for currentTranscoder == nil && rtm.waitForReconnect("") {
+		currentTranscoder = rtm.selectTranscoderFor(md)
+	}
 	if currentTranscoder == nil {
 		return nil, errors.New("No transcoders available")
 	}
-	res, err := currentTranscoder.Transcode(fname, profiles)
+	return rtm.transcodeWith(currentTranscoder, fname, md, retries)
+}
+
+func (rtm *RemoteTranscoderManager) transcodeWith(currentTranscoder *RemoteTranscoder, fname string, md *SegTranscodingMetadata, retries int) ([][]byte, error) {
+	start := time.Now()
+	res, err := currentTranscoder.transcode(fname, md)
+	if err == nil {
+		rtm.recordLatency(currentTranscoder, time.Since(start), md.Priority)
+	}
 	_, fatal := err.(RemoteTranscoderFatalError)
 	if fatal {
+		rtm.evictTranscoder(currentTranscoder)
 		// Don't retry if we've timed out; broadcaster likely to have moved on
 		// XXX problematic for VOD when we *should* retry
 		if err.(RemoteTranscoderFatalError).error == ErrRemoteTranscoderTimeout {
 			return res, err
 		}
-		return rtm.Transcode(fname, profiles)
+		// Segments that fail every transcoder they're sent to, e.g. as they
+		// crash them, aren't retried forever
+		if retries >= RemoteTranscoderMaxRetries {
+			glog.Errorf("Giving up on segment after %d retries fname=%s err=%v", retries, fname, err)
+			return res, err
+		}
+		// Send the segment to the same transcoder again if it reconnects in
+		// time, to other transcoders otherwise
+		if id := currentTranscoder.id; id != "" && rtm.waitForReconnect(id) {
+			rtm.RTmutex.Lock()
+			reconnected := rtm.selectTranscoderLocked(id, md)
+			rtm.RTmutex.Unlock()
+			if reconnected != nil {
+				return rtm.transcodeWith(reconnected, fname, md, retries+1)
+			}
+		}
+		return rtm.transcodeRetrying(fname, md, retries+1)
 	}
 	rtm.completeTranscoders(currentTranscoder)
 	return res, err
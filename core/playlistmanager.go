@@ -5,14 +5,14 @@ import (
 	"sync"
 
 	"github.com/ericxtang/m3u8"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/drivers"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 )
 
 const LIVE_LIST_LENGTH uint = 6
 
-//	PlaylistManager manages playlists and data for one video stream, backed by one object storage.
+// PlaylistManager manages playlists and data for one video stream, backed by one object storage.
 type PlaylistManager interface {
 	ManifestID() ManifestID
 	// Implicitly creates master and media playlists
@@ -48,9 +48,26 @@ func NewBasicPlaylistManager(manifestID ManifestID,
 		mediaLists:     make(map[string]*m3u8.MediaPlaylist),
 		mapSync:        &sync.RWMutex{},
 	}
+	if rs, ok := storageSession.(drivers.RepointingSession); ok {
+		rs.OnRepoint(bplm.repointSegment)
+	}
 	return bplm
 }
 
+// repointSegment has the segments at the URI from, e.g. in the fallback
+// storage, point to the URI to they were moved to instead
+func (mgr *BasicPlaylistManager) repointSegment(from, to string) {
+	mgr.mapSync.Lock()
+	defer mgr.mapSync.Unlock()
+	for _, mpl := range mgr.mediaLists {
+		for _, seg := range mpl.Segments {
+			if seg != nil && seg.URI == from {
+				seg.URI = to
+			}
+		}
+	}
+}
+
 func (mgr *BasicPlaylistManager) ManifestID() ManifestID {
 	return mgr.manifestID
 }
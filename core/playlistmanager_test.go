@@ -186,3 +186,33 @@ func TestCleanup(t *testing.T) {
 		t.Fatal("Data should be cleaned up")
 	}
 }
+
+// repointingSession captures the func called as its objects move
+type repointingSession struct {
+	drivers.OSSession
+	repoint func(from, to string)
+}
+
+func (s *repointingSession) OnRepoint(f func(from, to string)) {
+	s.repoint = f
+}
+
+func TestRepointSegment(t *testing.T) {
+	vProfile := ffmpeg.P144p30fps16x9
+	sess := &repointingSession{}
+	c := NewBasicPlaylistManager(RandomManifestID(), sess)
+	if sess.repoint == nil {
+		t.Fatal("Expected the playlists to follow the segments as they move")
+	}
+	c.InsertHLSSegment(&vProfile, 1, "http://node/stream/1.ts", 12)
+	c.InsertHLSSegment(&vProfile, 2, "http://node/stream/2.ts", 12)
+
+	// Segments moved, e.g. out of the fallback storage, are played from
+	// where they are now
+	sess.repoint("http://node/stream/1.ts", "https://primary/1.ts")
+	sess.repoint("http://node/stream/gone.ts", "https://primary/gone.ts")
+	pl := c.GetHLSMediaPlaylist(vProfile.Name)
+	if pl.Segments[0].URI != "https://primary/1.ts" || pl.Segments[1].URI != "http://node/stream/2.ts" {
+		t.Error("Unexpected segment URIs ", pl.Segments[0].URI, pl.Segments[1].URI)
+	}
+}
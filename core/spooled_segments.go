@@ -0,0 +1,58 @@
+package core
+
+import (
+	"sync"
+)
+
+// SpooledSegmentPath is where the orchestrator serves the source segments
+// spooled to disk to remote transcoders, by ID
+const SpooledSegmentPath = "/spooledSegment/"
+
+// spooledSegments are the files of source segments spooled to disk as they
+// were received, served by unguessable IDs to remote transcoders while they
+// transcode them, rather than copied into memory to be served from there
+type spooledSegments struct {
+	mu    sync.RWMutex
+	files map[string]string
+}
+
+// add serves fname until removed; returns its ID
+func (s *spooledSegments) add(fname string) (string, error) {
+	id, err := randHex(16)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string]string)
+	}
+	s.files[id] = fname
+	return id, nil
+}
+
+func (s *spooledSegments) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, id)
+}
+
+func (s *spooledSegments) get(id string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fname, ok := s.files[id]
+	return fname, ok
+}
+
+// spooledSegmentURI is where the spooled segment with id is served from
+func (n *LivepeerNode) spooledSegmentURI(id string) string {
+	uri := *n.GetServiceURI()
+	uri.Path = SpooledSegmentPath + id
+	return uri.String()
+}
+
+// SpooledSegment returns the file of the spooled segment with id, while it's
+// being transcoded
+func (orch *orchestrator) SpooledSegment(id string) (string, bool) {
+	return orch.node.spooled.get(id)
+}
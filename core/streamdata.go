@@ -28,6 +28,42 @@ type SegTranscodingMetadata struct {
 	Hash       ethcommon.Hash
 	Profiles   []ffmpeg.VideoProfile
 	OS         *net.OSInfo
+
+	// TraceContext of the segment's trace and its request ID, for remote
+	// transcoders. Not signed.
+	TraceContext map[string]string
+	// Priority the segment is routed to remote transcoders by. Not signed.
+	Priority SegmentPriority
+	// Fname is the file the segment was spooled to as it was received, read
+	// in place of its data. Not signed.
+	Fname string
+}
+
+// SegmentPriority is how urgently a segment is to be transcoded
+type SegmentPriority int
+
+const (
+	// SegmentPriorityLive segments are routed to the fastest transcoders
+	SegmentPriorityLive SegmentPriority = iota
+	// SegmentPriorityLow segments are routed to the slowest transcoders,
+	// keeping the fastest for live ones
+	SegmentPriorityLow
+)
+
+// ParseSegmentPriority parses "live" or "low"; segments are live unless
+// they're low
+func ParseSegmentPriority(s string) SegmentPriority {
+	if strings.EqualFold(s, "low") {
+		return SegmentPriorityLow
+	}
+	return SegmentPriorityLive
+}
+
+func (p SegmentPriority) String() string {
+	if p == SegmentPriorityLow {
+		return "low"
+	}
+	return "live"
 }
 
 func (md *SegTranscodingMetadata) Flatten() []byte {
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,7 +16,7 @@ import (
 	"github.com/livepeer/lpms/ffmpeg"
 	"github.com/livepeer/lpms/transcoder"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 type Transcoder interface {
@@ -23,7 +24,8 @@ type Transcoder interface {
 }
 
 type LocalTranscoder struct {
-	workDir string
+	workDir  string
+	sessions *transcodeSessions
 }
 
 func (lt *LocalTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
@@ -48,45 +50,92 @@ func (lt *LocalTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfil
 	return data, err
 }
 
+// TranscodeStream transcodes a segment of the stream mid in turn with its
+// other segments
+func (lt *LocalTranscoder) TranscodeStream(mid ManifestID, fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	s := lt.sessions.acquire(mid)
+	defer lt.sessions.release(s)
+	return lt.Transcode(fname, profiles)
+}
+
+func (lt *LocalTranscoder) EndStream(mid ManifestID) {
+	lt.sessions.end(mid)
+}
+
 func NewLocalTranscoder(workDir string) Transcoder {
-	return &LocalTranscoder{workDir: workDir}
+	return &LocalTranscoder{workDir: workDir, sessions: newTranscodeSessions(nil)}
 }
 
-type NvidiaTranscoder struct {
+// HardwareTranscoder transcodes on GPUs or other media engines through the
+// acceleration API of the platform
+type HardwareTranscoder struct {
+	accel   ffmpeg.Acceleration
 	workDir string
 	devices []string
 
 	// The following fields need to be protected by the mutex `mu`
 	mu     *sync.Mutex
 	devIdx int // current index within the devices list
+
+	sessions *transcodeSessions
+}
+
+// Names of the hardware accelerations, as advertised in transcoder capacity
+const (
+	AccelNvidia       = "nvidia"
+	AccelVAAPI        = "vaapi"
+	AccelVideoToolbox = "videotoolbox"
+)
+
+// VideoToolbox has no device to pick; the media engine of the Mac is
+// tracked as a single device
+const videoToolboxDevice = "0"
+
+func (hw *HardwareTranscoder) getDevice() string {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.devIdx = (hw.devIdx + 1) % len(hw.devices)
+	return hw.devices[hw.devIdx]
+}
+
+func (hw *HardwareTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	tc := ffmpeg.NewTranscoder()
+	defer tc.StopTranscoder()
+	return hw.transcodeOn(tc, hw.getDevice(), fname, profiles)
 }
 
-func (nv *NvidiaTranscoder) getDevice() string {
-	nv.mu.Lock()
-	defer nv.mu.Unlock()
-	nv.devIdx = (nv.devIdx + 1) % len(nv.devices)
-	return nv.devices[nv.devIdx]
+// TranscodeStream transcodes a segment of the stream mid on the GPU of its
+// session, in turn with its other segments, reusing the decoder and encoders
+// the earlier segments were transcoded with
+func (hw *HardwareTranscoder) TranscodeStream(mid ManifestID, fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	s := hw.sessions.acquire(mid)
+	defer hw.sessions.release(s)
+	return hw.transcodeOn(s.transcoder(), s.device, fname, profiles)
 }
 
-func (nv *NvidiaTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+func (hw *HardwareTranscoder) EndStream(mid ManifestID) {
+	hw.sessions.end(mid)
+}
+
+func (hw *HardwareTranscoder) transcodeOn(tc *ffmpeg.Transcoder, device string, fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
 	// Set up in / out config
 	in := &ffmpeg.TranscodeOptionsIn{
 		Fname:  fname,
-		Accel:  ffmpeg.Nvidia,
-		Device: nv.getDevice(),
+		Accel:  hw.accel,
+		Device: device,
 	}
 	opts := make([]ffmpeg.TranscodeOptions, len(profiles), len(profiles))
 	for i := range profiles {
 		o := ffmpeg.TranscodeOptions{
-			Oname:   fmt.Sprintf("%s/out_%s", nv.workDir, randName()),
+			Oname:   fmt.Sprintf("%s/out_%s", hw.workDir, randName()),
 			Profile: profiles[i],
-			Accel:   ffmpeg.Nvidia,
+			Accel:   hw.accel,
 		}
 		opts[i] = o
 	}
 
 	// Do the Transcoding
-	if err := ffmpeg.Transcode2(in, opts); err != nil {
+	if err := tc.Transcode(in, opts); err != nil {
 		return [][]byte{}, err
 	}
 
@@ -105,9 +154,119 @@ func (nv *NvidiaTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfi
 	return out, nil
 }
 
+// Accel returns the name of the acceleration transcoded with
+func (hw *HardwareTranscoder) Accel() string {
+	switch hw.accel {
+	case ffmpeg.Nvidia:
+		return AccelNvidia
+	case ffmpeg.VAAPI:
+		return AccelVAAPI
+	case ffmpeg.VideoToolbox:
+		return AccelVideoToolbox
+	}
+	return ""
+}
+
+// Devices returns the IDs of the GPUs used for transcoding
+func (hw *HardwareTranscoder) Devices() []string {
+	return hw.devices
+}
+
+// CheckDevices returns an error if any of the GPUs isn't present
+func (hw *HardwareTranscoder) CheckDevices() error {
+	for _, d := range hw.devices {
+		if err := hw.checkDevice(d); err != nil {
+			return fmt.Errorf("GPU %s unavailable: %v", d, err)
+		}
+	}
+	return nil
+}
+
+// AvailableDevices returns the GPUs that are present, and that FFmpeg can
+// transcode on
+func (hw *HardwareTranscoder) AvailableDevices() []string {
+	var available []string
+	for _, d := range hw.devices {
+		if err := hw.checkDevice(d); err == nil {
+			available = append(available, d)
+		}
+	}
+	return available
+}
+
+func (hw *HardwareTranscoder) checkDevice(d string) error {
+	switch hw.accel {
+	case ffmpeg.VideoToolbox:
+		if runtime.GOOS != "darwin" {
+			return fmt.Errorf("VideoToolbox is unsupported on %s", runtime.GOOS)
+		}
+	case ffmpeg.VAAPI:
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("VAAPI is unsupported on %s", runtime.GOOS)
+		}
+		if _, err := os.Stat(d); err != nil {
+			return err
+		}
+	default:
+		if _, err := os.Stat("/dev/nvidia" + d); err != nil {
+			return err
+		}
+	}
+	if !ffmpeg.HasEncoder(hw.accel) {
+		return fmt.Errorf("FFmpeg was built without the %s encoder", hw.Accel())
+	}
+	// The device node of a GPU is left behind as its driver fails, e.g. as
+	// it falls off the bus; the device is opened to tell
+	return ffmpeg.CheckDevice(hw.accel, d)
+}
+
+// AvailableCodecs returns the TranscoderCodecs FFmpeg has the encoders of
+// for the acceleration of t
+func AvailableCodecs(t Transcoder) []string {
+	accel := ffmpeg.Software
+	if hw, ok := t.(*HardwareTranscoder); ok {
+		accel = hw.accel
+	}
+	if !ffmpeg.HasEncoder(accel) {
+		return nil
+	}
+	return append([]string(nil), TranscoderCodecs...)
+}
+
+func newHardwareTranscoder(accel ffmpeg.Acceleration, devices []string, workDir string) *HardwareTranscoder {
+	return &HardwareTranscoder{accel: accel, devices: devices, workDir: workDir, mu: &sync.Mutex{}, sessions: newTranscodeSessions(devices)}
+}
+
 func NewNvidiaTranscoder(devices string, workDir string) Transcoder {
-	d := strings.Split(devices, ",")
-	return &NvidiaTranscoder{devices: d, workDir: workDir, mu: &sync.Mutex{}}
+	return newHardwareTranscoder(ffmpeg.Nvidia, strings.Split(devices, ","), workDir)
+}
+
+// NewVAAPITranscoder transcodes on the comma-separated VAAPI render nodes
+// devices, given either as paths or as names under /dev/dri
+func NewVAAPITranscoder(devices string, workDir string) Transcoder {
+	return newHardwareTranscoder(ffmpeg.VAAPI, VAAPIDevices(devices), workDir)
+}
+
+// NewVideoToolboxTranscoder transcodes on the media engine of the Mac
+func NewVideoToolboxTranscoder(workDir string) Transcoder {
+	return newHardwareTranscoder(ffmpeg.VideoToolbox, []string{videoToolboxDevice}, workDir)
+}
+
+// VAAPIDevices returns the paths of the comma-separated VAAPI render nodes
+// devices, e.g. renderD128 for /dev/dri/renderD128
+func VAAPIDevices(devices string) []string {
+	var paths []string
+	for _, d := range strings.Split(devices, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		if !strings.Contains(d, "/") {
+			d = "/dev/dri/" + d
+		}
+		paths = append(paths, d)
+	}
+	return paths
 }
 
 func parseURI(uri string) (string, uint64, error) {
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// Remote transcoders are sent RemoteTranscoderHealthCheckSegment to
+// transcode every RemoteTranscoderHealthCheckInterval, and are evicted once
+// they fail RemoteTranscoderHealthCheckFailures checks in a row. Health
+// checks are off without a segment. Evicted transcoders may not register
+// again, by ID or IP address, for RemoteTranscoderEvictionCooldown.
+var (
+	RemoteTranscoderHealthCheckSegment  []byte
+	RemoteTranscoderHealthCheckInterval = time.Minute
+	RemoteTranscoderHealthCheckFailures = 3
+	RemoteTranscoderEvictionCooldown    = 10 * time.Minute
+)
+
+// ErrTranscoderEvicted refuses transcoders registering again too soon after
+// they were evicted
+var ErrTranscoderEvicted = errors.New("transcoder evicted for failing health checks")
+
+// healthCheckProfiles are the renditions health check segments are
+// transcoded to, the cheapest
+var healthCheckProfiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+
+var errHealthCheckResults = errors.New("health check segment transcoded to the wrong renditions")
+
+// healthChecks checks transcoder periodically while it's live
+func (rtm *RemoteTranscoderManager) healthChecks(transcoder *RemoteTranscoder) {
+	if len(RemoteTranscoderHealthCheckSegment) == 0 || RemoteTranscoderHealthCheckInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(RemoteTranscoderHealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-transcoder.stream.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if !rtm.checkTranscoder(transcoder) {
+			return
+		}
+	}
+}
+
+// checkTranscoder sends the health check segment to transcoder, unless it's
+// busy, evicting it if it failed too many checks. Returns whether it's
+// still live.
+func (rtm *RemoteTranscoderManager) checkTranscoder(transcoder *RemoteTranscoder) bool {
+	rtm.RTmutex.Lock()
+	if rtm.liveTranscoders[transcoder.stream] != transcoder {
+		rtm.RTmutex.Unlock()
+		return false
+	}
+	if transcoder.draining || transcoder.headroom() <= 0 {
+		// Busy with segments, which fail it if it's unhealthy
+		rtm.RTmutex.Unlock()
+		return true
+	}
+	transcoder.load++
+	rtm.RTmutex.Unlock()
+
+	err := rtm.healthCheck(transcoder)
+	_, fatal := err.(RemoteTranscoderFatalError)
+
+	rtm.RTmutex.Lock()
+	transcoder.load--
+	if err == nil {
+		transcoder.failedChecks = 0
+		rtm.RTmutex.Unlock()
+		return true
+	}
+	transcoder.failedChecks++
+	failed := transcoder.failedChecks
+	rtm.RTmutex.Unlock()
+
+	glog.Errorf("Health check failed transcoder=%s id=%s failures=%d err=%v", transcoder.addr, transcoder.id, failed, err)
+	if !fatal && failed < RemoteTranscoderHealthCheckFailures {
+		return true
+	}
+	rtm.evictUnhealthy(transcoder, err)
+	return false
+}
+
+func (rtm *RemoteTranscoderManager) healthCheck(transcoder *RemoteTranscoder) error {
+	url, err := rtm.saveHealthCheckSegment()
+	if err != nil {
+		return err
+	}
+	res, err := transcoder.transcode(url, &SegTranscodingMetadata{Profiles: healthCheckProfiles})
+	if err != nil {
+		return err
+	}
+	if len(res) != len(healthCheckProfiles) || len(res[0]) == 0 {
+		return errHealthCheckResults
+	}
+	return nil
+}
+
+// saveHealthCheckSegment stores the health check segment for the
+// transcoders to download, in a session of its own that's started again
+// should it have been ended for being idle
+func (rtm *RemoteTranscoderManager) saveHealthCheckSegment() (string, error) {
+	if drivers.NodeStorage == nil {
+		return "", errors.New("missing local storage")
+	}
+	rtm.healthMu.Lock()
+	defer rtm.healthMu.Unlock()
+	if rtm.healthOS != nil {
+		if url, err := rtm.healthOS.SaveData("healthcheck.ts", RemoteTranscoderHealthCheckSegment); err == nil {
+			return url, nil
+		}
+	}
+	rtm.healthOS = drivers.NodeStorage.NewSession("healthcheck")
+	return rtm.healthOS.SaveData("healthcheck.ts", RemoteTranscoderHealthCheckSegment)
+}
+
+// evictUnhealthy disconnects transcoder, and has the streams pinned to it
+// go to other transcoders
+func (rtm *RemoteTranscoderManager) evictUnhealthy(transcoder *RemoteTranscoder, err error) {
+	rtm.RTmutex.Lock()
+	if rtm.liveTranscoders[transcoder.stream] == transcoder {
+		delete(rtm.liveTranscoders, transcoder.stream)
+	}
+	transcoder.evicted = true
+	if RemoteTranscoderEvictionCooldown > 0 {
+		until := time.Now().Add(RemoteTranscoderEvictionCooldown)
+		for _, key := range evictionKeys(transcoder.id, transcoder.addr) {
+			rtm.evictedUntil[key] = until
+		}
+	}
+	streams := 0
+	for mid, t := range rtm.affinity {
+		if t == transcoder || (t.id != "" && t.id == transcoder.id) {
+			delete(rtm.affinity, mid)
+			streams++
+		}
+	}
+	failed := transcoder.failedChecks
+	rtm.RTmutex.Unlock()
+
+	glog.Warningf("Evicting unhealthy transcoder=%s id=%s failures=%d streams=%d", transcoder.addr, transcoder.id, failed, streams)
+	transcoder.done()
+	monitor.EmitEvent(monitor.EventTranscoderEvicted, map[string]interface{}{
+		"transcoder": transcoder.addr,
+		"id":         transcoder.id,
+		"failures":   failed,
+		"streams":    streams,
+		"error":      fmt.Sprint(err),
+	})
+}
+
+// evictionKeys are what evicted transcoders are refused by: their ID, if
+// they have one, and their IP address
+func evictionKeys(id, addr string) []string {
+	var keys []string
+	if id != "" {
+		keys = append(keys, "id:"+id)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		keys = append(keys, "ip:"+host)
+	}
+	return keys
+}
+
+// checkEvicted refuses the transcoder with id at addr with
+// ErrTranscoderEvicted while it's cooling down from being evicted
+func (rtm *RemoteTranscoderManager) checkEvicted(id, addr string) error {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+	now := time.Now()
+	for _, key := range evictionKeys(id, addr) {
+		until, ok := rtm.evictedUntil[key]
+		if !ok {
+			continue
+		}
+		if now.Before(until) {
+			glog.Errorf("Refusing evicted transcoder=%s id=%s for another %v", addr, id, until.Sub(now).Round(time.Second))
+			return ErrTranscoderEvicted
+		}
+		delete(rtm.evictedUntil, key)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTranscoder(t *testing.T) {
+	assert := assert.New(t)
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+	defer func(seg []byte, failures int) {
+		RemoteTranscoderHealthCheckSegment, RemoteTranscoderHealthCheckFailures = seg, failures
+	}(RemoteTranscoderHealthCheckSegment, RemoteTranscoderHealthCheckFailures)
+	RemoteTranscoderHealthCheckSegment = []byte("segment")
+	RemoteTranscoderHealthCheckFailures = 2
+
+	m := NewRemoteTranscoderManager()
+	s := &StubTranscoderServer{manager: m}
+	wg := newWg(1)
+	go func() { m.ManageWithID(s, "t1", RemoteTranscoderCapacity{Sessions: 1}); wg.Done() }()
+	time.Sleep(1 * time.Millisecond)
+	tc := m.liveTranscoders[s]
+	tc.addr = "10.0.0.1:5678"
+	m.affinity["stream"] = tc
+
+	// healthy transcoders pass
+	assert.True(m.checkTranscoder(tc))
+	assert.Equal(0, tc.failedChecks)
+	assert.Equal(0, tc.load)
+
+	// busy ones aren't checked
+	s.TranscodeError = errors.New("TranscodeError")
+	tc.load = 1
+	assert.True(m.checkTranscoder(tc))
+	assert.Equal(0, tc.failedChecks)
+	tc.load = 0
+
+	// failing ones are evicted after failing enough checks in a row, along
+	// with the streams pinned to them, and not waited on to reconnect
+	assert.True(m.checkTranscoder(tc))
+	assert.Equal(1, tc.failedChecks)
+	assert.False(m.checkTranscoder(tc))
+	assert.True(wgWait(wg))
+	assert.Empty(m.liveTranscoders)
+	assert.Empty(m.affinity)
+	assert.Empty(m.reconnecting)
+	assert.False(m.checkTranscoder(tc))
+
+	// nor let back in for a while, by ID or IP address
+	n, _ := NewLivepeerNode(nil, "", nil)
+	n.TranscoderManager = m
+	err := n.serveTranscoder(&StubTranscoderServer{manager: m}, "t1", RemoteTranscoderCapacity{Sessions: 1}, nil)
+	assert.Equal(ErrTranscoderEvicted, err)
+	assert.Empty(m.liveTranscoders)
+	assert.Equal(ErrTranscoderEvicted, m.checkEvicted("t2", "10.0.0.1:1234"))
+	assert.Nil(m.checkEvicted("t2", "10.0.0.2:1234"))
+
+	// until the cooldown is over
+	for key := range m.evictedUntil {
+		m.evictedUntil[key] = time.Now()
+	}
+	assert.Nil(m.checkEvicted("t1", "10.0.0.1:1234"))
+	assert.Empty(m.evictedUntil)
+}
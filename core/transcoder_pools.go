@@ -0,0 +1,215 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+var ErrTranscoderRuleNotFound = errors.New("transcoder rule not found")
+
+// TranscoderRule routes the segments of the streams it matches only to the
+// pool of remote transcoders tagged with all of its tags, e.g. 4K streams
+// only to transcoders tagged "a100". Transcoders are tagged by the operator
+// of the orchestrator, by their IDs.
+type TranscoderRule struct {
+	Name string `json:"name"`
+	// ManifestID the manifest ID of the streams matched starts with; any if
+	// empty
+	ManifestID string `json:"manifestID,omitempty"`
+	// MinHeight of the highest rendition of the streams matched; any if 0
+	MinHeight int      `json:"minHeight,omitempty"`
+	Tags      []string `json:"tags"`
+}
+
+func (r *TranscoderRule) matches(md *SegTranscodingMetadata) bool {
+	if r.ManifestID != "" && !strings.HasPrefix(string(md.ManifestID), r.ManifestID) {
+		return false
+	}
+	return r.MinHeight <= 0 || maxHeight(md.Profiles) >= r.MinHeight
+}
+
+func maxHeight(profiles []ffmpeg.VideoProfile) int {
+	max := 0
+	for _, p := range profiles {
+		var w, h int
+		if _, err := fmt.Sscanf(p.Resolution, "%dx%d", &w, &h); err == nil && h > max {
+			max = h
+		}
+	}
+	return max
+}
+
+// hasTags is whether the transcoder is tagged with all of tags. RTmutex
+// must be held.
+func (rt *RemoteTranscoder) hasTags(tags []string) bool {
+	assigned := rt.tags()
+	for _, tag := range tags {
+		found := false
+		for _, t := range assigned {
+			if strings.EqualFold(t, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tags are those the operator assigned the transcoder by its ID; none if it
+// has no ID. RTmutex must be held.
+func (rt *RemoteTranscoder) tags() []string {
+	if rt.id == "" {
+		return nil
+	}
+	return rt.manager.tags[rt.id]
+}
+
+// LoadPools loads the rules and the tags of the transcoders from db, which
+// may be nil for them to be kept in memory only, and stores them there as
+// they're changed
+func (rtm *RemoteTranscoderManager) LoadPools(db *common.DB) error {
+	stored, err := db.TranscoderRules()
+	if err != nil {
+		return err
+	}
+	tags, err := db.TranscoderTags()
+	if err != nil {
+		return err
+	}
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	rtm.db = db
+	rtm.rules = nil
+	for _, r := range stored {
+		rtm.rules = append(rtm.rules, TranscoderRule{Name: r.Name, ManifestID: r.ManifestID, MinHeight: r.MinHeight, Tags: r.Tags})
+	}
+	rtm.tags = make(map[string][]string)
+	for id, t := range tags {
+		rtm.tags[id] = t
+	}
+	return nil
+}
+
+// storeRulesLocked stores rules in the DB. RTmutex must be held.
+func (rtm *RemoteTranscoderManager) storeRulesLocked(rules []TranscoderRule) error {
+	stored := make([]*common.DBTranscoderRule, len(rules))
+	for i, r := range rules {
+		stored[i] = &common.DBTranscoderRule{Name: r.Name, ManifestID: r.ManifestID, MinHeight: r.MinHeight, Tags: r.Tags}
+	}
+	return rtm.db.SetTranscoderRules(stored)
+}
+
+// SetRule adds rule, or replaces the rule with its name. Rules are matched in
+// the order they were added; a stream is routed by the first it matches, and
+// to any transcoder if none.
+func (rtm *RemoteTranscoderManager) SetRule(rule TranscoderRule) error {
+	if rule.Name == "" {
+		return errors.New("transcoder rule must be named")
+	}
+	if len(rule.Tags) == 0 {
+		return errors.New("transcoder rule must have tags")
+	}
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	rules := append([]TranscoderRule{}, rtm.rules...)
+	replaced := false
+	for i, r := range rules {
+		if r.Name == rule.Name {
+			rules[i] = rule
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		rules = append(rules, rule)
+	}
+	if err := rtm.storeRulesLocked(rules); err != nil {
+		return err
+	}
+	rtm.rules = rules
+	glog.Infof("Set transcoder rule name=%s manifestID=%s minHeight=%d tags=%v", rule.Name, rule.ManifestID, rule.MinHeight, rule.Tags)
+	return nil
+}
+
+// RemoveRule removes the rule named name
+func (rtm *RemoteTranscoderManager) RemoveRule(name string) error {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	for i, r := range rtm.rules {
+		if r.Name == name {
+			rules := append(append([]TranscoderRule{}, rtm.rules[:i]...), rtm.rules[i+1:]...)
+			if err := rtm.storeRulesLocked(rules); err != nil {
+				return err
+			}
+			rtm.rules = rules
+			glog.Infof("Removed transcoder rule name=%s", name)
+			return nil
+		}
+	}
+	return ErrTranscoderRuleNotFound
+}
+
+// Rules returns the rules, in the order they're matched
+func (rtm *RemoteTranscoderManager) Rules() []TranscoderRule {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	return append([]TranscoderRule{}, rtm.rules...)
+}
+
+// SetTags assigns the remote transcoder with id tags, for rules to route
+// streams to it by, in place of those it had; none untags it. The tags are
+// the operator's to assign rather than the transcoder's to claim, so they're
+// kept by ID, whether or not the transcoder is connected.
+func (rtm *RemoteTranscoderManager) SetTags(id string, tags []string) error {
+	if id == "" {
+		return errors.New("only transcoders with an ID can be tagged")
+	}
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	if err := rtm.db.SetTranscoderTags(id, tags); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		delete(rtm.tags, id)
+	} else {
+		rtm.tags[id] = append([]string{}, tags...)
+	}
+	glog.Infof("Set tags of transcoder id=%s tags=%v", id, tags)
+	return nil
+}
+
+// Tags returns the tags assigned to the remote transcoders, by ID
+func (rtm *RemoteTranscoderManager) Tags() map[string][]string {
+	rtm.RTmutex.Lock()
+	defer rtm.RTmutex.Unlock()
+
+	tags := make(map[string][]string, len(rtm.tags))
+	for id, t := range rtm.tags {
+		tags[id] = append([]string{}, t...)
+	}
+	return tags
+}
+
+// poolLocked returns the tags of the transcoders the segment md is to be
+// routed to; none for any transcoder. RTmutex must be held.
+func (rtm *RemoteTranscoderManager) poolLocked(md *SegTranscodingMetadata) []string {
+	for i := range rtm.rules {
+		if rtm.rules[i].matches(md) {
+			return rtm.rules[i].Tags
+		}
+	}
+	return nil
+}
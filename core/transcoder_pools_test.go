@@ -0,0 +1,106 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoderRules(t *testing.T) {
+	assert := assert.New(t)
+	m := NewRemoteTranscoderManager()
+
+	assert.NotNil(m.SetRule(TranscoderRule{Tags: []string{"a100"}}))
+	assert.NotNil(m.SetRule(TranscoderRule{Name: "4k"}))
+	assert.Equal(ErrTranscoderRuleNotFound, m.RemoveRule("4k"))
+
+	assert.Nil(m.SetRule(TranscoderRule{Name: "4k", MinHeight: 2160, Tags: []string{"a100"}}))
+	assert.Nil(m.SetRule(TranscoderRule{Name: "eu", ManifestID: "eu-", Tags: []string{"eu"}}))
+	assert.Nil(m.SetRule(TranscoderRule{Name: "4k", MinHeight: 2160, Tags: []string{"A100"}}))
+	rules := m.Rules()
+	assert.Len(rules, 2)
+	assert.Equal("4k", rules[0].Name)
+	assert.Equal([]string{"A100"}, rules[0].Tags)
+
+	// transcoders are tagged by the operator, by ID
+	assert.NotNil(m.SetTags("", []string{"a100"}))
+	assert.Nil(m.SetTags("t1", []string{"a100", "us"}))
+	assert.Nil(m.SetTags("t2", []string{"eu"}))
+	assert.Nil(m.SetTags("t3", []string{"eu"}))
+	assert.Nil(m.SetTags("t3", nil))
+	assert.Equal(map[string][]string{"t1": {"a100", "us"}, "t2": {"eu"}}, m.Tags())
+
+	managed := func(id string) *RemoteTranscoder {
+		tc := NewRemoteTranscoder(m, &StubTranscoderServer{manager: m}, RemoteTranscoderCapacity{Sessions: 1})
+		tc.id = id
+		m.liveTranscoders[tc.stream] = tc
+		return tc
+	}
+	a100 := managed("t1")
+	eu := managed("t2")
+	other := managed("t3")
+
+	// streams go to the pool of the first rule they match
+	uhd := &SegTranscodingMetadata{ManifestID: "eu-1", Profiles: []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9, {Resolution: "3840x2160"}}}
+	assert.Equal(a100, m.selectTranscoderFor(uhd))
+	assert.Nil(m.selectTranscoderFor(uhd))
+	hd := &SegTranscodingMetadata{ManifestID: "eu-1", Profiles: []ffmpeg.VideoProfile{ffmpeg.P720p30fps16x9}}
+	assert.Equal(eu, m.selectTranscoderFor(hd))
+	assert.Nil(m.selectTranscoderFor(hd))
+
+	// and to any transcoder if they match none
+	assert.Equal(other, m.selectTranscoderFor(&SegTranscodingMetadata{ManifestID: "us-1"}))
+
+	// once the rule is removed, the stream matches the next one
+	assert.Nil(m.RemoveRule("4k"))
+	assert.Len(m.Rules(), 1)
+	a100.load = 0
+	assert.Nil(m.selectTranscoderFor(uhd))
+
+	// transcoders without an ID are never in a pool
+	eu.load = 0
+	eu.id = ""
+	assert.Nil(m.selectTranscoderFor(hd))
+}
+
+func TestTranscoderPools_DB(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	m := NewRemoteTranscoderManager()
+	require.Nil(m.LoadPools(dbh))
+	assert.Empty(m.Rules())
+	assert.Empty(m.Tags())
+
+	require.Nil(m.SetRule(TranscoderRule{Name: "4k", MinHeight: 2160, Tags: []string{"a100"}}))
+	require.Nil(m.SetRule(TranscoderRule{Name: "eu", ManifestID: "eu-", Tags: []string{"eu"}}))
+	require.Nil(m.SetRule(TranscoderRule{Name: "us", ManifestID: "us-", Tags: []string{"us"}}))
+	require.Nil(m.RemoveRule("eu"))
+	require.Nil(m.SetTags("t1", []string{"a100"}))
+	require.Nil(m.SetTags("t2", []string{"us"}))
+	require.Nil(m.SetTags("t2", nil))
+
+	// Rules and tags outlive restarts, in order
+	m = NewRemoteTranscoderManager()
+	require.Nil(m.LoadPools(dbh))
+	assert.Equal([]TranscoderRule{
+		{Name: "4k", MinHeight: 2160, Tags: []string{"a100"}},
+		{Name: "us", ManifestID: "us-", Tags: []string{"us"}},
+	}, m.Rules())
+	assert.Equal(map[string][]string{"t1": {"a100"}}, m.Tags())
+
+	// Nothing is changed in memory that couldn't be stored
+	dbh.Close()
+	assert.NotNil(m.SetRule(TranscoderRule{Name: "eu", ManifestID: "eu-", Tags: []string{"eu"}}))
+	assert.NotNil(m.RemoveRule("4k"))
+	assert.NotNil(m.SetTags("t2", []string{"us"}))
+	assert.Len(m.Rules(), 2)
+	assert.Equal(map[string][]string{"t1": {"a100"}}, m.Tags())
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// TranscoderSessionIdleTimeout is how long the transcode session of a stream
+// is kept after its last segment
+var TranscoderSessionIdleTimeout = time.Minute
+
+// StreamTranscoder transcodes the segments of each stream on the same device,
+// one at a time, rather than wherever there's room for each segment
+type StreamTranscoder interface {
+	Transcoder
+	TranscodeStream(mid ManifestID, fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error)
+	// EndStream closes the session of the stream mid, as it ended
+	EndStream(mid ManifestID)
+}
+
+// transcodeSession is what a transcoder keeps for a stream between its
+// segments: the GPU they go to, their turn on it, and the lpms transcoder that
+// keeps the decoder and NVENC encoders of the stream open from one segment to
+// the next.
+type transcodeSession struct {
+	mid    ManifestID
+	device string
+	// turn has the segments of the stream transcoded one at a time
+	turn sync.Mutex
+	// opened on the first segment transcoded through it; guarded by turn
+	lpms *ffmpeg.Transcoder
+
+	// guarded by the sessions' mu
+	inUse    int
+	lastUsed time.Time
+}
+
+// transcoder returns the lpms transcoder of s, opened if it has none. The
+// caller must have its turn on s.
+func (s *transcodeSession) transcoder() *ffmpeg.Transcoder {
+	if s.lpms == nil {
+		s.lpms = ffmpeg.NewTranscoder()
+	}
+	return s.lpms
+}
+
+// close frees the decoder and encoders kept for the stream
+func (s *transcodeSession) close() {
+	s.turn.Lock()
+	defer s.turn.Unlock()
+	if s.lpms != nil {
+		s.lpms.StopTranscoder()
+		s.lpms = nil
+	}
+}
+
+// transcodeSessions are the sessions of the streams of a transcoder
+type transcodeSessions struct {
+	mu       sync.Mutex
+	sessions map[ManifestID]*transcodeSession
+	// devices new sessions are spread over; none if on the CPU
+	devices []string
+}
+
+func newTranscodeSessions(devices []string) *transcodeSessions {
+	return &transcodeSessions{sessions: make(map[ManifestID]*transcodeSession), devices: devices}
+}
+
+// acquire returns the session of the stream mid, opened if it has none, once
+// it's the segment's turn on it. The session must be released once the
+// segment is transcoded.
+func (ts *transcodeSessions) acquire(mid ManifestID) *transcodeSession {
+	ts.mu.Lock()
+	s, reused := ts.sessions[mid]
+	if !reused {
+		s = &transcodeSession{mid: mid, device: ts.leastUsedDeviceLocked()}
+		ts.sessions[mid] = s
+		glog.V(common.DEBUG).Infof("Opened transcode session manifestID=%s device=%s", mid, s.device)
+		if monitor.Enabled {
+			monitor.TranscodeSessions(len(ts.sessions))
+		}
+	}
+	s.inUse++
+	ts.mu.Unlock()
+	if monitor.Enabled {
+		monitor.TranscodeSessionSegment(reused)
+	}
+
+	s.turn.Lock()
+	return s
+}
+
+// release gives the next segment of the stream its turn on s, and closes s
+// once it's idle for TranscoderSessionIdleTimeout
+func (ts *transcodeSessions) release(s *transcodeSession) {
+	s.turn.Unlock()
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	s.inUse--
+	s.lastUsed = time.Now()
+	if s.inUse == 0 {
+		time.AfterFunc(TranscoderSessionIdleTimeout, func() { ts.expire(s) })
+	}
+}
+
+func (ts *transcodeSessions) expire(s *transcodeSession) {
+	ts.mu.Lock()
+	// s may have been ended, and the stream given a new session, since the
+	// timer was set
+	if ts.sessions[s.mid] != s || s.inUse > 0 || time.Since(s.lastUsed) < TranscoderSessionIdleTimeout {
+		ts.mu.Unlock()
+		return
+	}
+	ts.removeLocked(s)
+	ts.mu.Unlock()
+	s.close()
+}
+
+// end closes the session of the stream mid, if it has one that's idle
+func (ts *transcodeSessions) end(mid ManifestID) {
+	ts.mu.Lock()
+	s, ok := ts.sessions[mid]
+	if !ok || s.inUse > 0 {
+		ts.mu.Unlock()
+		return
+	}
+	ts.removeLocked(s)
+	ts.mu.Unlock()
+	s.close()
+}
+
+// removeLocked takes s out of the sessions; it's closed by the caller once
+// the lock is released, as closing encoders takes a while
+func (ts *transcodeSessions) removeLocked(s *transcodeSession) {
+	delete(ts.sessions, s.mid)
+	glog.V(common.DEBUG).Infof("Closed transcode session manifestID=%s", s.mid)
+	if monitor.Enabled {
+		monitor.TranscodeSessions(len(ts.sessions))
+	}
+}
+
+// leastUsedDeviceLocked returns the device with the fewest sessions
+func (ts *transcodeSessions) leastUsedDeviceLocked() string {
+	if len(ts.devices) == 0 {
+		return ""
+	}
+	counts := make(map[string]int, len(ts.devices))
+	for _, s := range ts.sessions {
+		counts[s.device]++
+	}
+	device := ts.devices[0]
+	for _, d := range ts.devices[1:] {
+		if counts[d] < counts[device] {
+			device = d
+		}
+	}
+	return device
+}
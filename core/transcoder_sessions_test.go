@@ -0,0 +1,86 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranscodeSessions(t *testing.T) {
+	assert := assert.New(t)
+	defer func(timeout time.Duration) { TranscoderSessionIdleTimeout = timeout }(TranscoderSessionIdleTimeout)
+	TranscoderSessionIdleTimeout = 50 * time.Millisecond
+	ts := newTranscodeSessions([]string{"0", "1"})
+
+	// streams are spread over the devices, and keep theirs between segments
+	s1 := ts.acquire("stream1")
+	s2 := ts.acquire("stream2")
+	assert.NotEqual(s1.device, s2.device)
+	ts.release(s1)
+	assert.Equal(s1, ts.acquire("stream1"))
+	ts.release(s1)
+
+	// the segments of a stream are transcoded in turn
+	turn := make(chan struct{})
+	go func() {
+		s := ts.acquire("stream2")
+		close(turn)
+		ts.release(s)
+	}()
+	select {
+	case <-turn:
+		t.Error("segment transcoded while another of the stream was")
+	case <-time.After(10 * time.Millisecond):
+	}
+	ts.release(s2)
+	<-turn
+
+	// sessions are closed once idle
+	time.Sleep(100 * time.Millisecond)
+	ts.mu.Lock()
+	assert.Empty(ts.sessions)
+	ts.mu.Unlock()
+	// compared by pointer, as the old session is being closed
+	assert.True(s1 != ts.acquire("stream1"))
+
+	// unless in use, until the stream ends
+	ts.end("stream1")
+	ts.mu.Lock()
+	assert.Len(ts.sessions, 1)
+	ts.mu.Unlock()
+}
+
+func TestTranscodeSessions_End(t *testing.T) {
+	ts := newTranscodeSessions(nil)
+	s := ts.acquire("stream")
+	assert.Equal(t, "", s.device)
+	tc := s.transcoder()
+	assert.Equal(t, tc, s.transcoder())
+	ts.release(s)
+	ts.end("stream")
+	assert.Empty(t, ts.sessions)
+	// the decoder and encoders kept for the stream are freed
+	assert.Nil(t, s.lpms)
+	assert.Equal(t, ffmpeg.ErrTranscoderStp, tc.Transcode(&ffmpeg.TranscodeOptionsIn{}, nil))
+}
+
+func TestTranscodeSessions_StaleExpiry(t *testing.T) {
+	assert := assert.New(t)
+	defer func(timeout time.Duration) { TranscoderSessionIdleTimeout = timeout }(TranscoderSessionIdleTimeout)
+	TranscoderSessionIdleTimeout = 50 * time.Millisecond
+	ts := newTranscodeSessions(nil)
+
+	// the stream ends and comes back before the timer of its old session
+	// fires; the timer leaves the new session be
+	s := ts.acquire("stream")
+	ts.release(s)
+	ts.end("stream")
+	s2 := ts.acquire("stream")
+	assert.True(s != s2)
+	time.Sleep(100 * time.Millisecond)
+	ts.mu.Lock()
+	assert.True(s2 == ts.sessions["stream"])
+	ts.mu.Unlock()
+}
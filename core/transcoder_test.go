@@ -3,6 +3,7 @@ package core
 import (
 	"io/ioutil"
 	"os"
+	"runtime"
 	"testing"
 
 	"github.com/livepeer/lpms/ffmpeg"
@@ -37,7 +38,7 @@ func TestNvidiaTranscoder(t *testing.T) {
 	ffmpeg.InitFFmpeg()
 
 	// test device selection
-	nv, ok := tc.(*NvidiaTranscoder)
+	nv, ok := tc.(*HardwareTranscoder)
 	if !ok || "456" != nv.getDevice() || "123" != nv.getDevice() ||
 		"456" != nv.getDevice() || "123" != nv.getDevice() {
 		t.Error("Error when getting devices")
@@ -72,3 +73,112 @@ func TestNvidiaTranscoder(t *testing.T) {
 		t.Errorf("Wrong data %v", len(res[1]))
 	}
 }
+
+func TestVAAPITranscoder(t *testing.T) {
+	tc := NewVAAPITranscoder("renderD128, /dev/dri/renderD129,,", "")
+	hw, ok := tc.(*HardwareTranscoder)
+	if !ok || hw.Accel() != AccelVAAPI {
+		t.Fatal("Expected a VAAPI transcoder")
+	}
+	devices := hw.Devices()
+	if len(devices) != 2 || devices[0] != "/dev/dri/renderD128" || devices[1] != "/dev/dri/renderD129" {
+		t.Error("Unexpected devices ", devices)
+	}
+	if hw.getDevice() != devices[1] || hw.getDevice() != devices[0] {
+		t.Error("Error when getting devices")
+	}
+
+	// render nodes that aren't present are reported
+	tc = NewVAAPITranscoder("/nonexistent/renderD128", "")
+	hw = tc.(*HardwareTranscoder)
+	if err := hw.CheckDevices(); err == nil {
+		t.Error("Expected missing render node to be reported")
+	}
+	if available := hw.AvailableDevices(); len(available) != 0 {
+		t.Error("Expected no available devices, got ", available)
+	}
+
+	if len(VAAPIDevices("")) != 0 {
+		t.Error("Expected no devices")
+	}
+}
+
+func TestVideoToolboxTranscoder(t *testing.T) {
+	tc := NewVideoToolboxTranscoder("")
+	hw, ok := tc.(*HardwareTranscoder)
+	if !ok || hw.Accel() != AccelVideoToolbox {
+		t.Fatal("Expected a VideoToolbox transcoder")
+	}
+	if len(hw.Devices()) != 1 {
+		t.Error("Expected the media engine as the only device, got ", hw.Devices())
+	}
+	err := hw.CheckDevices()
+	available := hw.AvailableDevices()
+	if runtime.GOOS == "darwin" {
+		if err != nil || len(available) != 1 {
+			t.Error("Expected VideoToolbox to be available ", err)
+		}
+	} else if err == nil || len(available) != 0 {
+		t.Error("Expected VideoToolbox to be unavailable on ", runtime.GOOS)
+	}
+}
+
+func TestHardwareTranscoder_Accel(t *testing.T) {
+	for accel, name := range map[ffmpeg.Acceleration]string{
+		ffmpeg.Nvidia:       AccelNvidia,
+		ffmpeg.VAAPI:        AccelVAAPI,
+		ffmpeg.VideoToolbox: AccelVideoToolbox,
+		ffmpeg.Software:     "",
+	} {
+		if got := newHardwareTranscoder(accel, nil, "").Accel(); got != name {
+			t.Errorf("Expected %q for acceleration %v, got %q", name, accel, got)
+		}
+	}
+}
+
+func TestHardwareTranscoder_Probe(t *testing.T) {
+	ffmpeg.InitFFmpeg()
+
+	if !ffmpeg.HasEncoder(ffmpeg.Software) {
+		t.Error("Expected FFmpeg to be built with libx264")
+	}
+	// VideoToolbox has no device to open; frames are decoded in software
+	if err := ffmpeg.CheckDevice(ffmpeg.VideoToolbox, ""); err != nil {
+		t.Error("Unexpected error ", err)
+	}
+	if err := ffmpeg.CheckDevice(ffmpeg.VAAPI, "/nonexistent/renderD128"); err == nil {
+		t.Error("Expected missing render node to be reported")
+	}
+
+	// Accelerations FFmpeg was built without aren't advertised
+	hw := newHardwareTranscoder(ffmpeg.VideoToolbox, []string{videoToolboxDevice}, "")
+	if !ffmpeg.HasEncoder(ffmpeg.VideoToolbox) && len(hw.AvailableDevices()) != 0 {
+		t.Error("Expected VideoToolbox to be unavailable without its encoder")
+	}
+}
+
+func TestAvailableCodecs(t *testing.T) {
+	ffmpeg.InitFFmpeg()
+
+	// libx264 encodes the codec of segments on the CPU
+	codecs := AvailableCodecs(NewLocalTranscoder(""))
+	if len(codecs) != 1 || codecs[0] != segmentCodec {
+		t.Error("Unexpected codecs ", codecs)
+	}
+	codecs[0] = "vp9"
+	if TranscoderCodecs[0] != segmentCodec {
+		t.Error("Expected the codecs to be copied")
+	}
+
+	// Accelerations FFmpeg was built without encode nothing
+	hw := newHardwareTranscoder(ffmpeg.VideoToolbox, []string{videoToolboxDevice}, "")
+	if !ffmpeg.HasEncoder(ffmpeg.VideoToolbox) && len(AvailableCodecs(hw)) != 0 {
+		t.Error("Expected no codecs without the VideoToolbox encoder")
+	}
+
+	// GPUs whose device is gone aren't transcoded on
+	hw = newHardwareTranscoder(ffmpeg.Nvidia, []string{"999"}, "")
+	if available := hw.AvailableDevices(); len(available) != 0 {
+		t.Error("Expected no available devices, got ", available)
+	}
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+var ErrTranscoderTokenNotFound = errors.New("transcoder token not found")
+
+// TranscoderTokenGrace is how long a rotated token is still accepted for,
+// for the results of the segments sent before the transcoder got the new one
+var TranscoderTokenGrace = time.Minute
+
+// TranscoderToken is a token issued for a remote transcoder to authenticate
+// to the orchestrator with instead of the shared -orchSecret, so it can be
+// revoked on its own
+type TranscoderToken struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ExpiresAt is zero if the token doesn't expire
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	Revoked   bool      `json:"revoked"`
+}
+
+func (t *TranscoderToken) valid(now time.Time) bool {
+	return !t.Revoked && (t.ExpiresAt.IsZero() || now.Before(t.ExpiresAt))
+}
+
+// TranscoderTokens are the tokens issued for the remote transcoders of an
+// orchestrator, kept in the DB so they outlive restarts
+type TranscoderTokens struct {
+	// TTL is how long tokens are valid for once issued; 0 if they don't
+	// expire. Tokens of connected transcoders are rotated before they do.
+	TTL time.Duration
+
+	db     *common.DB
+	mu     sync.RWMutex
+	tokens map[string]*TranscoderToken
+	hashes map[string][]byte
+}
+
+// NewTranscoderTokens loads the tokens issued from db, which may be nil for
+// the tokens to be kept in memory only
+func NewTranscoderTokens(db *common.DB, ttl time.Duration) (*TranscoderTokens, error) {
+	t := &TranscoderTokens{
+		TTL:    ttl,
+		db:     db,
+		tokens: make(map[string]*TranscoderToken),
+		hashes: make(map[string][]byte),
+	}
+	stored, err := db.TranscoderTokens()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range stored {
+		token := &TranscoderToken{ID: s.ID, Label: s.Label, CreatedAt: time.Unix(s.CreatedAt, 0), Revoked: s.Revoked}
+		if s.ExpiresAt != 0 {
+			token.ExpiresAt = time.Unix(s.ExpiresAt, 0)
+		}
+		t.tokens[s.ID] = token
+		t.hashes[s.ID] = s.Hash
+	}
+	return t, nil
+}
+
+// Issue creates a token for a transcoder, named label. The secret returned
+// is what the transcoder authenticates with; only its hash is kept.
+func (t *TranscoderTokens) Issue(label string) (*TranscoderToken, string, error) {
+	id, err := randHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := randHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := id + "." + key
+	hash := sha256.Sum256([]byte(secret))
+
+	now := time.Now()
+	token := &TranscoderToken{ID: id, Label: label, CreatedAt: now}
+	stored := &common.DBTranscoderToken{ID: id, Label: label, Hash: hash[:], CreatedAt: now.Unix()}
+	if t.TTL > 0 {
+		token.ExpiresAt = now.Add(t.TTL)
+		stored.ExpiresAt = token.ExpiresAt.Unix()
+	}
+	if err := t.db.InsertTranscoderToken(stored); err != nil {
+		return nil, "", err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens[id] = token
+	t.hashes[id] = hash[:]
+	c := *token
+	return &c, secret, nil
+}
+
+// List returns the tokens issued, oldest first
+func (t *TranscoderTokens) List() []*TranscoderToken {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	tokens := make([]*TranscoderToken, 0, len(t.tokens))
+	for _, token := range t.tokens {
+		c := *token
+		tokens = append(tokens, &c)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens
+}
+
+// Get returns the token with id
+func (t *TranscoderTokens) Get(id string) (*TranscoderToken, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	token, ok := t.tokens[id]
+	if !ok {
+		return nil, false
+	}
+	c := *token
+	return &c, true
+}
+
+// Revoke stops the token with id being accepted
+func (t *TranscoderTokens) Revoke(id string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	token, ok := t.tokens[id]
+	if !ok {
+		return ErrTranscoderTokenNotFound
+	}
+	if err := t.db.RevokeTranscoderToken(id); err != nil {
+		return err
+	}
+	token.Revoked = true
+	return nil
+}
+
+// Rotate issues a token to replace the one with id, under the same label.
+// The old token is accepted for TranscoderTokenGrace more.
+func (t *TranscoderTokens) Rotate(id string) (*TranscoderToken, string, error) {
+	old, ok := t.Get(id)
+	if !ok {
+		return nil, "", ErrTranscoderTokenNotFound
+	}
+	if !old.valid(time.Now()) {
+		return nil, "", errors.New("transcoder token is no longer valid")
+	}
+	token, secret, err := t.Issue(old.Label)
+	if err != nil {
+		return nil, "", err
+	}
+
+	expiresAt := time.Now().Add(TranscoderTokenGrace)
+	if !old.ExpiresAt.IsZero() && old.ExpiresAt.Before(expiresAt) {
+		expiresAt = old.ExpiresAt
+	}
+	if err := t.db.ExpireTranscoderToken(id, expiresAt); err != nil {
+		return nil, "", err
+	}
+	t.mu.Lock()
+	t.tokens[id].ExpiresAt = expiresAt
+	t.mu.Unlock()
+	glog.Infof("Rotated transcoder token id=%s label=%s to id=%s", id, old.Label, token.ID)
+	return token, secret, nil
+}
+
+// Authenticate returns the token secret was issued as, if it's still valid
+func (t *TranscoderTokens) Authenticate(secret string) (*TranscoderToken, bool) {
+	id := strings.SplitN(secret, ".", 2)[0]
+	hash := sha256.Sum256([]byte(secret))
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	token, ok := t.tokens[id]
+	if !ok || subtle.ConstantTimeCompare(hash[:], t.hashes[id]) != 1 || !token.valid(time.Now()) {
+		return nil, false
+	}
+	c := *token
+	return &c, true
+}
+
+func randHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoderTokens(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	tokens, err := NewTranscoderTokens(dbh, 0)
+	require.Nil(err)
+	assert.Empty(tokens.List())
+
+	t1, secret1, err := tokens.Issue("gpu1")
+	require.Nil(err)
+	_, secret2, err := tokens.Issue("gpu2")
+	require.Nil(err)
+	assert.NotEqual(secret1, secret2)
+	assert.True(t1.ExpiresAt.IsZero())
+
+	auth, ok := tokens.Authenticate(secret1)
+	assert.True(ok)
+	assert.Equal(t1.ID, auth.ID)
+	_, ok = tokens.Authenticate(t1.ID + ".wrong")
+	assert.False(ok)
+	_, ok = tokens.Authenticate("")
+	assert.False(ok)
+
+	// Revoking one token leaves the others valid
+	require.Nil(tokens.Revoke(t1.ID))
+	_, ok = tokens.Authenticate(secret1)
+	assert.False(ok)
+	_, ok = tokens.Authenticate(secret2)
+	assert.True(ok)
+	assert.Equal(ErrTranscoderTokenNotFound, tokens.Revoke("nonexistent"))
+
+	// Tokens outlive restarts
+	tokens, err = NewTranscoderTokens(dbh, 0)
+	require.Nil(err)
+	listed := tokens.List()
+	require.Len(listed, 2)
+	assert.True(listed[0].Revoked)
+	assert.Equal("gpu2", listed[1].Label)
+	_, ok = tokens.Authenticate(secret1)
+	assert.False(ok)
+	_, ok = tokens.Authenticate(secret2)
+	assert.True(ok)
+
+	// Revoked tokens aren't rotated
+	_, _, err = tokens.Rotate(t1.ID)
+	assert.NotNil(err)
+}
+
+func TestTranscoderTokens_Rotate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tokens, err := NewTranscoderTokens(nil, time.Hour)
+	require.Nil(err)
+	old, oldSecret, err := tokens.Issue("gpu1")
+	require.Nil(err)
+	assert.WithinDuration(time.Now().Add(time.Hour), old.ExpiresAt, time.Minute)
+
+	rotated, secret, err := tokens.Rotate(old.ID)
+	require.Nil(err)
+	assert.Equal("gpu1", rotated.Label)
+	assert.NotEqual(old.ID, rotated.ID)
+
+	// Both are accepted until the grace period of the old one is over
+	_, ok := tokens.Authenticate(secret)
+	assert.True(ok)
+	_, ok = tokens.Authenticate(oldSecret)
+	assert.True(ok)
+	o, _ := tokens.Get(old.ID)
+	assert.WithinDuration(time.Now().Add(TranscoderTokenGrace), o.ExpiresAt, time.Second)
+
+	defer func(grace time.Duration) { TranscoderTokenGrace = grace }(TranscoderTokenGrace)
+	TranscoderTokenGrace = -time.Second
+	expired, expiredSecret, err := tokens.Rotate(rotated.ID)
+	require.Nil(err)
+	_, ok = tokens.Authenticate(secret)
+	assert.False(ok)
+	_, ok = tokens.Authenticate(expiredSecret)
+	assert.True(ok)
+	assert.Equal("gpu1", expired.Label)
+}
+
+func TestWatchTranscoderToken(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(interval time.Duration) { transcoderTokenCheckInterval = interval }(transcoderTokenCheckInterval)
+	transcoderTokenCheckInterval = time.Millisecond
+
+	n, _ := NewLivepeerNode(nil, "", nil)
+	n.TranscoderManager = NewRemoteTranscoderManager()
+	tokens, err := NewTranscoderTokens(nil, time.Hour)
+	require.Nil(err)
+	n.TranscoderTokens = tokens
+	token, _, err := tokens.Issue("gpu1")
+	require.Nil(err)
+
+	// Rotated once half of the TTL is past
+	tokens.TTL = 3 * time.Hour
+	strm := &StubTranscoderServer{manager: n.TranscoderManager}
+	wg := newWg(1)
+	go func() { n.serveTranscoder(strm, "", RemoteTranscoderCapacity{Sessions: 1}, token); wg.Done() }()
+	time.Sleep(20 * time.Millisecond)
+	assert.NotEmpty(strm.LastToken())
+	rotated, ok := tokens.Authenticate(strm.LastToken())
+	require.True(ok)
+
+	// Disconnected once revoked
+	require.Nil(tokens.Revoke(rotated.ID))
+	assert.True(wgWait(wg))
+	assert.Empty(n.TranscoderManager.liveTranscoders)
+}
@@ -9,7 +9,7 @@ import (
 	lpTypes "github.com/livepeer/go-livepeer/eth/types"
 	"github.com/livepeer/go-livepeer/net"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 var cacheRefreshInterval = 1 * time.Hour
@@ -2,6 +2,8 @@ package discovery
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
 	"net/url"
@@ -14,37 +16,58 @@ import (
 	"github.com/livepeer/go-livepeer/net"
 	"github.com/livepeer/go-livepeer/server"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 const getOrchestratorsTimeoutLoop = 1 * time.Hour
 
 var serverGetOrchInfo = server.GetOrchestratorInfo
 
+var errPriceExceeded = errors.New("orchestrator price exceeds maxPrice")
+
 type orchestratorPool struct {
-	uris  []*url.URL
-	bcast server.Broadcaster
+	// mu protects uris, entries and weighted, which SetAddresses replaces
+	mu      sync.RWMutex
+	uris    []*url.URL
+	entries map[string]*orchestratorEntry
+	// weighted if any entry has a weight other than the default
+	weighted bool
+	bcast    server.Broadcaster
 }
 
 var perm = func(len int) []int { return rand.Perm(len) }
 
+// NewOrchestratorPool creates a pool of the orchestrators at addresses, each
+// of which may have options; see orchestratorEntry
 func NewOrchestratorPool(node *core.LivepeerNode, addresses []string) *orchestratorPool {
+	pool := &orchestratorPool{bcast: core.NewBroadcaster(node)}
+	uris, entries, weighted := parseOrchestratorEntries(addresses, func(addr string, err error) {
+		glog.Errorf("Could not parse orchestrator %s: %v", addr, err)
+	})
+	if len(uris) <= 0 {
+		glog.Error("Could not parse orchAddresses given - no URIs returned ")
+	}
+	pool.uris, pool.entries, pool.weighted = uris, entries, weighted
+	return pool
+}
+
+// parseOrchestratorEntries parses addresses into the shuffled URIs of the
+// pool and their entries. Addresses that can't be parsed are passed to
+// invalid and skipped.
+func parseOrchestratorEntries(addresses []string, invalid func(addr string, err error)) ([]*url.URL, map[string]*orchestratorEntry, bool) {
 	var uris []*url.URL
+	entries := make(map[string]*orchestratorEntry)
+	weighted := false
 
 	for _, addr := range addresses {
-		if !strings.HasPrefix(addr, "http") {
-			addr = "https://" + addr
-		}
-		uri, err := url.ParseRequestURI(addr)
+		e, err := parseOrchestratorEntry(addr)
 		if err != nil {
-			glog.Error("Could not parse orchestrator URI: ", err)
+			invalid(addr, err)
 			continue
 		}
-		uris = append(uris, uri)
-	}
-
-	if len(uris) <= 0 {
-		glog.Error("Could not parse orchAddresses given - no URIs returned ")
+		uris = append(uris, e.uri)
+		entries[e.uri.String()] = e
+		weighted = weighted || e.weight != 1
 	}
 
 	var randomizedUris []*url.URL
@@ -52,9 +75,27 @@ func NewOrchestratorPool(node *core.LivepeerNode, addresses []string) *orchestra
 		uri := uris[i]
 		randomizedUris = append(randomizedUris, uri)
 	}
+	return randomizedUris, entries, weighted
+}
 
-	bcast := core.NewBroadcaster(node)
-	return &orchestratorPool{bcast: bcast, uris: randomizedUris}
+// SetAddresses replaces the orchestrators of the pool, taking addresses as
+// NewOrchestratorPool does. The pool is left as it was if any is invalid.
+// Sessions already set up keep using their orchestrators.
+func (o *orchestratorPool) SetAddresses(addresses []string) error {
+	var errs []string
+	uris, entries, weighted := parseOrchestratorEntries(addresses, func(addr string, err error) {
+		errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid orchestrators %s", strings.Join(errs, "; "))
+	}
+	if len(uris) == 0 {
+		return errors.New("no orchestrators given")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.uris, o.entries, o.weighted = uris, entries, weighted
+	return nil
 }
 
 func NewOnchainOrchestratorPool(node *core.LivepeerNode) *orchestratorPool {
@@ -79,57 +120,80 @@ func NewOnchainOrchestratorPool(node *core.LivepeerNode) *orchestratorPool {
 }
 
 func (o *orchestratorPool) GetURLs() []*url.URL {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 	return o.uris
 }
 
 func (o *orchestratorPool) GetOrchestrators(numOrchestrators int) ([]*net.OrchestratorInfo, error) {
-	numAvailableOrchs := len(o.uris)
+	o.mu.RLock()
+	uris, entries, weighted := o.uris, o.entries, o.weighted
+	o.mu.RUnlock()
+
+	numAvailableOrchs := len(uris)
 	numOrchestrators = int(math.Min(float64(numAvailableOrchs), float64(numOrchestrators)))
 	ctx, cancel := context.WithTimeout(context.Background(), getOrchestratorsTimeoutLoop)
 	orchInfos := []*net.OrchestratorInfo{}
-	orchChan := make(chan struct{}, len(o.uris))
+	weights := []float64{}
+	orchChan := make(chan struct{}, len(uris))
 	numResp := 0
 	numSuccessResp := 0
 	respLock := sync.Mutex{}
 
 	getOrchInfo := func(uri *url.URL) {
 		info, err := serverGetOrchInfo(ctx, o.bcast, uri)
+		e := entries[uri.String()]
+		if err == nil && e != nil && !e.acceptsPrice(info) {
+			glog.Infof("Not using orchestrator %v; price %v wei per segment is above maxPrice %v", e, price(info).FloatString(3), e.maxPrice.FloatString(3))
+			err = errPriceExceeded
+		}
 		respLock.Lock()
 		defer respLock.Unlock()
 		numResp++
 		if err == nil {
 			orchInfos = append(orchInfos, info)
+			weight := 1.0
+			if e != nil {
+				weight = e.weight
+			}
+			weights = append(weights, weight)
 			numSuccessResp++
 		} else if monitor.Enabled {
 			monitor.LogDiscoveryError(err.Error())
 		}
-		if numSuccessResp >= numOrchestrators || numResp >= len(o.uris) {
+		// Weighted pools wait on all orchestrators so that the slower ones
+		// still get picked in line with their weights
+		if (!weighted && numSuccessResp >= numOrchestrators) || numResp >= len(uris) {
 			orchChan <- struct{}{}
 		}
 	}
 
-	for _, uri := range o.uris {
+	pick := func() []*net.OrchestratorInfo {
+		respLock.Lock()
+		defer respLock.Unlock()
+		if len(orchInfos) < numOrchestrators {
+			numOrchestrators = len(orchInfos)
+		}
+		if !weighted {
+			return orchInfos[:numOrchestrators]
+		}
+		// The broadcaster uses the last orchestrators first
+		ordered := weightedOrder(orchInfos, weights)
+		return ordered[len(ordered)-numOrchestrators:]
+	}
+
+	for _, uri := range uris {
 		go getOrchInfo(uri)
 	}
 
 	select {
 	case <-ctx.Done():
-		respLock.Lock()
-		if len(orchInfos) < numOrchestrators {
-			numOrchestrators = len(orchInfos)
-		}
-		returnOrchs := orchInfos[:numOrchestrators]
-		respLock.Unlock()
+		returnOrchs := pick()
 		glog.Info("Done fetching orch info for orchestrators, context timeout: ", returnOrchs)
 		cancel()
 		return returnOrchs, nil
 	case <-orchChan:
-		respLock.Lock()
-		if len(orchInfos) < numOrchestrators {
-			numOrchestrators = len(orchInfos)
-		}
-		returnOrchs := orchInfos[:numOrchestrators]
-		respLock.Unlock()
+		returnOrchs := pick()
 		glog.Info("Done fetching orch info for orchestrators, numResponses fetched: ", returnOrchs)
 		cancel()
 		return returnOrchs, nil
@@ -137,5 +201,7 @@ func (o *orchestratorPool) GetOrchestrators(numOrchestrators int) ([]*net.Orches
 }
 
 func (o *orchestratorPool) Size() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
 	return len(o.uris)
 }
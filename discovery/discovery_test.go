@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"context"
+	"math/big"
 	"math/rand"
 	"net/url"
 	"runtime"
@@ -222,3 +223,114 @@ func TestNewOrchestratorPoolCache_GivenListOfOrchs_CreatesPoolCacheCorrectly(t *
 		assert.Equal(uri.String(), expected[i])
 	}
 }
+
+func TestParseOrchestratorEntry(t *testing.T) {
+	assert := assert.New(t)
+
+	e, err := parseOrchestratorEntry("127.0.0.1:8936")
+	assert.Nil(err)
+	assert.Equal("https://127.0.0.1:8936", e.uri.String())
+	assert.Equal("127.0.0.1:8936", e.label)
+	assert.Equal(1.0, e.weight)
+	assert.Nil(e.maxPrice)
+
+	e, err = parseOrchestratorEntry("http://orch.example.com:8935?label=nyc&region=us-east&weight=2.5&maxPrice=1000")
+	assert.Nil(err)
+	assert.Equal("http://orch.example.com:8935", e.uri.String())
+	assert.Equal("nyc", e.label)
+	assert.Equal("us-east", e.region)
+	assert.Equal(2.5, e.weight)
+	assert.Equal("1000", e.maxPrice.RatString())
+	assert.Equal("nyc (us-east, http://orch.example.com:8935)", e.String())
+
+	for _, addr := range []string{
+		"127.0.0.1:8936?weight=0",
+		"127.0.0.1:8936?weight=-1",
+		"127.0.0.1:8936?weight=heavy",
+		"127.0.0.1:8936?maxPrice=-1",
+		"127.0.0.1:8936?maxPrice=cheap",
+		"127.0.0.1:8936?colour=blue",
+	} {
+		_, err := parseOrchestratorEntry(addr)
+		assert.NotNil(err, addr)
+	}
+
+	// Bad entries are left out of the pool
+	pool := NewOrchestratorPool(nil, []string{"127.0.0.1:8936?weight=0", "127.0.0.1:8937"})
+	assert.Equal(1, pool.Size())
+	assert.False(pool.weighted)
+}
+
+func TestGetOrchestrators_MaxPrice(t *testing.T) {
+	defer func(f func(context.Context, server.Broadcaster, *url.URL) (*net.OrchestratorInfo, error)) {
+		serverGetOrchInfo = f
+	}(serverGetOrchInfo)
+	// Half of the face value; 2^255 / 2^256
+	winProb := new(big.Int).Lsh(big.NewInt(1), 255).Bytes()
+	prices := map[string]int64{"127.0.0.1:8936": 1000, "127.0.0.1:8937": 3000}
+	serverGetOrchInfo = func(ctx context.Context, bcast server.Broadcaster, uri *url.URL) (*net.OrchestratorInfo, error) {
+		info := &net.OrchestratorInfo{Transcoder: uri.Host}
+		if faceValue, ok := prices[uri.Host]; ok {
+			info.TicketParams = &net.TicketParams{FaceValue: big.NewInt(faceValue).Bytes(), WinProb: winProb}
+		}
+		return info, nil
+	}
+
+	assert := assert.New(t)
+	pool := NewOrchestratorPool(nil, []string{
+		"127.0.0.1:8936?maxPrice=500",
+		"127.0.0.1:8937?maxPrice=1499",
+		"127.0.0.1:8938?maxPrice=0",
+	})
+	infos, err := pool.GetOrchestrators(3)
+	assert.Nil(err)
+	var hosts []string
+	for _, info := range infos {
+		hosts = append(hosts, info.Transcoder)
+	}
+	assert.Len(hosts, 2)
+	assert.Contains(hosts, "127.0.0.1:8936")
+	assert.Contains(hosts, "127.0.0.1:8938")
+}
+
+func TestWeightedOrder(t *testing.T) {
+	defer func(f func() float64) { randFloat = f }(randFloat)
+	assert := assert.New(t)
+
+	infos := []*net.OrchestratorInfo{{Transcoder: "a"}, {Transcoder: "b"}, {Transcoder: "c"}}
+	randFloat = func() float64 { return 0.5 }
+	// With the same random number, the heaviest gets the highest key and goes last
+	ordered := weightedOrder(infos, []float64{4, 1, 2})
+	assert.Equal("b", ordered[0].Transcoder)
+	assert.Equal("c", ordered[1].Transcoder)
+	assert.Equal("a", ordered[2].Transcoder)
+
+	// Equal weights keep the order of the random numbers
+	rands := []float64{0.9, 0.1, 0.5}
+	randFloat = func() float64 { r := rands[0]; rands = rands[1:]; return r }
+	ordered = weightedOrder(infos, []float64{1, 1, 1})
+	assert.Equal("b", ordered[0].Transcoder)
+	assert.Equal("c", ordered[1].Transcoder)
+	assert.Equal("a", ordered[2].Transcoder)
+}
+
+func TestSetAddresses(t *testing.T) {
+	assert := assert.New(t)
+	pool := NewOrchestratorPool(nil, []string{"127.0.0.1:8936"})
+
+	assert.Nil(pool.SetAddresses([]string{"127.0.0.1:8937?weight=2", "127.0.0.1:8938"}))
+	assert.Equal(2, pool.Size())
+	assert.True(pool.weighted)
+	var hosts []string
+	for _, uri := range pool.GetURLs() {
+		hosts = append(hosts, uri.Host)
+	}
+	assert.ElementsMatch([]string{"127.0.0.1:8937", "127.0.0.1:8938"}, hosts)
+
+	// The pool is left as it was on errors
+	err := pool.SetAddresses([]string{"127.0.0.1:8939", "127.0.0.1:8940?weight=0"})
+	assert.Contains(err.Error(), "127.0.0.1:8940?weight=0")
+	assert.NotNil(pool.SetAddresses(nil))
+	assert.Equal(2, pool.Size())
+	assert.True(pool.weighted)
+}
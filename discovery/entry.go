@@ -0,0 +1,118 @@
+package discovery
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/go-livepeer/pm"
+)
+
+// orchestratorEntry is an orchestrator given to the broadcaster with
+// -orchAddr, along with the options of the entry:
+//
+//	orch.example.com:8935?label=nyc&region=us-east&weight=2&maxPrice=1000
+type orchestratorEntry struct {
+	uri   *url.URL
+	label string
+	// region the orchestrator is in; informational, shown with the label
+	region string
+	// weight of the orchestrator relative to the others; orchestrators with
+	// higher weights are more likely to be used first
+	weight float64
+	// maxPrice in wei per segment the orchestrator may charge; nil if any
+	maxPrice *big.Rat
+}
+
+func parseOrchestratorEntry(addr string) (*orchestratorEntry, error) {
+	if !strings.HasPrefix(addr, "http") {
+		addr = "https://" + addr
+	}
+	uri, err := url.ParseRequestURI(addr)
+	if err != nil {
+		return nil, err
+	}
+	opts := uri.Query()
+	uri.RawQuery = ""
+
+	e := &orchestratorEntry{uri: uri, label: uri.Host, weight: 1}
+	for name := range opts {
+		v := opts.Get(name)
+		switch name {
+		case "label":
+			e.label = v
+		case "region":
+			e.region = v
+		case "weight":
+			w, err := strconv.ParseFloat(v, 64)
+			if err != nil || w <= 0 || math.IsInf(w, 0) {
+				return nil, fmt.Errorf("invalid weight %s; must be a number greater than 0", v)
+			}
+			e.weight = w
+		case "maxPrice":
+			p, ok := new(big.Rat).SetString(v)
+			if !ok || p.Sign() < 0 {
+				return nil, fmt.Errorf("invalid maxPrice %s; must be wei per segment", v)
+			}
+			e.maxPrice = p
+		default:
+			return nil, fmt.Errorf("unknown option %s", name)
+		}
+	}
+	return e, nil
+}
+
+func (e *orchestratorEntry) String() string {
+	if e.region != "" {
+		return fmt.Sprintf("%s (%s, %s)", e.label, e.region, e.uri)
+	}
+	return fmt.Sprintf("%s (%s)", e.label, e.uri)
+}
+
+// price is the expected value in wei of the tickets the orchestrator asks
+// for with each segment; zero if it doesn't ask for payment
+func price(info *net.OrchestratorInfo) *big.Rat {
+	params := info.TicketParams
+	if params == nil {
+		return new(big.Rat)
+	}
+	ticket := &pm.Ticket{
+		FaceValue: new(big.Int).SetBytes(params.FaceValue),
+		WinProb:   new(big.Int).SetBytes(params.WinProb),
+	}
+	return ticket.EV()
+}
+
+// acceptsPrice is whether the orchestrator's price is within the entry's max
+func (e *orchestratorEntry) acceptsPrice(info *net.OrchestratorInfo) bool {
+	return e.maxPrice == nil || price(info).Cmp(e.maxPrice) <= 0
+}
+
+type weightedInfo struct {
+	info *net.OrchestratorInfo
+	key  float64
+}
+
+var randFloat = rand.Float64
+
+// weightedOrder shuffles infos so each is more likely to come last the
+// higher its weight, as the broadcaster uses the last session first
+func weightedOrder(infos []*net.OrchestratorInfo, weights []float64) []*net.OrchestratorInfo {
+	// Weighted random sampling; see Efraimidis and Spirakis
+	keyed := make([]weightedInfo, len(infos))
+	for i, info := range infos {
+		keyed[i] = weightedInfo{info: info, key: math.Pow(randFloat(), 1/weights[i])}
+	}
+	sort.SliceStable(keyed, func(i, j int) bool { return keyed[i].key < keyed[j].key })
+	ordered := make([]*net.OrchestratorInfo, len(infos))
+	for i, k := range keyed {
+		ordered[i] = k.info
+	}
+	return ordered
+}
@@ -0,0 +1,337 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/ipfs"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// Web3StorageAPI default endpoint of the archival service
+const Web3StorageAPI = "https://api.web3.storage"
+
+// ARCHIVE_RETRIES how many times uploading an archive is retried before giving up
+var ARCHIVE_RETRIES = 3
+
+const archiveBackoff = 5 * time.Second
+
+var archiveDealsInterval = time.Hour
+var getArchiveDealsTicker = func() *time.Ticker {
+	return time.NewTicker(archiveDealsInterval)
+}
+
+// archiveRetryInterval is how often the streams that failed to be archived
+// are archived again
+var archiveRetryInterval = 10 * time.Minute
+var getArchiveRetryTicker = func() *time.Ticker {
+	return time.NewTicker(archiveRetryInterval)
+}
+
+// ArchiveOS saves data into the underlying driver and also spools it to disk.
+// Once a stream ends, its spooled data is uploaded to web3.storage as a
+// single directory which is then stored on Filecoin. The CID of the archive
+// and its Filecoin deals are recorded in the node DB. Streams that fail to
+// be archived stay spooled, and are archived again every
+// archiveRetryInterval, including after restarts.
+type ArchiveOS struct {
+	os       OSDriver
+	dir      string
+	endpoint string
+	token    string
+	api      *ipfs.IpfsHttpApi
+	db       *common.DB
+	client   *http.Client
+
+	mu sync.Mutex
+	// sessions are the number of sessions spooling each stream, by path
+	sessions map[string]int
+	// archiving are the paths of the streams being archived
+	archiving map[string]bool
+}
+
+type archiveSession struct {
+	aos     *ArchiveOS
+	session OSSession
+	path    string
+}
+
+// NewArchiveDriver returns a driver archiving streams saved into os.
+// Data is spooled into dir until it's uploaded to the web3.storage compatible
+// service at endpoint.
+func NewArchiveDriver(os OSDriver, dir, endpoint, token string, db *common.DB) *ArchiveOS {
+	endpoint = strings.TrimRight(endpoint, "/")
+	api := ipfs.NewPinningServiceApi(endpoint+"/upload", token)
+	// Archives hold whole streams; they can take a while to upload
+	api.SetTimeout(0)
+	aos := &ArchiveOS{
+		os:        os,
+		dir:       dir,
+		endpoint:  endpoint,
+		token:     token,
+		api:       api,
+		db:        db,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		sessions:  make(map[string]int),
+		archiving: make(map[string]bool),
+	}
+	ticker := getArchiveDealsTicker()
+	go func() {
+		for range ticker.C {
+			aos.updateDeals()
+		}
+	}()
+	retryTicker := getArchiveRetryTicker()
+	go func() {
+		for range retryTicker.C {
+			aos.retryArchives()
+		}
+	}()
+	return aos
+}
+
+// Archived returns the driver data is saved into before it's archived
+func (aos *ArchiveOS) Archived() OSDriver {
+	return aos.os
+}
+
+func (aos *ArchiveOS) NewSession(path string) OSSession {
+	aos.mu.Lock()
+	aos.sessions[path]++
+	aos.mu.Unlock()
+	return &archiveSession{aos: aos, session: aos.os.NewSession(path), path: path}
+}
+
+// endSession archives the stream at path once none of its sessions are
+// spooling it anymore
+func (aos *ArchiveOS) endSession(path string) {
+	aos.mu.Lock()
+	aos.sessions[path]--
+	if aos.sessions[path] <= 0 {
+		delete(aos.sessions, path)
+	}
+	aos.mu.Unlock()
+	aos.archive(path)
+}
+
+// retryArchives archives the streams left spooled, that failed to be
+// archived or were left over by a restart, unless they're still spooling.
+// Streams are spooled in a directory each, by path.
+func (aos *ArchiveOS) retryArchives() {
+	entries, err := ioutil.ReadDir(aos.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			glog.Errorf("Error reading archive spool dir=%s err=%v", aos.dir, err)
+		}
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			aos.archive(e.Name())
+		}
+	}
+}
+
+// spoolPath returns where name of the stream at path is spooled; the names
+// are cleaned so they can't point outside of the spool directory
+func (aos *ArchiveOS) spoolPath(path string, name ...string) string {
+	return filepath.Join(aos.dir, filepath.Clean("/"+filepath.Join(append([]string{path}, name...)...)))
+}
+
+// archive uploads the spooled data of the stream at path, unless it's still
+// being spooled or archived
+func (aos *ArchiveOS) archive(path string) {
+	aos.mu.Lock()
+	if aos.sessions[path] > 0 || aos.archiving[path] {
+		aos.mu.Unlock()
+		return
+	}
+	aos.archiving[path] = true
+	aos.mu.Unlock()
+	defer func() {
+		aos.mu.Lock()
+		delete(aos.archiving, path)
+		aos.mu.Unlock()
+	}()
+
+	dir := aos.spoolPath(path)
+	var paths []string
+	var size int64
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			paths = append(paths, p)
+			size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) || (err == nil && len(paths) == 0) {
+		return
+	}
+	if err != nil {
+		glog.Errorf("Error reading archive of stream=%s err=%v", path, err)
+		return
+	}
+
+	var cid string
+	for i := 0; i <= ARCHIVE_RETRIES; i++ {
+		if i > 0 {
+			time.Sleep(archiveBackoff << uint(i-1))
+		}
+		files := make([]ipfs.File, len(paths))
+		for j, p := range paths {
+			rel, _ := filepath.Rel(dir, p)
+			files[j] = ipfs.File{Path: filepath.ToSlash(rel), Data: &spooledFile{path: p}}
+		}
+		cid, err = aos.api.AddFiles(files)
+		for _, f := range files {
+			f.Data.(*spooledFile).Close()
+		}
+		if err == nil {
+			break
+		}
+		glog.Errorf("Error uploading archive of stream=%s attempt=%d err=%v", path, i+1, err)
+	}
+	if err != nil {
+		glog.Errorf("Error archiving stream=%s; data kept in %s to be archived again", path, dir)
+		return
+	}
+	glog.Infof("Archived stream=%s cid=%s size=%d", path, cid, size)
+
+	if aos.db != nil {
+		if err := aos.db.InsertRecording(path, cid, size); err != nil {
+			glog.Errorf("Error recording archive of stream=%s cid=%s; data kept in %s", path, cid, dir)
+			return
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		glog.Errorf("Error removing archive spool dir=%s err=%v", dir, err)
+	}
+}
+
+// updateDeals refreshes the Filecoin deals of all recordings
+func (aos *ArchiveOS) updateDeals() {
+	recordings, err := aos.db.Recordings()
+	if err != nil {
+		return
+	}
+	for _, rec := range recordings {
+		deals, err := aos.getDeals(rec.CID)
+		if err != nil {
+			glog.Errorf("Error getting deals of recording manifestID=%s cid=%s err=%v", rec.ManifestID, rec.CID, err)
+			continue
+		}
+		if deals != rec.Deals {
+			aos.db.UpdateRecordingDeals(rec.CID, deals)
+		}
+	}
+}
+
+func (aos *ArchiveOS) getDeals(cid string) (string, error) {
+	req, err := http.NewRequest("GET", aos.endpoint+"/status/"+cid, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+aos.token)
+	resp, err := aos.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status=%v body=%s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var status struct {
+		Deals json.RawMessage `json:"deals"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return "", err
+	}
+	if len(status.Deals) == 0 || string(status.Deals) == "null" {
+		return "[]", nil
+	}
+	return string(status.Deals), nil
+}
+
+func (session *archiveSession) SaveData(name string, data []byte) (string, error) {
+	uri, err := session.session.SaveData(name, data)
+	if err != nil {
+		return "", err
+	}
+	p := session.aos.spoolPath(session.path, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		glog.Errorf("Error spooling data for archive name=%s err=%v", name, err)
+		return uri, nil
+	}
+	if err := ioutil.WriteFile(p, data, 0644); err != nil {
+		glog.Errorf("Error spooling data for archive name=%s err=%v", name, err)
+	}
+	return uri, nil
+}
+
+func (session *archiveSession) EndSession() {
+	session.session.EndSession()
+	go session.aos.endSession(session.path)
+}
+
+// GetInfo returns nil so orchestrators hand transcoded data back to be archived
+func (session *archiveSession) GetInfo() *net.OSInfo {
+	return nil
+}
+
+// IsExternal returns false; all data has to pass through this node to be spooled
+func (session *archiveSession) IsExternal() bool {
+	return false
+}
+
+// spooledFile opens the file at path on first read, so that archives with
+// many segments don't hold a descriptor for each while uploading
+type spooledFile struct {
+	path string
+	f    *os.File
+	done bool
+}
+
+func (sf *spooledFile) Read(p []byte) (int, error) {
+	if sf.done {
+		return 0, io.EOF
+	}
+	if sf.f == nil {
+		f, err := os.Open(sf.path)
+		if err != nil {
+			return 0, err
+		}
+		sf.f = f
+	}
+	n, err := sf.f.Read(p)
+	if err == io.EOF {
+		sf.done = true
+		sf.Close()
+	}
+	return n, err
+}
+
+func (sf *spooledFile) Close() error {
+	if sf.f == nil {
+		return nil
+	}
+	err := sf.f.Close()
+	sf.f = nil
+	return err
+}
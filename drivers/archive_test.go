@@ -0,0 +1,92 @@
+package drivers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveOS_Retry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(deals, retry func() *time.Ticker, retries int) {
+		getArchiveDealsTicker, getArchiveRetryTicker, ARCHIVE_RETRIES = deals, retry, retries
+	}(getArchiveDealsTicker, getArchiveRetryTicker, ARCHIVE_RETRIES)
+	// Retried by hand rather than on the tickers
+	getArchiveDealsTicker = func() *time.Ticker { return &time.Ticker{} }
+	getArchiveRetryTicker = func() *time.Ticker { return &time.Ticker{} }
+	ARCHIVE_RETRIES = 0
+
+	dir, err := ioutil.TempDir("", "archive")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	var mu sync.Mutex
+	uploads, failing := 0, true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		uploads++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"cid": "bafy1"}`))
+	}))
+	defer ts.Close()
+
+	base, _ := url.Parse("http://node")
+	aos := NewArchiveDriver(NewMemoryDriver(base), dir, ts.URL, "token", dbh)
+	sess1 := aos.NewSession("mid")
+	sess2 := aos.NewSession("mid")
+	_, err = sess1.SaveData("source/0.ts", []byte("0"))
+	require.Nil(err)
+	_, err = sess2.SaveData("P144p30fps16x9/0.ts", []byte("00"))
+	require.Nil(err)
+
+	// Streams aren't archived while still spooled
+	aos.endSession("mid")
+	assert.Equal(0, uploads)
+
+	// Streams that fail to be archived stay spooled
+	aos.endSession("mid")
+	assert.Equal(1, uploads)
+	_, err = os.Stat(filepath.Join(dir, "mid", "source", "0.ts"))
+	assert.Nil(err)
+	recordings, err := dbh.Recordings()
+	require.Nil(err)
+	assert.Empty(recordings)
+
+	// and are archived again
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	aos.retryArchives()
+	assert.Equal(2, uploads)
+	recordings, err = dbh.Recordings()
+	require.Nil(err)
+	require.Len(recordings, 1)
+	assert.Equal("mid", recordings[0].ManifestID)
+	assert.Equal("bafy1", recordings[0].CID)
+	assert.Equal(int64(3), recordings[0].Size)
+	_, err = os.Stat(filepath.Join(dir, "mid"))
+	assert.True(os.IsNotExist(err))
+
+	// Nothing's left to archive
+	aos.retryArchives()
+	assert.Equal(2, uploads)
+}
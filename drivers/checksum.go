@@ -0,0 +1,137 @@
+package drivers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+)
+
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Objects are saved with their SHA-256 in this user metadata key, so that
+// they can be verified when read back
+const checksumMetaKey = "sha256"
+
+const s3ChecksumHeader = "x-amz-meta-" + checksumMetaKey
+
+// Checksum returns the hex encoded SHA-256 of data
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumReader checks the body of an object downloaded from host against
+// the checksums in the response headers as it's read. The SHA-256 we store
+// in the object metadata is preferred; for Google Storage the MD5 it always
+// reports is used otherwise, and for S3 the ETag of objects uploaded in one
+// part, e.g. to pre-signed URLs, which is their MD5 unless they're
+// encrypted with KMS. Objects without any checksum are accepted.
+type checksumReader struct {
+	body     io.ReadCloser
+	host     string
+	driver   string
+	expected string
+	hash     hash.Hash
+	encode   func([]byte) string
+
+	size int
+	done func(size int, err error)
+}
+
+func newChecksumReader(host string, header http.Header, body io.ReadCloser, done func(size int, err error)) *checksumReader {
+	r := &checksumReader{body: body, host: host, done: done}
+	if sum := header.Get(s3ChecksumHeader); sum != "" {
+		r.driver, r.expected, r.hash, r.encode = "s3", sum, sha256.New(), hex.EncodeToString
+	} else if md5sum := gsHashMD5(header); md5sum != "" {
+		r.driver, r.expected, r.hash, r.encode = "google", md5sum, md5.New(), base64.StdEncoding.EncodeToString
+	} else if md5sum := s3ETagMD5(header); md5sum != "" {
+		r.driver, r.expected, r.hash, r.encode = "s3", md5sum, md5.New(), hex.EncodeToString
+	}
+	return r
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.size += n
+	if r.hash != nil {
+		r.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verr := r.verify(); verr != nil {
+			err = verr
+		}
+		r.finish(err)
+	} else if err != nil {
+		r.finish(err)
+	}
+	return n, err
+}
+
+func (r *checksumReader) verify() error {
+	if r.hash == nil {
+		return nil
+	}
+	actual := r.encode(r.hash.Sum(nil))
+	if !strings.EqualFold(r.expected, actual) {
+		glog.Errorf("Checksum mismatch of object from %s expected=%s actual=%s", r.host, r.expected, actual)
+		if monitor.Enabled {
+			monitor.StorageChecksumMismatch(r.driver, r.host)
+		}
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// Close closes the body; objects closed before they're read to the end
+// count as failed downloads
+func (r *checksumReader) Close() error {
+	r.finish(io.ErrUnexpectedEOF)
+	return r.body.Close()
+}
+
+func (r *checksumReader) finish(err error) {
+	if r.done == nil {
+		return
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	r.done(r.size, err)
+	r.done = nil
+}
+
+// gsHashMD5 returns the base64 MD5 from the x-goog-hash header, eg
+// `crc32c=n03x6A==,md5=Ojk9c3dhfxgoKVVHYwFbHQ==`
+func gsHashMD5(header http.Header) string {
+	for _, h := range header[http.CanonicalHeaderKey("x-goog-hash")] {
+		for _, kv := range strings.Split(h, ",") {
+			if v := strings.TrimSpace(kv); strings.HasPrefix(v, "md5=") {
+				return strings.TrimPrefix(v, "md5=")
+			}
+		}
+	}
+	return ""
+}
+
+// s3ETagMD5 returns the hex MD5 of an object from the ETag of an S3
+// response, if it's the MD5: ETags of objects uploaded in parts have the part
+// count appended, and those of objects encrypted with KMS aren't MD5s at all
+func s3ETagMD5(header http.Header) string {
+	if header.Get("x-amz-request-id") == "" || header.Get("x-amz-server-side-encryption") == "aws:kms" {
+		return ""
+	}
+	etag := strings.Trim(header.Get("ETag"), `"`)
+	if _, err := hex.DecodeString(etag); err != nil || len(etag) != 2*md5.Size {
+		return ""
+	}
+	return etag
+}
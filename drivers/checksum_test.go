@@ -0,0 +1,110 @@
+package drivers
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSegmentData_Checksums(t *testing.T) {
+	assert := assert.New(t)
+	data := []byte("segment")
+	md5sum := md5.Sum(data)
+	etag := `"` + hex.EncodeToString(md5sum[:]) + `"`
+
+	var headers map[string]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		name    string
+		headers map[string]string
+		err     error
+	}{
+		{"no checksum", nil, nil},
+		{"sha256", map[string]string{s3ChecksumHeader: Checksum(data)}, nil},
+		{"wrong sha256", map[string]string{s3ChecksumHeader: Checksum([]byte("other"))}, ErrChecksumMismatch},
+		{"google md5", map[string]string{"x-goog-hash": "crc32c=n03x6A==,md5=" + base64.StdEncoding.EncodeToString(md5sum[:])}, nil},
+		{"wrong google md5", map[string]string{"x-goog-hash": "md5=Ojk9c3dhfxgoKVVHYwFbHQ=="}, ErrChecksumMismatch},
+		// Objects uploaded to pre-signed URLs are checked against their ETag
+		{"s3 etag", map[string]string{"x-amz-request-id": "1", "ETag": etag}, nil},
+		{"wrong s3 etag", map[string]string{"x-amz-request-id": "1", "ETag": `"3a393d73776a7f18282955476301581d"`}, ErrChecksumMismatch},
+		// unless it's not an MD5
+		{"multipart etag", map[string]string{"x-amz-request-id": "1", "ETag": `"3a393d73776a7f18282955476301581d-2"`}, nil},
+		{"kms etag", map[string]string{"x-amz-request-id": "1", "x-amz-server-side-encryption": "aws:kms", "ETag": `"3a393d73776a7f18282955476301581d"`}, nil},
+		{"not s3", map[string]string{"ETag": `"3a393d73776a7f18282955476301581d"`}, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			headers = tt.headers
+			got, err := GetSegmentData(ts.URL + "/seg.ts")
+			assert.Equal(tt.err, err)
+			if tt.err == nil {
+				assert.Equal(data, got)
+			}
+		})
+	}
+}
+
+func TestS3PostData_Checksum(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	data := []byte("segment")
+
+	var fields map[string][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(r.ParseMultipartForm(1 << 20))
+		fields = r.MultipartForm.Value
+	}))
+	defer ts.Close()
+
+	// Uploads through the POST policy carry the SHA-256
+	policy, signature, credential, xAmzDate := createPolicy("key", "bucket", "region", "secret", "mid")
+	info := &net.S3OSInfo{Host: ts.URL, Key: "mid", Policy: policy, Signature: signature, Credential: credential, XAmzDate: xAmzDate}
+	sess := newS3Session(info)
+	_, err := sess.SaveData("0.ts", data)
+	require.Nil(err)
+	assert.Equal([]string{Checksum(data)}, fields[s3ChecksumHeader])
+
+	// unless the policy of the storage owner doesn't allow it
+	info.Policy = base64.StdEncoding.EncodeToString([]byte(`{"conditions": [["starts-with", "$key", "mid"]]}`))
+	sess = newS3Session(info)
+	_, err = sess.SaveData("0.ts", data)
+	require.Nil(err)
+	assert.NotContains(fields, s3ChecksumHeader)
+}
+
+func TestS3PutPresigned_MD5(t *testing.T) {
+	assert := assert.New(t)
+	data := []byte("segment")
+	sum := md5.Sum(data)
+
+	var header http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer ts.Close()
+
+	info := &net.S3OSInfo{
+		Host:          ts.URL,
+		Key:           "mid",
+		PresignedUrls: map[string]string{"0.ts": ts.URL + "/mid/0.ts?X-Amz-Signature=sig"},
+	}
+	uri, err := newS3Session(info).SaveData("0.ts", data)
+	assert.Nil(err)
+	assert.Equal(ts.URL+"/mid/0.ts", uri)
+	// for S3 to check, and to be verified against the ETag once downloaded
+	assert.Equal(base64.StdEncoding.EncodeToString(sum[:]), header.Get("Content-MD5"))
+	assert.Equal("public-read", header.Get("x-amz-acl"))
+}
@@ -4,12 +4,17 @@ package drivers
 import (
 	"crypto/tls"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
 )
 
@@ -49,39 +54,136 @@ func NewSession(info *net.OSInfo) OSSession {
 }
 
 func IsOwnExternal(uri string) bool {
-	return IsOwnStorageS3(uri) || IsOwnStorageGS(uri)
+	return IsOwnStorageS3(uri) || IsOwnStorageGS(uri) || IsOwnStorageIPFS(uri)
 }
 
 func GetSegmentData(uri string) ([]byte, error) {
+	body, err := GetSegmentStream(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	data, err := common.ReadAll(body)
+	if err != nil {
+		glog.Error("Error reading body: ", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// GetSegmentStream opens the segment at uri, to be read as it downloads
+// rather than held in memory. The checksum of the segment is verified as
+// it's read; reading its end fails with ErrChecksumMismatch if it doesn't
+// match.
+func GetSegmentStream(uri string) (io.ReadCloser, error) {
 	parsed, err := url.Parse(uri)
 	if err != nil {
 		return nil, fmt.Errorf("Invalid URI")
 	}
 	if parsed.Scheme == "ipfs" {
-		return GetSegmentDataIpfs(uri)
+		cid := strings.TrimPrefix(uri, "ipfs://")
+		if cid == "" {
+			return nil, fmt.Errorf("Invalid IPFS URI")
+		}
+		uri = ipfsURL(ipfsGateway(), cid)
 	}
-	return getSegmentDataHTTP(uri)
+	return getSegmentStreamHTTP(uri)
 }
 
 var httpc = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
 
-func getSegmentDataHTTP(uri string) ([]byte, error) {
+// Operations recorded in object store metrics
+const (
+	opUpload   = "upload"
+	opDownload = "download"
+)
+
+func recordRequest(driver, bucket, op string, size int, start time.Time, err error) {
+	if monitor.Enabled {
+		monitor.StorageRequest(driver, bucket, op, size, time.Since(start), err)
+	}
+	if op == opUpload {
+		recordUploadStatus(driver, bucket, err)
+	}
+}
+
+// StorageStatus outcome of the latest uploads into a bucket of an object store
+type StorageStatus struct {
+	Driver      string    `json:"driver"`
+	Bucket      string    `json:"bucket"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   time.Time `json:"lastError"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Failing true if the latest upload failed
+func (st StorageStatus) Failing() bool {
+	return st.LastError.After(st.LastSuccess)
+}
+
+var uploadStatusLock sync.Mutex
+var uploadStatus = make(map[[2]string]*StorageStatus)
+
+func recordUploadStatus(driver, bucket string, err error) {
+	uploadStatusLock.Lock()
+	defer uploadStatusLock.Unlock()
+	st, ok := uploadStatus[[2]string{driver, bucket}]
+	if !ok {
+		st = &StorageStatus{Driver: driver, Bucket: bucket}
+		uploadStatus[[2]string{driver, bucket}] = st
+	}
+	if err != nil {
+		st.LastError, st.Error = time.Now(), err.Error()
+	} else {
+		st.LastSuccess, st.Error = time.Now(), ""
+	}
+}
+
+// StorageStatuses returns the status of uploads into each object store bucket used so far
+func StorageStatuses() []StorageStatus {
+	uploadStatusLock.Lock()
+	defer uploadStatusLock.Unlock()
+	statuses := make([]StorageStatus, 0, len(uploadStatus))
+	for _, st := range uploadStatus {
+		statuses = append(statuses, *st)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Driver != statuses[j].Driver {
+			return statuses[i].Driver < statuses[j].Driver
+		}
+		return statuses[i].Bucket < statuses[j].Bucket
+	})
+	return statuses
+}
+
+func getSegmentStreamHTTP(uri string) (io.ReadCloser, error) {
+	start := time.Now()
+	var host string
+	if parsed, perr := url.Parse(uri); perr == nil {
+		host = parsed.Host
+	}
+	body, err := downloadHTTP(uri, func(size int, err error) {
+		recordRequest("http", host, opDownload, size, start, err)
+	})
+	if err != nil {
+		recordRequest("http", host, opDownload, 0, start, err)
+	}
+	return body, err
+}
+
+// downloadHTTP opens the body of uri; done is called with its size once
+// it's been read, or closed
+func downloadHTTP(uri string, done func(size int, err error)) (io.ReadCloser, error) {
 	glog.V(common.VERBOSE).Info("Downloading ", uri)
 	resp, err := httpc.Get(uri)
 	if err != nil {
 		glog.Error("Error getting HTTP ", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
+		resp.Body.Close()
 		glog.Error("Non-200 response ", resp.Status)
 		return nil, fmt.Errorf(resp.Status)
 	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		glog.Error("Error reading body: ", err)
-		return nil, err
-	}
-	glog.V(common.VERBOSE).Info("Downloaded ", uri)
-	return body, nil
+	return newChecksumReader(resp.Request.URL.Host, resp.Header, resp.Body, done), nil
 }
@@ -0,0 +1,102 @@
+package drivers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+// storageData returns the data of object store metric name for the uploads
+// of driver into bucket; nil if there's none
+func storageData(t *testing.T, name, driver, bucket string) view.AggregationData {
+	rows, err := view.RetrieveData(name)
+	require.Nil(t, err)
+	for _, row := range rows {
+		tags := make(map[string]string)
+		for _, tg := range row.Tags {
+			tags[tg.Key.Name()] = tg.Value
+		}
+		if tags["driver"] == driver && tags["bucket"] == bucket && tags["operation"] == opUpload {
+			return row.Data
+		}
+	}
+	return nil
+}
+
+func TestSaveData_RecordRequest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(enabled bool) { monitor.Enabled = enabled }(monitor.Enabled)
+	monitor.Enabled = true
+	monitor.InitCensus("tst", "testid", "testversion")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fail/") {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	sess := newS3Session(&net.S3OSInfo{
+		Host: ts.URL,
+		Key:  "mid",
+		PresignedUrls: map[string]string{
+			"0.ts": ts.URL + "/mid/0.ts?X-Amz-Signature=sig",
+			"1.ts": ts.URL + "/fail/1.ts?X-Amz-Signature=sig",
+		},
+	})
+	data := []byte("segment")
+	_, err := sess.SaveData("0.ts", data)
+	require.Nil(err)
+	_, err = sess.SaveData("1.ts", data)
+	require.NotNil(err)
+
+	requests, ok := storageData(t, "storage_requests_total", "s3", ts.URL).(*view.CountData)
+	require.True(ok)
+	assert.Equal(int64(2), requests.Value)
+	failed, ok := storageData(t, "storage_errors_total", "s3", ts.URL).(*view.CountData)
+	require.True(ok)
+	assert.Equal(int64(1), failed.Value)
+	// Only the successful upload transferred its data
+	transferred, ok := storageData(t, "storage_transferred_bytes", "s3", ts.URL).(*view.SumData)
+	require.True(ok)
+	assert.Equal(float64(len(data)), transferred.Value)
+	latency, ok := storageData(t, "storage_latency_seconds", "s3", ts.URL).(*view.DistributionData)
+	require.True(ok)
+	assert.Equal(int64(2), latency.Count)
+	assert.True(latency.Max >= 0.05, "max latency %v", latency.Max)
+
+	// The bucket is failing since the latest upload
+	var status StorageStatus
+	for _, st := range StorageStatuses() {
+		if st.Driver == "s3" && st.Bucket == ts.URL {
+			status = st
+		}
+	}
+	require.Equal(ts.URL, status.Bucket)
+	assert.True(status.Failing())
+	assert.Contains(status.Error, "503")
+	assert.False(status.LastSuccess.IsZero())
+
+	// Nothing's recorded with the monitor off, but the status
+	monitor.Enabled = false
+	_, err = sess.SaveData("0.ts", data)
+	require.Nil(err)
+	requests, _ = storageData(t, "storage_requests_total", "s3", ts.URL).(*view.CountData)
+	assert.Equal(int64(2), requests.Value)
+	for _, st := range StorageStatuses() {
+		if st.Driver == "s3" && st.Bucket == ts.URL {
+			assert.False(st.Failing())
+		}
+	}
+}
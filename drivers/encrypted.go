@@ -0,0 +1,118 @@
+package drivers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// EncryptionKeySize AES-256 keys are used
+const EncryptionKeySize = 32
+
+var ErrEncryptedTooShort = errors.New("encrypted data too short")
+
+// encryptedOS encrypts data with AES-GCM before handing it over to the
+// underlying driver. Data is stored as nonce followed by the sealed data.
+type encryptedOS struct {
+	os   OSDriver
+	aead cipher.AEAD
+}
+
+type encryptedSession struct {
+	session OSSession
+	aead    cipher.AEAD
+}
+
+// NewEncryptedDriver returns a driver that encrypts everything saved into os with key
+func NewEncryptedDriver(os OSDriver, key []byte) (OSDriver, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedOS{os: os, aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != EncryptionKeySize {
+		return nil, fmt.Errorf("invalid encryption key size %d, expected %d", len(key), EncryptionKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadEncryptionKey reads the key from keyFile. The file holds either the raw
+// key or its hex encoding. If kmsRegion is set, the file holds a data key
+// encrypted by AWS KMS instead, which is decrypted with the default AWS credentials.
+func ReadEncryptionKey(keyFile, kmsRegion string) ([]byte, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if kmsRegion != "" {
+		svc := kms.New(session.New(), aws.NewConfig().WithRegion(kmsRegion))
+		out, err := svc.Decrypt(&kms.DecryptInput{CiphertextBlob: data})
+		if err != nil {
+			return nil, err
+		}
+		return out.Plaintext, nil
+	}
+	if hexKey := strings.TrimSpace(string(data)); len(hexKey) == hex.EncodedLen(EncryptionKeySize) {
+		if key, err := hex.DecodeString(hexKey); err == nil {
+			return key, nil
+		}
+	}
+	return data, nil
+}
+
+// DecryptData opens data saved through an encrypted driver with key
+func DecryptData(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize()+aead.Overhead() {
+		return nil, ErrEncryptedTooShort
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
+
+func (eos *encryptedOS) NewSession(path string) OSSession {
+	return &encryptedSession{session: eos.os.NewSession(path), aead: eos.aead}
+}
+
+func (session *encryptedSession) SaveData(name string, data []byte) (string, error) {
+	nonce := make([]byte, session.aead.NonceSize(), session.aead.NonceSize()+len(data)+session.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return session.session.SaveData(name, session.aead.Seal(nonce, nonce, data, nil))
+}
+
+func (session *encryptedSession) EndSession() {
+	session.session.EndSession()
+}
+
+// GetInfo returns nil; other nodes can't encrypt so they must not write into the storage directly
+func (session *encryptedSession) GetInfo() *net.OSInfo {
+	return nil
+}
+
+// IsExternal returns false so data always passes through this node to be encrypted
+func (session *encryptedSession) IsExternal() bool {
+	return false
+}
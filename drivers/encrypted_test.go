@@ -0,0 +1,89 @@
+package drivers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedOS_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	key := bytes.Repeat([]byte{1}, EncryptionKeySize)
+
+	mem := NewMemoryDriver(nil)
+	eos, err := NewEncryptedDriver(mem, key)
+	require.Nil(err)
+	sess := eos.NewSession("mid")
+	assert.False(sess.IsExternal())
+	assert.Nil(sess.GetInfo())
+
+	for _, data := range [][]byte{[]byte("segment"), {}, bytes.Repeat([]byte("0123456789"), 1000)} {
+		uri, err := sess.SaveData("0.ts", data)
+		require.Nil(err)
+		assert.Equal("/stream/mid/0.ts", uri)
+
+		// What's stored is sealed with a fresh nonce
+		stored := mem.GetSession("mid").GetData("mid/0.ts")
+		assert.Len(stored, 12+len(data)+16)
+		if len(data) > 0 {
+			assert.False(bytes.Contains(stored, data))
+		}
+		opened, err := DecryptData(key, stored)
+		require.Nil(err)
+		assert.Equal(data, append([]byte{}, opened...))
+	}
+
+	// The same data is stored differently each time
+	sess.SaveData("0.ts", []byte("segment"))
+	first := mem.GetSession("mid").GetData("mid/0.ts")
+	sess.SaveData("0.ts", []byte("segment"))
+	assert.NotEqual(first, mem.GetSession("mid").GetData("mid/0.ts"))
+
+	// and can't be opened with another key, or once tampered with
+	_, err = DecryptData(bytes.Repeat([]byte{2}, EncryptionKeySize), first)
+	assert.NotNil(err)
+	tampered := append([]byte{}, first...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = DecryptData(key, tampered)
+	assert.NotNil(err)
+	_, err = DecryptData(key, first[:20])
+	assert.Equal(ErrEncryptedTooShort, err)
+	_, err = DecryptData(key[:16], first)
+	assert.NotNil(err)
+
+	_, err = NewEncryptedDriver(mem, key[:16])
+	assert.NotNil(err)
+}
+
+func TestReadEncryptionKey(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "key")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	key := bytes.Repeat([]byte{0xab}, EncryptionKeySize)
+
+	// Raw keys
+	raw := filepath.Join(dir, "raw")
+	require.Nil(ioutil.WriteFile(raw, key, 0600))
+	read, err := ReadEncryptionKey(raw, "")
+	require.Nil(err)
+	assert.Equal(key, read)
+
+	// or hex encoded, with a trailing newline
+	encoded := filepath.Join(dir, "hex")
+	require.Nil(ioutil.WriteFile(encoded, []byte(hex.EncodeToString(key)+"\n"), 0600))
+	read, err = ReadEncryptionKey(encoded, "")
+	require.Nil(err)
+	assert.Equal(key, read)
+
+	_, err = ReadEncryptionKey(filepath.Join(dir, "missing"), "")
+	assert.NotNil(err)
+}
@@ -0,0 +1,194 @@
+package drivers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+var fallbackReconcileInterval = 30 * time.Second
+var getFallbackTicker = func() *time.Ticker {
+	return time.NewTicker(fallbackReconcileInterval)
+}
+
+// fallbackMaxPending is the number of objects kept for reconciliation;
+// the oldest are dropped once the primary has been failing for too long.
+var fallbackMaxPending = 300
+
+// FallbackOS saves data into the primary storage. Once the primary fails,
+// sessions write into the fallback storage instead. Everything written to
+// the fallback is copied into the primary by a background job as soon as
+// the primary recovers.
+type FallbackOS struct {
+	primary  OSDriver
+	fallback OSDriver
+
+	lock    sync.Mutex
+	failing bool
+	pending []*pendingObject
+}
+
+type pendingObject struct {
+	session *fallbackSession
+	name    string
+	data    []byte
+	// uri the object was saved to in the fallback storage
+	uri string
+}
+
+type fallbackSession struct {
+	os       *FallbackOS
+	primary  OSSession
+	fallback OSSession
+
+	// repoint is called, guarded by the driver's lock, with the URIs of the
+	// objects copied into the primary storage
+	repoint func(from, to string)
+}
+
+// RepointingSession is implemented by sessions whose objects may move to
+// other URIs after they're saved, such as from the fallback storage into the
+// primary one, for those keeping the URIs to update them
+type RepointingSession interface {
+	// OnRepoint has f called with the old and the new URI of each object
+	// moved from then on
+	OnRepoint(f func(from, to string))
+}
+
+func NewFallbackDriver(primary, fallback OSDriver) *FallbackOS {
+	fos := &FallbackOS{
+		primary:  primary,
+		fallback: fallback,
+	}
+	ticker := getFallbackTicker()
+	go func() {
+		for range ticker.C {
+			fos.reconcile()
+		}
+	}()
+	return fos
+}
+
+// Fallback returns the driver used while the primary is failing
+func (fos *FallbackOS) Fallback() OSDriver {
+	return fos.fallback
+}
+
+func (fos *FallbackOS) NewSession(path string) OSSession {
+	return &fallbackSession{
+		os:       fos,
+		primary:  fos.primary.NewSession(path),
+		fallback: fos.fallback.NewSession(path),
+	}
+}
+
+func (fos *FallbackOS) isFailing() bool {
+	fos.lock.Lock()
+	defer fos.lock.Unlock()
+	return fos.failing
+}
+
+func (fos *FallbackOS) addPending(obj *pendingObject) {
+	fos.lock.Lock()
+	defer fos.lock.Unlock()
+	fos.failing = true
+	if len(fos.pending) >= fallbackMaxPending {
+		dropped := fos.pending[0]
+		glog.Errorf("Too many objects pending for primary storage; dropping name=%s", dropped.name)
+		fos.pending = fos.pending[1:]
+	}
+	fos.pending = append(fos.pending, obj)
+}
+
+// reconcile copies pending objects into the primary storage, in order.
+// Stops at the first failure; the primary is still considered down then.
+func (fos *FallbackOS) reconcile() {
+	fos.lock.Lock()
+	pending := fos.pending
+	fos.lock.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	done := 0
+	var repointed []string
+	for _, obj := range pending {
+		uri, err := obj.session.primary.SaveData(obj.name, obj.data)
+		if err != nil {
+			glog.Errorf("Primary storage still failing; pending=%d err=%v", len(pending)-done, err)
+			break
+		}
+		repointed = append(repointed, uri)
+		done++
+	}
+
+	fos.lock.Lock()
+	defer fos.lock.Unlock()
+	for i, uri := range repointed {
+		if obj := pending[i]; obj.session.repoint != nil {
+			obj.session.repoint(obj.uri, uri)
+		}
+	}
+	// Objects may have been added or dropped while we were uploading
+	if done > 0 {
+		for i, obj := range fos.pending {
+			if obj == pending[done-1] {
+				fos.pending = fos.pending[i+1:]
+				break
+			}
+		}
+	}
+	if done == len(pending) && len(fos.pending) == 0 {
+		glog.Info("Primary storage recovered; all pending objects reconciled")
+		fos.failing = false
+	} else if done > 0 {
+		glog.V(common.DEBUG).Infof("Reconciled objects with primary storage count=%d", done)
+	}
+}
+
+func (session *fallbackSession) SaveData(name string, data []byte) (string, error) {
+	if !session.os.isFailing() {
+		uri, err := session.primary.SaveData(name, data)
+		if err == nil {
+			return uri, nil
+		}
+		glog.Errorf("Error saving to primary storage, using fallback name=%s err=%v", name, err)
+	}
+	uri, err := session.fallback.SaveData(name, data)
+	if err != nil {
+		return "", err
+	}
+	session.os.addPending(&pendingObject{session: session, name: name, data: data, uri: uri})
+	return uri, nil
+}
+
+// OnRepoint has f called with the URIs of the objects saved into the
+// fallback storage, and the ones they're copied to in the primary storage
+func (session *fallbackSession) OnRepoint(f func(from, to string)) {
+	session.os.lock.Lock()
+	defer session.os.lock.Unlock()
+	session.repoint = f
+}
+
+func (session *fallbackSession) EndSession() {
+	session.primary.EndSession()
+	session.fallback.EndSession()
+}
+
+func (session *fallbackSession) GetInfo() *net.OSInfo {
+	return session.primary.GetInfo()
+}
+
+func (session *fallbackSession) IsExternal() bool {
+	return session.primary.IsExternal()
+}
+
+func (session *fallbackSession) GetPresignedInfo(names []string) (*net.OSInfo, error) {
+	if ps, ok := session.primary.(PresignedSession); ok {
+		return ps.GetPresignedInfo(names)
+	}
+	return nil, nil
+}
@@ -0,0 +1,133 @@
+package drivers
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubOS saves data under its base URI, or fails while failing is set
+type stubOS struct {
+	mu      sync.Mutex
+	failing bool
+	saved   map[string][]byte
+}
+
+func (s *stubOS) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *stubOS) NewSession(path string) OSSession {
+	return &stubOSSession{os: s, path: path}
+}
+
+type stubOSSession struct {
+	os   *stubOS
+	path string
+}
+
+func (s *stubOSSession) SaveData(name string, data []byte) (string, error) {
+	s.os.mu.Lock()
+	defer s.os.mu.Unlock()
+	if s.os.failing {
+		return "", errors.New("primary down")
+	}
+	uri := "https://primary/" + s.path + "/" + name
+	if s.os.saved == nil {
+		s.os.saved = make(map[string][]byte)
+	}
+	s.os.saved[uri] = data
+	return uri, nil
+}
+
+func (s *stubOSSession) EndSession()          {}
+func (s *stubOSSession) GetInfo() *net.OSInfo { return nil }
+func (s *stubOSSession) IsExternal() bool     { return true }
+
+func TestFallbackOS(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func() *time.Ticker) { getFallbackTicker = f }(getFallbackTicker)
+	// Reconciled by hand rather than on the ticker
+	getFallbackTicker = func() *time.Ticker { return &time.Ticker{} }
+
+	primary := &stubOS{}
+	base, _ := url.Parse("http://node")
+	fos := NewFallbackDriver(primary, NewMemoryDriver(base))
+	sess := fos.NewSession("mid")
+	repointed := make(map[string]string)
+	sess.(RepointingSession).OnRepoint(func(from, to string) { repointed[from] = to })
+
+	uri, err := sess.SaveData("0.ts", []byte("0"))
+	assert.Nil(err)
+	assert.Equal("https://primary/mid/0.ts", uri)
+
+	// Fails over once the primary fails
+	primary.setFailing(true)
+	uri1, err := sess.SaveData("1.ts", []byte("1"))
+	assert.Nil(err)
+	assert.Equal("http://node/stream/mid/1.ts", uri1)
+	assert.True(fos.isFailing())
+
+	// and stays on the fallback until all is reconciled, even if the
+	// primary is back
+	primary.setFailing(false)
+	uri2, err := sess.SaveData("2.ts", []byte("2"))
+	assert.Nil(err)
+	assert.Equal("http://node/stream/mid/2.ts", uri2)
+	assert.Len(primary.saved, 1)
+
+	primary.setFailing(true)
+	fos.reconcile()
+	assert.Len(fos.pending, 2)
+	assert.Empty(repointed)
+	assert.True(fos.isFailing())
+
+	// Once recovered, what was saved to the fallback is copied into the
+	// primary, and repointed there
+	primary.setFailing(false)
+	fos.reconcile()
+	assert.Empty(fos.pending)
+	assert.False(fos.isFailing())
+	assert.Equal([]byte("1"), primary.saved["https://primary/mid/1.ts"])
+	assert.Equal([]byte("2"), primary.saved["https://primary/mid/2.ts"])
+	assert.Equal(map[string]string{
+		uri1: "https://primary/mid/1.ts",
+		uri2: "https://primary/mid/2.ts",
+	}, repointed)
+
+	uri, err = sess.SaveData("3.ts", []byte("3"))
+	assert.Nil(err)
+	assert.Equal("https://primary/mid/3.ts", uri)
+}
+
+func TestFallbackOS_MaxPending(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func() *time.Ticker, max int) {
+		getFallbackTicker, fallbackMaxPending = f, max
+	}(getFallbackTicker, fallbackMaxPending)
+	getFallbackTicker = func() *time.Ticker { return &time.Ticker{} }
+	fallbackMaxPending = 2
+
+	primary := &stubOS{failing: true}
+	base, _ := url.Parse("http://node")
+	fos := NewFallbackDriver(primary, NewMemoryDriver(base))
+	sess := fos.NewSession("mid")
+	for _, name := range []string{"0.ts", "1.ts", "2.ts"} {
+		_, err := sess.SaveData(name, []byte(name))
+		assert.Nil(err)
+	}
+
+	// The oldest are dropped
+	primary.setFailing(false)
+	fos.reconcile()
+	assert.Len(primary.saved, 2)
+	assert.NotContains(primary.saved, "https://primary/mid/0.ts")
+	assert.False(fos.isFailing())
+}
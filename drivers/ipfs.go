@@ -3,18 +3,49 @@ package drivers
 import (
 	"bytes"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/ipfs"
 	"github.com/livepeer/go-livepeer/net"
 )
 
+// DefaultIPFSGateway is used to build and resolve segment URLs when no gateway is configured
+const DefaultIPFSGateway = "https://ipfs.io"
+
+// ipfsOS IPFS backed object storage driver. Data is added either to a local
+// IPFS node or to a remote pinning service; the returned URLs are built from
+// the content identifier (CID) so they stay valid on any gateway.
 type ipfsOS struct {
+	api     ipfs.IpfsApi
+	gateway string
+}
+
+type ipfsSession struct {
+	os   *ipfsOS
+	path string
 }
 
 var ipfsAPI ipfs.IpfsApi
 
+// IPFSGATEWAY gateway used by this node's IPFS storage
+var IPFSGATEWAY string
+
+// IsOwnStorageIPFS returns true if uri points to the IPFS gateway used by this node
+func IsOwnStorageIPFS(uri string) bool {
+	return IPFSGATEWAY != "" && strings.HasPrefix(uri, IPFSGATEWAY+"/ipfs/")
+}
+
+// GetSegmentDataIpfs fetches an ipfs://CID uri through the configured gateway
 func GetSegmentDataIpfs(uri string) ([]byte, error) {
-	return nil, fmt.Errorf("NotImplemented")
+	cid := strings.TrimPrefix(uri, "ipfs://")
+	if cid == "" {
+		return nil, fmt.Errorf("Invalid IPFS URI")
+	}
+	return GetSegmentData(ipfsURL(ipfsGateway(), cid))
 }
 
 // SetIpfsAPI ...
@@ -22,30 +53,68 @@ func SetIpfsAPI(api ipfs.IpfsApi) {
 	ipfsAPI = api
 }
 
+// NewIPFSDriver returns a driver that adds data through api. Gateway is the
+// base of the returned URLs; DefaultIPFSGateway is used if it is empty.
+func NewIPFSDriver(api ipfs.IpfsApi, gateway string) OSDriver {
+	if gateway == "" {
+		gateway = DefaultIPFSGateway
+	}
+	return &ipfsOS{
+		api:     api,
+		gateway: strings.TrimRight(gateway, "/"),
+	}
+}
+
+func (os *ipfsOS) NewSession(path string) OSSession {
+	return &ipfsSession{os: os, path: path}
+}
+
+// newIPFSSession is used when other node asks us to store data in IPFS.
+// Content addressed data needs no credentials, so we add it through our own API.
 func newIPFSSession() OSSession {
-	od := &ipfsOS{}
-	return od
+	if ipfsAPI == nil {
+		glog.Error("IPFS storage requested but no IPFS API is configured")
+		return nil
+	}
+	return NewIPFSDriver(ipfsAPI, ipfsGateway()).NewSession("")
 }
 
-func (os *ipfsOS) IsExternal() bool {
+func (session *ipfsSession) IsExternal() bool {
 	return true
 }
 
 // GetInfo
-func (os *ipfsOS) GetInfo() *net.OSInfo {
+func (session *ipfsSession) GetInfo() *net.OSInfo {
 	info := net.OSInfo{
 		StorageType: net.OSInfo_IPFS,
 	}
 	return &info
 }
 
-func (os *ipfsOS) EndSession() {
+func (session *ipfsSession) EndSession() {
+}
 
+func (session *ipfsSession) SaveData(name string, data []byte) (string, error) {
+	defer waitUpload("ipfs")()
+	start := time.Now()
+	cid, err := session.os.api.Add(limitReader("ipfs", bytes.NewReader(data)))
+	recordRequest("ipfs", strings.TrimPrefix(session.os.gateway, "https://"), opUpload, len(data), start, err)
+	if err != nil {
+		glog.Errorf("Error adding %s to IPFS err=%v", path.Join(session.path, name), err)
+		return "", err
+	}
+	uri := ipfsURL(session.os.gateway, cid)
+	glog.V(common.VERBOSE).Infof("Saved %s to IPFS uri=%s", path.Join(session.path, name), uri)
+	return uri, nil
 }
 
-func (os *ipfsOS) SaveData(name string, data []byte) (string, error) {
+func ipfsGateway() string {
+	if IPFSGATEWAY != "" {
+		return IPFSGATEWAY
+	}
+	return DefaultIPFSGateway
+}
 
-	reader := bytes.NewReader(data)
-	url, err := ipfsAPI.Add(reader)
-	return url, err
+func ipfsURL(gateway, cid string) string {
+	return gateway + "/ipfs/" + cid
 }
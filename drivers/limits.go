@@ -0,0 +1,144 @@
+package drivers
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// UploadLimits caps the uploads of a driver. Zero disables the corresponding limit.
+type UploadLimits struct {
+	// MaxConcurrent number of uploads in flight at once; others wait for a slot
+	MaxConcurrent int
+	// BytesPerSec average upload bandwidth, shared by the uploads in flight
+	BytesPerSec int64
+}
+
+// Upload bodies are read in chunks of at most this size, so that concurrent
+// uploads share the bandwidth rather than taking turns with large reads
+const limitedReadSize = 32 * 1024
+
+var limitersLock sync.RWMutex
+var limiters = make(map[string]*uploadLimiter)
+
+// SetUploadLimits applies limits to all uploads of driver, eg "s3", "google" or "ipfs"
+func SetUploadLimits(driver string, limits UploadLimits) {
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+	if limits.MaxConcurrent <= 0 && limits.BytesPerSec <= 0 {
+		delete(limiters, driver)
+		return
+	}
+	l := &uploadLimiter{limits: limits, tokens: float64(limits.BytesPerSec), last: time.Now()}
+	if limits.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrent)
+	}
+	limiters[driver] = l
+}
+
+func getLimiter(driver string) *uploadLimiter {
+	limitersLock.RLock()
+	defer limitersLock.RUnlock()
+	return limiters[driver]
+}
+
+// waitUpload blocks until driver has a slot to upload in. The returned func
+// has to be called once the upload is done.
+func waitUpload(driver string) func() {
+	l := getLimiter(driver)
+	if l == nil || l.slots == nil {
+		return func() {}
+	}
+	l.slots <- struct{}{}
+	return func() { <-l.slots }
+}
+
+// limitReader returns r with its reads held to the upload bandwidth of driver
+func limitReader(driver string, r io.Reader) io.Reader {
+	l := getLimiter(driver)
+	if l == nil || l.limits.BytesPerSec <= 0 {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+// limitClient returns a copy of client whose request bodies are held to the
+// upload bandwidth of driver. Bodies are limited as the transport sends them,
+// so reads made before, such as the AWS SDK hashing a body to sign it, don't
+// count towards the bandwidth.
+func limitClient(driver string, client *http.Client) *http.Client {
+	c := *client
+	c.Transport = &limitedTransport{driver: driver, base: client.Transport}
+	return &c
+}
+
+type uploadLimiter struct {
+	limits UploadLimits
+	slots  chan struct{}
+
+	lock sync.Mutex
+	// bytes that may be read without waiting; a token bucket holding up to
+	// a second of bandwidth, negative while reads are waiting on it
+	tokens float64
+	last   time.Time
+}
+
+// take waits until n bytes may be read without going over BytesPerSec
+func (l *uploadLimiter) take(n int) {
+	rate := float64(l.limits.BytesPerSec)
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / rate * float64(time.Second))
+	l.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *uploadLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitedReadSize {
+		p = p[:limitedReadSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.l.take(n)
+	}
+	return n, err
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+type limitedTransport struct {
+	driver string
+	base   http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	l := getLimiter(t.driver)
+	if l == nil || l.limits.BytesPerSec <= 0 || req.Body == nil || req.Body == http.NoBody {
+		return base.RoundTrip(req)
+	}
+	// Requests are not to be modified by round trippers
+	r := *req
+	r.Body = limitedReadCloser{Reader: &limitedReader{r: req.Body, l: l}, Closer: req.Body}
+	return base.RoundTrip(&r)
+}
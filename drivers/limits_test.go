@@ -0,0 +1,110 @@
+package drivers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadLimits_MaxConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	SetUploadLimits("test", UploadLimits{MaxConcurrent: 2})
+	defer SetUploadLimits("test", UploadLimits{})
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := waitUpload("test")
+			defer done()
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(2, maxInFlight)
+
+	// Without limits uploads don't wait for each other
+	SetUploadLimits("test", UploadLimits{})
+	for i := 0; i < 6; i++ {
+		waitUpload("test")
+	}
+}
+
+func TestUploadLimits_BytesPerSec(t *testing.T) {
+	assert := assert.New(t)
+	SetUploadLimits("test", UploadLimits{BytesPerSec: 100 * 1024})
+	defer SetUploadLimits("test", UploadLimits{})
+
+	// A second of bandwidth goes through at once, the rest at BytesPerSec;
+	// two uploads of 75KB share the 100KB/s
+	data := make([]byte, 75*1024)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read, err := ioutil.ReadAll(limitReader("test", bytes.NewReader(data)))
+			assert.Nil(err)
+			assert.Equal(data, read)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	assert.True(elapsed >= 400*time.Millisecond, "elapsed %v", elapsed)
+	assert.True(elapsed < time.Second, "elapsed %v", elapsed)
+
+	// Without limits bodies are read as they are
+	r := bytes.NewReader(data)
+	assert.Equal(r, limitReader("other", r))
+}
+
+func TestS3PutPresigned_BytesPerSec(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	SetUploadLimits("s3", UploadLimits{BytesPerSec: 100 * 1024})
+	defer SetUploadLimits("s3", UploadLimits{})
+
+	data := make([]byte, 150*1024)
+	var received []byte
+	var contentLength int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentLength = r.ContentLength
+		received, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer ts.Close()
+
+	info := &net.S3OSInfo{
+		Host:          ts.URL,
+		Key:           "mid",
+		PresignedUrls: map[string]string{"0.ts": ts.URL + "/mid/0.ts?X-Amz-Signature=sig"},
+	}
+	start := time.Now()
+	_, err := newS3Session(info).SaveData("0.ts", data)
+	require.Nil(err)
+	// The body is held to the bandwidth as it's sent, not only before
+	elapsed := time.Since(start)
+	assert.True(elapsed >= 400*time.Millisecond, "elapsed %v", elapsed)
+	assert.Equal(data, received)
+	assert.Equal(int64(len(data)), contentLength)
+}
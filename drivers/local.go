@@ -1,11 +1,14 @@
 package drivers
 
 import (
+	"container/list"
 	"fmt"
 	"net/url"
 	"path"
 	"sync"
+	"time"
 
+	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
 )
 
@@ -15,6 +18,8 @@ type MemoryOS struct {
 	baseURI  *url.URL
 	sessions map[string]*MemorySession
 	lock     sync.RWMutex
+	policy   RetentionPolicy
+	lru      *memoryLRU
 }
 
 type MemorySession struct {
@@ -30,6 +35,7 @@ func NewMemoryDriver(baseURI *url.URL) *MemoryOS {
 		baseURI:  baseURI,
 		sessions: make(map[string]*MemorySession),
 		lock:     sync.RWMutex{},
+		lru:      newMemoryLRU(),
 	}
 }
 
@@ -62,7 +68,10 @@ func (ostore *MemoryOS) GetSession(path string) *MemorySession {
 func (ostore *MemorySession) EndSession() {
 	ostore.dLock.Lock()
 	ostore.ended = true
-	for k := range ostore.dCache {
+	for k, dc := range ostore.dCache {
+		for _, item := range dc.cache {
+			ostore.os.lru.remove(item)
+		}
 		delete(ostore.dCache, k)
 	}
 	ostore.dLock.Unlock()
@@ -80,7 +89,13 @@ func (ostore *MemorySession) GetData(name string) []byte {
 	defer ostore.dLock.RUnlock()
 
 	if cache, ok := ostore.dCache[path]; ok {
-		return cache.GetData(file)
+		if item := cache.getItem(file); item != nil {
+			ostore.os.lru.touch(item)
+			return item.data
+		}
+	}
+	if monitor.Enabled && ostore.os.lru.wasEvicted(path, file) {
+		monitor.StorageEvictedRequested()
 	}
 	return nil
 }
@@ -94,25 +109,53 @@ func (ostore *MemorySession) GetInfo() *net.OSInfo {
 }
 
 func (ostore *MemorySession) SaveData(name string, data []byte) (string, error) {
+	start := time.Now()
 	path, file := path.Split(ostore.getAbsolutePath(name))
+	policy := ostore.os.retentionPolicy()
 
 	ostore.dLock.Lock()
-	defer ostore.dLock.Unlock()
-
 	if ostore.ended {
-		return "", fmt.Errorf("Session ended")
+		ostore.dLock.Unlock()
+		err := fmt.Errorf("Session ended")
+		recordRequest("memory", "", opUpload, len(data), start, err)
+		return "", err
+	}
+
+	dc := ostore.getCacheForStream(path, policy)
+	item, removed, evicted := dc.Insert(file, data)
+	if removed != nil {
+		ostore.os.lru.remove(removed)
+	}
+	victims := ostore.os.lru.add(ostore, path, item, policy.MaxBytes, policy.StreamMaxBytes)
+	ostore.dLock.Unlock()
+	if evicted && monitor.Enabled {
+		monitor.StorageEvicted(monitor.StorageEvictionSegments, 1)
 	}
 
-	dc := ostore.getCacheForStream(path)
-	dc.Insert(file, data)
+	// Evicted data may belong to any session, so is removed outside of our lock
+	for _, v := range victims {
+		v.session.dLock.Lock()
+		if dc, ok := v.session.dCache[v.stream]; ok {
+			dc.remove(v.item)
+		}
+		v.session.dLock.Unlock()
+	}
+	if len(victims) > 0 && monitor.Enabled {
+		monitor.StorageEvicted(monitor.StorageEvictionBytes, int64(len(victims)))
+	}
+	recordRequest("memory", "", opUpload, len(data), start, nil)
 
 	return ostore.getAbsoluteURI(name), nil
 }
 
-func (ostore *MemorySession) getCacheForStream(streamID string) *dataCache {
+func (ostore *MemorySession) getCacheForStream(streamID string, policy RetentionPolicy) *dataCache {
 	sc, ok := ostore.dCache[streamID]
 	if !ok {
-		sc = newDataCache(dataCacheLen)
+		cacheLen := dataCacheLen
+		if policy.MaxSegments > 0 {
+			cacheLen = policy.MaxSegments
+		}
+		sc = newDataCache(cacheLen)
 		ostore.dCache[streamID] = sc
 	}
 	return sc
@@ -136,33 +179,51 @@ type dataCache struct {
 }
 
 type dataCacheItem struct {
-	name string
-	data []byte
+	name  string
+	data  []byte
+	saved time.Time
+	elem  *list.Element // position in the LRU; guarded by the LRU lock
 }
 
 func newDataCache(len int) *dataCache {
 	return &dataCache{cacheLen: len, cache: make([]*dataCacheItem, 0)}
 }
 
-func (dc *dataCache) Insert(name string, data []byte) {
+// Insert adds data into the cache, replacing an item with the same name.
+// Returns the new item, the item it replaced or evicted to make room, and
+// whether the removed item was evicted.
+func (dc *dataCache) Insert(name string, data []byte) (*dataCacheItem, *dataCacheItem, bool) {
+	var removed *dataCacheItem
+	evicted := false
 	// replace existing item
-	for _, item := range dc.cache {
+	for i, item := range dc.cache {
 		if item.name == name {
-			item.data = data
-			return
+			removed = item
+			dc.cache = append(dc.cache[:i], dc.cache[i+1:]...)
+			break
 		}
 	}
-	if len(dc.cache) >= dc.cacheLen {
+	if removed == nil && len(dc.cache) >= dc.cacheLen {
+		removed = dc.cache[0]
 		dc.cache = dc.cache[1:]
+		evicted = true
 	}
-	item := &dataCacheItem{name: name, data: data}
+	item := &dataCacheItem{name: name, data: data, saved: time.Now()}
 	dc.cache = append(dc.cache, item)
+	return item, removed, evicted
 }
 
 func (dc *dataCache) GetData(name string) []byte {
+	if item := dc.getItem(name); item != nil {
+		return item.data
+	}
+	return nil
+}
+
+func (dc *dataCache) getItem(name string) *dataCacheItem {
 	for _, s := range dc.cache {
 		if s.name == name {
-			return s.data
+			return s
 		}
 	}
 	return nil
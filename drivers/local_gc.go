@@ -0,0 +1,116 @@
+package drivers
+
+import (
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+)
+
+var memoryGCInterval = 10 * time.Second
+var getMemoryGCTicker = func() *time.Ticker {
+	return time.NewTicker(memoryGCInterval)
+}
+
+// RetentionPolicy limits the data kept by the memory storage. Zero disables
+// the corresponding limit.
+type RetentionPolicy struct {
+	// MaxBytes total size of the data kept across all sessions; least
+	// recently used data is evicted first
+	MaxBytes int64
+	// StreamMaxBytes size of the data kept for a single session
+	StreamMaxBytes int64
+	// MaxAge how long data is kept after being saved
+	MaxAge time.Duration
+	// MaxSegments number of segments kept per playlist of a stream
+	MaxSegments int
+}
+
+// SetRetentionPolicy applies policy to all data saved from now on and starts
+// the loop that evicts expired data
+func (ostore *MemoryOS) SetRetentionPolicy(policy RetentionPolicy) {
+	ostore.lock.Lock()
+	ostore.policy = policy
+	ostore.lock.Unlock()
+
+	ticker := getMemoryGCTicker()
+	go func() {
+		for range ticker.C {
+			ostore.gc()
+		}
+	}()
+}
+
+func (ostore *MemoryOS) retentionPolicy() RetentionPolicy {
+	ostore.lock.RLock()
+	defer ostore.lock.RUnlock()
+	return ostore.policy
+}
+
+// gc evicts data older than MaxAge; size limits are enforced as data is
+// saved. Returns the size of the data left.
+func (ostore *MemoryOS) gc() int64 {
+	ostore.lock.RLock()
+	policy := ostore.policy
+	sessions := make([]*MemorySession, 0, len(ostore.sessions))
+	for _, session := range ostore.sessions {
+		sessions = append(sessions, session)
+	}
+	ostore.lock.RUnlock()
+
+	var expired int64
+	now := time.Now()
+	for _, session := range sessions {
+		session.dLock.Lock()
+		for stream, dc := range session.dCache {
+			if policy.MaxAge > 0 {
+				for _, item := range dc.removeOlder(now.Add(-policy.MaxAge)) {
+					ostore.lru.remove(item)
+					expired++
+				}
+			}
+			if len(dc.cache) == 0 {
+				delete(session.dCache, stream)
+			}
+		}
+		session.dLock.Unlock()
+	}
+
+	usage := ostore.lru.usage()
+	if expired > 0 {
+		glog.V(common.DEBUG).Infof("Evicted expired data from memory storage count=%d bytesLeft=%d", expired, usage)
+	}
+	if monitor.Enabled {
+		if expired > 0 {
+			monitor.StorageEvicted(monitor.StorageEvictionAge, expired)
+		}
+		monitor.StorageUsage(usage)
+	}
+	return usage
+}
+
+// removeOlder removes and returns items saved before t
+func (dc *dataCache) removeOlder(t time.Time) []*dataCacheItem {
+	var removed []*dataCacheItem
+	kept := make([]*dataCacheItem, 0, len(dc.cache))
+	for _, item := range dc.cache {
+		if item.saved.Before(t) {
+			removed = append(removed, item)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	dc.cache = kept
+	return removed
+}
+
+func (dc *dataCache) remove(item *dataCacheItem) bool {
+	for i, it := range dc.cache {
+		if it == item {
+			dc.cache = append(dc.cache[:i], dc.cache[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package drivers
+
+import (
+	"container/list"
+	"sync"
+)
+
+// evictedHistoryLen how many evicted names are remembered to detect requests for evicted data
+var evictedHistoryLen = 1024
+
+// memoryLRU tracks usage of the memory storage across all sessions, and
+// picks data to evict when the storage or a single stream is over quota
+type memoryLRU struct {
+	lock        sync.Mutex
+	items       *list.List // of *lruEntry; front is most recently used
+	size        int64
+	sessionSize map[*MemorySession]int64
+
+	evicted     map[string]bool
+	evictedList []string
+}
+
+type lruEntry struct {
+	session *MemorySession
+	stream  string
+	item    *dataCacheItem
+}
+
+func newMemoryLRU() *memoryLRU {
+	return &memoryLRU{
+		items:       list.New(),
+		sessionSize: make(map[*MemorySession]int64),
+		evicted:     make(map[string]bool),
+	}
+}
+
+// add starts tracking item, saved into stream of session. Returns entries
+// that have to be evicted to make the storage fit into maxBytes and the
+// session into streamMaxBytes; the item just added is never evicted.
+func (l *memoryLRU) add(session *MemorySession, stream string, item *dataCacheItem, maxBytes, streamMaxBytes int64) []*lruEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	item.elem = l.items.PushFront(&lruEntry{session: session, stream: stream, item: item})
+	delete(l.evicted, stream+item.name)
+	l.size += int64(len(item.data))
+	l.sessionSize[session] += int64(len(item.data))
+
+	var victims []*lruEntry
+	if streamMaxBytes > 0 {
+		for e := l.items.Back(); e != nil && e != item.elem && l.sessionSize[session] > streamMaxBytes; {
+			prev := e.Prev()
+			if entry := e.Value.(*lruEntry); entry.session == session {
+				victims = append(victims, l.evict(entry))
+			}
+			e = prev
+		}
+	}
+	if maxBytes > 0 {
+		for l.size > maxBytes && l.items.Back() != item.elem {
+			victims = append(victims, l.evict(l.items.Back().Value.(*lruEntry)))
+		}
+	}
+	return victims
+}
+
+func (l *memoryLRU) evict(entry *lruEntry) *lruEntry {
+	l.removeLocked(entry.item)
+	key := entry.stream + entry.item.name
+	if !l.evicted[key] {
+		if len(l.evictedList) >= evictedHistoryLen {
+			delete(l.evicted, l.evictedList[0])
+			l.evictedList = l.evictedList[1:]
+		}
+		l.evicted[key] = true
+		l.evictedList = append(l.evictedList, key)
+	}
+	return entry
+}
+
+// touch marks item as most recently used
+func (l *memoryLRU) touch(item *dataCacheItem) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if item.elem != nil {
+		l.items.MoveToFront(item.elem)
+	}
+}
+
+// remove stops tracking item, e.g. when it's removed from its cache
+func (l *memoryLRU) remove(item *dataCacheItem) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.removeLocked(item)
+}
+
+func (l *memoryLRU) removeLocked(item *dataCacheItem) {
+	if item.elem == nil {
+		return
+	}
+	entry := l.items.Remove(item.elem).(*lruEntry)
+	item.elem = nil
+	l.size -= int64(len(item.data))
+	if l.sessionSize[entry.session] -= int64(len(item.data)); l.sessionSize[entry.session] <= 0 {
+		delete(l.sessionSize, entry.session)
+	}
+}
+
+// wasEvicted returns true if name in stream was recently evicted due to quotas
+func (l *memoryLRU) wasEvicted(stream, name string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.evicted[stream+name]
+}
+
+func (l *memoryLRU) usage() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.size
+}
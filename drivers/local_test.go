@@ -0,0 +1,111 @@
+package drivers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryOS_RetentionPolicy(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func() *time.Ticker) { getMemoryGCTicker = f }(getMemoryGCTicker)
+	// Collected by hand rather than on the ticker
+	getMemoryGCTicker = func() *time.Ticker { return &time.Ticker{} }
+
+	mem := NewMemoryDriver(nil)
+	mem.SetRetentionPolicy(RetentionPolicy{MaxSegments: 2, MaxAge: time.Minute})
+	sess := mem.NewSession("mid")
+	for _, name := range []string{"0.ts", "1.ts", "2.ts"} {
+		_, err := sess.SaveData(name, []byte(name))
+		assert.Nil(err)
+	}
+	msess := sess.(*MemorySession)
+
+	// Only the last MaxSegments are kept
+	assert.Nil(msess.GetData("mid/0.ts"))
+	assert.Equal([]byte("1.ts"), msess.GetData("mid/1.ts"))
+	assert.Equal([]byte("2.ts"), msess.GetData("mid/2.ts"))
+	assert.Equal(int64(8), mem.gc())
+
+	// and for no longer than MaxAge
+	msess.dLock.Lock()
+	msess.dCache["mid/"].cache[0].saved = time.Now().Add(-2 * time.Minute)
+	msess.dLock.Unlock()
+	assert.Equal(int64(4), mem.gc())
+	assert.Nil(msess.GetData("mid/1.ts"))
+	assert.Equal([]byte("2.ts"), msess.GetData("mid/2.ts"))
+
+	msess.dLock.Lock()
+	msess.dCache["mid/"].cache[0].saved = time.Now().Add(-2 * time.Minute)
+	msess.dLock.Unlock()
+	assert.Equal(int64(0), mem.gc())
+	assert.Empty(msess.dCache)
+}
+
+func TestMemoryOS_LRU(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func() *time.Ticker) { getMemoryGCTicker = f }(getMemoryGCTicker)
+	getMemoryGCTicker = func() *time.Ticker { return &time.Ticker{} }
+
+	mem := NewMemoryDriver(nil)
+	mem.SetRetentionPolicy(RetentionPolicy{MaxBytes: 10, StreamMaxBytes: 6})
+	sess1 := mem.NewSession("mid1").(*MemorySession)
+	sess2 := mem.NewSession("mid2").(*MemorySession)
+	save := func(sess OSSession, name string) {
+		_, err := sess.SaveData(name, []byte("0123"))
+		assert.Nil(err)
+	}
+
+	// Streams are kept within their quota
+	save(sess1, "0.ts")
+	save(sess1, "1.ts")
+	assert.Nil(sess1.GetData("mid1/0.ts"))
+	assert.True(mem.lru.wasEvicted("mid1/", "0.ts"))
+	assert.NotNil(sess1.GetData("mid1/1.ts"))
+
+	// and the storage within its own, evicting what was used least recently
+	save(sess2, "0.ts")
+	assert.NotNil(sess1.GetData("mid1/1.ts"))
+	save(sess2, "1.ts")
+	assert.Nil(sess2.GetData("mid2/0.ts"))
+	assert.NotNil(sess1.GetData("mid1/1.ts"))
+	assert.NotNil(sess2.GetData("mid2/1.ts"))
+	assert.Equal(int64(8), mem.lru.usage())
+
+	// Saving a segment again replaces it
+	save(sess2, "1.ts")
+	assert.Equal(int64(8), mem.lru.usage())
+
+	// Ended sessions free their data
+	sess1.EndSession()
+	assert.Equal(int64(4), mem.lru.usage())
+	_, err := sess1.SaveData("2.ts", []byte("0123"))
+	assert.NotNil(err)
+}
+
+func TestMemoryOS_SetRetentionPolicyWhileSaving(t *testing.T) {
+	assert := assert.New(t)
+	defer func(f func() *time.Ticker) { getMemoryGCTicker = f }(getMemoryGCTicker)
+	getMemoryGCTicker = func() *time.Ticker { return &time.Ticker{} }
+
+	// Run with -race: the policy may be set while data is saved
+	mem := NewMemoryDriver(nil)
+	sess := mem.NewSession("mid")
+	done := make(chan struct{})
+	go func() {
+		mem.SetRetentionPolicy(RetentionPolicy{MaxBytes: 8})
+		close(done)
+	}()
+	for i := 0; i < 4; i++ {
+		_, err := sess.SaveData(fmt.Sprintf("%d.ts", i), []byte("0123"))
+		assert.Nil(err)
+	}
+	<-done
+
+	// and applies to what's saved from then on
+	_, err := sess.SaveData("4.ts", []byte("0123"))
+	assert.Nil(err)
+	assert.Equal(int64(8), mem.lru.usage())
+}
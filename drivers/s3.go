@@ -3,19 +3,22 @@ package drivers
 import (
 	"bytes"
 	"crypto/hmac"
+	"crypto/md5"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"path"
 	"strings"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/net"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -27,9 +30,14 @@ import (
 // S3_POLICY_EXPIRE_IN_HOURS how long access rights given to other node will be valid
 const S3_POLICY_EXPIRE_IN_HOURS = 24
 
-/* S3OS S# backed object storage driver. For own storage access key and access key secret
-   should be specified. To give to other nodes access to own S3 storage so called 'POST' policy
-   is created. This policy is valid for S3_POLICY_EXPIRE_IN_HOURS hours.
+// S3_PRESIGNED_URL_EXPIRE how long pre-signed upload URLs given to other node will be valid
+const S3_PRESIGNED_URL_EXPIRE = 10 * time.Minute
+
+/*
+S3OS S# backed object storage driver. For own storage access key and access key secret
+
+	should be specified. To give to other nodes access to own S3 storage so called 'POST' policy
+	is created. This policy is valid for S3_POLICY_EXPIRE_IN_HOURS hours.
 */
 type s3OS struct {
 	host               string
@@ -38,6 +46,37 @@ type s3OS struct {
 	awsAccessKeyID     string
 	awsSecretAccessKey string
 	s3svc              *s3.S3
+	client             *http.Client
+}
+
+// S3Config describes an S3 compatible bucket. Endpoint is left empty for AWS;
+// for other providers (MinIO, Backblaze B2, Wasabi, ...) it's the base URL of
+// the service, e.g. https://minio.example.com:9000
+type S3Config struct {
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	AccessKeySecret string
+	// PathStyle addresses the bucket as Endpoint/Bucket instead of Bucket.Endpoint
+	PathStyle bool
+	// InsecureSkipVerify disables TLS certificate verification, for internal deployments
+	InsecureSkipVerify bool
+}
+
+// BucketURL returns the base URL of the objects stored in the bucket
+func (cfg S3Config) BucketURL() string {
+	if cfg.Endpoint == "" {
+		return s3Host(cfg.Bucket)
+	}
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.PathStyle {
+		return endpoint + "/" + cfg.Bucket
+	}
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		return endpoint[:i+3] + cfg.Bucket + "." + endpoint[i+3:]
+	}
+	return "https://" + cfg.Bucket + "." + endpoint
 }
 
 type s3Session struct {
@@ -49,18 +88,44 @@ type s3Session struct {
 	xAmzDate    string
 	storageType net.OSInfo_StorageType
 	fields      map[string]string
+
+	// Only set for our own storage; enables multipart uploads and pre-signing
+	bucket string
+	s3svc  *s3.S3
+	client *http.Client
+
+	// Pre-signed PUT URLs received from other node, keyed by object name
+	presigned map[string]string
+}
+
+// PresignedSession is implemented by sessions that can give other node
+// write access to individual objects rather than to the whole session
+type PresignedSession interface {
+	// GetPresignedInfo returns info with upload URLs for names, or nil if
+	// the session can't pre-sign
+	GetPresignedInfo(names []string) (*net.OSInfo, error)
 }
 
 // S3BUCKET s3 bucket owned by this node
 var S3BUCKET string
 
+// S3HOST base URL of the s3 bucket owned by this node, if it's not on AWS
+var S3HOST string
+
+// S3PresignUploads makes broadcaster give pre-signed PUT URLs to orchestrators instead of the POST policy
+var S3PresignUploads bool
+
 func s3Host(bucket string) string {
 	return fmt.Sprintf("https://%s.s3.amazonaws.com", bucket)
 }
 
 // IsOwnStorageS3 returns true if uri points to S3 bucket owned by this node
 func IsOwnStorageS3(uri string) bool {
-	return strings.HasPrefix(uri, s3Host(S3BUCKET))
+	host := S3HOST
+	if host == "" {
+		host = s3Host(S3BUCKET)
+	}
+	return strings.HasPrefix(uri, host)
 }
 
 func newS3Session(info *net.S3OSInfo) OSSession {
@@ -72,23 +137,57 @@ func newS3Session(info *net.S3OSInfo) OSSession {
 		xAmzDate:    info.XAmzDate,
 		credential:  info.Credential,
 		storageType: net.OSInfo_S3,
+		presigned:   info.PresignedUrls,
 	}
 	sess.fields = s3GetFields(sess)
 	return sess
 }
 
+// S3BucketCredentials reads the access key and secret of bucket from the
+// profile named after it in the AWS shared credentials file at file, so each
+// bucket can have its own keys
+func S3BucketCredentials(file, bucket string) (string, string, error) {
+	creds, err := credentials.NewSharedCredentials(file, bucket).Get()
+	if err != nil {
+		return "", "", err
+	}
+	// POST policies are signed without a session token
+	if creds.SessionToken != "" {
+		return "", "", fmt.Errorf("temporary credentials of profile %s aren't supported", bucket)
+	}
+	return creds.AccessKeyID, creds.SecretAccessKey, nil
+}
+
 func NewS3Driver(region, bucket, accessKey, accessKeySecret string) OSDriver {
+	return NewS3DriverWithConfig(S3Config{
+		Region:          region,
+		Bucket:          bucket,
+		AccessKeyID:     accessKey,
+		AccessKeySecret: accessKeySecret,
+	})
+}
+
+// NewS3DriverWithConfig returns a driver for any S3 compatible bucket
+func NewS3DriverWithConfig(cfg S3Config) OSDriver {
+	client := &http.Client{}
+	if cfg.InsecureSkipVerify {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
 	os := &s3OS{
-		host:               s3Host(bucket),
-		region:             region,
-		bucket:             bucket,
-		awsAccessKeyID:     accessKey,
-		awsSecretAccessKey: accessKeySecret,
+		host:               cfg.BucketURL(),
+		region:             cfg.Region,
+		bucket:             cfg.Bucket,
+		awsAccessKeyID:     cfg.AccessKeyID,
+		awsSecretAccessKey: cfg.AccessKeySecret,
+		client:             client,
 	}
 	if os.awsAccessKeyID != "" {
 		creds := credentials.NewStaticCredentials(os.awsAccessKeyID, os.awsSecretAccessKey, "")
-		cfg := aws.NewConfig().WithRegion(os.region).WithCredentials(creds)
-		os.s3svc = s3.New(session.New(), cfg)
+		awsCfg := aws.NewConfig().WithRegion(os.region).WithCredentials(creds).WithHTTPClient(limitClient("s3", client))
+		if cfg.Endpoint != "" {
+			awsCfg = awsCfg.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(cfg.PathStyle)
+		}
+		os.s3svc = s3.New(session.New(), awsCfg)
 	}
 	return os
 }
@@ -97,13 +196,16 @@ func (os *s3OS) NewSession(path string) OSSession {
 	policy, signature, credential, xAmzDate := createPolicy(os.awsAccessKeyID,
 		os.bucket, os.region, os.awsSecretAccessKey, path)
 	sess := &s3Session{
-		host:        s3Host(os.bucket),
+		host:        os.host,
 		key:         path,
 		policy:      policy,
 		signature:   signature,
 		credential:  credential,
 		xAmzDate:    xAmzDate,
 		storageType: net.OSInfo_S3,
+		bucket:      os.bucket,
+		s3svc:       os.s3svc,
+		client:      os.client,
 	}
 	sess.fields = s3GetFields(sess)
 	return sess
@@ -129,7 +231,21 @@ func (os *s3Session) SaveData(name string, data []byte) (string, error) {
 	// tentativeUrl just used for logging
 	tentativeURL := path.Join(os.host, os.key, name)
 	glog.V(common.VERBOSE).Infof("Saving to S3 %s", tentativeURL)
-	path, err := os.postData(name, data)
+	driver := strings.ToLower(os.storageType.String())
+	defer waitUpload(driver)()
+	start := time.Now()
+	var path string
+	var err error
+	if uri, ok := os.presigned[name]; ok {
+		path, err = os.putPresigned(uri, name, data)
+	} else if os.s3svc != nil && len(data) > S3_MULTIPART_THRESHOLD {
+		path, err = os.multipartUpload(name, data)
+	} else if os.s3svc != nil {
+		path, err = os.putObject(name, data)
+	} else {
+		path, err = os.postData(name, data)
+	}
+	recordRequest(driver, strings.TrimPrefix(os.host, "https://"), opUpload, len(data), start, err)
 	if err != nil {
 		// handle error
 		glog.Errorf("Save S3 error: %v", err)
@@ -142,6 +258,14 @@ func (os *s3Session) SaveData(name string, data []byte) (string, error) {
 	return url, err
 }
 
+func (os *s3Session) httpClient() *http.Client {
+	client := http.DefaultClient
+	if os.client != nil {
+		client = os.client
+	}
+	return limitClient(strings.ToLower(os.storageType.String()), client)
+}
+
 func (os *s3Session) getAbsURL(path string) string {
 	return os.host + "/" + path
 }
@@ -161,6 +285,81 @@ func (os *s3Session) GetInfo() *net.OSInfo {
 	return oi
 }
 
+// GetPresignedInfo creates pre-signed PUT URLs for names within the session key
+func (os *s3Session) GetPresignedInfo(names []string) (*net.OSInfo, error) {
+	if os.s3svc == nil {
+		return nil, nil
+	}
+	urls := make(map[string]string, len(names))
+	for _, name := range names {
+		req, _ := os.s3svc.PutObjectRequest(&s3.PutObjectInput{
+			Bucket: aws.String(os.bucket),
+			Key:    aws.String(path.Join(os.key, name)),
+			ACL:    aws.String("public-read"),
+		})
+		uri, err := req.Presign(S3_PRESIGNED_URL_EXPIRE)
+		if err != nil {
+			return nil, err
+		}
+		urls[name] = uri
+	}
+	return &net.OSInfo{
+		S3Info: &net.S3OSInfo{
+			Host:          os.host,
+			Key:           os.key,
+			PresignedUrls: urls,
+		},
+		StorageType: os.storageType,
+	}, nil
+}
+
+// putPresigned uploads data to the pre-signed URL given to us by the storage owner
+func (os *s3Session) putPresigned(uri, fileName string, data []byte) (string, error) {
+	req, err := http.NewRequest("PUT", uri, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	// ACL is part of the signature so it has to match
+	req.Header.Set("x-amz-acl", "public-read")
+	req.Header.Set("Content-Type", http.DetectContentType(data))
+	// Unsigned, but still checked by S3 against the data received. The
+	// SHA-256 can't be stored with the object, as metadata has to be signed
+	// along with the URL, before the data is known; the object is verified
+	// against its ETag, the MD5 S3 checked, instead.
+	sum := md5.Sum(data)
+	req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	resp, err := os.httpClient().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("S3 PUT failed status=%v body=%s", resp.Status, body)
+	}
+	return path.Join(os.key, fileName), nil
+}
+
+// putObject saves data into our own bucket along with its SHA-256. The MD5
+// is sent as well so S3 rejects data corrupted on the way.
+func (os *s3Session) putObject(fileName string, data []byte) (string, error) {
+	key := path.Join(os.key, fileName)
+	sum := md5.Sum(data)
+	_, err := os.s3svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(os.bucket),
+		Key:         aws.String(key),
+		ACL:         aws.String("public-read"),
+		ContentType: aws.String(http.DetectContentType(data)),
+		ContentMD5:  aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+		Metadata:    map[string]*string{checksumMetaKey: aws.String(Checksum(data))},
+		Body:        bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
 // if s3 storage is not our own, we are saving data into it using POST request
 func (os *s3Session) postData(fileName string, buffer []byte) (string, error) {
 	fileBytes := bytes.NewReader(buffer)
@@ -175,13 +374,17 @@ func (os *s3Session) postData(fileName string, buffer []byte) (string, error) {
 	for k, v := range os.fields {
 		fields[k] = v
 	}
+	// Policies of nodes from before checksums were stored don't allow the
+	// field, and S3 rejects uploads with fields their policy doesn't allow
+	if os.storageType == net.OSInfo_S3 && policyAllows(os.policy, s3ChecksumHeader) {
+		fields[s3ChecksumHeader] = Checksum(buffer)
+	}
 	req, err := newfileUploadRequest(os.host, fields, fileBytes, fileName)
 	if err != nil {
 		glog.Error(err)
 		return "", err
 	}
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := os.httpClient().Do(req)
 	if err != nil {
 		glog.Error(err)
 		return "", err
@@ -201,6 +404,15 @@ func (os *s3Session) postData(fileName string, buffer []byte) (string, error) {
 	return path + fileName, err
 }
 
+// policyAllows is whether the base64 POST policy has a condition on field
+func policyAllows(policy, field string) bool {
+	src, err := base64.StdEncoding.DecodeString(policy)
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(src), `"$`+field+`"`)
+}
+
 func makeHmac(key []byte, data []byte) []byte {
 	hash := hmac.New(sha256.New, key)
 	hash.Write(data)
@@ -232,6 +444,7 @@ func createPolicy(key, bucket, region, secret, path string) (string, string, str
       {"acl": "public-read"},
       ["starts-with", "$Content-Type", ""],
       ["starts-with", "$key", "%s"],
+      ["starts-with", "$x-amz-meta-sha256", ""],
       {"x-amz-algorithm": "AWS4-HMAC-SHA256"},
       {"x-amz-credential": "%s"},
       {"x-amz-date": "%sT000000Z" }
@@ -0,0 +1,47 @@
+package drivers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3BucketCredentials(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "s3creds")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials")
+	require.Nil(ioutil.WriteFile(file, []byte(`
+[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+[segments]
+aws_access_key_id = SEGMENTSKEY
+aws_secret_access_key = segments/secret
+
+[temporary]
+aws_access_key_id = TEMPKEY
+aws_secret_access_key = tempsecret
+aws_session_token = token
+`), 0600))
+
+	// Each bucket has the keys of its own profile
+	key, secret, err := S3BucketCredentials(file, "segments")
+	require.Nil(err)
+	assert.Equal("SEGMENTSKEY", key)
+	assert.Equal("segments/secret", secret)
+
+	_, _, err = S3BucketCredentials(file, "recordings")
+	assert.NotNil(err)
+	_, _, err = S3BucketCredentials(file, "temporary")
+	assert.EqualError(err, "temporary credentials of profile temporary aren't supported")
+	_, _, err = S3BucketCredentials(filepath.Join(dir, "missing"), "segments")
+	assert.NotNil(err)
+}
@@ -0,0 +1,152 @@
+package drivers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3_MULTIPART_THRESHOLD objects larger than this are uploaded in parts
+const S3_MULTIPART_THRESHOLD = 16 * 1024 * 1024
+
+// S3_MULTIPART_PART_SIZE size of every part but the last one; S3 requires at least 5MB
+const S3_MULTIPART_PART_SIZE = 8 * 1024 * 1024
+
+// S3_MULTIPART_RETRIES how many times a single part is retried before the upload is aborted
+const S3_MULTIPART_RETRIES = 4
+
+// s3MultipartBackoff delay before the first retry of a part; doubled on every next attempt
+var s3MultipartBackoff = 500 * time.Millisecond
+
+// s3KMSEncryption is the server side encryption of objects encrypted with
+// KMS keys, whose ETags aren't the MD5 of their data
+const s3KMSEncryption = "aws:kms"
+
+// multipartUpload saves data into our own bucket as a multipart upload.
+// Every part is sent with its MD5 so S3 rejects corrupted parts, and the
+// returned ETags are checked against the local checksums, unless the bucket
+// encrypts objects with KMS. If any part can't be uploaded the upload is
+// aborted so S3 discards stored parts; an object whose ETag doesn't match
+// once completed is deleted.
+func (os *s3Session) multipartUpload(fileName string, data []byte) (string, error) {
+	key := path.Join(os.key, fileName)
+	create, err := os.s3svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(os.bucket),
+		Key:         aws.String(key),
+		ACL:         aws.String("public-read"),
+		ContentType: aws.String(http.DetectContentType(data)),
+		Metadata:    map[string]*string{checksumMetaKey: aws.String(Checksum(data))},
+	})
+	if err != nil {
+		return "", err
+	}
+	uploadID := create.UploadId
+
+	var parts []*s3.CompletedPart
+	var sums []byte
+	for num, start := int64(1), 0; start < len(data); num, start = num+1, start+S3_MULTIPART_PART_SIZE {
+		end := start + S3_MULTIPART_PART_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := md5.Sum(data[start:end])
+		etag, err := os.uploadPart(key, uploadID, num, data[start:end], sum[:])
+		if err != nil {
+			os.abortMultipartUpload(key, uploadID)
+			return "", err
+		}
+		sums = append(sums, sum[:]...)
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(num)})
+	}
+
+	complete, err := os.s3svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(os.bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		os.abortMultipartUpload(key, uploadID)
+		return "", err
+	}
+	if aws.StringValue(complete.ServerSideEncryption) == s3KMSEncryption {
+		return key, nil
+	}
+	// ETag of a multipart object is the MD5 of the concatenated part MD5s, suffixed by the part count
+	total := md5.Sum(sums)
+	expected := fmt.Sprintf("%s-%d", hex.EncodeToString(total[:]), len(parts))
+	if etag := strings.Trim(aws.StringValue(complete.ETag), `"`); etag != expected {
+		os.deleteObject(key)
+		return "", fmt.Errorf("checksum mismatch for %s: expected ETag %s got %s", key, expected, etag)
+	}
+	return key, nil
+}
+
+func (os *s3Session) uploadPart(key string, uploadID *string, num int64, part []byte, sum []byte) (string, error) {
+	expected := hex.EncodeToString(sum)
+	backoff := s3MultipartBackoff
+	var err error
+	for attempt := 0; attempt <= S3_MULTIPART_RETRIES; attempt++ {
+		if attempt > 0 {
+			glog.Warningf("Retrying S3 part upload key=%s part=%d attempt=%d err=%v", key, num, attempt, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var out *s3.UploadPartOutput
+		out, err = os.s3svc.UploadPart(&s3.UploadPartInput{
+			Bucket:        aws.String(os.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int64(num),
+			Body:          bytes.NewReader(part),
+			ContentLength: aws.Int64(int64(len(part))),
+			ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+		})
+		if err != nil {
+			continue
+		}
+		etag := aws.StringValue(out.ETag)
+		if aws.StringValue(out.ServerSideEncryption) != s3KMSEncryption && strings.Trim(etag, `"`) != expected {
+			err = fmt.Errorf("checksum mismatch for part %d: expected ETag %s got %s", num, expected, etag)
+			continue
+		}
+		glog.V(common.DEBUG).Infof("Uploaded S3 part key=%s part=%d size=%d", key, num, len(part))
+		return etag, nil
+	}
+	return "", err
+}
+
+func (os *s3Session) abortMultipartUpload(key string, uploadID *string) {
+	_, err := os.s3svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(os.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		glog.Errorf("Error aborting S3 multipart upload key=%s err=%v", key, err)
+	}
+}
+
+// deleteObject removes an object that was stored corrupted, so it isn't
+// served in place of the one that failed to upload
+func (os *s3Session) deleteObject(key string) {
+	_, err := os.s3svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(os.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		glog.Errorf("Error deleting corrupted S3 object key=%s err=%v", key, err)
+	}
+}
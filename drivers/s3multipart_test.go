@@ -0,0 +1,157 @@
+package drivers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves the multipart upload API of a bucket
+type fakeS3 struct {
+	mu sync.Mutex
+	// corruptParts is the number of part uploads answered with the wrong
+	// ETag, as if the part was corrupted
+	corruptParts int
+	// badETag has the completed object's ETag not match its parts
+	badETag bool
+	// kms has objects encrypted with KMS, whose ETags aren't MD5s
+	kms bool
+
+	parts     map[int64][]byte
+	uploads   int
+	completed bool
+	aborted   bool
+	deleted   bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	q := r.URL.Query()
+	if f.kms {
+		w.Header().Set("x-amz-server-side-encryption", s3KMSEncryption)
+	}
+	_, initiate := q["uploads"]
+	switch {
+	case r.Method == "POST" && initiate:
+		f.parts = make(map[int64][]byte)
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>mid/big.ts</Key><UploadId>u1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == "PUT" && q.Get("uploadId") != "":
+		num, _ := strconv.ParseInt(q.Get("partNumber"), 10, 64)
+		body, _ := ioutil.ReadAll(r.Body)
+		f.uploads++
+		sum := md5.Sum(body)
+		etag := hex.EncodeToString(sum[:])
+		if f.corruptParts > 0 {
+			f.corruptParts--
+			etag = "00000000000000000000000000000000"
+		} else {
+			f.parts[num] = body
+		}
+		if f.kms {
+			etag = "kms"
+		}
+		w.Header().Set("ETag", `"`+etag+`"`)
+	case r.Method == "POST" && q.Get("uploadId") != "":
+		f.completed = true
+		var sums []byte
+		for num := int64(1); num <= int64(len(f.parts)); num++ {
+			sum := md5.Sum(f.parts[num])
+			sums = append(sums, sum[:]...)
+		}
+		total := md5.Sum(sums)
+		etag := fmt.Sprintf("%s-%d", hex.EncodeToString(total[:]), len(f.parts))
+		if f.badETag || f.kms {
+			etag = "ffffffffffffffffffffffffffffffff-1"
+		}
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>mid/big.ts</Key><ETag>"%s"</ETag></CompleteMultipartUploadResult>`, etag)
+	case r.Method == "DELETE" && q.Get("uploadId") != "":
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "DELETE":
+		f.deleted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func TestS3MultipartUpload(t *testing.T) {
+	defer func(backoff time.Duration) { s3MultipartBackoff = backoff }(s3MultipartBackoff)
+	s3MultipartBackoff = time.Millisecond
+	data := bytes.Repeat([]byte("0123456789abcdef"), (S3_MULTIPART_THRESHOLD+S3_MULTIPART_PART_SIZE/2)/16)
+
+	upload := func(f *fakeS3) (string, error) {
+		ts := httptest.NewServer(f)
+		defer ts.Close()
+		drv := NewS3DriverWithConfig(S3Config{
+			Endpoint:        ts.URL,
+			Region:          "us-east-1",
+			Bucket:          "bucket",
+			AccessKeyID:     "key",
+			AccessKeySecret: "secret",
+			PathStyle:       true,
+		})
+		return drv.NewSession("mid").SaveData("big.ts", data)
+	}
+
+	t.Run("in parts", func(t *testing.T) {
+		f := &fakeS3{}
+		uri, err := upload(f)
+		require.Nil(t, err)
+		assert.Contains(t, uri, "/bucket/mid/big.ts")
+		assert.Len(t, f.parts, 3)
+		assert.Equal(t, data, bytes.Join([][]byte{f.parts[1], f.parts[2], f.parts[3]}, nil))
+		assert.True(t, f.completed)
+		assert.False(t, f.aborted)
+		assert.False(t, f.deleted)
+	})
+
+	t.Run("retrying corrupted parts", func(t *testing.T) {
+		f := &fakeS3{corruptParts: 2}
+		_, err := upload(f)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, f.uploads)
+		assert.True(t, f.completed)
+	})
+
+	t.Run("aborted once retries are exhausted", func(t *testing.T) {
+		f := &fakeS3{corruptParts: S3_MULTIPART_RETRIES + 1}
+		_, err := upload(f)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch for part 1")
+		assert.Equal(t, S3_MULTIPART_RETRIES+1, f.uploads)
+		assert.True(t, f.aborted)
+		assert.False(t, f.completed)
+	})
+
+	t.Run("deleted if the object doesn't match", func(t *testing.T) {
+		f := &fakeS3{badETag: true}
+		_, err := upload(f)
+		require.NotNil(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch for mid/big.ts")
+		assert.True(t, f.deleted)
+	})
+
+	t.Run("encrypted with KMS", func(t *testing.T) {
+		// ETags aren't MD5s, so aren't checked; S3 still checks the MD5
+		// each part is sent with
+		f := &fakeS3{kms: true}
+		_, err := upload(f)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, f.uploads)
+		assert.True(t, f.completed)
+		assert.False(t, f.deleted)
+	})
+}
@@ -29,8 +29,8 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/eth/contracts"
 	lpTypes "github.com/livepeer/go-livepeer/eth/types"
 	"github.com/livepeer/go-livepeer/pm"
@@ -767,20 +767,68 @@ func (c *client) ContractAddresses() map[string]ethcommon.Address {
 	return addrMap
 }
 
+// TxConfirmations how many blocks CheckTx waits for on top of the block a tx
+// is mined in
+var TxConfirmations uint64
+
 func (c *client) CheckTx(tx *types.Transaction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), c.txTimeout)
-	defer cancel()
-
 	receipt, err := bind.WaitMined(ctx, c.backend, tx)
+	cancel()
 	if err != nil {
 		return err
 	}
 
 	if receipt.Status == uint64(0) {
 		return fmt.Errorf("tx %v failed", tx.Hash().Hex())
-	} else {
+	}
+
+	// Confirmations get a timeout of their own, rather than whatever is left
+	// of the one for mining
+	ctx, cancel = context.WithTimeout(context.Background(), c.txTimeout)
+	defer cancel()
+	return waitConfirmations(ctx, c.backend, tx.Hash())
+}
+
+// confirmationsBackend is what waitConfirmations queries the chain with
+type confirmationsBackend interface {
+	TransactionReceipt(ctx context.Context, txHash ethcommon.Hash) (*types.Receipt, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// waitConfirmations waits until the block the tx was mined in is
+// TxConfirmations deep. The receipt is fetched again once it is, in case
+// the tx was reorged out of the chain in the meantime.
+func waitConfirmations(ctx context.Context, backend confirmationsBackend, txHash ethcommon.Hash) error {
+	if TxConfirmations == 0 {
 		return nil
 	}
+	ticker := time.NewTicker(BlockTime)
+	defer ticker.Stop()
+	for {
+		receipt, err := backend.TransactionReceipt(ctx, txHash)
+		if err != nil && err != ethereum.NotFound {
+			return err
+		}
+		if receipt != nil && receipt.BlockNumber != nil {
+			if receipt.Status == uint64(0) {
+				return fmt.Errorf("tx %v failed", txHash.Hex())
+			}
+			head, err := backend.HeaderByNumber(ctx, nil)
+			if err != nil {
+				return err
+			}
+			target := new(big.Int).Add(receipt.BlockNumber, new(big.Int).SetUint64(TxConfirmations))
+			if head.Number.Cmp(target) >= 0 {
+				return nil
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
 func (c *client) Sign(msg []byte) ([]byte, error) {
@@ -0,0 +1,64 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubConfirmationsBackend has the tx mined in block 10, and advances its
+// head by a block on every header requested
+type stubConfirmationsBackend struct {
+	receipt    *types.Receipt
+	receiptErr error
+	head       int64
+}
+
+func (b *stubConfirmationsBackend) TransactionReceipt(ctx context.Context, txHash ethcommon.Hash) (*types.Receipt, error) {
+	return b.receipt, b.receiptErr
+}
+
+func (b *stubConfirmationsBackend) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	b.head++
+	return &types.Header{Number: big.NewInt(b.head)}, nil
+}
+
+func TestWaitConfirmations(t *testing.T) {
+	assert := assert.New(t)
+	defer func(confirmations uint64, blockTime time.Duration) {
+		TxConfirmations, BlockTime = confirmations, blockTime
+	}(TxConfirmations, BlockTime)
+	BlockTime = time.Millisecond
+	ctx := context.Background()
+	mined := &types.Receipt{Status: 1, BlockNumber: big.NewInt(10)}
+
+	// Nothing is waited for without confirmations
+	TxConfirmations = 0
+	assert.Nil(waitConfirmations(ctx, &stubConfirmationsBackend{receiptErr: errors.New("unreachable")}, ethcommon.Hash{}))
+
+	// Until the block the tx was mined in is deep enough
+	TxConfirmations = 2
+	backend := &stubConfirmationsBackend{receipt: mined, head: 9}
+	assert.Nil(waitConfirmations(ctx, backend, ethcommon.Hash{}))
+	assert.Equal(int64(12), backend.head)
+
+	// Txs that failed once reorged
+	backend = &stubConfirmationsBackend{receipt: &types.Receipt{Status: 0, BlockNumber: big.NewInt(10)}}
+	assert.Contains(waitConfirmations(ctx, backend, ethcommon.Hash{}).Error(), "failed")
+
+	backend = &stubConfirmationsBackend{receiptErr: errors.New("rpc error")}
+	assert.EqualError(waitConfirmations(ctx, backend, ethcommon.Hash{}), "rpc error")
+
+	// Txs reorged out of the chain are waited on until the context is done
+	backend = &stubConfirmationsBackend{receiptErr: ethereum.NotFound}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, waitConfirmations(ctx, backend, ethcommon.Hash{}))
+}
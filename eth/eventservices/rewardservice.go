@@ -6,7 +6,8 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/eth"
 )
 
@@ -19,14 +20,17 @@ var (
 
 type RewardService struct {
 	client       eth.LivepeerEthClient
+	db           *common.DB
 	pendingTx    *types.Transaction
 	working      bool
 	cancelWorker context.CancelFunc
 }
 
-func NewRewardService(client eth.LivepeerEthClient) *RewardService {
+// NewRewardService returns a service calling reward every round; minted rewards are recorded in db
+func NewRewardService(client eth.LivepeerEthClient, db *common.DB) *RewardService {
 	return &RewardService{
 		client: client,
+		db:     db,
 	}
 }
 
@@ -144,6 +148,7 @@ func (s *RewardService) tryReward() error {
 		}
 
 		glog.Infof("Called reward for round %v - %v rewards minted", currentRound, eth.FormatUnits(tp.RewardPool, "LPTU"))
+		s.db.InsertPayment(common.PaymentReward, "", "", tp.RewardPool)
 
 		return nil
 	}
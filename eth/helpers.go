@@ -6,8 +6,8 @@ import (
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 var (
@@ -75,6 +75,9 @@ func FromPercOfUint256(perc float64) *big.Int {
 	return fromPerc(perc, multiplier)
 }
 
+// BlockTime how often the chain produces blocks
+var BlockTime = 15 * time.Second
+
 func Wait(db *common.DB, blocks *big.Int) error {
 	var (
 		lastSeenBlock *big.Int
@@ -87,7 +90,7 @@ func Wait(db *common.DB, blocks *big.Int) error {
 	}
 
 	targetBlock := new(big.Int).Add(lastSeenBlock, blocks)
-	tickCh := time.NewTicker(15 * time.Second).C
+	tickCh := time.NewTicker(BlockTime).C
 
 	glog.Infof("Waiting %v blocks...", blocks)
 
@@ -25,6 +25,15 @@ if [ ! -e "$HOME/x264/x264" ]; then
   make install-lib-static
 fi
 
+# Hardware encoders the platform supports: VideoToolbox on macOS, and VAAPI
+# on Linux if libva is installed
+EXTRA_FFMPEG_FLAGS=""
+if [ "$(uname)" == "Darwin" ]; then
+  EXTRA_FFMPEG_FLAGS="--enable-videotoolbox --enable-encoder=h264_videotoolbox"
+elif pkg-config --exists libva libva-drm; then
+  EXTRA_FFMPEG_FLAGS="--enable-vaapi --enable-hwaccel=h264_vaapi --enable-encoder=h264_vaapi --enable-filter=scale_vaapi,hwupload,hwdownload,format"
+fi
+
 if [ ! -e "$HOME/ffmpeg/libavcodec/libavcodec.a" ]; then
   git clone -b n4.1 https://git.ffmpeg.org/ffmpeg.git "$HOME/ffmpeg" || echo "FFmpeg dir already exists"
   cd "$HOME/ffmpeg"
@@ -41,6 +50,7 @@ if [ ! -e "$HOME/ffmpeg/libavcodec/libavcodec.a" ]; then
     --enable-filter=aresample,asetnsamples,fps,scale \
     --enable-encoder=aac,libx264 \
     --enable-decoder=aac,h264 \
+    $EXTRA_FFMPEG_FLAGS \
     --prefix="$HOME/compiled"
   make
   make install
@@ -0,0 +1,157 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrNoCID = errors.New("no CID in IPFS response")
+var ErrAddToDirUnsupported = errors.New("AddToDir is not supported over the IPFS HTTP API")
+
+const httpAPITimeout = 30 * time.Second
+
+// IpfsHttpApi adds data through an HTTP endpoint. The endpoint can either be
+// the API of a local IPFS node or a remote pinning service that accepts
+// multipart file uploads (Pinata, web3.storage and similar).
+type IpfsHttpApi struct {
+	endpoint string
+	token    string
+	local    bool
+	client   *http.Client
+}
+
+// NewLocalNodeApi returns an API that adds and pins data on the IPFS node
+// listening on addr, e.g. 127.0.0.1:5001
+func NewLocalNodeApi(addr string) *IpfsHttpApi {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	endpoint := strings.TrimRight(addr, "/") + "/api/v0/add?pin=true&cid-version=1"
+	api := newIpfsHttpApi(endpoint, "")
+	api.local = true
+	return api
+}
+
+// NewPinningServiceApi returns an API that uploads data to the pinning
+// service at endpoint, authenticating with token as a bearer token
+func NewPinningServiceApi(endpoint, token string) *IpfsHttpApi {
+	return newIpfsHttpApi(endpoint, token)
+}
+
+func newIpfsHttpApi(endpoint, token string) *IpfsHttpApi {
+	return &IpfsHttpApi{
+		endpoint: endpoint,
+		token:    token,
+		client:   &http.Client{Timeout: httpAPITimeout},
+	}
+}
+
+// SetTimeout changes the timeout of requests to the endpoint; 0 for no timeout
+func (a *IpfsHttpApi) SetTimeout(timeout time.Duration) {
+	a.client.Timeout = timeout
+}
+
+// File is a file added as part of a directory
+type File struct {
+	Path string
+	Data io.Reader
+}
+
+// Add uploads the contents of r and returns its CID
+func (a *IpfsHttpApi) Add(r io.Reader) (string, error) {
+	return a.post(a.endpoint, []File{{Path: "file", Data: r}})
+}
+
+// AddFiles uploads files as a single directory and returns the CID of the directory
+func (a *IpfsHttpApi) AddFiles(files []File) (string, error) {
+	endpoint := a.endpoint
+	if a.local {
+		// The node responds with a line per file; the directory comes last
+		endpoint += "&wrap-with-directory=true"
+	}
+	return a.post(endpoint, files)
+}
+
+func (a *IpfsHttpApi) post(endpoint string, files []File) (string, error) {
+	// Stream the body so large directories aren't buffered in memory
+	pr, pw := io.Pipe()
+	w := multipart.NewWriter(pw)
+	written := make(chan struct{})
+	// Wait for the writer so callers may close the files once we return
+	defer func() {
+		pr.Close()
+		<-written
+	}()
+	go func() {
+		defer close(written)
+		for _, f := range files {
+			part, err := w.CreateFormFile("file", f.Path)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(part, f.Data); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(w.Close())
+	}()
+
+	req, err := http.NewRequest("POST", endpoint, pr)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("IPFS upload failed status=%v body=%s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return parseCID(data)
+}
+
+// AddToDir is not available through the HTTP API
+func (a *IpfsHttpApi) AddToDir(dir, fileName string, r io.Reader) (string, error) {
+	return "", ErrAddToDirUnsupported
+}
+
+// parseCID extracts the CID from the JSON returned by either the node API
+// (`Hash`), Pinata (`IpfsHash`) or web3.storage (`cid`). For responses with
+// an object per line, the last one is used.
+func parseCID(data []byte) (string, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	data = []byte(lines[len(lines)-1])
+	var res struct {
+		Hash     string
+		IpfsHash string
+		CID      string `json:"cid"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", err
+	}
+	for _, cid := range []string{res.Hash, res.IpfsHash, res.CID} {
+		if cid != "" {
+			return cid, nil
+		}
+	}
+	return "", ErrNoCID
+}
@@ -0,0 +1,75 @@
+package ipfs
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIpfsHttpApi_Add(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var auth, path, data string
+	resp := `{"Hash":"bafynode"}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		path = r.URL.RequestURI()
+		file, _, err := r.FormFile("file")
+		require.Nil(err)
+		b, err := ioutil.ReadAll(file)
+		require.Nil(err)
+		data = string(b)
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+
+	// Local node
+	api := NewLocalNodeApi(strings.TrimPrefix(ts.URL, "http://"))
+	cid, err := api.Add(strings.NewReader("segment"))
+	assert.Nil(err)
+	assert.Equal("bafynode", cid)
+	assert.Equal("/api/v0/add?pin=true&cid-version=1", path)
+	assert.Equal("segment", data)
+	assert.Empty(auth)
+
+	// Pinata style pinning service
+	resp = `{"IpfsHash":"bafypinata","PinSize":7}`
+	api = NewPinningServiceApi(ts.URL+"/pinning/pinFileToIPFS", "jwt")
+	cid, err = api.Add(strings.NewReader("segment"))
+	assert.Nil(err)
+	assert.Equal("bafypinata", cid)
+	assert.Equal("/pinning/pinFileToIPFS", path)
+	assert.Equal("Bearer jwt", auth)
+
+	// web3.storage style pinning service
+	resp = `{"cid":"bafyweb3"}`
+	cid, err = api.Add(strings.NewReader("segment"))
+	assert.Nil(err)
+	assert.Equal("bafyweb3", cid)
+
+	// No CID in response
+	resp = `{}`
+	_, err = api.Add(strings.NewReader("segment"))
+	assert.Equal(ErrNoCID, err)
+}
+
+func TestIpfsHttpApi_AddError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	api := NewPinningServiceApi(ts.URL, "bad")
+	_, err := api.Add(strings.NewReader("segment"))
+	assert.Contains(t, err.Error(), "401")
+	assert.Contains(t, err.Error(), "unauthorized")
+
+	_, err = api.AddToDir("dir", "file", strings.NewReader("segment"))
+	assert.Equal(t, ErrAddToDirUnsupported, err)
+}
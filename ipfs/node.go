@@ -14,7 +14,6 @@ import (
 	mfs "github.com/ipfs/go-ipfs/mfs"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
 
-	"github.com/golang/glog"
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/core/coreunix"
 	"github.com/ipfs/go-ipfs/importer/balanced"
@@ -25,6 +24,7 @@ import (
 	"github.com/ipfs/go-ipfs/repo/config"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	"github.com/livepeer/go-livepeer/common/glog"
 )
 
 type IpfsApi interface {
@@ -8,7 +8,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 
 	"contrib.go.opencensus.io/exporter/prometheus"
 	rprom "github.com/prometheus/client_golang/prometheus"
@@ -18,11 +18,17 @@ import (
 )
 
 type (
+	LabelMode             string
 	SegmentUploadError    string
 	SegmentTranscodeError string
+	StorageEvictionReason string
+	SegmentPhase          string
 )
 
 const (
+	LabelFull                               LabelMode             = "full"
+	LabelAggregate                          LabelMode             = "aggregate"
+	LabelOff                                LabelMode             = "off"
 	SegmentUploadErrorUnknown               SegmentUploadError    = "Unknown"
 	SegmentUploadErrorGenCreds              SegmentUploadError    = "GenCreds"
 	SegmentUploadErrorOS                    SegmentUploadError    = "ObjectStorage"
@@ -40,6 +46,16 @@ const (
 	SegmentTranscodeErrorSaveData           SegmentTranscodeError = "SaveData"
 	SegmentTranscodeErrorSessionEnded       SegmentTranscodeError = "SessionEnded"
 	SegmentTranscodeErrorPlaylist           SegmentTranscodeError = "Playlist"
+	StorageEvictionSegments                 StorageEvictionReason = "MaxSegments"
+	StorageEvictionAge                      StorageEvictionReason = "MaxAge"
+	StorageEvictionBytes                    StorageEvictionReason = "MaxBytes"
+	SegmentPhaseIngest                      SegmentPhase          = "ingest"
+	SegmentPhaseUpload                      SegmentPhase          = "upload"
+	SegmentPhaseTranscode                   SegmentPhase          = "transcode"
+	SegmentPhaseDownload                    SegmentPhase          = "download"
+	SegmentPhaseVerify                      SegmentPhase          = "verify"
+	SegmentPhasePublish                     SegmentPhase          = "publish"
+	SegmentPhaseTotal                       SegmentPhase          = "total"
 
 	numberOfSegmentsToCalcAverage = 30
 )
@@ -47,6 +63,17 @@ const (
 // Enabled true if metrics was enabled in command line
 var Enabled bool
 
+// OrchestratorLabels how metrics of broadcasters are labelled by orchestrator,
+// as busy broadcasters may send segments to hundreds of them: LabelFull labels
+// each orchestrator, LabelAggregate labels the first MaxOrchestratorLabels and
+// the rest as "other", and LabelOff only keeps the aggregates of the node
+var OrchestratorLabels = LabelFull
+
+// MaxOrchestratorLabels distinct orchestrators labelled with LabelAggregate
+var MaxOrchestratorLabels = 50
+
+const otherLabel = "other"
+
 var timeToWaitForError = 8500 * time.Millisecond
 var timeoutWatcherPause = 15 * time.Second
 
@@ -61,6 +88,18 @@ type (
 		kProfiles                     tag.Key
 		kErrorCode                    tag.Key
 		kTry                          tag.Key
+		kReason                       tag.Key
+		kDriver                       tag.Key
+		kBucket                       tag.Key
+		kOperation                    tag.Key
+		kOrchestrator                 tag.Key
+		kDevice                       tag.Key
+		kPhase                        tag.Key
+		kTranscoder                   tag.Key
+		kPriority                     tag.Key
+		kReused                       tag.Key
+		kEndpoint                     tag.Key
+		kScope                        tag.Key
 		mSegmentSourceAppeared        *stats.Int64Measure
 		mSegmentEmerged               *stats.Int64Measure
 		mSegmentEmergedUnprocessed    *stats.Int64Measure
@@ -85,6 +124,34 @@ type (
 		mTranscodeLatency             *stats.Float64Measure
 		mTranscodeOverallLatency      *stats.Float64Measure
 		mUploadTime                   *stats.Float64Measure
+		mStorageEvicted               *stats.Int64Measure
+		mStorageBytes                 *stats.Int64Measure
+		mStorageEvictedRequested      *stats.Int64Measure
+		mStorageRequests              *stats.Int64Measure
+		mStorageErrors                *stats.Int64Measure
+		mStorageTransferred           *stats.Int64Measure
+		mStorageLatency               *stats.Float64Measure
+		mStorageChecksumMismatch      *stats.Int64Measure
+		mOrchSegmentsSent             *stats.Int64Measure
+		mOrchSegmentsFailed           *stats.Int64Measure
+		mOrchRoundTripLatency         *stats.Float64Measure
+		mOrchPaid                     *stats.Float64Measure
+		mOrchVerificationFailed       *stats.Int64Measure
+		mSegmentPhaseLatency          *stats.Float64Measure
+		mTranscoderRoundTripLatency   *stats.Float64Measure
+		mTranscodeSessions            *stats.Int64Measure
+		mTranscodeSessionSegments     *stats.Int64Measure
+		mRateLimited                  *stats.Int64Measure
+		mGPUUtilization               *stats.Int64Measure
+		mGPUEncoderUtilization        *stats.Int64Measure
+		mGPUDecoderUtilization        *stats.Int64Measure
+		mGPUMemoryUsed                *stats.Int64Measure
+		mGPUMemoryTotal               *stats.Int64Measure
+		mGPUTemperature               *stats.Int64Measure
+		mGPUECCErrors                 *stats.Int64Measure
+		mGPUEncoderSessions           *stats.Int64Measure
+		orchLabelsLock                sync.Mutex
+		orchLabels                    map[string]bool
 		lock                          sync.Mutex
 		emergeTimes                   map[uint64]map[uint64]time.Time // nonce:seqNo
 		success                       map[uint64]*segmentsAverager
@@ -124,6 +191,7 @@ var unitTestMode bool
 func InitCensus(nodeType, nodeID, version string) {
 	census = censusMetricsCounter{
 		emergeTimes: make(map[uint64]map[uint64]time.Time),
+		orchLabels:  make(map[string]bool),
 		nodeID:      nodeID,
 		nodeType:    nodeType,
 		success:     make(map[uint64]*segmentsAverager),
@@ -135,6 +203,18 @@ func InitCensus(nodeType, nodeID, version string) {
 	census.kProfiles, _ = tag.NewKey("profiles")
 	census.kErrorCode, _ = tag.NewKey("error_code")
 	census.kTry, _ = tag.NewKey("try")
+	census.kReason, _ = tag.NewKey("reason")
+	census.kDriver, _ = tag.NewKey("driver")
+	census.kBucket, _ = tag.NewKey("bucket")
+	census.kOperation, _ = tag.NewKey("operation")
+	census.kOrchestrator, _ = tag.NewKey("orchestrator")
+	census.kDevice, _ = tag.NewKey("device")
+	census.kPhase, _ = tag.NewKey("phase")
+	census.kTranscoder, _ = tag.NewKey("transcoder")
+	census.kPriority, _ = tag.NewKey("priority")
+	census.kReused, _ = tag.NewKey("reused")
+	census.kEndpoint, _ = tag.NewKey("endpoint")
+	census.kScope, _ = tag.NewKey("scope")
 	census.ctx, err = tag.New(context.Background(), tag.Insert(census.kNodeType, nodeType), tag.Insert(census.kNodeID, nodeID))
 	if err != nil {
 		glog.Fatal("Error creating context", err)
@@ -165,6 +245,32 @@ func InitCensus(nodeType, nodeID, version string) {
 	census.mTranscodeOverallLatency = stats.Float64("transcode_overall_latency_seconds",
 		"Transcoding latency, from source segment emered from segmenter till all transcoded segment apeeared in manifest", "sec")
 	census.mUploadTime = stats.Float64("upload_time_seconds", "Upload (to Orchestrator) time", "sec")
+	census.mStorageEvicted = stats.Int64("storage_evicted_total", "Number of objects evicted from local storage", "tot")
+	census.mStorageBytes = stats.Int64("storage_bytes", "Size of data kept in local storage", "By")
+	census.mStorageEvictedRequested = stats.Int64("storage_evicted_requested_total", "Number of requests for data already evicted from local storage", "tot")
+	census.mStorageRequests = stats.Int64("storage_requests_total", "Number of object store requests", "tot")
+	census.mStorageErrors = stats.Int64("storage_errors_total", "Number of failed object store requests", "tot")
+	census.mStorageTransferred = stats.Int64("storage_transferred_bytes", "Bytes uploaded to or downloaded from object stores", "By")
+	census.mStorageLatency = stats.Float64("storage_latency_seconds", "Object store request latency", "sec")
+	census.mStorageChecksumMismatch = stats.Int64("storage_checksum_mismatch_total", "Number of objects read from object stores that don't match their checksum", "tot")
+	census.mOrchSegmentsSent = stats.Int64("orchestrator_segments_sent_total", "Number of segments sent to the orchestrator", "tot")
+	census.mOrchSegmentsFailed = stats.Int64("orchestrator_segments_failed_total", "Number of segments the orchestrator failed to transcode", "tot")
+	census.mOrchRoundTripLatency = stats.Float64("orchestrator_round_trip_seconds", "Time from sending a segment to the orchestrator till receiving its response", "sec")
+	census.mOrchPaid = stats.Float64("orchestrator_paid_wei", "Expected value of the tickets sent to the orchestrator", "wei")
+	census.mOrchVerificationFailed = stats.Int64("orchestrator_verification_failed_total", "Number of segments from the orchestrator that failed verification", "tot")
+	census.mSegmentPhaseLatency = stats.Float64("segment_phase_latency_seconds", "Time a segment spent in each phase of processing", "sec")
+	census.mTranscodeSessions = stats.Int64("transcode_sessions", "Number of streams with a transcode session kept between their segments", "tot")
+	census.mTranscodeSessionSegments = stats.Int64("transcode_session_segments_total", "Number of segments transcoded on transcode sessions", "tot")
+	census.mRateLimited = stats.Int64("rate_limited_total", "Number of requests refused for exceeding a rate limit", "tot")
+	census.mTranscoderRoundTripLatency = stats.Float64("transcoder_round_trip_seconds", "Time from sending a segment to a remote transcoder till receiving its results", "sec")
+	census.mGPUUtilization = stats.Int64("gpu_utilization_percent", "GPU utilization", "%")
+	census.mGPUEncoderUtilization = stats.Int64("gpu_encoder_utilization_percent", "GPU video encoder utilization", "%")
+	census.mGPUDecoderUtilization = stats.Int64("gpu_decoder_utilization_percent", "GPU video decoder utilization", "%")
+	census.mGPUMemoryUsed = stats.Int64("gpu_memory_used_bytes", "GPU memory used", "By")
+	census.mGPUMemoryTotal = stats.Int64("gpu_memory_total_bytes", "GPU memory installed", "By")
+	census.mGPUTemperature = stats.Int64("gpu_temperature_celsius", "GPU temperature", "C")
+	census.mGPUECCErrors = stats.Int64("gpu_ecc_errors_uncorrected", "Uncorrected GPU memory ECC errors since the driver loaded", "tot")
+	census.mGPUEncoderSessions = stats.Int64("gpu_encoder_sessions", "Number of active GPU encoder sessions", "tot")
 
 	glog.Infof("Compiler: %s Arch %s OS %s Go version %s", runtime.Compiler, runtime.GOARCH, runtime.GOOS, runtime.Version())
 	glog.Infof("Livepeer version: %s", version)
@@ -358,6 +464,188 @@ func InitCensus(nodeType, nodeID, version string) {
 			TagKeys:     append([]tag.Key{census.kTry}, baseTags...),
 			Aggregation: view.Count(),
 		},
+		&view.View{
+			Name:        "storage_evicted_total",
+			Measure:     census.mStorageEvicted,
+			Description: "Number of objects evicted from local storage",
+			TagKeys:     append([]tag.Key{census.kReason}, baseTags...),
+			Aggregation: view.Sum(),
+		},
+		&view.View{
+			Name:        "storage_bytes",
+			Measure:     census.mStorageBytes,
+			Description: "Size of data kept in local storage",
+			TagKeys:     baseTags,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "storage_evicted_requested_total",
+			Measure:     census.mStorageEvictedRequested,
+			Description: "Number of requests for data already evicted from local storage; local storage is undersized if this grows",
+			TagKeys:     baseTags,
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "storage_requests_total",
+			Measure:     census.mStorageRequests,
+			Description: "Number of object store requests",
+			TagKeys:     append([]tag.Key{census.kDriver, census.kBucket, census.kOperation}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "storage_errors_total",
+			Measure:     census.mStorageErrors,
+			Description: "Number of failed object store requests",
+			TagKeys:     append([]tag.Key{census.kDriver, census.kBucket, census.kOperation}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "storage_transferred_bytes",
+			Measure:     census.mStorageTransferred,
+			Description: "Bytes uploaded to or downloaded from object stores",
+			TagKeys:     append([]tag.Key{census.kDriver, census.kBucket, census.kOperation}, baseTags...),
+			Aggregation: view.Sum(),
+		},
+		&view.View{
+			Name:        "storage_latency_seconds",
+			Measure:     census.mStorageLatency,
+			Description: "Object store request latency, seconds",
+			TagKeys:     append([]tag.Key{census.kDriver, census.kBucket, census.kOperation}, baseTags...),
+			Aggregation: view.Distribution(0, .010, .025, .050, .100, .250, .500, 1.000, 2.500, 5.000, 10.000),
+		},
+		&view.View{
+			Name:        "storage_checksum_mismatch_total",
+			Measure:     census.mStorageChecksumMismatch,
+			Description: "Number of objects read from object stores that don't match their checksum",
+			TagKeys:     append([]tag.Key{census.kDriver, census.kBucket}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "orchestrator_segments_sent_total",
+			Measure:     census.mOrchSegmentsSent,
+			Description: "Number of segments sent to the orchestrator",
+			TagKeys:     census.orchestratorTags(baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "orchestrator_segments_failed_total",
+			Measure:     census.mOrchSegmentsFailed,
+			Description: "Number of segments the orchestrator failed to transcode",
+			TagKeys:     census.orchestratorTags(append([]tag.Key{census.kErrorCode}, baseTags...)...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "orchestrator_round_trip_seconds",
+			Measure:     census.mOrchRoundTripLatency,
+			Description: "Time from sending a segment to the orchestrator till receiving its response, seconds",
+			TagKeys:     census.orchestratorTags(baseTags...),
+			Aggregation: view.Distribution(0, .250, .500, .750, 1.000, 1.250, 1.500, 2.000, 2.500, 3.000, 3.500, 4.000, 4.500, 5.000, 10.000),
+		},
+		&view.View{
+			Name:        "orchestrator_paid_wei",
+			Measure:     census.mOrchPaid,
+			Description: "Expected value of the tickets sent to the orchestrator, wei",
+			TagKeys:     census.orchestratorTags(baseTags...),
+			Aggregation: view.Sum(),
+		},
+		&view.View{
+			Name:        "orchestrator_verification_failed_total",
+			Measure:     census.mOrchVerificationFailed,
+			Description: "Number of segments from the orchestrator that failed verification",
+			TagKeys:     census.orchestratorTags(baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "segment_phase_latency_seconds",
+			Measure:     census.mSegmentPhaseLatency,
+			Description: "Time a segment spent in each phase of processing, seconds",
+			TagKeys:     append([]tag.Key{census.kPhase}, baseTags...),
+			Aggregation: view.Distribution(0, .010, .025, .050, .100, .250, .500, .750, 1.000, 1.500, 2.000, 3.000, 4.000, 5.000, 10.000),
+		},
+		&view.View{
+			Name:        "transcode_sessions",
+			Measure:     census.mTranscodeSessions,
+			Description: "Number of streams with a transcode session kept between their segments",
+			TagKeys:     baseTags,
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "transcode_session_segments_total",
+			Measure:     census.mTranscodeSessionSegments,
+			Description: "Number of segments transcoded on transcode sessions, by whether the session was reused from an earlier segment",
+			TagKeys:     append([]tag.Key{census.kReused}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "rate_limited_total",
+			Measure:     census.mRateLimited,
+			Description: "Number of requests refused for exceeding a rate limit, by endpoint and whether the limit was of the IP address or global",
+			TagKeys:     append([]tag.Key{census.kEndpoint, census.kScope}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		&view.View{
+			Name:        "transcoder_round_trip_seconds",
+			Measure:     census.mTranscoderRoundTripLatency,
+			Description: "Time from sending a segment to a remote transcoder till receiving its results, seconds",
+			TagKeys:     append([]tag.Key{census.kTranscoder, census.kPriority}, baseTags...),
+			Aggregation: view.Distribution(0, .100, .250, .500, .750, 1.000, 1.250, 1.500, 2.000, 2.500, 3.000, 4.000, 5.000, 8.000),
+		},
+		&view.View{
+			Name:        "gpu_utilization_percent",
+			Measure:     census.mGPUUtilization,
+			Description: "GPU utilization, percent",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_encoder_utilization_percent",
+			Measure:     census.mGPUEncoderUtilization,
+			Description: "GPU video encoder utilization, percent",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_decoder_utilization_percent",
+			Measure:     census.mGPUDecoderUtilization,
+			Description: "GPU video decoder utilization, percent",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_memory_used_bytes",
+			Measure:     census.mGPUMemoryUsed,
+			Description: "GPU memory used",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_memory_total_bytes",
+			Measure:     census.mGPUMemoryTotal,
+			Description: "GPU memory installed",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_temperature_celsius",
+			Measure:     census.mGPUTemperature,
+			Description: "GPU temperature, degrees Celsius",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_ecc_errors_uncorrected",
+			Measure:     census.mGPUECCErrors,
+			Description: "Uncorrected GPU memory ECC errors since the driver loaded",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
+		&view.View{
+			Name:        "gpu_encoder_sessions",
+			Measure:     census.mGPUEncoderSessions,
+			Description: "Number of active GPU encoder sessions",
+			TagKeys:     append([]tag.Key{census.kDevice}, baseTags...),
+			Aggregation: view.LastValue(),
+		},
 	}
 	// Register the views
 	if err := view.Register(views...); err != nil {
@@ -565,6 +853,171 @@ func CurrentSessions(currentSessions int) {
 	stats.Record(census.ctx, census.mCurrentSessions.M(int64(currentSessions)))
 }
 
+// TranscodeSessions records the number of streams with a transcode session
+func TranscodeSessions(sessions int) {
+	stats.Record(census.ctx, census.mTranscodeSessions.M(int64(sessions)))
+}
+
+// TranscodeSessionSegment records a segment transcoded on a transcode
+// session, reused if it was already open for an earlier segment
+func TranscodeSessionSegment(reused bool) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kReused, strconv.FormatBool(reused)))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mTranscodeSessionSegments.M(1))
+}
+
+// RateLimited records a request to endpoint refused for exceeding the rate
+// limit of scope, ip or global
+func RateLimited(endpoint, scope string) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kEndpoint, endpoint), tag.Insert(census.kScope, scope))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mRateLimited.M(1))
+}
+
+// StorageEvicted records objects evicted from local storage
+func StorageEvicted(reason StorageEvictionReason, count int64) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kReason, string(reason)))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mStorageEvicted.M(count))
+}
+
+// StorageUsage records size of data kept in local storage
+func StorageUsage(bytes int64) {
+	stats.Record(census.ctx, census.mStorageBytes.M(bytes))
+}
+
+// StorageEvictedRequested records a request for data that was evicted from local storage
+func StorageEvictedRequested() {
+	stats.Record(census.ctx, census.mStorageEvictedRequested.M(1))
+}
+
+// StorageRequest records an upload or download of size bytes through an object store driver
+func StorageRequest(driver, bucket, operation string, size int, dur time.Duration, err error) {
+	ctx, terr := tag.New(census.ctx, tag.Insert(census.kDriver, driver), tag.Insert(census.kBucket, bucket),
+		tag.Insert(census.kOperation, operation))
+	if terr != nil {
+		glog.Error("Error creating context", terr)
+		return
+	}
+	stats.Record(ctx, census.mStorageRequests.M(1), census.mStorageLatency.M(dur.Seconds()))
+	if err != nil {
+		stats.Record(ctx, census.mStorageErrors.M(1))
+		return
+	}
+	stats.Record(ctx, census.mStorageTransferred.M(int64(size)))
+}
+
+// StorageChecksumMismatch records an object read from an object store that doesn't match its checksum
+func StorageChecksumMismatch(driver, bucket string) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kDriver, driver), tag.Insert(census.kBucket, bucket))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mStorageChecksumMismatch.M(1))
+}
+
+// RemoteTranscoderSegment records the round trip time of a segment of
+// priority transcoded by the remote transcoder, by its ID or tags
+func RemoteTranscoderSegment(transcoder, priority string, roundTrip time.Duration) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kTranscoder, transcoder), tag.Insert(census.kPriority, priority))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mTranscoderRoundTripLatency.M(roundTrip.Seconds()))
+}
+
+// orchestratorTags the tag keys of a per orchestrator view
+func (cen *censusMetricsCounter) orchestratorTags(keys ...tag.Key) []tag.Key {
+	if OrchestratorLabels == LabelOff {
+		return keys
+	}
+	return append([]tag.Key{cen.kOrchestrator}, keys...)
+}
+
+// orchestratorLabel the label orch is recorded with
+func (cen *censusMetricsCounter) orchestratorLabel(orch string) string {
+	if OrchestratorLabels != LabelAggregate {
+		return orch
+	}
+	cen.orchLabelsLock.Lock()
+	defer cen.orchLabelsLock.Unlock()
+	if cen.orchLabels[orch] {
+		return orch
+	}
+	if len(cen.orchLabels) >= MaxOrchestratorLabels {
+		return otherLabel
+	}
+	cen.orchLabels[orch] = true
+	return orch
+}
+
+func orchestratorContext(orch string, mutators ...tag.Mutator) (context.Context, error) {
+	orch = census.orchestratorLabel(orch)
+	return tag.New(census.ctx, append([]tag.Mutator{tag.Insert(census.kOrchestrator, orch)}, mutators...)...)
+}
+
+// OrchestratorSegmentSent records a segment sent to orch, along with the
+// round trip time if it was transcoded
+func OrchestratorSegmentSent(orch string, roundTrip time.Duration, code string) {
+	ctx, err := orchestratorContext(orch)
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mOrchSegmentsSent.M(1))
+	if code != "" {
+		ctx, err = orchestratorContext(orch, tag.Insert(census.kErrorCode, code))
+		if err != nil {
+			glog.Error("Error creating context", err)
+			return
+		}
+		stats.Record(ctx, census.mOrchSegmentsFailed.M(1))
+		return
+	}
+	stats.Record(ctx, census.mOrchRoundTripLatency.M(roundTrip.Seconds()))
+}
+
+// OrchestratorPaid records the expected value in wei of a ticket sent to orch
+func OrchestratorPaid(orch string, ev float64) {
+	ctx, err := orchestratorContext(orch)
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mOrchPaid.M(ev))
+}
+
+// OrchestratorVerificationFailed records a segment transcoded by orch that failed verification
+func OrchestratorVerificationFailed(orch string) {
+	ctx, err := orchestratorContext(orch)
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mOrchVerificationFailed.M(1))
+}
+
+// SegmentPhaseLatency records the time a segment spent in phase
+func SegmentPhaseLatency(phase SegmentPhase, dur time.Duration) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kPhase, string(phase)))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mSegmentPhaseLatency.M(dur.Seconds()))
+}
+
 func TranscodeTry(nonce, seqNo uint64) {
 	census.lock.Lock()
 	defer census.lock.Unlock()
@@ -723,6 +1176,14 @@ func (cen *censusMetricsCounter) countSegmentEmerged(nonce, seqNo uint64) {
 	}
 }
 
+// SegmentSuccessRate the fraction of recent segments that were transcoded,
+// averaged over the streams; 1 if there are none. Only tracked if enabled.
+func SegmentSuccessRate() float64 {
+	census.lock.Lock()
+	defer census.lock.Unlock()
+	return census.successRate()
+}
+
 func (cen *censusMetricsCounter) sendSuccess() {
 	stats.Record(cen.ctx, cen.mSuccessRate.M(cen.successRate()))
 }
@@ -2,9 +2,12 @@ package monitor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
+
+	"go.opencensus.io/stats/view"
 )
 
 func TestAveragerCanBeRemoved(t *testing.T) {
@@ -119,3 +122,91 @@ func TestLastSegmentTimeout(t *testing.T) {
 		t.Fatalf("Success rate should be 0.5, not %f", sr)
 	}
 }
+
+func TestOrchestratorLabel(t *testing.T) {
+	defer func(mode LabelMode, max int) {
+		OrchestratorLabels, MaxOrchestratorLabels = mode, max
+	}(OrchestratorLabels, MaxOrchestratorLabels)
+	cen := &censusMetricsCounter{orchLabels: make(map[string]bool)}
+
+	OrchestratorLabels = LabelFull
+	for _, orch := range []string{"a", "b", "c"} {
+		if label := cen.orchestratorLabel(orch); label != orch {
+			t.Errorf("Expected label %s got %s", orch, label)
+		}
+	}
+	if len(cen.orchestratorTags()) != 1 {
+		t.Error("Expected orchestrator tag")
+	}
+
+	OrchestratorLabels = LabelAggregate
+	MaxOrchestratorLabels = 2
+	expected := []string{"a", "b", otherLabel, "a", otherLabel}
+	for i, orch := range []string{"a", "b", "c", "a", "d"} {
+		if label := cen.orchestratorLabel(orch); label != expected[i] {
+			t.Errorf("Expected label %s for %s got %s", expected[i], orch, label)
+		}
+	}
+
+	OrchestratorLabels = LabelOff
+	if len(cen.orchestratorTags()) != 0 {
+		t.Error("Expected no orchestrator tag")
+	}
+}
+
+// rowData returns the data of the row of view name with tags; nil if there's none
+func rowData(t *testing.T, name string, tags map[string]string) view.AggregationData {
+	rows, err := view.RetrieveData(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		matched := 0
+		for _, tg := range row.Tags {
+			if v, ok := tags[tg.Key.Name()]; ok && v == tg.Value {
+				matched++
+			}
+		}
+		if matched == len(tags) {
+			return row.Data
+		}
+	}
+	return nil
+}
+
+func TestStorageRequest(t *testing.T) {
+	unitTestMode = true
+	defer func() { unitTestMode = false }()
+	InitCensus("tst", "testid", "testversion")
+
+	StorageRequest("s3", "segments.s3.amazonaws.com", "upload", 100, 200*time.Millisecond, nil)
+	StorageRequest("s3", "segments.s3.amazonaws.com", "upload", 50, 400*time.Millisecond, errors.New("timeout"))
+	StorageRequest("s3", "segments.s3.amazonaws.com", "download", 10, 100*time.Millisecond, nil)
+
+	upload := map[string]string{"driver": "s3", "bucket": "segments.s3.amazonaws.com", "operation": "upload"}
+	if d, ok := rowData(t, "storage_requests_total", upload).(*view.CountData); !ok || d.Value != 2 {
+		t.Errorf("Expected 2 upload requests, got %v", d)
+	}
+	if d, ok := rowData(t, "storage_errors_total", upload).(*view.CountData); !ok || d.Value != 1 {
+		t.Errorf("Expected 1 upload error, got %v", d)
+	}
+	// Failed requests transfer nothing
+	if d, ok := rowData(t, "storage_transferred_bytes", upload).(*view.SumData); !ok || d.Value != 100 {
+		t.Errorf("Expected 100 bytes uploaded, got %v", d)
+	}
+	// Including that of failed requests
+	if d, ok := rowData(t, "storage_latency_seconds", upload).(*view.DistributionData); !ok || d.Count != 2 || d.Mean < 0.299 || d.Mean > 0.301 {
+		t.Errorf("Expected 2 upload latencies averaging 0.3s, got %v", d)
+	}
+
+	download := map[string]string{"driver": "s3", "bucket": "segments.s3.amazonaws.com", "operation": "download"}
+	if d, ok := rowData(t, "storage_requests_total", download).(*view.CountData); !ok || d.Value != 1 {
+		t.Errorf("Expected 1 download request, got %v", d)
+	}
+	if d := rowData(t, "storage_errors_total", download); d != nil {
+		t.Errorf("Expected no download errors, got %v", d)
+	}
+	if d, ok := rowData(t, "storage_transferred_bytes", download).(*view.SumData); !ok || d.Value != 10 {
+		t.Errorf("Expected 10 bytes downloaded, got %v", d)
+	}
+}
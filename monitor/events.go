@@ -0,0 +1,229 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	nats "github.com/nats-io/nats.go"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Types of events emitted to the event sinks
+const (
+	EventStreamStarted        = "stream_started"
+	EventStreamEnded          = "stream_ended"
+	EventSegmentTranscoded    = "segment_transcoded"
+	EventOrchestratorSwitched = "orchestrator_switched"
+	EventTicketWon            = "ticket_won"
+	EventVerificationFailed   = "verification_failed"
+	EventTranscoderEvicted    = "transcoder_evicted"
+	EventIngestRefused        = "ingest_refused"
+	EventSegmentSenderBanned  = "segment_sender_banned"
+)
+
+// EventQueueSize how many events may wait to be delivered; events emitted while
+// the queue is full are dropped rather than holding up the caller
+var EventQueueSize = 1000
+
+var eventSendTimeout = 10 * time.Second
+
+// Event is emitted to the event sinks as a JSON object
+type Event struct {
+	Type      string                 `json:"type"`
+	Timestamp time.Time              `json:"timestamp"`
+	NodeType  string                 `json:"nodeType"`
+	NodeID    string                 `json:"nodeID"`
+	Data      map[string]interface{} `json:"data"`
+}
+
+// EventSink delivers events to an external system
+type EventSink interface {
+	Send(ctx context.Context, event *Event, data []byte) error
+	Close() error
+	String() string
+}
+
+type eventBus struct {
+	nodeType string
+	nodeID   string
+	sinks    []EventSink
+	queue    chan *Event
+	done     chan struct{}
+}
+
+var eventsLock sync.RWMutex
+var events *eventBus
+
+// InitEvents starts emitting events to sinks. Returns a func delivering the
+// events still queued and closing the sinks, to be called on shutdown.
+func InitEvents(nodeType, nodeID string, sinks ...EventSink) func() {
+	bus := &eventBus{
+		nodeType: nodeType,
+		nodeID:   nodeID,
+		sinks:    sinks,
+		queue:    make(chan *Event, EventQueueSize),
+		done:     make(chan struct{}),
+	}
+	eventsLock.Lock()
+	events = bus
+	eventsLock.Unlock()
+	go bus.run()
+	return func() {
+		eventsLock.Lock()
+		events = nil
+		eventsLock.Unlock()
+		close(bus.queue)
+		<-bus.done
+	}
+}
+
+// EmitEvent queues an event of typ for delivery to the sinks. Does nothing
+// if no sinks were set up.
+func EmitEvent(typ string, data map[string]interface{}) {
+	eventsLock.RLock()
+	defer eventsLock.RUnlock()
+	if events == nil {
+		return
+	}
+	event := &Event{
+		Type:      typ,
+		Timestamp: time.Now(),
+		NodeType:  events.nodeType,
+		NodeID:    events.nodeID,
+		Data:      data,
+	}
+	select {
+	case events.queue <- event:
+	default:
+		glog.Warningf("Event queue full; dropping event type=%s", typ)
+	}
+}
+
+func (bus *eventBus) run() {
+	defer close(bus.done)
+	for event := range bus.queue {
+		data, err := json.Marshal(event)
+		if err != nil {
+			glog.Errorf("Error encoding event type=%s err=%v", event.Type, err)
+			continue
+		}
+		for _, sink := range bus.sinks {
+			ctx, cancel := context.WithTimeout(context.Background(), eventSendTimeout)
+			if err := sink.Send(ctx, event, data); err != nil {
+				glog.Errorf("Error sending event type=%s to sink=%s err=%v", event.Type, sink, err)
+			}
+			cancel()
+		}
+	}
+	for _, sink := range bus.sinks {
+		if err := sink.Close(); err != nil {
+			glog.Errorf("Error closing event sink=%s err=%v", sink, err)
+		}
+	}
+}
+
+// eventKey keeps the events of a stream in order within Kafka partitions
+func eventKey(event *Event) []byte {
+	if mid, ok := event.Data["manifestID"].(string); ok {
+		return []byte(mid)
+	}
+	return nil
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink POSTs each event to url
+func NewWebhookSink(url string) EventSink {
+	return &webhookSink{url: url, client: &http.Client{}}
+}
+
+func (ws *webhookSink) Send(ctx context.Context, event *Event, data []byte) error {
+	req, err := http.NewRequest("POST", ws.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ws.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status=%v body=%s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (ws *webhookSink) Close() error {
+	return nil
+}
+
+func (ws *webhookSink) String() string {
+	return "webhook"
+}
+
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink writes events into topic of the Kafka cluster brokers belong to.
+// Events are keyed by manifest ID.
+func NewKafkaSink(brokers []string, topic string) EventSink {
+	return &kafkaSink{writer: kafka.NewWriter(kafka.WriterConfig{
+		Brokers:  brokers,
+		Topic:    topic,
+		Balancer: &kafka.Hash{},
+	})}
+}
+
+func (ks *kafkaSink) Send(ctx context.Context, event *Event, data []byte) error {
+	return ks.writer.WriteMessages(ctx, kafka.Message{Key: eventKey(event), Value: data})
+}
+
+func (ks *kafkaSink) Close() error {
+	return ks.writer.Close()
+}
+
+func (ks *kafkaSink) String() string {
+	return "kafka"
+}
+
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSSink publishes events on subject of the NATS server at url
+func NewNATSSink(url, subject string) (EventSink, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{conn: conn, subject: subject}, nil
+}
+
+func (ns *natsSink) Send(ctx context.Context, event *Event, data []byte) error {
+	return ns.conn.Publish(ns.subject, data)
+}
+
+func (ns *natsSink) Close() error {
+	ns.conn.Flush()
+	ns.conn.Close()
+	return nil
+}
+
+func (ns *natsSink) String() string {
+	return "nats"
+}
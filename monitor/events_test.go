@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type stubEventSink struct {
+	lock   sync.Mutex
+	events []*Event
+	closed bool
+}
+
+func (s *stubEventSink) Send(ctx context.Context, event *Event, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *stubEventSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func (s *stubEventSink) String() string {
+	return "stub"
+}
+
+func TestEmitEvent(t *testing.T) {
+	// No sinks
+	EmitEvent(EventStreamStarted, map[string]interface{}{"manifestID": "a"})
+
+	sink := &stubEventSink{}
+	shutdown := InitEvents("broadcaster", "node1", sink)
+	EmitEvent(EventStreamStarted, map[string]interface{}{"manifestID": "a"})
+	EmitEvent(EventStreamEnded, map[string]interface{}{"manifestID": "a"})
+	shutdown()
+
+	if !sink.closed {
+		t.Error("Sink should be closed on shutdown")
+	}
+	if len(sink.events) != 2 {
+		t.Fatalf("Expected 2 events got %d", len(sink.events))
+	}
+	if sink.events[0].Type != EventStreamStarted || sink.events[1].Type != EventStreamEnded {
+		t.Errorf("Unexpected events %v %v", sink.events[0].Type, sink.events[1].Type)
+	}
+	if sink.events[0].NodeID != "node1" || sink.events[0].NodeType != "broadcaster" {
+		t.Errorf("Unexpected node %v %v", sink.events[0].NodeID, sink.events[0].NodeType)
+	}
+
+	// Events emitted after shutdown are ignored
+	EmitEvent(EventStreamStarted, nil)
+	if len(sink.events) != 2 {
+		t.Errorf("Expected 2 events got %d", len(sink.events))
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Type == EventTicketWon {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("fail"))
+		}
+	}))
+	defer ts.Close()
+
+	sink := NewWebhookSink(ts.URL)
+	event := &Event{Type: EventSegmentTranscoded, Data: map[string]interface{}{"seqNo": 1.0}}
+	data, _ := json.Marshal(event)
+	if err := sink.Send(context.Background(), event, data); err != nil {
+		t.Fatal(err)
+	}
+	if received.Type != EventSegmentTranscoded || received.Data["seqNo"] != 1.0 {
+		t.Errorf("Unexpected event received %+v", received)
+	}
+
+	event = &Event{Type: EventTicketWon}
+	data, _ = json.Marshal(event)
+	if err := sink.Send(context.Background(), event, data); err == nil {
+		t.Error("Expected error from webhook")
+	}
+}
@@ -0,0 +1,180 @@
+package monitor
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/NVIDIA/go-nvml/pkg/nvml"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// MaxEncoderSessions concurrent NVENC sessions a GPU supports; consumer
+// GPUs are capped by the driver. Zero if unlimited.
+var MaxEncoderSessions = 3
+
+// A warning is logged once this fraction of MaxEncoderSessions is in use
+var encoderSessionsWarnRatio = 0.8
+
+var gpuMetricsInterval = 10 * time.Second
+var getGPUMetricsTicker = func() *time.Ticker {
+	return time.NewTicker(gpuMetricsInterval)
+}
+
+var gpuUtilizationLock sync.Mutex
+var gpuUtilization = make(map[string]int64) // device:percent
+
+// GPUUtilization the latest utilization in percent of each monitored GPU
+func GPUUtilization() map[string]int64 {
+	gpuUtilizationLock.Lock()
+	defer gpuUtilizationLock.Unlock()
+	util := make(map[string]int64, len(gpuUtilization))
+	for id, u := range gpuUtilization {
+		util[id] = u
+	}
+	return util
+}
+
+type gpuDevice struct {
+	id     string
+	device nvml.Device
+	ctx    context.Context
+	warned bool
+}
+
+// StartGPUMonitor polls NVML for the utilization and health of the GPUs with
+// the given IDs, recording them as metrics if enabled. Returns an error if
+// NVML isn't available.
+func StartGPUMonitor(ids []string) error {
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		return nvmlError(ret)
+	}
+	var devices []*gpuDevice
+	for _, id := range ids {
+		idx, err := strconv.Atoi(id)
+		if err != nil {
+			glog.Errorf("Not monitoring GPU with invalid device ID=%s", id)
+			continue
+		}
+		device, ret := nvml.DeviceGetHandleByIndex(idx)
+		if ret != nvml.SUCCESS {
+			glog.Errorf("Not monitoring GPU device=%s err=%v", id, nvmlError(ret))
+			continue
+		}
+		d := &gpuDevice{id: id, device: device}
+		if Enabled {
+			ctx, err := tag.New(census.ctx, tag.Insert(census.kDevice, id))
+			if err != nil {
+				glog.Error("Error creating context", err)
+				continue
+			}
+			d.ctx = ctx
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		nvml.Shutdown()
+		return nil
+	}
+	ticker := getGPUMetricsTicker()
+	go func() {
+		for {
+			for _, d := range devices {
+				d.poll()
+			}
+			<-ticker.C
+		}
+	}()
+	return nil
+}
+
+// GPUInfo identifies a GPU found by FindGPUs
+type GPUInfo struct {
+	ID   string
+	Name string
+}
+
+// FindGPUs lists the Nvidia GPUs NVML can see, by the device IDs -nvidia
+// takes. Returns an error if NVML isn't available.
+func FindGPUs() ([]GPUInfo, error) {
+	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	defer nvml.Shutdown()
+	count, ret := nvml.DeviceGetCount()
+	if ret != nvml.SUCCESS {
+		return nil, nvmlError(ret)
+	}
+	gpus := make([]GPUInfo, 0, count)
+	for i := 0; i < count; i++ {
+		device, ret := nvml.DeviceGetHandleByIndex(i)
+		if ret != nvml.SUCCESS {
+			return nil, nvmlError(ret)
+		}
+		name, _ := device.GetName()
+		gpus = append(gpus, GPUInfo{ID: strconv.Itoa(i), Name: name})
+	}
+	return gpus, nil
+}
+
+type nvmlError nvml.Return
+
+func (e nvmlError) Error() string {
+	return nvml.ErrorString(nvml.Return(e))
+}
+
+func (d *gpuDevice) poll() {
+	var ms []stats.Measurement
+	record := func(m *stats.Int64Measure, v int64) {
+		if d.ctx != nil {
+			ms = append(ms, m.M(v))
+		}
+	}
+	if util, ret := d.device.GetUtilizationRates(); ret == nvml.SUCCESS {
+		record(census.mGPUUtilization, int64(util.Gpu))
+		gpuUtilizationLock.Lock()
+		gpuUtilization[d.id] = int64(util.Gpu)
+		gpuUtilizationLock.Unlock()
+	}
+	if util, _, ret := d.device.GetEncoderUtilization(); ret == nvml.SUCCESS {
+		record(census.mGPUEncoderUtilization, int64(util))
+	}
+	if util, _, ret := d.device.GetDecoderUtilization(); ret == nvml.SUCCESS {
+		record(census.mGPUDecoderUtilization, int64(util))
+	}
+	if mem, ret := d.device.GetMemoryInfo(); ret == nvml.SUCCESS {
+		record(census.mGPUMemoryUsed, int64(mem.Used))
+		record(census.mGPUMemoryTotal, int64(mem.Total))
+	}
+	if temp, ret := d.device.GetTemperature(nvml.TEMPERATURE_GPU); ret == nvml.SUCCESS {
+		record(census.mGPUTemperature, int64(temp))
+	}
+	// Not supported by consumer GPUs
+	if errs, ret := d.device.GetTotalEccErrors(nvml.MEMORY_ERROR_TYPE_UNCORRECTED, nvml.VOLATILE_ECC); ret == nvml.SUCCESS {
+		record(census.mGPUECCErrors, int64(errs))
+	}
+	if sessions, _, _, ret := d.device.GetEncoderStats(); ret == nvml.SUCCESS {
+		record(census.mGPUEncoderSessions, int64(sessions))
+		d.checkEncoderSessions(int(sessions))
+	}
+	if len(ms) > 0 {
+		stats.Record(d.ctx, ms...)
+	}
+}
+
+// checkEncoderSessions warns once the GPU gets close to running out of
+// encoder sessions, and again after it recovers and gets close again
+func (d *gpuDevice) checkEncoderSessions(sessions int) {
+	if MaxEncoderSessions <= 0 {
+		return
+	}
+	high := float64(sessions) >= encoderSessionsWarnRatio*float64(MaxEncoderSessions)
+	if high && !d.warned {
+		glog.Warningf("GPU device=%s is using %d of %d encoder sessions; transcoding will fail once out of sessions",
+			d.id, sessions, MaxEncoderSessions)
+	}
+	d.warned = high
+}
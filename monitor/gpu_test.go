@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+func TestCheckEncoderSessions(t *testing.T) {
+	defer func(max int) { MaxEncoderSessions = max }(MaxEncoderSessions)
+	var warnings []string
+	glog.SetHook(func(e *glog.Entry) {
+		if e.Severity == glog.WarningSeverity {
+			warnings = append(warnings, e.Message)
+		}
+	})
+	defer glog.SetHook(nil)
+
+	d := &gpuDevice{id: "0"}
+	MaxEncoderSessions = 5
+	for _, tt := range []struct {
+		sessions int
+		warned   bool
+		warnings int
+	}{
+		{1, false, 0},
+		{3, false, 0},
+		// at 80% of the sessions
+		{4, true, 1},
+		// once
+		{5, true, 1},
+		{4, true, 1},
+		// and again after recovering
+		{2, false, 1},
+		{5, true, 2},
+	} {
+		d.checkEncoderSessions(tt.sessions)
+		if d.warned != tt.warned || len(warnings) != tt.warnings {
+			t.Fatalf("With %d sessions expected warned=%v after %d warnings, got warned=%v after %d warnings",
+				tt.sessions, tt.warned, tt.warnings, d.warned, len(warnings))
+		}
+	}
+	if want := "GPU device=0 is using 4 of 5 encoder sessions; transcoding will fail once out of sessions"; warnings[0] != want {
+		t.Errorf("Expected warning %q, got %q", want, warnings[0])
+	}
+
+	// Unlimited sessions
+	MaxEncoderSessions = 0
+	d = &gpuDevice{id: "1"}
+	d.checkEncoderSessions(100)
+	if d.warned || len(warnings) != 2 {
+		t.Errorf("Expected no warning with unlimited sessions")
+	}
+}
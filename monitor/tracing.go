@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/livepeer/go-livepeer"
+
+// Span attribute keys shared by the nodes
+var (
+	AttrManifestID = attribute.Key("livepeer.manifest_id")
+	AttrSeqNo      = attribute.Key("livepeer.seq_no")
+	AttrNonce      = attribute.Key("livepeer.nonce")
+	AttrOrch       = attribute.Key("livepeer.orchestrator")
+)
+
+// TraceContextCarrier trace context passed between nodes outside of HTTP headers
+type TraceContextCarrier = propagation.MapCarrier
+
+var propagator = propagation.TraceContext{}
+
+// InitTracing exports spans to the OTLP collector listening on endpoint,
+// e.g. localhost:4317. sampleRatio is the fraction of new traces recorded;
+// traces started by other nodes are recorded if they were sampled there.
+// Returns a func flushing pending spans, to be called on shutdown.
+func InitTracing(endpoint string, insecure bool, nodeType, nodeID string, sampleRatio float64) (func(), error) {
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exp, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "livepeer"),
+		attribute.String("service.instance.id", nodeID),
+		attribute.String("livepeer.node_type", nodeType),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return func() {
+		tp.Shutdown(context.Background())
+	}, nil
+}
+
+// StartSpan starts a span as a child of the span in ctx, if any. Spans are
+// no-ops until tracing is initialized.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends span, marking it as failed if err is set
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectTraceHeaders adds the trace context of ctx to the headers of a request to another node
+func InjectTraceHeaders(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractTraceHeaders returns ctx with the trace context of a request from another node
+func ExtractTraceHeaders(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectTraceCarrier returns the trace context of ctx for messages other than
+// HTTP requests, or nil if there is none
+func InjectTraceCarrier(ctx context.Context) TraceContextCarrier {
+	carrier := TraceContextCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		// Not tracing
+		return nil
+	}
+	return carrier
+}
+
+// ExtractTraceCarrier returns ctx with the trace context in carrier
+func ExtractTraceCarrier(ctx context.Context, carrier TraceContextCarrier) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, carrier)
+}
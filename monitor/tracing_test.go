@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracePropagation(t *testing.T) {
+	// Not tracing, there's nothing to pass on
+	ctx, span := StartSpan(context.Background(), "untraced")
+	header := http.Header{}
+	InjectTraceHeaders(ctx, header)
+	if len(header) != 0 {
+		t.Errorf("Expected no trace headers, got %v", header)
+	}
+	if carrier := InjectTraceCarrier(ctx); carrier != nil {
+		t.Errorf("Expected no trace carrier, got %v", carrier)
+	}
+	if got := ExtractTraceCarrier(ctx, nil); got != ctx {
+		t.Error("Expected the context to be kept without a trace carrier")
+	}
+	EndSpan(span, nil)
+
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	defer tp.Shutdown(context.Background())
+	otel.SetTracerProvider(tp)
+
+	ctx, span = StartSpan(context.Background(), "traced")
+	defer span.End()
+	want := span.SpanContext()
+
+	check := func(via string, ctx context.Context) {
+		got := trace.SpanContextFromContext(ctx)
+		if !got.IsRemote() {
+			t.Errorf("Expected a remote span context %s", via)
+		}
+		if got.TraceID() != want.TraceID() || got.SpanID() != want.SpanID() || !got.IsSampled() {
+			t.Errorf("Expected trace=%s span=%s sampled %s, got trace=%s span=%s sampled=%v",
+				want.TraceID(), want.SpanID(), via, got.TraceID(), got.SpanID(), got.IsSampled())
+		}
+	}
+
+	// Between nodes in HTTP headers
+	header = http.Header{}
+	InjectTraceHeaders(ctx, header)
+	if header.Get("traceparent") == "" {
+		t.Error("Expected a traceparent header")
+	}
+	check("in headers", ExtractTraceHeaders(context.Background(), header))
+
+	// and in other messages
+	carrier := InjectTraceCarrier(ctx)
+	if carrier == nil {
+		t.Fatal("Expected a trace carrier")
+	}
+	check("in a carrier", ExtractTraceCarrier(context.Background(), carrier))
+
+	// Spans continuing the trace are its children
+	child, cspan := StartSpan(ExtractTraceCarrier(context.Background(), carrier), "child")
+	EndSpan(cspan, nil)
+	if got := trace.SpanContextFromContext(child); got.TraceID() != want.TraceID() || got.SpanID() == want.SpanID() {
+		t.Errorf("Expected a child span of trace=%s, got trace=%s span=%s", want.TraceID(), got.TraceID(), got.SpanID())
+	}
+}
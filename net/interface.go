@@ -13,8 +13,23 @@ type OrchestratorPool interface {
 }
 
 type RemoteTranscoderInfo struct {
+	ID       string
 	Address  string
 	Capacity int
+	GPUs     int
+	Codecs   []string
+	// Accel is the hardware acceleration the transcoder transcodes with;
+	// empty if on the CPU
+	Accel string
+	// Load is the number of segments the transcoder is transcoding
+	Load     int
+	Draining bool
+	// LatencyMs is the moving average of the round trip time of the
+	// segments the transcoder transcoded
+	LatencyMs float64
+	Tags      []string
+	// FailedChecks is the number of health checks failed in a row
+	FailedChecks int
 }
 
 type NodeStatus struct {
@@ -209,10 +209,13 @@ type S3OSInfo struct {
 	// Needed for POST policy.
 	Credential string `protobuf:"bytes,5,opt,name=credential,proto3" json:"credential,omitempty"`
 	// Needed for POST policy.
-	XAmzDate             string   `protobuf:"bytes,6,opt,name=xAmzDate,proto3" json:"xAmzDate,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	XAmzDate string `protobuf:"bytes,6,opt,name=xAmzDate,proto3" json:"xAmzDate,omitempty"`
+	// Pre-signed PUT URLs keyed by object name (relative to key). When
+	// present, these are used instead of the POST policy.
+	PresignedUrls        map[string]string `protobuf:"bytes,7,rep,name=presignedUrls,proto3" json:"presignedUrls,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
 }
 
 func (m *S3OSInfo) Reset()         { *m = S3OSInfo{} }
@@ -282,6 +285,13 @@ func (m *S3OSInfo) GetXAmzDate() string {
 	return ""
 }
 
+func (m *S3OSInfo) GetPresignedUrls() map[string]string {
+	if m != nil {
+		return m.PresignedUrls
+	}
+	return nil
+}
+
 // The orchestrator sends this in response to `GetOrchestrator`, containing
 // miscellaneous data related to the job.
 type OrchestratorInfo struct {
@@ -613,12 +623,21 @@ func (*TranscodeResult) XXX_OneofWrappers() []interface{} {
 	}
 }
 
-// Sent by the transcoder to register itself to the orchestrator.
+// Sent by the transcoder to register itself to the orchestrator, and then
+// whenever its capacity changes.
 type RegisterRequest struct {
 	// Shared secret for auth
 	Secret string `protobuf:"bytes,1,opt,name=secret,proto3" json:"secret,omitempty"`
 	// Transcoder capacity
-	Capacity             int64    `protobuf:"varint,2,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	Capacity int64 `protobuf:"varint,2,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	// Number of GPUs the transcoder transcodes on; 0 if on the CPU
+	Gpus int64 `protobuf:"varint,3,opt,name=gpus,proto3" json:"gpus,omitempty"`
+	// Codecs the transcoder can decode and encode
+	Codecs []string `protobuf:"bytes,4,rep,name=codecs,proto3" json:"codecs,omitempty"`
+	// Identifies the transcoder across reconnects
+	Id string `protobuf:"bytes,5,opt,name=id,proto3" json:"id,omitempty"`
+	// Hardware acceleration the GPUs are used through; empty if on the CPU
+	Accel                string   `protobuf:"bytes,7,opt,name=accel,proto3" json:"accel,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -663,11 +682,50 @@ func (m *RegisterRequest) GetCapacity() int64 {
 	return 0
 }
 
+func (m *RegisterRequest) GetGpus() int64 {
+	if m != nil {
+		return m.Gpus
+	}
+	return 0
+}
+
+func (m *RegisterRequest) GetCodecs() []string {
+	if m != nil {
+		return m.Codecs
+	}
+	return nil
+}
+
+func (m *RegisterRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *RegisterRequest) GetAccel() string {
+	if m != nil {
+		return m.Accel
+	}
+	return ""
+}
+
 // Sent by the orchestrator to the transcoder
 type NotifySegment struct {
-	Url                  string   `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
-	TaskId               int64    `protobuf:"varint,16,opt,name=taskId,proto3" json:"taskId,omitempty"`
-	Profiles             []byte   `protobuf:"bytes,17,opt,name=profiles,proto3" json:"profiles,omitempty"`
+	Url      string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	TaskId   int64  `protobuf:"varint,16,opt,name=taskId,proto3" json:"taskId,omitempty"`
+	Profiles []byte `protobuf:"bytes,17,opt,name=profiles,proto3" json:"profiles,omitempty"`
+	// W3C trace context of the segment's trace
+	TraceContext map[string]string `protobuf:"bytes,18,rep,name=traceContext,proto3" json:"traceContext,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Token for the transcoder to authenticate with from now on, sent in
+	// place of a segment as the token is rotated
+	Token string `protobuf:"bytes,19,opt,name=token,proto3" json:"token,omitempty"`
+	// The transcoder is being drained: it gets no more segments, and is to
+	// disconnect and exit once those it has are done
+	Drain bool `protobuf:"varint,20,opt,name=drain,proto3" json:"drain,omitempty"`
+	// Stream the segment belongs to, for the transcoder to keep a session
+	// for between its segments
+	ManifestId           string   `protobuf:"bytes,21,opt,name=manifestId,proto3" json:"manifestId,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -719,6 +777,34 @@ func (m *NotifySegment) GetProfiles() []byte {
 	return nil
 }
 
+func (m *NotifySegment) GetTraceContext() map[string]string {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
+func (m *NotifySegment) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *NotifySegment) GetDrain() bool {
+	if m != nil {
+		return m.Drain
+	}
+	return false
+}
+
+func (m *NotifySegment) GetManifestId() string {
+	if m != nil {
+		return m.ManifestId
+	}
+	return ""
+}
+
 // Required parameters for probabilistic micropayment tickets
 type TicketParams struct {
 	// ETH address of the recipient
@@ -952,6 +1038,7 @@ func init() {
 	proto.RegisterType((*OrchestratorRequest)(nil), "net.OrchestratorRequest")
 	proto.RegisterType((*OSInfo)(nil), "net.OSInfo")
 	proto.RegisterType((*S3OSInfo)(nil), "net.S3OSInfo")
+	proto.RegisterMapType((map[string]string)(nil), "net.S3OSInfo.PresignedUrlsEntry")
 	proto.RegisterType((*OrchestratorInfo)(nil), "net.OrchestratorInfo")
 	proto.RegisterType((*SegData)(nil), "net.SegData")
 	proto.RegisterType((*TranscodedSegmentData)(nil), "net.TranscodedSegmentData")
@@ -959,6 +1046,7 @@ func init() {
 	proto.RegisterType((*TranscodeResult)(nil), "net.TranscodeResult")
 	proto.RegisterType((*RegisterRequest)(nil), "net.RegisterRequest")
 	proto.RegisterType((*NotifySegment)(nil), "net.NotifySegment")
+	proto.RegisterMapType((map[string]string)(nil), "net.NotifySegment.TraceContextEntry")
 	proto.RegisterType((*TicketParams)(nil), "net.TicketParams")
 	proto.RegisterType((*Ticket)(nil), "net.Ticket")
 	proto.RegisterType((*Payment)(nil), "net.Payment")
@@ -967,61 +1055,71 @@ func init() {
 func init() { proto.RegisterFile("net/lp_rpc.proto", fileDescriptor_034e29c79f9ba827) }
 
 var fileDescriptor_034e29c79f9ba827 = []byte{
-	// 860 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0x5f, 0x6f, 0xe3, 0x44,
-	0x10, 0xaf, 0xeb, 0xd4, 0x4d, 0x27, 0x09, 0x4d, 0xf7, 0xee, 0x8a, 0x89, 0xe0, 0x14, 0x0c, 0x27,
-	0x95, 0x97, 0x80, 0x52, 0xe9, 0x10, 0x6f, 0xdc, 0xd1, 0xd2, 0x46, 0x42, 0x6d, 0xb4, 0x09, 0x48,
-	0x3c, 0x45, 0x5b, 0x7b, 0x92, 0x5a, 0x4d, 0xd7, 0xbe, 0xdd, 0x0d, 0x77, 0xe1, 0x5b, 0xf0, 0x15,
-	0x80, 0x27, 0xbe, 0x0a, 0x5f, 0x0a, 0xed, 0xac, 0xed, 0x38, 0xd7, 0x3b, 0xe0, 0x6d, 0xe7, 0xcf,
-	0x8e, 0x7f, 0xf3, 0x9b, 0xdf, 0xac, 0xa1, 0x2b, 0xd1, 0x7c, 0xb9, 0xcc, 0x67, 0x2a, 0x8f, 0x07,
-	0xb9, 0xca, 0x4c, 0xc6, 0x7c, 0x89, 0x26, 0xea, 0x43, 0x73, 0x9c, 0xca, 0xc5, 0x38, 0x93, 0x0b,
-	0xf6, 0x18, 0xf6, 0x7e, 0x11, 0xcb, 0x15, 0x86, 0x5e, 0xdf, 0x3b, 0x69, 0x73, 0x67, 0x44, 0x2f,
-	0xe0, 0xd1, 0xb5, 0x8a, 0x6f, 0x51, 0x1b, 0x25, 0x4c, 0xa6, 0x38, 0xbe, 0x5a, 0xa1, 0x36, 0x2c,
-	0x84, 0x7d, 0x91, 0x24, 0x0a, 0xb5, 0x2e, 0xd2, 0x4b, 0x93, 0x75, 0xc1, 0xd7, 0xe9, 0x22, 0xdc,
-	0x25, 0xaf, 0x3d, 0x46, 0x7f, 0x78, 0x10, 0x5c, 0x4f, 0x46, 0x72, 0x9e, 0xb1, 0x6f, 0xa0, 0xa5,
-	0x4d, 0xa6, 0xc4, 0x02, 0xa7, 0xeb, 0xdc, 0x7d, 0xe9, 0x83, 0xe1, 0x87, 0x03, 0x89, 0x66, 0xe0,
-	0x32, 0x06, 0x93, 0x4d, 0x98, 0xd7, 0x73, 0xd9, 0x33, 0x08, 0xf4, 0x69, 0x2a, 0xe7, 0x59, 0xd8,
-	0xed, 0x7b, 0x27, 0xad, 0x61, 0x87, 0x6e, 0x4d, 0x4e, 0xdd, 0x3d, 0x5e, 0x04, 0xa3, 0xaf, 0xa1,
-	0x55, 0x2b, 0xc1, 0x00, 0x82, 0xb3, 0x11, 0x3f, 0xff, 0x6e, 0xda, 0xdd, 0x61, 0x01, 0xec, 0x4e,
-	0x4e, 0xbb, 0x1e, 0x6b, 0x42, 0x63, 0x34, 0xfe, 0x7e, 0xd2, 0xdd, 0xb5, 0xd1, 0x8b, 0xeb, 0xeb,
-	0x8b, 0x1f, 0xce, 0xbb, 0x7e, 0xf4, 0xbb, 0x07, 0xcd, 0xb2, 0x1a, 0x63, 0xd0, 0xb8, 0xcd, 0xb4,
-	0x21, 0x80, 0x07, 0x9c, 0xce, 0xb6, 0xb1, 0x3b, 0x5c, 0x53, 0x63, 0x07, 0xdc, 0x1e, 0xd9, 0x31,
-	0x04, 0x79, 0xb6, 0x4c, 0xe3, 0x75, 0xe8, 0x93, 0xb3, 0xb0, 0xd8, 0xc7, 0x70, 0xa0, 0xd3, 0x85,
-	0x14, 0x66, 0xa5, 0x30, 0x6c, 0x50, 0x68, 0xe3, 0x60, 0x4f, 0x01, 0x62, 0x85, 0x09, 0x4a, 0x93,
-	0x8a, 0x65, 0xb8, 0x47, 0xe1, 0x9a, 0x87, 0xf5, 0xa0, 0xf9, 0xe6, 0xc5, 0xfd, 0xaf, 0x67, 0xc2,
-	0x60, 0x18, 0x50, 0xb4, 0xb2, 0xa3, 0xdf, 0x3c, 0xe8, 0xd6, 0xc7, 0x41, 0x60, 0x9f, 0x02, 0x18,
-	0x25, 0xa4, 0x8e, 0xb3, 0x04, 0x55, 0x01, 0xb9, 0xe6, 0x61, 0xcf, 0xa1, 0x63, 0xd2, 0xf8, 0x0e,
-	0xcd, 0x2c, 0x17, 0x4a, 0xdc, 0x6b, 0x6a, 0xa1, 0x35, 0x3c, 0x22, 0x02, 0xa7, 0x14, 0x19, 0x53,
-	0x80, 0xb7, 0x4d, 0xcd, 0x62, 0xcf, 0x60, 0xbf, 0x18, 0x40, 0xd8, 0xef, 0xfb, 0x27, 0xad, 0x61,
-	0xab, 0x36, 0x28, 0x5e, 0xc6, 0xa2, 0x3f, 0x3d, 0xd8, 0x9f, 0xe0, 0xe2, 0x4c, 0x18, 0x61, 0xa1,
-	0xdc, 0x0b, 0x99, 0xce, 0x51, 0x9b, 0x51, 0x52, 0x28, 0xa3, 0xe6, 0x21, 0x71, 0xe0, 0x2b, 0x02,
-	0xe0, 0x73, 0x7b, 0x24, 0xa6, 0x85, 0xbe, 0x25, 0x06, 0xdb, 0x9c, 0xce, 0x96, 0x81, 0x5c, 0x65,
-	0xf3, 0x74, 0x89, 0x9a, 0xe8, 0x6b, 0xf3, 0xca, 0x2e, 0xe5, 0xb5, 0x57, 0xc9, 0xeb, 0xff, 0xc2,
-	0xfc, 0x02, 0x9e, 0x4c, 0x4b, 0x4e, 0x92, 0x09, 0x2e, 0xee, 0x51, 0x1a, 0xc2, 0xdc, 0x05, 0x7f,
-	0xa5, 0x96, 0x05, 0x6f, 0xf6, 0x18, 0xfd, 0x0c, 0x9d, 0x2a, 0x95, 0x52, 0x9e, 0x43, 0x53, 0xbb,
-	0x1b, 0x56, 0xee, 0xf6, 0x1b, 0x3d, 0x47, 0xde, 0xbb, 0x0a, 0xf2, 0x2a, 0xf7, 0x1d, 0xbb, 0x90,
-	0xc1, 0x61, 0x75, 0x89, 0xa3, 0x5e, 0x2d, 0x4d, 0xc9, 0x89, 0xb7, 0xe1, 0xe4, 0x18, 0xf6, 0x50,
-	0xa9, 0x4c, 0x39, 0xad, 0x5d, 0xee, 0x70, 0x67, 0xb2, 0x13, 0x68, 0x24, 0xc2, 0x08, 0xe2, 0xaa,
-	0x35, 0x64, 0xdb, 0x10, 0xec, 0xa7, 0x2f, 0x77, 0x38, 0x65, 0xbc, 0x6c, 0x42, 0xa0, 0xa8, 0x7a,
-	0x74, 0x0e, 0x87, 0x1c, 0x17, 0xa9, 0x36, 0x58, 0xed, 0xee, 0x31, 0x04, 0x1a, 0x63, 0x85, 0xa5,
-	0xbc, 0x0b, 0xcb, 0xd2, 0x1e, 0x8b, 0x5c, 0xc4, 0xa9, 0x59, 0x17, 0x13, 0xaa, 0xec, 0xe8, 0x47,
-	0xe8, 0x5c, 0x65, 0x26, 0x9d, 0xaf, 0x8b, 0x46, 0x1f, 0xb2, 0x66, 0xcb, 0x1a, 0xa1, 0xef, 0x46,
-	0x09, 0x2d, 0xa8, 0xcf, 0x0b, 0x6b, 0x6b, 0x9a, 0x47, 0xdb, 0xd3, 0x8c, 0xfe, 0xf2, 0xa0, 0x5d,
-	0x57, 0xa0, 0x5d, 0x1d, 0x85, 0x71, 0x9a, 0xa7, 0x28, 0x4d, 0xa1, 0x9f, 0x8d, 0x83, 0x7d, 0x02,
-	0x30, 0x17, 0x31, 0xce, 0xdc, 0x3b, 0xe5, 0x68, 0x3d, 0xb0, 0x9e, 0x9f, 0xac, 0x83, 0x7d, 0x04,
-	0xcd, 0xd7, 0xa9, 0x9c, 0xe5, 0x2a, 0xbb, 0x29, 0xf4, 0xb4, 0xff, 0x3a, 0x95, 0x63, 0x95, 0xdd,
-	0xb0, 0x01, 0x3c, 0xaa, 0xca, 0xcc, 0x94, 0x90, 0xc9, 0x8c, 0x54, 0xe7, 0xd4, 0x75, 0x54, 0x85,
-	0xb8, 0x90, 0xc9, 0xa5, 0x95, 0x20, 0x83, 0x86, 0x46, 0x4c, 0x0a, 0x9d, 0xd1, 0x39, 0xfa, 0xdb,
-	0x83, 0xc0, 0x81, 0xfd, 0x0f, 0x98, 0x44, 0xb0, 0xb4, 0xcb, 0xe8, 0x20, 0x16, 0xd6, 0x5b, 0xf0,
-	0xfd, 0x7f, 0x83, 0xdf, 0xd8, 0x86, 0xff, 0x29, 0xb4, 0x5d, 0x8d, 0x99, 0xcc, 0x64, 0x8c, 0x04,
-	0xab, 0xc3, 0x5b, 0xce, 0x77, 0x65, 0x5d, 0xef, 0xeb, 0x30, 0x78, 0x4f, 0x87, 0xd1, 0x14, 0xf6,
-	0xc7, 0x62, 0x4d, 0xb3, 0xfc, 0x0c, 0x02, 0xb7, 0xf8, 0xd4, 0x4a, 0xb9, 0x40, 0xae, 0x55, 0x5e,
-	0x84, 0x1e, 0x6a, 0xb9, 0xe2, 0xc8, 0xdf, 0x70, 0x34, 0x7c, 0x03, 0xed, 0xfa, 0xfb, 0xc4, 0x5e,
-	0xc2, 0xe1, 0x05, 0x9a, 0x2d, 0x57, 0xe8, 0xd6, 0xf3, 0xe1, 0x4f, 0xa5, 0xf7, 0xe4, 0x41, 0x84,
-	0xde, 0xb7, 0xcf, 0xa1, 0x61, 0x7f, 0x52, 0xcc, 0xbd, 0xf8, 0xe5, 0xff, 0xaa, 0xb7, 0x6d, 0x0e,
-	0xaf, 0x00, 0xa6, 0x9b, 0x37, 0xef, 0x5b, 0x60, 0xa5, 0xec, 0x6b, 0xde, 0xc7, 0x74, 0xe5, 0xad,
-	0x7d, 0xe8, 0xb9, 0x45, 0xda, 0x92, 0xf7, 0x57, 0xde, 0x4d, 0x40, 0xbf, 0xc9, 0xd3, 0x7f, 0x02,
-	0x00, 0x00, 0xff, 0xff, 0xf9, 0x62, 0x9c, 0x14, 0x3a, 0x07, 0x00, 0x00,
+	// 1015 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x03, 0x95, 0x56, 0xcd, 0x6e, 0xdb, 0x46,
+	0x10, 0x0e, 0x45, 0x99, 0x92, 0x47, 0x52, 0x4c, 0xaf, 0xed, 0x94, 0x15, 0xda, 0x42, 0x61, 0x53,
+	0xc0, 0xbd, 0x28, 0x81, 0x0c, 0xa4, 0x49, 0x2f, 0x6d, 0x9c, 0x38, 0xb6, 0x8b, 0x22, 0x16, 0x56,
+	0x6a, 0x81, 0x9e, 0x04, 0x86, 0x5c, 0xc9, 0x84, 0x65, 0x92, 0x21, 0x57, 0x8d, 0x95, 0xb7, 0xe8,
+	0x03, 0xf4, 0xd4, 0x9e, 0x8a, 0xbe, 0x49, 0x5f, 0xaa, 0xb3, 0xb3, 0x24, 0x45, 0x59, 0x49, 0x7f,
+	0x6e, 0xf3, 0xcd, 0xcc, 0xce, 0xff, 0x0c, 0x09, 0x76, 0x24, 0xe4, 0xc3, 0x79, 0x32, 0x49, 0x13,
+	0xbf, 0x9f, 0xa4, 0xb1, 0x8c, 0x99, 0x89, 0x1c, 0xb7, 0x07, 0xcd, 0x61, 0x18, 0xcd, 0x86, 0x71,
+	0x34, 0x63, 0xfb, 0xb0, 0xf5, 0xb3, 0x37, 0x5f, 0x08, 0xc7, 0xe8, 0x19, 0x87, 0x6d, 0xae, 0x81,
+	0xfb, 0x0c, 0xf6, 0x2e, 0x52, 0xff, 0x52, 0x64, 0x32, 0xf5, 0x64, 0x9c, 0x72, 0xf1, 0x66, 0x81,
+	0x34, 0x73, 0xa0, 0xe1, 0x05, 0x41, 0x2a, 0xb2, 0x2c, 0x57, 0x2f, 0x20, 0xb3, 0xc1, 0xcc, 0xc2,
+	0x99, 0x53, 0x23, 0xae, 0x22, 0xdd, 0xdf, 0x0c, 0xb0, 0x2e, 0x46, 0xe7, 0xd1, 0x34, 0x66, 0x4f,
+	0xa1, 0x95, 0xa1, 0x15, 0x6f, 0x26, 0xc6, 0xcb, 0x44, 0x7b, 0xba, 0x3b, 0xf8, 0xa8, 0x8f, 0xa1,
+	0xf4, 0xb5, 0x46, 0x7f, 0xb4, 0x12, 0xf3, 0xaa, 0x2e, 0xfb, 0x02, 0xac, 0xec, 0x28, 0x44, 0x15,
+	0xc7, 0xc6, 0x57, 0xad, 0x41, 0x87, 0x5e, 0x8d, 0x8e, 0xf4, 0x3b, 0x9e, 0x0b, 0xdd, 0xaf, 0xa0,
+	0x55, 0x31, 0xc1, 0x00, 0xac, 0x17, 0xe7, 0xfc, 0xe4, 0xf9, 0xd8, 0xbe, 0xc3, 0x2c, 0xa8, 0x8d,
+	0x8e, 0x6c, 0x83, 0x35, 0xa1, 0x7e, 0x3e, 0x7c, 0x39, 0xb2, 0x6b, 0x4a, 0x7a, 0x7a, 0x71, 0x71,
+	0xfa, 0xfd, 0x89, 0x6d, 0xba, 0x7f, 0xd6, 0xa0, 0x59, 0x58, 0x63, 0x0c, 0xea, 0x97, 0x71, 0x26,
+	0x29, 0xc0, 0x6d, 0x4e, 0xb4, 0x4a, 0xec, 0x4a, 0x2c, 0x29, 0xb1, 0x6d, 0xae, 0x48, 0x76, 0x0f,
+	0xac, 0x24, 0x9e, 0x87, 0xfe, 0xd2, 0x31, 0x89, 0x99, 0x23, 0xf6, 0x09, 0x6c, 0x63, 0xde, 0x91,
+	0x27, 0x17, 0xa9, 0x70, 0xea, 0x24, 0x5a, 0x31, 0xd8, 0x67, 0x00, 0x7e, 0x2a, 0x02, 0x11, 0xc9,
+	0xd0, 0x9b, 0x3b, 0x5b, 0x24, 0xae, 0x70, 0x58, 0x17, 0x9a, 0x37, 0xcf, 0xae, 0xdf, 0xbd, 0xf0,
+	0xa4, 0x70, 0x2c, 0x92, 0x96, 0x98, 0xbd, 0x84, 0x4e, 0x82, 0x55, 0x46, 0x5b, 0x22, 0xf8, 0x21,
+	0x9d, 0x67, 0x4e, 0xa3, 0x67, 0x62, 0x2d, 0x7a, 0x6b, 0xb5, 0xe8, 0x0f, 0xab, 0x2a, 0x27, 0x91,
+	0x4c, 0x97, 0x7c, 0xfd, 0x59, 0xf7, 0x5b, 0x60, 0x9b, 0x4a, 0x45, 0x86, 0xc6, 0x2a, 0xc3, 0x72,
+	0x26, 0x74, 0xd6, 0x1a, 0x7c, 0x5d, 0x7b, 0x62, 0xb8, 0xbf, 0x18, 0x60, 0x57, 0x07, 0x83, 0xca,
+	0x86, 0xa9, 0x21, 0x8a, 0x32, 0x3f, 0x0e, 0x44, 0x9a, 0xdb, 0xa9, 0x70, 0xd8, 0x63, 0xe8, 0xc8,
+	0xd0, 0xbf, 0x12, 0x72, 0x92, 0x78, 0xa9, 0x77, 0x9d, 0x91, 0xd9, 0xd6, 0x60, 0x97, 0xc2, 0x1f,
+	0x93, 0x64, 0x48, 0x02, 0xde, 0x96, 0x15, 0x84, 0xbd, 0x6f, 0xe4, 0xa3, 0xe0, 0xf4, 0x28, 0xe1,
+	0x56, 0x65, 0x64, 0x78, 0x21, 0x73, 0x7f, 0x37, 0xa0, 0x31, 0x12, 0x33, 0xac, 0x94, 0xa7, 0x42,
+	0xb9, 0xf6, 0xa2, 0x70, 0x8a, 0xf1, 0x9d, 0x07, 0xf9, 0x8c, 0x56, 0x38, 0x34, 0xa6, 0xe2, 0x0d,
+	0x05, 0x60, 0x72, 0x45, 0x52, 0xcf, 0xbd, 0xec, 0x92, 0x7a, 0xd9, 0xe6, 0x44, 0xab, 0x5e, 0xe0,
+	0xb6, 0x4c, 0xc3, 0xb9, 0xc8, 0xa8, 0x91, 0x6d, 0x5e, 0xe2, 0x62, 0xd0, 0xb7, 0xca, 0x41, 0xff,
+	0xaf, 0x61, 0x7e, 0x09, 0x07, 0xe3, 0xa2, 0x26, 0x01, 0xc6, 0x7b, 0x8d, 0x8d, 0xa7, 0x98, 0xd1,
+	0xe2, 0x22, 0x9d, 0x17, 0xf5, 0x47, 0xd2, 0xfd, 0x09, 0x3a, 0xa5, 0x2a, 0xa9, 0x3c, 0x86, 0x66,
+	0xa6, 0x5f, 0xa8, 0xc5, 0x53, 0x3e, 0xba, 0xba, 0x78, 0xef, 0x33, 0xc8, 0x4b, 0xdd, 0xf7, 0x6c,
+	0x65, 0x0c, 0x3b, 0xe5, 0x23, 0x2e, 0xb2, 0xc5, 0x5c, 0x16, 0x35, 0x31, 0x56, 0x35, 0xb9, 0x07,
+	0x5b, 0x22, 0x4d, 0xe3, 0x54, 0xf7, 0xff, 0xec, 0x0e, 0xd7, 0x90, 0x1d, 0x42, 0x3d, 0x40, 0x07,
+	0x54, 0xab, 0xd6, 0x80, 0xad, 0x87, 0xa0, 0x5c, 0xa3, 0x2a, 0x69, 0x1c, 0x37, 0xc1, 0x4a, 0xc9,
+	0xba, 0xfb, 0xab, 0x01, 0x3b, 0x5c, 0xcc, 0xc2, 0x4c, 0x8a, 0xf2, 0x8c, 0xe0, 0x06, 0x65, 0x02,
+	0x67, 0xbf, 0xd8, 0xb4, 0x1c, 0xa9, 0xba, 0xfb, 0x5e, 0xe2, 0xf9, 0xa1, 0x5c, 0xe6, 0x2d, 0x2a,
+	0xb1, 0xea, 0xd3, 0x2c, 0x59, 0x64, 0xe4, 0xdb, 0xe4, 0x44, 0x2b, 0x3b, 0xca, 0xb3, 0xaf, 0xba,
+	0x64, 0x2a, 0x3b, 0x1a, 0xb1, 0xbb, 0x50, 0x0b, 0x83, 0x7c, 0xc7, 0x90, 0x52, 0xf3, 0xec, 0xf9,
+	0xbe, 0x98, 0xe3, 0xde, 0xd0, 0x3c, 0x13, 0xf8, 0xae, 0xde, 0xb4, 0xec, 0x86, 0x3a, 0x00, 0x9d,
+	0x57, 0xb1, 0x0c, 0xa7, 0xcb, 0xbc, 0x84, 0x9b, 0xfd, 0x50, 0x7e, 0xa4, 0x97, 0x5d, 0xe1, 0x44,
+	0xd9, 0xe4, 0x3d, 0x47, 0x6b, 0x73, 0xb2, 0x7b, 0x6b, 0x4e, 0xce, 0xa0, 0x8d, 0x2b, 0xe0, 0x8b,
+	0xe7, 0x71, 0x24, 0xc5, 0x8d, 0x74, 0x18, 0xb5, 0xed, 0x01, 0xd5, 0x6c, 0xcd, 0x9f, 0xaa, 0x60,
+	0xa9, 0xa6, 0xd7, 0x76, 0xed, 0xa5, 0x8a, 0x5e, 0xc6, 0x57, 0x22, 0x72, 0xf6, 0x74, 0xf4, 0x04,
+	0x14, 0x37, 0x48, 0xbd, 0x30, 0x72, 0xf6, 0x91, 0xdb, 0xe4, 0x1a, 0xdc, 0x9a, 0xff, 0x03, 0xbd,
+	0x8a, 0x2b, 0x4e, 0xf7, 0x1b, 0xd8, 0xdd, 0x70, 0xf7, 0xbf, 0x0e, 0xc0, 0x1f, 0x06, 0xb4, 0xab,
+	0x2b, 0xab, 0xae, 0x5e, 0x2a, 0xfc, 0x30, 0x09, 0x31, 0x95, 0x7c, 0xe1, 0x56, 0x0c, 0xf6, 0x29,
+	0xc0, 0x14, 0xdd, 0x4d, 0x56, 0xd6, 0x50, 0xac, 0x38, 0x3f, 0x2a, 0x06, 0xfb, 0x18, 0x9a, 0x6f,
+	0xc3, 0x68, 0x82, 0x45, 0x7b, 0x9d, 0x2f, 0x60, 0x03, 0xf1, 0x10, 0x21, 0xeb, 0xc3, 0x5e, 0x69,
+	0x66, 0x82, 0x33, 0x16, 0x4c, 0x68, 0x4d, 0xf5, 0x3a, 0xee, 0x96, 0x22, 0x8e, 0x92, 0x33, 0xb5,
+	0xb3, 0x38, 0x1f, 0x99, 0x10, 0x41, 0xbe, 0x98, 0x44, 0xbb, 0x7f, 0xe1, 0x27, 0x48, 0x07, 0xfb,
+	0x2f, 0x61, 0xd2, 0x40, 0x46, 0xea, 0x7a, 0xe9, 0x10, 0x73, 0x74, 0x2b, 0x7c, 0xf3, 0x9f, 0xc2,
+	0xaf, 0xaf, 0x87, 0x7f, 0x1f, 0xda, 0xda, 0xc6, 0x24, 0x8a, 0x23, 0x5f, 0x50, 0x58, 0x1d, 0xfc,
+	0xb4, 0x11, 0xef, 0x95, 0x62, 0x7d, 0x28, 0x43, 0xeb, 0x03, 0x19, 0xba, 0x63, 0x68, 0x0c, 0xbd,
+	0x25, 0x8d, 0xe8, 0xe7, 0x38, 0x90, 0x94, 0x17, 0xa5, 0x52, 0x5c, 0x1c, 0x9d, 0x2a, 0xcf, 0x45,
+	0x9b, 0xcb, 0x5f, 0xd6, 0xc8, 0x5c, 0xd5, 0x68, 0x70, 0x03, 0xed, 0xea, 0x41, 0x67, 0xc7, 0xb0,
+	0x73, 0x2a, 0xe4, 0x1a, 0xcb, 0xd1, 0xf7, 0x6c, 0xf3, 0x7f, 0xa0, 0x7b, 0xb0, 0x21, 0xa1, 0x0f,
+	0xc2, 0x03, 0xa8, 0xab, 0xff, 0x0b, 0xa6, 0x3f, 0xd6, 0xc5, 0xaf, 0x46, 0x77, 0x1d, 0x0e, 0x86,
+	0x00, 0xe3, 0xd5, 0x47, 0xe2, 0x18, 0x58, 0x71, 0x26, 0x2a, 0xdc, 0x7d, 0x7a, 0x72, 0xeb, 0x7e,
+	0x74, 0xd9, 0xe6, 0x16, 0x1d, 0x1a, 0x8f, 0x8c, 0xd7, 0x16, 0xfd, 0xe3, 0x1c, 0xfd, 0x0d, 0xcc,
+	0x88, 0xfd, 0xad, 0xf7, 0x08, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1148,7 +1246,8 @@ var _Orchestrator_serviceDesc = grpc.ServiceDesc{
 type TranscoderClient interface {
 	// Called by the transcoder to register to an orchestrator. The orchestrator
 	// notifies registered transcoders of segments as they come in.
-	RegisterTranscoder(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (Transcoder_RegisterTranscoderClient, error)
+	// Transcoders send their capacity again over the stream as it changes.
+	RegisterTranscoder(ctx context.Context, opts ...grpc.CallOption) (Transcoder_RegisterTranscoderClient, error)
 }
 
 type transcoderClient struct {
@@ -1159,22 +1258,17 @@ func NewTranscoderClient(cc *grpc.ClientConn) TranscoderClient {
 	return &transcoderClient{cc}
 }
 
-func (c *transcoderClient) RegisterTranscoder(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (Transcoder_RegisterTranscoderClient, error) {
+func (c *transcoderClient) RegisterTranscoder(ctx context.Context, opts ...grpc.CallOption) (Transcoder_RegisterTranscoderClient, error) {
 	stream, err := c.cc.NewStream(ctx, &_Transcoder_serviceDesc.Streams[0], "/net.Transcoder/RegisterTranscoder", opts...)
 	if err != nil {
 		return nil, err
 	}
 	x := &transcoderRegisterTranscoderClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
-	}
 	return x, nil
 }
 
 type Transcoder_RegisterTranscoderClient interface {
+	Send(*RegisterRequest) error
 	Recv() (*NotifySegment, error)
 	grpc.ClientStream
 }
@@ -1183,6 +1277,10 @@ type transcoderRegisterTranscoderClient struct {
 	grpc.ClientStream
 }
 
+func (x *transcoderRegisterTranscoderClient) Send(m *RegisterRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
 func (x *transcoderRegisterTranscoderClient) Recv() (*NotifySegment, error) {
 	m := new(NotifySegment)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
@@ -1195,14 +1293,15 @@ func (x *transcoderRegisterTranscoderClient) Recv() (*NotifySegment, error) {
 type TranscoderServer interface {
 	// Called by the transcoder to register to an orchestrator. The orchestrator
 	// notifies registered transcoders of segments as they come in.
-	RegisterTranscoder(*RegisterRequest, Transcoder_RegisterTranscoderServer) error
+	// Transcoders send their capacity again over the stream as it changes.
+	RegisterTranscoder(Transcoder_RegisterTranscoderServer) error
 }
 
 // UnimplementedTranscoderServer can be embedded to have forward compatible implementations.
 type UnimplementedTranscoderServer struct {
 }
 
-func (*UnimplementedTranscoderServer) RegisterTranscoder(req *RegisterRequest, srv Transcoder_RegisterTranscoderServer) error {
+func (*UnimplementedTranscoderServer) RegisterTranscoder(srv Transcoder_RegisterTranscoderServer) error {
 	return status.Errorf(codes.Unimplemented, "method RegisterTranscoder not implemented")
 }
 
@@ -1211,15 +1310,12 @@ func RegisterTranscoderServer(s *grpc.Server, srv TranscoderServer) {
 }
 
 func _Transcoder_RegisterTranscoder_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(RegisterRequest)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
-	}
-	return srv.(TranscoderServer).RegisterTranscoder(m, &transcoderRegisterTranscoderServer{stream})
+	return srv.(TranscoderServer).RegisterTranscoder(&transcoderRegisterTranscoderServer{stream})
 }
 
 type Transcoder_RegisterTranscoderServer interface {
 	Send(*NotifySegment) error
+	Recv() (*RegisterRequest, error)
 	grpc.ServerStream
 }
 
@@ -1231,6 +1327,14 @@ func (x *transcoderRegisterTranscoderServer) Send(m *NotifySegment) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func (x *transcoderRegisterTranscoderServer) Recv() (*RegisterRequest, error) {
+	m := new(RegisterRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Transcoder_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "net.Transcoder",
 	HandlerType: (*TranscoderServer)(nil),
@@ -1240,6 +1344,7 @@ var _Transcoder_serviceDesc = grpc.ServiceDesc{
 			StreamName:    "RegisterTranscoder",
 			Handler:       _Transcoder_RegisterTranscoder_Handler,
 			ServerStreams: true,
+			ClientStreams: true,
 		},
 	},
 	Metadata: "net/lp_rpc.proto",
@@ -9,6 +9,7 @@ import (
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/pkg/errors"
 )
 
@@ -165,9 +166,16 @@ func (r *recipient) redeemWinningTicket(ticket *Ticket, sig []byte, recipientRan
 	// Assume that that this call will return immediately if there
 	// is an error in transaction submission. Else, the function will kick off
 	// a goroutine and then return to the caller
-	if _, err := r.broker.RedeemWinningTicket(ticket, sig, recipientRand); err != nil {
+	tx, err := r.broker.RedeemWinningTicket(ticket, sig, recipientRand)
+	if err != nil {
 		return err
 	}
+	if tx != nil {
+		// Only affects the reporting of pending tickets; not worth failing over
+		if err := r.store.MarkWinningTicketRedeemed(sig, tx.Hash()); err != nil {
+			glog.Errorf("Error marking winning ticket redeemed: %v", err)
+		}
+	}
 
 	// If there is no error, the transaction has been submitted. As a result,
 	// we assume that recipientRand has been revealed so we should invalidate it locally
@@ -573,6 +573,10 @@ func TestRedeemWinningTickets_SingleTicket(t *testing.T) {
 		t.Error("expected used ticket")
 	}
 
+	if _, ok := ts.redeemed[string(sig)]; !ok {
+		t.Error("expected ticket to be marked redeemed")
+	}
+
 	recipientRand := genRecipientRand(sender, secret, params.Seed)
 
 	if _, ok := r.(*recipient).invalidRands.Load(recipientRand.String()); !ok {
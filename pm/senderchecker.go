@@ -0,0 +1,146 @@
+package pm
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/pkg/errors"
+)
+
+// senderCheckStaleTTLs is for how many TTLs the last result of checking a
+// sender is served while its funds can't be looked up
+const senderCheckStaleTTLs = 10
+
+// SenderChecker is an interface which describes an object capable of
+// checking senders have the funds to pay for the work they submit, before
+// any is done
+type SenderChecker interface {
+	// CheckSender returns an error if the sender's deposit or reserve is
+	// below the minimums
+	CheckSender(addr ethcommon.Address) error
+}
+
+// senderCheck is the result of checking a sender, and when it was checked
+type senderCheck struct {
+	err     error
+	checked time.Time
+}
+
+// senderLookup is a lookup of a sender's funds in flight, whose result is
+// shared by all the checks of the sender made in the meantime
+type senderLookup struct {
+	done chan struct{}
+	err  error
+}
+
+// senderChecker is an implementation of the SenderChecker interface that
+// caches the result of checking each sender with the broker for ttl
+type senderChecker struct {
+	broker     Broker
+	minDeposit *big.Int
+	minReserve *big.Int
+	ttl        time.Duration
+
+	mu        sync.Mutex
+	checks    map[ethcommon.Address]senderCheck
+	lookups   map[ethcommon.Address]*senderLookup
+	lastSweep time.Time
+
+	now func() time.Time
+}
+
+// NewSenderChecker creates an instance of a sender checker. Senders must have
+// a deposit of at least minDeposit and a reserve of at least minReserve, and
+// can't have neither.
+func NewSenderChecker(broker Broker, minDeposit, minReserve *big.Int, ttl time.Duration) SenderChecker {
+	if minDeposit == nil {
+		minDeposit = big.NewInt(0)
+	}
+	if minReserve == nil {
+		minReserve = big.NewInt(0)
+	}
+	return &senderChecker{
+		broker:     broker,
+		minDeposit: minDeposit,
+		minReserve: minReserve,
+		ttl:        ttl,
+		checks:     make(map[ethcommon.Address]senderCheck),
+		lookups:    make(map[ethcommon.Address]*senderLookup),
+		lastSweep:  time.Now(),
+		now:        time.Now,
+	}
+}
+
+// CheckSender returns an error if the sender's deposit or reserve is below
+// the minimums, looking up its funds with the broker unless they were
+// checked within the TTL. Concurrent checks of a sender share one lookup.
+// If the funds can't be looked up, the last result is returned, or the
+// sender is let through if it was never checked: work isn't refused for
+// the eth node being unreachable.
+func (c *senderChecker) CheckSender(addr ethcommon.Address) error {
+	now := c.now()
+	c.mu.Lock()
+	check, checked := c.checks[addr]
+	if checked && now.Sub(check.checked) < c.ttl {
+		c.mu.Unlock()
+		return check.err
+	}
+	if l, ok := c.lookups[addr]; ok {
+		c.mu.Unlock()
+		<-l.done
+		return l.err
+	}
+	l := &senderLookup{done: make(chan struct{})}
+	c.lookups[addr] = l
+	c.mu.Unlock()
+
+	info, err := c.broker.GetSenderInfo(addr)
+	lookedUp := err == nil
+	if lookedUp {
+		err = c.checkFunds(addr, info)
+	} else {
+		glog.Errorf("Error getting funds of sender %v; using the last check: %v", addr.Hex(), err)
+		err = check.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if lookedUp {
+		c.checks[addr] = senderCheck{err: err, checked: now}
+	}
+	delete(c.lookups, addr)
+	l.err = err
+	close(l.done)
+	if now.Sub(c.lastSweep) >= c.ttl {
+		for a, check := range c.checks {
+			if now.Sub(check.checked) >= senderCheckStaleTTLs*c.ttl {
+				delete(c.checks, a)
+			}
+		}
+		c.lastSweep = now
+	}
+	return err
+}
+
+func (c *senderChecker) checkFunds(addr ethcommon.Address, info *SenderInfo) error {
+	deposit, reserve := info.Deposit, info.Reserve
+	if deposit == nil {
+		deposit = big.NewInt(0)
+	}
+	if reserve == nil {
+		reserve = big.NewInt(0)
+	}
+	if deposit.Sign() == 0 && reserve.Sign() == 0 {
+		return errors.Errorf("sender %v has zero deposit and reserve", addr.Hex())
+	}
+	if deposit.Cmp(c.minDeposit) < 0 {
+		return errors.Errorf("sender %v deposit %v is below the minimum %v", addr.Hex(), deposit, c.minDeposit)
+	}
+	if reserve.Cmp(c.minReserve) < 0 {
+		return errors.Errorf("sender %v reserve %v is below the minimum %v", addr.Hex(), reserve, c.minReserve)
+	}
+	return nil
+}
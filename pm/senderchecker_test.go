@@ -0,0 +1,128 @@
+package pm
+
+import (
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSender_Funds(t *testing.T) {
+	assert := assert.New(t)
+	b := newStubBroker()
+	c := NewSenderChecker(b, big.NewInt(100), big.NewInt(50), time.Minute)
+
+	sender := RandAddress()
+	b.SetDeposit(sender, big.NewInt(100))
+	b.SetReserve(sender, big.NewInt(50))
+	assert.Nil(c.CheckSender(sender))
+
+	lowDeposit := RandAddress()
+	b.SetDeposit(lowDeposit, big.NewInt(99))
+	b.SetReserve(lowDeposit, big.NewInt(50))
+	err := c.CheckSender(lowDeposit)
+	assert.True(err != nil && strings.Contains(err.Error(), "deposit 99 is below the minimum 100"), err)
+
+	lowReserve := RandAddress()
+	b.SetDeposit(lowReserve, big.NewInt(100))
+	b.SetReserve(lowReserve, big.NewInt(49))
+	err = c.CheckSender(lowReserve)
+	assert.True(err != nil && strings.Contains(err.Error(), "reserve 49 is below the minimum 50"), err)
+
+	// Senders without funds are refused even without minimums
+	c = NewSenderChecker(b, nil, nil, time.Minute)
+	err = c.CheckSender(RandAddress())
+	assert.True(err != nil && strings.Contains(err.Error(), "zero deposit and reserve"), err)
+	assert.Nil(c.CheckSender(sender))
+}
+
+func TestCheckSender_Cache(t *testing.T) {
+	assert := assert.New(t)
+	b := newStubBroker()
+	c := NewSenderChecker(b, big.NewInt(100), nil, time.Minute).(*senderChecker)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	sender := RandAddress()
+	b.SetDeposit(sender, big.NewInt(10))
+	assert.NotNil(c.CheckSender(sender))
+
+	// The result is cached until the TTL passes
+	b.SetDeposit(sender, big.NewInt(100))
+	assert.NotNil(c.CheckSender(sender))
+	now = now.Add(time.Minute)
+	assert.Nil(c.CheckSender(sender))
+	b.SetDeposit(sender, big.NewInt(0))
+	assert.Nil(c.CheckSender(sender))
+
+	// Senders whose funds can't be looked up are let through the first time,
+	// without the failure being cached
+	other := RandAddress()
+	b.getSenderInfoShouldFail = true
+	assert.Nil(c.CheckSender(other))
+	b.getSenderInfoShouldFail = false
+	b.SetDeposit(other, big.NewInt(10))
+	assert.NotNil(c.CheckSender(other))
+
+	// and get their last result afterwards
+	now = now.Add(time.Minute)
+	b.getSenderInfoShouldFail = true
+	assert.NotNil(c.CheckSender(other))
+	b.getSenderInfoShouldFail = false
+	b.SetDeposit(other, big.NewInt(100))
+	assert.Nil(c.CheckSender(other))
+
+	// Results are swept once too old to be served while lookups fail
+	now = now.Add(senderCheckStaleTTLs * time.Minute)
+	assert.Nil(c.CheckSender(other))
+	assert.Len(c.checks, 1)
+}
+
+// blockingBroker counts the lookups of sender funds, which return once
+// unblocked
+type blockingBroker struct {
+	*stubBroker
+	mu      sync.Mutex
+	lookups int
+	unblock chan struct{}
+}
+
+func (b *blockingBroker) GetSenderInfo(addr ethcommon.Address) (*SenderInfo, error) {
+	b.mu.Lock()
+	b.lookups++
+	b.mu.Unlock()
+	<-b.unblock
+	return b.stubBroker.GetSenderInfo(addr)
+}
+
+func TestCheckSender_SharedLookup(t *testing.T) {
+	assert := assert.New(t)
+	b := &blockingBroker{stubBroker: newStubBroker(), unblock: make(chan struct{})}
+	c := NewSenderChecker(b, big.NewInt(100), nil, time.Minute)
+	sender := RandAddress()
+	b.SetDeposit(sender, big.NewInt(10))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.CheckSender(sender)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(b.unblock)
+	wg.Wait()
+	close(errs)
+
+	// Checks made while the funds were looked up waited on that lookup
+	assert.Equal(1, b.lookups)
+	for err := range errs {
+		assert.True(err != nil && strings.Contains(err.Error(), "deposit 10 is below the minimum 100"), err)
+	}
+}
@@ -18,6 +18,7 @@ type stubTicketStore struct {
 	recipientRands  map[string][]*big.Int
 	storeShouldFail bool
 	loadShouldFail  bool
+	redeemed        map[string]ethcommon.Hash
 	lock            sync.RWMutex
 }
 
@@ -74,6 +75,18 @@ func (ts *stubTicketStore) LoadWinningTickets(sessionIDs []string) ([]*Ticket, [
 	return allTix, allSigs, allRecipientRands, nil
 }
 
+func (ts *stubTicketStore) MarkWinningTicketRedeemed(sig []byte, txHash ethcommon.Hash) error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.redeemed == nil {
+		ts.redeemed = make(map[string]ethcommon.Hash)
+	}
+	ts.redeemed[string(sig)] = txHash
+
+	return nil
+}
+
 type stubSigVerifier struct {
 	verifyResult bool
 }
@@ -136,7 +149,7 @@ func (b *stubBroker) RedeemWinningTicket(ticket *Ticket, sig []byte, recipientRa
 
 	b.usedTickets[ticket.Hash()] = true
 
-	return nil, nil
+	return types.NewTransaction(uint64(ticket.SenderNonce), ticket.Recipient, big.NewInt(0), 0, big.NewInt(0), nil), nil
 }
 
 func (b *stubBroker) IsUsedTicket(ticket *Ticket) (bool, error) {
@@ -250,6 +263,19 @@ func (m *MockRecipient) TicketParams(sender ethcommon.Address) *TicketParams {
 	return params
 }
 
+// MockSenderChecker is useful for testing components that depend on
+// pm.SenderChecker
+type MockSenderChecker struct {
+	mock.Mock
+}
+
+// CheckSender returns an error if the sender's deposit or reserve is below
+// the minimums
+func (m *MockSenderChecker) CheckSender(addr ethcommon.Address) error {
+	args := m.Called(addr)
+	return args.Error(0)
+}
+
 // MockSender is useful for testing components that depend on pm.Sender
 type MockSender struct {
 	mock.Mock
@@ -7,6 +7,10 @@ import (
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// maxWinProb is the winProb of a ticket that always wins; the winProb of a
+// 256 bit hash of the ticket can be lower than this
+var maxWinProb = new(big.Int).Lsh(big.NewInt(1), 256)
+
 // Constants for byte sizes of Solidity types
 const (
 	addressSize = 20
@@ -55,6 +59,11 @@ type Ticket struct {
 	RecipientRandHash ethcommon.Hash
 }
 
+// EV returns the expected value of the ticket, faceValue * winProb / 2^256
+func (t *Ticket) EV() *big.Rat {
+	return new(big.Rat).SetFrac(new(big.Int).Mul(t.FaceValue, t.WinProb), maxWinProb)
+}
+
 // Hash returns the keccak-256 hash of the ticket's fields as tightly packed
 // arguments as described in the Solidity documentation
 // See: https://solidity.readthedocs.io/en/v0.4.25/units-and-global-variables.html#mathematical-and-cryptographic-functions
@@ -102,3 +102,18 @@ func TestHash(t *testing.T) {
 		t.Errorf("Expected %v got %v", exp, h)
 	}
 }
+
+func TestEV(t *testing.T) {
+	ticket := &Ticket{
+		FaceValue: big.NewInt(1000),
+		WinProb:   new(big.Int).Lsh(big.NewInt(1), 255),
+	}
+	if ev := ticket.EV(); ev.Cmp(big.NewRat(500, 1)) != 0 {
+		t.Errorf("Expected EV 500 got %v", ev)
+	}
+
+	ticket.WinProb = big.NewInt(0)
+	if ev := ticket.EV(); ev.Sign() != 0 {
+		t.Errorf("Expected EV 0 got %v", ev)
+	}
+}
@@ -2,6 +2,8 @@ package pm
 
 import (
 	"math/big"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
 )
 
 // TicketStore is an interface which describes an object capable
@@ -14,4 +16,8 @@ type TicketStore interface {
 	// Load fetches all persisted tickets in the store with their signatures and recipientRands
 	// for a session ID
 	LoadWinningTickets(sessionIDs []string) (tickets []*Ticket, sigs [][]byte, recipientRands []*big.Int, err error)
+
+	// MarkWinningTicketRedeemed records that the ticket with sig was
+	// submitted for redemption in the tx with txHash
+	MarkWinningTicketRedeemed(sig []byte, txHash ethcommon.Hash) error
 }
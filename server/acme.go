@@ -0,0 +1,283 @@
+package server
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v3/certcrypto"
+	"github.com/go-acme/lego/v3/certificate"
+	"github.com/go-acme/lego/v3/challenge"
+	"github.com/go-acme/lego/v3/challenge/http01"
+	"github.com/go-acme/lego/v3/lego"
+	"github.com/go-acme/lego/v3/providers/dns/cloudflare"
+	"github.com/go-acme/lego/v3/providers/dns/digitalocean"
+	"github.com/go-acme/lego/v3/providers/dns/route53"
+	"github.com/go-acme/lego/v3/registration"
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// ACME challenges the certificates of the service URI may be obtained with
+const (
+	ACMEChallengeHTTP01 = "http-01"
+	ACMEChallengeDNS01  = "dns-01"
+)
+
+// Certificates for the service URI hostname are obtained from the ACME CA at
+// ACMEDirectoryURL, and renewed, if ACMEChallenge is set. For HTTP-01 the CA
+// must reach the node on ACMEHTTPPort; for DNS-01 the TXT record is set with
+// the lego DNS provider ACMEDNSProvider, its credentials taken from the
+// environment. The account is only registered with the CA if the operator
+// agreed to its terms of service with ACMEAgreeTOS. Self-signed certificates
+// are used if ACMEChallenge isn't set, or no certificate can be obtained.
+var (
+	ACMEChallenge    string
+	ACMEEmail        string
+	ACMEDirectoryURL = lego.LEDirectoryProduction
+	ACMEHTTPPort     = "80"
+	ACMEDNSProvider  string
+	ACMEAgreeTOS     bool
+)
+
+// ACMEDNSProviders are the lego DNS providers the dns-01 challenge may be
+// set with
+var ACMEDNSProviders = []string{"cloudflare", "digitalocean", "route53"}
+
+var errACMETerms = errors.New("the ACME CA's terms of service must be agreed to with -acmeAgreeTOS")
+
+// ACMERenewBefore is how long before it expires the certificate is renewed
+var ACMERenewBefore = 30 * 24 * time.Hour
+
+// acmeCheckInterval is how often the certificate is checked for renewal
+var acmeCheckInterval = 12 * time.Hour
+
+// acmeUser is the ACME account the certificates are obtained with
+type acmeUser struct {
+	email string
+	reg   *registration.Resource
+	key   crypto.PrivateKey
+}
+
+func (u *acmeUser) GetEmail() string                        { return u.email }
+func (u *acmeUser) GetRegistration() *registration.Resource { return u.reg }
+func (u *acmeUser) GetPrivateKey() crypto.PrivateKey        { return u.key }
+
+// acmeCerts serves the certificate of host, obtained from the ACME CA and
+// kept in dir so it isn't obtained again on each restart
+type acmeCerts struct {
+	host string
+	dir  string
+
+	client *lego.Client
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newACMECerts loads the certificate of host kept in workDir, obtaining one if
+// there's none or it's due for renewal
+func newACMECerts(host, workDir string) (*acmeCerts, error) {
+	if host == "" {
+		return nil, errors.New("missing service URI hostname")
+	}
+	if net.ParseIP(host) != nil {
+		return nil, fmt.Errorf("ACME certificates can't be issued for IP address %v; set a hostname as the service URI", host)
+	}
+	dir := filepath.Join(workDir, "acme")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &acmeCerts{host: host, dir: dir}
+	cert, err := loadACMECert(c.certFile(), c.keyFile())
+	if err != nil && !os.IsNotExist(err) {
+		glog.Errorf("Unable to load ACME certificate host=%s, obtaining a new one: %v", host, err)
+	}
+	c.cert = cert
+	if needsRenewal(cert, time.Now()) {
+		if err := c.obtain(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *acmeCerts) certFile() string { return filepath.Join(c.dir, c.host+".crt") }
+func (c *acmeCerts) keyFile() string  { return filepath.Join(c.dir, c.host+".key") }
+
+func (c *acmeCerts) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cert == nil {
+		return nil, errors.New("missing ACME certificate")
+	}
+	return c.cert, nil
+}
+
+// renewLoop renews the certificate once it's due, retrying on
+// acmeCheckInterval should the CA fail
+func (c *acmeCerts) renewLoop() {
+	ticker := time.NewTicker(acmeCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.RLock()
+		due := needsRenewal(c.cert, time.Now())
+		c.mu.RUnlock()
+		if !due {
+			continue
+		}
+		if err := c.obtain(); err != nil {
+			glog.Errorf("Unable to renew ACME certificate host=%s: %v", c.host, err)
+		}
+	}
+}
+
+// obtain gets a new certificate from the CA, and serves it from then on
+func (c *acmeCerts) obtain() error {
+	glog.Infof("Obtaining ACME certificate host=%s challenge=%s directory=%s", c.host, ACMEChallenge, ACMEDirectoryURL)
+	if c.client == nil {
+		client, err := c.newClient()
+		if err != nil {
+			return err
+		}
+		c.client = client
+	}
+	res, err := c.client.Certificate.Obtain(certificate.ObtainRequest{Domains: []string{c.host}, Bundle: true})
+	if err != nil {
+		return fmt.Errorf("failed obtaining ACME certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(c.keyFile(), res.PrivateKey, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.certFile(), res.Certificate, 0644); err != nil {
+		return err
+	}
+	cert, err := loadACMECert(c.certFile(), c.keyFile())
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.cert = cert
+	c.mu.Unlock()
+	glog.Infof("Obtained ACME certificate host=%s expires=%v", c.host, cert.Leaf.NotAfter)
+	return nil
+}
+
+// newClient sets up the ACME client for the challenge, registering the
+// account with the CA unless it already is
+func (c *acmeCerts) newClient() (*lego.Client, error) {
+	key, err := c.accountKey()
+	if err != nil {
+		return nil, err
+	}
+	user := &acmeUser{email: ACMEEmail, key: key}
+	config := lego.NewConfig(user)
+	config.CADirURL = ACMEDirectoryURL
+	config.Certificate.KeyType = certcrypto.EC256
+	client, err := lego.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating ACME client: %v", err)
+	}
+
+	switch ACMEChallenge {
+	case ACMEChallengeHTTP01:
+		err = client.Challenge.SetHTTP01Provider(http01.NewProviderServer("", ACMEHTTPPort))
+	case ACMEChallengeDNS01:
+		if ACMEDNSProvider == "" {
+			return nil, errors.New("missing DNS provider for the dns-01 challenge")
+		}
+		provider, perr := newDNSProvider(ACMEDNSProvider)
+		if perr != nil {
+			return nil, fmt.Errorf("failed setting up DNS provider %v: %v", ACMEDNSProvider, perr)
+		}
+		err = client.Challenge.SetDNS01Provider(provider)
+	default:
+		return nil, fmt.Errorf("unknown ACME challenge %v; must be %v or %v", ACMEChallenge, ACMEChallengeHTTP01, ACMEChallengeDNS01)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.reg, err = client.Registration.ResolveAccountByKey(); err != nil {
+		if !ACMEAgreeTOS {
+			return nil, errACMETerms
+		}
+		user.reg, err = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed registering ACME account: %v", err)
+		}
+	}
+	return client, nil
+}
+
+// IsACMEDNSProvider is whether name is one of ACMEDNSProviders
+func IsACMEDNSProvider(name string) bool {
+	for _, p := range ACMEDNSProviders {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newDNSProvider sets up the DNS provider name, one of ACMEDNSProviders,
+// with its credentials from the environment
+func newDNSProvider(name string) (challenge.Provider, error) {
+	switch name {
+	case "cloudflare":
+		return cloudflare.NewDNSProvider()
+	case "digitalocean":
+		return digitalocean.NewDNSProvider()
+	case "route53":
+		return route53.NewDNSProvider()
+	}
+	return nil, fmt.Errorf("unsupported DNS provider; must be one of %v", ACMEDNSProviders)
+}
+
+// accountKey returns the key of the ACME account, generated if there's none
+func (c *acmeCerts) accountKey() (crypto.PrivateKey, error) {
+	fname := filepath.Join(c.dir, "account.key")
+	data, err := ioutil.ReadFile(fname)
+	if os.IsNotExist(err) {
+		key, keyBytes, err := genKey()
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(fname, "EC PRIVATE KEY", keyBytes); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid ACME account key %v", fname)
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func loadACMECert(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// needsRenewal is whether cert is missing or expires within ACMERenewBefore
+func needsRenewal(cert *tls.Certificate, now time.Time) bool {
+	return cert == nil || cert.Leaf == nil || now.Add(ACMERenewBefore).After(cert.Leaf.NotAfter)
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNeedsRenewal(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	leaf := func(notAfter time.Time) *tls.Certificate {
+		return &tls.Certificate{Leaf: &x509.Certificate{NotAfter: notAfter}}
+	}
+
+	assert.True(needsRenewal(nil, now))
+	assert.True(needsRenewal(&tls.Certificate{}, now))
+	assert.True(needsRenewal(leaf(now.Add(-time.Hour)), now))
+	assert.True(needsRenewal(leaf(now.Add(ACMERenewBefore-time.Hour)), now))
+	assert.False(needsRenewal(leaf(now.Add(ACMERenewBefore+time.Hour)), now))
+}
+
+func TestNewACMECerts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	workDir, err := ioutil.TempDir("", "acme")
+	require.Nil(err)
+	defer os.RemoveAll(workDir)
+
+	_, err = newACMECerts("127.0.0.1", workDir)
+	assert.Contains(err.Error(), "IP address")
+	_, err = newACMECerts("", workDir)
+	assert.EqualError(err, "missing service URI hostname")
+
+	// A certificate kept from before is served, rather than obtained again
+	host := "orch.example.com"
+	dir := filepath.Join(workDir, "acme")
+	require.Nil(os.MkdirAll(dir, 0700))
+	key, keyBytes, err := genKey()
+	require.Nil(err)
+	cert, err := genCert(host, key)
+	require.Nil(err)
+	require.Nil(writeFile(filepath.Join(dir, host+".key"), "EC PRIVATE KEY", keyBytes))
+	require.Nil(writeFile(filepath.Join(dir, host+".crt"), "CERTIFICATE", cert))
+
+	certs, err := newACMECerts(host, workDir)
+	require.Nil(err)
+	assert.Nil(certs.client)
+	served, err := certs.getCertificate(nil)
+	require.Nil(err)
+	assert.Equal(cert, served.Certificate[0])
+	assert.Equal([]string{host}, served.Leaf.DNSNames)
+}
+
+func TestACMEDNSProviders(t *testing.T) {
+	assert := assert.New(t)
+	for _, name := range ACMEDNSProviders {
+		assert.True(IsACMEDNSProvider(name))
+	}
+	assert.False(IsACMEDNSProvider("godaddy"))
+	_, err := newDNSProvider("godaddy")
+	assert.Contains(err.Error(), "unsupported DNS provider")
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// adminEndpoints are the endpoints of the CLI webserver that are also served
+// by the admin webserver, to operate a node remotely
+var adminEndpoints = []string{
+	"/status",
+	"/healthz",
+	"/readyz",
+	"/getBroadcastConfig",
+	"/setBroadcastConfig",
+	"/setOrchestratorConfig",
+	"/currentRound",
+	"/roundInitialized",
+	"/initializeRound",
+	"/drain",
+	"/orchestrators",
+	"/dbStats",
+	"/transcoderTokens",
+	"/drainTranscoder",
+	"/transcoderRules",
+	"/transcoderTags",
+	"/ingestACL",
+	"/auditLog",
+}
+
+// updatableOrchestratorPool is a pool whose orchestrators can be replaced,
+// such as that of the orchestrators given with -orchAddr
+type updatableOrchestratorPool interface {
+	SetAddresses(addresses []string) error
+}
+
+// StartAdminWebserver serves the admin endpoints at bindAddr, and the
+// operational endpoints of the CLI webserver, all of which require
+// AdminToken. TLS is used if certFile and keyFile are given.
+func (s *LivepeerServer) StartAdminWebserver(bindAddr, certFile, keyFile string) error {
+	if AdminToken == "" {
+		return errors.New("the admin webserver requires an admin token")
+	}
+	srv := &http.Server{
+		Addr:    bindAddr,
+		Handler: withRequestID(s.withAuditLog(auditViaAdmin, s.adminWebServerHandlers(bindAddr))),
+	}
+	if certFile != "" && keyFile != "" {
+		glog.Info("Admin server listening with TLS on ", bindAddr)
+		return srv.ListenAndServeTLS(certFile, keyFile)
+	}
+	glog.Warning("Admin server listening without TLS on ", bindAddr, "; the admin token is sent in the clear")
+	return srv.ListenAndServe()
+}
+
+func (s *LivepeerServer) adminWebServerHandlers(bindAddr string) *http.ServeMux {
+	cli := s.cliWebServerHandlers(bindAddr)
+	mux := http.NewServeMux()
+	for _, path := range adminEndpoints {
+		mux.Handle(path, mustHaveAdminToken(cli))
+	}
+	for _, path := range []string{"/debug/profiling", "/debug/pprof/", "/debug/dump"} {
+		// Already require the token
+		mux.Handle(path, cli)
+	}
+	return mux
+}
+
+// drainHandler reports whether the node is draining and its live streams.
+// POSTing `draining=true` has the node refuse new streams and segments, as
+// on shutdown, without exiting; `draining=false` takes them again.
+func (s *LivepeerServer) drainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			draining, err := strconv.ParseBool(r.FormValue("draining"))
+			if err != nil {
+				respondWith400(w, fmt.Sprintf("invalid draining: %v", r.FormValue("draining")))
+				return
+			}
+			if draining {
+				shutdown.drain()
+			} else {
+				shutdown.resume()
+			}
+			glog.Infof("Draining updated draining=%v", draining)
+		}
+		respondWithJSON(w, struct {
+			Draining bool `json:"draining"`
+			Streams  int  `json:"streams"`
+		}{shutdown.isDraining(), s.activeStreams()})
+	})
+}
+
+// ingestACLHandler reports the ranges of the addresses a broadcaster accepts
+// RTMP connections from. POSTing `allow` or `deny`, comma-separated CIDR
+// ranges, replaces those ranges; connections from the addresses no longer
+// allowed are closed.
+func (s *LivepeerServer) ingestACLHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ingestACL == nil {
+			respondWith400(w, "ingest filter disabled; start the broadcaster with -ingestFilter")
+			return
+		}
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			allow, deny := s.ingestACL.nets()
+			var err error
+			if _, ok := r.PostForm["allow"]; ok {
+				if allow, err = ParseCIDRs(r.PostFormValue("allow")); err != nil {
+					respondWith400(w, fmt.Sprintf("invalid allow: %v", err))
+					return
+				}
+			}
+			if _, ok := r.PostForm["deny"]; ok {
+				if deny, err = ParseCIDRs(r.PostFormValue("deny")); err != nil {
+					respondWith400(w, fmt.Sprintf("invalid deny: %v", err))
+					return
+				}
+			}
+			s.ingestACL.Set(allow, deny)
+			glog.Infof("Ingest ACL updated allow=%v deny=%v", allow, deny)
+		}
+		allow, deny := s.ingestACL.Ranges()
+		respondWithJSON(w, struct {
+			Allow []string `json:"allow"`
+			Deny  []string `json:"deny"`
+		}{allow, deny})
+	})
+}
+
+// orchestratorsHandler lists the orchestrators of a broadcaster. POSTing
+// `orchAddr`, as given to -orchAddr, replaces them.
+func orchestratorsHandler(n *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.NodeType != core.BroadcasterNode || n.OrchestratorPool == nil {
+			respondWith400(w, "node is not a broadcaster")
+			return
+		}
+		if r.Method == "POST" {
+			pool, ok := n.OrchestratorPool.(updatableOrchestratorPool)
+			if !ok {
+				respondWith400(w, "orchestrators are discovered on chain; use -orchAddr to set them")
+				return
+			}
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			var addrs []string
+			for _, addr := range strings.Split(r.FormValue("orchAddr"), ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					addrs = append(addrs, addr)
+				}
+			}
+			if err := pool.SetAddresses(addrs); err != nil {
+				respondWith400(w, err.Error())
+				return
+			}
+			glog.Infof("Orchestrators updated orchAddr=%v", addrs)
+		}
+		urls := []string{}
+		for _, u := range n.OrchestratorPool.GetURLs() {
+			urls = append(urls, u.String())
+		}
+		respondWithJSON(w, urls)
+	})
+}
+
+// transcoderTokensHandler lists the tokens issued for the remote transcoders
+// of an orchestrator. POSTing `label` issues a token, which is responded
+// with along with its secret, shown only then; POSTing `revoke`, the ID of a
+// token, revokes it and disconnects the transcoder using it.
+func transcoderTokensHandler(n *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.NodeType != core.OrchestratorNode || n.TranscoderTokens == nil {
+			respondWith400(w, "node is not an orchestrator")
+			return
+		}
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			if id := r.FormValue("revoke"); id != "" {
+				if err := n.TranscoderTokens.Revoke(id); err != nil {
+					if err == core.ErrTranscoderTokenNotFound {
+						respondWith400(w, err.Error())
+						return
+					}
+					respondWith500(w, err.Error())
+					return
+				}
+				glog.Infof("Transcoder token revoked id=%s", id)
+			} else {
+				token, secret, err := n.TranscoderTokens.Issue(r.FormValue("label"))
+				if err != nil {
+					respondWith500(w, err.Error())
+					return
+				}
+				glog.Infof("Transcoder token issued id=%s label=%s", token.ID, token.Label)
+				respondWithJSON(w, struct {
+					*core.TranscoderToken
+					Secret string `json:"secret"`
+				}{token, secret})
+				return
+			}
+		}
+		respondWithJSON(w, n.TranscoderTokens.List())
+	})
+}
+
+// transcoderRulesHandler lists the rules routing the streams of an
+// orchestrator to pools of its remote transcoders by their tags. POSTing a
+// rule's `name` and `tags`, with the `manifestID` prefix and `minHeight` of
+// the streams it matches, adds it or replaces the rule with the name;
+// POSTing `remove`, the name of a rule, removes it.
+func transcoderRulesHandler(n *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.TranscoderManager == nil {
+			respondWith400(w, "node is not an orchestrator with remote transcoders")
+			return
+		}
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			if name := r.FormValue("remove"); name != "" {
+				if err := n.TranscoderManager.RemoveRule(name); err != nil {
+					respondWith400(w, err.Error())
+					return
+				}
+			} else {
+				rule := core.TranscoderRule{Name: r.FormValue("name"), ManifestID: r.FormValue("manifestID")}
+				if h := r.FormValue("minHeight"); h != "" {
+					minHeight, err := strconv.Atoi(h)
+					if err != nil {
+						respondWith400(w, fmt.Sprintf("invalid minHeight: %v", h))
+						return
+					}
+					rule.MinHeight = minHeight
+				}
+				rule.Tags = splitTags(r.FormValue("tags"))
+				if err := n.TranscoderManager.SetRule(rule); err != nil {
+					respondWith400(w, err.Error())
+					return
+				}
+			}
+		}
+		respondWithJSON(w, n.TranscoderManager.Rules())
+	})
+}
+
+// transcoderTagsHandler lists the tags assigned to the remote transcoders of
+// an orchestrator, by ID, for its transcoder rules to route streams by.
+// POSTing the ID of a `transcoder` and its comma-separated `tags` assigns it
+// those in place of the ones it had; no tags untags it.
+func transcoderTagsHandler(n *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.TranscoderManager == nil {
+			respondWith400(w, "node is not an orchestrator with remote transcoders")
+			return
+		}
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			if err := n.TranscoderManager.SetTags(r.FormValue("transcoder"), splitTags(r.FormValue("tags"))); err != nil {
+				respondWith400(w, err.Error())
+				return
+			}
+		}
+		respondWithJSON(w, n.TranscoderManager.Tags())
+	})
+}
+
+// splitTags splits comma-separated tags, dropping empty ones
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// drainTranscoderHandler drains the remote transcoder of an orchestrator
+// POSTed as `transcoder`, its ID or address as in /status: it gets no new
+// segments, and disconnects and exits once those it has are done. Responds
+// with the transcoders.
+func drainTranscoderHandler(n *core.LivepeerNode) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.TranscoderManager == nil {
+			respondWith400(w, "node is not an orchestrator with remote transcoders")
+			return
+		}
+		if r.Method != "POST" {
+			respondWith400(w, "transcoder to drain must be POSTed")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+			return
+		}
+		if err := n.TranscoderManager.Drain(r.FormValue("transcoder")); err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		respondWithJSON(w, n.TranscoderManager.RegisteredTranscodersInfo())
+	})
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubUpdatablePool struct {
+	stubDiscovery
+	urls []*url.URL
+}
+
+func (p *stubUpdatablePool) GetURLs() []*url.URL {
+	return p.urls
+}
+
+func (p *stubUpdatablePool) SetAddresses(addresses []string) error {
+	var urls []*url.URL
+	for _, addr := range addresses {
+		u, err := url.Parse("https://" + addr)
+		if err != nil || strings.Contains(addr, "?") {
+			return errors.New("invalid orchestrator " + addr)
+		}
+		urls = append(urls, u)
+	}
+	p.urls = urls
+	return nil
+}
+
+func TestAdminWebServerHandlers(t *testing.T) {
+	assert := assert.New(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+	AdminToken = "secret"
+
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	mux := s.adminWebServerHandlers("addr")
+	req := func(path, token string) int {
+		r := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusUnauthorized, req("/status", ""))
+	assert.Equal(http.StatusUnauthorized, req("/status", "foo"))
+	assert.Equal(http.StatusOK, req("/status", "secret"))
+	assert.Equal(http.StatusUnauthorized, req("/debug/profiling", ""))
+	assert.Equal(http.StatusOK, req("/debug/profiling", "secret"))
+	// Only the operational endpoints are served
+	assert.Equal(http.StatusNotFound, req("/transferTokens", "secret"))
+
+	AdminToken = ""
+	assert.NotNil(s.StartAdminWebserver("127.0.0.1:0", "", ""))
+}
+
+func TestIngestACLHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/ingestACL", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		s.ingestACLHandler().ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(url.Values{})
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "ingest filter disabled")
+
+	s.ingestACL = NewIngestACL(nil, nil)
+	w = post(url.Values{"allow": {"10.0.0.0/8,1.2.3.4"}})
+	require.Equal(http.StatusOK, w.Code)
+	var acl struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &acl))
+	assert.Equal([]string{"10.0.0.0/8", "1.2.3.4/32"}, acl.Allow)
+	assert.Equal([]string{}, acl.Deny)
+
+	// Ranges not POSTed are kept
+	w = post(url.Values{"deny": {"10.1.0.0/16"}})
+	require.Equal(http.StatusOK, w.Code)
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &acl))
+	assert.Equal([]string{"10.0.0.0/8", "1.2.3.4/32"}, acl.Allow)
+	assert.Equal([]string{"10.1.0.0/16"}, acl.Deny)
+
+	w = post(url.Values{"allow": {"bogus"}})
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "invalid allow")
+	assert.True(s.ingestACL.Allowed(net.ParseIP("1.2.3.4")))
+}
+
+func TestDrainHandler(t *testing.T) {
+	defer resetShutdown()()
+	assert := assert.New(t)
+	require := require.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	handler := s.drainHandler()
+
+	post := func(draining string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/drain", strings.NewReader(url.Values{"draining": {draining}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("true")
+	require.Equal(http.StatusOK, w.Code)
+	var res struct{ Draining bool }
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(res.Draining)
+	assert.False(shutdown.beginSegment())
+
+	w = post("false")
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &res))
+	assert.False(res.Draining)
+	assert.True(shutdown.beginSegment())
+	shutdown.endSegment()
+
+	assert.Equal(http.StatusBadRequest, post("maybe").Code)
+}
+
+func TestOrchestratorsHandler(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	handler := orchestratorsHandler(n)
+
+	post := func(orchAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/orchestrators", strings.NewReader(url.Values{"orchAddr": {orchAddr}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	n.NodeType = core.OrchestratorNode
+	assert.Equal(http.StatusBadRequest, post("127.0.0.1:8936").Code)
+
+	// Pools discovered on chain can't be replaced
+	n.NodeType = core.BroadcasterNode
+	n.OrchestratorPool = &stubDiscovery{}
+	assert.Equal(http.StatusBadRequest, post("127.0.0.1:8936").Code)
+
+	pool := &stubUpdatablePool{}
+	n.OrchestratorPool = pool
+	w := post("127.0.0.1:8936, 127.0.0.1:8937")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`["https://127.0.0.1:8936","https://127.0.0.1:8937"]`, w.Body.String())
+
+	w = post("127.0.0.1:8938?weight=0")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Len(pool.urls, 2)
+}
+
+func TestTranscoderTokensHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	handler := transcoderTokensHandler(n)
+
+	request := func(method string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://example.com/transcoderTokens", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	n.NodeType = core.BroadcasterNode
+	assert.Equal(http.StatusBadRequest, request("GET", nil).Code)
+
+	n.NodeType = core.OrchestratorNode
+	tokens, err := core.NewTranscoderTokens(nil, 0)
+	require.Nil(err)
+	n.TranscoderTokens = tokens
+
+	w := request("POST", url.Values{"label": {"gpu1"}})
+	require.Equal(http.StatusOK, w.Code)
+	var issued struct {
+		ID     string `json:"id"`
+		Label  string `json:"label"`
+		Secret string `json:"secret"`
+	}
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &issued))
+	assert.Equal("gpu1", issued.Label)
+	assert.NotEmpty(issued.ID)
+	_, ok := tokens.Authenticate(issued.Secret)
+	assert.True(ok)
+
+	w = request("GET", nil)
+	assert.Equal(http.StatusOK, w.Code)
+	var listed []core.TranscoderToken
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(listed, 1)
+	assert.Equal(issued.ID, listed[0].ID)
+	assert.False(listed[0].Revoked)
+	assert.NotContains(w.Body.String(), issued.Secret)
+
+	assert.Equal(http.StatusBadRequest, request("POST", url.Values{"revoke": {"nonexistent"}}).Code)
+	w = request("POST", url.Values{"revoke": {issued.ID}})
+	assert.Equal(http.StatusOK, w.Code)
+	listed = nil
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(listed, 1)
+	assert.True(listed[0].Revoked)
+	_, ok = tokens.Authenticate(issued.Secret)
+	assert.False(ok)
+}
+
+func TestDrainTranscoderHandler(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	handler := drainTranscoderHandler(n)
+
+	post := func(transcoder string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/drainTranscoder", strings.NewReader(url.Values{"transcoder": {transcoder}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusBadRequest, post("TestAddress").Code)
+
+	n.TranscoderManager = core.NewRemoteTranscoderManager()
+	strm := &common.StubServerStream{}
+	done := make(chan struct{})
+	go func() {
+		n.TranscoderManager.Manage(strm, core.RemoteTranscoderCapacity{Sessions: 5})
+		close(done)
+	}()
+	time.Sleep(1 * time.Millisecond)
+
+	assert.Equal(http.StatusBadRequest, post("nonexistent").Code)
+	r := httptest.NewRequest("GET", "http://example.com/drainTranscoder", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
+
+	w = post("TestAddress")
+	assert.Equal(http.StatusOK, w.Code)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("idle transcoder wasn't disconnected")
+	}
+}
+
+func TestTranscoderRulesHandler(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	handler := transcoderRulesHandler(n)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/transcoderRules", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusBadRequest, post(url.Values{"name": {"4k"}, "tags": {"a100"}}).Code)
+
+	n.TranscoderManager = core.NewRemoteTranscoderManager()
+	assert.Equal(http.StatusBadRequest, post(url.Values{"name": {"4k"}}).Code)
+	assert.Equal(http.StatusBadRequest, post(url.Values{"name": {"4k"}, "tags": {"a100"}, "minHeight": {"big"}}).Code)
+	assert.Equal(http.StatusBadRequest, post(url.Values{"remove": {"4k"}}).Code)
+
+	w := post(url.Values{"name": {"4k"}, "tags": {"a100, eu"}, "minHeight": {"2160"}})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`[{"name":"4k","minHeight":2160,"tags":["a100","eu"]}]`, w.Body.String())
+	assert.Equal([]core.TranscoderRule{{Name: "4k", MinHeight: 2160, Tags: []string{"a100", "eu"}}}, n.TranscoderManager.Rules())
+
+	w = post(url.Values{"remove": {"4k"}})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`[]`, w.Body.String())
+}
+
+func TestTranscoderTagsHandler(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
+	handler := transcoderTagsHandler(n)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/transcoderTags", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusBadRequest, post(url.Values{"transcoder": {"t1"}, "tags": {"a100"}}).Code)
+
+	n.TranscoderManager = core.NewRemoteTranscoderManager()
+	// Only transcoders with IDs can be tagged
+	assert.Equal(http.StatusBadRequest, post(url.Values{"tags": {"a100"}}).Code)
+
+	w := post(url.Values{"transcoder": {"t1"}, "tags": {"a100, eu"}})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{"t1":["a100","eu"]}`, w.Body.String())
+	assert.Equal(map[string][]string{"t1": {"a100", "eu"}}, n.TranscoderManager.Tags())
+
+	w = post(url.Values{"transcoder": {"t1"}, "tags": {""}})
+	assert.Equal(http.StatusOK, w.Code)
+	assert.JSONEq(`{}`, w.Body.String())
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/monitor"
+)
+
+// Alerts are sent to AlertWebhookURL when any of the following thresholds is
+// crossed, and again once the node recovers. Zero values disable an alert.
+var (
+	AlertWebhookURL string
+	// AlertMinSuccessRate fraction of segments that must be transcoded; needs -monitor
+	AlertMinSuccessRate float64
+	// AlertMaxGPUUtilization percent a GPU may be utilized
+	AlertMaxGPUUtilization int64
+	// AlertMinDeposit wei a broadcaster's deposit may drop to
+	AlertMinDeposit *big.Int
+)
+
+var alertCheckInterval = 30 * time.Second
+var getAlertTicker = func() *time.Ticker {
+	return time.NewTicker(alertCheckInterval)
+}
+
+const alertTimeout = 10 * time.Second
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// alertNotification is the payload POSTed to the webhook. `text` makes it a
+// valid Slack incoming webhook message.
+type alertNotification struct {
+	Text      string    `json:"text"`
+	Alert     string    `json:"alert"`
+	Status    string    `json:"status"`
+	Message   string    `json:"message"`
+	NodeID    string    `json:"nodeID"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// alertCheck returns a description of the problem if the alert should fire.
+// firing is whether it currently is, for checks that can't tell to keep it.
+type alertCheck func(ctx context.Context, firing bool) (string, bool)
+
+type alerter struct {
+	nodeID string
+	checks map[string]alertCheck
+	firing map[string]bool
+	client *http.Client
+}
+
+// StartAlerts checks the configured alert thresholds periodically, until ctx
+// is done. Does nothing without AlertWebhookURL.
+func (s *LivepeerServer) StartAlerts(ctx context.Context, nodeID string) {
+	if AlertWebhookURL == "" {
+		return
+	}
+	a := &alerter{
+		nodeID: nodeID,
+		checks: s.alertChecks(),
+		firing: make(map[string]bool),
+		client: &http.Client{Timeout: alertTimeout},
+	}
+	if len(a.checks) == 0 {
+		glog.Warning("Alert webhook set without any alerts that apply to this node")
+		return
+	}
+	go func() {
+		ticker := getAlertTicker()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				a.check(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *LivepeerServer) alertChecks() map[string]alertCheck {
+	n := s.LivepeerNode
+	checks := make(map[string]alertCheck)
+	if n.Eth != nil {
+		checks["eth_rpc"] = func(ctx context.Context, _ bool) (string, bool) {
+			if _, err := checkEthRPC(ctx, n); err != nil {
+				return fmt.Sprintf("eth RPC endpoint is down: %v", err), true
+			}
+			return "eth RPC endpoint is up", false
+		}
+	}
+	if AlertMinSuccessRate > 0 && monitor.Enabled && n.NodeType == core.BroadcasterNode {
+		checks["segment_success_rate"] = func(ctx context.Context, _ bool) (string, bool) {
+			rate := monitor.SegmentSuccessRate()
+			return fmt.Sprintf("segment success rate is %.2f; threshold %.2f", rate, AlertMinSuccessRate), rate < AlertMinSuccessRate
+		}
+	}
+	if AlertMaxGPUUtilization > 0 {
+		checks["gpu_utilization"] = func(ctx context.Context, _ bool) (string, bool) {
+			return gpuUtilizationAlert(monitor.GPUUtilization(), AlertMaxGPUUtilization)
+		}
+	}
+	if AlertMinDeposit != nil && n.Eth != nil && n.NodeType == core.BroadcasterNode {
+		checks["deposit"] = func(ctx context.Context, firing bool) (string, bool) {
+			return depositAlert(n.Eth, AlertMinDeposit, firing)
+		}
+	}
+	return checks
+}
+
+func gpuUtilizationAlert(util map[string]int64, max int64) (string, bool) {
+	var saturated []string
+	for id, u := range util {
+		if u >= max {
+			saturated = append(saturated, fmt.Sprintf("%s=%d%%", id, u))
+		}
+	}
+	if len(saturated) == 0 {
+		return fmt.Sprintf("GPU utilization is below %d%%", max), false
+	}
+	sort.Strings(saturated)
+	return fmt.Sprintf("GPU utilization is at or above %d%%: %s", max, strings.Join(saturated, ", ")), true
+}
+
+// depositAlert fires while the deposit is below min. It stays as it was,
+// firing or not, while the deposit can't be got.
+func depositAlert(client eth.LivepeerEthClient, min *big.Int, firing bool) (string, bool) {
+	info, err := client.GetSenderInfo(client.Account().Address)
+	if err != nil {
+		// The eth_rpc alert covers the node being unreachable
+		glog.Error("Error getting sender info for deposit alert: ", err)
+		return fmt.Sprintf("could not get deposit: %v", err), firing
+	}
+	return fmt.Sprintf("deposit is %v wei; threshold %v wei", info.Deposit, min), info.Deposit.Cmp(min) < 0
+}
+
+// check notifies about the alerts that started firing or resolved since the
+// previous check
+func (a *alerter) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, alertTimeout)
+	defer cancel()
+	for name, check := range a.checks {
+		msg, firing := check(ctx, a.firing[name])
+		if firing == a.firing[name] {
+			continue
+		}
+		status := alertResolved
+		if firing {
+			status = alertFiring
+			glog.Warningf("Alert firing alert=%s: %s", name, msg)
+		} else {
+			glog.Infof("Alert resolved alert=%s: %s", name, msg)
+		}
+		if err := a.notify(ctx, name, status, msg); err != nil {
+			// Try again on the next check
+			glog.Errorf("Error sending alert=%s to webhook err=%v", name, err)
+			continue
+		}
+		a.firing[name] = firing
+	}
+}
+
+func (a *alerter) notify(ctx context.Context, name, status, msg string) error {
+	data, err := json.Marshal(&alertNotification{
+		Text:      fmt.Sprintf("[%s] %s %s: %s", a.nodeID, name, status, msg),
+		Alert:     name,
+		Status:    status,
+		Message:   msg,
+		NodeID:    a.nodeID,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", AlertWebhookURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status=%v body=%s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
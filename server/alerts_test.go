@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/eth"
+	"github.com/livepeer/go-livepeer/pm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAlerter_Check(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var received []alertNotification
+	fail := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var n alertNotification
+		body, _ := ioutil.ReadAll(r.Body)
+		require.Nil(json.Unmarshal(body, &n))
+		received = append(received, n)
+	}))
+	defer ts.Close()
+	defer func(url string) { AlertWebhookURL = url }(AlertWebhookURL)
+	AlertWebhookURL = ts.URL
+
+	firing := false
+	a := &alerter{
+		nodeID: "node1",
+		checks: map[string]alertCheck{
+			"test": func(ctx context.Context, _ bool) (string, bool) { return "value", firing },
+		},
+		firing: make(map[string]bool),
+		client: &http.Client{},
+	}
+
+	// Nothing sent while not firing
+	a.check(context.Background())
+	assert.Len(received, 0)
+
+	firing = true
+	a.check(context.Background())
+	require.Len(received, 1)
+	assert.Equal("test", received[0].Alert)
+	assert.Equal(alertFiring, received[0].Status)
+	assert.Equal("node1", received[0].NodeID)
+	assert.Equal("[node1] test firing: value", received[0].Text)
+
+	// Only sent once while firing
+	a.check(context.Background())
+	assert.Len(received, 1)
+
+	// Resolution is retried until the webhook accepts it
+	firing = false
+	fail = true
+	a.check(context.Background())
+	assert.Len(received, 1)
+	fail = false
+	a.check(context.Background())
+	require.Len(received, 2)
+	assert.Equal(alertResolved, received[1].Status)
+}
+
+func TestGPUUtilizationAlert(t *testing.T) {
+	assert := assert.New(t)
+
+	_, firing := gpuUtilizationAlert(map[string]int64{}, 90)
+	assert.False(firing)
+	_, firing = gpuUtilizationAlert(map[string]int64{"0": 50, "1": 89}, 90)
+	assert.False(firing)
+	msg, firing := gpuUtilizationAlert(map[string]int64{"0": 50, "1": 95, "2": 90}, 90)
+	assert.True(firing)
+	assert.Equal("GPU utilization is at or above 90%: 1=95%, 2=90%", msg)
+}
+
+func TestDepositAlert(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &eth.MockClient{}
+	addr := ethcommon.Address{}
+	client.On("Account").Return(accounts.Account{Address: addr})
+	client.On("GetSenderInfo", addr).Return(&pm.SenderInfo{Deposit: big.NewInt(100)}, nil).Once()
+	_, firing := depositAlert(client, big.NewInt(100), false)
+	assert.False(firing)
+
+	client.On("GetSenderInfo", addr).Return(&pm.SenderInfo{Deposit: big.NewInt(99)}, nil).Once()
+	msg, firing := depositAlert(client, big.NewInt(100), false)
+	assert.True(firing)
+	assert.Equal("deposit is 99 wei; threshold 100 wei", msg)
+
+	// Errors neither fire nor resolve the alert
+	client.On("GetSenderInfo", addr).Return(nil, errors.New("error")).Twice()
+	_, firing = depositAlert(client, big.NewInt(100), false)
+	assert.False(firing)
+	_, firing = depositAlert(client, big.NewInt(100), true)
+	assert.True(firing)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// How the requests to the audited endpoints were made: over TCP to the CLI
+// webserver, over its Unix socket, or to the admin webserver
+const (
+	auditViaCli    = "cli"
+	auditViaSocket = "socket"
+	auditViaAdmin  = "admin"
+)
+
+// auditSecretParams are the parts of the names of the parameters whose
+// values are masked in the audit log
+var auditSecretParams = []string{"secret", "password", "passphrase", "token", "key", "creds"}
+
+// audited is whether requests to path with method are administrative
+// actions: any request to an endpoint that moves funds, and those to
+// endpoints that change settings other than reading them
+func audited(method, path string) bool {
+	required := cliEndpointPermission(path)
+	if strings.HasPrefix(path, "/debug/") {
+		// Require AdminToken themselves, but change the node's profiling
+		required = CliPermissionOperate
+	}
+	switch required {
+	case CliPermissionFunds:
+		return true
+	case CliPermissionOperate:
+		return method != "GET" && method != "HEAD"
+	}
+	return false
+}
+
+// auditCaller identifies who made r via a webserver: by how it was
+// authenticated, and the fingerprint of its token if it has one
+func auditCaller(via string, r *http.Request) string {
+	if via != auditViaCli || len(CliTokens) == 0 {
+		return via
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	granted, ok := cliTokenPermission(token)
+	if !ok {
+		return "token:invalid"
+	}
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("token:%v:%s", granted, hex.EncodeToString(sum[:4]))
+}
+
+// auditParams returns the JSON object of the form and query parameters of r,
+// with the values of secrets masked
+func auditParams(r *http.Request) string {
+	if err := r.ParseForm(); err != nil || len(r.Form) == 0 {
+		return "{}"
+	}
+	params := make(map[string][]string, len(r.Form))
+	for name, values := range r.Form {
+		lower := strings.ToLower(name)
+		for _, secret := range auditSecretParams {
+			if strings.Contains(lower, secret) {
+				values = []string{common.Redacted}
+				break
+			}
+		}
+		params[name] = values
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// statusRecorder keeps the status of the response written through it
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// withAuditLog records the administrative actions requested of h via a
+// webserver in the audit log, whether they're allowed or not: as they're
+// requested, and then with the status they were responded to with. Actions
+// that can't be recorded aren't taken.
+func (s *LivepeerServer) withAuditLog(via string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !audited(r.Method, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		entry := &common.DBAuditEntry{
+			RequestID:  common.RequestID(r.Context()),
+			Caller:     auditCaller(via, r),
+			RemoteAddr: r.RemoteAddr,
+			Action:     r.URL.Path,
+			Params:     auditParams(r),
+		}
+		db := s.LivepeerNode.Database
+		if err := db.InsertAuditEntry(entry); err != nil {
+			glog.Errorf("Unable to record audit entry action=%s requestID=%s; refusing the action: %v", entry.Action, entry.RequestID, err)
+			respondWith500(w, "unable to record the action in the audit log")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		done := *entry
+		done.Status = rec.status
+		if done.Status == 0 {
+			done.Status = http.StatusOK
+		}
+
+		glog.Infof("Audit action=%s caller=%s remote=%s status=%d requestID=%s", done.Action, done.Caller, done.RemoteAddr, done.Status, done.RequestID)
+		if err := db.InsertAuditEntry(&done); err != nil {
+			// The action was taken; its entry as requested is in the log
+			glog.Errorf("Unable to record audit entry action=%s status=%d requestID=%s: %v", done.Action, done.Status, done.RequestID, err)
+		}
+	})
+}
+
+// auditLogHandler responds with the entries of the audit log within the
+// `window` before now, 24h by default
+func auditLogHandler(db *common.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		from, to, err := paymentsWindow(r)
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		entries, err := db.AuditLog(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query audit log: %v", err))
+			return
+		}
+		respondWithJSON(w, entries)
+	})
+}
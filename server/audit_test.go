@@ -0,0 +1,155 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAudited(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(audited("POST", "/bond"))
+	assert.True(audited("GET", "/reward"))
+	assert.True(audited("POST", "/setOrchestratorConfig"))
+	assert.True(audited("POST", "/debug/profiling"))
+	// Reading settings isn't an action
+	assert.False(audited("GET", "/transcoderRules"))
+	assert.False(audited("GET", "/debug/pprof/heap"))
+	assert.False(audited("POST", "/status"))
+	assert.False(audited("GET", "/healthz"))
+}
+
+func TestAuditCaller(t *testing.T) {
+	assert := assert.New(t)
+	defer func(tokens map[string]CliPermission) { CliTokens = tokens }(CliTokens)
+	r := httptest.NewRequest("POST", "/bond", nil)
+
+	CliTokens = nil
+	assert.Equal("cli", auditCaller(auditViaCli, r))
+	assert.Equal("socket", auditCaller(auditViaSocket, r))
+	assert.Equal("admin", auditCaller(auditViaAdmin, r))
+
+	CliTokens = map[string]CliPermission{"abc": CliPermissionFunds}
+	assert.Equal("token:invalid", auditCaller(auditViaCli, r))
+	r.Header.Set("Authorization", "Bearer abc")
+	// The fingerprint is the start of the sha256 of the token
+	assert.Equal("token:funds:ba7816bf", auditCaller(auditViaCli, r))
+}
+
+func TestAuditParams(t *testing.T) {
+	assert := assert.New(t)
+	form := url.Values{"amount": {"100"}, "ethKeyPassphrase": {"hunter2"}, "orchSecret": {"s3cret"}}
+	r := httptest.NewRequest("POST", "/bond?to=0xabc", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var params map[string][]string
+	assert.Nil(json.Unmarshal([]byte(auditParams(r)), &params))
+	assert.Equal(map[string][]string{
+		"amount":           {"100"},
+		"to":               {"0xabc"},
+		"ethKeyPassphrase": {common.Redacted},
+		"orchSecret":       {common.Redacted},
+	}, params)
+	// The handler still gets the parameters
+	assert.Equal("100", r.FormValue("amount"))
+
+	assert.Equal("{}", auditParams(httptest.NewRequest("POST", "/reward", nil)))
+}
+
+func TestWithAuditLog(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	n, _ := core.NewLivepeerNode(nil, "./tmp", dbh)
+	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+
+	h := withRequestID(s.withAuditLog(auditViaSocket, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("amount") == "" {
+			respondWith400(w, "missing amount")
+			return
+		}
+		w.Write([]byte("ok"))
+	})))
+	call := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(http.StatusOK, call("POST", "/bond", url.Values{"amount": {"5"}}).Code)
+	assert.Equal(http.StatusBadRequest, call("POST", "/unbond", url.Values{}).Code)
+	call("POST", "/status", url.Values{"amount": {"5"}})
+
+	// Actions are recorded as they're requested, and once done
+	entries, err := dbh.AuditLog(time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	require.Nil(err)
+	require.Len(entries, 4)
+	actions := map[string]*common.DBAuditEntry{}
+	requested := map[string]*common.DBAuditEntry{}
+	for _, e := range entries {
+		if e.Status == 0 {
+			requested[e.Action] = e
+		} else {
+			actions[e.Action] = e
+		}
+	}
+	require.Contains(requested, "/bond")
+	assert.Equal(`{"amount":["5"]}`, requested["/bond"].Params)
+	require.Contains(requested, "/unbond")
+	require.Contains(actions, "/bond")
+	assert.Equal("socket", actions["/bond"].Caller)
+	assert.Equal(`{"amount":["5"]}`, actions["/bond"].Params)
+	assert.Equal(http.StatusOK, actions["/bond"].Status)
+	assert.NotEmpty(actions["/bond"].RequestID)
+	assert.Equal(requested["/bond"].RequestID, actions["/bond"].RequestID)
+	require.Contains(actions, "/unbond")
+	assert.Equal(http.StatusBadRequest, actions["/unbond"].Status)
+
+	// The log is served at /auditLog
+	w := httptest.NewRecorder()
+	auditLogHandler(dbh).ServeHTTP(w, httptest.NewRequest("GET", "/auditLog?window=1h", nil))
+	require.Equal(http.StatusOK, w.Code)
+	var served []*common.DBAuditEntry
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &served))
+	assert.Len(served, 4)
+}
+
+func TestWithAuditLog_Unrecorded(t *testing.T) {
+	assert := assert.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(t, err)
+	defer dbraw.Close()
+	n, _ := core.NewLivepeerNode(nil, "./tmp", dbh)
+	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	taken := false
+	h := s.withAuditLog(auditViaSocket, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		taken = true
+	}))
+
+	// Actions aren't taken once the audit log can't be written to
+	dbh.Close()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/bond", nil))
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.False(taken)
+
+	// Unaudited requests are served regardless
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.True(taken)
+}
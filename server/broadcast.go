@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -8,8 +9,9 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -18,9 +20,12 @@ import (
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/drivers"
 	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/net"
 	"github.com/livepeer/go-livepeer/pm"
 
 	"github.com/livepeer/lpms/stream"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type BroadcastSessionsManager struct {
@@ -35,6 +40,8 @@ type BroadcastSessionsManager struct {
 	finished   bool // set at stream end
 
 	createSessions func() ([]*BroadcastSession, error)
+
+	lastOrch string // orchestrator the previous segment was sent to
 }
 
 func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
@@ -67,6 +74,16 @@ func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
 	return nil
 }
 
+// switchOrch records sess as used for the next segment. Returns the
+// orchestrator used before if it's a different one.
+func (bsm *BroadcastSessionsManager) switchOrch(sess *BroadcastSession) (string, bool) {
+	bsm.sessLock.Lock()
+	defer bsm.sessLock.Unlock()
+	prev := bsm.lastOrch
+	bsm.lastOrch = sess.OrchestratorInfo.Transcoder
+	return prev, prev != "" && prev != bsm.lastOrch
+}
+
 func (bsm *BroadcastSessionsManager) removeSession(session *BroadcastSession) {
 	bsm.sessLock.Lock()
 	defer bsm.sessLock.Unlock()
@@ -129,6 +146,41 @@ func (bsm *BroadcastSessionsManager) refreshSessions() {
 	bsm.sessList = append(uniqueSessions, bsm.sessList...)
 }
 
+// addSessions adds sessions with orchestrators the stream has none with yet,
+// to be selected before those already there; the first of sessions first
+func (bsm *BroadcastSessionsManager) addSessions(sessions []*BroadcastSession) {
+	bsm.sessLock.Lock()
+	defer bsm.sessLock.Unlock()
+	if bsm.finished {
+		return
+	}
+	for i := len(sessions) - 1; i >= 0; i-- {
+		sess := sessions[i]
+		if _, ok := bsm.sessMap[sess.OrchestratorInfo.Transcoder]; ok {
+			continue
+		}
+		bsm.sessMap[sess.OrchestratorInfo.Transcoder] = sess
+		bsm.sessList = append(bsm.sessList, sess)
+	}
+}
+
+// orchestrators returns the orchestrators the stream has sessions with, the
+// one the previous segment was sent to first
+func (bsm *BroadcastSessionsManager) orchestrators() []string {
+	bsm.sessLock.Lock()
+	defer bsm.sessLock.Unlock()
+	orchs := make([]string, 0, len(bsm.sessMap))
+	if _, ok := bsm.sessMap[bsm.lastOrch]; ok {
+		orchs = append(orchs, bsm.lastOrch)
+	}
+	for orch := range bsm.sessMap {
+		if orch != bsm.lastOrch {
+			orchs = append(orchs, orch)
+		}
+	}
+	return orchs
+}
+
 func (bsm *BroadcastSessionsManager) cleanup() {
 	bsm.sessLock.Lock()
 	defer bsm.sessLock.Unlock()
@@ -162,7 +214,11 @@ func selectOrchestrator(n *core.LivepeerNode, params *streamParameters, cpl core
 
 	rpcBcast := core.NewBroadcaster(n)
 
+	_, span := monitor.StartSpan(context.Background(), "discovery.get_orchestrators",
+		monitor.AttrManifestID.String(string(params.mid)))
 	tinfos, err := n.OrchestratorPool.GetOrchestrators(count)
+	span.SetAttributes(attribute.Int("livepeer.orchestrators", len(tinfos)))
+	monitor.EndSpan(span, err)
 	if len(tinfos) <= 0 {
 		glog.Info("No orchestrators found; not transcoding. Error: ", err)
 		return nil, errNoOrchs
@@ -173,48 +229,111 @@ func selectOrchestrator(n *core.LivepeerNode, params *streamParameters, cpl core
 
 	var sessions []*BroadcastSession
 
-	for _, tinfo := range tinfos {
-		var sessionID string
-
-		if n.Sender != nil {
-			protoParams := tinfo.TicketParams
-			params := pm.TicketParams{
-				Recipient:         ethcommon.BytesToAddress(protoParams.Recipient),
-				FaceValue:         new(big.Int).SetBytes(protoParams.FaceValue),
-				WinProb:           new(big.Int).SetBytes(protoParams.WinProb),
-				RecipientRandHash: ethcommon.BytesToHash(protoParams.RecipientRandHash),
-				Seed:              new(big.Int).SetBytes(protoParams.Seed),
-			}
+	for _, tinfo := range orderByPerformance(n.Database, tinfos) {
+		sessions = append(sessions, newBroadcastSession(n, rpcBcast, params, cpl, tinfo))
+	}
+	return sessions, nil
+}
 
-			sessionID = n.Sender.StartSession(params)
-		}
+// Orchestrators that succeeded on less than minSuccessRate of at least
+// minPerformanceSegments segments over the last performanceWindow are used
+// after the others
+var (
+	performanceWindow            = 3 * 24 * time.Hour
+	minPerformanceSegments int64 = 10
+	minSuccessRate               = 0.5
+)
 
-		var orchOS drivers.OSSession
-		if len(tinfo.Storage) > 0 {
-			orchOS = drivers.NewSession(tinfo.Storage[0])
-		}
+// orderByPerformance moves the orchestrators of tinfos that failed most of
+// the segments they were sent recently to the front, for the broadcaster to
+// use them last. The order is kept otherwise.
+func orderByPerformance(db *common.DB, tinfos []*net.OrchestratorInfo) []*net.OrchestratorInfo {
+	if db == nil {
+		return tinfos
+	}
+	now := time.Now()
+	perfs, err := db.OrchestratorPerformance(now.Add(-performanceWindow), now)
+	if err != nil {
+		glog.Error("Error getting orchestrator performance: ", err)
+		return tinfos
+	}
+	segments, succeeded := make(map[string]int64), make(map[string]int64)
+	for _, p := range perfs {
+		segments[p.Orchestrator] += p.Segments
+		succeeded[p.Orchestrator] += p.Succeeded
+	}
+	unreliable := func(orch string) bool {
+		segs := segments[orch]
+		return segs >= minPerformanceSegments && float64(succeeded[orch])/float64(segs) < minSuccessRate
+	}
 
-		bcastOS := cpl.GetOSSession()
-		if bcastOS.IsExternal() {
-			// Give each O its own OS session to prevent front running uploads
-			pfx := fmt.Sprintf("%v/%v", cpl.ManifestID(), core.RandomManifestID())
-			bcastOS = drivers.NodeStorage.NewSession(pfx)
+	ordered := make([]*net.OrchestratorInfo, 0, len(tinfos))
+	for _, tinfo := range tinfos {
+		if unreliable(tinfo.GetTranscoder()) {
+			glog.Infof("Using orchestrator %v last; it succeeded on %d of the %d segments sent recently",
+				tinfo.GetTranscoder(), succeeded[tinfo.GetTranscoder()], segments[tinfo.GetTranscoder()])
+			ordered = append(ordered, tinfo)
+		}
+	}
+	for _, tinfo := range tinfos {
+		if !unreliable(tinfo.GetTranscoder()) {
+			ordered = append(ordered, tinfo)
 		}
+	}
+	return ordered
+}
 
-		session := &BroadcastSession{
-			Broadcaster:      rpcBcast,
-			ManifestID:       params.mid,
-			Profiles:         params.profiles,
-			OrchestratorInfo: tinfo,
-			OrchestratorOS:   orchOS,
-			BroadcasterOS:    bcastOS,
-			Sender:           n.Sender,
-			PMSessionID:      sessionID,
+// newBroadcastSession sets up a session of the stream with the orchestrator
+// of tinfo
+func newBroadcastSession(n *core.LivepeerNode, rpcBcast Broadcaster, params *streamParameters, cpl core.PlaylistManager,
+	tinfo *net.OrchestratorInfo) *BroadcastSession {
+
+	var sessionID string
+
+	if n.Sender != nil {
+		protoParams := tinfo.TicketParams
+		params := pm.TicketParams{
+			Recipient:         ethcommon.BytesToAddress(protoParams.Recipient),
+			FaceValue:         new(big.Int).SetBytes(protoParams.FaceValue),
+			WinProb:           new(big.Int).SetBytes(protoParams.WinProb),
+			RecipientRandHash: ethcommon.BytesToHash(protoParams.RecipientRandHash),
+			Seed:              new(big.Int).SetBytes(protoParams.Seed),
 		}
 
-		sessions = append(sessions, session)
+		sessionID = n.Sender.StartSession(params)
+	}
+
+	var orchOS drivers.OSSession
+	if len(tinfo.Storage) > 0 {
+		orchOS = drivers.NewSession(tinfo.Storage[0])
+	}
+
+	bcastOS := cpl.GetOSSession()
+	if bcastOS.IsExternal() {
+		// Give each O its own OS session to prevent front running uploads
+		pfx := fmt.Sprintf("%v/%v", cpl.ManifestID(), core.RandomManifestID())
+		bcastOS = drivers.NodeStorage.NewSession(pfx)
+	}
+
+	return &BroadcastSession{
+		Broadcaster:      rpcBcast,
+		ManifestID:       params.mid,
+		Profiles:         params.profiles,
+		OrchestratorInfo: tinfo,
+		OrchestratorOS:   orchOS,
+		BroadcasterOS:    bcastOS,
+		Sender:           n.Sender,
+		PMSessionID:      sessionID,
+		Database:         n.Database,
+		Priority:         params.priority,
+	}
+}
+
+// recordSegmentPhase records the time a segment spent in phase
+var recordSegmentPhase = func(phase monitor.SegmentPhase, dur time.Duration) {
+	if monitor.Enabled {
+		monitor.SegmentPhaseLatency(phase, dur)
 	}
-	return sessions, nil
 }
 
 func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) {
@@ -224,25 +343,45 @@ func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) {
 	mid := cxn.mid
 	vProfile := cxn.profile
 
-	glog.V(common.DEBUG).Infof("Processing segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
+	// Identifies the segment in the logs of every node it's sent to
+	reqID := common.NewRequestID()
+	glog.V(common.DEBUG).Infof("Processing segment nonce=%d seqNo=%d requestID=%s", nonce, seg.SeqNo, reqID)
+	emerged := time.Now()
 	if monitor.Enabled {
 		monitor.SegmentEmerged(nonce, seg.SeqNo, len(BroadcastJobVideoProfiles))
 	}
+	cxn.stats.segmentEmerged(seg)
+	// Segment spans start once the segmenter is done with the segment
+	ctx, span := monitor.StartSpan(common.WithRequestID(context.Background(), reqID), "broadcast.segment",
+		monitor.AttrManifestID.String(string(mid)), monitor.AttrNonce.Int64(int64(nonce)),
+		monitor.AttrSeqNo.Int64(int64(seg.SeqNo)), attribute.Float64("livepeer.duration", seg.Duration))
 
 	seg.Name = "" // hijack seg.Name to convey the uploaded URI
 	name := fmt.Sprintf("%s/%d.ts", vProfile.Name, seg.SeqNo)
+	_, uspan := monitor.StartSpan(ctx, "storage.upload", attribute.String("livepeer.name", name))
 	uri, err := cpl.GetOSSession().SaveData(name, seg.Data)
+	monitor.EndSpan(uspan, err)
 	if err != nil {
 		glog.Errorf("Error saving segment nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorUnknown, err.Error(), true)
 		}
+		monitor.EndSpan(span, err)
+		cxn.stats.segmentDone(seg.SeqNo)
 		return
 	}
 	if cpl.GetOSSession().IsExternal() {
 		seg.Name = uri // hijack seg.Name to convey the uploaded URI
 	}
+	if cxn.failover != nil {
+		cxn.failover.segmentIngested(seg.SeqNo)
+	}
+	// Ingested once the source is stored; publishing it is measured apart
+	recordSegmentPhase(monitor.SegmentPhaseIngest, time.Since(emerged))
+	_, pspan := monitor.StartSpan(ctx, "playlist.publish", attribute.String("livepeer.rendition", vProfile.Name))
+	pubStart := time.Now()
 	err = cpl.InsertHLSSegment(vProfile, seg.SeqNo, uri, seg.Duration)
+	monitor.EndSpan(pspan, err)
 	if monitor.Enabled {
 		monitor.SourceSegmentAppeared(nonce, seg.SeqNo, string(mid), vProfile.Name)
 	}
@@ -251,25 +390,35 @@ func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) {
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorUnknown, err.Error(), true)
 		}
+	} else {
+		recordSegmentPhase(monitor.SegmentPhasePublish, time.Since(pubStart))
 	}
 
 	// Process the rest of the segment asynchronously - transcode
 	go func() {
+		defer span.End()
+		defer cxn.stats.segmentDone(seg.SeqNo)
 		for true {
 			// if fails, retry; rudimentary
-			if err := transcodeSegment(cxn, seg, name); err == nil {
+			if err := transcodeSegment(ctx, cxn, seg, name, emerged); err == nil {
 				return
 			}
 		}
 	}()
 }
 
-func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string) error {
+func transcodeSegment(ctx context.Context, cxn *rtmpConnection, seg *stream.HLSSegment, name string,
+	emerged time.Time) (err error) {
 
 	nonce := cxn.nonce
 	rtmpStrm := cxn.stream
 	cpl := cxn.pl
+	reqID := common.RequestID(ctx)
+	ctx, span := monitor.StartSpan(ctx, "broadcast.transcode")
+	defer func() { monitor.EndSpan(span, err) }()
+	_, sspan := monitor.StartSpan(ctx, "discovery.select_session")
 	sess := cxn.sessManager.selectSession()
+	sspan.End()
 	// Return early under a few circumstances:
 	// View-only (non-transcoded) streams or no sessions available
 	if sess == nil {
@@ -279,16 +428,26 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 		glog.Infof("No sessions available for segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
 		return nil
 	}
+	submitted := time.Now()
+	defer func() { recordOrchestratorSegment(sess, time.Since(submitted), err) }()
 	{
-		glog.Infof("Trying to transcode segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
+		glog.Infof("Trying to transcode segment nonce=%d seqNo=%d requestID=%s", nonce, seg.SeqNo, reqID)
 		if monitor.Enabled {
 			monitor.TranscodeTry(nonce, seg.SeqNo)
 		}
+		span.SetAttributes(monitor.AttrOrch.String(sess.OrchestratorInfo.Transcoder))
+		if prev, switched := cxn.sessManager.switchOrch(sess); switched {
+			monitor.EmitEvent(monitor.EventOrchestratorSwitched, map[string]interface{}{
+				"manifestID": string(cxn.mid), "seqNo": seg.SeqNo, "previous": prev, "orchestrator": sess.OrchestratorInfo.Transcoder,
+			})
+		}
 
 		// storage the orchestrator prefers
 		if ios := sess.OrchestratorOS; ios != nil {
 			// XXX handle case when orch expects direct upload
+			_, uspan := monitor.StartSpan(ctx, "storage.upload", attribute.String("livepeer.name", name))
 			uri, err := ios.SaveData(name, seg.Data)
+			monitor.EndSpan(uspan, err)
 			if err != nil {
 				glog.Errorf("Error saving segment to OS nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
 				if monitor.Enabled {
@@ -303,7 +462,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 		// send segment to the orchestrator
 		glog.V(common.DEBUG).Infof("Submitting segment nonce=%d seqNo=%d orch=%s", nonce, seg.SeqNo, sess.OrchestratorInfo.Transcoder)
 
-		res, err := SubmitSegment(sess, seg, nonce)
+		res, err := SubmitSegment(ctx, sess, seg, nonce)
 		if err != nil || res == nil {
 			cxn.sessManager.removeSession(sess)
 			if res == nil && err == nil {
@@ -325,7 +484,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 		gotErr := false // only send one error msg per segment list
 		var errCode monitor.SegmentTranscodeError
 		errFunc := func(subType monitor.SegmentTranscodeError, url string, err error) {
-			glog.Errorf("%v error with segment nonce=%d seqNo=%d: %v (URL: %v)", subType, nonce, seg.SeqNo, err, url)
+			glog.Errorf("%v error with segment nonce=%d seqNo=%d requestID=%s: %v (URL: %v)", subType, nonce, seg.SeqNo, reqID, err, url)
 			if monitor.Enabled && !gotErr {
 				monitor.SegmentTranscodeFailed(subType, nonce, seg.SeqNo, err, false)
 				gotErr = true
@@ -350,7 +509,13 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 			}()
 
 			if bos := sess.BroadcasterOS; bos != nil && !drivers.IsOwnExternal(url) {
+				_, dspan := monitor.StartSpan(ctx, "storage.download", attribute.String("livepeer.rendition", sess.Profiles[i].Name))
+				dlStart := time.Now()
 				data, err := drivers.GetSegmentData(url)
+				monitor.EndSpan(dspan, err)
+				if err == nil {
+					recordSegmentPhase(monitor.SegmentPhaseDownload, time.Since(dlStart))
+				}
 				if err != nil {
 					errFunc(monitor.SegmentTranscodeErrorDownload, url, err)
 					segHashLock.Lock()
@@ -360,7 +525,9 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 					return
 				}
 				name := fmt.Sprintf("%s/%d.ts", sess.Profiles[i].Name, seg.SeqNo)
+				_, uspan := monitor.StartSpan(ctx, "storage.upload", attribute.String("livepeer.name", name))
 				newURL, err := bos.SaveData(name, data)
+				monitor.EndSpan(uspan, err)
 				if err != nil {
 					segHashLock.Lock()
 					saveErr = err
@@ -384,7 +551,13 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 			if monitor.Enabled {
 				monitor.TranscodedSegmentAppeared(nonce, seg.SeqNo, sess.Profiles[i].Name)
 			}
+			_, pspan := monitor.StartSpan(ctx, "playlist.publish", attribute.String("livepeer.rendition", sess.Profiles[i].Name))
+			pubStart := time.Now()
 			err = cpl.InsertHLSSegment(&sess.Profiles[i], seg.SeqNo, url, seg.Duration)
+			monitor.EndSpan(pspan, err)
+			if err == nil {
+				recordSegmentPhase(monitor.SegmentPhasePublish, time.Since(pubStart))
+			}
 			if err != nil {
 				errFunc(monitor.SegmentTranscodeErrorPlaylist, url, err)
 				return
@@ -404,22 +577,51 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string)
 			return dlErr
 		}
 		ticketParams := sess.OrchestratorInfo.GetTicketParams()
+		_, vspan := monitor.StartSpan(ctx, "broadcast.verify")
+		verifyStart := time.Now()
 		if ticketParams != nil && // may be nil in offchain mode
 			saveErr == nil && // save error leads to early exit before sighash computation
 			!pm.VerifySig(ethcommon.BytesToAddress(ticketParams.Recipient), crypto.Keccak256(segHashes...), res.Sig) {
-			glog.Errorf("Sig check failed for segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
+			glog.Errorf("Sig check failed for segment nonce=%d seqNo=%d requestID=%s", nonce, seg.SeqNo, reqID)
+			if monitor.Enabled {
+				monitor.OrchestratorVerificationFailed(sess.OrchestratorInfo.Transcoder)
+			}
+			monitor.EmitEvent(monitor.EventVerificationFailed, map[string]interface{}{
+				"manifestID": string(cxn.mid), "seqNo": seg.SeqNo, "orchestrator": sess.OrchestratorInfo.Transcoder,
+			})
 			cxn.sessManager.removeSession(sess)
+			monitor.EndSpan(vspan, errPMCheckFailed)
 			return errPMCheckFailed
 		}
+		vspan.End()
+		recordSegmentPhase(monitor.SegmentPhaseVerify, time.Since(verifyStart))
+		recordSegmentPhase(monitor.SegmentPhaseTotal, time.Since(emerged))
 		if monitor.Enabled {
 			monitor.SegmentFullyTranscoded(nonce, seg.SeqNo, common.ProfilesNames(sess.Profiles), errCode)
 		}
 
 		glog.V(common.DEBUG).Infof("Successfully validated segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
+		cxn.stats.segmentTranscoded(seg.SeqNo, sess.OrchestratorInfo.Transcoder)
+		monitor.EmitEvent(monitor.EventSegmentTranscoded, map[string]interface{}{
+			"manifestID": string(cxn.mid), "seqNo": seg.SeqNo, "orchestrator": sess.OrchestratorInfo.Transcoder,
+			"profiles": common.ProfilesNames(sess.Profiles),
+		})
 		return nil
 	}
 }
 
+// recordOrchestratorSegment adds a segment to the performance history of the
+// orchestrator it was sent to
+func recordOrchestratorSegment(sess *BroadcastSession, latency time.Duration, err error) {
+	var paid *big.Int
+	if tp := sess.OrchestratorInfo.GetTicketParams(); tp != nil && sess.Sender != nil {
+		ticket := &pm.Ticket{FaceValue: new(big.Int).SetBytes(tp.FaceValue), WinProb: new(big.Int).SetBytes(tp.WinProb)}
+		ev := ticket.EV()
+		paid = new(big.Int).Quo(ev.Num(), ev.Denom())
+	}
+	sess.Database.RecordOrchestratorSegment(sess.OrchestratorInfo.GetTranscoder(), latency, err == nil, paid)
+}
+
 var sessionErrStrings = []string{"dial tcp", "unexpected EOF", core.ErrOrchBusy.Error(), core.ErrOrchCap.Error()}
 
 func generateSessionErrors() *regexp.Regexp {
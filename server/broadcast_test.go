@@ -1,17 +1,28 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func StubBroadcastSession(transcoder string) *BroadcastSession {
@@ -325,6 +336,126 @@ func TestCleanupSessions(t *testing.T) {
 	assert.Len(bsm.sessMap, 0)
 }
 
+func TestOrderByPerformance(t *testing.T) {
+	assert := assert.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(t, err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	record := func(orch string, succeeded, failed int) {
+		for i := 0; i < succeeded+failed; i++ {
+			require.Nil(t, dbh.RecordOrchestratorSegment(orch, time.Second, i < succeeded, nil))
+		}
+	}
+	record("o1", 2, 10)  // unreliable
+	record("o2", 10, 2)  // reliable
+	record("o3", 0, 5)   // too few segments to tell
+	record("o4", 10, 11) // unreliable
+	tinfos := []*net.OrchestratorInfo{{Transcoder: "o1"}, {Transcoder: "o2"}, {Transcoder: "o3"}, {Transcoder: "o4"}, {Transcoder: "o5"}}
+	transcoders := func(tinfos []*net.OrchestratorInfo) []string {
+		var ts []string
+		for _, tinfo := range tinfos {
+			ts = append(ts, tinfo.Transcoder)
+		}
+		return ts
+	}
+
+	// The unreliable are used last
+	assert.Equal([]string{"o1", "o4", "o2", "o3", "o5"}, transcoders(orderByPerformance(dbh, tinfos)))
+
+	// Orchestrators are left as they are without any history
+	assert.Equal(tinfos, orderByPerformance(nil, tinfos))
+}
+
 // Note: Add processSegment tests, including:
 //     assert an error from transcoder removes sess from BroadcastSessionManager
 //     assert a success re-adds sess to BroadcastSessionManager
+
+// phaseRecorder keeps the phases segments were recorded in, in order
+type phaseRecorder struct {
+	mu     sync.Mutex
+	phases []monitor.SegmentPhase
+}
+
+func (r *phaseRecorder) record(phase monitor.SegmentPhase, dur time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.phases = append(r.phases, phase)
+}
+
+func (r *phaseRecorder) recorded() []monitor.SegmentPhase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]monitor.SegmentPhase(nil), r.phases...)
+}
+
+func stubRTMPConnection(sessions ...*BroadcastSession) *rtmpConnection {
+	base, _ := url.Parse("http://node")
+	mid := core.RandomManifestID()
+	bsm := &BroadcastSessionsManager{sessMap: make(map[string]*BroadcastSession), sessLock: &sync.Mutex{}}
+	for _, sess := range sessions {
+		bsm.sessList = append(bsm.sessList, sess)
+		bsm.sessMap[sess.OrchestratorInfo.Transcoder] = sess
+	}
+	return &rtmpConnection{
+		mid:         mid,
+		nonce:       1,
+		pl:          core.NewBasicPlaylistManager(mid, drivers.NewMemoryDriver(base).NewSession(string(mid))),
+		profile:     &ffmpeg.P720p30fps16x9,
+		sessManager: bsm,
+		stats:       newStreamStats(),
+	}
+}
+
+func TestProcessSegment_Phases(t *testing.T) {
+	rec := &phaseRecorder{}
+	defer func(f func(monitor.SegmentPhase, time.Duration)) { recordSegmentPhase = f }(recordSegmentPhase)
+	recordSegmentPhase = rec.record
+
+	// Without orchestrators, the source is only stored and then published
+	processSegment(stubRTMPConnection(), &stream.HLSSegment{SeqNo: 1, Data: []byte("source"), Duration: 2})
+	assert.Equal(t, []monitor.SegmentPhase{monitor.SegmentPhaseIngest, monitor.SegmentPhasePublish}, rec.recorded())
+}
+
+func TestTranscodeSegment_Phases(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	rec := &phaseRecorder{}
+	defer func(f func(monitor.SegmentPhase, time.Duration)) { recordSegmentPhase = f }(recordSegmentPhase)
+	recordSegmentPhase = rec.record
+
+	rendition := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("rendition"))
+	}))
+	defer rendition.Close()
+	buf, err := proto.Marshal(&net.TranscodeResult{Result: &net.TranscodeResult_Data{Data: &net.TranscodeData{
+		Segments: []*net.TranscodedSegmentData{{Url: rendition.URL + "/P144p30fps16x9/1.ts"}},
+	}}})
+	require.Nil(err)
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write(buf)
+	})
+
+	base, _ := url.Parse("http://node")
+	sess := StubBroadcastSession(ts.URL)
+	sess.Profiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+	sess.BroadcasterOS = drivers.NewMemoryDriver(base).NewSession(string(sess.ManifestID))
+	cxn := stubRTMPConnection(sess)
+	seg := &stream.HLSSegment{SeqNo: 1, Data: []byte("source"), Duration: 2}
+	require.Nil(transcodeSegment(context.Background(), cxn, seg, "source/1.ts", time.Now()))
+
+	// Each phase is recorded once, in order, with the rendition downloaded
+	// into the broadcaster's storage
+	assert.Equal([]monitor.SegmentPhase{
+		monitor.SegmentPhaseUpload,
+		monitor.SegmentPhaseTranscode,
+		monitor.SegmentPhaseDownload,
+		monitor.SegmentPhasePublish,
+		monitor.SegmentPhaseVerify,
+		monitor.SegmentPhaseTotal,
+	}, rec.recorded())
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Broadcasters stop sending requests to orchestrators that refuse them for
+// being at capacity until the Retry-After they respond with, or for
+// CapacityBackoff if they don't give one. Retry-Afters longer than
+// MaxCapacityBackoff are cut short.
+var (
+	CapacityBackoff    = 10 * time.Second
+	MaxCapacityBackoff = 5 * time.Minute
+)
+
+// retryAfterTrailer is the gRPC trailer with the seconds until a refused call
+// may be made again
+const retryAfterTrailer = "retry-after"
+
+// segErrAtCapacity is the code of the error refusing segments of new streams
+// while the orchestrator is at capacity
+const segErrAtCapacity = "at_capacity"
+
+var errOrchBackingOff = errors.New("orchestrator at capacity")
+
+// retryAfterSeconds is d in whole seconds, rounded up
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// resourceExhausted refuses a gRPC call with ResourceExhausted, and a
+// Retry-After trailer of when it may be made again
+func resourceExhausted(ctx context.Context, retryAfter time.Duration, format string, args ...interface{}) error {
+	grpc.SetTrailer(ctx, metadata.Pairs(retryAfterTrailer, retryAfterSeconds(retryAfter)))
+	return status.Errorf(codes.ResourceExhausted, format, args...)
+}
+
+// capacityStatus is the error GetOrchestrator refuses broadcasters with while
+// the orchestrator is at capacity
+func capacityStatus(ctx context.Context, err *core.CapacityError) error {
+	return resourceExhausted(ctx, err.RetryAfter, "orchestrator at capacity; retry in %v", err.RetryAfter)
+}
+
+// parseRetryAfter is the duration of a Retry-After in seconds; 0 if it isn't
+// one
+func parseRetryAfter(s string) time.Duration {
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// orchBackoff tracks the orchestrators a broadcaster holds off sending
+// requests to, by host, until when
+type orchBackoff struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+var capacityBackoff = &orchBackoff{until: make(map[string]time.Time)}
+
+// backoff holds off the orchestrator at uri for retryAfter from now, or for
+// CapacityBackoff if not given
+func (b *orchBackoff) backoff(uri string, retryAfter time.Duration, now time.Time) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return
+	}
+	if retryAfter <= 0 {
+		retryAfter = CapacityBackoff
+	}
+	if retryAfter > MaxCapacityBackoff {
+		retryAfter = MaxCapacityBackoff
+	}
+	glog.Infof("Orchestrator %v at capacity; backing off for %v", u.Host, retryAfter)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until := now.Add(retryAfter); until.After(b.until[u.Host]) {
+		b.until[u.Host] = until
+	}
+}
+
+// backingOff returns how much longer requests to the orchestrator at uri are
+// held off for, if they are
+func (b *orchBackoff) backingOff(uri *url.URL, now time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.until[uri.Host]
+	if !ok {
+		return 0, false
+	}
+	if !now.Before(until) {
+		delete(b.until, uri.Host)
+		return 0, false
+	}
+	return until.Sub(now), true
+}
+
+// backoffResponse holds off the orchestrator at uri if resp, with body,
+// refused a segment for it being at capacity. Segments refused for breaking
+// the limits of the broadcaster, or its streams, don't hold the orchestrator
+// off for all of them.
+func (b *orchBackoff) backoffResponse(uri string, resp *http.Response, body []byte, now time.Time) {
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	var e segmentError
+	if err := json.Unmarshal(body, &e); err != nil || e.Code != segErrAtCapacity {
+		// Others may just be shutting down
+		return
+	}
+	retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+	if retryAfter <= 0 {
+		retryAfter = time.Duration(e.RetryAfter) * time.Second
+	}
+	b.backoff(uri, retryAfter, now)
+}
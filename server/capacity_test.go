@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// cappedOrchestrator is at capacity for any new stream
+type cappedOrchestrator struct {
+	*mockOrchestrator
+	err error
+}
+
+func (o *cappedOrchestrator) CheckCapacity(mid core.ManifestID) error {
+	return o.err
+}
+
+func stubCapacityBackoff() func() {
+	prev := capacityBackoff
+	capacityBackoff = &orchBackoff{until: make(map[string]time.Time)}
+	return func() { capacityBackoff = prev }
+}
+
+func TestOrchBackoff(t *testing.T) {
+	assert := assert.New(t)
+	defer stubCapacityBackoff()()
+	now := time.Now()
+	uri, _ := url.Parse("https://127.0.0.1:8935")
+
+	_, ok := capacityBackoff.backingOff(uri, now)
+	assert.False(ok)
+
+	// Orchestrators are held off for as long as they said
+	capacityBackoff.backoff("https://127.0.0.1:8935", 20*time.Second, now)
+	wait, ok := capacityBackoff.backingOff(uri, now.Add(5*time.Second))
+	assert.True(ok)
+	assert.Equal(15*time.Second, wait)
+	// by host, whatever the path
+	other, _ := url.Parse("https://127.0.0.1:8935/segment")
+	_, ok = capacityBackoff.backingOff(other, now)
+	assert.True(ok)
+
+	// A shorter Retry-After doesn't cut the backoff short
+	capacityBackoff.backoff("https://127.0.0.1:8935", 5*time.Second, now)
+	wait, _ = capacityBackoff.backingOff(uri, now)
+	assert.Equal(20*time.Second, wait)
+
+	_, ok = capacityBackoff.backingOff(uri, now.Add(20*time.Second))
+	assert.False(ok)
+
+	// Without a Retry-After, or with too long of one
+	capacityBackoff.backoff("https://127.0.0.1:8935", 0, now)
+	wait, _ = capacityBackoff.backingOff(uri, now)
+	assert.Equal(CapacityBackoff, wait)
+	capacityBackoff.backoff("https://127.0.0.1:8935", 24*time.Hour, now)
+	wait, _ = capacityBackoff.backingOff(uri, now)
+	assert.Equal(MaxCapacityBackoff, wait)
+}
+
+func TestOrchBackoff_Response(t *testing.T) {
+	uri, _ := url.Parse("https://127.0.0.1:8935")
+	for _, tt := range []struct {
+		name    string
+		respond func(w http.ResponseWriter)
+		wait    time.Duration
+	}{
+		{"at capacity", func(w http.ResponseWriter) {
+			respondSegmentError(w, http.StatusServiceUnavailable, segErrAtCapacity, errOrchBackingOff.Error(), 30*time.Second)
+		}, 30 * time.Second},
+		{"at capacity without a Retry-After header", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(segmentError{Code: segErrAtCapacity, RetryAfter: 40})
+		}, 40 * time.Second},
+		{"at capacity without saying when to retry", func(w http.ResponseWriter) {
+			respondSegmentError(w, http.StatusServiceUnavailable, segErrAtCapacity, errOrchBackingOff.Error(), 0)
+		}, CapacityBackoff},
+		// Limits of the broadcaster and its streams only hold off its segments
+		{"too many uploads", func(w http.ResponseWriter) {
+			respondSegmentError(w, http.StatusTooManyRequests, segErrTooManyUploads, "too many uploads", 30*time.Second)
+		}, 0},
+		{"banned", func(w http.ResponseWriter) {
+			respondSegmentError(w, http.StatusTooManyRequests, segErrBanned, "banned", 5*time.Minute)
+		}, 0},
+		{"rate limited", func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		}, 0},
+		// and orchestrators that are unavailable otherwise may be shutting down
+		{"unavailable", func(w http.ResponseWriter) {
+			w.Header().Set("Retry-After", "30")
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		}, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			defer stubCapacityBackoff()()
+			rec := httptest.NewRecorder()
+			tt.respond(rec)
+			resp := rec.Result()
+			now := time.Now()
+			capacityBackoff.backoffResponse(uri.String(), resp, rec.Body.Bytes(), now)
+
+			wait, ok := capacityBackoff.backingOff(uri, now)
+			assert.Equal(t, tt.wait > 0, ok)
+			assert.Equal(t, tt.wait, wait)
+		})
+	}
+}
+
+func TestGetOrchestrator_AtCapacity(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	o := newStubOrchestrator()
+	o.sessCapErr = &core.CapacityError{RetryAfter: 20 * time.Second}
+	req, err := genOrchestratorReq(stubBroadcaster2())
+	require.Nil(err)
+
+	_, err = getOrchestrator(o, req)
+	assert.Equal(o.sessCapErr, err)
+
+	lp := &lphttp{orchestrator: o}
+	_, err = lp.GetOrchestrator(context.Background(), req)
+	assert.Equal(codes.ResourceExhausted, status.Code(err))
+	assert.Contains(err.Error(), "retry in 20s")
+}
+
+func TestGetOrchestratorInfo_BackingOff(t *testing.T) {
+	defer stubCapacityBackoff()()
+	uri, _ := url.Parse("https://127.0.0.1:8935")
+	capacityBackoff.backoff(uri.String(), time.Minute, time.Now())
+
+	// The orchestrator isn't called at all
+	start := time.Now()
+	_, err := GetOrchestratorInfo(context.Background(), stubBroadcaster2(), uri)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), errOrchBackingOff.Error())
+	assert.True(t, time.Since(start) < GRPCConnectTimeout)
+}
+
+func TestServeSegment_AtCapacity(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	orch := &cappedOrchestrator{&mockOrchestrator{}, &core.CapacityError{RetryAfter: 20 * time.Second}}
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		ManifestID:  core.RandomManifestID(),
+	}
+	creds, err := genSegCreds(s, &stream.HLSSegment{})
+	require.Nil(err)
+
+	headers := map[string]string{
+		paymentHeader: "",
+		segmentHeader: creds,
+	}
+	resp := httpPostResp(serveSegmentHandler(orch), nil, headers)
+	defer resp.Body.Close()
+
+	// Refused without counting as a violation
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal("20", resp.Header.Get("Retry-After"))
+	var e segmentError
+	require.Nil(json.NewDecoder(resp.Body).Decode(&e))
+	assert.Equal(segErrAtCapacity, e.Code)
+	assert.Equal(20, e.RetryAfter)
+}
+
+func TestSubmitSegment_AtCapacity(t *testing.T) {
+	assert := assert.New(t)
+	defer stubCapacityBackoff()()
+
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		respondSegmentError(w, http.StatusServiceUnavailable, segErrAtCapacity, errOrchBackingOff.Error(), 30*time.Second)
+	})
+	uri, _ := url.Parse(ts.URL)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		ManifestID:  core.RandomManifestID(),
+		OrchestratorInfo: &net.OrchestratorInfo{
+			Transcoder: ts.URL,
+		},
+	}
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
+	assert.NotNil(err)
+
+	// The orchestrator is held off for the Retry-After it responded with
+	wait, ok := capacityBackoff.backingOff(uri, time.Now())
+	assert.True(ok)
+	assert.InDelta(30*time.Second, wait, float64(time.Second))
+}
+
+func TestSubmitSegment_OrchestratorCapped(t *testing.T) {
+	assert := assert.New(t)
+	defer stubCapacityBackoff()()
+
+	buf, err := proto.Marshal(&net.TranscodeResult{
+		Result: &net.TranscodeResult_Error{Error: core.ErrOrchCap.Error()},
+	})
+	require.Nil(t, err)
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf)
+	})
+	uri, _ := url.Parse(ts.URL)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		ManifestID:  core.RandomManifestID(),
+		OrchestratorInfo: &net.OrchestratorInfo{
+			Transcoder: ts.URL,
+		},
+	}
+	_, err = SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
+	assert.Equal(core.ErrOrchCap.Error(), err.Error())
+
+	// Orchestrators that don't say when to retry are held off for the default
+	wait, ok := capacityBackoff.backingOff(uri, time.Now())
+	assert.True(ok)
+	assert.InDelta(CapacityBackoff, wait, float64(time.Second))
+}
+
+func TestSubmitSegment_BackingOff(t *testing.T) {
+	assert := assert.New(t)
+	defer stubCapacityBackoff()()
+
+	var mu sync.Mutex
+	submitted := 0
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		submitted++
+		mu.Unlock()
+		respondSegmentError(w, http.StatusTooManyRequests, segErrTooManyUploads, "too many uploads", 30*time.Second)
+	})
+	uri, _ := url.Parse(ts.URL)
+	s := StubBroadcastSession(ts.URL)
+
+	// Segments refused for the limits of the stream don't hold the
+	// orchestrator off
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
+	assert.NotNil(err)
+	mu.Lock()
+	assert.Equal(1, submitted)
+	mu.Unlock()
+	_, ok := capacityBackoff.backingOff(uri, time.Now())
+	assert.False(ok)
+
+	// Orchestrators held off still get the segments of existing sessions;
+	// only new sessions are kept from them
+	capacityBackoff.backoff(ts.URL, time.Minute, time.Now())
+	_, err = SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
+	assert.NotNil(err)
+	assert.NotContains(err.Error(), errOrchBackingOff.Error())
+	mu.Lock()
+	assert.Equal(2, submitted)
+	mu.Unlock()
+}
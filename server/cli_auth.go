@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// CliPermission is what a token may do with the endpoints of the CLI
+// webserver; each permission includes those below it
+type CliPermission int
+
+const (
+	// CliPermissionNone is for the endpoints anyone may call, such as probes
+	CliPermissionNone CliPermission = iota
+	// CliPermissionRead is for reading the node's state
+	CliPermissionRead
+	// CliPermissionOperate is for changing the node's settings, such as its
+	// price, but not spending its funds
+	CliPermissionOperate
+	// CliPermissionFunds is for sending transactions that move or lock the
+	// node's funds, or spend gas
+	CliPermissionFunds
+)
+
+var cliPermissionNames = map[CliPermission]string{
+	CliPermissionNone:    "none",
+	CliPermissionRead:    "read",
+	CliPermissionOperate: "operate",
+	CliPermissionFunds:   "funds",
+}
+
+func (p CliPermission) String() string {
+	if name, ok := cliPermissionNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("CliPermission(%d)", int(p))
+}
+
+// ParseCliPermission parses read, operate or funds
+func ParseCliPermission(s string) (CliPermission, error) {
+	for p, name := range cliPermissionNames {
+		if p != CliPermissionNone && strings.EqualFold(s, name) {
+			return p, nil
+		}
+	}
+	return CliPermissionNone, fmt.Errorf("invalid CLI permission %v; must be read, operate or funds", s)
+}
+
+// ParseCliTokens parses comma-separated `permission:token` pairs, e.g.
+// `read:abc,funds:def`
+func ParseCliTokens(s string) (map[string]CliPermission, error) {
+	tokens := make(map[string]CliPermission)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, errors.New("invalid CLI token; must be permission:token")
+		}
+		p, err := ParseCliPermission(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		tokens[parts[1]] = p
+	}
+	return tokens, nil
+}
+
+// CliTokens are the bearer tokens the endpoints of the CLI webserver may be
+// called with over TCP, and what each may do. If there are none, the
+// endpoints are open over TCP as they always were, unless CliSocket is set.
+var CliTokens map[string]CliPermission
+
+// CliSocket is the path of a Unix socket the CLI webserver is also served
+// at, to any local user the socket's permissions allow, without tokens. If
+// set without CliTokens, only the endpoints that read are served over TCP.
+var CliSocket string
+
+// cliEndpointPermissions are the permissions required by the endpoints of the
+// CLI webserver. Endpoints that aren't listed require CliPermissionFunds.
+var cliEndpointPermissions = map[string]CliPermission{
+	"/healthz": CliPermissionNone,
+	"/readyz":  CliPermissionNone,
+
+	"/getBroadcastConfig":               CliPermissionRead,
+	"/getAvailableTranscodingOptions":   CliPermissionRead,
+	"/currentRound":                     CliPermissionRead,
+	"/roundInitialized":                 CliPermissionRead,
+	"/unbondingLocks":                   CliPermissionRead,
+	"/delegatorInfo":                    CliPermissionRead,
+	"/orchestratorEarningPoolsForRound": CliPermissionRead,
+	"/streamID":                         CliPermissionRead,
+	"/manifestID":                       CliPermissionRead,
+	"/localStreams":                     CliPermissionRead,
+	"/recordings":                       CliPermissionRead,
+	"/status":                           CliPermissionRead,
+	"/contractAddresses":                CliPermissionRead,
+	"/orchestratorEventSubscriptions":   CliPermissionRead,
+	"/protocolParameters":               CliPermissionRead,
+	"/ethAddr":                          CliPermissionRead,
+	"/tokenBalance":                     CliPermissionRead,
+	"/ethBalance":                       CliPermissionRead,
+	"/registeredOrchestrators":          CliPermissionRead,
+	"/orchestratorInfo":                 CliPermissionRead,
+	"/IsOrchestrator":                   CliPermissionRead,
+	"/EthNetworkID":                     CliPermissionRead,
+	"/gasPrice":                         CliPermissionRead,
+	"/currentBlock":                     CliPermissionRead,
+	"/dbStats":                          CliPermissionRead,
+	"/senderInfo":                       CliPermissionRead,
+	"/ticketBrokerParams":               CliPermissionRead,
+	"/earnings":                         CliPermissionRead,
+	"/spend":                            CliPermissionRead,
+	"/payments":                         CliPermissionRead,
+	"/orchestratorPerformance":          CliPermissionRead,
+	"/streamMetrics":                    CliPermissionRead,
+	"/metrics":                          CliPermissionRead,
+
+	"/setBroadcastConfig": CliPermissionOperate,
+	"/setGasPrice":        CliPermissionOperate,
+	"/drain":              CliPermissionOperate,
+	"/orchestrators":      CliPermissionOperate,
+	"/drainTranscoder":    CliPermissionOperate,
+	"/transcoderRules":    CliPermissionOperate,
+	"/transcoderTags":     CliPermissionOperate,
+	"/ingestACL":          CliPermissionOperate,
+	"/auditLog":           CliPermissionOperate,
+	"/debug":              CliPermissionOperate,
+
+	// Sends a transaction, though it only changes the price
+	"/setOrchestratorConfig": CliPermissionFunds,
+	// Lets transcoders in, and takes any of them out
+	"/transcoderTokens": CliPermissionFunds,
+
+	// Require AdminToken themselves
+	"/debug/profiling": CliPermissionNone,
+	"/debug/pprof/":    CliPermissionNone,
+	"/debug/dump":      CliPermissionNone,
+}
+
+// cliEndpointPermission returns the permission required to call path
+func cliEndpointPermission(path string) CliPermission {
+	if p, ok := cliEndpointPermissions[path]; ok {
+		return p
+	}
+	if strings.HasPrefix(path, "/debug/pprof/") {
+		return CliPermissionNone
+	}
+	return CliPermissionFunds
+}
+
+// mustHaveCliPermission has the endpoints of h served over TCP only to the
+// tokens with the permission they require
+func mustHaveCliPermission(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required := cliEndpointPermission(r.URL.Path)
+		if len(CliTokens) == 0 {
+			if CliSocket != "" && required > CliPermissionRead {
+				respondWithError(w, fmt.Sprintf("%v requires the %v permission; call it over the CLI socket", r.URL.Path, required), http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		if required == CliPermissionNone {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		granted, ok := CliPermissionNone, false
+		if strings.HasPrefix(auth, "Bearer ") {
+			granted, ok = cliTokenPermission(strings.TrimPrefix(auth, "Bearer "))
+		}
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithError(w, "invalid CLI token", http.StatusUnauthorized)
+			return
+		}
+		if granted < required {
+			glog.Warningf("CLI request denied path=%s required=%v granted=%v remote=%s", r.URL.Path, required, granted, r.RemoteAddr)
+			respondWithError(w, fmt.Sprintf("%v requires the %v permission", r.URL.Path, required), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// cliTokenPermission returns the permission of token, comparing it to every
+// token so the time taken doesn't tell which it's close to
+func cliTokenPermission(token string) (CliPermission, bool) {
+	granted, found := CliPermissionNone, false
+	for t, p := range CliTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			granted, found = p, true
+		}
+	}
+	return granted, found
+}
+
+// listenCliSocket listens on the Unix socket at path, readable and writable
+// only by the node's user
+func listenCliSocket(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
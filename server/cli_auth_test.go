@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCliTokens(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tokens, err := ParseCliTokens("read:abc, operate:def,FUNDS:g:h,")
+	require.Nil(err)
+	assert.Equal(map[string]CliPermission{"abc": CliPermissionRead, "def": CliPermissionOperate, "g:h": CliPermissionFunds}, tokens)
+
+	tokens, err = ParseCliTokens("")
+	require.Nil(err)
+	assert.Empty(tokens)
+
+	_, err = ParseCliTokens("abc")
+	assert.EqualError(err, "invalid CLI token; must be permission:token")
+	_, err = ParseCliTokens("read:")
+	assert.NotNil(err)
+	_, err = ParseCliTokens("none:abc")
+	assert.Contains(err.Error(), "invalid CLI permission none")
+}
+
+func TestMustHaveCliPermission(t *testing.T) {
+	assert := assert.New(t)
+	defer func(tokens map[string]CliPermission, socket string) { CliTokens, CliSocket = tokens, socket }(CliTokens, CliSocket)
+
+	handler := mustHaveCliPermission(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := func(path, token string) int {
+		r := httptest.NewRequest("POST", "http://example.com"+path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Open without tokens
+	CliTokens, CliSocket = nil, ""
+	assert.Equal(http.StatusOK, req("/transferTokens", ""))
+
+	// Only reads over TCP with a socket
+	CliSocket = "/tmp/livepeer.sock"
+	assert.Equal(http.StatusOK, req("/status", ""))
+	assert.Equal(http.StatusForbidden, req("/setGasPrice", ""))
+	assert.Equal(http.StatusForbidden, req("/transferTokens", ""))
+
+	CliTokens = map[string]CliPermission{"r": CliPermissionRead, "o": CliPermissionOperate, "f": CliPermissionFunds}
+	assert.Equal(http.StatusOK, req("/healthz", ""))
+	assert.Equal(http.StatusUnauthorized, req("/status", ""))
+	assert.Equal(http.StatusUnauthorized, req("/status", "x"))
+	assert.Equal(http.StatusOK, req("/status", "r"))
+	assert.Equal(http.StatusForbidden, req("/setGasPrice", "r"))
+	assert.Equal(http.StatusOK, req("/setGasPrice", "o"))
+	assert.Equal(http.StatusForbidden, req("/transferTokens", "o"))
+	assert.Equal(http.StatusOK, req("/transferTokens", "f"))
+	// Endpoints that aren't listed need funds
+	assert.Equal(http.StatusForbidden, req("/newEndpoint", "o"))
+	assert.Equal(http.StatusOK, req("/newEndpoint", "f"))
+	// Setting the orchestrator's config sends a transaction
+	assert.Equal(http.StatusForbidden, req("/setOrchestratorConfig", "o"))
+	assert.Equal(http.StatusOK, req("/setOrchestratorConfig", "f"))
+	// Issuing transcoder tokens lets transcoders in
+	assert.Equal(http.StatusForbidden, req("/transcoderTokens", "o"))
+	assert.Equal(http.StatusOK, req("/transcoderTokens", "f"))
+
+	// Tokens must be given as bearer tokens
+	r := httptest.NewRequest("POST", "http://example.com/status", nil)
+	r.Header.Set("Authorization", "r")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusUnauthorized, w.Code)
+}
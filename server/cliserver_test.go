@@ -19,7 +19,7 @@ func TestGetStatus(t *testing.T) {
 	n.NodeType = core.TranscoderNode
 	n.TranscoderManager = core.NewRemoteTranscoderManager()
 	strm := &common.StubServerStream{}
-	go func() { n.TranscoderManager.Manage(strm, 5) }()
+	go func() { n.TranscoderManager.Manage(strm, core.RemoteTranscoderCapacity{Sessions: 5}) }()
 	time.Sleep(1 * time.Millisecond)
 	n.Transcoder = n.TranscoderManager
 	s := NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
@@ -34,6 +34,6 @@ func TestGetStatus(t *testing.T) {
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	req.Nil(err)
-	assert.Equal(`{"Manifests":{},"OrchestratorPool":[],"Version":"undefined","RegisteredTranscodersNumber":1,"RegisteredTranscoders":[{"Address":"TestAddress","Capacity":5}],"LocalTranscoding":false}`,
+	assert.Equal(`{"Manifests":{},"OrchestratorPool":[],"Version":"undefined","RegisteredTranscodersNumber":1,"RegisteredTranscoders":[{"ID":"","Address":"TestAddress","Capacity":5,"GPUs":0,"Codecs":null,"Load":0,"Draining":false,"LatencyMs":0,"Tags":null,"FailedChecks":0}],"LocalTranscoding":false}`,
 		string(body))
 }
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// FailoverID is the ID a broadcaster holds the streams it ingests under in
+// the DB it shares with other broadcasters, so the ingest of a stream can
+// fail over between them. The broadcaster taking over a stream keeps its
+// manifest ID, renditions, sequence numbers and orchestrators, so players
+// don't see a new stream. Off if empty.
+//
+// A broadcaster holds a stream for FailoverLease, renewing it while the stream
+// is ingested. Another can take over the stream once the lease lapses, or
+// once the broadcaster holding it ends it.
+var (
+	FailoverID    string
+	FailoverLease = 10 * time.Second
+)
+
+// getOrchestratorInfo is how sessions are resumed with orchestrators
+var getOrchestratorInfo = GetOrchestratorInfo
+
+// streamFailover keeps the state of a stream in the shared DB while the
+// broadcaster ingests it
+type streamFailover struct {
+	db *common.DB
+
+	// mu protects state
+	mu    sync.Mutex
+	state *common.DBStreamState
+	// resumed if the stream was taken over from another broadcaster, or was
+	// ingested before
+	resumed bool
+
+	// writeMu orders the writes of the state, none once released
+	writeMu  sync.Mutex
+	released bool
+	done     chan struct{}
+}
+
+// acquireStream holds the stream of params for the broadcaster. The stream
+// key of a stream that was ingested before replaces that of params, as do
+// its renditions unless the auth webhook gave them.
+func acquireStream(db *common.DB, params *streamParameters) (*streamFailover, error) {
+	state, err := db.AcquireStream(string(params.mid), FailoverID, FailoverLease)
+	if err != nil {
+		return nil, err
+	}
+	f := &streamFailover{db: db, state: state, done: make(chan struct{})}
+	if state.LastSeqNo >= 0 {
+		f.resumed = true
+		if state.Lapsed {
+			// The last segments ingested by the broadcaster whose lease
+			// lapsed may not have been written; don't reuse their seqNos
+			state.LastSeqNo += lapsedSeqSkip()
+		}
+		if state.StreamKey != "" {
+			params.rtmpKey = state.StreamKey
+		}
+		if profiles := parsePresets(strings.Split(state.Profiles, ",")); len(profiles) > 0 && !params.authProfiles {
+			params.profiles = profiles
+		}
+	}
+	names := make([]string, 0, len(params.profiles))
+	for _, p := range params.profiles {
+		names = append(names, p.Name)
+	}
+	state.StreamKey, state.Profiles = params.rtmpKey, strings.Join(names, ",")
+	return f, nil
+}
+
+// startSeq is the sequence number of the first segment ingested
+func (f *streamFailover) startSeq() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int(f.state.LastSeqNo + 1)
+}
+
+// orchestrators the stream had sessions with before
+func (f *streamFailover) orchestrators() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state.Orchestrators
+}
+
+// lapsedSeqSkip is how far ahead the seqNos of a stream taken over after a
+// lapsed lease are skipped: the segments that could have been ingested
+// since the last flush of the DB's buffered writes, at a second each
+func lapsedSeqSkip() int64 {
+	return int64(common.DBFlushInterval/time.Second) + 1
+}
+
+// segmentIngested writes seqNo as the last ingested, with the next flush of
+// the DB's writes, so that a broadcaster taking over the stream doesn't
+// reuse it
+func (f *streamFailover) segmentIngested(seqNo uint64) {
+	f.mu.Lock()
+	if int64(seqNo) <= f.state.LastSeqNo {
+		f.mu.Unlock()
+		return
+	}
+	f.state.LastSeqNo = int64(seqNo)
+	state := *f.state
+	f.mu.Unlock()
+
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	if f.released {
+		return
+	}
+	if err := f.db.StreamSegmentIngested(&state); err != nil {
+		glog.Errorf("Error writing seqNo=%d of stream manifestID=%s: %v", seqNo, state.ManifestID, err)
+	}
+}
+
+// snapshot copies the state to be written, with the orchestrators of bsm
+func (f *streamFailover) snapshot(bsm *BroadcastSessionsManager) *common.DBStreamState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if orchs := bsm.orchestrators(); len(orchs) > 0 {
+		f.state.Orchestrators = orchs
+	}
+	state := *f.state
+	return &state
+}
+
+// renew writes the state of the stream and renews its lease until it's
+// released. The ingest of cxn is stopped if another broadcaster took it over.
+func (f *streamFailover) renew(cxn *rtmpConnection) {
+	ticker := time.NewTicker(FailoverLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			f.writeMu.Lock()
+			if f.released {
+				f.writeMu.Unlock()
+				return
+			}
+			err := f.db.RenewStream(f.snapshot(cxn.sessManager), FailoverLease)
+			f.writeMu.Unlock()
+			if err == common.ErrStreamHeld {
+				glog.Errorf("Stream manifestID=%s was taken over by another broadcaster; stopping ingest", cxn.mid)
+				cxn.stream.Close()
+				return
+			}
+			if err != nil {
+				glog.Errorf("Error renewing stream manifestID=%s: %v", cxn.mid, err)
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// release writes the state of the stream as it ends, for any broadcaster to
+// take it over
+func (f *streamFailover) release(cxn *rtmpConnection) {
+	f.writeMu.Lock()
+	defer f.writeMu.Unlock()
+	if f.released {
+		return
+	}
+	f.released = true
+	close(f.done)
+	err := f.db.ReleaseStream(f.snapshot(cxn.sessManager))
+	if err != nil && err != common.ErrStreamHeld {
+		glog.Errorf("Error releasing stream manifestID=%s: %v", cxn.mid, err)
+	}
+}
+
+// resumeSessions sets up sessions of the stream with the orchestrators at
+// uris. Those that can't be reached are skipped.
+func resumeSessions(n *core.LivepeerNode, params *streamParameters, cpl core.PlaylistManager, uris []string) []*BroadcastSession {
+	rpcBcast := core.NewBroadcaster(n)
+	ctx, cancel := context.WithTimeout(context.Background(), GRPCConnectTimeout)
+	defer cancel()
+
+	sessions := make([]*BroadcastSession, len(uris))
+	var wg sync.WaitGroup
+	for i, uri := range uris {
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			glog.Errorf("Not resuming session of manifestID=%s with orchestrator=%s: %v", params.mid, uri, err)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			tinfo, err := getOrchestratorInfo(ctx, rpcBcast, u)
+			if err != nil {
+				glog.Errorf("Not resuming session of manifestID=%s with orchestrator=%s: %v", params.mid, u, err)
+				return
+			}
+			sessions[i] = newBroadcastSession(n, rpcBcast, params, cpl, tinfo)
+		}(i, u)
+	}
+	wg.Wait()
+
+	// Keep the order the orchestrators were used in
+	resumed := sessions[:0]
+	for _, sess := range sessions {
+		if sess != nil {
+			resumed = append(resumed, sess)
+		}
+	}
+	return resumed
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamFailover(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+
+	defer func(id string, get func(context.Context, Broadcaster, *url.URL) (*net.OrchestratorInfo, error)) {
+		FailoverID, getOrchestratorInfo = id, get
+	}(FailoverID, getOrchestratorInfo)
+	getOrchestratorInfo = func(ctx context.Context, b Broadcaster, uri *url.URL) (*net.OrchestratorInfo, error) {
+		if uri.Host == "127.0.0.1:8937" {
+			return nil, errors.New("unreachable")
+		}
+		return &net.OrchestratorInfo{Transcoder: uri.String()}, nil
+	}
+
+	newServer := func() *LivepeerServer {
+		n, err := core.NewLivepeerNode(nil, "./tmp", dbh)
+		require.Nil(err)
+		return NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	}
+	a, b := newServer(), newServer()
+	mid := core.ManifestID("failover")
+	newStream := func(key string, profiles ...ffmpeg.VideoProfile) stream.RTMPVideoStream {
+		return stream.NewBasicRTMPVideoStream(&streamParameters{mid: mid, rtmpKey: key, profiles: profiles})
+	}
+
+	// A new stream starts from the first segment
+	FailoverID = "a"
+	strmA := newStream("keyA", ffmpeg.P360p30fps16x9, ffmpeg.P240p30fps16x9)
+	cxnA, err := a.registerConnection(strmA)
+	require.Nil(err)
+	require.NotNil(cxnA.failover)
+	assert.False(cxnA.failover.resumed)
+	assert.Equal(0, cxnA.failover.startSeq())
+
+	// and can't be taken by another broadcaster while held
+	FailoverID = "b"
+	_, err = b.registerConnection(newStream("keyB", ffmpeg.P144p30fps16x9))
+	assert.Equal(errAlreadyExists, err)
+
+	cxnA.failover.segmentIngested(7)
+	cxnA.failover.segmentIngested(6)
+	cxnA.sessManager.addSessions([]*BroadcastSession{
+		{OrchestratorInfo: &net.OrchestratorInfo{Transcoder: "https://127.0.0.1:8936"}},
+		{OrchestratorInfo: &net.OrchestratorInfo{Transcoder: "https://127.0.0.1:8937"}},
+	})
+	require.Nil(endRTMPStreamHandler(a)(nil, strmA))
+
+	// Once released, another broadcaster carries on where it was left
+	// with the key and renditions players know the stream by
+	strmB := newStream("keyB", ffmpeg.P144p30fps16x9)
+	cxnB, err := b.registerConnection(strmB)
+	require.Nil(err)
+	require.NotNil(cxnB.failover)
+	assert.True(cxnB.failover.resumed)
+	assert.Equal(8, cxnB.failover.startSeq())
+	assert.Equal("keyA", cxnB.params.rtmpKey)
+	assert.Equal([]ffmpeg.VideoProfile{ffmpeg.P360p30fps16x9, ffmpeg.P240p30fps16x9}, cxnB.params.profiles)
+	assert.ElementsMatch([]string{"https://127.0.0.1:8936", "https://127.0.0.1:8937"}, cxnB.failover.orchestrators())
+	// with the sessions of the orchestrators that can still be reached
+	assert.Equal([]string{"https://127.0.0.1:8936"}, cxnB.sessManager.orchestrators())
+	sess := cxnB.sessManager.selectSession()
+	require.NotNil(sess)
+	assert.Equal("https://127.0.0.1:8936", sess.OrchestratorInfo.Transcoder)
+
+	// Its state is written as the lease is renewed
+	cxnB.failover.segmentIngested(9)
+	require.Nil(dbh.RenewStream(cxnB.failover.snapshot(cxnB.sessManager), FailoverLease))
+	state, err := dbh.StreamState(string(mid))
+	require.Nil(err)
+	assert.Equal("b", state.Owner)
+	assert.Equal(int64(9), state.LastSeqNo)
+	require.Nil(endRTMPStreamHandler(b)(nil, strmB))
+}
+
+func TestStreamFailover_LeaseLapsed(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+	defer func(id string) { FailoverID = id }(FailoverID)
+
+	newServer := func() *LivepeerServer {
+		n, err := core.NewLivepeerNode(nil, "./tmp", dbh)
+		require.Nil(err)
+		return NewLivepeerServer("127.0.0.1:1938", "127.0.0.1:8080", n)
+	}
+	a, b := newServer(), newServer()
+	mid := core.ManifestID("lapsed")
+
+	FailoverID = "a"
+	strmA := stream.NewBasicRTMPVideoStream(&streamParameters{mid: mid, rtmpKey: "keyA", profiles: []ffmpeg.VideoProfile{ffmpeg.P360p30fps16x9}})
+	cxnA, err := a.registerConnection(strmA)
+	require.Nil(err)
+	defer endRTMPStreamHandler(a)(nil, strmA)
+
+	// Each segment ingested is written, without waiting for the lease to be
+	// renewed
+	cxnA.failover.segmentIngested(3)
+	state, err := dbh.StreamState(string(mid))
+	require.Nil(err)
+	assert.Equal(int64(3), state.LastSeqNo)
+	assert.False(state.Lapsed)
+	cxnA.failover.segmentIngested(4)
+
+	// The broadcaster crashes, and its lease lapses
+	_, err = dbraw.Exec("UPDATE streamState SET leaseExpiresAt = 1 WHERE manifestID = ?", string(mid))
+	require.Nil(err)
+
+	// The broadcaster taking over skips the seqNos that may not have been
+	// written, and keeps the renditions the auth webhook gave it
+	FailoverID = "b"
+	strmB := stream.NewBasicRTMPVideoStream(&streamParameters{mid: mid, rtmpKey: "keyB", profiles: []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}, authProfiles: true})
+	cxnB, err := b.registerConnection(strmB)
+	require.Nil(err)
+	defer endRTMPStreamHandler(b)(nil, strmB)
+	assert.True(cxnB.failover.resumed)
+	assert.Equal(int(5+lapsedSeqSkip()), cxnB.failover.startSeq())
+	assert.Equal("keyA", cxnB.params.rtmpKey)
+	assert.Equal([]ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}, cxnB.params.profiles)
+}
@@ -1,13 +1,17 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"math/big"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/eth"
 	"github.com/livepeer/go-livepeer/pm"
 )
@@ -43,6 +47,44 @@ func mustHaveFormParams(h http.Handler, params ...string) http.Handler {
 	})
 }
 
+// AdminToken must be presented as a bearer token to the admin endpoints of the
+// CLI webserver; they're disabled if empty
+var AdminToken string
+
+func mustHaveAdminToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if AdminToken == "" {
+			respondWithError(w, "admin endpoints disabled", http.StatusForbidden)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithError(w, "invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Request IDs are passed to remote transcoders and over gRPC with this key
+const requestIDCarrierKey = "x-request-id"
+
+// withRequestID identifies each request by the request ID it came with, or a
+// new one, which is returned in the response headers and set in the
+// request's context for the handler to log and pass on
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(common.RequestIDHeader)
+		if !common.ValidRequestID(id) {
+			id = common.NewRequestID()
+		}
+		w.Header().Set(common.RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(common.WithRequestID(r.Context(), id)))
+	})
+}
+
 // BlockGetter is an interface which describes an object capable
 // of getting blocks
 type BlockGetter interface {
@@ -271,3 +313,291 @@ func ticketBrokerParamsHandler(client eth.LivepeerEthClient) http.Handler {
 		w.Write(data)
 	})
 }
+
+// paymentsWindow returns the time window given in the `window` query param,
+// e.g. 12h or 7d, ending now. Defaults to the last 24 hours.
+func paymentsWindow(r *http.Request) (time.Time, time.Time, error) {
+	to := time.Now()
+	window := 24 * time.Hour
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		if strings.HasSuffix(v, "d") {
+			var days int
+			days, err = strconv.Atoi(strings.TrimSuffix(v, "d"))
+			window = time.Duration(days) * 24 * time.Hour
+		} else {
+			window, err = time.ParseDuration(v)
+		}
+		if err != nil || window <= 0 {
+			return to, to, fmt.Errorf("invalid window: %v", v)
+		}
+	}
+	return to.Add(-window), to, nil
+}
+
+type paymentsTotal struct {
+	Tickets int
+	Amount  *big.Int
+}
+
+func (pt *paymentsTotal) add(amount *big.Int) {
+	pt.Tickets++
+	pt.Amount.Add(pt.Amount, amount)
+}
+
+func newPaymentsTotal() *paymentsTotal {
+	return &paymentsTotal{Amount: big.NewInt(0)}
+}
+
+func respondWithJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		respondWith500(w, fmt.Sprintf("could not encode response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// earningsHandler summarizes the tickets received, the fees won and the
+// rewards minted by an orchestrator over a time window. Ticket amounts are
+// expected values in wei; fees are face values of winning tickets.
+func earningsHandler(db *common.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		from, to, err := paymentsWindow(r)
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		payments, err := db.Payments(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query payments: %v", err))
+			return
+		}
+
+		received, fees, reward := newPaymentsTotal(), newPaymentsTotal(), big.NewInt(0)
+		bySender := make(map[string]*paymentsTotal)
+		for _, p := range payments {
+			switch p.Kind {
+			case common.PaymentTicketReceived:
+				received.add(p.Amount)
+				if bySender[p.Counterparty] == nil {
+					bySender[p.Counterparty] = newPaymentsTotal()
+				}
+				bySender[p.Counterparty].add(p.Amount)
+			case common.PaymentTicketWon:
+				fees.add(p.Amount)
+			case common.PaymentReward:
+				reward.Add(reward, p.Amount)
+			}
+		}
+
+		respondWithJSON(w, struct {
+			From, To        time.Time
+			TicketsReceived *paymentsTotal
+			WinningTickets  *paymentsTotal
+			Reward          *big.Int
+			BySender        map[string]*paymentsTotal
+		}{from, to, received, fees, reward, bySender})
+	})
+}
+
+// spendHandler summarizes the expected value of the tickets a broadcaster
+// sent over a time window, per stream and per orchestrator, along with the
+// remaining deposit and reserve
+func spendHandler(db *common.DB, client eth.LivepeerEthClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		from, to, err := paymentsWindow(r)
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		payments, err := db.Payments(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query payments: %v", err))
+			return
+		}
+
+		sent := newPaymentsTotal()
+		byStream := make(map[string]*paymentsTotal)
+		byOrch := make(map[string]*paymentsTotal)
+		for _, p := range payments {
+			if p.Kind != common.PaymentTicketSent {
+				continue
+			}
+			sent.add(p.Amount)
+			if byStream[p.ManifestID] == nil {
+				byStream[p.ManifestID] = newPaymentsTotal()
+			}
+			byStream[p.ManifestID].add(p.Amount)
+			if byOrch[p.Counterparty] == nil {
+				byOrch[p.Counterparty] = newPaymentsTotal()
+			}
+			byOrch[p.Counterparty].add(p.Amount)
+		}
+
+		deposit, reserve, err := senderFunds(client)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query sender info: %v", err))
+			return
+		}
+
+		respondWithJSON(w, struct {
+			From, To       time.Time
+			TicketsSent    *paymentsTotal
+			Deposit        *big.Int
+			Reserve        *big.Int
+			ByStream       map[string]*paymentsTotal
+			ByOrchestrator map[string]*paymentsTotal
+		}{from, to, sent, deposit, reserve, byStream, byOrch})
+	})
+}
+
+// senderFunds returns the deposit and reserve of the node's account, or nil
+// if offchain
+func senderFunds(client eth.LivepeerEthClient) (deposit, reserve *big.Int, err error) {
+	if client == nil {
+		return nil, nil, nil
+	}
+	info, err := client.GetSenderInfo(client.Account().Address)
+	if err != nil && err.Error() != "ErrNoResult" {
+		return nil, nil, err
+	}
+	if info != nil {
+		deposit, reserve = info.Deposit, info.Reserve
+	}
+	return deposit, reserve, nil
+}
+
+type pendingTicket struct {
+	CreatedAt time.Time
+	Sender    string
+	FaceValue *big.Int
+	SessionID string
+}
+
+// paymentsHandler reports the state of the node's payments: the deposit
+// and reserve of its account, the winning tickets it received that are
+// waiting to be redeemed, the tickets it redeemed and the expected value of
+// the tickets it sent per orchestrator over a time window
+func paymentsHandler(db *common.DB, client eth.LivepeerEthClient) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		from, to, err := paymentsWindow(r)
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+
+		deposit, reserve, err := senderFunds(client)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query sender info: %v", err))
+			return
+		}
+
+		tickets, err := db.PendingWinningTickets()
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query winning tickets: %v", err))
+			return
+		}
+		pendingTotal := newPaymentsTotal()
+		pending := make([]*pendingTicket, 0, len(tickets))
+		for _, t := range tickets {
+			pendingTotal.add(t.Ticket.FaceValue)
+			pending = append(pending, &pendingTicket{
+				CreatedAt: t.CreatedAt,
+				Sender:    t.Ticket.Sender.Hex(),
+				FaceValue: t.Ticket.FaceValue,
+				SessionID: t.SessionID,
+			})
+		}
+
+		redemptions, err := db.Redemptions(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query redemptions: %v", err))
+			return
+		}
+		redeemedTotal := newPaymentsTotal()
+		for _, rd := range redemptions {
+			redeemedTotal.add(rd.FaceValue)
+		}
+
+		payments, err := db.Payments(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query payments: %v", err))
+			return
+		}
+		byOrch := make(map[string]*paymentsTotal)
+		for _, p := range payments {
+			if p.Kind != common.PaymentTicketSent {
+				continue
+			}
+			if byOrch[p.Counterparty] == nil {
+				byOrch[p.Counterparty] = newPaymentsTotal()
+			}
+			byOrch[p.Counterparty].add(p.Amount)
+		}
+
+		respondWithJSON(w, struct {
+			From, To            time.Time
+			Deposit             *big.Int
+			Reserve             *big.Int
+			PendingTickets      *paymentsTotal
+			Pending             []*pendingTicket
+			RedeemedTickets     *paymentsTotal
+			Redemptions         []*common.DBRedemption
+			SpendByOrchestrator map[string]*paymentsTotal
+		}{from, to, deposit, reserve, pendingTotal, pending, redeemedTotal, redemptions, byOrch})
+	})
+}
+
+// dbStatsHandler reports the size of the DB, the rows of its tables, and the
+// latency and lock contention of the node's writes to it
+func dbStatsHandler(db *common.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		stats, err := db.Stats()
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query DB stats: %v", err))
+			return
+		}
+		respondWithJSON(w, stats)
+	})
+}
+
+// orchestratorPerformanceHandler reports the daily performance of the
+// orchestrators segments were sent to over the window
+func orchestratorPerformanceHandler(db *common.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if db == nil {
+			respondWith500(w, "missing DB")
+			return
+		}
+		from, to, err := paymentsWindow(r)
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		perfs, err := db.OrchestratorPerformance(from, to)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not query orchestrator performance: %v", err))
+			return
+		}
+		respondWithJSON(w, perfs)
+	})
+}
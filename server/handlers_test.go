@@ -11,9 +11,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts"
 	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/eth"
 	"github.com/livepeer/go-livepeer/pm"
 	"github.com/stretchr/testify/assert"
@@ -612,6 +614,181 @@ func TestTicketBrokerParamsHandler_Success(t *testing.T) {
 	assert.Equal(unlockPeriod, params.UnlockPeriod)
 }
 
+func TestEarningsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	resp := httpGetResp(earningsHandler(nil))
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	handler := earningsHandler(dbh)
+
+	require.Nil(dbh.InsertPayment(common.PaymentTicketReceived, "mid", "0x1", big.NewInt(10)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketReceived, "mid", "0x1", big.NewInt(20)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketReceived, "mid", "0x2", big.NewInt(5)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketWon, "mid", "0x1", big.NewInt(1000)))
+	require.Nil(dbh.InsertPayment(common.PaymentReward, "", "", big.NewInt(7)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid", "0x3", big.NewInt(1)))
+
+	resp = httpGetResp(handler)
+	require.Equal(http.StatusOK, resp.StatusCode)
+	var earnings struct {
+		TicketsReceived paymentsTotal
+		WinningTickets  paymentsTotal
+		Reward          *big.Int
+		BySender        map[string]paymentsTotal
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Nil(json.Unmarshal(body, &earnings))
+	assert.Equal(3, earnings.TicketsReceived.Tickets)
+	assert.Equal(big.NewInt(35), earnings.TicketsReceived.Amount)
+	assert.Equal(1, earnings.WinningTickets.Tickets)
+	assert.Equal(big.NewInt(1000), earnings.WinningTickets.Amount)
+	assert.Equal(big.NewInt(7), earnings.Reward)
+	assert.Len(earnings.BySender, 2)
+	assert.Equal(big.NewInt(30), earnings.BySender["0x1"].Amount)
+
+	// Payments older than the window are left out
+	_, err = dbraw.Exec("UPDATE payments SET createdAt = '2000-01-01 00:00:00' WHERE counterparty = '0x2' OR kind = 'reward'")
+	require.Nil(err)
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/earnings"+query, nil))
+		return w
+	}
+	w := get("?window=1d")
+	require.Equal(http.StatusOK, w.Code)
+	earnings.BySender = nil
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &earnings))
+	assert.Equal(2, earnings.TicketsReceived.Tickets)
+	assert.Equal(big.NewInt(30), earnings.TicketsReceived.Amount)
+	assert.Equal(1, earnings.WinningTickets.Tickets)
+	assert.Zero(earnings.Reward.Sign())
+	assert.Len(earnings.BySender, 1)
+	assert.Equal(2, earnings.BySender["0x1"].Tickets)
+
+	assert.Equal(http.StatusBadRequest, get("?window=foo").Code)
+	assert.Equal(http.StatusBadRequest, get("?window=-1h").Code)
+}
+
+func TestSpendHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid1", "0x1", big.NewInt(10)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid1", "0x2", big.NewInt(20)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid2", "0x2", big.NewInt(5)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketReceived, "mid3", "0x3", big.NewInt(1)))
+
+	var spend struct {
+		TicketsSent    paymentsTotal
+		Deposit        *big.Int
+		ByStream       map[string]paymentsTotal
+		ByOrchestrator map[string]paymentsTotal
+	}
+
+	// Offchain
+	resp := httpGetResp(spendHandler(dbh, nil))
+	require.Equal(http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Nil(json.Unmarshal(body, &spend))
+	assert.Equal(3, spend.TicketsSent.Tickets)
+	assert.Equal(big.NewInt(35), spend.TicketsSent.Amount)
+	assert.Nil(spend.Deposit)
+	assert.Equal(big.NewInt(30), spend.ByStream["mid1"].Amount)
+	assert.Equal(2, spend.ByStream["mid1"].Tickets)
+	assert.Equal(big.NewInt(25), spend.ByOrchestrator["0x2"].Amount)
+
+	client := &eth.MockClient{}
+	addr := ethcommon.Address{}
+	client.On("Account").Return(accounts.Account{Address: addr})
+	client.On("GetSenderInfo", addr).Return(&pm.SenderInfo{Deposit: big.NewInt(100), Reserve: big.NewInt(50)}, nil)
+	resp = httpGetResp(spendHandler(dbh, client))
+	require.Equal(http.StatusOK, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	require.Nil(json.Unmarshal(body, &spend))
+	assert.Equal(big.NewInt(100), spend.Deposit)
+	assert.Equal(3, spend.TicketsSent.Tickets)
+	assert.Equal(big.NewInt(35), spend.TicketsSent.Amount)
+
+	// Payments older than the window are left out
+	_, err = dbraw.Exec("UPDATE payments SET createdAt = '2000-01-01 00:00:00' WHERE manifestID = 'mid2'")
+	require.Nil(err)
+	w := httptest.NewRecorder()
+	spendHandler(dbh, nil).ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/spend?window=12h", nil))
+	require.Equal(http.StatusOK, w.Code)
+	spend.ByStream, spend.ByOrchestrator = nil, nil
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &spend))
+	assert.Equal(2, spend.TicketsSent.Tickets)
+	assert.Equal(big.NewInt(30), spend.TicketsSent.Amount)
+	assert.Len(spend.ByStream, 1)
+	assert.Equal(big.NewInt(20), spend.ByOrchestrator["0x2"].Amount)
+	assert.Equal(1, spend.ByOrchestrator["0x2"].Tickets)
+}
+
+func TestPaymentsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	resp := httpGetResp(paymentsHandler(nil, nil))
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	sender := pm.RandAddress()
+	pending := &pm.Ticket{Sender: sender, Recipient: pm.RandAddress(), FaceValue: big.NewInt(1000), WinProb: big.NewInt(1), RecipientRandHash: pm.RandHash()}
+	require.Nil(dbh.StoreWinningTicket("session1", pending, []byte("sig1"), big.NewInt(1)))
+	redeemed := &pm.Ticket{Sender: sender, Recipient: pm.RandAddress(), FaceValue: big.NewInt(3000), WinProb: big.NewInt(1), RecipientRandHash: pm.RandHash()}
+	require.Nil(dbh.StoreWinningTicket("session2", redeemed, []byte("sig2"), big.NewInt(2)))
+	require.Nil(dbh.MarkWinningTicketRedeemed([]byte("sig2"), pm.RandHash()))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid1", "0x1", big.NewInt(10)))
+	require.Nil(dbh.InsertPayment(common.PaymentTicketSent, "mid2", "0x1", big.NewInt(20)))
+
+	client := &eth.MockClient{}
+	addr := ethcommon.Address{}
+	client.On("Account").Return(accounts.Account{Address: addr})
+	client.On("GetSenderInfo", addr).Return(&pm.SenderInfo{Deposit: big.NewInt(100), Reserve: big.NewInt(50)}, nil)
+	resp = httpGetResp(paymentsHandler(dbh, client))
+	require.Equal(http.StatusOK, resp.StatusCode)
+
+	var payments struct {
+		Deposit             *big.Int
+		Reserve             *big.Int
+		PendingTickets      paymentsTotal
+		Pending             []pendingTicket
+		RedeemedTickets     paymentsTotal
+		Redemptions         []common.DBRedemption
+		SpendByOrchestrator map[string]paymentsTotal
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Nil(json.Unmarshal(body, &payments))
+	assert.Equal(big.NewInt(100), payments.Deposit)
+	assert.Equal(big.NewInt(50), payments.Reserve)
+	assert.Equal(1, payments.PendingTickets.Tickets)
+	assert.Equal(big.NewInt(1000), payments.PendingTickets.Amount)
+	require.Len(payments.Pending, 1)
+	assert.Equal(sender.Hex(), payments.Pending[0].Sender)
+	assert.Equal("session1", payments.Pending[0].SessionID)
+	assert.Equal(1, payments.RedeemedTickets.Tickets)
+	require.Len(payments.Redemptions, 1)
+	assert.Equal(big.NewInt(3000), payments.Redemptions[0].FaceValue)
+	assert.Equal(sender.Hex(), payments.Redemptions[0].Sender)
+	assert.Equal(2, payments.SpendByOrchestrator["0x1"].Tickets)
+	assert.Equal(big.NewInt(30), payments.SpendByOrchestrator["0x1"].Amount)
+}
+
 func httpPostFormResp(handler http.Handler, body io.Reader) *http.Response {
 	headers := map[string]string{
 		"Content-Type": "application/x-www-form-urlencoded",
@@ -642,3 +819,85 @@ func httpResp(handler http.Handler, method string, body io.Reader, headers map[s
 
 	return w.Result()
 }
+
+func TestWithRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	var got string
+	handler := withRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = common.RequestID(r.Context())
+	}))
+
+	// Generated if missing
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com", nil))
+	assert.Len(got, 16)
+	assert.Equal(got, w.Header().Get(common.RequestIDHeader))
+
+	// Passed on if valid
+	for _, id := range []string{"abc", "a b"} {
+		req := httptest.NewRequest("GET", "http://example.com", nil)
+		req.Header.Set(common.RequestIDHeader, id)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(got, w.Header().Get(common.RequestIDHeader))
+		if id == "abc" {
+			assert.Equal(id, got)
+		} else {
+			assert.NotEqual(id, got)
+		}
+	}
+}
+
+func TestDBStatsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	resp := httpGetResp(dbStatsHandler(nil))
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	require.Nil(dbh.InsertPayment(common.PaymentReward, "", "", big.NewInt(7)))
+
+	resp = httpGetResp(dbStatsHandler(dbh))
+	require.Equal(http.StatusOK, resp.StatusCode)
+	var stats common.DBStats
+	body, _ := ioutil.ReadAll(resp.Body)
+	require.Nil(json.Unmarshal(body, &stats))
+	assert.True(stats.SizeBytes > 0)
+	assert.Equal(int64(1), stats.Rows["payments"])
+	assert.Equal(int64(1), stats.Writes.Count)
+}
+
+func TestOrchestratorPerformanceHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	resp := httpGetResp(orchestratorPerformanceHandler(nil))
+	assert.Equal(http.StatusInternalServerError, resp.StatusCode)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	handler := orchestratorPerformanceHandler(dbh)
+	require.Nil(dbh.RecordOrchestratorSegment("https://127.0.0.1:8936", 100*time.Millisecond, true, big.NewInt(10)))
+
+	req := httptest.NewRequest("GET", "http://example.com/orchestratorPerformance?window=7d", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	require.Equal(http.StatusOK, w.Code)
+	var perfs []common.DBOrchPerformance
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &perfs))
+	require.Len(perfs, 1)
+	assert.Equal("https://127.0.0.1:8936", perfs[0].Orchestrator)
+	assert.Equal(int64(1), perfs[0].Segments)
+
+	req = httptest.NewRequest("GET", "http://example.com/orchestratorPerformance?window=foo", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
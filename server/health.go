@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	gonet "net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+)
+
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+)
+
+// HealthCheckTimeout bounds the time all subsystem checks of a request can take
+var HealthCheckTimeout = 5 * time.Second
+
+// MaxBlockLag how many blocks the block watcher may be behind the eth node
+// before the node is reported not ready
+var MaxBlockLag = big.NewInt(20)
+
+type subsystemHealth struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Info   interface{} `json:"info,omitempty"`
+}
+
+type healthReport struct {
+	Status     string                      `json:"status"`
+	Subsystems map[string]*subsystemHealth `json:"subsystems"`
+}
+
+func newSubsystemHealth(info interface{}, err error) *subsystemHealth {
+	if err != nil {
+		return &subsystemHealth{Status: healthFailing, Error: err.Error(), Info: info}
+	}
+	return &subsystemHealth{Status: healthOK, Info: info}
+}
+
+// healthHandler reports the health of each subsystem of the node as JSON.
+// Only subsystems the node uses are reported. /healthz always responds with
+// 200 while the node is up; /readyz (readiness set) responds with 503 if
+// any subsystem is failing, so load balancers stop sending work. As anyone
+// can call /readyz, it responds with the overall status only, and the
+// errors of failing subsystems are logged instead.
+func (s *LivepeerServer) healthHandler(readiness bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+		defer cancel()
+		report := s.checkHealth(ctx)
+
+		status := http.StatusOK
+		var resp interface{} = report
+		if readiness {
+			if report.Status != healthOK {
+				status = http.StatusServiceUnavailable
+			}
+			logReadiness(report)
+			resp = map[string]string{"status": report.Status}
+		}
+		data, err := json.Marshal(resp)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(status)
+		w.Write(data)
+	})
+}
+
+var lastReadinessLock sync.Mutex
+var lastReadiness string
+
+// logReadiness logs the errors of the failing subsystems of report when
+// they change, as load balancers check readiness every few seconds
+func logReadiness(report *healthReport) {
+	var failures []string
+	for name, sub := range report.Subsystems {
+		if sub.Status != healthOK {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, sub.Error))
+		}
+	}
+	sort.Strings(failures)
+	msg := strings.Join(failures, "; ")
+
+	lastReadinessLock.Lock()
+	defer lastReadinessLock.Unlock()
+	if msg == lastReadiness {
+		return
+	}
+	lastReadiness = msg
+	if msg == "" {
+		glog.Info("Node is ready")
+	} else {
+		glog.Errorf("Node is not ready: %s", msg)
+	}
+}
+
+func (s *LivepeerServer) checkHealth(ctx context.Context) *healthReport {
+	n := s.LivepeerNode
+	report := &healthReport{Status: healthOK, Subsystems: make(map[string]*subsystemHealth)}
+
+	if n.Eth != nil {
+		head, err := checkEthRPC(ctx, n)
+		report.Subsystems["eth"] = newSubsystemHealth(map[string]interface{}{"block": head}, err)
+		if n.Database != nil {
+			report.Subsystems["blockWatcher"] = checkBlockWatcher(n, head)
+		}
+	}
+
+	if drivers.NodeStorage != nil {
+		report.Subsystems["storage"] = checkStorage()
+	}
+
+	if hw, ok := n.Transcoder.(*core.HardwareTranscoder); ok {
+		report.Subsystems["gpu"] = newSubsystemHealth(map[string]interface{}{"accel": hw.Accel(), "devices": hw.Devices()}, hw.CheckDevices())
+	}
+
+	if n.TranscoderManager != nil {
+		var err error
+		count := n.TranscoderManager.RegisteredTranscodersCount()
+		if count == 0 {
+			err = errors.New("no transcoders connected")
+		}
+		report.Subsystems["transcoders"] = newSubsystemHealth(map[string]interface{}{"connected": count}, err)
+	}
+
+	if AuthWebhookURL != "" {
+		report.Subsystems["webhook"] = newSubsystemHealth(nil, checkWebhook(ctx, AuthWebhookURL))
+	}
+
+	if shutdown.isDraining() {
+		report.Subsystems["shutdown"] = newSubsystemHealth(nil, errShuttingDown)
+	}
+
+	for _, sub := range report.Subsystems {
+		if sub.Status != healthOK {
+			report.Status = healthFailing
+		}
+	}
+	return report
+}
+
+// checkEthRPC returns the latest block of the eth node
+func checkEthRPC(ctx context.Context, n *core.LivepeerNode) (*big.Int, error) {
+	backend, err := n.Eth.Backend()
+	if err != nil {
+		return nil, err
+	}
+	header, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return header.Number, nil
+}
+
+func checkBlockWatcher(n *core.LivepeerNode, head *big.Int) *subsystemHealth {
+	last, err := n.Database.LastSeenBlock()
+	info := map[string]interface{}{"lastSeenBlock": last}
+	if err != nil {
+		return newSubsystemHealth(info, err)
+	}
+	if last == nil {
+		return newSubsystemHealth(info, errors.New("no blocks seen yet"))
+	}
+	if head != nil {
+		lag := new(big.Int).Sub(head, last)
+		info["behind"] = lag
+		if lag.Cmp(MaxBlockLag) > 0 {
+			return newSubsystemHealth(info, fmt.Errorf("block watcher is %v blocks behind", lag))
+		}
+	}
+	return newSubsystemHealth(info, nil)
+}
+
+// checkStorage reports the object stores whose latest upload failed
+func checkStorage() *subsystemHealth {
+	statuses := drivers.StorageStatuses()
+	var err error
+	for _, st := range statuses {
+		if st.Failing() {
+			err = fmt.Errorf("uploads to %s %s failing: %s", st.Driver, st.Bucket, st.Error)
+			break
+		}
+	}
+	return newSubsystemHealth(statuses, err)
+}
+
+// checkWebhook returns an error if the webhook host can't be connected to.
+// Calling the webhook itself would authenticate a stream.
+func checkWebhook(ctx context.Context, webhook string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "https" {
+			addr = gonet.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = gonet.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	var d gonet.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldStorage, oldWebhook := drivers.NodeStorage, AuthWebhookURL
+	defer func() { drivers.NodeStorage, AuthWebhookURL = oldStorage, oldWebhook }()
+	drivers.NodeStorage, AuthWebhookURL = nil, ""
+
+	n, _ := core.NewLivepeerNode(nil, "", nil)
+	s := &LivepeerServer{LivepeerNode: n}
+
+	get := func(h http.Handler) (int, *healthReport) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		var report healthReport
+		require.Nil(json.Unmarshal(rr.Body.Bytes(), &report))
+		return rr.Code, &report
+	}
+
+	// Nothing to check
+	code, report := get(s.healthHandler(true))
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(healthOK, report.Status)
+	assert.Empty(report.Subsystems)
+
+	// Orchestrator waiting for remote transcoders
+	n.TranscoderManager = core.NewRemoteTranscoderManager()
+	code, report = get(s.healthHandler(true))
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Equal(healthFailing, report.Status)
+	// Readiness is reported without the details
+	assert.Empty(report.Subsystems)
+	assert.Equal("transcoders: no transcoders connected", lastReadiness)
+
+	// Liveness isn't affected
+	code, report = get(s.healthHandler(false))
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(healthFailing, report.Status)
+	require.NotNil(report.Subsystems["transcoders"])
+	assert.Equal(healthFailing, report.Subsystems["transcoders"].Status)
+	assert.Equal("no transcoders connected", report.Subsystems["transcoders"].Error)
+
+	// Unreachable webhook
+	n.TranscoderManager = nil
+	AuthWebhookURL = "http://127.0.0.1:1/auth"
+	code, report = get(s.healthHandler(true))
+	assert.Equal(http.StatusServiceUnavailable, code)
+	assert.Empty(report.Subsystems)
+	assert.Contains(lastReadiness, "webhook: ")
+	code, report = get(s.healthHandler(false))
+	require.NotNil(report.Subsystems["webhook"])
+	assert.Equal(healthFailing, report.Subsystems["webhook"].Status)
+	assert.NotEmpty(report.Subsystems["webhook"].Error)
+
+	AuthWebhookURL = ""
+	code, _ = get(s.healthHandler(true))
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(lastReadiness)
+}
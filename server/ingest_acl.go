@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+)
+
+// IngestFilter has the RTMP connections of a broadcaster accepted only from
+// the addresses IngestACL allows, starting with IngestAllow and IngestDeny.
+// lpms doesn't tell the addresses streams come from, so its RTMP server
+// listens on loopback behind a filter listening on the RTMP address.
+var (
+	IngestFilter bool
+	IngestAllow  []*net.IPNet
+	IngestDeny   []*net.IPNet
+)
+
+// IngestACL are the ranges of the addresses that may connect to ingest
+// streams: an address is refused if it's in a denied range, and allowed if
+// it's in an allowed range or there are none
+type IngestACL struct {
+	mu          sync.RWMutex
+	allow, deny []*net.IPNet
+	// conns are the connections relayed, to close those whose address is no
+	// longer allowed
+	conns map[net.Conn]net.IP
+}
+
+// NewIngestACL returns an ACL of the allow and deny ranges
+func NewIngestACL(allow, deny []*net.IPNet) *IngestACL {
+	return &IngestACL{allow: allow, deny: deny, conns: make(map[net.Conn]net.IP)}
+}
+
+// Set replaces the ranges of the ACL, closing the connections of the
+// addresses no longer allowed
+func (a *IngestACL) Set(allow, deny []*net.IPNet) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.allow, a.deny = allow, deny
+	for conn, ip := range a.conns {
+		if !a.allowedLocked(ip) {
+			glog.Infof("Closing ingest connection no longer allowed remote=%s", conn.RemoteAddr())
+			conn.Close()
+			delete(a.conns, conn)
+		}
+	}
+}
+
+// Ranges returns the allowed and denied ranges, in CIDR notation
+func (a *IngestACL) Ranges() (allow, deny []string) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	allow, deny = []string{}, []string{}
+	for _, n := range a.allow {
+		allow = append(allow, n.String())
+	}
+	for _, n := range a.deny {
+		deny = append(deny, n.String())
+	}
+	return allow, deny
+}
+
+func (a *IngestACL) nets() (allow, deny []*net.IPNet) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.allow, a.deny
+}
+
+// Allowed is whether ip may connect
+func (a *IngestACL) Allowed(ip net.IP) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.allowedLocked(ip)
+}
+
+func (a *IngestACL) allowedLocked(ip net.IP) bool {
+	for _, n := range a.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, n := range a.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCIDRs parses comma-separated CIDR ranges; an IP address is a range of
+// its own
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		if !strings.Contains(r, "/") {
+			ip := net.ParseIP(r)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %v", r)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %v", r)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// freeLoopbackAddr returns a loopback address with a port that's free, for
+// the RTMP server to listen on behind the filter
+func freeLoopbackAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// serveIngestFilter accepts the connections on addr from the addresses acl
+// allows, and relays them to the RTMP server at target
+func serveIngestFilter(addr, target string, acl *IngestACL) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	glog.Infof("Ingest filter listening on %s for RTMP server on %s", addr, target)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go relayIngest(conn, target, acl)
+	}
+}
+
+// track adds conn from ip to the connections relayed, if ip is allowed
+func (a *IngestACL) track(conn net.Conn, ip net.IP) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ip == nil || !a.allowedLocked(ip) {
+		return false
+	}
+	a.conns[conn] = ip
+	return true
+}
+
+func (a *IngestACL) untrack(conn net.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.conns, conn)
+}
+
+func relayIngest(conn net.Conn, target string, acl *IngestACL) {
+	defer conn.Close()
+	ip := net.ParseIP(remoteIP(conn.RemoteAddr().String()))
+	if !acl.track(conn, ip) {
+		glog.Infof("Refused ingest connection from remote=%s", conn.RemoteAddr())
+		monitor.EmitEvent(monitor.EventIngestRefused, map[string]interface{}{"remote": conn.RemoteAddr().String()})
+		return
+	}
+	defer acl.untrack(conn)
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		glog.Errorf("Unable to relay ingest connection remote=%s err=%v", conn.RemoteAddr(), err)
+		return
+	}
+	defer upstream.Close()
+	glog.V(common.DEBUG).Infof("Relaying ingest connection remote=%s", conn.RemoteAddr())
+
+	done := make(chan struct{}, 2)
+	relay := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go relay(upstream, conn)
+	go relay(conn, upstream)
+	// Either side closing ends the relay
+	<-done
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	nets, err := ParseCIDRs("10.0.0.0/8, 192.168.1.5,2001:db8::/32,::1,")
+	require.Nil(err)
+	var ranges []string
+	for _, n := range nets {
+		ranges = append(ranges, n.String())
+	}
+	assert.Equal([]string{"10.0.0.0/8", "192.168.1.5/32", "2001:db8::/32", "::1/128"}, ranges)
+
+	nets, err = ParseCIDRs("")
+	require.Nil(err)
+	assert.Empty(nets)
+
+	_, err = ParseCIDRs("10.0.0.0/33")
+	assert.EqualError(err, "invalid CIDR range 10.0.0.0/33")
+	_, err = ParseCIDRs("encoder.example.com")
+	assert.EqualError(err, "invalid IP address encoder.example.com")
+}
+
+func TestIngestACL_Allowed(t *testing.T) {
+	assert := assert.New(t)
+	cidrs := func(s string) []*net.IPNet {
+		nets, err := ParseCIDRs(s)
+		require.Nil(t, err)
+		return nets
+	}
+
+	// Anything is allowed without ranges
+	acl := NewIngestACL(nil, nil)
+	assert.True(acl.Allowed(net.ParseIP("1.2.3.4")))
+
+	acl = NewIngestACL(nil, cidrs("1.2.3.0/24"))
+	assert.False(acl.Allowed(net.ParseIP("1.2.3.4")))
+	assert.True(acl.Allowed(net.ParseIP("1.2.4.4")))
+
+	// Denied ranges take precedence over allowed ones
+	acl = NewIngestACL(cidrs("10.0.0.0/8"), cidrs("10.1.0.0/16"))
+	assert.True(acl.Allowed(net.ParseIP("10.2.0.1")))
+	assert.False(acl.Allowed(net.ParseIP("10.1.0.1")))
+	assert.False(acl.Allowed(net.ParseIP("1.2.3.4")))
+
+	acl.Set(nil, nil)
+	assert.True(acl.Allowed(net.ParseIP("1.2.3.4")))
+	allow, deny := acl.Ranges()
+	assert.Equal([]string{}, allow)
+	assert.Equal([]string{}, deny)
+}
+
+func TestServeIngestFilter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Echoes lines back, standing in for the RTMP server
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	addr, err := freeLoopbackAddr()
+	require.Nil(err)
+	acl := NewIngestACL(nil, nil)
+	go serveIngestFilter(addr, target.Addr().String(), acl)
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Nil(err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	_, err = conn.Write([]byte("hello\n"))
+	require.Nil(err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.Nil(err)
+	assert.Equal("hello\n", line)
+
+	// Denying the address closes its connection, and refuses new ones
+	deny, _ := ParseCIDRs("127.0.0.1")
+	acl.Set(nil, deny)
+	_, err = conn.Read(make([]byte, 1))
+	assert.NotNil(err)
+
+	refused, err := net.Dial("tcp", addr)
+	require.Nil(err)
+	defer refused.Close()
+	refused.SetDeadline(time.Now().Add(time.Second))
+	_, err = refused.Read(make([]byte, 1))
+	assert.Equal(io.EOF, err)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+)
+
+// LiveMetricsInterval default interval between updates sent to WebSocket clients
+var LiveMetricsInterval = time.Second
+
+// minLiveMetricsInterval is the shortest interval clients may ask for
+var minLiveMetricsInterval = time.Second
+
+// LiveMetricsOrigins are the origins of the dashboards that may connect to
+// the live metrics from a browser, besides those served from the node itself;
+// any if it has "*"
+var LiveMetricsOrigins []string
+
+const liveMetricsWriteTimeout = 10 * time.Second
+
+// streamStats live metrics of a stream, streamed to dashboards
+type streamStats struct {
+	lock        sync.Mutex
+	bitrate     float64 // of the latest source segment, bits per second
+	latency     time.Duration
+	orch        string
+	seqNo       uint64
+	transcoded  int
+	pending     map[uint64]time.Time // seqNo:time the segment emerged
+	lastUpdated time.Time
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{pending: make(map[uint64]time.Time)}
+}
+
+// segmentEmerged records a source segment coming out of the segmenter
+func (st *streamStats) segmentEmerged(seg *stream.HLSSegment) {
+	if st == nil {
+		return
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if seg.Duration > 0 {
+		st.bitrate = float64(len(seg.Data)*8) / seg.Duration
+	}
+	st.seqNo = seg.SeqNo
+	st.pending[seg.SeqNo] = time.Now()
+	st.lastUpdated = time.Now()
+}
+
+// segmentTranscoded records the renditions of a segment transcoded by orch
+// appearing in the playlist
+func (st *streamStats) segmentTranscoded(seqNo uint64, orch string) {
+	if st == nil {
+		return
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	if emerged, ok := st.pending[seqNo]; ok {
+		st.latency = time.Since(emerged)
+		delete(st.pending, seqNo)
+	}
+	st.orch = orch
+	st.transcoded++
+	st.lastUpdated = time.Now()
+}
+
+// segmentDone forgets a segment once there's nothing more to do with it
+func (st *streamStats) segmentDone(seqNo uint64) {
+	if st == nil {
+		return
+	}
+	st.lock.Lock()
+	defer st.lock.Unlock()
+	delete(st.pending, seqNo)
+}
+
+type liveStreamMetrics struct {
+	ManifestID   string  `json:"manifestID"`
+	Nonce        uint64  `json:"nonce"`
+	SeqNo        uint64  `json:"seqNo"`
+	Bitrate      float64 `json:"bitrate"`
+	Latency      float64 `json:"latency"`
+	Orchestrator string  `json:"orchestrator"`
+	Transcoded   int     `json:"transcoded"`
+	Pending      int     `json:"pending"`
+	LastUpdated  int64   `json:"lastUpdated"`
+}
+
+type liveMetrics struct {
+	Timestamp int64                `json:"timestamp"`
+	Streams   []*liveStreamMetrics `json:"streams"`
+}
+
+func (s *LivepeerServer) liveMetrics() *liveMetrics {
+	s.connectionLock.RLock()
+	cxns := make(map[core.ManifestID]*rtmpConnection, len(s.rtmpConnections))
+	for mid, cxn := range s.rtmpConnections {
+		cxns[mid] = cxn
+	}
+	s.connectionLock.RUnlock()
+
+	m := &liveMetrics{Timestamp: time.Now().Unix(), Streams: make([]*liveStreamMetrics, 0, len(cxns))}
+	for mid, cxn := range cxns {
+		sm := &liveStreamMetrics{ManifestID: string(mid), Nonce: cxn.nonce}
+		if st := cxn.stats; st != nil {
+			st.lock.Lock()
+			sm.SeqNo = st.seqNo
+			sm.Bitrate = st.bitrate
+			sm.Latency = st.latency.Seconds()
+			sm.Orchestrator = st.orch
+			sm.Transcoded = st.transcoded
+			sm.Pending = len(st.pending)
+			if !st.lastUpdated.IsZero() {
+				sm.LastUpdated = st.lastUpdated.Unix()
+			}
+			st.lock.Unlock()
+		}
+		m.Streams = append(m.Streams, sm)
+	}
+	sort.Slice(m.Streams, func(i, j int) bool { return m.Streams[i].ManifestID < m.Streams[j].ManifestID })
+	return m
+}
+
+var liveMetricsUpgrader = websocket.Upgrader{CheckOrigin: liveMetricsOriginAllowed}
+
+// liveMetricsOriginAllowed keeps pages of other origins from reading the live
+// metrics through the browsers of those who can reach the node, unless the
+// origin is in LiveMetricsOrigins. Clients that aren't browsers send no
+// Origin.
+func liveMetricsOriginAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range LiveMetricsOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// liveMetricsHandler streams the metrics of each stream to WebSocket clients
+// as JSON messages. The interval between messages can be set in seconds
+// with the `interval` query parameter, no shorter than a second.
+func (s *LivepeerServer) liveMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		interval := LiveMetricsInterval
+		if v := r.URL.Query().Get("interval"); v != "" {
+			secs, err := strconv.ParseFloat(v, 64)
+			if err != nil || secs <= 0 {
+				http.Error(w, "invalid interval", http.StatusBadRequest)
+				return
+			}
+			interval = time.Duration(secs * float64(time.Second))
+			if interval < minLiveMetricsInterval {
+				interval = minLiveMetricsInterval
+			}
+		}
+		conn, err := liveMetricsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade already responded to the client
+			glog.Error("Error upgrading live metrics connection: ", err)
+			return
+		}
+		defer conn.Close()
+
+		// Clients aren't expected to send anything; reading detects them going away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			conn.SetWriteDeadline(time.Now().Add(liveMetricsWriteTimeout))
+			if err := conn.WriteJSON(s.liveMetrics()); err != nil {
+				glog.V(common.DEBUG).Info("Live metrics client went away: ", err)
+				return
+			}
+			select {
+			case <-ticker.C:
+			case <-closed:
+				return
+			}
+		}
+	})
+}
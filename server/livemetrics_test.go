@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamStats(t *testing.T) {
+	assert := assert.New(t)
+
+	st := newStreamStats()
+	st.segmentEmerged(&stream.HLSSegment{SeqNo: 1, Data: make([]byte, 1000), Duration: 2})
+	assert.Equal(float64(4000), st.bitrate)
+	assert.Len(st.pending, 1)
+
+	st.segmentTranscoded(1, "https://orch:8935")
+	assert.Equal("https://orch:8935", st.orch)
+	assert.Equal(1, st.transcoded)
+	assert.Len(st.pending, 0)
+
+	st.segmentEmerged(&stream.HLSSegment{SeqNo: 2, Duration: 2})
+	st.segmentDone(2)
+	assert.Len(st.pending, 0)
+
+	// Connections without stats
+	var nilStats *streamStats
+	nilStats.segmentEmerged(&stream.HLSSegment{})
+	nilStats.segmentTranscoded(1, "")
+	nilStats.segmentDone(1)
+}
+
+func TestLiveMetricsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	st := newStreamStats()
+	st.segmentEmerged(&stream.HLSSegment{SeqNo: 3, Data: make([]byte, 100), Duration: 1})
+	s := &LivepeerServer{
+		connectionLock: &sync.RWMutex{},
+		rtmpConnections: map[core.ManifestID]*rtmpConnection{
+			"b": &rtmpConnection{mid: "b", nonce: 2},
+			"a": &rtmpConnection{mid: "a", nonce: 1, stats: st},
+		},
+	}
+	defer func(d time.Duration) { minLiveMetricsInterval = d }(minLiveMetricsInterval)
+	minLiveMetricsInterval = 10 * time.Millisecond
+	ts := httptest.NewServer(s.liveMetricsHandler())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?interval=0.01", nil)
+	require.Nil(err)
+	defer conn.Close()
+
+	for i := 0; i < 2; i++ {
+		var m liveMetrics
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		require.Nil(conn.ReadJSON(&m))
+		require.Len(m.Streams, 2)
+		assert.Equal("a", m.Streams[0].ManifestID)
+		assert.Equal(uint64(3), m.Streams[0].SeqNo)
+		assert.Equal(float64(800), m.Streams[0].Bitrate)
+		assert.Equal(1, m.Streams[0].Pending)
+		assert.Equal("b", m.Streams[1].ManifestID)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?interval=x", nil)
+	assert.NotNil(err)
+	require.NotNil(resp)
+	assert.Equal(400, resp.StatusCode)
+
+	// Pages of other origins can't connect
+	_, resp, err = websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://evil.example.com"}})
+	assert.NotNil(err)
+	require.NotNil(resp)
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+}
+
+func TestLiveMetricsOriginAllowed(t *testing.T) {
+	assert := assert.New(t)
+	defer func(origins []string) { LiveMetricsOrigins = origins }(LiveMetricsOrigins)
+	LiveMetricsOrigins = nil
+	req := func(origin string) *http.Request {
+		r := httptest.NewRequest("GET", "http://localhost:7935/streamMetrics", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	assert.True(liveMetricsOriginAllowed(req("")))
+	assert.True(liveMetricsOriginAllowed(req("http://localhost:7935")))
+	assert.False(liveMetricsOriginAllowed(req("http://localhost:3000")))
+	assert.False(liveMetricsOriginAllowed(req("https://dashboard.example.com")))
+
+	LiveMetricsOrigins = []string{"https://dashboard.example.com"}
+	assert.True(liveMetricsOriginAllowed(req("https://dashboard.example.com")))
+	assert.False(liveMetricsOriginAllowed(req("http://localhost:3000")))
+	LiveMetricsOrigins = []string{"*"}
+	assert.True(liveMetricsOriginAllowed(req("http://localhost:3000")))
+}
+
+func TestLiveMetricsHandler_MinInterval(t *testing.T) {
+	s := &LivepeerServer{connectionLock: &sync.RWMutex{}, rtmpConnections: map[core.ManifestID]*rtmpConnection{}}
+	ts := httptest.NewServer(s.liveMetricsHandler())
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"?interval=0.001", nil)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// Asking for less than the minimum gets the minimum
+	var m liveMetrics
+	require.Nil(t, conn.ReadJSON(&m))
+	start := time.Now()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.Nil(t, conn.ReadJSON(&m))
+	assert.True(t, time.Since(start) > minLiveMetricsInterval/2)
+}
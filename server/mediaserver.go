@@ -26,8 +26,8 @@ import (
 	"github.com/livepeer/go-livepeer/net"
 
 	"github.com/ericxtang/m3u8"
-	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/core"
 	lpmscore "github.com/livepeer/lpms/core"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
@@ -57,12 +57,21 @@ const BroadcastRetry = 15 * time.Second
 var BroadcastPrice = big.NewInt(1)
 var BroadcastJobVideoProfiles = []ffmpeg.VideoProfile{ffmpeg.P240p30fps4x3, ffmpeg.P360p30fps16x9}
 
+// BroadcastSegmentPriority is the priority of the segments of streams the
+// auth webhook doesn't give one for
+var BroadcastSegmentPriority = core.SegmentPriorityLive
+
 var AuthWebhookURL string
 
 type streamParameters struct {
 	mid      core.ManifestID
 	rtmpKey  string
 	profiles []ffmpeg.VideoProfile
+	// authProfiles if the profiles were given by the auth webhook, rather
+	// than being the defaults
+	authProfiles bool
+	// priority orchestrators transcode the segments of the stream with
+	priority core.SegmentPriority
 }
 
 func (s *streamParameters) StreamID() string {
@@ -77,6 +86,10 @@ type rtmpConnection struct {
 	profile     *ffmpeg.VideoProfile
 	params      *streamParameters
 	sessManager *BroadcastSessionsManager
+	stats       *streamStats
+	// failover keeps the state of the stream in the shared DB, if FailoverID
+	// is set
+	failover *streamFailover
 }
 
 type LivepeerServer struct {
@@ -84,6 +97,12 @@ type LivepeerServer struct {
 	LPMS          *lpmscore.LPMS
 	LivepeerNode  *core.LivepeerNode
 	HTTPMux       *http.ServeMux
+	httpAddr      string
+
+	// RTMP connections to rtmpAddr are let through to the RTMP server on
+	// lpmsRtmpAddr if ingestACL allows them
+	rtmpAddr, lpmsRtmpAddr string
+	ingestACL              *IngestACL
 
 	ExposeCurrentManifest bool
 
@@ -99,9 +118,13 @@ type authWebhookResponse struct {
 	ManifestID string   `json:"manifestID"`
 	StreamKey  string   `json:"streamKey"`
 	Presets    []string `json:"presets"`
+	// Priority of the segments of the stream: "live", or "low" for streams
+	// that aren't watched live
+	Priority string `json:"priority"`
 }
 
 func NewLivepeerServer(rtmpAddr string, httpAddr string, lpNode *core.LivepeerNode) *LivepeerServer {
+	ls := &LivepeerServer{LivepeerNode: lpNode, httpAddr: httpAddr, connectionLock: &sync.RWMutex{}, rtmpConnections: make(map[core.ManifestID]*rtmpConnection)}
 	opts := lpmscore.LPMSOpts{
 		RtmpAddr: rtmpAddr, RtmpDisabled: true,
 		HttpAddr: httpAddr,
@@ -110,14 +133,31 @@ func NewLivepeerServer(rtmpAddr string, httpAddr string, lpNode *core.LivepeerNo
 	switch lpNode.NodeType {
 	case core.BroadcasterNode:
 		opts.RtmpDisabled = false
+		// Served by StartMediaServer, behind the HLS rate limits
+		opts.HttpMux = http.NewServeMux()
+		if IngestFilter {
+			addr, err := freeLoopbackAddr()
+			if err != nil {
+				glog.Fatal("Unable to listen for the RTMP server behind the ingest filter ", err)
+			}
+			opts.RtmpAddr = addr
+		}
 	case core.OrchestratorNode:
 		opts.HttpMux = http.NewServeMux()
+		// Orchestrators are health checked on their service port by load balancers
+		opts.HttpMux.Handle("/healthz", ls.healthHandler(false))
+		opts.HttpMux.Handle("/readyz", ls.healthHandler(true))
 	}
 	server := lpmscore.New(&opts)
-	return &LivepeerServer{RTMPSegmenter: server, LPMS: server, LivepeerNode: lpNode, HTTPMux: opts.HttpMux, connectionLock: &sync.RWMutex{}, rtmpConnections: make(map[core.ManifestID]*rtmpConnection)}
+	ls.RTMPSegmenter, ls.LPMS, ls.HTTPMux = server, server, opts.HttpMux
+	if opts.RtmpAddr != rtmpAddr {
+		ls.rtmpAddr, ls.lpmsRtmpAddr = rtmpAddr, opts.RtmpAddr
+		ls.ingestACL = NewIngestACL(IngestAllow, IngestDeny)
+	}
+	return ls
 }
 
-//StartServer starts the LPMS server
+// StartServer starts the LPMS server
 func (s *LivepeerServer) StartMediaServer(ctx context.Context, transcodingOptions string) error {
 	BroadcastJobVideoProfiles = parsePresets(strings.Split(transcodingOptions, ","))
 
@@ -133,6 +173,19 @@ func (s *LivepeerServer) StartMediaServer(ctx context.Context, transcodingOption
 	//Start the LPMS server
 	lpmsCtx, cancel := context.WithCancel(context.Background())
 	ec := make(chan error, 1)
+	if s.LivepeerNode.NodeType == core.BroadcasterNode {
+		go func() {
+			limiter := newRateLimiter("hls", HLSRateLimitPerIP, HLSRateLimit)
+			srv := &http.Server{Addr: s.httpAddr, Handler: limiter.handler(s.HTTPMux)}
+			glog.Info("HLS server listening on ", s.httpAddr)
+			ec <- srv.ListenAndServe()
+		}()
+	}
+	if s.ingestACL != nil {
+		go func() {
+			ec <- serveIngestFilter(s.rtmpAddr, s.lpmsRtmpAddr, s.ingestACL)
+		}()
+	}
 	go func() {
 		if err := s.LPMS.Start(lpmsCtx); err != nil {
 			// typically triggered if there's an error with broadcaster LPMS
@@ -152,9 +205,14 @@ func (s *LivepeerServer) StartMediaServer(ctx context.Context, transcodingOption
 	}
 }
 
-//RTMP Publish Handlers
+// RTMP Publish Handlers
 func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID stream.AppData) {
 	return func(url *url.URL) (strmID stream.AppData) {
+		if shutdown.isDraining() {
+			glog.Error("Not accepting stream: ", errShuttingDown)
+			return nil
+		}
+
 		//Check webhook for ManifestID
 		//If ManifestID is returned from webhook, use it
 		//Else check URL for ManifestID
@@ -165,6 +223,8 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 		var err error
 		var key string
 		presets := BroadcastJobVideoProfiles
+		authPresets := false
+		priority := BroadcastSegmentPriority
 		if resp, err = authenticateStream(url.String()); err != nil {
 			glog.Error("Authentication denied for ", err)
 			return nil
@@ -173,7 +233,10 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 			mid, key = parseStreamID(resp.ManifestID).ManifestID, resp.StreamKey
 			// Process transcoding options presets
 			if len(resp.Presets) > 0 {
-				presets = parsePresets(resp.Presets)
+				presets, authPresets = parsePresets(resp.Presets), true
+			}
+			if resp.Priority != "" {
+				priority = core.ParseSegmentPriority(resp.Priority)
 			}
 		}
 
@@ -202,9 +265,11 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 			key = hex.EncodeToString(core.RandomIdGenerator(StreamKeyBytes))
 		}
 		return &streamParameters{
-			mid:      mid,
-			rtmpKey:  key,
-			profiles: presets,
+			mid:          mid,
+			rtmpKey:      key,
+			profiles:     presets,
+			authProfiles: authPresets,
+			priority:     priority,
 		}
 	}
 }
@@ -256,14 +321,22 @@ func streamParams(rtmpStrm stream.RTMPVideoStream) *streamParameters {
 func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
 	return func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
 
+		_, span := monitor.StartSpan(context.Background(), "broadcast.ingest")
 		cxn, err := s.registerConnection(rtmpStrm)
 		if err != nil {
+			monitor.EndSpan(span, err)
 			return err
 		}
 
 		mid := cxn.mid
 		nonce := cxn.nonce
+		span.SetAttributes(monitor.AttrManifestID.String(string(mid)), monitor.AttrNonce.Int64(int64(nonce)))
+		span.End()
 		startSeq := 0
+		if cxn.failover != nil {
+			startSeq = cxn.failover.startSeq()
+			go cxn.failover.renew(cxn)
+		}
 
 		streamStarted := false
 		//Segment the stream, insert the segments into the broadcaster
@@ -288,7 +361,10 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 				StartSeq:  startSeq,
 				SegLength: SegLen,
 			}
+			_, sspan := monitor.StartSpan(context.Background(), "broadcast.segmentation",
+				monitor.AttrManifestID.String(string(mid)), monitor.AttrNonce.Int64(int64(nonce)))
 			err := s.RTMPSegmenter.SegmentRTMPToHLS(context.Background(), rtmpStrm, hlsStrm, segOptions)
+			monitor.EndSpan(sspan, err)
 			if err != nil {
 				// Stop the incoming RTMP connection.
 				// TODO retry segmentation if err != SegmenterTimeout; may be recoverable
@@ -322,10 +398,15 @@ func endRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 			glog.Error("Attempted to end unknown stream with manifest ID ", mid)
 			return errUnknownStream
 		}
+		if cxn.failover != nil {
+			cxn.failover.release(cxn)
+		}
 		cxn.sessManager.cleanup()
 		cxn.pl.Cleanup()
 		glog.Infof("Ended stream with id=%s", mid)
+		common.ClearLogStream(cxn.nonce)
 		delete(s.rtmpConnections, mid)
+		monitor.EmitEvent(monitor.EventStreamEnded, map[string]interface{}{"manifestID": string(mid), "nonce": cxn.nonce})
 		if monitor.Enabled {
 			monitor.StreamEnded(cxn.nonce)
 			monitor.CurrentSessions(len(s.rtmpConnections))
@@ -383,7 +464,28 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 		return nil, errAlreadyExists
 	}
 
+	var failover *streamFailover
+	if FailoverID != "" && s.LivepeerNode.Database != nil {
+		f, err := acquireStream(s.LivepeerNode.Database, params)
+		if err == common.ErrStreamHeld {
+			// Another broadcaster is ingesting the stream
+			glog.Errorf("Stream manifestID=%s is held by another broadcaster", mid)
+			return nil, errAlreadyExists
+		}
+		if err != nil {
+			glog.Errorf("Error acquiring stream manifestID=%s: %v", mid, err)
+			return nil, err
+		}
+		failover = f
+	}
+
 	playlist := core.NewBasicPlaylistManager(mid, storage)
+	sessManager := NewSessionManager(s.LivepeerNode, params, playlist)
+	if failover != nil && failover.resumed {
+		// Carry on with the orchestrators the stream was sent to before
+		sessManager.addSessions(resumeSessions(s.LivepeerNode, params, playlist, failover.orchestrators()))
+		glog.Infof("Resuming stream manifestID=%s from seqNo=%d", mid, failover.startSeq())
+	}
 	cxn := &rtmpConnection{
 		mid:         mid,
 		nonce:       nonce,
@@ -391,7 +493,9 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 		pl:          playlist,
 		profile:     &vProfile,
 		params:      params,
-		sessManager: NewSessionManager(s.LivepeerNode, params, playlist),
+		sessManager: sessManager,
+		stats:       newStreamStats(),
+		failover:    failover,
 	}
 	s.connectionLock.Lock()
 	s.rtmpConnections[mid] = cxn
@@ -399,6 +503,8 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 	s.lastHLSStreamID = hlsStrmID
 	sessionsNumber := len(s.rtmpConnections)
 	s.connectionLock.Unlock()
+	common.SetLogStream(nonce, string(mid))
+	monitor.EmitEvent(monitor.EventStreamStarted, map[string]interface{}{"manifestID": string(mid), "nonce": nonce})
 	if monitor.Enabled {
 		monitor.CurrentSessions(sessionsNumber)
 	}
@@ -408,7 +514,7 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 
 //End RTMP Publish Handlers
 
-//HLS Play Handlers
+// HLS Play Handlers
 func getHLSMasterPlaylistHandler(s *LivepeerServer) func(url *url.URL) (*m3u8.MasterPlaylist, error) {
 	return func(url *url.URL) (*m3u8.MasterPlaylist, error) {
 		var manifestID core.ManifestID
@@ -471,7 +577,15 @@ func getHLSSegmentHandler(s *LivepeerServer) func(url *url.URL) ([]byte, error)
 			glog.Error("Unexpected path structure")
 			return nil, vidplayer.ErrNotFound
 		}
-		memoryOS, ok := drivers.NodeStorage.(*drivers.MemoryOS)
+		storage := drivers.NodeStorage
+		if aos, ok := storage.(*drivers.ArchiveOS); ok {
+			storage = aos.Archived()
+		}
+		if fos, ok := storage.(*drivers.FallbackOS); ok {
+			// Segments saved while the primary storage is failing are served by us
+			storage = fos.Fallback()
+		}
+		memoryOS, ok := storage.(*drivers.MemoryOS)
 		if !ok {
 			return nil, vidplayer.ErrNotFound
 		}
@@ -491,7 +605,7 @@ func getHLSSegmentHandler(s *LivepeerServer) func(url *url.URL) ([]byte, error)
 
 //End HLS Play Handlers
 
-//Start RTMP Play Handlers
+// Start RTMP Play Handlers
 func getRTMPStreamHandler(s *LivepeerServer) func(url *url.URL) (stream.RTMPVideoStream, error) {
 	return func(url *url.URL) (stream.RTMPVideoStream, error) {
 		mid := parseManifestID(url.Path)
@@ -578,7 +692,7 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 	if s.LivepeerNode.OrchestratorPool != nil {
 		urls := s.LivepeerNode.OrchestratorPool.GetURLs()
 		for _, url := range urls {
-			res.OrchestratorPool = append(res.OrchestratorPool, url.String())
+			res.OrchestratorPool = append(res.OrchestratorPool, common.RedactURL(url.String()))
 		}
 	}
 	return res
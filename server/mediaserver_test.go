@@ -368,6 +368,13 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	defer ts7.Close()
 	params = createSid(u).(*streamParameters)
 	assert.Len(params.profiles, 0, "Unexpected value in presets")
+	assert.Equal(BroadcastSegmentPriority, params.priority)
+
+	// set segment priority
+	ts8 := makeServer(`{"manifestID":"a", "priority":"low"}`)
+	defer ts8.Close()
+	params = createSid(u).(*streamParameters)
+	assert.Equal(core.SegmentPriorityLow, params.priority)
 }
 
 func TestCreateRTMPStreamHandler(t *testing.T) {
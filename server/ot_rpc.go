@@ -16,13 +16,16 @@ import (
 	"net/textproto"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/cenkalti/backoff"
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
@@ -30,6 +33,7 @@ import (
 
 	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
 )
 
@@ -38,18 +42,101 @@ const transcodingErrorMimeType = "livepeer/transcoding-error"
 
 var errSecret = errors.New("Invalid secret")
 
+// errTranscoderDrained is returned once the orchestrator drained the transcoder
+var errTranscoderDrained = errors.New("transcoder drained by the orchestrator")
+
 // Standalone Transcoder
 
+// transcoderCapacityInterval is how often a standalone transcoder checks its
+// capacity, to update the orchestrator on it as it changes
+var transcoderCapacityInterval = 30 * time.Second
+
+// transcoderTokenFile is where a standalone transcoder saves the token the
+// orchestrator last rotated it to, in its datadir
+const transcoderTokenFile = "transcoder_token"
+
+// transcoderCreds are what a standalone transcoder authenticates to the
+// orchestrator with: -orchSecret, or the token the orchestrator last rotated
+// it to, which is used across restarts
+type transcoderCreds struct {
+	mu      sync.RWMutex
+	secret  string
+	rotated bool
+	path    string
+}
+
+func newTranscoderCreds(n *core.LivepeerNode) *transcoderCreds {
+	c := &transcoderCreds{secret: n.OrchSecret}
+	if n.WorkDir == "" {
+		return c
+	}
+	c.path = filepath.Join(n.WorkDir, transcoderTokenFile)
+	if token, err := ioutil.ReadFile(c.path); err == nil && len(bytes.TrimSpace(token)) > 0 {
+		c.secret, c.rotated = string(bytes.TrimSpace(token)), true
+	}
+	return c
+}
+
+func (c *transcoderCreds) get() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.secret
+}
+
+func (c *transcoderCreds) rotate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.secret, c.rotated = token, true
+	if c.path == "" {
+		return
+	}
+	if err := ioutil.WriteFile(c.path, []byte(token), 0600); err != nil {
+		glog.Errorf("Error saving rotated transcoder token to %v: %v", c.path, err)
+	}
+}
+
+// reset goes back to -orchSecret once the rotated token is rejected, e.g. as
+// it expired while the transcoder was down. Returns whether it did.
+func (c *transcoderCreds) reset(orchSecret string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.rotated {
+		return false
+	}
+	c.secret, c.rotated = orchSecret, false
+	if c.path != "" {
+		os.Remove(c.path)
+	}
+	return true
+}
+
 // RunTranscoder is main routing of standalone transcoder
 // Exiting it will terminate executable
-func RunTranscoder(n *core.LivepeerNode, orchAddr string, capacity int) {
+func RunTranscoder(n *core.LivepeerNode, orchAddr string, capacity core.RemoteTranscoderCapacity) {
 	expb := backoff.NewExponentialBackOff()
 	expb.MaxInterval = time.Minute
 	expb.MaxElapsedTime = 0
+	creds := newTranscoderCreds(n)
+	// The orchestrator holds the segments of a transcoder that reconnects
+	// with the same ID in a moment
+	id := randName()
 	backoff.Retry(func() error {
 		glog.Info("Registering transcoder to ", orchAddr)
-		err := runTranscoder(n, orchAddr, capacity)
+		start := time.Now()
+		err := runTranscoder(n, orchAddr, id, capacity, creds)
 		glog.Info("Unregistering transcoder: ", err)
+		if time.Since(start) > expb.MaxInterval {
+			// Reconnect right away after a blip
+			expb.Reset()
+		}
+		if err != nil && err.Error() == errSecret.Error() && creds.reset(n.OrchSecret) {
+			glog.Info("Rotated token rejected, registering with -orchSecret")
+			return err
+		}
+		if err == errTranscoderDrained {
+			glog.Info("Terminating transcoder: drained by the orchestrator")
+			return nil
+		}
 		if _, fatal := err.(core.RemoteTranscoderFatalError); fatal {
 			glog.Info("Terminating transcoder because of ", err)
 			// Returning nil here will make `backoff` to stop trying to reconnect and exit
@@ -73,7 +160,7 @@ func checkTranscoderError(err error) error {
 	return err
 }
 
-func runTranscoder(n *core.LivepeerNode, orchAddr string, capacity int) error {
+func runTranscoder(n *core.LivepeerNode, orchAddr, id string, capacity core.RemoteTranscoderCapacity, creds *transcoderCreds) error {
 	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	conn, err := grpc.Dial(orchAddr,
 		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
@@ -88,7 +175,16 @@ func runTranscoder(n *core.LivepeerNode, orchAddr string, capacity int) error {
 	ctx, cancel := context.WithCancel(ctx)
 	// Silence linter
 	defer cancel()
-	r, err := c.RegisterTranscoder(ctx, &net.RegisterRequest{Secret: n.OrchSecret, Capacity: int64(capacity)})
+	current := currentCapacity(n, capacity)
+	r, err := c.RegisterTranscoder(ctx)
+	if err == nil {
+		req := registerRequest(id, current)
+		req.Secret = creds.get()
+		if err = r.Send(req); err == io.EOF {
+			// The orchestrator ended the stream; why is received below
+			err = nil
+		}
+	}
 	if err := checkTranscoderError(err); err != nil {
 		glog.Error("Could not register transcoder to orchestrator ", err)
 		return err
@@ -109,91 +205,265 @@ func runTranscoder(n *core.LivepeerNode, orchAddr string, capacity int) error {
 	}()
 
 	httpc := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	go watchCapacity(ctx, n, r, id, capacity, current)
 	var wg sync.WaitGroup
+	// Once drained, the orchestrator ends the stream as the segments it sent
+	// are done
+	drained := false
 	for {
 		notify, err := r.Recv()
 		if err := checkTranscoderError(err); err != nil {
 			glog.Infof(`End of stream recieve cylcle because of err="%v", waiting for running transcode jobs to complete`, err)
 			wg.Wait()
+			if drained {
+				return errTranscoderDrained
+			}
 			return err
 		}
+		if notify.Drain {
+			glog.Info("Orchestrator is draining the transcoder, exiting once running transcode jobs complete")
+			drained = true
+			continue
+		}
+		if notify.Token != "" {
+			glog.Info("Orchestrator rotated the transcoder's token")
+			creds.rotate(notify.Token)
+			continue
+		}
 		wg.Add(1)
 		go func() {
-			runTranscode(n, orchAddr, httpc, notify)
+			runTranscode(n, orchAddr, httpc, notify, creds.get())
 			wg.Done()
 		}()
 	}
 }
 
-func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifySegment) {
+// availableCodecs are the codecs the transcoder can encode in now
+var availableCodecs = core.AvailableCodecs
+
+// currentCapacity is the capacity the transcoder was started with, less the
+// GPUs that have since gone, e.g. taken offline, along with their sessions,
+// and with the codecs it can encode in now
+func currentCapacity(n *core.LivepeerNode, capacity core.RemoteTranscoderCapacity) core.RemoteTranscoderCapacity {
+	current := capacity
+	current.Codecs = availableCodecs(n.Transcoder)
+	if hw, ok := n.Transcoder.(*core.HardwareTranscoder); ok && capacity.GPUs > 0 {
+		current.Accel = hw.Accel()
+		current.GPUs = len(hw.AvailableDevices())
+		current.Sessions = capacity.Sessions * current.GPUs / capacity.GPUs
+	}
+	if len(current.Codecs) == 0 {
+		// Reporting no codecs would have it sent segments of any codec
+		current.Sessions = 0
+	}
+	return current
+}
+
+// capacitySender sends the capacity of the transcoder to the orchestrator
+// over the stream it registered with
+type capacitySender interface {
+	Send(*net.RegisterRequest) error
+}
+
+// watchCapacity updates the orchestrator on the capacity of the transcoder
+// as it changes, until ctx is done
+func watchCapacity(ctx context.Context, n *core.LivepeerNode, stream capacitySender, id string, capacity, current core.RemoteTranscoderCapacity) {
+	ticker := time.NewTicker(transcoderCapacityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		updated := currentCapacity(n, capacity)
+		if reflect.DeepEqual(updated, current) {
+			continue
+		}
+		glog.Infof("Transcoder capacity changed sessions=%d gpus=%d codecs=%v, updating the orchestrator",
+			updated.Sessions, updated.GPUs, updated.Codecs)
+		if err := stream.Send(registerRequest(id, updated)); err != nil {
+			// The stream is broken; the transcoder registers again
+			glog.Error("Error updating transcoder capacity: ", err)
+			return
+		}
+		current = updated
+	}
+}
+
+func registerRequest(id string, capacity core.RemoteTranscoderCapacity) *net.RegisterRequest {
+	return &net.RegisterRequest{
+		Capacity: int64(capacity.Sessions),
+		Gpus:     int64(capacity.GPUs),
+		Codecs:   capacity.Codecs,
+		Accel:    capacity.Accel,
+		Id:       id,
+	}
+}
+
+func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifySegment, secret string) {
 	profiles, err := common.TxDataToVideoProfile(hex.EncodeToString(notify.Profiles))
 	if err != nil {
 		glog.Info("Unable to deserialize profiles ", err)
 	}
 
-	glog.Infof("Transcoding taskId=%d url=%s", notify.TaskId, notify.Url)
+	reqID := notify.TraceContext[requestIDCarrierKey]
+	glog.Infof("Transcoding taskId=%d url=%s requestID=%s", notify.TaskId, notify.Url, reqID)
 	var contentType string
-	var body bytes.Buffer
+	var body io.Reader
 
-	tData, err := n.Transcoder.Transcode(notify.Url, profiles)
+	// Continue the trace of the orchestrator
+	ctx := monitor.ExtractTraceCarrier(context.Background(), notify.TraceContext)
+	_, span := monitor.StartSpan(ctx, "transcoder.transcode", monitor.AttrOrch.String(orchAddr))
+	var tData [][]byte
+	if st, ok := n.Transcoder.(core.StreamTranscoder); ok && notify.ManifestId != "" {
+		tData, err = st.TranscodeStream(core.ManifestID(notify.ManifestId), notify.Url, profiles)
+	} else {
+		tData, err = n.Transcoder.Transcode(notify.Url, profiles)
+	}
+	monitor.EndSpan(span, err)
 	glog.V(common.VERBOSE).Infof("Transcoding done for taskId=%d url=%s err=%v", notify.TaskId, notify.Url, err)
 	if err != nil {
-		glog.Error("Unable to transcode ", err)
-		body.Write([]byte(err.Error()))
+		glog.Errorf("Unable to transcode requestID=%s: %v", reqID, err)
+		body = strings.NewReader(err.Error())
 		contentType = transcodingErrorMimeType
 	} else {
+		// The renditions are written to the request as it's sent, rather
+		// than copied into one buffer beforehand
 		boundary := randName()
-		w := multipart.NewWriter(&body)
-		for _, v := range tData {
-			w.SetBoundary(boundary)
-			hdrs := textproto.MIMEHeader{
-				"Content-Type":   {"video/MP2T"},
-				"Content-Length": {strconv.Itoa(len(v))},
-			}
-			fw, err := w.CreatePart(hdrs)
-			if err != nil {
-				glog.Error("Could not create multipart part ", err)
+		pr, pw := io.Pipe()
+		w := multipart.NewWriter(pw)
+		w.SetBoundary(boundary)
+		go func() {
+			for _, v := range tData {
+				hdrs := textproto.MIMEHeader{
+					"Content-Type":   {"video/MP2T"},
+					"Content-Length": {strconv.Itoa(len(v))},
+				}
+				fw, err := w.CreatePart(hdrs)
+				if err != nil {
+					glog.Error("Could not create multipart part ", err)
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := fw.Write(v); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
 			}
-			io.Copy(fw, bytes.NewBuffer(v))
-		}
-		w.Close()
+			pw.CloseWithError(w.Close())
+		}()
+		// Unblocks the writer should the renditions not be sent, on any path
+		defer pr.Close()
+		body = pr
 		contentType = "multipart/mixed; boundary=" + boundary
 	}
-	req, err := http.NewRequest("POST", "https://"+orchAddr+"/transcodeResults", &body)
+	req, err := http.NewRequest("POST", "https://"+orchAddr+"/transcodeResults", body)
 	if err != nil {
 		glog.Error("Error posting results ", err)
+		return
 	}
 	req.Header.Set("Authorization", protoVerLPT)
-	req.Header.Set("Credentials", n.OrchSecret)
+	req.Header.Set("Credentials", secret)
 	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("TaskId", strconv.FormatInt(notify.TaskId, 10))
+	if common.ValidRequestID(reqID) {
+		req.Header.Set(common.RequestIDHeader, reqID)
+	}
+	rctx, rspan := monitor.StartSpan(ctx, "transcoder.send_results", monitor.AttrOrch.String(orchAddr))
+	monitor.InjectTraceHeaders(rctx, req.Header)
 	resp, err := httpc.Do(req)
+	monitor.EndSpan(rspan, err)
 	if err != nil {
 		glog.Error("Error submitting results ", err)
+		return
 	}
-	ioutil.ReadAll(resp.Body)
+	io.Copy(ioutil.Discard, resp.Body)
 	resp.Body.Close()
 	glog.V(common.VERBOSE).Infof("Transcoding done results sent for taskId=%d url=%s err=%v", notify.TaskId, notify.Url, err)
 }
 
 // Orchestrator gRPC
 
-func (h *lphttp) RegisterTranscoder(req *net.RegisterRequest, stream net.Transcoder_RegisterTranscoderServer) error {
+func (h *lphttp) RegisterTranscoder(stream net.Transcoder_RegisterTranscoderServer) error {
 	from := common.GetConnectionAddr(stream.Context())
 	glog.Infof("Got a RegisterTranscoder request from transcoder=%s", from)
 
-	if req.Secret != h.orchestrator.TranscoderSecret() {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	token, ok := h.orchestrator.AuthenticateTranscoder(req.Secret)
+	if !ok {
 		glog.Info(errSecret.Error())
 		return errSecret
 	}
 
+	go h.receiveCapacity(stream)
 	// blocks until stream is finished
-	h.orchestrator.ServeTranscoder(stream, int(req.Capacity))
-	return nil
+	err = h.orchestrator.ServeTranscoder(stream, req.Id, registeredCapacity(req), token)
+	if err == core.ErrTranscoderEvicted {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+// receiveCapacity updates the capacity of the transcoder registered with
+// stream as it sends it again, until the stream ends. Transcoders that don't
+// update their capacity close their side of the stream once registered.
+func (h *lphttp) receiveCapacity(stream net.Transcoder_RegisterTranscoderServer) {
+	from := common.GetConnectionAddr(stream.Context())
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if err != io.EOF && stream.Context().Err() == nil {
+				glog.Errorf("Error receiving capacity of transcoder=%s: %v", from, err)
+			}
+			return
+		}
+		if err := h.orchestrator.UpdateTranscoderCapacity(stream, registeredCapacity(req)); err != nil {
+			glog.Errorf("Error updating capacity of transcoder=%s: %v", from, err)
+		}
+	}
+}
+
+func registeredCapacity(req *net.RegisterRequest) core.RemoteTranscoderCapacity {
+	return core.RemoteTranscoderCapacity{
+		Sessions: int(req.Capacity),
+		GPUs:     int(req.Gpus),
+		Codecs:   req.Codecs,
+		Accel:    req.Accel,
+	}
 }
 
 // Orchestrator HTTP
 
+// SpooledSegment serves a source segment spooled to disk to the remote
+// transcoder it was sent to, streamed from its file
+func (h *lphttp) SpooledSegment(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, core.SpooledSegmentPath)
+	fname, ok := h.orchestrator.SpooledSegment(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		glog.Error("Error opening spooled segment ", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		glog.Error("Error opening spooled segment ", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "video/MP2T")
+	http.ServeContent(w, r, "", fi.ModTime(), f)
+}
+
 func (h *lphttp) TranscodeResults(w http.ResponseWriter, r *http.Request) {
 	orch := h.orchestrator
 
@@ -205,7 +475,7 @@ func (h *lphttp) TranscodeResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if creds != orch.TranscoderSecret() {
+	if _, ok := orch.AuthenticateTranscoder(creds); !ok {
 		glog.Error("Invalid shared secret")
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -253,7 +523,7 @@ func (h *lphttp) TranscodeResults(w http.ResponseWriter, r *http.Request) {
 				res.Err = err
 				break
 			}
-			body, err := ioutil.ReadAll(p)
+			body, err := common.ReadAll(p)
 			if err != nil {
 				glog.Error("Error reading body ", err)
 				res.Err = err
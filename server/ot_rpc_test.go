@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// registrationStream is the orchestrator's end of the stream of a transcoder
+// that registers with, and then sends, reqs
+type registrationStream struct {
+	common.StubServerStream
+	reqs chan *net.RegisterRequest
+}
+
+func (s *registrationStream) Recv() (*net.RegisterRequest, error) {
+	req, ok := <-s.reqs
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+// capacityStream is the transcoder's end of its registration stream
+type capacityStream struct {
+	sent chan *net.RegisterRequest
+	err  error
+}
+
+func (s *capacityStream) Send(req *net.RegisterRequest) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent <- req
+	return nil
+}
+
+func stubAvailableCodecs(codecs []string) func() {
+	prev := availableCodecs
+	availableCodecs = func(core.Transcoder) []string { return codecs }
+	return func() { availableCodecs = prev }
+}
+
+func TestRegisterTranscoder(t *testing.T) {
+	assert := assert.New(t)
+	orch := &mockOrchestrator{}
+	lp := &lphttp{orchestrator: orch}
+	orch.On("AuthenticateTranscoder", "bad").Return(nil, false)
+	orch.On("AuthenticateTranscoder", "secret").Return(nil, true)
+
+	stream := &registrationStream{reqs: make(chan *net.RegisterRequest, 1)}
+	stream.reqs <- &net.RegisterRequest{Secret: "bad", Capacity: 2}
+	assert.Equal(errSecret, lp.RegisterTranscoder(stream))
+
+	// The transcoder is served with the capacity it registered with
+	stream = &registrationStream{reqs: make(chan *net.RegisterRequest, 1)}
+	stream.reqs <- &net.RegisterRequest{Secret: "secret", Id: "t1", Capacity: 2, Gpus: 1, Codecs: []string{"h264"}}
+	close(stream.reqs)
+	orch.On("ServeTranscoder", stream).Return(nil)
+	assert.Nil(lp.RegisterTranscoder(stream))
+
+	// Transcoders that end the stream without registering aren't served
+	stream = &registrationStream{reqs: make(chan *net.RegisterRequest)}
+	close(stream.reqs)
+	assert.Equal(io.EOF, lp.RegisterTranscoder(stream))
+	orch.AssertNumberOfCalls(t, "ServeTranscoder", 1)
+}
+
+func TestReceiveCapacity(t *testing.T) {
+	orch := &mockOrchestrator{}
+	lp := &lphttp{orchestrator: orch}
+	stream := &registrationStream{reqs: make(chan *net.RegisterRequest, 2)}
+
+	// Updates apply to the transcoder registered with the stream they're
+	// sent over, whatever ID they carry
+	stream.reqs <- &net.RegisterRequest{Id: "other", Capacity: 2, Gpus: 1, Codecs: []string{"h264"}}
+	stream.reqs <- &net.RegisterRequest{Capacity: 0, Gpus: 0, Codecs: []string{"h264"}}
+	close(stream.reqs)
+	orch.On("UpdateTranscoderCapacity", stream, core.RemoteTranscoderCapacity{Sessions: 2, GPUs: 1, Codecs: []string{"h264"}}).Return(nil)
+	orch.On("UpdateTranscoderCapacity", stream, core.RemoteTranscoderCapacity{Codecs: []string{"h264"}}).Return(core.ErrTranscoderNotFound)
+
+	// Returns once the transcoder ends its side of the stream
+	lp.receiveCapacity(stream)
+	orch.AssertExpectations(t)
+}
+
+func TestCurrentCapacity(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer stubAvailableCodecs([]string{"h264"})()
+	n, err := core.NewLivepeerNode(nil, "", nil)
+	require.Nil(err)
+
+	// On the CPU
+	n.Transcoder = core.NewLocalTranscoder("")
+	capacity := core.RemoteTranscoderCapacity{Sessions: 4}
+	assert.Equal(core.RemoteTranscoderCapacity{Sessions: 4, Codecs: []string{"h264"}}, currentCapacity(n, capacity))
+
+	// Nothing is taken on while no codec can be encoded in
+	availableCodecs = func(core.Transcoder) []string { return nil }
+	assert.Equal(0, currentCapacity(n, capacity).Sessions)
+	availableCodecs = func(core.Transcoder) []string { return []string{"h264"} }
+
+	// GPUs that are gone take their sessions with them
+	n.Transcoder = core.NewNvidiaTranscoder("998,999", "")
+	capacity = core.RemoteTranscoderCapacity{Sessions: 4, GPUs: 2}
+	assert.Equal(core.RemoteTranscoderCapacity{Accel: core.AccelNvidia, Codecs: []string{"h264"}}, currentCapacity(n, capacity))
+}
+
+func TestWatchCapacity(t *testing.T) {
+	assert := assert.New(t)
+	defer func(interval time.Duration) { transcoderCapacityInterval = interval }(transcoderCapacityInterval)
+	transcoderCapacityInterval = 5 * time.Millisecond
+	var mu sync.Mutex
+	codecs := []string{"h264"}
+	prev := availableCodecs
+	availableCodecs = func(core.Transcoder) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return codecs
+	}
+	defer func() { availableCodecs = prev }()
+
+	n, _ := core.NewLivepeerNode(nil, "", nil)
+	n.Transcoder = core.NewLocalTranscoder("")
+	capacity := core.RemoteTranscoderCapacity{Sessions: 4}
+	current := currentCapacity(n, capacity)
+	stream := &capacityStream{sent: make(chan *net.RegisterRequest, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchCapacity(ctx, n, stream, "t1", capacity, current)
+		close(done)
+	}()
+
+	// Unchanged capacity isn't sent
+	select {
+	case req := <-stream.sent:
+		t.Fatal("Unexpected capacity update ", req)
+	case <-time.After(5 * transcoderCapacityInterval):
+	}
+
+	// Changes are sent once, without the secret
+	mu.Lock()
+	codecs = []string{"h264", "vp9"}
+	mu.Unlock()
+	select {
+	case req := <-stream.sent:
+		assert.Equal(&net.RegisterRequest{Id: "t1", Capacity: 4, Codecs: []string{"h264", "vp9"}}, req)
+	case <-time.After(time.Second):
+		t.Fatal("Expected a capacity update")
+	}
+	time.Sleep(5 * transcoderCapacityInterval)
+	assert.Len(stream.sent, 0)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected watching to stop with the context")
+	}
+
+	// Watching stops once the stream breaks, as the transcoder registers again
+	stream.err = errors.New("stream broken")
+	mu.Lock()
+	codecs = nil
+	mu.Unlock()
+	done = make(chan struct{})
+	go func() {
+		watchCapacity(context.Background(), n, stream, "t1", capacity, current)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected watching to stop with the stream")
+	}
+}
+
+func TestSpooledSegment(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "spooled")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "seg.ts")
+	require.Nil(t, ioutil.WriteFile(fname, []byte("segment"), 0644))
+
+	orch := &mockOrchestrator{}
+	orch.On("SpooledSegment", "abc").Return(fname, true)
+	orch.On("SpooledSegment", "gone").Return(filepath.Join(dir, "gone.ts"), true)
+	orch.On("SpooledSegment", "unknown").Return("", false)
+	lp := &lphttp{orchestrator: orch}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		lp.SpooledSegment(w, httptest.NewRequest("GET", core.SpooledSegmentPath+id, nil))
+		return w
+	}
+	w := get("abc")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("video/MP2T", w.Header().Get("Content-Type"))
+	assert.Equal("7", w.Header().Get("Content-Length"))
+	assert.Equal("segment", w.Body.String())
+
+	assert.Equal(http.StatusNotFound, get("gone").Code)
+	assert.Equal(http.StatusNotFound, get("unknown").Code)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+type profilingState struct {
+	lock      sync.Mutex
+	Enabled   bool `json:"enabled"`
+	MutexRate int  `json:"mutexRate"`
+	BlockRate int  `json:"blockRate"`
+}
+
+var profiling = &profilingState{}
+
+// profilingHandler reports whether the pprof endpoints are enabled and the
+// mutex and block profiling rates. POSTing `enabled`, `mutexRate` and/or
+// `blockRate` changes them; see runtime.SetMutexProfileFraction and
+// runtime.SetBlockProfileRate for the rates, 0 turns profiling off.
+func profilingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profiling.lock.Lock()
+		defer profiling.lock.Unlock()
+		if r.Method == "POST" {
+			if err := r.ParseForm(); err != nil {
+				respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+				return
+			}
+			enabled := profiling.Enabled
+			if v := r.FormValue("enabled"); v != "" {
+				var err error
+				if enabled, err = strconv.ParseBool(v); err != nil {
+					respondWith400(w, fmt.Sprintf("invalid enabled: %v", v))
+					return
+				}
+			}
+			mutexRate, ok := profilingRate(w, r, "mutexRate", profiling.MutexRate)
+			if !ok {
+				return
+			}
+			blockRate, ok := profilingRate(w, r, "blockRate", profiling.BlockRate)
+			if !ok {
+				return
+			}
+			profiling.Enabled = enabled
+			if mutexRate != profiling.MutexRate {
+				runtime.SetMutexProfileFraction(mutexRate)
+				profiling.MutexRate = mutexRate
+			}
+			if blockRate != profiling.BlockRate {
+				runtime.SetBlockProfileRate(blockRate)
+				profiling.BlockRate = blockRate
+			}
+			glog.Infof("Profiling updated enabled=%v mutexRate=%d blockRate=%d", enabled, mutexRate, blockRate)
+		}
+		respondWithJSON(w, profiling)
+	})
+}
+
+func profilingRate(w http.ResponseWriter, r *http.Request, param string, current int) (int, bool) {
+	v := r.FormValue(param)
+	if v == "" {
+		return current, true
+	}
+	rate, err := strconv.Atoi(v)
+	if err != nil || rate < 0 {
+		respondWith400(w, fmt.Sprintf("invalid %s: %v", param, v))
+		return 0, false
+	}
+	return rate, true
+}
+
+// pprofHandler serves the net/http/pprof endpoints under /debug/pprof/ while
+// profiling is enabled
+func pprofHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		profiling.lock.Lock()
+		enabled := profiling.Enabled
+		profiling.lock.Unlock()
+		if !enabled {
+			respondWithError(w, "profiling disabled", http.StatusNotFound)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/debug/pprof/") {
+		case "cmdline":
+			pprof.Cmdline(w, r)
+		case "profile":
+			pprof.Profile(w, r)
+		case "symbol":
+			pprof.Symbol(w, r)
+		case "trace":
+			pprof.Trace(w, r)
+		default:
+			pprof.Index(w, r)
+		}
+	})
+}
+
+// dumpHandler responds with a dump of the `profile` named in the query, e.g.
+// heap or goroutine, whether or not profiling is enabled. `debug=1` or 2
+// dumps as text rather than in pprof's format.
+func dumpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("profile")
+		if name == "" {
+			name = "heap"
+		}
+		p := rpprof.Lookup(name)
+		if p == nil {
+			respondWith400(w, fmt.Sprintf("unknown profile: %v", name))
+			return
+		}
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if name == "heap" {
+			runtime.GC()
+		}
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.pprof"`, name, time.Now().Format("20060102T150405")))
+		}
+		glog.Infof("Dumping profile=%s", name)
+		if err := p.WriteTo(w, debug); err != nil {
+			glog.Errorf("Error dumping profile=%s err=%v", name, err)
+		}
+	})
+}
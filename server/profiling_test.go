@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMustHaveAdminToken(t *testing.T) {
+	assert := assert.New(t)
+	defer func(token string) { AdminToken = token }(AdminToken)
+
+	handler := mustHaveAdminToken(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	reqAuth := func(auth string) int {
+		r := httptest.NewRequest("GET", "http://example.com/debug/profiling", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	req := func(token string) int {
+		if token == "" {
+			return reqAuth("")
+		}
+		return reqAuth("Bearer " + token)
+	}
+
+	AdminToken = ""
+	assert.Equal(http.StatusForbidden, req("foo"))
+
+	AdminToken = "secret"
+	assert.Equal(http.StatusUnauthorized, req(""))
+	assert.Equal(http.StatusUnauthorized, req("foo"))
+	assert.Equal(http.StatusOK, req("secret"))
+	// The token has to be sent with the Bearer scheme
+	assert.Equal(http.StatusUnauthorized, reqAuth("secret"))
+	assert.Equal(http.StatusUnauthorized, reqAuth("Basic secret"))
+	assert.Equal(http.StatusUnauthorized, reqAuth("bearer secret"))
+}
+
+func TestProfilingHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func() { profiling = &profilingState{} }()
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "http://example.com/debug/profiling", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		profilingHandler().ServeHTTP(w, r)
+		return w
+	}
+	pprofCode := func() int {
+		w := httptest.NewRecorder()
+		pprofHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/debug/pprof/", nil))
+		return w.Code
+	}
+
+	assert.Equal(http.StatusNotFound, pprofCode())
+
+	w := post(url.Values{"enabled": {"true"}, "mutexRate": {"5"}})
+	require.Equal(http.StatusOK, w.Code)
+	var state profilingState
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &state))
+	assert.True(state.Enabled)
+	assert.Equal(5, state.MutexRate)
+	assert.Equal(0, state.BlockRate)
+	assert.Equal(http.StatusOK, pprofCode())
+
+	assert.Equal(http.StatusBadRequest, post(url.Values{"enabled": {"foo"}}).Code)
+	assert.Equal(http.StatusBadRequest, post(url.Values{"blockRate": {"-1"}}).Code)
+	assert.True(profiling.Enabled)
+
+	w = post(url.Values{"enabled": {"false"}, "mutexRate": {"0"}})
+	require.Equal(http.StatusOK, w.Code)
+	assert.False(profiling.Enabled)
+	assert.Equal(http.StatusNotFound, pprofCode())
+}
+
+func TestDumpHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	w := httptest.NewRecorder()
+	dumpHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/debug/dump?profile=goroutine&debug=1", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "goroutine profile")
+
+	w = httptest.NewRecorder()
+	dumpHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/debug/dump", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Header().Get("Content-Disposition"), "heap-")
+
+	w = httptest.NewRecorder()
+	dumpHandler().ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/debug/dump?profile=foo", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
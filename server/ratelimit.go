@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// RateLimit allows Rate requests a second, in bursts of up to Burst; any
+// number if Rate is 0
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses `rate` or `rate:burst`, the rate in requests a
+// second; the burst defaults to the rate, rounded up
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+	parts := strings.SplitN(s, ":", 2)
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %v; must be rate or rate:burst", s)
+	}
+	l := RateLimit{Rate: rate, Burst: int(math.Ceil(rate))}
+	if len(parts) == 2 {
+		if l.Burst, err = strconv.Atoi(parts[1]); err != nil || l.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit burst %v", parts[1])
+		}
+	}
+	return l, nil
+}
+
+func (l RateLimit) String() string {
+	if l.Rate == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v:%d", l.Rate, l.Burst)
+}
+
+// Rate limits of each IP address and of all requests together, for each of
+// the public endpoints of the orchestrator, its RPC such as GetOrchestrator
+// and segment submission, and for the HLS endpoints of the broadcaster.
+// Requests over them are refused with 429.
+var (
+	OrchestratorRateLimitPerIP RateLimit
+	OrchestratorRateLimit      RateLimit
+	HLSRateLimitPerIP          RateLimit
+	HLSRateLimit               RateLimit
+)
+
+// rateLimitSweepInterval is how often the buckets of the IP addresses that
+// have been idle long enough to be full again are dropped
+var rateLimitSweepInterval = time.Minute
+
+// tokenBucket is Burst tokens refilled at Rate a second, a token taken by
+// each request allowed
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(l RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: float64(l.Burst), last: now}
+}
+
+func (b *tokenBucket) refill(l RateLimit, now time.Time) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+}
+
+// wait is how long until the bucket has a token
+func (b *tokenBucket) wait(l RateLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// rateLimiter limits the requests to the endpoints named endpoint, in the
+// metrics, by IP address and globally
+type rateLimiter struct {
+	endpoint      string
+	perIP, global RateLimit
+
+	mu        sync.Mutex
+	all       *tokenBucket
+	ips       map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(endpoint string, perIP, global RateLimit) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		endpoint:  endpoint,
+		perIP:     perIP,
+		global:    global,
+		all:       newTokenBucket(global, now),
+		ips:       make(map[string]*tokenBucket),
+		lastSweep: now,
+	}
+}
+
+// allow takes a token for a request from ip, unless it's over either limit.
+// Returns the scope of the limit it's over, and how long until it isn't.
+func (l *rateLimiter) allow(ip string, now time.Time) (string, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b *tokenBucket
+	if l.perIP.Rate > 0 {
+		if b = l.ips[ip]; b == nil {
+			b = newTokenBucket(l.perIP, now)
+			l.ips[ip] = b
+		}
+		b.refill(l.perIP, now)
+		if wait := b.wait(l.perIP); wait > 0 {
+			return "ip", wait
+		}
+	}
+	if l.global.Rate > 0 {
+		l.all.refill(l.global, now)
+		if wait := l.all.wait(l.global); wait > 0 {
+			return "global", wait
+		}
+		l.all.tokens--
+	}
+	if b != nil {
+		b.tokens--
+	}
+
+	if now.Sub(l.lastSweep) >= rateLimitSweepInterval {
+		for addr, bucket := range l.ips {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*l.perIP.Rate >= float64(l.perIP.Burst) {
+				delete(l.ips, addr)
+			}
+		}
+		l.lastSweep = now
+	}
+	return "", 0
+}
+
+func (l *rateLimiter) enabled() bool {
+	return l != nil && (l.perIP.Rate > 0 || l.global.Rate > 0)
+}
+
+// refused records a request refused for being over the limit of scope
+func (l *rateLimiter) refused(ctx context.Context, ip, scope string) {
+	glog.V(common.DEBUG).Infof("Rate limited request endpoint=%s ip=%s scope=%s requestID=%s", l.endpoint, ip, scope, common.RequestID(ctx))
+	if monitor.Enabled {
+		monitor.RateLimited(l.endpoint, scope)
+	}
+}
+
+// handler refuses the requests to h over the limits with 429, and a
+// Retry-After of when they'd be allowed
+func (l *rateLimiter) handler(h http.Handler) http.Handler {
+	if !l.enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r.RemoteAddr)
+		if scope, wait := l.allow(ip, time.Now()); scope != "" {
+			l.refused(r.Context(), ip, scope)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			respondWithError(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// unaryInterceptor refuses the gRPC calls over the limits with
+// ResourceExhausted, and a Retry-After trailer of when they'd be allowed
+func (l *rateLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !l.enabled() {
+		return handler(ctx, req)
+	}
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok {
+		ip = remoteIP(p.Addr.String())
+	}
+	if scope, wait := l.allow(ip, time.Now()); scope != "" {
+		l.refused(ctx, ip, scope)
+		return nil, resourceExhausted(ctx, wait, "rate limit exceeded; retry in %v", wait.Round(time.Millisecond))
+	}
+	return handler(ctx, req)
+}
+
+// remoteIP is the IP address of addr, without the port
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	l, err := ParseRateLimit("")
+	require.Nil(err)
+	assert.Equal(RateLimit{}, l)
+	l, err = ParseRateLimit("2.5")
+	require.Nil(err)
+	assert.Equal(RateLimit{Rate: 2.5, Burst: 3}, l)
+	l, err = ParseRateLimit("10:50")
+	require.Nil(err)
+	assert.Equal(RateLimit{Rate: 10, Burst: 50}, l)
+	assert.Equal("10:50", l.String())
+
+	for _, s := range []string{"abc", "-1", "10:0", "10:x"} {
+		_, err = ParseRateLimit(s)
+		assert.NotNil(err, s)
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	l := newRateLimiter("test", RateLimit{Rate: 1, Burst: 2}, RateLimit{Rate: 10, Burst: 3})
+
+	// The bursts are allowed, then requests over the rate are refused until
+	// the buckets refill
+	scope, _ := l.allow("a", now)
+	assert.Equal("", scope)
+	scope, _ = l.allow("a", now)
+	assert.Equal("", scope)
+	scope, wait := l.allow("a", now)
+	assert.Equal("ip", scope)
+	assert.Equal(time.Second, wait)
+	scope, _ = l.allow("b", now)
+	assert.Equal("", scope)
+	scope, wait = l.allow("c", now)
+	assert.Equal("global", scope)
+	assert.Equal(100*time.Millisecond, wait)
+
+	// Refused requests don't take tokens
+	scope, _ = l.allow("c", now.Add(100*time.Millisecond))
+	assert.Equal("", scope)
+	scope, _ = l.allow("a", now.Add(time.Second))
+	assert.Equal("", scope)
+
+	// The buckets of idle IP addresses are dropped
+	l.allow("d", now.Add(2*rateLimitSweepInterval))
+	assert.Len(l.ips, 1)
+}
+
+func TestRateLimiter_Handler(t *testing.T) {
+	assert := assert.New(t)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	req := func(handler http.Handler, remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "http://example.com/stream/abc.m3u8", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	// Without limits the handler is served as is
+	handler := newRateLimiter("hls", RateLimit{}, RateLimit{}).handler(h)
+	for i := 0; i < 10; i++ {
+		assert.Equal(http.StatusOK, req(handler, "1.2.3.4:1234").Code)
+	}
+
+	handler = newRateLimiter("hls", RateLimit{Rate: 0.5, Burst: 1}, RateLimit{}).handler(h)
+	assert.Equal(http.StatusOK, req(handler, "1.2.3.4:1234").Code)
+	w := req(handler, "1.2.3.4:5678")
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("2", w.Header().Get("Retry-After"))
+	assert.Equal(http.StatusOK, req(handler, "5.6.7.8:1234").Code)
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"math/big"
 	"net/http"
@@ -10,8 +11,12 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/drivers"
 	"github.com/livepeer/go-livepeer/net"
@@ -22,7 +27,7 @@ import (
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 
-	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/pkg/errors"
 )
 
@@ -33,16 +38,19 @@ const HTTPTimeout = 8 * time.Second
 type Orchestrator interface {
 	ServiceURI() *url.URL
 	Address() ethcommon.Address
-	TranscoderSecret() string
+	AuthenticateTranscoder(secret string) (*core.TranscoderToken, bool)
 	Sign([]byte) ([]byte, error)
 	VerifySig(ethcommon.Address, string, []byte) bool
 	CurrentBlock() *big.Int
 	CheckCapacity(core.ManifestID) error
 	TranscodeSeg(*core.SegTranscodingMetadata, *stream.HLSSegment) (*core.TranscodeResult, error)
-	ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, capacity int)
+	ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, id string, capacity core.RemoteTranscoderCapacity, token *core.TranscoderToken) error
 	TranscoderResults(job int64, res *core.RemoteTranscoderResult)
+	UpdateTranscoderCapacity(stream net.Transcoder_RegisterTranscoderServer, capacity core.RemoteTranscoderCapacity) error
+	SpooledSegment(id string) (string, bool)
 	ProcessPayment(payment net.Payment, manifestID core.ManifestID) error
 	TicketParams(sender ethcommon.Address) *net.TicketParams
+	CheckSender(sender ethcommon.Address) error
 }
 
 type Broadcaster interface {
@@ -60,12 +68,18 @@ type BroadcastSession struct {
 	BroadcasterOS    drivers.OSSession
 	Sender           pm.Sender
 	PMSessionID      string
+	Database         *common.DB
+	// Priority the orchestrator routes the segments of the stream to its
+	// remote transcoders by
+	Priority core.SegmentPriority
 }
 
 type lphttp struct {
 	orchestrator Orchestrator
 	orchRPC      *grpc.Server
 	transRPC     *http.ServeMux
+	// workDir is where the segments received are spooled to
+	workDir string
 }
 
 // grpc methods
@@ -78,8 +92,25 @@ func (h *lphttp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *lphttp) GetOrchestrator(context context.Context, req *net.OrchestratorRequest) (*net.OrchestratorInfo, error) {
-	return getOrchestrator(h.orchestrator, req)
+func (h *lphttp) GetOrchestrator(ctx context.Context, req *net.OrchestratorRequest) (*net.OrchestratorInfo, error) {
+	info, err := getOrchestrator(h.orchestrator, req)
+	if err != nil {
+		glog.Errorf("Error getting orchestrator info requestID=%s err=%v", grpcRequestID(ctx), err)
+		if capErr, ok := err.(*core.CapacityError); ok {
+			return nil, capacityStatus(ctx, capErr)
+		}
+	}
+	return info, err
+}
+
+// grpcRequestID returns the request ID sent by the caller of a gRPC method
+func grpcRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDCarrierKey); len(ids) > 0 && common.ValidRequestID(ids[0]) {
+			return ids[0]
+		}
+	}
+	return common.RequestID(ctx)
 }
 
 func (h *lphttp) Ping(context context.Context, req *net.PingPong) (*net.PingPong, error) {
@@ -88,32 +119,54 @@ func (h *lphttp) Ping(context context.Context, req *net.PingPong) (*net.PingPong
 
 // XXX do something about the implicit start of the http mux? this smells
 func StartTranscodeServer(orch Orchestrator, bind string, mux *http.ServeMux, workDir string, acceptRemoteTranscoders bool) {
-	s := grpc.NewServer()
+	rpcLimiter := newRateLimiter("rpc", OrchestratorRateLimitPerIP, OrchestratorRateLimit)
+	segLimiter := newRateLimiter("segment", OrchestratorRateLimitPerIP, OrchestratorRateLimit)
+	s := grpc.NewServer(grpc.UnaryInterceptor(rpcLimiter.unaryInterceptor))
 	lp := lphttp{
 		orchestrator: orch,
 		orchRPC:      s,
 		transRPC:     mux,
+		workDir:      workDir,
 	}
 	net.RegisterOrchestratorServer(s, &lp)
-	lp.transRPC.HandleFunc("/segment", lp.ServeSegment)
+	lp.transRPC.Handle("/segment", segLimiter.handler(http.HandlerFunc(lp.ServeSegment)))
 	if acceptRemoteTranscoders {
 		net.RegisterTranscoderServer(s, &lp)
 		lp.transRPC.HandleFunc("/transcodeResults", lp.TranscodeResults)
+		lp.transRPC.HandleFunc(core.SpooledSegmentPath, lp.SpooledSegment)
 	}
 
-	cert, key, err := getCert(orch.ServiceURI(), workDir)
-	if err != nil {
-		return // XXX return error
-	}
-
-	glog.Info("Listening for RPC on ", bind)
 	srv := http.Server{
 		Addr:    bind,
-		Handler: &lp,
+		Handler: withRequestID(&lp),
+		// Slow clients mustn't hold connections open sending headers
+		ReadHeaderTimeout: HTTPTimeout,
 		// XXX doesn't handle streaming RPC well; split remote transcoder RPC?
 		//ReadTimeout:  HTTPTimeout,
 		//WriteTimeout: HTTPTimeout,
 	}
+
+	if ACMEChallenge != "" {
+		certs, err := newACMECerts(orch.ServiceURI().Hostname(), workDir)
+		if err == nil {
+			go certs.renewLoop()
+			srv.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+
+			glog.Info("Listening for RPC on ", bind)
+			srv.ListenAndServeTLS("", "")
+			return
+		}
+		// Broadcasters don't verify the certificate; don't leave them with
+		// no orchestrator at all
+		glog.Errorf("Unable to get ACME certificate, falling back to a self-signed one: %v", err)
+	}
+
+	cert, key, err := getCert(orch.ServiceURI(), workDir)
+	if err != nil {
+		return // XXX return error
+	}
+
+	glog.Info("Listening for RPC on ", bind)
 	srv.ListenAndServeTLS(cert, key)
 }
 
@@ -157,16 +210,35 @@ func ping(context context.Context, req *net.PingPong, orch Orchestrator) (*net.P
 
 // GetOrchestratorInfo - the broadcaster calls GetOrchestratorInfo which invokes GetOrchestrator on the orchestrator
 func GetOrchestratorInfo(ctx context.Context, bcast Broadcaster, orchestratorServer *url.URL) (*net.OrchestratorInfo, error) {
+	// Orchestrators at capacity are left alone until they said to retry
+	if wait, ok := capacityBackoff.backingOff(orchestratorServer, time.Now()); ok {
+		return nil, fmt.Errorf("%v; retry in %v", errOrchBackingOff, wait.Round(time.Second))
+	}
+
 	c, conn, err := startOrchestratorClient(orchestratorServer)
 	if err != nil {
 		return nil, err
 	}
 	defer conn.Close()
 
+	id := common.RequestID(ctx)
+	if id == "" {
+		id = common.NewRequestID()
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, requestIDCarrierKey, id)
+
 	req, err := genOrchestratorReq(bcast)
-	r, err := c.GetOrchestrator(ctx, req)
+	var trailer metadata.MD
+	r, err := c.GetOrchestrator(ctx, req, grpc.Trailer(&trailer))
 	if err != nil {
-		glog.Errorf("Could not get orchestrator %v: %v", orchestratorServer, err)
+		glog.Errorf("Could not get orchestrator %v requestID=%s: %v", orchestratorServer, id, err)
+		if status.Code(err) == codes.ResourceExhausted {
+			var retryAfter time.Duration
+			if vals := trailer.Get(retryAfterTrailer); len(vals) > 0 {
+				retryAfter = parseRetryAfter(vals[0])
+			}
+			capacityBackoff.backoff(orchestratorServer.String(), retryAfter, time.Now())
+		}
 		return nil, errors.New("Could not get orchestrator: " + err.Error())
 	}
 
@@ -199,8 +271,16 @@ func genOrchestratorReq(b Broadcaster) (*net.OrchestratorRequest, error) {
 func getOrchestrator(orch Orchestrator, req *net.OrchestratorRequest) (*net.OrchestratorInfo, error) {
 	addr := ethcommon.BytesToAddress(req.Address)
 	if err := verifyOrchestratorReq(orch, addr, req.Sig); err != nil {
+		if _, ok := err.(*core.CapacityError); ok {
+			return nil, err
+		}
 		return nil, fmt.Errorf("Invalid orchestrator request (%v)", err)
 	}
+	// Refuse underfunded broadcasters before they send any segments
+	if err := orch.CheckSender(addr); err != nil {
+		glog.Errorf("Refusing broadcaster=%s: %v", addr.Hex(), err)
+		return nil, fmt.Errorf("Insufficient broadcaster funds (%v)", err)
+	}
 
 	tr := net.OrchestratorInfo{
 		Transcoder:   orch.ServiceURI().String(), // currently,  orchestrator == transcoder
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"net/http"
 	"net/url"
 	"testing"
 	"time"
@@ -33,6 +34,7 @@ type stubOrchestrator struct {
 	block      *big.Int
 	signErr    error
 	sessCapErr error
+	senderErr  error
 }
 
 func (r *stubOrchestrator) ServiceURI() *url.URL {
@@ -75,6 +77,10 @@ func (r *stubOrchestrator) TicketParams(sender ethcommon.Address) *net.TicketPar
 	return nil
 }
 
+func (r *stubOrchestrator) CheckSender(sender ethcommon.Address) error {
+	return r.senderErr
+}
+
 func newStubOrchestrator() *stubOrchestrator {
 	pk, err := ethcrypto.GenerateKey()
 	if err != nil {
@@ -86,12 +92,19 @@ func newStubOrchestrator() *stubOrchestrator {
 func (r *stubOrchestrator) CheckCapacity(mid core.ManifestID) error {
 	return r.sessCapErr
 }
-func (r *stubOrchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, capacity int) {
+func (r *stubOrchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, id string, capacity core.RemoteTranscoderCapacity, token *core.TranscoderToken) error {
+	return nil
 }
 func (r *stubOrchestrator) TranscoderResults(job int64, res *core.RemoteTranscoderResult) {
 }
-func (r *stubOrchestrator) TranscoderSecret() string {
-	return ""
+func (r *stubOrchestrator) UpdateTranscoderCapacity(stream net.Transcoder_RegisterTranscoderServer, capacity core.RemoteTranscoderCapacity) error {
+	return nil
+}
+func (r *stubOrchestrator) SpooledSegment(id string) (string, bool) {
+	return "", false
+}
+func (r *stubOrchestrator) AuthenticateTranscoder(secret string) (*core.TranscoderToken, bool) {
+	return nil, false
 }
 func stubBroadcaster2() *stubOrchestrator {
 	return newStubOrchestrator() // lazy; leverage subtyping for interface commonalities
@@ -414,6 +427,23 @@ func TestGetOrchestrator_GivenValidSig_ReturnsOrchTicketParams(t *testing.T) {
 	assert.Equal(expectedParams, oInfo.TicketParams)
 }
 
+func TestGetOrchestrator_GivenUnderfundedSender_ReturnsError(t *testing.T) {
+	assert := assert.New(t)
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+	o := newStubOrchestrator()
+	req, err := genOrchestratorReq(stubBroadcaster2())
+	assert.Nil(err)
+
+	o.senderErr = fmt.Errorf("zero deposit and reserve")
+	oInfo, err := getOrchestrator(o, req)
+	assert.Nil(oInfo)
+	assert.Contains(err.Error(), "Insufficient broadcaster funds")
+
+	o.senderErr = nil
+	_, err = getOrchestrator(o, req)
+	assert.Nil(err)
+}
+
 type mockOSSession struct {
 	mock.Mock
 }
@@ -455,9 +485,10 @@ func (o *mockOrchestrator) Address() ethcommon.Address {
 	o.Called()
 	return ethcommon.Address{}
 }
-func (o *mockOrchestrator) TranscoderSecret() string {
-	o.Called()
-	return ""
+func (o *mockOrchestrator) AuthenticateTranscoder(secret string) (*core.TranscoderToken, bool) {
+	args := o.Called(secret)
+	token, _ := args.Get(0).(*core.TranscoderToken)
+	return token, args.Bool(1)
 }
 func (o *mockOrchestrator) Sign(msg []byte) ([]byte, error) {
 	o.Called(msg)
@@ -481,8 +512,17 @@ func (o *mockOrchestrator) TranscodeSeg(md *core.SegTranscodingMetadata, seg *st
 
 	return res, args.Error(1)
 }
-func (o *mockOrchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, capacity int) {
+func (o *mockOrchestrator) ServeTranscoder(stream net.Transcoder_RegisterTranscoderServer, id string, capacity core.RemoteTranscoderCapacity, token *core.TranscoderToken) error {
 	o.Called(stream)
+	return nil
+}
+func (o *mockOrchestrator) UpdateTranscoderCapacity(stream net.Transcoder_RegisterTranscoderServer, capacity core.RemoteTranscoderCapacity) error {
+	args := o.Called(stream, capacity)
+	return args.Error(0)
+}
+func (o *mockOrchestrator) SpooledSegment(id string) (string, bool) {
+	args := o.Called(id)
+	return args.String(0), args.Bool(1)
 }
 func (o *mockOrchestrator) TranscoderResults(job int64, res *core.RemoteTranscoderResult) {
 	o.Called(job, res)
@@ -504,6 +544,10 @@ func (o *mockOrchestrator) CheckCapacity(mid core.ManifestID) error {
 	return nil
 }
 
+func (o *mockOrchestrator) CheckSender(sender ethcommon.Address) error {
+	return nil
+}
+
 func defaultTicketParams() *net.TicketParams {
 	return &net.TicketParams{
 		Recipient:         pm.RandBytes(123),
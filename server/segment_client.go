@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+	"golang.org/x/net/http2"
+)
+
+// Settings of the HTTP client broadcasters submit segments to orchestrators
+// with, read when the first segment is submitted. By default the segments for
+// an orchestrator are multiplexed over one HTTP/2 connection, where a slow
+// upload holds up the streams behind it; broadcasters with many streams can
+// set SegmentHTTP2 false to spread them over a pool of HTTP/1.1 connections
+// instead, up to SegmentMaxConnsPerHost of them per orchestrator (unlimited
+// if 0), keeping SegmentMaxIdleConnsPerHost open between segments for
+// SegmentIdleConnTimeout so they aren't dialed over and over.
+var (
+	SegmentHTTP2                 = true
+	SegmentMaxConnsPerHost       = 0
+	SegmentMaxIdleConnsPerHost   = 8
+	SegmentIdleConnTimeout       = 90 * time.Second
+	SegmentTLSHandshakeTimeout   = 5 * time.Second
+	SegmentResponseHeaderTimeout = HTTPTimeout
+)
+
+var (
+	segmentClientOnce sync.Once
+	segmentClient     *http.Client
+)
+
+// segmentHTTPClient returns the client segments are submitted with
+func segmentHTTPClient() *http.Client {
+	segmentClientOnce.Do(func() {
+		segmentClient = newSegmentClient()
+	})
+	return segmentClient
+}
+
+func newSegmentClient() *http.Client {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   HTTPTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig.Clone(),
+		TLSHandshakeTimeout:   SegmentTLSHandshakeTimeout,
+		MaxConnsPerHost:       SegmentMaxConnsPerHost,
+		MaxIdleConnsPerHost:   SegmentMaxIdleConnsPerHost,
+		IdleConnTimeout:       SegmentIdleConnTimeout,
+		ResponseHeaderTimeout: SegmentResponseHeaderTimeout,
+	}
+	if SegmentHTTP2 {
+		if err := http2.ConfigureTransport(t); err != nil {
+			glog.Errorf("Could not set up HTTP/2 for segments, falling back to HTTP/1.1: %v", err)
+		}
+	} else {
+		// Without HTTP/2 being negotiated over TLS
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return &http.Client{
+		Transport: t,
+		Timeout:   HTTPTimeout,
+	}
+}
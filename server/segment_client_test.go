@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSegmentClient(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(h2 bool, conns, idle int, idleTimeout, handshake, header time.Duration) {
+		SegmentHTTP2, SegmentMaxConnsPerHost, SegmentMaxIdleConnsPerHost = h2, conns, idle
+		SegmentIdleConnTimeout, SegmentTLSHandshakeTimeout, SegmentResponseHeaderTimeout = idleTimeout, handshake, header
+	}(SegmentHTTP2, SegmentMaxConnsPerHost, SegmentMaxIdleConnsPerHost, SegmentIdleConnTimeout, SegmentTLSHandshakeTimeout, SegmentResponseHeaderTimeout)
+
+	SegmentMaxConnsPerHost, SegmentMaxIdleConnsPerHost = 16, 4
+	SegmentIdleConnTimeout, SegmentTLSHandshakeTimeout, SegmentResponseHeaderTimeout = time.Minute, time.Second, 2*time.Second
+	client := newSegmentClient()
+	assert.Equal(HTTPTimeout, client.Timeout)
+	tr, ok := client.Transport.(*http.Transport)
+	require.True(ok)
+	assert.Equal(16, tr.MaxConnsPerHost)
+	assert.Equal(4, tr.MaxIdleConnsPerHost)
+	assert.Equal(time.Minute, tr.IdleConnTimeout)
+	assert.Equal(time.Second, tr.TLSHandshakeTimeout)
+	assert.Equal(2*time.Second, tr.ResponseHeaderTimeout)
+	// The config shared with the gRPC clients isn't modified
+	assert.Empty(tlsConfig.NextProtos)
+
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	var proto int
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		proto = r.ProtoMajor
+	})
+
+	resp, err := client.Post(ts.URL+"/segment", "video/MP2T", nil)
+	require.Nil(err)
+	resp.Body.Close()
+	assert.Equal(2, proto)
+
+	// Over HTTP/1.1 when HTTP/2 is off
+	SegmentHTTP2 = false
+	resp, err = newSegmentClient().Post(ts.URL+"/segment", "video/MP2T", nil)
+	require.Nil(err)
+	resp.Body.Close()
+	assert.Equal(1, proto)
+
+	// Headers that don't arrive in time
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	})
+	SegmentResponseHeaderTimeout = 100 * time.Millisecond
+	_, err = newSegmentClient().Post(ts.URL+"/slow", "video/MP2T", nil)
+	assert.NotNil(err)
+}
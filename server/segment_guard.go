@@ -0,0 +1,345 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+	"github.com/livepeer/go-livepeer/monitor"
+	"golang.org/x/crypto/sha3"
+)
+
+// Limits on the segments submitted to an orchestrator, so no broadcaster can
+// tie it up: each stream of a broadcaster may upload MaxSegmentUploads
+// segments at once, each no larger than a segment of MaxSegmentResolution would be and within
+// SegmentUploadTimeout. Broadcasters and IP addresses that break the limits
+// SegmentBanViolations times within SegmentBanWindow are banned from
+// submitting segments for SegmentBanDuration.
+var (
+	MaxSegmentUploads    = 8
+	MaxSegmentResolution = "3840x2160"
+	SegmentUploadTimeout = HTTPTimeout
+	SegmentBanViolations = 5
+	SegmentBanWindow     = time.Minute
+	SegmentBanDuration   = 5 * time.Minute
+)
+
+// ValidateSegments is whether orchestrators check the container of segments
+// before transcoding them, rejecting those that aren't well formed MPEG-TS or
+// MP4 as violations
+var ValidateSegments = true
+
+// segmentBytesPerPixel is the size a segment may be for each pixel of its
+// resolution: ~50MB for 1080p, far above the bitrate of a few seconds of
+// any sane encoding
+const segmentBytesPerPixel = 24
+
+// maxSegmentURILength is the size the body may be of a segment submitted as
+// the URI it's stored at
+const maxSegmentURILength = 4096
+
+// maxSegmentErrorLength is the size of the error read from orchestrators
+// that refuse a segment
+const maxSegmentErrorLength = 4096
+
+// Codes of the errors the segment endpoint refuses violators with
+const (
+	segErrBanned         = "banned"
+	segErrTooManyUploads = "too_many_uploads"
+	segErrTooLarge       = "segment_too_large"
+	segErrUploadTimeout  = "upload_timeout"
+	segErrInvalidSegment = "invalid_segment"
+)
+
+var (
+	errSegmentTooLarge      = errors.New("segment exceeds the maximum size")
+	errSegmentUploadTimeout = errors.New("segment upload timed out")
+)
+
+// segmentError is the body of the responses refusing segments for breaking
+// the limits
+type segmentError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+	// RetryAfter is the seconds until the segment may be submitted again
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func respondSegmentError(w http.ResponseWriter, status int, code, msg string, retryAfter time.Duration) {
+	e := segmentError{Code: code, Error: msg}
+	if retryAfter > 0 {
+		e.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfter))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// maxSegmentSize is the size a segment of MaxSegmentResolution may be;
+// unlimited if the resolution isn't set
+func maxSegmentSize() int64 {
+	var width, height int64
+	if _, err := fmt.Sscanf(MaxSegmentResolution, "%dx%d", &width, &height); err != nil {
+		return 0
+	}
+	return width * height * segmentBytesPerPixel
+}
+
+// ParseResolution checks a resolution is given as WIDTHxHEIGHT
+func ParseResolution(s string) error {
+	var width, height int
+	if _, err := fmt.Sscanf(s, "%dx%d", &width, &height); err != nil || width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid resolution %v; must be WIDTHxHEIGHT", s)
+	}
+	return nil
+}
+
+// segmentGuard tracks the uploads of each stream, the times broadcasters and
+// IP addresses broke the limits, and those banned for it
+type segmentGuard struct {
+	mu         sync.Mutex
+	uploads    map[string]int
+	violations map[string][]time.Time
+	bans       map[string]time.Time
+	// swept is when violations and bans that expired were last removed
+	swept time.Time
+}
+
+func newSegmentGuard() *segmentGuard {
+	return &segmentGuard{
+		uploads:    make(map[string]int),
+		violations: make(map[string][]time.Time),
+		bans:       make(map[string]time.Time),
+	}
+}
+
+var segGuard = newSegmentGuard()
+
+// banned returns how long the first of keys that's banned still is
+func (g *segmentGuard) banned(now time.Time, keys ...string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		until, ok := g.bans[key]
+		if !ok {
+			continue
+		}
+		if now.Before(until) {
+			return until.Sub(now), true
+		}
+		delete(g.bans, key)
+	}
+	return 0, false
+}
+
+// uploadKey tells apart the streams of broadcasters, whose uploads are
+// limited separately
+func uploadKey(broadcaster, manifestID string) string {
+	return broadcaster + "/" + manifestID
+}
+
+// beginUpload counts an upload of the stream with key, unless it already has
+// MaxSegmentUploads; the upload must be ended once the segment is read
+func (g *segmentGuard) beginUpload(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if MaxSegmentUploads > 0 && g.uploads[key] >= MaxSegmentUploads {
+		return false
+	}
+	g.uploads[key]++
+	return true
+}
+
+func (g *segmentGuard) endUpload(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.uploads[key]--; g.uploads[key] <= 0 {
+		delete(g.uploads, key)
+	}
+}
+
+// violation records keys breaking a limit, banning those that did so
+// SegmentBanViolations times within SegmentBanWindow
+func (g *segmentGuard) violation(now time.Time, code string, keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sweep(now)
+	for _, key := range keys {
+		var recent []time.Time
+		for _, t := range g.violations[key] {
+			if now.Sub(t) < SegmentBanWindow {
+				recent = append(recent, t)
+			}
+		}
+		recent = append(recent, now)
+		if SegmentBanViolations <= 0 || len(recent) < SegmentBanViolations {
+			g.violations[key] = recent
+			continue
+		}
+		delete(g.violations, key)
+		g.bans[key] = now.Add(SegmentBanDuration)
+		glog.Warningf("Banned from submitting segments key=%s violation=%s duration=%v", key, code, SegmentBanDuration)
+		monitor.EmitEvent(monitor.EventSegmentSenderBanned, map[string]interface{}{
+			"key":       key,
+			"violation": code,
+			"duration":  SegmentBanDuration.String(),
+		})
+	}
+}
+
+// sweep removes the violations and bans of keys that no longer count, at
+// most once per SegmentBanWindow, so that keys that broke a limit once aren't
+// kept forever. g.mu must be held.
+func (g *segmentGuard) sweep(now time.Time) {
+	if now.Sub(g.swept) < SegmentBanWindow {
+		return
+	}
+	g.swept = now
+	for key, times := range g.violations {
+		if len(times) == 0 || now.Sub(times[len(times)-1]) >= SegmentBanWindow {
+			delete(g.violations, key)
+		}
+	}
+	for key, until := range g.bans {
+		if !now.Before(until) {
+			delete(g.bans, key)
+		}
+	}
+}
+
+// readSegmentBody reads the body of r into memory, for bodies that are small
+// such as the URIs of segments, failing once it's over limit bytes, if limit
+// is set, or it takes longer than timeout to arrive
+func readSegmentBody(r *http.Request, limit int64, timeout time.Duration) ([]byte, error) {
+	if limit > 0 && r.ContentLength > limit {
+		return nil, errSegmentTooLarge
+	}
+	var body io.Reader = r.Body
+	if limit > 0 {
+		body = io.LimitReader(r.Body, limit+1)
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		data, err := ioutil.ReadAll(body)
+		read <- result{data, err}
+	}()
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case res := <-read:
+		if res.err == nil && limit > 0 && int64(len(res.data)) > limit {
+			return nil, errSegmentTooLarge
+		}
+		return res.data, res.err
+	case <-timer:
+		// The read is left to end with the connection
+		return nil, errSegmentUploadTimeout
+	}
+}
+
+// spooledSegment is a segment written to disk as it was received
+type spooledSegment struct {
+	fname string
+	// hash is the Keccak-256 of the segment, as the broadcaster signs it
+	hash []byte
+	size int64
+}
+
+// spoolSegmentBody spools the body of r to a file in dir, failing once it's
+// over limit bytes, if limit is set, or it takes longer than timeout to
+// arrive
+func spoolSegmentBody(r *http.Request, limit int64, timeout time.Duration, dir string) (*spooledSegment, error) {
+	if limit > 0 && r.ContentLength > limit {
+		return nil, errSegmentTooLarge
+	}
+	return spoolSegment(r.Body, limit, timeout, dir)
+}
+
+// spoolSegment writes body to a file in dir through a bounded buffer,
+// hashing it as it goes. On failure the file is removed; otherwise it's for
+// the caller to remove.
+func spoolSegment(body io.Reader, limit int64, timeout time.Duration, dir string) (*spooledSegment, error) {
+	f, err := ioutil.TempFile(dir, "segment-*.ts")
+	if err != nil {
+		return nil, err
+	}
+	src := body
+	if limit > 0 {
+		src = io.LimitReader(body, limit+1)
+	}
+
+	type result struct {
+		seg *spooledSegment
+		err error
+	}
+	spooled := make(chan result, 1)
+	go func() {
+		hash := sha3.NewLegacyKeccak256()
+		// The buffer copied through is all of the segment that's in memory
+		size, err := common.CopyBuffer(io.MultiWriter(f, hash), src)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && limit > 0 && size > limit {
+			err = errSegmentTooLarge
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			spooled <- result{err: err}
+			return
+		}
+		spooled <- result{seg: &spooledSegment{fname: f.Name(), hash: hash.Sum(nil), size: size}}
+	}()
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case res := <-spooled:
+		return res.seg, res.err
+	case <-timer:
+		// The spool is left to end with the connection, and cleaned up then
+		go func() {
+			if res := <-spooled; res.err == nil {
+				os.Remove(res.seg.fname)
+			}
+		}()
+		return nil, errSegmentUploadTimeout
+	}
+}
+
+// validateSpooledSegment checks the container of seg if ValidateSegments is
+// set, returning a *common.ContainerError if it's malformed
+func validateSpooledSegment(seg *spooledSegment) error {
+	if !ValidateSegments {
+		return nil
+	}
+	f, err := os.Open(seg.fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return common.ValidateContainer(f, seg.size)
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaxSegmentSize(t *testing.T) {
+	assert := assert.New(t)
+	defer func(res string) { MaxSegmentResolution = res }(MaxSegmentResolution)
+
+	MaxSegmentResolution = "1920x1080"
+	assert.Equal(int64(1920*1080*segmentBytesPerPixel), maxSegmentSize())
+	MaxSegmentResolution = ""
+	assert.Equal(int64(0), maxSegmentSize())
+
+	assert.Nil(ParseResolution("1280x720"))
+	for _, s := range []string{"", "1280", "1280x", "0x720", "axb"} {
+		assert.NotNil(ParseResolution(s), s)
+	}
+}
+
+func TestSegmentGuard_Uploads(t *testing.T) {
+	assert := assert.New(t)
+	defer func(n int) { MaxSegmentUploads = n }(MaxSegmentUploads)
+	MaxSegmentUploads = 2
+	g := newSegmentGuard()
+
+	assert.True(g.beginUpload("a"))
+	assert.True(g.beginUpload("a"))
+	assert.False(g.beginUpload("a"))
+	// Other streams have their own limit, even of the same broadcaster
+	assert.True(g.beginUpload("b"))
+	assert.NotEqual(uploadKey("a", "stream1"), uploadKey("a", "stream2"))
+
+	g.endUpload("a")
+	assert.True(g.beginUpload("a"))
+	g.endUpload("a")
+	g.endUpload("a")
+	g.endUpload("b")
+	assert.Empty(g.uploads)
+
+	MaxSegmentUploads = 0
+	for i := 0; i < 10; i++ {
+		assert.True(g.beginUpload("a"))
+	}
+}
+
+func TestSegmentGuard_Bans(t *testing.T) {
+	assert := assert.New(t)
+	defer func(n int, window, duration time.Duration) {
+		SegmentBanViolations, SegmentBanWindow, SegmentBanDuration = n, window, duration
+	}(SegmentBanViolations, SegmentBanWindow, SegmentBanDuration)
+	SegmentBanViolations, SegmentBanWindow, SegmentBanDuration = 3, time.Minute, 5*time.Minute
+	g := newSegmentGuard()
+	now := time.Now()
+
+	g.violation(now, segErrTooLarge, "a", "ip")
+	g.violation(now.Add(time.Second), segErrTooLarge, "a")
+	_, banned := g.banned(now, "a", "ip")
+	assert.False(banned)
+
+	// Violations older than the window don't count
+	g.violation(now.Add(2*time.Minute), segErrTooLarge, "a")
+	_, banned = g.banned(now.Add(2*time.Minute), "a")
+	assert.False(banned)
+
+	g.violation(now.Add(2*time.Minute), segErrTooLarge, "a")
+	g.violation(now.Add(2*time.Minute), segErrTooLarge, "a")
+	wait, banned := g.banned(now.Add(3*time.Minute), "ip", "a")
+	assert.True(banned)
+	assert.Equal(4*time.Minute, wait)
+	_, banned = g.banned(now.Add(3*time.Minute), "ip")
+	assert.False(banned)
+
+	// Bans lapse
+	_, banned = g.banned(now.Add(8*time.Minute), "a")
+	assert.False(banned)
+	assert.Empty(g.bans)
+}
+
+func TestSegmentGuard_Sweep(t *testing.T) {
+	assert := assert.New(t)
+	defer func(n int, window, duration time.Duration) {
+		SegmentBanViolations, SegmentBanWindow, SegmentBanDuration = n, window, duration
+	}(SegmentBanViolations, SegmentBanWindow, SegmentBanDuration)
+	SegmentBanViolations, SegmentBanWindow, SegmentBanDuration = 2, time.Minute, 5*time.Minute
+	g := newSegmentGuard()
+	now := time.Now()
+
+	g.violation(now, segErrTooLarge, "a")
+	g.violation(now, segErrTooLarge, "b", "b")
+	assert.Len(g.violations, 1)
+	assert.Len(g.bans, 1)
+
+	// Keys that haven't broken a limit since are forgotten, even if they're
+	// never looked up again
+	g.violation(now.Add(2*time.Minute), segErrTooLarge, "c")
+	assert.Equal([]string{"c"}, violationKeys(g.violations))
+	assert.Len(g.bans, 1)
+	g.violation(now.Add(6*time.Minute), segErrTooLarge, "c")
+	assert.Empty(g.bans)
+}
+
+func violationKeys(m map[string][]time.Time) []string {
+	var ks []string
+	for k := range m {
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestReadSegmentBody(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	r := httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	data, err := readSegmentBody(r, 10, time.Second)
+	require.Nil(err)
+	assert.Equal("0123456789", string(data))
+
+	// Over the limit by Content-Length, or by what's read without one
+	r = httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	_, err = readSegmentBody(r, 9, time.Second)
+	assert.Equal(errSegmentTooLarge, err)
+	r = httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	r.ContentLength = -1
+	_, err = readSegmentBody(r, 9, time.Second)
+	assert.Equal(errSegmentTooLarge, err)
+
+	// Bodies that trickle in time out
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	r = httptest.NewRequest("POST", "/segment", pr)
+	go pw.Write([]byte("01"))
+	_, err = readSegmentBody(r, 10, 50*time.Millisecond)
+	assert.Equal(errSegmentUploadTimeout, err)
+}
+
+func TestSpoolSegmentBody(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "spool")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	spooled := func() []os.FileInfo {
+		files, err := ioutil.ReadDir(dir)
+		require.Nil(err)
+		return files
+	}
+
+	r := httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	seg, err := spoolSegmentBody(r, 10, time.Second, dir)
+	require.Nil(err)
+	data, err := ioutil.ReadFile(seg.fname)
+	require.Nil(err)
+	assert.Equal("0123456789", string(data))
+	assert.Equal(crypto.Keccak256(data), seg.hash)
+	assert.Equal(int64(10), seg.size)
+	os.Remove(seg.fname)
+
+	// Nothing is left behind by segments over the limit
+	r = httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	_, err = spoolSegmentBody(r, 9, time.Second, dir)
+	assert.Equal(errSegmentTooLarge, err)
+	r = httptest.NewRequest("POST", "/segment", strings.NewReader("0123456789"))
+	r.ContentLength = -1
+	_, err = spoolSegmentBody(r, 9, time.Second, dir)
+	assert.Equal(errSegmentTooLarge, err)
+	assert.Empty(spooled())
+
+	// Nor by those that time out, once their connection ends
+	pr, pw := io.Pipe()
+	r = httptest.NewRequest("POST", "/segment", pr)
+	go pw.Write([]byte("01"))
+	_, err = spoolSegmentBody(r, 10, 50*time.Millisecond, dir)
+	assert.Equal(errSegmentUploadTimeout, err)
+	pw.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(spooled())
+}
+
+func TestRespondSegmentError(t *testing.T) {
+	assert := assert.New(t)
+	w := httptest.NewRecorder()
+	respondSegmentError(w, http.StatusTooManyRequests, segErrTooManyUploads, "too many", 1500*time.Millisecond)
+
+	assert.Equal(http.StatusTooManyRequests, w.Code)
+	assert.Equal("2", w.Header().Get("Retry-After"))
+	var e segmentError
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(segmentError{Code: segErrTooManyUploads, Error: "too many", RetryAfter: 2}, e)
+}
@@ -2,12 +2,17 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/livepeer/go-livepeer/common"
@@ -15,35 +20,55 @@ import (
 	"github.com/livepeer/go-livepeer/drivers"
 	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 	"github.com/livepeer/lpms/stream"
-	"golang.org/x/net/http2"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 
-	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/pkg/errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const paymentHeader = "Livepeer-Payment"
 const segmentHeader = "Livepeer-Segment"
 
+// segmentPriorityHeader is "low" for segments to be routed to the slowest
+// remote transcoders, as they're not live
+const segmentPriorityHeader = "Livepeer-Segment-Priority"
+
 var errSegEncoding = errors.New("ErrorSegEncoding")
 var errSegSig = errors.New("ErrSegSig")
 
+// MaxRenditionUploads is the number of renditions of a segment orchestrators
+// save to the object store at once
+var MaxRenditionUploads = 4
+
 var tlsConfig = &tls.Config{InsecureSkipVerify: true}
-var httpClient = &http.Client{
-	Transport: &http2.Transport{TLSClientConfig: tlsConfig},
-	Timeout:   HTTPTimeout,
-}
 
 func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	orch := h.orchestrator
+	reqID := common.RequestID(r.Context())
+
+	if !shutdown.beginSegment() {
+		respondShuttingDown(w)
+		return
+	}
+	defer shutdown.endSegment()
+
+	ip := remoteIP(r.RemoteAddr)
+	if wait, banned := segGuard.banned(time.Now(), ip); banned {
+		respondSegmentError(w, http.StatusForbidden, segErrBanned, "banned from submitting segments", wait)
+		return
+	}
 
 	payment, err := getPayment(r.Header.Get(paymentHeader))
 	if err != nil {
-		glog.Error("Could not parse payment")
+		glog.Errorf("Could not parse payment requestID=%s", reqID)
 		http.Error(w, err.Error(), http.StatusPaymentRequired)
 		return
 	}
@@ -51,56 +76,148 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	// check the segment sig from the broadcaster
 	seg := r.Header.Get(segmentHeader)
 
-	segData, err := verifySegCreds(orch, seg, getPaymentSender(payment))
+	sender := getPaymentSender(payment)
+	segData, err := verifySegCreds(orch, seg, sender)
+	if capErr, ok := err.(*core.CapacityError); ok {
+		glog.Errorf("Refusing segment at capacity requestID=%s", reqID)
+		respondSegmentError(w, http.StatusServiceUnavailable, segErrAtCapacity, errOrchBackingOff.Error(), capErr.RetryAfter)
+		return
+	}
 	if err != nil {
-		glog.Error("Could not verify segment creds")
+		glog.Errorf("Could not verify segment creds requestID=%s", reqID)
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
+	// Broadcasters without payments are told apart by their address
+	broadcaster := ip
+	if sender != (ethcommon.Address{}) {
+		broadcaster = sender.Hex()
+	}
+	violators := []string{broadcaster}
+	if broadcaster != ip {
+		violators = append(violators, ip)
+	}
+	if wait, banned := segGuard.banned(time.Now(), broadcaster); banned {
+		respondSegmentError(w, http.StatusForbidden, segErrBanned, "banned from submitting segments", wait)
+		return
+	}
+	upload := uploadKey(broadcaster, string(segData.ManifestID))
+	if !segGuard.beginUpload(upload) {
+		glog.Errorf("Too many concurrent segment uploads broadcaster=%s manifestID=%s seqNo=%d requestID=%s", broadcaster, segData.ManifestID, segData.Seq, reqID)
+		segGuard.violation(time.Now(), segErrTooManyUploads, violators...)
+		respondSegmentError(w, http.StatusTooManyRequests, segErrTooManyUploads,
+			fmt.Sprintf("more than %d concurrent segment uploads of the stream", MaxSegmentUploads), time.Second)
+		return
+	}
+	uploading := true
+	endUpload := func() {
+		if uploading {
+			segGuard.endUpload(upload)
+			uploading = false
+		}
+	}
+	defer endUpload()
+
+	ctx, span := monitor.StartSpan(monitor.ExtractTraceHeaders(r.Context(), r.Header), "orchestrator.segment",
+		monitor.AttrManifestID.String(string(segData.ManifestID)), monitor.AttrSeqNo.Int64(segData.Seq))
+	defer span.End()
+
 	if err := orch.ProcessPayment(payment, segData.ManifestID); err != nil {
-		glog.Errorf("Error processing payment: %v", err)
+		glog.Errorf("Error processing payment requestID=%s: %v", reqID, err)
 		http.Error(w, err.Error(), http.StatusPaymentRequired)
 		return
 	}
 
 	// download the segment and check the hash
-	data, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		glog.Error("Could not read request body: ", err)
+	isURI := r.Header.Get("Content-Type") == "application/vnd+livepeer.uri"
+	limit := maxSegmentSize()
+	if isURI {
+		limit = maxSegmentURILength
+	}
+	// Segments are spooled to disk as they arrive rather than held in memory
+	var (
+		uri     string
+		spooled *spooledSegment
+	)
+	if isURI {
+		var data []byte
+		if data, err = readSegmentBody(r, limit, SegmentUploadTimeout); err == nil {
+			uri = string(data)
+		}
+	} else {
+		spooled, err = spoolSegmentBody(r, limit, SegmentUploadTimeout, h.workDir)
+	}
+	endUpload()
+	switch err {
+	case nil:
+	case errSegmentTooLarge:
+		glog.Errorf("Segment too large broadcaster=%s manifestID=%s seqNo=%d requestID=%s", broadcaster, segData.ManifestID, segData.Seq, reqID)
+		segGuard.violation(time.Now(), segErrTooLarge, violators...)
+		w.Header().Set("Connection", "close")
+		respondSegmentError(w, http.StatusRequestEntityTooLarge, segErrTooLarge,
+			fmt.Sprintf("segment larger than %d bytes", limit), 0)
+		return
+	case errSegmentUploadTimeout:
+		glog.Errorf("Segment upload timed out broadcaster=%s manifestID=%s seqNo=%d requestID=%s", broadcaster, segData.ManifestID, segData.Seq, reqID)
+		segGuard.violation(time.Now(), segErrUploadTimeout, violators...)
+		w.Header().Set("Connection", "close")
+		respondSegmentError(w, http.StatusRequestTimeout, segErrUploadTimeout,
+			fmt.Sprintf("segment not uploaded within %v", SegmentUploadTimeout), 0)
+		return
+	default:
+		glog.Errorf("Could not read request body requestID=%s: %v", reqID, err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	uri := ""
-	if r.Header.Get("Content-Type") == "application/vnd+livepeer.uri" {
-		uri = string(data)
+	if isURI {
 		glog.V(common.DEBUG).Infof("Start getting segment from %s", uri)
 		start := time.Now()
-		data, err = drivers.GetSegmentData(uri)
+		_, dspan := monitor.StartSpan(ctx, "storage.download")
+		var body io.ReadCloser
+		if body, err = drivers.GetSegmentStream(uri); err == nil {
+			spooled, err = spoolSegment(body, maxSegmentSize(), HTTPTimeout, h.workDir)
+			body.Close()
+		}
+		monitor.EndSpan(dspan, err)
 		took := time.Since(start)
 		glog.V(common.DEBUG).Infof("Getting segment from %s took %s", uri, took)
 		if err != nil {
-			glog.Errorf("Error getting input segment %v from input OS: %v", uri, err)
+			glog.Errorf("Error getting input segment %v from input OS requestID=%s: %v", uri, reqID, err)
 			http.Error(w, "BadRequest", http.StatusBadRequest)
 			return
 		}
 		if took > HTTPTimeout {
 			// download from object storage took more time when broadcaster will be waiting for result
 			// so there is no point to start transcoding process
-			glog.Errorf(" Getting segment from %s took too long, aborting", uri)
+			glog.Errorf("Getting segment from %s took too long, aborting requestID=%s", uri, reqID)
 			http.Error(w, "BadRequest", http.StatusBadRequest)
 			return
 		}
 	}
 
-	hash := crypto.Keccak256(data)
-	if !bytes.Equal(hash, segData.Hash.Bytes()) {
-		glog.Error("Mismatched hash for body; rejecting")
+	defer os.Remove(spooled.fname)
+
+	if !bytes.Equal(spooled.hash, segData.Hash.Bytes()) {
+		glog.Errorf("Mismatched hash for body; rejecting requestID=%s", reqID)
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
 
+	// Malformed segments are refused before they reach the decoder
+	if err := validateSpooledSegment(spooled); err != nil {
+		if _, ok := err.(*common.ContainerError); !ok {
+			glog.Errorf("Could not validate segment requestID=%s: %v", reqID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		glog.Errorf("Invalid segment broadcaster=%s manifestID=%s seqNo=%d requestID=%s: %v", broadcaster, segData.ManifestID, segData.Seq, reqID, err)
+		segGuard.violation(time.Now(), segErrInvalidSegment, violators...)
+		respondSegmentError(w, http.StatusUnprocessableEntity, segErrInvalidSegment, err.Error(), 0)
+		return
+	}
+
 	// Send down 200OK early as an indication that the upload completed
 	// Any further errors come through the response body
 	w.WriteHeader(http.StatusOK)
@@ -108,31 +225,41 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 
 	hlsStream := stream.HLSSegment{
 		SeqNo: uint64(segData.Seq),
-		Data:  data,
 		Name:  uri,
 	}
+	segData.Fname = spooled.fname
 
+	tctx, tspan := monitor.StartSpan(ctx, "orchestrator.transcode")
+	// Remote transcoders continue the trace from here
+	segData.TraceContext = monitor.InjectTraceCarrier(tctx)
+	if segData.TraceContext == nil {
+		// Not tracing; the request ID is still passed on
+		segData.TraceContext = make(map[string]string)
+	}
+	segData.TraceContext[requestIDCarrierKey] = reqID
+	segData.Priority = core.ParseSegmentPriority(r.Header.Get(segmentPriorityHeader))
 	res, err := orch.TranscodeSeg(segData, &hlsStream) // ANGIE - NEED TO CHANGE ALL JOBIDS IN TRANSCODING LOOP INTO STRINGS
+	monitor.EndSpan(tspan, err)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
 
 	// Upload to OS and construct segment result set
 	var segments []*net.TranscodedSegmentData
-	for i := 0; err == nil && i < len(res.Data); i++ {
-		name := fmt.Sprintf("%s/%d.ts", segData.Profiles[i].Name, segData.Seq) // ANGIE - NEED TO EDIT OUT JOB PROFILES
-		uri, err := res.OS.SaveData(name, res.Data[i])
-		if err != nil {
-			glog.Error("Could not upload segment ", segData.Seq)
-			break
+	if err == nil {
+		uris, uerr := saveRenditions(ctx, res.OS, segData.Profiles, segData.Seq, res.Data)
+		if uerr != nil {
+			glog.Errorf("Could not upload segment seqNo=%d requestID=%s: %v", segData.Seq, reqID, uerr)
 		}
-		d := &net.TranscodedSegmentData{
-			Url: uri,
+		for _, uri := range uris {
+			segments = append(segments, &net.TranscodedSegmentData{Url: uri})
 		}
-		segments = append(segments, d)
 	}
 
 	// construct the response
 	var result net.TranscodeResult
 	if err != nil {
-		glog.Error("Could not transcode ", err)
+		glog.Errorf("Could not transcode requestID=%s: %v", reqID, err)
 		result = net.TranscodeResult{Result: &net.TranscodeResult_Error{Error: err.Error()}}
 	} else {
 		result = net.TranscodeResult{Result: &net.TranscodeResult_Data{
@@ -195,9 +322,9 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 	}
 	mid := core.ManifestID(segData.ManifestId)
 
-	var os *net.OSInfo
+	var osInfo *net.OSInfo
 	if len(segData.Storage) > 0 {
-		os = segData.Storage[0]
+		osInfo = segData.Storage[0]
 	}
 
 	md := &core.SegTranscodingMetadata{
@@ -205,7 +332,7 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 		Seq:        segData.Seq,
 		Hash:       ethcommon.BytesToHash(segData.Hash),
 		Profiles:   profiles,
-		OS:         os,
+		OS:         osInfo,
 	}
 
 	if !orch.VerifySig(broadcaster, string(md.Flatten()), segData.Sig) {
@@ -221,8 +348,17 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 	return md, nil
 }
 
-func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64) (*net.TranscodeData, error) {
+// SubmitSegment sends seg to the orchestrator of sess for transcoding, continuing the trace in ctx
+func SubmitSegment(ctx context.Context, sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64) (*net.TranscodeData, error) {
+	ctx, span := monitor.StartSpan(ctx, "segment.submit", monitor.AttrOrch.String(sess.OrchestratorInfo.Transcoder))
+	tdata, err := submitSegment(ctx, sess, seg, nonce)
+	monitor.EndSpan(span, err)
+	return tdata, err
+}
+
+func submitSegment(ctx context.Context, sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64) (*net.TranscodeData, error) {
 	uploaded := seg.Name != "" // hijack seg.Name to convey the uploaded URI
+	reqID := common.RequestID(ctx)
 
 	segCreds, err := genSegCreds(sess, seg)
 	if err != nil {
@@ -242,7 +378,9 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	}
 
 	ti := sess.OrchestratorInfo
-	req, err := http.NewRequest("POST", ti.Transcoder+"/segment", bytes.NewBuffer(data))
+	// Sent from the buffer the segmenter read the segment into, which is
+	// kept for playback, without copying it
+	req, err := http.NewRequest("POST", ti.Transcoder+"/segment", bytes.NewReader(data))
 	if err != nil {
 		glog.Error("Could not generate trascode request to ", ti.Transcoder)
 		if monitor.Enabled {
@@ -253,18 +391,31 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 
 	req.Header.Set(segmentHeader, segCreds)
 	req.Header.Set(paymentHeader, payment)
+	req.Header.Set(segmentPriorityHeader, sess.Priority.String())
+	monitor.InjectTraceHeaders(ctx, req.Header)
+	if reqID != "" {
+		req.Header.Set(common.RequestIDHeader, reqID)
+	}
 	if uploaded {
 		req.Header.Set("Content-Type", "application/vnd+livepeer.uri")
 	} else {
 		req.Header.Set("Content-Type", "video/MP2T")
 	}
 
-	glog.Infof("Submitting segment nonce=%d seqNo=%d : %v bytes", nonce, seg.SeqNo, len(data))
+	glog.Infof("Submitting segment nonce=%d seqNo=%d requestID=%s : %v bytes", nonce, seg.SeqNo, reqID, len(data))
 	start := time.Now()
-	resp, err := httpClient.Do(req)
+	var tookAllDur time.Duration
+	var failCode string
+	defer func() {
+		if monitor.Enabled {
+			monitor.OrchestratorSegmentSent(ti.Transcoder, tookAllDur, failCode)
+		}
+	}()
+	resp, err := segmentHTTPClient().Do(req)
 	uploadDur := time.Since(start)
 	if err != nil {
-		glog.Errorf("Unable to submit segment nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
+		glog.Errorf("Unable to submit segment nonce=%d seqNo=%d requestID=%s: %v", nonce, seg.SeqNo, reqID, err)
+		failCode = string(monitor.SegmentUploadErrorUnknown)
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorUnknown, err.Error(), false)
 		}
@@ -273,9 +424,12 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		data, _ := ioutil.ReadAll(resp.Body)
+		// Errors are short; orchestrators that respond with more are cut off
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxSegmentErrorLength))
+		capacityBackoff.backoffResponse(ti.Transcoder, resp, data, time.Now())
 		errorString := strings.TrimSpace(string(data))
-		glog.Errorf("Error submitting segment nonce=%d seqNo=%d code=%d error=%v", nonce, seg.SeqNo, resp.StatusCode, string(data))
+		glog.Errorf("Error submitting segment nonce=%d seqNo=%d requestID=%s code=%d error=%v", nonce, seg.SeqNo, reqID, resp.StatusCode, string(data))
+		failCode = resp.Status
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadError(resp.Status),
 				fmt.Sprintf("Code: %d Error: %s", resp.StatusCode, errorString), false)
@@ -286,12 +440,18 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	if monitor.Enabled {
 		monitor.SegmentUploaded(nonce, seg.SeqNo, uploadDur)
 	}
+	recordSegmentPhase(monitor.SegmentPhaseUpload, uploadDur)
 
-	data, err = ioutil.ReadAll(resp.Body)
-	tookAllDur := time.Since(start)
+	// The response is only read until it's parsed, so its buffer is reused
+	buf := common.GetBuffer()
+	defer common.PutBuffer(buf)
+	_, err = buf.ReadFrom(resp.Body)
+	data = buf.Bytes()
+	tookAllDur = time.Since(start)
 
 	if err != nil {
-		glog.Errorf("Unable to read response body for segment nonce=%d seqNo=%d : %v", nonce, seg.SeqNo, err)
+		glog.Errorf("Unable to read response body for segment nonce=%d seqNo=%d requestID=%s : %v", nonce, seg.SeqNo, reqID, err)
+		failCode = string(monitor.SegmentTranscodeErrorReadBody)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorReadBody, nonce, seg.SeqNo, err, false)
 		}
@@ -302,7 +462,8 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	var tr net.TranscodeResult
 	err = proto.Unmarshal(data, &tr)
 	if err != nil {
-		glog.Errorf("Unable to parse response for segment nonce=%d seqNo=%d : %v", nonce, seg.SeqNo, err)
+		glog.Errorf("Unable to parse response for segment nonce=%d seqNo=%d requestID=%s : %v", nonce, seg.SeqNo, reqID, err)
+		failCode = string(monitor.SegmentTranscodeErrorParseResponse)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorParseResponse, nonce, seg.SeqNo, err, false)
 		}
@@ -314,27 +475,30 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	switch res := tr.Result.(type) {
 	case *net.TranscodeResult_Error:
 		err = fmt.Errorf(res.Error)
-		glog.Errorf("Transcode failed for segment nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
+		glog.Errorf("Transcode failed for segment nonce=%d seqNo=%d requestID=%s: %v", nonce, seg.SeqNo, reqID, err)
 		if err.Error() == "MediaStats Failure" {
 			glog.Info("Ensure the keyframe interval is 4 seconds or less")
 		}
+		code := monitor.SegmentTranscodeErrorTranscode
+		switch res.Error {
+		case "OrchestratorBusy":
+			code = monitor.SegmentTranscodeErrorOrchestratorBusy
+		case "OrchestratorCapped":
+			code = monitor.SegmentTranscodeErrorOrchestratorCapped
+			capacityBackoff.backoff(ti.Transcoder, 0, time.Now())
+		}
+		failCode = string(code)
 		if monitor.Enabled {
-			switch res.Error {
-			case "OrchestratorBusy":
-				monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorOrchestratorBusy, nonce, seg.SeqNo, err, false)
-			case "OrchestratorCapped":
-				monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorOrchestratorCapped, nonce, seg.SeqNo, err, false)
-			default:
-				monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorTranscode, nonce, seg.SeqNo, err, false)
-			}
+			monitor.SegmentTranscodeFailed(code, nonce, seg.SeqNo, err, false)
 		}
 		return nil, err
 	case *net.TranscodeResult_Data:
 		// fall through here for the normal case
 		tdata = res.Data
 	default:
-		glog.Errorf("Unexpected or unset transcode response field for nonce=%d seqNo=%d", nonce, seg.SeqNo)
+		glog.Errorf("Unexpected or unset transcode response field for nonce=%d seqNo=%d requestID=%s", nonce, seg.SeqNo, reqID)
 		err = fmt.Errorf("UnknownResponse")
+		failCode = string(monitor.SegmentTranscodeErrorUnknownResponse)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorUnknownResponse, nonce, seg.SeqNo, err, false)
 		}
@@ -345,6 +509,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	if monitor.Enabled {
 		monitor.SegmentTranscoded(nonce, seg.SeqNo, transcodeDur, common.ProfilesNames(sess.Profiles))
 	}
+	recordSegmentPhase(monitor.SegmentPhaseTranscode, transcodeDur)
 
 	glog.Infof("Successfully transcoded segment nonce=%d manifestID=%s segName=%s seqNo=%d", nonce, string(sess.ManifestID), seg.Name, seg.SeqNo)
 
@@ -369,7 +534,23 @@ func genSegCreds(sess *BroadcastSession, seg *stream.HLSSegment) (string, error)
 	// Send credentials for our own storage
 	var storage []*net.OSInfo
 	if bos := sess.BroadcasterOS; bos != nil && bos.IsExternal() {
-		storage = []*net.OSInfo{bos.GetInfo()}
+		info := bos.GetInfo()
+		// Only grant access to the renditions of this segment if possible
+		if ps, ok := bos.(drivers.PresignedSession); ok && drivers.S3PresignUploads {
+			names := make([]string, len(sess.Profiles))
+			for i, p := range sess.Profiles {
+				names[i] = renditionName(p.Name, md.Seq)
+			}
+			pinfo, err := ps.GetPresignedInfo(names)
+			if err != nil {
+				glog.Error("Unable to pre-sign upload URLs ", err)
+				return "", err
+			}
+			if pinfo != nil {
+				info = pinfo
+			}
+		}
+		storage = []*net.OSInfo{info}
 	}
 
 	// Generate serialized segment info
@@ -389,6 +570,59 @@ func genSegCreds(sess *BroadcastSession, seg *stream.HLSSegment) (string, error)
 	return base64.StdEncoding.EncodeToString(data), nil
 }
 
+// saveRenditions saves the renditions of segment seq to sess, up to
+// MaxRenditionUploads at once. It returns the URIs of the renditions saved
+// before the first that couldn't be, in the order of profiles, and an error
+// listing every rendition that couldn't be saved.
+func saveRenditions(ctx context.Context, sess drivers.OSSession, profiles []ffmpeg.VideoProfile, seq int64, data [][]byte) ([]string, error) {
+	uris := make([]string, len(data))
+	errs := make([]error, len(data))
+	workers := MaxRenditionUploads
+	if workers <= 0 || workers > len(data) {
+		workers = len(data)
+	}
+
+	renditions := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range renditions {
+				name := renditionName(profiles[i].Name, seq)
+				_, uspan := monitor.StartSpan(ctx, "storage.upload", attribute.String("livepeer.name", name))
+				uris[i], errs[i] = sess.SaveData(name, data[i])
+				monitor.EndSpan(uspan, errs[i])
+			}
+		}()
+	}
+	for i := range data {
+		renditions <- i
+	}
+	close(renditions)
+	wg.Wait()
+
+	var failed []string
+	saved := len(data)
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if saved > i {
+			saved = i
+		}
+		failed = append(failed, fmt.Sprintf("%s: %v", profiles[i].Name, err))
+	}
+	if len(failed) > 0 {
+		return uris[:saved], fmt.Errorf("could not save %d of %d renditions: %s", len(failed), len(data), strings.Join(failed, "; "))
+	}
+	return uris, nil
+}
+
+func renditionName(profile string, seq int64) string {
+	return fmt.Sprintf("%s/%d.ts", profile, seq)
+}
+
 func genPayment(sess *BroadcastSession) (string, error) {
 	if sess.Sender == nil {
 		return "", nil
@@ -398,6 +632,12 @@ func genPayment(sess *BroadcastSession) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	ev := ticket.EV()
+	if monitor.Enabled {
+		evf, _ := ev.Float64()
+		monitor.OrchestratorPaid(sess.OrchestratorInfo.GetTranscoder(), evf)
+	}
+	sess.Database.InsertPayment(common.PaymentTicketSent, string(sess.ManifestID), ticket.Recipient.Hex(), new(big.Int).Quo(ev.Num(), ev.Denom()))
 
 	protoTicket := &net.Ticket{
 		Recipient:         ticket.Recipient.Bytes(),
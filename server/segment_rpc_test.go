@@ -2,24 +2,33 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 	"github.com/livepeer/lpms/stream"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http2"
 )
 
@@ -32,6 +41,35 @@ func serveSegmentHandler(orch Orchestrator) http.Handler {
 		lp.ServeSegment(w, r)
 	})
 }
+
+// spooledAs matches the metadata of a segment the orchestrator spooled to
+// disk, holding data, against md
+func spooledAs(md *core.SegTranscodingMetadata, data []byte) interface{} {
+	return mock.MatchedBy(func(got *core.SegTranscodingMetadata) bool {
+		if got.ManifestID != md.ManifestID || got.Seq != md.Seq || got.Hash != md.Hash || got.Fname == "" {
+			return false
+		}
+		spooled, err := ioutil.ReadFile(got.Fname)
+		return err == nil && bytes.Equal(spooled, data)
+	})
+}
+
+// tsSegment is the smallest segment orchestrators accept: a PAT, the PMT of
+// one H.264 stream and the start of a PES packet of it
+func tsSegment() []byte {
+	packet := func(pid uint16, payload ...byte) []byte {
+		pkt := bytes.Repeat([]byte{0xff}, 188)
+		copy(pkt, []byte{0x47, 0x40 | byte(pid>>8), byte(pid), 0x10})
+		copy(pkt[4:], payload)
+		return pkt
+	}
+	return bytes.Join([][]byte{
+		packet(0, 0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00, 0x2a, 0xb1, 0x04, 0xb2),
+		packet(0x1000, 0x00, 0x02, 0xb0, 0x12, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x00, 0xf0, 0x00, 0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x15, 0xbd, 0x4d, 0x56),
+		packet(0x100, 0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x80, 0x05, 0x21, 0x00, 0x01, 0x00, 0x01),
+	}, nil)
+}
+
 func TestServeSegment_GetPaymentError(t *testing.T) {
 	orch := &mockOrchestrator{}
 	handler := serveSegmentHandler(orch)
@@ -129,6 +167,49 @@ func TestServeSegment_MismatchHashError(t *testing.T) {
 	assert.Equal("Forbidden", strings.TrimSpace(string(body)))
 }
 
+func TestServeSegment_InvalidSegmentError(t *testing.T) {
+	orch := &mockOrchestrator{}
+	handler := serveSegmentHandler(orch)
+
+	require := require.New(t)
+
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		ManifestID:  core.RandomManifestID(),
+	}
+	seg := &stream.HLSSegment{Data: tsSegment()[:188*2]}
+	creds, err := genSegCreds(s, seg)
+	require.Nil(err)
+
+	orch.On("ProcessPayment", net.Payment{}, s.ManifestID).Return(nil)
+
+	headers := map[string]string{
+		paymentHeader: "",
+		segmentHeader: creds,
+	}
+	resp := httpPostResp(handler, bytes.NewReader(seg.Data), headers)
+	defer resp.Body.Close()
+
+	var e segmentError
+	require.Nil(json.NewDecoder(resp.Body).Decode(&e))
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(segErrInvalidSegment, e.Code)
+	assert.Equal("invalid ts segment at byte 376: no PES packets", e.Error)
+	orch.AssertNotCalled(t, "TranscodeSeg", mock.Anything, mock.Anything)
+
+	// Unless segments aren't validated
+	defer func() { ValidateSegments = true }()
+	ValidateSegments = false
+	orch.On("TranscodeSeg", mock.Anything, mock.AnythingOfType("*stream.HLSSegment")).Return(nil, errors.New("TranscodeSeg error"))
+	resp = httpPostResp(handler, bytes.NewReader(seg.Data), headers)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+}
+
 func TestServeSegment_TranscodeSegError(t *testing.T) {
 	orch := &mockOrchestrator{}
 	handler := serveSegmentHandler(orch)
@@ -141,7 +222,7 @@ func TestServeSegment_TranscodeSegError(t *testing.T) {
 		Broadcaster: stubBroadcaster2(),
 		ManifestID:  core.RandomManifestID(),
 	}
-	seg := &stream.HLSSegment{Data: []byte("foo")}
+	seg := &stream.HLSSegment{Data: tsSegment()}
 	creds, err := genSegCreds(s, seg)
 	require.Nil(err)
 
@@ -149,7 +230,7 @@ func TestServeSegment_TranscodeSegError(t *testing.T) {
 	require.Nil(err)
 
 	orch.On("ProcessPayment", net.Payment{}, s.ManifestID).Return(nil)
-	orch.On("TranscodeSeg", md, seg).Return(nil, errors.New("TranscodeSeg error"))
+	orch.On("TranscodeSeg", spooledAs(md, seg.Data), mock.AnythingOfType("*stream.HLSSegment")).Return(nil, errors.New("TranscodeSeg error"))
 
 	headers := map[string]string{
 		paymentHeader: "",
@@ -173,6 +254,113 @@ func TestServeSegment_TranscodeSegError(t *testing.T) {
 	assert.Equal("TranscodeSeg error", res.Error)
 }
 
+func TestServeSegment_RequestIDWithoutTracing(t *testing.T) {
+	orch := &mockOrchestrator{}
+	handler := serveSegmentHandler(orch)
+
+	require := require.New(t)
+
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		ManifestID:  core.RandomManifestID(),
+	}
+	seg := &stream.HLSSegment{Data: tsSegment()}
+	creds, err := genSegCreds(s, seg)
+	require.Nil(err)
+
+	// Tracing is off, so there's no trace context to add the request ID to
+	withRequestID := mock.MatchedBy(func(md *core.SegTranscodingMetadata) bool {
+		_, ok := md.TraceContext[requestIDCarrierKey]
+		return ok
+	})
+	orch.On("ProcessPayment", net.Payment{}, s.ManifestID).Return(nil)
+	orch.On("TranscodeSeg", withRequestID, mock.AnythingOfType("*stream.HLSSegment")).Return(nil, errors.New("TranscodeSeg error"))
+
+	headers := map[string]string{
+		paymentHeader: "",
+		segmentHeader: creds,
+	}
+	resp := httpPostResp(handler, bytes.NewReader(seg.Data), headers)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	orch.AssertExpectations(t)
+}
+
+// failingTranscoder fails to transcode with err
+type failingTranscoder struct{ err error }
+
+func (t *failingTranscoder) Transcode(fname string, profiles []ffmpeg.VideoProfile) ([][]byte, error) {
+	return nil, t.err
+}
+
+func TestSegment_TraceAndRequestIDPropagation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	defer tp.Shutdown(context.Background())
+	otel.SetTracerProvider(tp)
+	traceOf := func(ctx context.Context) trace.TraceID {
+		return trace.SpanContextFromContext(ctx).TraceID()
+	}
+
+	// The broadcaster starts the trace of the segment
+	reqID := common.NewRequestID()
+	ctx, span := monitor.StartSpan(common.WithRequestID(context.Background(), reqID), "broadcast.segment")
+	defer span.End()
+	traceID := span.SpanContext().TraceID()
+
+	// which the orchestrator continues
+	var segHeader http.Header
+	var notified map[string]string
+	orch := &mockOrchestrator{}
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+	orch.On("ProcessPayment", net.Payment{}, mock.Anything).Return(nil)
+	orch.On("TranscodeSeg", mock.MatchedBy(func(md *core.SegTranscodingMetadata) bool {
+		notified = md.TraceContext
+		return true
+	}), mock.Anything).Return(nil, errors.New("TranscodeSeg error"))
+	handler := withRequestID(serveSegmentHandler(orch))
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		segHeader = r.Header
+		handler.ServeHTTP(w, r)
+	})
+
+	s := &BroadcastSession{
+		Broadcaster:      stubBroadcaster2(),
+		ManifestID:       core.RandomManifestID(),
+		OrchestratorInfo: &net.OrchestratorInfo{Transcoder: ts.URL},
+	}
+	_, err := SubmitSegment(ctx, s, &stream.HLSSegment{Data: tsSegment()}, 0)
+	require.EqualError(err, "TranscodeSeg error")
+	assert.Equal(reqID, segHeader.Get(common.RequestIDHeader))
+	assert.Equal(traceID, traceOf(monitor.ExtractTraceHeaders(context.Background(), segHeader)))
+
+	// and passes on to remote transcoders
+	require.NotNil(notified)
+	assert.Equal(reqID, notified[requestIDCarrierKey])
+	assert.Equal(traceID, traceOf(monitor.ExtractTraceCarrier(context.Background(), notified)))
+
+	// which send the results back in the same trace
+	var resHeader http.Header
+	rts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resHeader = r.Header
+		ioutil.ReadAll(r.Body)
+	}))
+	defer rts.Close()
+	n := &core.LivepeerNode{Transcoder: &failingTranscoder{err: errors.New("Transcode error")}}
+	notify := &net.NotifySegment{TaskId: 1, Url: "seg.ts", TraceContext: notified}
+	runTranscode(n, rts.Listener.Addr().String(), rts.Client(), notify, "secret")
+	require.NotNil(resHeader)
+	assert.Equal(reqID, resHeader.Get(common.RequestIDHeader))
+	assert.Equal(traceID, traceOf(monitor.ExtractTraceHeaders(context.Background(), resHeader)))
+}
+
 func TestServeSegment_OSSaveDataError(t *testing.T) {
 	orch := &mockOrchestrator{}
 	handler := serveSegmentHandler(orch)
@@ -188,7 +376,7 @@ func TestServeSegment_OSSaveDataError(t *testing.T) {
 			ffmpeg.P720p60fps16x9,
 		},
 	}
-	seg := &stream.HLSSegment{Data: []byte("foo")}
+	seg := &stream.HLSSegment{Data: tsSegment()}
 	creds, err := genSegCreds(s, seg)
 	require.Nil(err)
 
@@ -208,7 +396,7 @@ func TestServeSegment_OSSaveDataError(t *testing.T) {
 		Sig: []byte("foo"),
 		OS:  mos,
 	}
-	orch.On("TranscodeSeg", md, seg).Return(tRes, nil)
+	orch.On("TranscodeSeg", spooledAs(md, seg.Data), mock.AnythingOfType("*stream.HLSSegment")).Return(tRes, nil)
 
 	headers := map[string]string{
 		paymentHeader: "",
@@ -248,7 +436,7 @@ func TestServeSegment_ReturnSingleTranscodedSegmentData(t *testing.T) {
 			ffmpeg.P720p60fps16x9,
 		},
 	}
-	seg := &stream.HLSSegment{Data: []byte("foo")}
+	seg := &stream.HLSSegment{Data: tsSegment()}
 	creds, err := genSegCreds(s, seg)
 	require.Nil(err)
 
@@ -264,7 +452,7 @@ func TestServeSegment_ReturnSingleTranscodedSegmentData(t *testing.T) {
 		Sig: []byte("foo"),
 		OS:  drivers.NewMemoryDriver(nil).NewSession(""),
 	}
-	orch.On("TranscodeSeg", md, seg).Return(tRes, nil)
+	orch.On("TranscodeSeg", spooledAs(md, seg.Data), mock.AnythingOfType("*stream.HLSSegment")).Return(tRes, nil)
 
 	headers := map[string]string{
 		paymentHeader: "",
@@ -305,7 +493,7 @@ func TestServeSegment_ReturnMultipleTranscodedSegmentData(t *testing.T) {
 			ffmpeg.P240p30fps16x9,
 		},
 	}
-	seg := &stream.HLSSegment{Data: []byte("foo")}
+	seg := &stream.HLSSegment{Data: tsSegment()}
 	creds, err := genSegCreds(s, seg)
 	require.Nil(err)
 
@@ -322,7 +510,7 @@ func TestServeSegment_ReturnMultipleTranscodedSegmentData(t *testing.T) {
 		Sig: []byte("foo"),
 		OS:  drivers.NewMemoryDriver(nil).NewSession(""),
 	}
-	orch.On("TranscodeSeg", md, seg).Return(tRes, nil)
+	orch.On("TranscodeSeg", spooledAs(md, seg.Data), mock.AnythingOfType("*stream.HLSSegment")).Return(tRes, nil)
 
 	headers := map[string]string{
 		paymentHeader: "",
@@ -356,7 +544,7 @@ func TestSubmitSegment_GenSegCredsError(t *testing.T) {
 		ManifestID:  core.RandomManifestID(),
 	}
 
-	_, err := SubmitSegment(s, &stream.HLSSegment{}, 0)
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
 
 	assert.Equal(t, "Sign error", err.Error())
 }
@@ -370,7 +558,7 @@ func TestSubmitSegment_HttpPostError(t *testing.T) {
 		},
 	}
 
-	_, err := SubmitSegment(s, &stream.HLSSegment{}, 0)
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
 
 	assert.Contains(t, err.Error(), "connection refused")
 }
@@ -390,7 +578,7 @@ func TestSubmitSegment_Non200StatusCode(t *testing.T) {
 		},
 	}
 
-	_, err := SubmitSegment(s, &stream.HLSSegment{}, 0)
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
 
 	assert.Equal(t, "Server error", err.Error())
 }
@@ -411,7 +599,7 @@ func TestSubmitSegment_ProtoUnmarshalError(t *testing.T) {
 		},
 	}
 
-	_, err := SubmitSegment(s, &stream.HLSSegment{}, 0)
+	_, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
 
 	assert.Contains(t, err.Error(), "proto")
 }
@@ -438,7 +626,7 @@ func TestSubmitSegment_TranscodeResultError(t *testing.T) {
 		},
 	}
 
-	_, err = SubmitSegment(s, &stream.HLSSegment{}, 0)
+	_, err = SubmitSegment(context.Background(), s, &stream.HLSSegment{}, 0)
 
 	assert.Equal(t, "TranscodeResult error", err.Error())
 }
@@ -484,6 +672,7 @@ func TestSubmitSegment_Success(t *testing.T) {
 
 	runChecks = func(r *http.Request) {
 		assert.Equal("video/MP2T", r.Header.Get("Content-Type"))
+		assert.Equal("live", r.Header.Get(segmentPriorityHeader))
 
 		data, err := ioutil.ReadAll(r.Body)
 		require.Nil(err)
@@ -491,7 +680,7 @@ func TestSubmitSegment_Success(t *testing.T) {
 		assert.Equal([]byte("dummy"), data)
 	}
 
-	tdata, err := SubmitSegment(s, &stream.HLSSegment{Data: []byte("dummy")}, 0)
+	tdata, err := SubmitSegment(context.Background(), s, &stream.HLSSegment{Data: []byte("dummy")}, 0)
 
 	assert.Nil(err)
 	assert.Equal(1, len(tdata.Segments))
@@ -508,7 +697,15 @@ func TestSubmitSegment_Success(t *testing.T) {
 		assert.Equal([]byte("foo"), data)
 	}
 
-	SubmitSegment(s, &stream.HLSSegment{Name: "foo", Data: []byte("dummy")}, 0)
+	SubmitSegment(context.Background(), s, &stream.HLSSegment{Name: "foo", Data: []byte("dummy")}, 0)
+
+	// Segments of streams that aren't watched live are sent as such
+	s.Priority = core.SegmentPriorityLow
+	runChecks = func(r *http.Request) {
+		assert.Equal("low", r.Header.Get(segmentPriorityHeader))
+	}
+	_, err = SubmitSegment(context.Background(), s, &stream.HLSSegment{Data: []byte("dummy")}, 0)
+	assert.Nil(err)
 }
 
 func stubTLSServer() (*httptest.Server, *http.ServeMux) {
@@ -523,3 +720,126 @@ func stubTLSServer() (*httptest.Server, *http.ServeMux) {
 
 	return ts, mux
 }
+
+type mockPresignedOSSession struct {
+	mockOSSession
+}
+
+func (s *mockPresignedOSSession) GetPresignedInfo(names []string) (*net.OSInfo, error) {
+	args := s.Called(names)
+	if args.Get(0) != nil {
+		return args.Get(0).(*net.OSInfo), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func TestGenSegCreds_PresignedUploads(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	policyInfo := &net.OSInfo{StorageType: net.OSInfo_S3, S3Info: &net.S3OSInfo{Policy: "policy"}}
+	presignedInfo := &net.OSInfo{StorageType: net.OSInfo_S3, S3Info: &net.S3OSInfo{
+		PresignedUrls: map[string]string{"P720p60fps16x9/5.ts": "https://bucket/P720p60fps16x9/5.ts?sig"},
+	}}
+	names := []string{"P720p60fps16x9/5.ts"}
+
+	bos := &mockPresignedOSSession{}
+	bos.On("IsExternal").Return(true)
+	bos.On("GetInfo").Return(policyInfo)
+	s := &BroadcastSession{
+		Broadcaster:   stubBroadcaster2(),
+		ManifestID:    core.RandomManifestID(),
+		Profiles:      []ffmpeg.VideoProfile{ffmpeg.P720p60fps16x9},
+		BroadcasterOS: bos,
+	}
+	seg := &stream.HLSSegment{SeqNo: 5}
+	orch := &mockOrchestrator{}
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+
+	// Policy is sent unless pre-signing is enabled
+	creds, err := genSegCreds(s, seg)
+	require.Nil(err)
+	md, err := verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Equal("policy", md.OS.S3Info.Policy)
+	assert.Empty(md.OS.S3Info.PresignedUrls)
+
+	defer func() { drivers.S3PresignUploads = false }()
+	drivers.S3PresignUploads = true
+
+	// Only the renditions of the segment are pre-signed
+	bos.On("GetPresignedInfo", names).Return(presignedInfo, nil).Once()
+	creds, err = genSegCreds(s, seg)
+	require.Nil(err)
+	md, err = verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Empty(md.OS.S3Info.Policy)
+	assert.Equal(presignedInfo.S3Info.PresignedUrls, md.OS.S3Info.PresignedUrls)
+
+	// Falls back to the policy if the session can't pre-sign
+	bos.On("GetPresignedInfo", names).Return(nil, nil).Once()
+	creds, err = genSegCreds(s, seg)
+	require.Nil(err)
+	md, err = verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Equal("policy", md.OS.S3Info.Policy)
+
+	// Pre-signing errors are returned
+	bos.On("GetPresignedInfo", names).Return(nil, errors.New("presign error")).Once()
+	_, err = genSegCreds(s, seg)
+	assert.EqualError(err, "presign error")
+}
+
+// concurrentOSSession counts the saves in progress, failing those of names
+// in fail
+type concurrentOSSession struct {
+	drivers.OSSession
+	mu           sync.Mutex
+	saving, peak int
+	fail         map[string]bool
+}
+
+func (s *concurrentOSSession) SaveData(name string, data []byte) (string, error) {
+	s.mu.Lock()
+	s.saving++
+	if s.saving > s.peak {
+		s.peak = s.saving
+	}
+	s.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	s.mu.Lock()
+	s.saving--
+	s.mu.Unlock()
+	if s.fail[name] {
+		return "", errors.New("save error")
+	}
+	return "saved/" + name, nil
+}
+
+func TestSaveRenditions(t *testing.T) {
+	assert := assert.New(t)
+	defer func(n int) { MaxRenditionUploads = n }(MaxRenditionUploads)
+	MaxRenditionUploads = 2
+
+	profiles := []ffmpeg.VideoProfile{ffmpeg.P720p60fps16x9, ffmpeg.P576p30fps16x9, ffmpeg.P360p30fps16x9, ffmpeg.P240p30fps16x9}
+	data := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	sess := &concurrentOSSession{}
+	uris, err := saveRenditions(context.Background(), sess, profiles, 7, data)
+	assert.Nil(err)
+	assert.Equal([]string{"saved/P720p60fps16x9/7.ts", "saved/P576p30fps16x9/7.ts", "saved/P360p30fps16x9/7.ts", "saved/P240p30fps16x9/7.ts"}, uris)
+	assert.Equal(2, sess.peak)
+
+	// Every failure is reported, and only the renditions before the first
+	// are returned
+	sess = &concurrentOSSession{fail: map[string]bool{"P576p30fps16x9/7.ts": true, "P240p30fps16x9/7.ts": true}}
+	uris, err = saveRenditions(context.Background(), sess, profiles, 7, data)
+	assert.EqualError(err, "could not save 2 of 4 renditions: P576p30fps16x9: save error; P240p30fps16x9: save error")
+	assert.Equal([]string{"saved/P720p60fps16x9/7.ts"}, uris)
+
+	// All at once without a limit
+	MaxRenditionUploads = 0
+	sess = &concurrentOSSession{}
+	_, err = saveRenditions(context.Background(), sess, profiles, 7, data)
+	assert.Nil(err)
+	assert.Equal(4, sess.peak)
+}
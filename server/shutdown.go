@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+var errShuttingDown = errors.New("node is shutting down")
+
+// drainPollInterval is how often Shutdown checks whether streams ended
+var drainPollInterval = 500 * time.Millisecond
+
+// shutdown stops new work being accepted once the node starts draining, and
+// tracks segments being processed so they complete, and the tickets paying
+// for them are stored, before the node exits
+var shutdown = &shutdownState{}
+
+type shutdownState struct {
+	mu       sync.Mutex
+	draining bool
+	segments sync.WaitGroup
+}
+
+func (s *shutdownState) isDraining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draining
+}
+
+// beginSegment returns false if the node is draining; otherwise endSegment
+// must be called once the segment is done with
+func (s *shutdownState) beginSegment() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return false
+	}
+	s.segments.Add(1)
+	return true
+}
+
+func (s *shutdownState) endSegment() {
+	s.segments.Done()
+}
+
+func (s *shutdownState) drain() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+}
+
+func (s *shutdownState) resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = false
+}
+
+// waitSegments waits for the segments in process, or until ctx is done
+func (s *shutdownState) waitSegments(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.segments.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func respondShuttingDown(w http.ResponseWriter) {
+	// Let clients take their work elsewhere rather than retrying here
+	w.Header().Set("Connection", "close")
+	http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+}
+
+// Shutdown drains the node: new streams and segments are refused, and the
+// streams already ingested are given until ctx is done to end. Those still
+// live then are disconnected. Returns once the segments in process are done,
+// or ctx is.
+func (s *LivepeerServer) Shutdown(ctx context.Context) {
+	shutdown.drain()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+wait:
+	for s.activeStreams() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			break wait
+		}
+	}
+
+	s.connectionLock.RLock()
+	cxns := make([]*rtmpConnection, 0, len(s.rtmpConnections))
+	for _, cxn := range s.rtmpConnections {
+		cxns = append(cxns, cxn)
+	}
+	s.connectionLock.RUnlock()
+	for _, cxn := range cxns {
+		glog.Infof("Disconnecting stream manifestID=%s: %v", cxn.mid, errShuttingDown)
+		// Ends the stream the same way the publisher going away does
+		cxn.stream.Close()
+	}
+
+	if err := shutdown.waitSegments(ctx); err != nil {
+		glog.Errorf("Segments still in process at shutdown: %v", err)
+	}
+}
+
+func (s *LivepeerServer) activeStreams() int {
+	s.connectionLock.RLock()
+	defer s.connectionLock.RUnlock()
+	return len(s.rtmpConnections)
+}
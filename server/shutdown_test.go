@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func resetShutdown() func() {
+	old, oldInterval := shutdown, drainPollInterval
+	shutdown = &shutdownState{}
+	drainPollInterval = time.Millisecond
+	return func() { shutdown, drainPollInterval = old, oldInterval }
+}
+
+func TestShutdown_RefusesNewWork(t *testing.T) {
+	defer resetShutdown()()
+	assert := assert.New(t)
+	s := &LivepeerServer{
+		LivepeerNode:    &core.LivepeerNode{},
+		connectionLock:  &sync.RWMutex{},
+		rtmpConnections: make(map[core.ManifestID]*rtmpConnection),
+	}
+	createSid := createRTMPStreamIDHandler(s)
+	u, _ := url.Parse("rtmp://localhost/stream/key")
+	assert.NotNil(createSid(u))
+
+	s.Shutdown(context.Background())
+	assert.Nil(createSid(u))
+
+	resp := httpPostResp(serveSegmentHandler(&mockOrchestrator{}), nil, nil)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(healthFailing, s.checkHealth(context.Background()).Subsystems["shutdown"].Status)
+}
+
+func TestShutdown_WaitsForStreams(t *testing.T) {
+	defer resetShutdown()()
+	assert := assert.New(t)
+	s := &LivepeerServer{
+		connectionLock:  &sync.RWMutex{},
+		rtmpConnections: make(map[core.ManifestID]*rtmpConnection),
+	}
+	mid := core.ManifestID("mid")
+	s.rtmpConnections[mid] = &rtmpConnection{mid: mid, stream: stream.NewBasicRTMPVideoStream(newStreamParams(mid, "key"))}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		s.connectionLock.Lock()
+		delete(s.rtmpConnections, mid)
+		s.connectionLock.Unlock()
+	}()
+	start := time.Now()
+	s.Shutdown(context.Background())
+	assert.True(time.Since(start) >= 20*time.Millisecond)
+	assert.Equal(0, s.activeStreams())
+}
+
+func TestShutdown_DisconnectsAfterGracePeriod(t *testing.T) {
+	defer resetShutdown()()
+	assert := assert.New(t)
+	s := &LivepeerServer{
+		connectionLock:  &sync.RWMutex{},
+		rtmpConnections: make(map[core.ManifestID]*rtmpConnection),
+	}
+	mid := core.ManifestID("mid")
+	strm := stream.NewBasicRTMPVideoStream(newStreamParams(mid, "key"))
+	s.rtmpConnections[mid] = &rtmpConnection{mid: mid, stream: strm}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	s.Shutdown(ctx)
+	select {
+	case <-strm.EOF:
+	default:
+		t.Error("Stream not disconnected")
+	}
+
+	// Segments in process are waited for until the grace period is up
+	shutdown = &shutdownState{}
+	assert.True(shutdown.beginSegment())
+	ended := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		shutdown.endSegment()
+		close(ended)
+	}()
+	s.Shutdown(context.Background())
+	select {
+	case <-ended:
+	default:
+		t.Error("Shutdown returned before segment ended")
+	}
+	assert.False(shutdown.beginSegment())
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	gonet "net"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
+)
+
+// systemdReadyInterval how often the node checks whether it's ready before
+// notifying systemd
+var systemdReadyInterval = time.Second
+
+// Subsystems that must be healthy before the node is ready. Failures of the
+// others, like the object store, are outside of the node's control so don't
+// hold up startup.
+var systemdReadySubsystems = []string{"eth", "blockWatcher"}
+
+// StartSystemdNotify notifies systemd once addrs accept connections and the
+// eth client is synced, then pets the watchdog after each health check for
+// as long as the checks keep completing. Does nothing unless the node was
+// started by systemd with Type=notify.
+func (s *LivepeerServer) StartSystemdNotify(ctx context.Context, addrs []string) {
+	if !common.SdNotifyEnabled() {
+		return
+	}
+	watchdog, err := common.SdWatchdogInterval()
+	if err != nil {
+		glog.Error("Error getting the systemd watchdog interval: ", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(systemdReadyInterval)
+		defer ticker.Stop()
+		for {
+			err := s.checkReady(ctx, addrs)
+			if err == nil {
+				break
+			}
+			glog.V(common.DEBUG).Infof("Not ready to notify systemd: %v", err)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if _, err := common.SdNotify(common.SdNotifyReady); err != nil {
+			glog.Error("Error notifying systemd: ", err)
+		} else {
+			glog.Info("Notified systemd that the node is ready")
+		}
+
+		if watchdog <= 0 {
+			return
+		}
+		// Checks are cut short well within the interval, so only a check that
+		// hangs regardless of its context stops the watchdog being petted
+		wdTicker := time.NewTicker(watchdog / 2)
+		defer wdTicker.Stop()
+		for {
+			select {
+			case <-wdTicker.C:
+				checkCtx, cancel := context.WithTimeout(ctx, watchdog/4)
+				s.checkHealth(checkCtx)
+				cancel()
+				if _, err := common.SdNotify(common.SdNotifyWatchdog); err != nil {
+					glog.Error("Error petting the systemd watchdog: ", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (s *LivepeerServer) checkReady(ctx context.Context, addrs []string) error {
+	for _, addr := range addrs {
+		conn, err := gonet.DialTimeout("tcp", addr, systemdReadyInterval)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	report := s.checkHealth(checkCtx)
+	for _, name := range systemdReadySubsystems {
+		if sub, ok := report.Subsystems[name]; ok && sub.Status != healthOK {
+			return fmt.Errorf("%s %s: %s", name, sub.Status, sub.Error)
+		}
+	}
+	return nil
+}
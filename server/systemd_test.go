@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckReady(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldStorage, oldWebhook := drivers.NodeStorage, AuthWebhookURL
+	defer func() { drivers.NodeStorage, AuthWebhookURL = oldStorage, oldWebhook }()
+	drivers.NodeStorage, AuthWebhookURL = nil, ""
+
+	n, _ := core.NewLivepeerNode(nil, "", nil)
+	s := &LivepeerServer{LivepeerNode: n}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	addr := l.Addr().String()
+
+	assert.Nil(s.checkReady(context.Background(), []string{addr}))
+
+	// Subsystems outside of the node's control don't hold it up
+	n.TranscoderManager = core.NewRemoteTranscoderManager()
+	assert.Nil(s.checkReady(context.Background(), []string{addr}))
+
+	// Not listening
+	l.Close()
+	assert.NotNil(s.checkReady(context.Background(), []string{addr}))
+}
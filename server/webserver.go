@@ -15,8 +15,8 @@ import (
 	"github.com/cenkalti/backoff"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/golang/glog"
 	lpcommon "github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/common/glog"
 	"github.com/livepeer/go-livepeer/core"
 	"github.com/livepeer/go-livepeer/eth"
 	lpTypes "github.com/livepeer/go-livepeer/eth/types"
@@ -59,9 +59,22 @@ func (s *LivepeerServer) StartCliWebserver(bindAddr string) {
 	mux := s.cliWebServerHandlers(bindAddr)
 	srv := &http.Server{
 		Addr:    bindAddr,
-		Handler: mux,
+		Handler: withRequestID(s.withAuditLog(auditViaCli, mustHaveCliPermission(mux))),
 	}
 
+	if CliSocket != "" {
+		l, err := listenCliSocket(CliSocket)
+		if err != nil {
+			glog.Errorf("Unable to listen on CLI socket %v: %v", CliSocket, err)
+		} else {
+			glog.Info("CLI server listening on socket ", CliSocket)
+			go (&http.Server{Handler: withRequestID(s.withAuditLog(auditViaSocket, mux))}).Serve(l)
+		}
+	}
+
+	if len(CliTokens) == 0 && CliSocket == "" && !isLoopbackAddr(bindAddr) {
+		glog.Warning("CLI server listening on ", bindAddr, " without -cliTokens; anyone who can reach it can spend the node's funds")
+	}
 	glog.Info("CLI server listening on ", bindAddr)
 	srv.ListenAndServe()
 }
@@ -72,24 +85,24 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 	//Set the broadcast config for creating onchain jobs.
 	mux.HandleFunc("/setBroadcastConfig", func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
-			glog.Errorf("Parse Form Error: %v", err)
+			respondWith400(w, fmt.Sprintf("parse form error: %v", err))
 			return
 		}
 
 		priceStr := r.FormValue("maxPricePerSegment")
 		if priceStr == "" {
-			glog.Errorf("Need to provide max price per segment")
+			respondWith400(w, "need to provide max price per segment")
 			return
 		}
 		price, err := lpcommon.ParseBigInt(priceStr)
 		if err != nil {
-			glog.Error(err)
+			respondWith400(w, fmt.Sprintf("invalid max price per segment: %v", err))
 			return
 		}
 
 		transcodingOptions := r.FormValue("transcodingOptions")
 		if transcodingOptions == "" {
-			glog.Errorf("Need to provide transcoding options")
+			respondWith400(w, "need to provide transcoding options")
 			return
 		}
 
@@ -101,7 +114,7 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 			}
 		}
 		if len(profiles) == 0 {
-			glog.Errorf("Invalid transcoding options: %v", transcodingOptions)
+			respondWith400(w, fmt.Sprintf("invalid transcoding options: %v", transcodingOptions))
 			return
 		}
 
@@ -325,53 +338,57 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 
 	//Set transcoder config on-chain.
 	mux.HandleFunc("/setOrchestratorConfig", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.Eth == nil {
+			respondWith500(w, "missing ETH client")
+			return
+		}
 		if err := r.ParseForm(); err != nil {
-			glog.Errorf("Parse Form Error: %v", err)
+			respondWith400(w, fmt.Sprintf("parse form error: %v", err))
 			return
 		}
 
 		blockRewardCutStr := r.FormValue("blockRewardCut")
 		if blockRewardCutStr == "" {
-			glog.Errorf("Need to provide block reward cut")
+			respondWith400(w, "need to provide block reward cut")
 			return
 		}
 		blockRewardCut, err := strconv.ParseFloat(blockRewardCutStr, 64)
 		if err != nil {
-			glog.Errorf("Cannot convert block reward cut: %v", err)
+			respondWith400(w, fmt.Sprintf("cannot convert block reward cut: %v", err))
 			return
 		}
 
 		feeShareStr := r.FormValue("feeShare")
 		if feeShareStr == "" {
-			glog.Errorf("Need to provide fee share")
+			respondWith400(w, "need to provide fee share")
 			return
 		}
 		feeShare, err := strconv.ParseFloat(feeShareStr, 64)
 		if err != nil {
-			glog.Errorf("Cannot convert fee share: %v", err)
+			respondWith400(w, fmt.Sprintf("cannot convert fee share: %v", err))
 			return
 		}
 
 		priceStr := r.FormValue("pricePerSegment")
 		if priceStr == "" {
-			glog.Errorf("Need to provide price per segment")
+			respondWith400(w, "need to provide price per segment")
 			return
 		}
 		price, err := lpcommon.ParseBigInt(priceStr)
 		if err != nil {
-			glog.Error(err)
+			respondWith400(w, fmt.Sprintf("invalid price per segment: %v", err))
 			return
 		}
 
 		serviceURI := r.FormValue("serviceURI")
 		if _, err := url.ParseRequestURI(serviceURI); err != nil {
-			glog.Error(err)
+			respondWith400(w, fmt.Sprintf("invalid service URI: %v", err))
 			return
 		}
 
 		t, err := s.LivepeerNode.Eth.GetTranscoder(s.LivepeerNode.Eth.Account().Address)
 		if err != nil {
-			glog.Error(err)
+			respondWith500(w, fmt.Sprintf("could not get orchestrator: %v", err))
 			return
 		}
 
@@ -380,60 +397,64 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 
 			tx, err := s.LivepeerNode.Eth.Transcoder(eth.FromPerc(blockRewardCut), eth.FromPerc(feeShare), price)
 			if err != nil {
-				glog.Error(err)
+				respondWith500(w, fmt.Sprintf("could not execute transcoder: %v", err))
 				return
 			}
 
 			err = s.LivepeerNode.Eth.CheckTx(tx)
 			if err != nil {
-				glog.Error(err)
+				respondWith500(w, fmt.Sprintf("could not execute transcoder: %v", err))
 				return
 			}
 		}
 
 		if t.ServiceURI != serviceURI {
 			if err := s.setServiceURI(serviceURI); err != nil {
+				respondWith500(w, fmt.Sprintf("could not set service URI: %v", err))
 				return
 			}
 		}
 	})
 
 	//Bond some amount of tokens to an orchestrator.
+
 	mux.HandleFunc("/bond", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := r.ParseForm(); err != nil {
-				glog.Errorf("Parse Form Error: %v", err)
-				return
-			}
+		if s.LivepeerNode.Eth == nil {
+			respondWith500(w, "missing ETH client")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+			return
+		}
 
-			amountStr := r.FormValue("amount")
-			if amountStr == "" {
-				glog.Errorf("Need to provide amount")
-				return
-			}
-			amount, err := lpcommon.ParseBigInt(amountStr)
-			if err != nil {
-				glog.Errorf("Cannot convert amount: %v", err)
-				return
-			}
+		amountStr := r.FormValue("amount")
+		if amountStr == "" {
+			respondWith400(w, "need to provide amount")
+			return
+		}
+		amount, err := lpcommon.ParseBigInt(amountStr)
+		if err != nil {
+			respondWith400(w, fmt.Sprintf("cannot convert amount: %v", err))
+			return
+		}
 
-			toAddr := r.FormValue("toAddr")
-			if toAddr == "" {
-				glog.Errorf("Need to provide to addr")
-				return
-			}
+		toAddr := r.FormValue("toAddr")
+		if toAddr == "" {
+			respondWith400(w, "need to provide to addr")
+			return
+		}
 
-			tx, err := s.LivepeerNode.Eth.Bond(amount, common.HexToAddress(toAddr))
-			if err != nil {
-				glog.Error(err)
-				return
-			}
+		tx, err := s.LivepeerNode.Eth.Bond(amount, common.HexToAddress(toAddr))
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not execute bond: %v", err))
+			return
+		}
 
-			err = s.LivepeerNode.Eth.CheckTx(tx)
-			if err != nil {
-				glog.Error(err)
-				return
-			}
+		err = s.LivepeerNode.Eth.CheckTx(tx)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not execute bond: %v", err))
+			return
 		}
 	})
 
@@ -475,34 +496,36 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 	})
 
 	mux.HandleFunc("/unbond", func(w http.ResponseWriter, r *http.Request) {
-		if s.LivepeerNode.Eth != nil {
-			if err := r.ParseForm(); err != nil {
-				glog.Errorf("Parse Form Error: %v", err)
-				return
-			}
+		if s.LivepeerNode.Eth == nil {
+			respondWith500(w, "missing ETH client")
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondWith400(w, fmt.Sprintf("parse form error: %v", err))
+			return
+		}
 
-			amountStr := r.FormValue("amount")
-			if amountStr == "" {
-				glog.Errorf("Need to provide amount")
-				return
-			}
-			amount, err := lpcommon.ParseBigInt(amountStr)
-			if err != nil {
-				glog.Errorf("Cannot convert amount: %v", err)
-				return
-			}
+		amountStr := r.FormValue("amount")
+		if amountStr == "" {
+			respondWith400(w, "need to provide amount")
+			return
+		}
+		amount, err := lpcommon.ParseBigInt(amountStr)
+		if err != nil {
+			respondWith400(w, fmt.Sprintf("cannot convert amount: %v", err))
+			return
+		}
 
-			tx, err := s.LivepeerNode.Eth.Unbond(amount)
-			if err != nil {
-				glog.Error(err)
-				return
-			}
+		tx, err := s.LivepeerNode.Eth.Unbond(amount)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not execute unbond: %v", err))
+			return
+		}
 
-			err = s.LivepeerNode.Eth.CheckTx(tx)
-			if err != nil {
-				glog.Error(err)
-				return
-			}
+		err = s.LivepeerNode.Eth.CheckTx(tx)
+		if err != nil {
+			respondWith500(w, fmt.Sprintf("could not execute unbond: %v", err))
+			return
 		}
 	})
 
@@ -741,6 +764,41 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 		w.Write(js)
 	})
 
+	mux.HandleFunc("/recordings", func(w http.ResponseWriter, r *http.Request) {
+		recordings, err := s.LivepeerNode.Database.Recordings()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type recording struct {
+			ManifestID string          `json:"manifestID"`
+			CID        string          `json:"cid"`
+			Size       int64           `json:"size"`
+			CreatedAt  string          `json:"createdAt"`
+			UpdatedAt  string          `json:"updatedAt"`
+			Deals      json.RawMessage `json:"deals"`
+		}
+		ret := make([]recording, 0, len(recordings))
+		for _, rec := range recordings {
+			ret = append(ret, recording{
+				ManifestID: rec.ManifestID,
+				CID:        rec.CID,
+				Size:       rec.Size,
+				CreatedAt:  rec.CreatedAt,
+				UpdatedAt:  rec.UpdatedAt,
+				Deals:      json.RawMessage(rec.Deals),
+			})
+		}
+		js, err := json.Marshal(ret)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
 	mux.HandleFunc("/debug", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(fmt.Sprintf("\n\nLatestPlaylist: %v", s.LatestPlaylist())))
 	})
@@ -1077,6 +1135,7 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 	})
 
 	mux.Handle("/currentBlock", currentBlockHandler(s.LivepeerNode.Database))
+	mux.Handle("/dbStats", dbStatsHandler(s.LivepeerNode.Database))
 
 	// TicketBroker
 
@@ -1088,6 +1147,33 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 	mux.Handle("/senderInfo", senderInfoHandler(s.LivepeerNode.Eth))
 	mux.Handle("/ticketBrokerParams", ticketBrokerParamsHandler(s.LivepeerNode.Eth))
 
+	// Payments
+
+	mux.Handle("/earnings", earningsHandler(s.LivepeerNode.Database))
+	mux.Handle("/spend", spendHandler(s.LivepeerNode.Database, s.LivepeerNode.Eth))
+	mux.Handle("/payments", paymentsHandler(s.LivepeerNode.Database, s.LivepeerNode.Eth))
+	mux.Handle("/auditLog", auditLogHandler(s.LivepeerNode.Database))
+	mux.Handle("/orchestratorPerformance", orchestratorPerformanceHandler(s.LivepeerNode.Database))
+
+	mux.Handle("/streamMetrics", s.liveMetricsHandler())
+	mux.Handle("/drain", s.drainHandler())
+	mux.Handle("/orchestrators", orchestratorsHandler(s.LivepeerNode))
+	mux.Handle("/transcoderTokens", transcoderTokensHandler(s.LivepeerNode))
+	mux.Handle("/drainTranscoder", drainTranscoderHandler(s.LivepeerNode))
+	mux.Handle("/transcoderRules", transcoderRulesHandler(s.LivepeerNode))
+	mux.Handle("/transcoderTags", transcoderTagsHandler(s.LivepeerNode))
+	mux.Handle("/ingestACL", s.ingestACLHandler())
+
+	mux.Handle("/healthz", s.healthHandler(false))
+	mux.Handle("/readyz", s.healthHandler(true))
+
+	// Profiling
+	if AdminToken != "" {
+		mux.Handle("/debug/profiling", mustHaveAdminToken(profilingHandler()))
+		mux.Handle("/debug/pprof/", mustHaveAdminToken(pprofHandler()))
+		mux.Handle("/debug/dump", mustHaveAdminToken(dumpHandler()))
+	}
+
 	// Metrics
 	if monitor.Enabled {
 		mux.Handle("/metrics", monitor.Exporter)
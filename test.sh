@@ -45,7 +45,10 @@ cd ..
 ./test_args.sh
 t_args=$?
 
-if (($t1!=0||$t2!=0||$t3!=0||$t4!=0||$t5!=0||$t6!=0||$t7!=0||$t8!=0||$t_args!=0))
+./check_vendor_patches.sh
+t_patches=$?
+
+if (($t1!=0||$t2!=0||$t3!=0||$t4!=0||$t5!=0||$t6!=0||$t7!=0||$t8!=0||$t_args!=0||$t_patches!=0))
 then
     printf "\n\nSome Tests Failed\n\n"
     exit -1